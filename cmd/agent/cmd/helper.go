@@ -0,0 +1,43 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+	"github.com/spidernet-io/egressgateway/pkg/privilege"
+)
+
+var helperCmd = &cobra.Command{
+	Use:   "helper",
+	Short: "Run the privileged helper of the agent",
+	Long:  "Run the privileged helper writing the sysctls and running the iptables commands for the agent running without the privileges.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		socket, err := cmd.Flags().GetString("socket")
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		helper := &privilege.Helper{
+			Socket:  socket,
+			ProcSys: "/proc/sys",
+			Log:     logger.NewLogger(logger.Config{}),
+		}
+		if err := helper.Start(ctx); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
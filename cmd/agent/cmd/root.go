@@ -7,8 +7,8 @@ import (
 	"context"
 	"fmt"
 	"github.com/spf13/cobra"
-	"github.com/spidernet-io/egressgateway/pkg/agent"
 	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/simulator"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -24,7 +24,13 @@ var rootCmd = &cobra.Command{
 		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 		defer stop()
 
-		cfg, err := config.LoadConfig(true)
+		nodes, err := cmd.Flags().GetStringSlice("simulate-nodes")
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		cfg, err := config.LoadConfig(len(nodes) == 0)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -39,6 +45,29 @@ var rootCmd = &cobra.Command{
 			}
 		}()
 
+		if len(nodes) != 0 {
+			err = simulate(ctx, cfg, nodes)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		cleanup, err := cmd.Flags().GetBool("cleanup")
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if cleanup {
+			err = runCleanup(cfg)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
+
 		err = run(ctx, cfg)
 		if err != nil {
 			fmt.Println(err)
@@ -47,21 +76,25 @@ var rootCmd = &cobra.Command{
 	},
 }
 
-func run(ctx context.Context, config *config.Config) error {
-	svc, err := agent.New(config)
+// simulate runs the simulated agents of the nodes with the fake datapath, it runs
+// out-of-cluster against the kubeconfig on any OS
+func simulate(ctx context.Context, config *config.Config, nodes []string) error {
+	svc, err := simulator.New(config, nodes)
 	if err != nil {
 		return err
 	}
-	err = svc.Start(ctx)
-	if err != nil {
-		return err
-	}
-	return nil
+	return svc.Start(ctx)
 }
 
 // Execute adds all child commands to the root command sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	rootCmd.Flags().Bool("cleanup", false, "Remove the datapath created by the agent from the node and exit")
+	rootCmd.Flags().StringSlice("simulate-nodes", nil, "Simulate the agents of the nodes with a fake datapath for the development")
+
+	helperCmd.Flags().String("socket", "/var/run/egressgateway/helper.sock", "Specify the unix socket the helper listens on")
+	rootCmd.AddCommand(helperCmd)
+
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
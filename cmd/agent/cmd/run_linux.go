@@ -0,0 +1,28 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent"
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+)
+
+func run(ctx context.Context, config *config.Config) error {
+	svc, err := agent.New(config)
+	if err != nil {
+		return err
+	}
+	err = svc.Start(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func runCleanup(cfg *config.Config) error {
+	return agent.Cleanup(cfg, logger.NewLogger(cfg.EnvConfig.Logger))
+}
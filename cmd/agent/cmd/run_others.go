@@ -0,0 +1,25 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package cmd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+)
+
+// errNotLinux the datapath of the agent is programmed with netlink and iptables, only the
+// simulated agents run on the other OS
+var errNotLinux = errors.New("the agent only runs on Linux, simulate the agents with --simulate-nodes instead")
+
+func run(ctx context.Context, config *config.Config) error {
+	return errNotLinux
+}
+
+func runCleanup(cfg *config.Config) error {
+	return errNotLinux
+}
@@ -6,10 +6,18 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 	webhook "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -17,6 +25,9 @@ import (
 	"github.com/spidernet-io/egressgateway/pkg/schema"
 )
 
+// cleanupLabel labels the Jobs cleaning the nodes with the name of the agent DaemonSet
+const cleanupLabel = "egressgateway.spidernet.io/cleanup"
+
 var cleanCmd = &cobra.Command{
 	Use:   "clean",
 	Short: "Clean resources",
@@ -34,12 +45,36 @@ var cleanCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		fmt.Printf("validate %s\nmutating %s\n", validate, mutating)
+		agent, err := cmd.Flags().GetString("agent")
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		namespace, err := cmd.Flags().GetString("namespace")
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		timeout, err := cmd.Flags().GetDuration("timeout")
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("validate %s\nmutating %s\nagent %s/%s\n", validate, mutating, namespace, agent)
 		err = clean(validate, mutating)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
+		if agent == "" {
+			return
+		}
+		err = cleanNodes(namespace, agent, timeout)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	},
 }
 
@@ -94,6 +129,13 @@ func clean(validate, mutating string) error {
 	err = cli.List(ctx, policyList)
 	if err == nil {
 		for _, item := range policyList.Items {
+			if len(item.Finalizers) != 0 {
+				(&item).Finalizers = nil
+				err := cli.Update(ctx, &item)
+				if err != nil {
+					return err
+				}
+			}
 			err = cli.Delete(ctx, &item)
 			if err != nil {
 				return err
@@ -105,6 +147,13 @@ func clean(validate, mutating string) error {
 	err = cli.List(ctx, clusterPolicyList)
 	if err == nil {
 		for _, item := range clusterPolicyList.Items {
+			if len(item.Finalizers) != 0 {
+				(&item).Finalizers = nil
+				err := cli.Update(ctx, &item)
+				if err != nil {
+					return err
+				}
+			}
 			err = cli.Delete(ctx, &item)
 			if err != nil {
 				return err
@@ -132,3 +181,151 @@ func clean(validate, mutating string) error {
 
 	return nil
 }
+
+// cleanNodes removes the datapath left on the nodes by the agent DaemonSet: it deletes
+// the DaemonSet to stop the agents from restoring the datapath, then runs a Job with the
+// pod template of the DaemonSet in the cleanup mode on each node the agents ran on.
+func cleanNodes(namespace, name string, timeout time.Duration) error {
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return err
+	}
+
+	cli, err := client.New(cfg, client.Options{Scheme: schema.GetScheme()})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ds := new(appsv1.DaemonSet)
+	err = cli.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, ds)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			fmt.Printf("agent %s/%s not found, skip cleaning nodes\n", namespace, name)
+			return nil
+		}
+		return err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(ds.Spec.Selector)
+	if err != nil {
+		return err
+	}
+	listAgents := func() (*corev1.PodList, error) {
+		pods := new(corev1.PodList)
+		err := cli.List(ctx, pods, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: selector})
+		return pods, err
+	}
+
+	pods, err := listAgents()
+	if err != nil {
+		return err
+	}
+	nodes := make(map[string]struct{})
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != "" {
+			nodes[pod.Spec.NodeName] = struct{}{}
+		}
+	}
+
+	err = cli.Delete(ctx, ds, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	err = wait.PollUntilContextCancel(ctx, time.Second, true, func(ctx context.Context) (bool, error) {
+		pods, err := listAgents()
+		if err != nil {
+			return false, err
+		}
+		return len(pods.Items) == 0, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to wait for the agents to exit: %w", err)
+	}
+
+	jobs := make(map[string]*batchv1.Job, len(nodes))
+	for node := range nodes {
+		job := cleanupJob(ds, node)
+		err := cli.Create(ctx, job)
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+		fmt.Printf("clean node %s with job %s\n", node, job.Name)
+		jobs[node] = job
+	}
+
+	failed := make([]string, 0)
+	for node, job := range jobs {
+		err := wait.PollUntilContextCancel(ctx, time.Second, true, func(ctx context.Context) (bool, error) {
+			err := cli.Get(ctx, client.ObjectKeyFromObject(job), job)
+			if err != nil {
+				return false, err
+			}
+			for _, cond := range job.Status.Conditions {
+				if cond.Status != corev1.ConditionTrue {
+					continue
+				}
+				if cond.Type == batchv1.JobFailed {
+					return false, fmt.Errorf("job %s failed: %s", job.Name, cond.Message)
+				}
+				if cond.Type == batchv1.JobComplete {
+					return true, nil
+				}
+			}
+			return false, nil
+		})
+		if err != nil {
+			failed = append(failed, node)
+			fmt.Printf("failed to clean node %s: %v\n", node, err)
+			continue
+		}
+		err = cli.Delete(context.Background(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	// the failed nodes are reported without failing the uninstallation, the Jobs are
+	// kept for troubleshooting
+	if len(failed) > 0 {
+		fmt.Printf("the datapath may be left on nodes %v\n", failed)
+	}
+	return nil
+}
+
+// cleanupJob returns the Job running the agent of the DaemonSet in the cleanup mode on the node
+func cleanupJob(ds *appsv1.DaemonSet, node string) *batchv1.Job {
+	template := ds.Spec.Template.DeepCopy()
+	template.Labels = map[string]string{cleanupLabel: ds.Name}
+	template.Spec.NodeName = node
+	template.Spec.RestartPolicy = corev1.RestartPolicyNever
+	template.Spec.Containers = template.Spec.Containers[:1]
+	container := &template.Spec.Containers[0]
+	container.Args = append(container.Args, "--cleanup")
+	container.Ports = nil
+	container.StartupProbe = nil
+	container.LivenessProbe = nil
+	container.ReadinessProbe = nil
+
+	// the node name may be too long for the Job name
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(node))
+	prefix := ds.Name
+	if len(prefix) > 40 {
+		prefix = prefix[:40]
+	}
+
+	backoffLimit := int32(2)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-cleanup-%08x", prefix, hash.Sum32()),
+			Namespace: ds.Namespace,
+			Labels:    map[string]string{cleanupLabel: ds.Name},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template:     *template,
+		},
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -70,6 +71,9 @@ restart:
 func Execute() {
 	cleanCmd.Flags().String("validate", "", "Specify validate parameter")
 	cleanCmd.Flags().String("mutating", "", "Specify mutating parameter")
+	cleanCmd.Flags().String("agent", "", "Specify the agent DaemonSet whose nodes are cleaned")
+	cleanCmd.Flags().String("namespace", "", "Specify the namespace of the agent DaemonSet")
+	cleanCmd.Flags().Duration("timeout", 3*time.Minute, "Specify the timeout of cleaning the nodes")
 
 	rootCmd.AddCommand(cleanCmd)
 	if err := rootCmd.Execute(); err != nil {
@@ -0,0 +1,93 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/loadgen"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+var binName = filepath.Base(os.Args[0])
+
+var opts loadgen.Options
+var qps float32
+var burst int
+
+// rootCmd represents the base command.
+var rootCmd = &cobra.Command{
+	Use:   binName,
+	Short: "generate the load of the policies and the pods, and report the convergence latency",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		err := run(ctx)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func run(ctx context.Context) error {
+	restConfig, err := ctrl.GetConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load kubeconfig, error: %w", err)
+	}
+	restConfig.QPS = qps
+	restConfig.Burst = burst
+	cli, err := client.New(restConfig, client.Options{Scheme: schema.GetScheme()})
+	if err != nil {
+		return err
+	}
+	g, err := loadgen.New(cli, opts, os.Stdout)
+	if err != nil {
+		return err
+	}
+	report, err := g.Run(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Println(report)
+	if report.TimedOut != 0 {
+		return fmt.Errorf("%d churns are not converged in %s", report.TimedOut, opts.Timeout)
+	}
+	return nil
+}
+
+// Execute adds all child commands to the root command sets flags appropriately.
+// This is called by main.main(). It only needs to happen once to the rootCmd.
+func Execute() {
+	flags := rootCmd.Flags()
+	flags.StringVar(&opts.Namespace, "namespace", "default", "Specify the namespace of the policies and the pods")
+	flags.StringVar(&opts.Gateway, "gateway", "", "Specify the EgressGateway of the policies")
+	flags.IntVar(&opts.Policies, "policies", 100, "Specify the count of the policies")
+	flags.IntVar(&opts.Pods, "pods", 1000, "Specify the count of the pods")
+	flags.IntVar(&opts.Rounds, "rounds", 10, "Specify the count of the churn rounds")
+	flags.IntVar(&opts.ChurnPods, "churn-pods", 100, "Specify the count of the pods churned in each round")
+	flags.StringVar(&opts.PodCIDR, "pod-cidr", "10.240.0.0/12", "Specify the CIDR of the IPs of the pods, it should have more IPs than the pods")
+	flags.IntVar(&opts.Workers, "workers", 16, "Specify the count of the workers updating the pods")
+	flags.DurationVar(&opts.Interval, "interval", time.Second, "Specify the interval between the churn rounds")
+	flags.DurationVar(&opts.PollInterval, "poll-interval", 100*time.Millisecond, "Specify the interval of checking the endpoint slices")
+	flags.DurationVar(&opts.Timeout, "timeout", 5*time.Minute, "Specify the timeout of the convergence of each round")
+	flags.BoolVar(&opts.Cleanup, "cleanup", true, "Delete the policies and the pods at the end")
+	flags.Float32Var(&qps, "qps", 100, "Specify the QPS of the client")
+	flags.IntVar(&burst, "burst", 200, "Specify the burst of the client")
+
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
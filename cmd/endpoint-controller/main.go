@@ -0,0 +1,12 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"github.com/spidernet-io/egressgateway/cmd/endpoint-controller/cmd"
+)
+
+func main() {
+	cmd.Execute()
+}
@@ -6,13 +6,16 @@ package batch
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
-	"github.com/spidernet-io/egressgateway/cmd/nettools/flag"
 	"io"
 	"net"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/spidernet-io/egressgateway/cmd/nettools/flag"
+	"github.com/spidernet-io/egressgateway/cmd/nettools/sctp"
 )
 
 type Case func(ctx context.Context, config flag.Config) error
@@ -29,21 +32,25 @@ func Batch(ctx context.Context, config flag.Config) error {
 		cases = []Case{udp}
 	case "tcp":
 		cases = []Case{tcp}
+	case "sctp":
+		cases = []Case{sctpCase}
 	case "wss":
 		cases = []Case{wss}
 	default:
-		cases = []Case{tcp, udp, wss}
+		cases = []Case{tcp, udp, sctpCase, wss}
 	}
 
 	wg := &sync.WaitGroup{}
+	errs := make([]error, len(cases))
 
-	for _, c := range cases {
-		cc := c
+	for i, c := range cases {
+		i, cc := i, c
 		wg.Add(1)
 		go func(wg *sync.WaitGroup) {
 			err := cc(ctx, config)
 			if err != nil {
 				fmt.Println(err)
+				errs[i] = err
 				cancel()
 			}
 			wg.Done()
@@ -51,7 +58,7 @@ func Batch(ctx context.Context, config flag.Config) error {
 	}
 
 	wg.Wait()
-	return nil
+	return errors.Join(errs...)
 }
 
 func tcp(ctx context.Context, config flag.Config) error {
@@ -102,7 +109,78 @@ func tcp(ctx context.Context, config flag.Config) error {
 }
 
 func udp(ctx context.Context, config flag.Config) error {
-	return nil
+	addrStr := net.JoinHostPort(strings.Trim(*config.Addr, "[]"), *config.UdpPort)
+	dialer := net.Dialer{Timeout: 3 * time.Second}
+	conn, err := dialer.DialContext(ctx, "udp", addrStr)
+	if err != nil {
+		return fmt.Errorf("failed to connect udp server: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	b := []byte(conn.LocalAddr().String() + " Say hello to UDP Server... \n")
+	buf := make([]byte, 4096)
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("cancel to check egress IP")
+		default:
+		}
+
+		// the datagrams may be lost, so send a request before every read
+		if _, err := conn.Write(b); err != nil {
+			return fmt.Errorf("failed send msg to udp server: %v", err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			continue
+		}
+		if done, err := checkEgressIP(config, "udp", string(buf[:n])); done {
+			return err
+		}
+	}
+}
+
+func sctpCase(ctx context.Context, config flag.Config) error {
+	conn, err := sctp.Dial(*config.Addr, *config.SctpPort, 3*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect sctp server: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	reader := bufio.NewReader(conn)
+	b := []byte(conn.LocalAddr() + " Say hello to SCTP Server... \n")
+	for {
+		if _, err := conn.Write(b); err != nil {
+			return fmt.Errorf("failed send msg to sctp server: %v", err)
+		}
+		msg, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read msg from sctp server: %v", err)
+		}
+		if done, err := checkEgressIP(config, "sctp", msg); done {
+			return err
+		}
+	}
+}
+
+// checkEgressIP checks whether the server sees the egress IP as the client IP as
+// expected, it returns false if more replies are required
+func checkEgressIP(config flag.Config, proto, msg string) (bool, error) {
+	if strings.Contains(msg, *config.EgressIP) {
+		return *config.Contain, nil
+	}
+	if !*config.Contain {
+		return true, nil
+	}
+	return true, fmt.Errorf("%s: the server didn't see the egressIP %s: %s", proto, *config.EgressIP, msg)
 }
 
 func wss(ctx context.Context, config flag.Config) error {
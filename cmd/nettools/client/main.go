@@ -19,6 +19,7 @@ import (
 
 	"github.com/spidernet-io/egressgateway/cmd/nettools/client/batch"
 	"github.com/spidernet-io/egressgateway/cmd/nettools/flag"
+	"github.com/spidernet-io/egressgateway/cmd/nettools/sctp"
 )
 
 var wg sync.WaitGroup
@@ -50,16 +51,20 @@ func main() {
 		case flag.ProtocolUdp:
 			wg.Add(1)
 			go udpClient(config)
+		case flag.ProtocolSctp:
+			wg.Add(1)
+			go sctpClient(config)
 		case flag.ProtocolWeb:
 			wg.Add(1)
 			go webClient(config)
 		case flag.ProtocolAll:
-			wg.Add(3)
+			wg.Add(4)
 			go tcpClient(config)
 			go udpClient(config)
+			go sctpClient(config)
 			go webClient(config)
 		default:
-			log.Fatalf("protocol: %s don't support, available protocols: tcp,udp,web,all", *config.Proto)
+			log.Fatalf("protocol: %s don't support, available protocols: tcp,udp,sctp,web,all", *config.Proto)
 		}
 
 		wg.Wait()
@@ -108,6 +113,39 @@ func udpClient(config flag.Config) {
 	onMessageReceivedUDP(conn)
 }
 
+func sctpClient(config flag.Config) {
+	defer wg.Done()
+
+	log.Println("trying to connect sctpServer: ", fmt.Sprintf("%s:%s", *config.Addr, *config.SctpPort))
+	conn, err := sctp.Dial(*config.Addr, *config.SctpPort, 3*time.Second)
+	if err != nil {
+		log.Fatalln("SCTP: connect server failed: ", err)
+	}
+
+	defer conn.Close()
+
+	fmt.Println(conn.LocalAddr() + " : SCTP Client connected!")
+
+	reader := bufio.NewReader(conn)
+	b := []byte(conn.LocalAddr() + " Say hello to SCTP Server... \n")
+	for {
+		if _, err := conn.Write(b); err != nil {
+			fmt.Println(err)
+			break
+		}
+
+		msg, err := reader.ReadString('\n')
+		if err != nil {
+			fmt.Println(err)
+			break
+		}
+		fmt.Println(msg)
+
+		time.Sleep(time.Second * 2)
+		b = []byte(conn.LocalAddr() + " write data to SCTP Server... \n")
+	}
+}
+
 func webClient(config flag.Config) {
 	defer wg.Done()
 
@@ -6,26 +6,28 @@ package flag
 import "flag"
 
 const (
-	ProtocolTcp = "tcp"
-	ProtocolUdp = "udp"
-	ProtocolAll = "all"
-	ProtocolWeb = "web"
+	ProtocolTcp  = "tcp"
+	ProtocolUdp  = "udp"
+	ProtocolSctp = "sctp"
+	ProtocolAll  = "all"
+	ProtocolWeb  = "web"
 )
 
 type Config struct {
-	Addr, Proto, TcpPort, UdpPort, WebPort *string
-	Timeout                                *int
-	EgressIP                               *string
-	Contain                                *bool
-	Batch                                  *bool
+	Addr, Proto, TcpPort, UdpPort, SctpPort, WebPort *string
+	Timeout                                          *int
+	EgressIP                                         *string
+	Contain                                          *bool
+	Batch                                            *bool
 }
 
 func ParseClientFlag() Config {
 	config := Config{
 		Addr:     flag.String("addr", "", "server listen ip addr, default is all local addresses"),
-		Proto:    flag.String("protocol", "tcp", "server listen protocol, available options: tcp,udp,web(websocket),all"),
+		Proto:    flag.String("protocol", "tcp", "server listen protocol, available options: tcp,udp,sctp,web(websocket),all"),
 		TcpPort:  flag.String("tcpPort", "8080", "tcp listen port"),
 		UdpPort:  flag.String("udpPort", "8081", "udp listen port"),
+		SctpPort: flag.String("sctpPort", "8083", "sctp listen port"),
 		WebPort:  flag.String("webPort", "8082", "webSocket listen port"),
 		Timeout:  flag.Int("timeout", 10, "command execution seconds time"),
 		EgressIP: flag.String("eip", "", "egress IP"),
@@ -39,16 +41,17 @@ func ParseClientFlag() Config {
 }
 
 type ServerConfig struct {
-	Addr, Proto, TcpPort, UdpPort, WebPort *string
+	Addr, Proto, TcpPort, UdpPort, SctpPort, WebPort *string
 }
 
 func ParseServerFlag() ServerConfig {
 	config := ServerConfig{
-		Addr:    flag.String("addr", "", "server listen ip addr, default is all local addresses"),
-		Proto:   flag.String("protocol", "tcp", "server listen protocol, available options: tcp,udp,web(websocket),all"),
-		TcpPort: flag.String("tcpPort", "8080", "tcp listen port"),
-		UdpPort: flag.String("udpPort", "8081", "udp listen port"),
-		WebPort: flag.String("webPort", "8082", "webSocket listen port"),
+		Addr:     flag.String("addr", "", "server listen ip addr, default is all local addresses"),
+		Proto:    flag.String("protocol", "tcp", "server listen protocol, available options: tcp,udp,sctp,web(websocket),all"),
+		TcpPort:  flag.String("tcpPort", "8080", "tcp listen port"),
+		UdpPort:  flag.String("udpPort", "8081", "udp listen port"),
+		SctpPort: flag.String("sctpPort", "8083", "sctp listen port"),
+		WebPort:  flag.String("webPort", "8082", "webSocket listen port"),
 	}
 
 	flag.Parse()
@@ -0,0 +1,151 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package sctp provides one-to-one style SCTP sockets, which behave like TCP
+// streams, for the nettools client and server.
+package sctp
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Conn is an SCTP association
+type Conn struct {
+	*os.File
+	local, remote string
+}
+
+func (c *Conn) LocalAddr() string  { return c.local }
+func (c *Conn) RemoteAddr() string { return c.remote }
+
+// Listener accepts SCTP associations
+type Listener struct {
+	fd    int
+	local string
+}
+
+// Listen listens on the address, an empty host means all local addresses
+func Listen(host, port string) (*Listener, error) {
+	family, sa, err := sockaddr(host, port)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.Socket(family, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, unix.IPPROTO_SCTP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sctp socket: %w", err)
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind sctp socket: %w", err)
+	}
+	if err := unix.Listen(fd, 128); err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("failed to listen sctp socket: %w", err)
+	}
+	return &Listener{fd: fd, local: net.JoinHostPort(host, port)}, nil
+}
+
+func (l *Listener) Addr() string { return l.local }
+
+// Accept waits for the next association
+func (l *Listener) Accept() (*Conn, error) {
+	fd, sa, err := unix.Accept4(l.fd, unix.SOCK_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(fd, sa)
+}
+
+func (l *Listener) Close() error {
+	return unix.Close(l.fd)
+}
+
+// Dial connects to the address within the timeout
+func Dial(host, port string, timeout time.Duration) (*Conn, error) {
+	family, sa, err := sockaddr(host, port)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.Socket(family, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, unix.IPPROTO_SCTP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sctp socket: %w", err)
+	}
+	// the send timeout of a blocking socket also limits connect
+	tv := unix.NsecToTimeval(timeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_SNDTIMEO, &tv); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	if err := unix.Connect(fd, sa); err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("failed to connect sctp server: %w", err)
+	}
+	return newConn(fd, sa)
+}
+
+// newConn wraps the connected socket, which is set non-blocking so that the
+// runtime poller supports the deadlines of the file
+func newConn(fd int, remote unix.Sockaddr) (*Conn, error) {
+	if err := unix.SetNonblock(fd, true); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	local, err := unix.Getsockname(fd)
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	return &Conn{
+		File:   os.NewFile(uintptr(fd), "sctp"),
+		local:  addrString(local),
+		remote: addrString(remote),
+	}, nil
+}
+
+func sockaddr(host, port string) (int, unix.Sockaddr, error) {
+	host = strings.Trim(host, "[]")
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid port %q: %w", port, err)
+	}
+	if host == "" {
+		return unix.AF_INET6, &unix.SockaddrInet6{Port: p}, nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ips, err := net.LookupIP(host)
+		if err != nil || len(ips) == 0 {
+			return 0, nil, fmt.Errorf("failed to resolve %s: %v", host, err)
+		}
+		ip = ips[0]
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		sa := &unix.SockaddrInet4{Port: p}
+		copy(sa.Addr[:], ip4)
+		return unix.AF_INET, sa, nil
+	}
+	sa := &unix.SockaddrInet6{Port: p}
+	copy(sa.Addr[:], ip.To16())
+	return unix.AF_INET6, sa, nil
+}
+
+func addrString(sa unix.Sockaddr) string {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return net.JoinHostPort(net.IP(sa.Addr[:]).String(), strconv.Itoa(sa.Port))
+	case *unix.SockaddrInet6:
+		return net.JoinHostPort(net.IP(sa.Addr[:]).String(), strconv.Itoa(sa.Port))
+	}
+	return ""
+}
@@ -0,0 +1,31 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package sctp
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+var errNotSupported = errors.New("sctp is only supported on linux")
+
+type Conn struct {
+	*os.File
+}
+
+func (c *Conn) LocalAddr() string  { return "" }
+func (c *Conn) RemoteAddr() string { return "" }
+
+type Listener struct{}
+
+func Listen(host, port string) (*Listener, error) { return nil, errNotSupported }
+
+func (l *Listener) Addr() string           { return "" }
+func (l *Listener) Accept() (*Conn, error) { return nil, errNotSupported }
+func (l *Listener) Close() error           { return nil }
+
+func Dial(host, port string, timeout time.Duration) (*Conn, error) { return nil, errNotSupported }
@@ -16,6 +16,7 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/spidernet-io/egressgateway/cmd/nettools/flag"
+	"github.com/spidernet-io/egressgateway/cmd/nettools/sctp"
 )
 
 var (
@@ -36,16 +37,20 @@ func main() {
 	case flag.ProtocolUdp:
 		wg.Add(1)
 		go udpServer(config)
+	case flag.ProtocolSctp:
+		wg.Add(1)
+		go sctpServer(config, true)
 	case flag.ProtocolWeb:
 		wg.Add(1)
 		go websocketServer(config)
 	case flag.ProtocolAll:
-		wg.Add(3)
+		wg.Add(4)
 		go tcpServer(config)
 		go udpServer(config)
+		go sctpServer(config, false)
 		go websocketServer(config)
 	default:
-		log.Fatalf("protocol: %s don't support, available protocols: tcp,udp,web,all", *config.Proto)
+		log.Fatalf("protocol: %s don't support, available protocols: tcp,udp,sctp,web,all", *config.Proto)
 	}
 
 	wg.Wait()
@@ -139,6 +144,55 @@ func udpServer(config flag.ServerConfig) {
 	}
 }
 
+// sctpServer serves SCTP like the TCP server, the host may not support SCTP,
+// so it's only fatal if SCTP is the only protocol to serve
+func sctpServer(config flag.ServerConfig, required bool) {
+	defer wg.Done()
+	listener, err := sctp.Listen(*config.Addr, *config.SctpPort)
+	if err != nil {
+		if required {
+			log.Fatalf("sctpServer failed to start: %v", err)
+		}
+		log.Printf("sctpServer failed to start, skip it: %v", err)
+		return
+	}
+	defer listener.Close()
+	log.Println("SCTP Server listen on: ", listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Println("A client connected :" + conn.RemoteAddr())
+		go sctpPipe(conn)
+	}
+}
+
+func sctpPipe(conn *sctp.Conn) {
+	defer func() {
+		fmt.Println(" Disconnected : " + conn.RemoteAddr())
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for i := 0; i <= 100; i++ {
+		message, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		fmt.Println(message)
+
+		time.Sleep(time.Second * 2)
+
+		msg := time.Now().String() + " clientIP=" + conn.RemoteAddr() + " SCTP Server Say hello! \n"
+		if _, err = conn.Write([]byte(msg)); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+}
+
 func websocketServer(config flag.ServerConfig) {
 	defer wg.Done()
 
@@ -6,8 +6,12 @@ package agent
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"time"
 
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -15,10 +19,13 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	"github.com/spidernet-io/egressgateway/pkg/agent/metrics"
+	"github.com/spidernet-io/egressgateway/pkg/auth"
 	"github.com/spidernet-io/egressgateway/pkg/config"
 	"github.com/spidernet-io/egressgateway/pkg/logger"
+	"github.com/spidernet-io/egressgateway/pkg/privilege"
 	"github.com/spidernet-io/egressgateway/pkg/profiling"
 	"github.com/spidernet-io/egressgateway/pkg/schema"
+	"github.com/spidernet-io/egressgateway/pkg/tlsconfig"
 	"github.com/spidernet-io/egressgateway/pkg/types"
 )
 
@@ -30,6 +37,18 @@ type Agent struct {
 func New(cfg *config.Config) (types.Service, error) {
 	syncPeriod := time.Second * 15
 	log := logger.NewLogger(cfg.EnvConfig.Logger)
+	if err := checkCapabilities(cfg, log); err != nil {
+		return nil, err
+	}
+	tlsServer, err := tlsconfig.New(cfg.FileConfig.TLS, cfg.TLSCertDir, log)
+	if err != nil {
+		return nil, err
+	}
+	debugAuth, err := auth.New(cfg.FileConfig.Auth, cfg.KubeConfig, log)
+	if err != nil {
+		return nil, err
+	}
+	peerSync := &peerSyncHandler{}
 	t := time.Duration(0)
 	mgrOpts := manager.Options{
 		Cache: cache.Options{
@@ -43,10 +62,36 @@ func New(cfg *config.Config) (types.Service, error) {
 
 	if cfg.MetricsBindAddress != "" {
 		mgrOpts.Metrics.BindAddress = cfg.MetricsBindAddress
+		if debugAuth.Enabled() {
+			mgrOpts.Metrics.ExtraHandlers = map[string]http.Handler{
+				"/loglevel":           debugAuth.Handler(logger.LevelHandler()),
+				"/debug/tunnel/peers": debugAuth.Handler(peerSync),
+			}
+			if cfg.FileConfig.Debug.Pprof {
+				for path, handler := range profiling.DebugHandlers() {
+					mgrOpts.Metrics.ExtraHandlers[path] = debugAuth.Handler(handler)
+				}
+			}
+		} else {
+			log.Info("the debug endpoints are not served without the auth mode")
+		}
+		if cfg.FileConfig.TLS.SecureMetrics {
+			mgrOpts.Metrics.SecureServing = true
+			mgrOpts.Metrics.CertDir = cfg.TLSCertDir
+			mgrOpts.Metrics.TLSOpts = append(tlsServer.Options(), debugAuth.TLSOptions()...)
+		}
 	}
 	if cfg.HealthProbeBindAddress != "" {
 		mgrOpts.HealthProbeBindAddress = cfg.HealthProbeBindAddress
 	}
+	// only the node of the agent is watched for the exclusions of the cluster policies
+	mgrOpts.Cache.ByObject = map[client.Object]cache.ByObject{
+		&corev1.Node{}: {Field: fields.OneTermEqualSelector("metadata.name", cfg.EnvConfig.NodeName)},
+	}
+	if cfg.FileConfig.EnableDatapathReadyCondition {
+		// only the Pods of the node are watched for the readiness gates
+		mgrOpts.Cache.ByObject[&corev1.Pod{}] = cache.ByObject{Field: fields.OneTermEqualSelector("spec.nodeName", cfg.EnvConfig.NodeName)}
+	}
 
 	mgr, err := ctrl.NewManager(cfg.KubeConfig, mgrOpts)
 	if err != nil {
@@ -62,6 +107,10 @@ func New(cfg *config.Config) (types.Service, error) {
 		return nil, fmt.Errorf("failed to AddReadyzCheck: %w", err)
 	}
 
+	err = mgr.Add(tlsServer)
+	if err != nil {
+		return nil, err
+	}
 	err = mgr.Add(&profiling.GoPS{Port: cfg.GopsPort, Log: log})
 	if err != nil {
 		return nil, err
@@ -73,16 +122,33 @@ func New(cfg *config.Config) (types.Service, error) {
 
 	metrics.RegisterMetricCollectors()
 
-	err = newEgressTunnelController(mgr, cfg, log)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create node controller: %w", err)
+	readiness := newDatapathReadiness(datapathVXLAN, datapathPolicy)
+	if cfg.FileConfig.EnableDatapathReadyCondition {
+		err = newDatapathConditionController(mgr, cfg, readiness, log)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create datapath condition controller: %w", err)
+		}
 	}
 
-	err = newPolicyController(mgr, log, cfg)
+	// the policy controller comes first, it restores the fail-closed rules before any other
+	// activity of the agent
+	err = newPolicyController(mgr, logger.ForModule(log, logger.ModuleAgentIPTables), cfg, readiness)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create egress gateway policy controller: %w", err)
 	}
 
+	err = newEgressTunnelController(mgr, cfg, readiness, peerSync, logger.ForModule(log, logger.ModuleAgentVXLAN))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create node controller: %w", err)
+	}
+
+	if cfg.FileConfig.Capture.Enable {
+		err = newCaptureController(mgr, log.WithName("capture"), cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create capture controller: %w", err)
+		}
+	}
+
 	err = newEipCtrl(mgr, log, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to eip controller: %w", err)
@@ -91,6 +157,23 @@ func New(cfg *config.Config) (types.Service, error) {
 	return &Agent{client: mgr.GetClient(), manager: mgr}, err
 }
 
+// checkCapabilities makes sure the agent gets the capabilities it needs, and warns
+// about the needless ones when the agent runs with the privileged helper
+func checkCapabilities(cfg *config.Config, log logr.Logger) error {
+	set, err := privilege.EffectiveCapabilities()
+	if err != nil {
+		return fmt.Errorf("failed to get capabilities: %w", err)
+	}
+	extra, err := privilege.CheckAgentCapabilities(set)
+	if err != nil {
+		return err
+	}
+	if cfg.HelperSocket != "" && len(extra) > 0 {
+		log.Info("the agent runs with the privileged helper, the capabilities are needless", "capabilities", extra)
+	}
+	return nil
+}
+
 func (c *Agent) Start(ctx context.Context) error {
 	errChan := make(chan error)
 	go func() {
@@ -0,0 +1,164 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxApplyBackoff limits the backoff of the batched applies retried after failures
+const maxApplyBackoff = 30 * time.Second
+
+var (
+	histogramApplyBatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "egress_iptables_apply_batch_size",
+		Help:    "Number of the changes of the gateways and the policies applied by one iptables restore",
+		Buckets: []float64{1, 2, 5, 10, 20, 50, 100, 200},
+	})
+	counterBatchedApplies = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "egress_iptables_batched_applies_total",
+		Help: "Number of the batched applies of the iptables rules by the result",
+	}, []string{"result"})
+)
+
+// applyBatcher batches the applies of the rules requested by the changes of the gateways
+// and the policies in the window into one apply, so a burst of the changes runs
+// iptables-restore once instead of once per change, and holds the xtables lock shorter.
+// The applied callbacks of the requests, e.g. reporting the applied generations, run once
+// the apply of their batch succeeds, the failed batches are retried with backoff.
+type applyBatcher struct {
+	window time.Duration
+	apply  func() error
+	log    logr.Logger
+
+	mutex sync.Mutex
+	// pending is the applied callbacks of the requests by their keys, a request of the
+	// same key in the window replaces the former one
+	pending map[string]func(ctx context.Context) error
+	trigger chan struct{}
+}
+
+func newApplyBatcher(window time.Duration, apply func() error, log logr.Logger) *applyBatcher {
+	return &applyBatcher{
+		window:  window,
+		apply:   apply,
+		log:     log,
+		pending: make(map[string]func(ctx context.Context) error),
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+// Request adds the change of the key to the next batch, the applied callback is invoked
+// after the rules are applied
+func (b *applyBatcher) Request(key string, applied func(ctx context.Context) error) {
+	b.mutex.Lock()
+	b.pending[key] = applied
+	b.mutex.Unlock()
+	select {
+	case b.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Start applies the batches until the context is done
+func (b *applyBatcher) Start(ctx context.Context) error {
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-b.trigger:
+		}
+
+		delay := b.window
+		if failures > 0 {
+			delay = b.backoff(failures)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		if b.flush(ctx) {
+			failures = 0
+			continue
+		}
+		failures++
+		select {
+		case b.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// flush applies the pending requests in one apply, and returns whether it succeeded. The
+// requests of a failed apply or a failed callback are put back into the pending ones.
+func (b *applyBatcher) flush(ctx context.Context) bool {
+	b.mutex.Lock()
+	batch := b.pending
+	b.pending = make(map[string]func(ctx context.Context) error)
+	b.mutex.Unlock()
+	if len(batch) == 0 {
+		return true
+	}
+
+	keys := make([]string, 0, len(batch))
+	for key := range batch {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	histogramApplyBatchSize.Observe(float64(len(batch)))
+	if err := b.apply(); err != nil {
+		counterBatchedApplies.WithLabelValues("failure").Inc()
+		b.log.Error(err, "failed to apply the batched changes", "changes", keys)
+		b.requeue(batch)
+		return false
+	}
+	counterBatchedApplies.WithLabelValues("success").Inc()
+	b.log.V(1).Info("apply the batched changes", "changes", keys)
+
+	failed := make(map[string]func(ctx context.Context) error)
+	for _, key := range keys {
+		if err := batch[key](ctx); err != nil {
+			b.log.Error(err, "failed to report the applied change", "change", key)
+			failed[key] = batch[key]
+		}
+	}
+	b.requeue(failed)
+	return len(failed) == 0
+}
+
+// requeue puts the requests back into the pending ones unless they are requested again
+func (b *applyBatcher) requeue(batch map[string]func(ctx context.Context) error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for key, applied := range batch {
+		if _, ok := b.pending[key]; !ok {
+			b.pending[key] = applied
+		}
+	}
+}
+
+// backoff doubles from the window by the failures, it's limited by the max backoff
+func (b *applyBatcher) backoff(failures int) time.Duration {
+	backoff := b.window
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	for i := 0; i < failures && backoff < maxApplyBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxApplyBackoff {
+		backoff = maxApplyBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,90 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyBatcherFlush(t *testing.T) {
+	ctx := context.Background()
+	applies := 0
+	var applyErr error
+	b := newApplyBatcher(time.Millisecond, func() error {
+		applies++
+		return applyErr
+	}, logr.Discard())
+
+	reported := make([]string, 0)
+	report := func(name string, err error) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			reported = append(reported, name)
+			return err
+		}
+	}
+
+	// the requests of the same key are merged, and they are applied once
+	b.Request("EgressGateway/a", report("a-old", nil))
+	b.Request("EgressGateway/a", report("a", nil))
+	b.Request("EgressPolicy/default/b", report("b", nil))
+	assert.True(t, b.flush(ctx))
+	assert.Equal(t, 1, applies)
+	assert.Equal(t, []string{"a", "b"}, reported)
+	assert.True(t, b.flush(ctx))
+	assert.Equal(t, 1, applies)
+
+	// the requests of the failed apply are kept unless they are requested again
+	applyErr = errors.New("xtables lock")
+	reported = reported[:0]
+	b.Request("EgressGateway/a", report("a", nil))
+	assert.False(t, b.flush(ctx))
+	assert.Empty(t, reported)
+	b.Request("EgressGateway/a", report("a-new", nil))
+	applyErr = nil
+	assert.True(t, b.flush(ctx))
+	assert.Equal(t, []string{"a-new"}, reported)
+
+	// the failed callbacks are retried by the next batch
+	reported = reported[:0]
+	b.Request("EgressGateway/a", report("a", errors.New("conflict")))
+	b.Request("EgressGateway/c", report("c", nil))
+	assert.False(t, b.flush(ctx))
+	assert.Equal(t, []string{"a", "c"}, reported)
+	assert.Len(t, b.pending, 1)
+	assert.Contains(t, b.pending, "EgressGateway/a")
+}
+
+func TestApplyBatcherStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var applies, reported int32
+	b := newApplyBatcher(50*time.Millisecond, func() error {
+		atomic.AddInt32(&applies, 1)
+		return nil
+	}, logr.Discard())
+	go func() { _ = b.Start(ctx) }()
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		b.Request(key, func(ctx context.Context) error {
+			atomic.AddInt32(&reported, 1)
+			return nil
+		})
+	}
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&reported) == 4 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&applies))
+}
+
+func TestApplyBatcherBackoff(t *testing.T) {
+	b := newApplyBatcher(200*time.Millisecond, nil, logr.Discard())
+	assert.Equal(t, 400*time.Millisecond, b.backoff(1))
+	assert.Equal(t, 800*time.Millisecond, b.backoff(2))
+	assert.Equal(t, maxApplyBackoff, b.backoff(20))
+}
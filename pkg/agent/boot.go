@@ -0,0 +1,268 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+const (
+	// bootUnitName is the systemd unit restoring the fail-closed rules on the boot of the node
+	bootUnitName  = "egressgateway-boot.service"
+	bootIPSetFile = "boot.ipset"
+
+	// the sources of the policies held on the boot of the node, and the critical exclusions,
+	// i.e. the cluster CIDRs and the bypassed destinations, which are never held
+	bootSrcIPSetV4  = "egress-boot-src-v4"
+	bootSrcIPSetV6  = "egress-boot-src-v6"
+	bootExclIPSetV4 = "egress-boot-excl-v4"
+	bootExclIPSetV6 = "egress-boot-excl-v6"
+)
+
+func bootIPSetNames(version uint8) (src, excl string) {
+	if version == 6 {
+		return bootSrcIPSetV6, bootExclIPSetV6
+	}
+	return bootSrcIPSetV4, bootExclIPSetV4
+}
+
+func bootRulesFile(version uint8) string {
+	return fmt.Sprintf("boot.rules.v%d", version)
+}
+
+// buildBootRules builds the fail-closed rules restored on the boot of the node, which hold
+// the traffic from the sources of the policies before the agent starts. They're in the
+// fail-closed chain, so the agent takes them over as it starts.
+func buildBootRules(version uint8) []iptables.Rule {
+	src, excl := bootIPSetNames(version)
+	return []iptables.Rule{
+		{
+			Match:   iptables.MatchCriteria{}.DestIPSet(excl),
+			Action:  iptables.ReturnAction{},
+			Comment: []string{"Never hold the cluster CIDRs and the bypassed destinations"},
+		},
+		{
+			Match:   iptables.MatchCriteria{}.SourceIPSet(src),
+			Action:  iptables.DropAction{},
+			Comment: []string{"Hold the EgressPolicy traffic until the agent starts"},
+		},
+	}
+}
+
+// renderBootIPSets renders the input of ipset restore creating the sets of the boot rules
+func renderBootIPSets(versions []uint8, sources, exclusions map[uint8][]string) string {
+	buf := &bytes.Buffer{}
+	for _, version := range versions {
+		family := "inet"
+		if version == 6 {
+			family = "inet6"
+		}
+		src, excl := bootIPSetNames(version)
+		for _, set := range []struct {
+			name    string
+			entries []string
+		}{{src, sources[version]}, {excl, exclusions[version]}} {
+			fmt.Fprintf(buf, "create %s hash:net family %s -exist\n", set.name, family)
+			fmt.Fprintf(buf, "flush %s\n", set.name)
+			for _, entry := range set.entries {
+				fmt.Fprintf(buf, "add %s %s -exist\n", set.name, entry)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// renderBootUnit renders the systemd unit restoring the ipsets and the rules before kubelet
+func renderBootUnit(dir string, tables []*iptables.Table) string {
+	buf := &bytes.Buffer{}
+	buf.WriteString(`# Generated by the egressgateway agent, do not edit.
+[Unit]
+Description=Restore the fail-closed rules of EgressGateway
+DefaultDependencies=no
+After=local-fs.target
+Before=network-pre.target kubelet.service
+Wants=network-pre.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+`)
+	fmt.Fprintf(buf, "ExecStart=/bin/sh -c 'ipset restore -exist -file %s'\n", filepath.Join(dir, bootIPSetFile))
+	for _, table := range tables {
+		fmt.Fprintf(buf, "ExecStart=/bin/sh -c '%s --noflush %s'\n",
+			table.RestoreCommand(), filepath.Join(dir, bootRulesFile(table.IPVersion)))
+	}
+	buf.WriteString(`
+[Install]
+WantedBy=multi-user.target
+`)
+	return buf.String()
+}
+
+// bootPersistence writes the fail-closed rules into the files on the host, so they're
+// restored by the systemd unit on the boot of the node before the agent starts
+type bootPersistence struct {
+	dir          string
+	systemdDir   string
+	filterTables []*iptables.Table
+	log          logr.Logger
+
+	// written is the contents of the files written by the agent by their paths
+	written map[string]string
+}
+
+func newBootPersistence(dir, systemdDir string, filterTables []*iptables.Table, log logr.Logger) *bootPersistence {
+	return &bootPersistence{
+		dir:          dir,
+		systemdDir:   systemdDir,
+		filterTables: filterTables,
+		log:          log,
+		written:      make(map[string]string),
+	}
+}
+
+// Write writes the rules holding the sources, except the exclusions, the entries are by the
+// IP versions, and the unchanged files are not written again
+func (b *bootPersistence) Write(sources, exclusions map[uint8][]string) error {
+	versions := make([]uint8, 0, len(b.filterTables))
+	files := make(map[string]string)
+	for _, table := range b.filterTables {
+		versions = append(versions, table.IPVersion)
+		chain := &iptables.Chain{Name: EgressFailClosedChain, Rules: buildBootRules(table.IPVersion)}
+		files[filepath.Join(b.dir, bootRulesFile(table.IPVersion))] =
+			table.RenderRestore(chain, "FORWARD", []iptables.Rule{failClosedJumpRule()})
+	}
+	files[filepath.Join(b.dir, bootIPSetFile)] = renderBootIPSets(versions, sources, exclusions)
+	unit := filepath.Join(b.systemdDir, bootUnitName)
+	files[unit] = renderBootUnit(b.dir, b.filterTables)
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if b.written[path] == files[path] {
+			continue
+		}
+		if err := writeFileAtomic(path, files[path]); err != nil {
+			return err
+		}
+		b.written[path] = files[path]
+		b.log.V(1).Info("write the boot file", "path", path)
+	}
+
+	// the unit is enabled as systemctl enable does
+	wants := filepath.Join(b.systemdDir, "multi-user.target.wants", bootUnitName)
+	if target, err := os.Readlink(wants); err == nil && target == unit {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(wants), 0755); err != nil {
+		return err
+	}
+	_ = os.Remove(wants)
+	if err := os.Symlink(unit, wants); err != nil {
+		return fmt.Errorf("failed to enable %s: %w", bootUnitName, err)
+	}
+	b.log.Info("enable the systemd unit restoring the fail-closed rules on boot", "unit", unit)
+	return nil
+}
+
+// removeBootPersistence removes the files written by the agent if they exist
+func removeBootPersistence(dir, systemdDir string) error {
+	paths := []string{
+		filepath.Join(systemdDir, "multi-user.target.wants", bootUnitName),
+		filepath.Join(systemdDir, bootUnitName),
+		filepath.Join(dir, bootIPSetFile),
+		filepath.Join(dir, bootRulesFile(4)),
+		filepath.Join(dir, bootRulesFile(6)),
+	}
+	errs := make([]error, 0)
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// persistBoot writes the sources of the policies forwarded or SNATed on the node, and the
+// critical exclusions into the boot files
+func (r *policeReconciler) persistBoot(policies ...map[egressv1.Policy]*PolicyCommon) error {
+	sources := make([]string, 0)
+	for _, items := range policies {
+		for policy := range items {
+			for _, set := range buildIPSetNamesByPolicy(policy.Namespace, policy.Name, r.cfg.FileConfig.EnableIPv4, r.cfg.FileConfig.EnableIPv6) {
+				if set.Kind != IPSrc {
+					continue
+				}
+				entries, err := r.ipset.ListEntries(set.Name)
+				if err != nil {
+					return fmt.Errorf("failed to list ipset %s: %w", set.Name, err)
+				}
+				sources = append(sources, entries...)
+			}
+		}
+	}
+	exclusions := bypassCIDRs(r.cfg.FileConfig)
+	for _, name := range []string{EgressClusterCIDRIPv4, EgressClusterCIDRIPv6} {
+		if _, ok := r.ipsetMap.Load(name); !ok {
+			continue
+		}
+		entries, err := r.ipset.ListEntries(name)
+		if err != nil {
+			return fmt.Errorf("failed to list ipset %s: %w", name, err)
+		}
+		exclusions = append(exclusions, entries...)
+	}
+	return r.boot.Write(splitByVersion(sources), splitByVersion(exclusions))
+}
+
+// splitByVersion splits the IPs and the CIDRs by the IP versions, they're sorted and unique
+func splitByVersion(items []string) map[uint8][]string {
+	versions := make(map[uint8]sets.Set[string])
+	for _, item := range items {
+		ip := net.ParseIP(strings.SplitN(item, "/", 2)[0])
+		if ip == nil {
+			continue
+		}
+		version := uint8(4)
+		if ip.To4() == nil {
+			version = 6
+		}
+		if versions[version] == nil {
+			versions[version] = sets.New[string]()
+		}
+		versions[version].Insert(item)
+	}
+	res := make(map[uint8][]string)
+	for version, set := range versions {
+		res[version] = sets.List(set)
+	}
+	return res
+}
+
+func writeFileAtomic(path, content string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
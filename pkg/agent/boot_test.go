@@ -0,0 +1,97 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	"github.com/spidernet-io/egressgateway/pkg/iptables/testutils"
+)
+
+func TestRenderBootIPSets(t *testing.T) {
+	res := renderBootIPSets([]uint8{4, 6},
+		map[uint8][]string{4: {"10.21.1.2"}},
+		map[uint8][]string{4: {"10.244.0.0/16"}, 6: {"fd00::/64"}})
+	assert.Equal(t, `create egress-boot-src-v4 hash:net family inet -exist
+flush egress-boot-src-v4
+add egress-boot-src-v4 10.21.1.2 -exist
+create egress-boot-excl-v4 hash:net family inet -exist
+flush egress-boot-excl-v4
+add egress-boot-excl-v4 10.244.0.0/16 -exist
+create egress-boot-src-v6 hash:net family inet6 -exist
+flush egress-boot-src-v6
+create egress-boot-excl-v6 hash:net family inet6 -exist
+flush egress-boot-excl-v6
+add egress-boot-excl-v6 fd00::/64 -exist
+`, res)
+}
+
+func TestSplitByVersion(t *testing.T) {
+	res := splitByVersion([]string{"10.21.1.3", "fd00::1", "10.21.1.2", "invalid", "10.21.1.3", "10.244.0.0/16"})
+	assert.Equal(t, map[uint8][]string{
+		4: {"10.21.1.2", "10.21.1.3", "10.244.0.0/16"},
+		6: {"fd00::1"},
+	}, res)
+}
+
+func TestBootPersistence(t *testing.T) {
+	dataplane := testutils.NewMockDataplane("filter", map[string][]string{}, "legacy")
+	table, err := iptables.NewTable("filter", 4, "egw:v2:", iptables.Options{
+		XTablesLock:      iptables.DummyLock{},
+		NewCmdOverride:   dataplane.NewCmd,
+		SleepOverride:    dataplane.Sleep,
+		NowOverride:      dataplane.Now,
+		LookPathOverride: func(file string) (string, error) { return file, nil },
+	}, logr.Discard())
+	assert.NoError(t, err)
+
+	dir, systemdDir := filepath.Join(t.TempDir(), "egressgateway"), t.TempDir()
+	boot := newBootPersistence(dir, systemdDir, []*iptables.Table{table}, logr.Discard())
+	sources := map[uint8][]string{4: {"10.21.1.2"}}
+	exclusions := map[uint8][]string{4: {"10.244.0.0/16"}}
+	assert.NoError(t, boot.Write(sources, exclusions))
+
+	unit := filepath.Join(systemdDir, bootUnitName)
+	content, err := os.ReadFile(unit)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "ipset restore -exist -file "+filepath.Join(dir, bootIPSetFile))
+	assert.Contains(t, string(content), "--noflush "+filepath.Join(dir, "boot.rules.v4"))
+	assert.NotContains(t, string(content), "boot.rules.v6")
+	target, err := os.Readlink(filepath.Join(systemdDir, "multi-user.target.wants", bootUnitName))
+	assert.NoError(t, err)
+	assert.Equal(t, unit, target)
+
+	content, err = os.ReadFile(filepath.Join(dir, "boot.rules.v4"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "*filter\n:"+EgressFailClosedChain+" - [0:0]\n"))
+	assert.Contains(t, string(content), "--match-set egress-boot-src-v4 src --jump DROP")
+	assert.Contains(t, string(content), "-I FORWARD 1 ")
+	content, err = os.ReadFile(filepath.Join(dir, bootIPSetFile))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "add egress-boot-src-v4 10.21.1.2 -exist")
+
+	// the unchanged files are not written again
+	assert.NoError(t, os.Remove(unit))
+	assert.NoError(t, boot.Write(sources, exclusions))
+	assert.NoFileExists(t, unit)
+	sources[4] = append(sources[4], "10.21.1.3")
+	assert.NoError(t, boot.Write(sources, exclusions))
+	content, err = os.ReadFile(filepath.Join(dir, bootIPSetFile))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "add egress-boot-src-v4 10.21.1.3 -exist")
+
+	assert.NoError(t, removeBootPersistence(dir, systemdDir))
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	assert.NoFileExists(t, filepath.Join(systemdDir, "multi-user.target.wants", bootUnitName))
+	assert.NoError(t, removeBootPersistence(dir, systemdDir))
+}
@@ -0,0 +1,106 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"net"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+)
+
+const (
+	// the destinations never forwarded to the gateway nodes or SNATed with the EIPs
+	bypassIPSetV4 = "egress-bypass-v4"
+	bypassIPSetV6 = "egress-bypass-v6"
+)
+
+// builtinBypassCIDRs are always bypassed, e.g. the metadata services of the clouds on the
+// link-local addresses, the config only extends them
+var builtinBypassCIDRs = []string{
+	"169.254.0.0/16",
+	"224.0.0.0/4",
+	"255.255.255.255/32",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+func buildBypassIPSetNames(enableIPv4, enableIPv6 bool) SetNames {
+	res := make([]SetName, 0)
+	if enableIPv4 {
+		res = append(res, SetName{Name: bypassIPSetV4, Stack: IPv4, Kind: IPDst})
+	}
+	if enableIPv6 {
+		res = append(res, SetName{Name: bypassIPSetV6, Stack: IPv6, Kind: IPDst})
+	}
+	return res
+}
+
+// bypassCIDRs returns the built-in bypass CIDRs, the configured ones, and the metadata
+// services if they're protected
+func bypassCIDRs(fc config.FileConfig) []string {
+	res := append(append([]string{}, builtinBypassCIDRs...), fc.BypassCIDRs...)
+	if fc.MetadataProtection.Enable {
+		res = append(res, fc.MetadataProtection.CIDRs...)
+	}
+	return res
+}
+
+// builtinBypassedCIDRs returns the CIDRs within the built-in bypass CIDRs, they're always
+// bypassed whether the metadata services are protected or not
+func builtinBypassedCIDRs(cidrs []string) []string {
+	res := make([]string, 0)
+	for _, item := range cidrs {
+		_, cidr, err := net.ParseCIDR(item)
+		if err != nil {
+			continue
+		}
+		ones, _ := cidr.Mask.Size()
+		for _, builtin := range builtinBypassCIDRs {
+			_, bypass, _ := net.ParseCIDR(builtin)
+			if bits, _ := bypass.Mask.Size(); bits <= ones && bypass.Contains(cidr.IP) {
+				res = append(res, item)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// updateBypassIPSet syncs the bypass CIDRs to the ipsets
+func (r *policeReconciler) updateBypassIPSet() error {
+	cidrs := bypassCIDRs(r.cfg.FileConfig)
+	ipv4, ipv6, err := r.getDstCIDR(cidrs)
+	if err != nil {
+		return err
+	}
+	setNames := buildBypassIPSetNames(r.cfg.FileConfig.EnableIPv4, r.cfg.FileConfig.EnableIPv6)
+	return setNames.Map(func(set SetName) error {
+		if set.Stack == IPv6 {
+			return r.syncIPSetEntries(set, ipv6)
+		}
+		return r.syncIPSetEntries(set, ipv4)
+	})
+}
+
+// buildBypassRules returns from the chains of the policies for the bypassed destinations
+// and the addresses of the node, they precede the rules of the policies
+func buildBypassRules(version uint8) []iptables.Rule {
+	set := bypassIPSetV4
+	if version == 6 {
+		set = bypassIPSetV6
+	}
+	return []iptables.Rule{
+		{
+			Match:   iptables.MatchCriteria{}.DestIPSet(set),
+			Action:  iptables.ReturnAction{},
+			Comment: []string{"Bypass the link-local, multicast, metadata and configured destinations"},
+		},
+		{
+			Match:   iptables.MatchCriteria{}.DestAddrType(iptables.AddrTypeLocal),
+			Action:  iptables.ReturnAction{},
+			Comment: []string{"Bypass the addresses of the node"},
+		},
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"net"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/ipset"
+	ipsettest "github.com/spidernet-io/egressgateway/pkg/ipset/testing"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+func TestUpdateBypassIPSet(t *testing.T) {
+	fakeIPSet := ipsettest.NewFake("v7.1")
+	cfg := &config.Config{}
+	cfg.FileConfig.EnableIPv4 = true
+	cfg.FileConfig.EnableIPv6 = true
+	cfg.FileConfig.BypassCIDRs = []string{"100.100.100.200/32", "fd00:ec2::254/128"}
+	cfg.FileConfig.MetadataProtection.Enable = false
+	r := &policeReconciler{
+		cfg:      cfg,
+		log:      logr.Discard(),
+		ipset:    fakeIPSet,
+		ipsetMap: utils.NewSyncMap[string, *ipset.IPSet](),
+	}
+
+	// the config extends the built-in CIDRs
+	assert.NoError(t, r.updateBypassIPSet())
+	entries, err := fakeIPSet.ListEntries(bypassIPSetV4)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"169.254.0.0/16", "224.0.0.0/4", "255.255.255.255", "100.100.100.200"}, entries)
+	entries, err = fakeIPSet.ListEntries(bypassIPSetV6)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"fe80::/10", "ff00::/8", "fd00:ec2::254"}, entries)
+
+	// the built-in CIDRs are kept without the config
+	cfg.FileConfig.BypassCIDRs = nil
+	assert.NoError(t, r.updateBypassIPSet())
+	entries, err = fakeIPSet.ListEntries(bypassIPSetV6)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"fe80::/10", "ff00::/8"}, entries)
+}
+
+func TestBuiltinBypassedCIDRs(t *testing.T) {
+	cidrs := []string{"169.254.169.254/32", "169.0.0.0/8", "fd00:ec2::254/128", "fe80::a9fe:a9fe/128", "invalid"}
+	assert.Equal(t, []string{"169.254.169.254/32", "fe80::a9fe:a9fe/128"}, builtinBypassedCIDRs(cidrs))
+	assert.Empty(t, builtinBypassedCIDRs(nil))
+}
+
+func TestBuildBypassRules(t *testing.T) {
+	rules := buildBypassRules(6)
+	assert.Len(t, rules, 2)
+	assert.Equal(t, "-m set --match-set "+bypassIPSetV6+" dst", rules[0].Match.Render())
+	assert.Equal(t, "-m addrtype --dst-type LOCAL", rules[1].Match.Render())
+	for _, rule := range rules {
+		assert.Equal(t, iptables.ReturnAction{}, rule.Action)
+	}
+}
+
+func TestMetadataProtection(t *testing.T) {
+	protection := config.MetadataProtection{Enable: true, CIDRs: []string{"169.254.169.254/32", "fd00:ec2::254/128"}}
+	cases := []struct {
+		name       string
+		protection config.MetadataProtection
+		bypass     []string
+		dst        string
+		bypassed   bool
+	}{
+		{"ipv4 protected", protection, nil, "169.254.169.254", true},
+		{"ipv6 protected", protection, nil, "fd00:ec2::254", true},
+		{"ipv4 link-local unprotected", config.MetadataProtection{}, nil, "169.254.169.254", true},
+		{"ipv6 unprotected", config.MetadataProtection{}, nil, "fd00:ec2::254", false},
+		{"ipv6 bypassed by config", config.MetadataProtection{}, []string{"fd00:ec2::/64"}, "fd00:ec2::254", true},
+		{"other cloud protected", config.MetadataProtection{Enable: true, CIDRs: []string{"100.100.100.200/32"}}, nil, "100.100.100.200", true},
+		{"disabled protection ignores cidrs", config.MetadataProtection{CIDRs: []string{"100.100.100.200/32"}}, nil, "100.100.100.200", false},
+		{"ipv4 not metadata", protection, nil, "8.8.8.8", false},
+		{"ipv6 not metadata", protection, nil, "2001:4860:4860::8888", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fakeIPSet := ipsettest.NewFake("v7.1")
+			cfg := &config.Config{}
+			cfg.FileConfig.EnableIPv4 = true
+			cfg.FileConfig.EnableIPv6 = true
+			cfg.FileConfig.BypassCIDRs = c.bypass
+			cfg.FileConfig.MetadataProtection = c.protection
+			r := &policeReconciler{
+				cfg:      cfg,
+				log:      logr.Discard(),
+				ipset:    fakeIPSet,
+				ipsetMap: utils.NewSyncMap[string, *ipset.IPSet](),
+			}
+			assert.NoError(t, r.updateBypassIPSet())
+
+			set := bypassIPSetV4
+			if net.ParseIP(c.dst).To4() == nil {
+				set = bypassIPSetV6
+			}
+			entries, err := fakeIPSet.ListEntries(set)
+			assert.NoError(t, err)
+			assert.Equal(t, c.bypassed, containsIP(entries, c.dst), entries)
+		})
+	}
+}
+
+func containsIP(entries []string, dst string) bool {
+	ip := net.ParseIP(dst)
+	for _, item := range entries {
+		if _, ipNet, err := net.ParseCIDR(item); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+		if net.ParseIP(item).Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/spidernet-io/egressgateway/pkg/capture"
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egresserrors "github.com/spidernet-io/egressgateway/pkg/errors"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+// defaultCaptureDuration is the duration of the captures without the duration annotation
+const defaultCaptureDuration = time.Minute
+
+var unsafeFileNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// captureReconciler runs the packet captures requested by the capture annotation of
+// the policies. The agent of the gateway node captures the packets of the EIP and the
+// Pods of the policy, and the agents of the other nodes capture the packets of the Pods
+// of the policy on the node.
+type captureReconciler struct {
+	client   client.Client
+	log      logr.Logger
+	cfg      *config.Config
+	recorder record.EventRecorder
+	// run is capture.Run, it's replaced in the tests
+	run func(ctx context.Context, cfg capture.Config, w io.Writer) (capture.Result, error)
+
+	mu sync.Mutex
+	// captures is the capture ID handled for each policy
+	captures map[string]string
+	wg       sync.WaitGroup
+}
+
+func (r *captureReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	kind, newReq, err := utils.ParseKindWithReq(req)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	log := r.log.WithValues("kind", kind, "name", newReq.Name, "namespace", newReq.Namespace)
+
+	var obj client.Object
+	var status *egressv1.EgressPolicyStatus
+	switch kind {
+	case "EgressPolicy":
+		policy := new(egressv1.EgressPolicy)
+		obj, status = policy, &policy.Status
+	case "EgressClusterPolicy":
+		policy := new(egressv1.EgressClusterPolicy)
+		obj, status = policy, &policy.Status
+	default:
+		return reconcile.Result{}, nil
+	}
+	if err := r.client.Get(ctx, newReq.NamespacedName, obj); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	if !obj.GetDeletionTimestamp().IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	id := obj.GetAnnotations()[egressv1.AnnotationCapture]
+	if id == "" {
+		return reconcile.Result{}, nil
+	}
+	key := kind + "/" + newReq.String()
+	r.mu.Lock()
+	handled := r.captures[key] == id
+	r.captures[key] = id
+	r.mu.Unlock()
+	if handled {
+		return reconcile.Result{}, nil
+	}
+
+	file := filepath.Join(r.cfg.FileConfig.Capture.Dir, captureFileName(newReq.Namespace, newReq.Name, id, r.cfg.EnvConfig.NodeName))
+	if _, err := os.Stat(file); err == nil {
+		// captured before the agent restarted
+		return reconcile.Result{}, nil
+	}
+
+	duration, err := r.captureDuration(obj.GetAnnotations()[egressv1.AnnotationCaptureDuration])
+	if err != nil {
+		r.recorder.Eventf(obj, corev1.EventTypeWarning, "CaptureFailed",
+			"failed to capture %s on node %s: %v", id, r.cfg.EnvConfig.NodeName, err)
+		return reconcile.Result{}, nil
+	}
+
+	ips, err := r.captureIPs(ctx, newReq.Namespace, newReq.Name, status)
+	if err != nil {
+		r.mu.Lock()
+		delete(r.captures, key)
+		r.mu.Unlock()
+		return reconcile.Result{}, err
+	}
+	if len(ips) == 0 {
+		log.V(1).Info("skip the capture, neither the EIP nor the Pods of the policy are on the node", "capture", id)
+		return reconcile.Result{}, nil
+	}
+
+	cfg := capture.Config{
+		IPs:        ips,
+		Duration:   duration,
+		MaxPackets: r.cfg.FileConfig.Capture.MaxPackets,
+		SnapLen:    r.cfg.FileConfig.Capture.SnapLen,
+	}
+	log.Info("start capture", "capture", id, "file", file, "duration", duration, "ips", ips)
+	r.recorder.Eventf(obj, corev1.EventTypeNormal, "CaptureStarted",
+		"capture %s started on node %s for %s", id, r.cfg.EnvConfig.NodeName, duration)
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		res, err := r.capture(ctx, cfg, file)
+		if err != nil {
+			log.Error(err, "failed to capture", "capture", id)
+			r.recorder.Eventf(obj, corev1.EventTypeWarning, "CaptureFailed",
+				"failed to capture %s on node %s: %v", id, r.cfg.EnvConfig.NodeName, err)
+			return
+		}
+		log.Info("capture completed", "capture", id, "file", file, "packets", res.Packets)
+		r.recorder.Eventf(obj, corev1.EventTypeNormal, "CaptureCompleted",
+			"capture %s completed on node %s with %d packets, written to %s",
+			id, r.cfg.EnvConfig.NodeName, res.Packets, file)
+	}()
+	return reconcile.Result{}, nil
+}
+
+// capture writes the capture to a temporary file, which is renamed to the file once the
+// capture completes, so an interrupted capture is run again after the agent restarts
+func (r *captureReconciler) capture(ctx context.Context, cfg capture.Config, file string) (capture.Result, error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return capture.Result{}, err
+	}
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return capture.Result{}, err
+	}
+	res, err := r.run(ctx, cfg, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return res, err
+	}
+	return res, os.Rename(tmp, file)
+}
+
+// captureDuration returns the duration of the annotation, which is limited by the max
+// duration of the config
+func (r *captureReconciler) captureDuration(value string) (time.Duration, error) {
+	duration := defaultCaptureDuration
+	if value != "" {
+		var err error
+		duration, err = time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %w", egressv1.AnnotationCaptureDuration, err)
+		}
+		if duration <= 0 {
+			return 0, fmt.Errorf("%s should be greater than 0", egressv1.AnnotationCaptureDuration)
+		}
+	}
+	maxDuration := time.Duration(r.cfg.FileConfig.Capture.MaxDurationSecond) * time.Second
+	if duration > maxDuration {
+		duration = maxDuration
+	}
+	return duration, nil
+}
+
+// captureIPs returns the EIP of the policy if the node is its gateway node, and the IPs
+// of the Pods of the policy on the node
+func (r *captureReconciler) captureIPs(ctx context.Context, ns, name string, status *egressv1.EgressPolicyStatus) ([]net.IP, error) {
+	nodeName := r.cfg.EnvConfig.NodeName
+	ipv4List, ipv6List, err := listPolicySrcIPs(ctx, r.client, ns, name, func(ep egressv1.EgressEndpoint) bool {
+		return ep.Node == nodeName
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := append(ipv4List, ipv6List...)
+	if status.Node == nodeName {
+		list = append(list, status.Eip.Ipv4, status.Eip.Ipv6)
+	}
+
+	res := make([]net.IP, 0, len(list))
+	for _, item := range list {
+		if ip := net.ParseIP(item); ip != nil {
+			res = append(res, ip)
+		}
+	}
+	return res, nil
+}
+
+// captureFileName returns the name of the pcap file of the capture on the node
+func captureFileName(ns, name, id, node string) string {
+	res := name + "_" + id + "_" + node + ".pcap"
+	if ns != "" {
+		res = ns + "_" + res
+	}
+	return unsafeFileNameChars.ReplaceAllString(res, "-")
+}
+
+func newCaptureController(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
+	r := &captureReconciler{
+		client:   mgr.GetClient(),
+		log:      log,
+		cfg:      cfg,
+		recorder: mgr.GetEventRecorderFor("egressgateway-agent"),
+		run:      capture.Run,
+		captures: make(map[string]string),
+	}
+	c, err := controller.New("capture", mgr, controller.Options{Reconciler: egresserrors.NewReconciler("capture", r)})
+	if err != nil {
+		return err
+	}
+
+	hasCapture := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		_, ok := obj.GetAnnotations()[egressv1.AnnotationCapture]
+		return ok
+	})
+	if err := c.Watch(source.Kind(mgr.GetCache(), &egressv1.EgressPolicy{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressPolicy")), hasCapture); err != nil {
+		return fmt.Errorf("failed to watch EgressPolicy: %w", err)
+	}
+	if err := c.Watch(source.Kind(mgr.GetCache(), &egressv1.EgressClusterPolicy{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressClusterPolicy")), hasCapture); err != nil {
+		return fmt.Errorf("failed to watch EgressClusterPolicy: %w", err)
+	}
+	return nil
+}
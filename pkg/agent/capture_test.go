@@ -0,0 +1,122 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/capture"
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestCaptureReconciler(t *testing.T) {
+	ctx := context.Background()
+	policy := &egressv1.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "policy1",
+			Namespace: "default",
+			Annotations: map[string]string{
+				egressv1.AnnotationCapture:         "1",
+				egressv1.AnnotationCaptureDuration: "1h",
+			},
+		},
+		Status: egressv1.EgressPolicyStatus{Node: "node1", Eip: egressv1.Eip{Ipv4: "10.6.1.21"}},
+	}
+	slice := &egressv1.EgressEndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "policy1-abc",
+			Namespace: "default",
+			Labels:    map[string]string{egressv1.LabelPolicyName: "policy1"},
+		},
+		Endpoints: []egressv1.EgressEndpoint{
+			{Pod: "pod1", Node: "node1", IPv4: []string{"10.21.0.1"}},
+			{Pod: "pod2", Node: "node2", IPv4: []string{"10.21.0.2"}},
+		},
+	}
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(policy, slice).Build()
+
+	cfg := &config.Config{}
+	cfg.EnvConfig.NodeName = "node1"
+	cfg.FileConfig.Capture = config.Capture{Enable: true, Dir: t.TempDir(), MaxDurationSecond: 60, MaxPackets: 10}
+
+	var runs []capture.Config
+	recorder := record.NewFakeRecorder(10)
+	r := &captureReconciler{
+		client:   cli,
+		log:      logr.Discard(),
+		cfg:      cfg,
+		recorder: recorder,
+		run: func(ctx context.Context, cfg capture.Config, w io.Writer) (capture.Result, error) {
+			runs = append(runs, cfg)
+			_, err := w.Write([]byte("pcap"))
+			return capture.Result{Packets: 1}, err
+		},
+		captures: make(map[string]string),
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "EgressPolicy/default", Name: "policy1"}}
+
+	_, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	r.wg.Wait()
+	assert.Len(t, runs, 1)
+	// the EIP and the Pods on the node, the duration is limited by the config
+	assert.ElementsMatch(t, []net.IP{net.ParseIP("10.21.0.1"), net.ParseIP("10.6.1.21")}, runs[0].IPs)
+	assert.Equal(t, time.Minute, runs[0].Duration)
+	assert.Equal(t, 10, runs[0].MaxPackets)
+	data, err := os.ReadFile(filepath.Join(cfg.FileConfig.Capture.Dir, "default_policy1_1_node1.pcap"))
+	assert.NoError(t, err)
+	assert.Equal(t, "pcap", string(data))
+	assert.Contains(t, <-recorder.Events, "CaptureStarted")
+	assert.Contains(t, <-recorder.Events, "CaptureCompleted")
+
+	// the handled capture is not run again, even after the agent restarts
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	r.captures = make(map[string]string)
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	r.wg.Wait()
+	assert.Len(t, runs, 1)
+
+	// capture again with a new ID, the node without the EIP captures the Pods on it only
+	policy.Annotations[egressv1.AnnotationCapture] = "2"
+	policy.Status.Node = "node2"
+	assert.NoError(t, cli.Update(ctx, policy))
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	r.wg.Wait()
+	assert.Len(t, runs, 2)
+	assert.Equal(t, []net.IP{net.ParseIP("10.21.0.1")}, runs[1].IPs)
+
+	// the invalid duration
+	policy.Annotations[egressv1.AnnotationCapture] = "3"
+	policy.Annotations[egressv1.AnnotationCaptureDuration] = "abc"
+	assert.NoError(t, cli.Update(ctx, policy))
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	r.wg.Wait()
+	assert.Len(t, runs, 2)
+}
+
+func TestCaptureFileName(t *testing.T) {
+	assert.Equal(t, "default_policy1_2023-01-01T00-00-00_node1.pcap",
+		captureFileName("default", "policy1", "2023-01-01T00:00:00", "node1"))
+	assert.Equal(t, "policy1_a-b_node1.pcap", captureFileName("", "policy1", "a/b", "node1"))
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/vishvananda/netlink"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/utils/exec"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/route"
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/ipset"
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
+)
+
+// networkDeviceRegexp matches the vxlan device names of the dedicated tunnel networks
+var networkDeviceRegexp = regexp.MustCompile(`^egress\.[0-9]+$`)
+
+// Cleanup removes the datapath created by the agent from the node: the iptables rules
+// and chains, the ipsets, the policy routing rules and routes, and the vxlan devices
+// along with their addresses and neighbors. The EIPs are announced by ARP and NDP
+// without being assigned to the interfaces, so they leave nothing on the node.
+// It goes on after a failure, and returns all the errors.
+func Cleanup(cfg *config.Config, log logr.Logger) error {
+	errs := make([]error, 0)
+
+	mangleTables, natTables, filterTables, err := newTables(cfg, log)
+	if err != nil {
+		return fmt.Errorf("failed to create iptables tables: %w", err)
+	}
+	// the tables without any chain or rule delete all the chains and rules of ours
+	tables := append(mangleTables, natTables...)
+	tables = append(tables, filterTables...)
+	for _, table := range tables {
+		log.Info("clean iptables", "table", table.Name, "ipVersion", table.IPVersion)
+		if _, err := table.Apply(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to clean iptables table %s: %w", table.Name, err))
+		}
+	}
+
+	// the ipsets are destroyed after the rules referring them are deleted
+	sets := ipset.New(exec.New())
+	names, err := sets.ListSets()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to list ipsets: %w", err))
+	}
+	for _, name := range names {
+		if !strings.HasPrefix(name, "egress-") {
+			continue
+		}
+		log.Info("destroy ipset", "ipset", name)
+		if err := sets.DestroySet(name); err != nil {
+			errs = append(errs, fmt.Errorf("failed to destroy ipset %s: %w", name, err))
+		}
+	}
+
+	space, err := markallocator.NewSpace(cfg.FileConfig.Mark, cfg.FileConfig.MarkMask)
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		routeTables := make([]int, 0)
+		if cfg.FileConfig.EnableGatewayReplyRoute {
+			// the reply route table may be renumbered away from the tables of the others
+			tables := route.NewTables(space, true)
+			routeTables = append(routeTables, tables.Candidates(cfg.FileConfig.GatewayReplyRouteTable)...)
+		}
+		if cfg.FileConfig.L7Proxy.Enable {
+			routeTables = append(routeTables, cfg.FileConfig.L7Proxy.RouteTable)
+		}
+		if err := route.NewRuleRoute(log).Purge(space, routeTables...); err != nil {
+			errs = append(errs, fmt.Errorf("failed to purge route rules: %w", err))
+		}
+	}
+
+	// the boot files are only reachable if the directories of the host are mounted
+	if conf := cfg.FileConfig.BootPersistence; conf.Enable {
+		log.Info("remove the boot files", "dir", conf.Dir)
+		if err := removeBootPersistence(conf.Dir, conf.SystemdDir); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove the boot files: %w", err))
+		}
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to list links: %w", err))
+	}
+	for _, link := range links {
+		name := link.Attrs().Name
+		if link.Type() != "vxlan" || (name != cfg.FileConfig.VXLAN.Name && !networkDeviceRegexp.MatchString(name)) {
+			continue
+		}
+		log.Info("delete vxlan device", "device", name)
+		if err := netlink.LinkDel(link); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete vxlan device %s: %w", name, err))
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
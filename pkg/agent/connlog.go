@@ -0,0 +1,163 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/nflog"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+const (
+	connLogPrefix = "egress-log-"
+	// connLogRetryInterval is the interval of listening the NFLOG group again after it fails
+	connLogRetryInterval = 10 * time.Second
+)
+
+// loggedPolicy is the policy whose new connections are logged on the node
+type loggedPolicy struct {
+	Policy  egressv1.Policy
+	Logging egressv1.PolicyLogging
+	EIP     IP
+}
+
+// connLogPrefixOf returns the NFLOG prefix of the policy, by which the collector finds the
+// policy of the logged packets
+func connLogPrefixOf(policyName string) string {
+	return formatIPSetName(connLogPrefix, policyName)
+}
+
+// loggingEnabled returns whether the new connections are logged by the logging of a policy
+func loggingEnabled(logging *egressv1.PolicyLogging) bool {
+	return logging != nil && logging.Enabled && logging.SampleRate > 0
+}
+
+func (r *policeReconciler) getPolicyLogging(ns, name string) (*egressv1.PolicyLogging, error) {
+	key := types.NamespacedName{Namespace: ns, Name: name}
+	if ns != "" {
+		obj := new(egressv1.EgressPolicy)
+		if err := r.client.Get(context.Background(), key, obj); err != nil {
+			return nil, client.IgnoreNotFound(err)
+		}
+		return obj.Spec.Logging, nil
+	}
+	obj := new(egressv1.EgressClusterPolicy)
+	if err := r.client.Get(context.Background(), key, obj); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return obj.Spec.Logging, nil
+}
+
+// syncLoggedPolicy returns whether the rules should be applied again, as the logging of the
+// policy SNATed on the node is changed
+func (r *policeReconciler) syncLoggedPolicy(policy egressv1.Policy, logging *egressv1.PolicyLogging) bool {
+	if !r.cfg.FileConfig.ConnectionLog.Enable {
+		return false
+	}
+	policyName := policy.Name
+	if policy.Namespace != "" {
+		policyName = policy.Namespace + "-" + policy.Name
+	}
+	applied, ok := r.loggedPolicies.Load(connLogPrefixOf(policyName))
+	if !loggingEnabled(logging) {
+		return ok
+	}
+	return !ok || applied.Logging.SampleRate != logging.SampleRate
+}
+
+// buildConnectionLogRule sends the new connections of the policy SNATed by the EIP rule to
+// the NFLOG group, the sampled ones if the sample rate is less than 100. It's in the SNAT
+// chain, which only sees the first packets of the connections.
+func buildConnectionLogRule(policyName string, eip IP, logging *egressv1.PolicyLogging, group uint16, version uint8, isIgnoreInternalCIDR bool) *iptables.Rule {
+	if !loggingEnabled(logging) {
+		return nil
+	}
+
+	tmp := "v4-"
+	ip := eip.V4
+	ignoreName := EgressClusterCIDRIPv4
+	if version == 6 {
+		tmp = "v6-"
+		ip = eip.V6
+		ignoreName = EgressClusterCIDRIPv6
+	}
+	if ip == "" {
+		return nil
+	}
+	srcName := formatIPSetName("egress-src-"+tmp, policyName)
+	dstName := formatIPSetName("egress-dst-"+tmp, policyName)
+
+	match := iptables.MatchCriteria{}.SourceIPSet(srcName).DestIPSet(dstName)
+	if isIgnoreInternalCIDR {
+		match = iptables.MatchCriteria{}.SourceIPSet(srcName).NotDestIPSet(ignoreName)
+	}
+	match = match.CTDirectionOriginal(iptables.DirectionOriginal)
+	if logging.SampleRate < 100 {
+		match = match.Probability(float64(logging.SampleRate) / 100)
+	}
+	return &iptables.Rule{
+		Match:   match,
+		Action:  iptables.NflogAction{Group: group, Prefix: connLogPrefixOf(policyName)},
+		Comment: []string{"Log the new connections of " + policyName},
+	}
+}
+
+// connectionLogger collects the new connections of the policies from the NFLOG group, and
+// logs them with the policies and their EIPs
+type connectionLogger struct {
+	group    uint16
+	snapLen  int
+	policies *utils.SyncMap[string, loggedPolicy]
+	log      logr.Logger
+}
+
+func (c *connectionLogger) Start(ctx context.Context) error {
+	c.log.Info("start collecting the new connections", "group", c.group)
+	for {
+		err := nflog.Listen(ctx, c.group, c.snapLen, c.handle)
+		if err == nil {
+			return nil
+		}
+		c.log.Error(err, "failed to listen the NFLOG group, retry later", "group", c.group)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(connLogRetryInterval):
+		}
+	}
+}
+
+func (c *connectionLogger) handle(packet nflog.Packet) {
+	policy, ok := c.policies.Load(packet.Prefix)
+	if !ok {
+		return
+	}
+	conn, ok := nflog.ParseConnection(packet.Payload)
+	if !ok {
+		return
+	}
+	eip := policy.EIP.V4
+	if conn.Src.To4() == nil {
+		eip = policy.EIP.V6
+	}
+	c.log.Info("new connection",
+		"policy", policy.Policy.Name,
+		"namespace", policy.Policy.Namespace,
+		"eip", eip,
+		"protocol", conn.Protocol,
+		"src", conn.Src.String(),
+		"srcPort", conn.SrcPort,
+		"dst", conn.Dst.String(),
+		"dstPort", conn.DstPort,
+		"time", packet.Time.UTC().Format(time.RFC3339Nano),
+	)
+}
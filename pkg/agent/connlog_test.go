@@ -0,0 +1,80 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+func TestBuildConnectionLogRule(t *testing.T) {
+	eip := IP{V4: "10.6.1.21"}
+	logging := &egressv1.PolicyLogging{Enabled: true, SampleRate: 100}
+
+	rule := buildConnectionLogRule("default-test", eip, logging, 100, 4, false)
+	assert.NotNil(t, rule)
+	assert.Equal(t, "-m set --match-set "+formatIPSetName("egress-src-v4-", "default-test")+" src "+
+		"-m set --match-set "+formatIPSetName("egress-dst-v4-", "default-test")+" dst "+
+		"-m conntrack --ctdir ORIGINAL", rule.Match.Render())
+	assert.Equal(t, `--jump NFLOG --nflog-group 100 --nflog-prefix "`+connLogPrefixOf("default-test")+`"`,
+		rule.Action.ToFragment(&iptables.Options{}))
+
+	// the connections are sampled by the sample rate
+	rule = buildConnectionLogRule("default-test", eip, &egressv1.PolicyLogging{Enabled: true, SampleRate: 25}, 100, 4, true)
+	assert.NotNil(t, rule)
+	assert.Equal(t, "-m set --match-set "+formatIPSetName("egress-src-v4-", "default-test")+" src "+
+		"-m set ! --match-set "+EgressClusterCIDRIPv4+" dst "+
+		"-m conntrack --ctdir ORIGINAL -m statistic --mode random --probability 0.2500", rule.Match.Render())
+
+	assert.Nil(t, buildConnectionLogRule("default-test", eip, logging, 100, 6, false))
+	assert.Nil(t, buildConnectionLogRule("default-test", eip, nil, 100, 4, false))
+	assert.Nil(t, buildConnectionLogRule("default-test", eip, &egressv1.PolicyLogging{SampleRate: 100}, 100, 4, false))
+	assert.Nil(t, buildConnectionLogRule("default-test", eip, &egressv1.PolicyLogging{Enabled: true}, 100, 4, false))
+}
+
+func TestSyncLoggedPolicy(t *testing.T) {
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(&egressv1.EgressPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+			Spec:       egressv1.EgressPolicySpec{Logging: &egressv1.PolicyLogging{Enabled: true, SampleRate: 50}},
+		}).Build()
+	cfg := &config.Config{}
+	r := &policeReconciler{
+		client:         cli,
+		cfg:            cfg,
+		log:            logr.Discard(),
+		loggedPolicies: utils.NewSyncMap[string, loggedPolicy](),
+	}
+
+	logging, err := r.getPolicyLogging("default", "test")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(50), logging.SampleRate)
+	logging, err = r.getPolicyLogging("", "deleted")
+	assert.NoError(t, err)
+	assert.Nil(t, logging)
+
+	policy := egressv1.Policy{Name: "test", Namespace: "default"}
+	enabled := &egressv1.PolicyLogging{Enabled: true, SampleRate: 50}
+	// the connections aren't logged if the feature is disabled
+	assert.False(t, r.syncLoggedPolicy(policy, enabled))
+
+	cfg.FileConfig.ConnectionLog.Enable = true
+	assert.True(t, r.syncLoggedPolicy(policy, enabled))
+	assert.False(t, r.syncLoggedPolicy(policy, nil))
+	r.loggedPolicies.Store(connLogPrefixOf("default-test"), loggedPolicy{Policy: policy, Logging: *enabled})
+	assert.False(t, r.syncLoggedPolicy(policy, enabled))
+	assert.True(t, r.syncLoggedPolicy(policy, &egressv1.PolicyLogging{Enabled: true, SampleRate: 100}))
+	assert.True(t, r.syncLoggedPolicy(policy, &egressv1.PolicyLogging{SampleRate: 50}))
+}
@@ -0,0 +1,219 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/vishvananda/netlink"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/privilege"
+)
+
+const (
+	sysctlTCPEstablished = "net/netfilter/nf_conntrack_tcp_timeout_established"
+	sysctlUDP            = "net/netfilter/nf_conntrack_udp_timeout"
+	sysctlUDPStream      = "net/netfilter/nf_conntrack_udp_timeout_stream"
+
+	// sysctlSCTPEstablished exists if the kernel tracks SCTP, which is built in
+	// or provided by the nf_conntrack_proto_sctp module of the old kernels
+	sysctlSCTPEstablished = "net/netfilter/nf_conntrack_sctp_timeout_established"
+)
+
+// conntrackTuner sets the conntrack timeout sysctls of the node by the overrides of the
+// gateways of the node, and restores the original values when they are not overridden.
+type conntrackTuner struct {
+	log logr.Logger
+	// procSys is the root of the sysctls to read
+	procSys string
+	sysctl  privilege.SysctlWriter
+	// original is the values of the sysctls before they are overridden
+	original map[string]string
+}
+
+func newConntrackTuner(log logr.Logger, sysctl privilege.SysctlWriter) *conntrackTuner {
+	return &conntrackTuner{log: log, procSys: "/proc/sys", sysctl: sysctl, original: make(map[string]string)}
+}
+
+// conntrackTimeouts returns the sysctls overridden by the gateways of the node, the
+// sysctls are shared by all flows of the node, so the largest value wins.
+func conntrackTimeouts(gateways []egressv1.EgressGateway, nodeName string) map[string]int32 {
+	res := make(map[string]int32)
+	set := func(key string, val int32) {
+		if val > res[key] {
+			res[key] = val
+		}
+	}
+	for _, gateway := range gateways {
+		timeouts := gateway.Spec.Conntrack
+		if timeouts == nil || !gateway.DeletionTimestamp.IsZero() {
+			continue
+		}
+		for _, node := range gateway.Status.NodeList {
+			if node.Name != nodeName {
+				continue
+			}
+			set(sysctlTCPEstablished, timeouts.TCPEstablishedSeconds)
+			set(sysctlUDP, timeouts.UDPSeconds)
+			set(sysctlUDPStream, timeouts.UDPStreamSeconds)
+		}
+	}
+	for key, val := range res {
+		if val <= 0 {
+			delete(res, key)
+		}
+	}
+	return res
+}
+
+// apply sets the overridden sysctls, and restores the others
+func (t *conntrackTuner) apply(timeouts map[string]int32) error {
+	for _, key := range []string{sysctlTCPEstablished, sysctlUDP, sysctlUDPStream} {
+		path := filepath.Join(t.procSys, key)
+		val, ok := timeouts[key]
+		if !ok {
+			original, saved := t.original[key]
+			if !saved {
+				continue
+			}
+			if err := t.sysctl.WriteSysctl(key, original); err != nil {
+				return fmt.Errorf("failed to write %s: %w", key, err)
+			}
+			t.log.Info("restore conntrack timeout", "sysctl", key, "value", original)
+			delete(t.original, key)
+			continue
+		}
+
+		current, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		want := strconv.Itoa(int(val))
+		if strings.TrimSpace(string(current)) == want {
+			continue
+		}
+		if _, saved := t.original[key]; !saved {
+			t.original[key] = strings.TrimSpace(string(current))
+		}
+		if err := t.sysctl.WriteSysctl(key, want); err != nil {
+			return fmt.Errorf("failed to write %s: %w", key, err)
+		}
+		t.log.Info("set conntrack timeout", "sysctl", key, "value", want)
+	}
+	return nil
+}
+
+// sctpConntrackSupported returns whether the kernel tracks SCTP. Otherwise the SCTP
+// associations are tracked without ports, the associations of the pods SNATed to
+// the same EIP and going to the same destination can't get unique tuples, and leave
+// the gateway node without SNAT.
+func sctpConntrackSupported(procSys string) bool {
+	_, err := os.Stat(filepath.Join(procSys, sysctlSCTPEstablished))
+	return err == nil
+}
+
+// eipFlowFilter matches the flows SNATed to the EIP, and from the sources if they are set
+type eipFlowFilter struct {
+	eip     net.IP
+	sources map[string]struct{}
+}
+
+func (f eipFlowFilter) MatchConntrackFlow(flow *netlink.ConntrackFlow) bool {
+	// the reply of a SNATed flow is sent to the EIP, while the flows of the node
+	// itself using the EIP as the source are not SNATed
+	if !flow.Reverse.DstIP.Equal(f.eip) || flow.Forward.SrcIP.Equal(f.eip) {
+		return false
+	}
+	if f.sources == nil {
+		return true
+	}
+	_, ok := f.sources[flow.Forward.SrcIP.String()]
+	return ok
+}
+
+// staleEIPs returns the EIPs applied to the policies on the node previously, which are
+// not applied to the policies any more
+func staleEIPs(previous, current map[egressv1.Policy]IP) map[egressv1.Policy]IP {
+	res := make(map[egressv1.Policy]IP)
+	for policy, prev := range previous {
+		cur := current[policy]
+		stale := IP{}
+		if prev.V4 != "" && prev.V4 != cur.V4 {
+			stale.V4 = prev.V4
+		}
+		if prev.V6 != "" && prev.V6 != cur.V6 {
+			stale.V6 = prev.V6
+		}
+		if stale.V4 != "" || stale.V6 != "" {
+			res[policy] = stale
+		}
+	}
+	return res
+}
+
+// flushStaleConntrack deletes the conntrack entries SNATed to the EIPs which are no longer
+// applied to the policies, so the existing flows switch to the new EIP at once. If the EIP
+// is still used by other policies on the node, only the flows from the sources of the
+// policy are deleted.
+func (r *policeReconciler) flushStaleConntrack(current map[egressv1.Policy]IP) {
+	inUse := make(map[string]struct{})
+	for _, ip := range current {
+		inUse[ip.V4] = struct{}{}
+		inUse[ip.V6] = struct{}{}
+	}
+
+	for policy, stale := range staleEIPs(r.appliedEIPs, current) {
+		var srcV4, srcV6 []string
+		for _, eip := range []string{stale.V4, stale.V6} {
+			if eip == "" {
+				continue
+			}
+			filter := eipFlowFilter{eip: net.ParseIP(eip)}
+			if filter.eip == nil {
+				continue
+			}
+			if _, ok := inUse[eip]; ok {
+				if srcV4 == nil && srcV6 == nil {
+					var err error
+					srcV4, srcV6, err = r.getPolicySrcIPs(policy.Namespace, policy.Name,
+						func(egressv1.EgressEndpoint) bool { return true })
+					if err != nil {
+						r.log.Error(err, "failed to get sources of policy, skip flushing conntrack", "policy", policy)
+						continue
+					}
+				}
+				sources := srcV4
+				if filter.eip.To4() == nil {
+					sources = srcV6
+				}
+				// the flows of the policy can't be told from the others
+				if len(sources) == 0 {
+					continue
+				}
+				filter.sources = make(map[string]struct{}, len(sources))
+				for _, src := range sources {
+					filter.sources[src] = struct{}{}
+				}
+			}
+
+			family := netlink.InetFamily(netlink.FAMILY_V4)
+			if filter.eip.To4() == nil {
+				family = netlink.InetFamily(netlink.FAMILY_V6)
+			}
+			n, err := netlink.ConntrackDeleteFilter(netlink.ConntrackTable, family, filter)
+			if err != nil {
+				r.log.Error(err, "failed to flush conntrack of previous EIP", "policy", policy, "eip", eip)
+				continue
+			}
+			r.log.Info("flush conntrack of previous EIP", "policy", policy, "eip", eip, "entries", n)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/privilege"
+)
+
+func TestConntrackTuner(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "net/netfilter"), 0755))
+	read := func(key string) string {
+		b, err := os.ReadFile(filepath.Join(root, key))
+		assert.NoError(t, err)
+		return string(b)
+	}
+	for key, val := range map[string]string{
+		sysctlTCPEstablished: "432000\n",
+		sysctlUDP:            "30\n",
+		sysctlUDPStream:      "120\n",
+	} {
+		assert.NoError(t, os.WriteFile(filepath.Join(root, key), []byte(val), 0644))
+	}
+
+	gateway := func(name string, timeouts *egressv1.ConntrackTimeouts, nodes ...string) egressv1.EgressGateway {
+		egw := egressv1.EgressGateway{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       egressv1.EgressGatewaySpec{Conntrack: timeouts},
+		}
+		for _, node := range nodes {
+			egw.Status.NodeList = append(egw.Status.NodeList, egressv1.EgressIPStatus{Name: node})
+		}
+		return egw
+	}
+	gateways := []egressv1.EgressGateway{
+		gateway("egw1", &egressv1.ConntrackTimeouts{TCPEstablishedSeconds: 3600, UDPSeconds: 60}, "node1"),
+		gateway("egw2", &egressv1.ConntrackTimeouts{TCPEstablishedSeconds: 7200}, "node1", "node2"),
+		gateway("egw3", &egressv1.ConntrackTimeouts{UDPStreamSeconds: 600}, "node2"),
+	}
+	timeouts := conntrackTimeouts(gateways, "node1")
+	assert.Equal(t, map[string]int32{sysctlTCPEstablished: 7200, sysctlUDP: 60}, timeouts)
+
+	tuner := &conntrackTuner{
+		log:      logr.Discard(),
+		procSys:  root,
+		sysctl:   privilege.NewSysctlWriter(root, ""),
+		original: make(map[string]string),
+	}
+	assert.NoError(t, tuner.apply(timeouts))
+	assert.Equal(t, "7200", read(sysctlTCPEstablished))
+	assert.Equal(t, "60", read(sysctlUDP))
+	assert.Equal(t, "120\n", read(sysctlUDPStream))
+
+	// the original values are restored when the node leaves the gateways
+	assert.NoError(t, tuner.apply(conntrackTimeouts(gateways, "node3")))
+	assert.Equal(t, "432000", read(sysctlTCPEstablished))
+	assert.Equal(t, "30", read(sysctlUDP))
+	assert.Empty(t, tuner.original)
+}
+
+func TestSCTPConntrackSupported(t *testing.T) {
+	root := t.TempDir()
+	assert.False(t, sctpConntrackSupported(root))
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "net/netfilter"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, sysctlSCTPEstablished), []byte("432000\n"), 0644))
+	assert.True(t, sctpConntrackSupported(root))
+}
+
+func TestStaleEIPs(t *testing.T) {
+	p1 := egressv1.Policy{Name: "p1", Namespace: "default"}
+	p2 := egressv1.Policy{Name: "p2", Namespace: "default"}
+	p3 := egressv1.Policy{Name: "p3"}
+	previous := map[egressv1.Policy]IP{
+		p1: {V4: "10.6.1.21", V6: "fd00::21"},
+		p2: {V4: "10.6.1.22"},
+		p3: {V4: "10.6.1.23"},
+	}
+	current := map[egressv1.Policy]IP{
+		p1: {V4: "10.6.1.21", V6: "fd00::25"},
+		p3: {V4: "10.6.1.23"},
+	}
+	assert.Equal(t, map[egressv1.Policy]IP{
+		p1: {V6: "fd00::21"},
+		p2: {V4: "10.6.1.22"},
+	}, staleEIPs(previous, current))
+}
+
+func TestEIPFlowFilter(t *testing.T) {
+	flow := func(src, replyDst string) *netlink.ConntrackFlow {
+		f := new(netlink.ConntrackFlow)
+		f.Forward.SrcIP = net.ParseIP(src)
+		f.Reverse.DstIP = net.ParseIP(replyDst)
+		return f
+	}
+	filter := eipFlowFilter{eip: net.ParseIP("10.6.1.21")}
+	assert.True(t, filter.MatchConntrackFlow(flow("10.21.0.5", "10.6.1.21")))
+	assert.False(t, filter.MatchConntrackFlow(flow("10.21.0.5", "10.6.1.22")))
+	assert.False(t, filter.MatchConntrackFlow(flow("10.6.1.21", "10.6.1.21")))
+
+	filter.sources = map[string]struct{}{"10.21.0.6": {}}
+	assert.False(t, filter.MatchConntrackFlow(flow("10.21.0.5", "10.6.1.21")))
+	assert.True(t, filter.MatchConntrackFlow(flow("10.21.0.6", "10.6.1.21")))
+}
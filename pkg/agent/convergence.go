@@ -0,0 +1,111 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+var histogramProgrammingSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "egress_datapath_programming_seconds",
+	Help:    "Seconds from a change of the policies, the endpoint slices or the gateways observed by the agent to the datapath of the node programmed",
+	Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+}, []string{"node", "kind"})
+
+// changeKey is the key of the change of the object of the kind
+func changeKey(kind string, req types.NamespacedName) string {
+	if req.Namespace == "" {
+		return kind + "/" + req.Name
+	}
+	return kind + "/" + req.Namespace + "/" + req.Name
+}
+
+// convergenceTracker records the time a change is observed by the watches, and the
+// duration until the datapath of the node is programmed for it. The changes coalesced
+// before they're programmed are measured from the first one.
+type convergenceTracker struct {
+	node string
+
+	mutex    sync.Mutex
+	observed map[string]time.Time
+	// deferred is the changes left to the batched applies
+	deferred sets.Set[string]
+}
+
+func newConvergenceTracker(node string) *convergenceTracker {
+	return &convergenceTracker{
+		node:     node,
+		observed: make(map[string]time.Time),
+		deferred: sets.New[string](),
+	}
+}
+
+// Observe wraps the map function of a watch, recording the changes of the requests
+func (t *convergenceTracker) Observe(mapFunc handler.MapFunc) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		reqs := mapFunc(ctx, obj)
+		now := time.Now()
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		for _, req := range reqs {
+			kind, newReq, err := utils.ParseKindWithReq(req)
+			if err != nil {
+				continue
+			}
+			key := changeKey(kind, newReq.NamespacedName)
+			if _, ok := t.observed[key]; !ok {
+				t.observed[key] = now
+			}
+		}
+		return reqs
+	}
+}
+
+// Defer marks the change as programmed by a batched apply later
+func (t *convergenceTracker) Defer(key string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.deferred.Insert(key)
+}
+
+// Settle records the change as programmed once its reconciliation is done, unless it's
+// deferred to a batched apply
+func (t *convergenceTracker) Settle(key string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.deferred.Has(key) {
+		return
+	}
+	t.programmed(key)
+}
+
+// Programmed records the change as programmed
+func (t *convergenceTracker) Programmed(key string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.deferred.Delete(key)
+	t.programmed(key)
+}
+
+func (t *convergenceTracker) programmed(key string) {
+	observed, ok := t.observed[key]
+	if !ok {
+		return
+	}
+	delete(t.observed, key)
+	kind, _, _ := strings.Cut(key, "/")
+	histogramProgrammingSeconds.WithLabelValues(t.node, kind).Observe(time.Since(observed).Seconds())
+}
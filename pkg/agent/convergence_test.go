@@ -0,0 +1,66 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+func programmedCount(t *testing.T, node, kind string) uint64 {
+	m := new(dto.Metric)
+	assert.NoError(t, histogramProgrammingSeconds.WithLabelValues(node, kind).(prometheus.Metric).Write(m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestChangeKey(t *testing.T) {
+	assert.Equal(t, "EgressGateway/default", changeKey("EgressGateway", types.NamespacedName{Name: "default"}))
+	assert.Equal(t, "EgressPolicy/ns/p1", changeKey("EgressPolicy", types.NamespacedName{Namespace: "ns", Name: "p1"}))
+}
+
+func TestConvergenceTracker(t *testing.T) {
+	tracker := newConvergenceTracker("node-convergence")
+	observe := tracker.Observe(utils.KindToMapFlat("EgressPolicy"))
+	policy := &egressv1.EgressPolicy{}
+	policy.Namespace, policy.Name = "ns", "p1"
+
+	// the change is measured once it's settled
+	reqs := observe(context.Background(), policy)
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "EgressPolicy/ns", Name: "p1"}}}, reqs)
+	tracker.Settle("EgressPolicy/ns/p1")
+	assert.Equal(t, uint64(1), programmedCount(t, "node-convergence", "EgressPolicy"))
+
+	// the unobserved changes are not measured
+	tracker.Settle("EgressPolicy/ns/p1")
+	assert.Equal(t, uint64(1), programmedCount(t, "node-convergence", "EgressPolicy"))
+
+	// the deferred change is measured by the batched apply, once for the coalesced changes
+	observe(context.Background(), policy)
+	tracker.Defer("EgressPolicy/ns/p1")
+	tracker.Settle("EgressPolicy/ns/p1")
+	observe(context.Background(), policy)
+	tracker.Settle("EgressPolicy/ns/p1")
+	assert.Equal(t, uint64(1), programmedCount(t, "node-convergence", "EgressPolicy"))
+	before := tracker.observed["EgressPolicy/ns/p1"]
+	observe(context.Background(), policy)
+	assert.Equal(t, before, tracker.observed["EgressPolicy/ns/p1"])
+	tracker.Programmed("EgressPolicy/ns/p1")
+	assert.Equal(t, uint64(2), programmedCount(t, "node-convergence", "EgressPolicy"))
+	assert.Empty(t, tracker.observed)
+	assert.Empty(t, tracker.deferred)
+
+	// the objects mapped to no request are ignored
+	slice := &egressv1.EgressEndpointSlice{}
+	assert.Empty(t, tracker.Observe(enqueueEndpointSlice())(context.Background(), slice))
+	assert.Empty(t, tracker.observed)
+}
@@ -0,0 +1,262 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+const (
+	// the comments of the rules matching the traffic of the policies, followed by the
+	// name of the policy
+	markRuleCommentPrefix = "Set mark for EgressPolicy "
+	snatRuleCommentPrefix = "snat policy "
+)
+
+// counterReader reads the counters of the rules of a chain, it's *iptables.Table
+type counterReader interface {
+	ReadCounters(chainName string) ([]iptables.RuleCounters, error)
+}
+
+// ruleCounters is the counters of the rules of a policy on the node. The mark rule
+// matches the traffic forwarded to the gateway node, the SNAT rule in the nat table
+// only matches the first packet of each connection, and the ICMP rule matches the ICMP
+// errors translated back to the Pods on the gateway node.
+type ruleCounters struct {
+	// HasMark, HasSNAT and HasICMP are true if the policy has the rule on the node
+	HasMark     bool
+	HasSNAT     bool
+	HasICMP     bool
+	MarkPackets uint64
+	MarkBytes   uint64
+	SNATPackets uint64
+	SNATBytes   uint64
+	ICMPPackets uint64
+	ICMPBytes   uint64
+}
+
+// policyCounters samples the counters of the rules of the policies on the node every
+// interval, exports them as the metrics, and records them in the status of the policies
+type policyCounters struct {
+	client       client.Client
+	log          logr.Logger
+	nodeName     string
+	interval     time.Duration
+	mangleTables []counterReader
+	natTables    []counterReader
+
+	mu   sync.RWMutex
+	last map[egressv1.Policy]ruleCounters
+}
+
+func (p *policyCounters) Start(ctx context.Context) error {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := p.sample(ctx); err != nil {
+				p.log.Error(err, "failed to sample the counters of the policies")
+			}
+		}
+	}
+}
+
+// sample reads the counters of the rules, and updates the status of the policies whose
+// counters on the node are changed
+func (p *policyCounters) sample(ctx context.Context) error {
+	counters, err := p.readCounters()
+	if err != nil {
+		return err
+	}
+
+	policies := make([]egressv1.Policy, 0)
+	egpList := new(egressv1.EgressPolicyList)
+	if err := p.client.List(ctx, egpList); err != nil {
+		return err
+	}
+	for _, item := range egpList.Items {
+		policies = append(policies, egressv1.Policy{Name: item.Name, Namespace: item.Namespace})
+	}
+	egcpList := new(egressv1.EgressClusterPolicyList)
+	if err := p.client.List(ctx, egcpList); err != nil {
+		return err
+	}
+	for _, item := range egcpList.Items {
+		policies = append(policies, egressv1.Policy{Name: item.Name})
+	}
+
+	last := make(map[egressv1.Policy]ruleCounters)
+	for _, policy := range policies {
+		policyName := policy.Name
+		if policy.Namespace != "" {
+			policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+		}
+		val, ok := counters[policyName]
+		if ok {
+			last[policy] = val
+		}
+		if err := p.updateStatus(ctx, policy, val, ok); err != nil {
+			p.log.Error(err, "failed to update the counters of the policy", "policy", policy)
+		}
+	}
+
+	p.mu.Lock()
+	p.last = last
+	p.mu.Unlock()
+	return nil
+}
+
+// readCounters returns the counters of the rules by the name of the policy in the
+// comments, the counters of IPv4 and IPv6 are summed up
+func (p *policyCounters) readCounters() (map[string]ruleCounters, error) {
+	res := make(map[string]ruleCounters)
+	read := func(tables []counterReader, chain, prefix string, add func(val *ruleCounters, rule iptables.RuleCounters)) error {
+		for _, table := range tables {
+			rules, err := table.ReadCounters(chain)
+			if err != nil {
+				return err
+			}
+			for _, rule := range rules {
+				for _, comment := range rule.Comments {
+					name, ok := strings.CutPrefix(comment, prefix)
+					if !ok {
+						continue
+					}
+					val := res[name]
+					add(&val, rule)
+					res[name] = val
+				}
+			}
+		}
+		return nil
+	}
+	err := read(p.mangleTables, "EGRESSGATEWAY-MARK-REQUEST", markRuleCommentPrefix, func(val *ruleCounters, rule iptables.RuleCounters) {
+		val.HasMark = true
+		val.MarkPackets += rule.Packets
+		val.MarkBytes += rule.Bytes
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = read(p.natTables, "EGRESSGATEWAY-SNAT-EIP", snatRuleCommentPrefix, func(val *ruleCounters, rule iptables.RuleCounters) {
+		val.HasSNAT = true
+		val.SNATPackets += rule.Packets
+		val.SNATBytes += rule.Bytes
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = read(p.mangleTables, EgressICMPErrorChain, icmpRuleCommentPrefix, func(val *ruleCounters, rule iptables.RuleCounters) {
+		val.HasICMP = true
+		val.ICMPPackets += rule.Packets
+		val.ICMPBytes += rule.Bytes
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// updateStatus records the counters of the node in the status of the policy, or removes
+// them if the policy has no rule on the node
+func (p *policyCounters) updateStatus(ctx context.Context, policy egressv1.Policy, val ruleCounters, exist bool) error {
+	var obj client.Object
+	var status *egressv1.EgressPolicyStatus
+	if policy.Namespace != "" {
+		egp := new(egressv1.EgressPolicy)
+		obj, status = egp, &egp.Status
+	} else {
+		egcp := new(egressv1.EgressClusterPolicy)
+		obj, status = egcp, &egcp.Status
+	}
+
+	var err error
+	for i := 0; i < 5; i++ {
+		if err = p.client.Get(ctx, types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name}, obj); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if !obj.GetDeletionTimestamp().IsZero() {
+			return nil
+		}
+		var changed bool
+		if exist {
+			changed = egressv1.SetNodePolicyCounters(&status.Counters, egressv1.NodePolicyCounters{
+				Name:            p.nodeName,
+				Packets:         int64(val.MarkPackets),
+				Bytes:           int64(val.MarkBytes),
+				SNATConnections: int64(val.SNATPackets),
+				SampleTime:      metav1.Now(),
+			})
+		} else {
+			changed = egressv1.RemoveNodePolicyCounters(&status.Counters, p.nodeName)
+		}
+		if !changed {
+			return nil
+		}
+		err = p.client.Status().Update(ctx, obj)
+		if err == nil || apierr.IsNotFound(err) {
+			return nil
+		}
+		if !apierr.IsConflict(err) {
+			return err
+		}
+	}
+	return err
+}
+
+var (
+	descRulePackets = prometheus.NewDesc("egress_policy_rule_packets_total",
+		"The number of packets matched by the rule of the policy on the node, the rule is mark, snat or icmp",
+		[]string{"namespace", "policy", "rule"}, nil)
+	descRuleBytes = prometheus.NewDesc("egress_policy_rule_bytes_total",
+		"The number of bytes matched by the rule of the policy on the node, the rule is mark, snat or icmp",
+		[]string{"namespace", "policy", "rule"}, nil)
+)
+
+func (p *policyCounters) Describe(ch chan<- *prometheus.Desc) {
+	ch <- descRulePackets
+	ch <- descRuleBytes
+}
+
+// Collect exports the counters of the last sample
+func (p *policyCounters) Collect(ch chan<- prometheus.Metric) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for policy, val := range p.last {
+		for _, item := range []struct {
+			rule           string
+			exist          bool
+			packets, bytes uint64
+		}{
+			{"mark", val.HasMark, val.MarkPackets, val.MarkBytes},
+			{"snat", val.HasSNAT, val.SNATPackets, val.SNATBytes},
+			{"icmp", val.HasICMP, val.ICMPPackets, val.ICMPBytes},
+		} {
+			if !item.exist {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(descRulePackets, prometheus.CounterValue,
+				float64(item.packets), policy.Namespace, policy.Name, item.rule)
+			ch <- prometheus.MustNewConstMetric(descRuleBytes, prometheus.CounterValue,
+				float64(item.bytes), policy.Namespace, policy.Name, item.rule)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+type fakeCounterReader map[string][]iptables.RuleCounters
+
+func (f fakeCounterReader) ReadCounters(chainName string) ([]iptables.RuleCounters, error) {
+	return f[chainName], nil
+}
+
+func TestPolicyCounters(t *testing.T) {
+	ctx := context.Background()
+	egp := &egressv1.EgressPolicy{ObjectMeta: metav1.ObjectMeta{Name: "policy1", Namespace: "default"}}
+	egcp := &egressv1.EgressClusterPolicy{ObjectMeta: metav1.ObjectMeta{Name: "policy2"}}
+	idle := &egressv1.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "idle", Namespace: "default"},
+		Status: egressv1.EgressPolicyStatus{Counters: []egressv1.NodePolicyCounters{
+			{Name: "node1", Packets: 1}, {Name: "node2", Packets: 2},
+		}},
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(egp, egcp, idle).
+		WithStatusSubresource(egp, egcp, idle).
+		Build()
+
+	mangleV4 := fakeCounterReader{"EGRESSGATEWAY-MARK-REQUEST": {
+		{Comments: []string{"egw:hash1", markRuleCommentPrefix + "default-policy1"}, Packets: 10, Bytes: 1000},
+	}, EgressICMPErrorChain: {
+		{Comments: []string{"egw:hash4", icmpRuleCommentPrefix + "policy2"}, Packets: 2, Bytes: 1152},
+	}}
+	mangleV6 := fakeCounterReader{"EGRESSGATEWAY-MARK-REQUEST": {
+		{Comments: []string{"egw:hash2", markRuleCommentPrefix + "default-policy1"}, Packets: 5, Bytes: 600},
+	}}
+	nat := fakeCounterReader{"EGRESSGATEWAY-SNAT-EIP": {
+		{Comments: []string{"egw:hash3", snatRuleCommentPrefix + "policy2"}, Packets: 3, Bytes: 180},
+	}}
+	p := &policyCounters{
+		client:       cli,
+		log:          logr.Discard(),
+		nodeName:     "node1",
+		mangleTables: []counterReader{mangleV4, mangleV6},
+		natTables:    []counterReader{nat},
+	}
+	assert.NoError(t, p.sample(ctx))
+
+	// the counters of IPv4 and IPv6 are summed up
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(egp), egp))
+	assert.Len(t, egp.Status.Counters, 1)
+	assert.Equal(t, "node1", egp.Status.Counters[0].Name)
+	assert.Equal(t, int64(15), egp.Status.Counters[0].Packets)
+	assert.Equal(t, int64(1600), egp.Status.Counters[0].Bytes)
+	assert.False(t, egp.Status.Counters[0].SampleTime.IsZero())
+
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(egcp), egcp))
+	assert.Len(t, egcp.Status.Counters, 1)
+	assert.Equal(t, int64(3), egcp.Status.Counters[0].SNATConnections)
+
+	// the counters of the node are removed without the rules
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(idle), idle))
+	assert.Equal(t, []egressv1.NodePolicyCounters{{Name: "node2", Packets: 2}}, idle.Status.Counters)
+
+	// the unchanged counters are not updated
+	version := egp.ResourceVersion
+	assert.NoError(t, p.sample(ctx))
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(egp), egp))
+	assert.Equal(t, version, egp.ResourceVersion)
+
+	// only the rules on the node are exported
+	registry := prometheus.NewRegistry()
+	assert.NoError(t, registry.Register(p))
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	assert.Len(t, families, 2)
+	for _, family := range families {
+		assert.Len(t, family.Metric, 3)
+		for _, metric := range family.Metric {
+			labels := make(map[string]string)
+			for _, label := range metric.Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["policy"] == "policy1" {
+				assert.Equal(t, "mark", labels["rule"])
+			} else {
+				assert.Contains(t, []string{"snat", "icmp"}, labels["rule"])
+			}
+			if labels["rule"] == "icmp" && family.GetName() == "egress_policy_rule_packets_total" {
+				assert.Equal(t, float64(2), metric.GetCounter().GetValue())
+			}
+		}
+	}
+}
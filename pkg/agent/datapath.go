@@ -0,0 +1,39 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+)
+
+const (
+	// datapathVersion is bumped whenever the rules or routes created by the agent
+	// change in a way that the rules of the previous version must be rewritten
+	datapathVersion = 2
+
+	// chainPrefix is the prefix of the iptables chains owned by the agent, the
+	// chains with the prefix which are not used anymore are deleted
+	chainPrefix = "EGRESSGATEWAY-"
+)
+
+// hashPrefix returns the prefix of the comment carrying the hash of the rules
+// created by the datapath of the version, e.g. "egw:v2:<hash>"
+func hashPrefix(version int) string {
+	if version <= 1 {
+		// the agents before the datapath versioning don't have the version
+		return "egw:"
+	}
+	return fmt.Sprintf("egw:v%d:", version)
+}
+
+// historicHashPrefixes returns the hash prefixes of the rules created by the older
+// datapath versions, so the rules left by them are cleaned up after the upgrade
+func historicHashPrefixes() []string {
+	// the ip6tables mangle table of the version 1 uses the "egw:-" prefix
+	res := []string{"egw:-"}
+	for version := 1; version < datapathVersion; version++ {
+		res = append(res, hashPrefix(version))
+	}
+	return res
+}
@@ -0,0 +1,26 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// getPolicyClusterDefault returns whether the policy is a cluster default policy, which is
+// evaluated at a lower priority than the other policies
+func (r *policeReconciler) getPolicyClusterDefault(ns, name string) (bool, error) {
+	if ns != "" {
+		return false, nil
+	}
+	obj := new(egressv1.EgressClusterPolicy)
+	if err := r.client.Get(context.Background(), types.NamespacedName{Name: name}, obj); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return obj.Spec.ClusterDefault, nil
+}
@@ -0,0 +1,42 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestGetPolicyClusterDefault(t *testing.T) {
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(
+			&egressv1.EgressClusterPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "default"},
+				Spec:       egressv1.EgressClusterPolicySpec{ClusterDefault: true},
+			},
+			&egressv1.EgressClusterPolicy{ObjectMeta: metav1.ObjectMeta{Name: "explicit"}},
+		).Build()
+	r := &policeReconciler{client: cli}
+
+	ok, err := r.getPolicyClusterDefault("", "default")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = r.getPolicyClusterDefault("", "explicit")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = r.getPolicyClusterDefault("", "deleted")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	// the EgressPolicy is never a cluster default policy
+	ok, err = r.getPolicyClusterDefault("default", "default")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
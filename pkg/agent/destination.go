@@ -0,0 +1,183 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/ipset"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// destinationIPSetPrefix is the prefix of the ipsets of the destination groups of the policies
+const destinationIPSetPrefix = "egress-gdst-"
+
+// DestinationEIP is a destination group of the policy placed on the node, the traffic to
+// its destinations is SNATed with its EIP
+type DestinationEIP struct {
+	Name       string
+	DestSubnet []string
+	IP         IP
+}
+
+func (r *policeReconciler) getPolicyDestinationEIPs(ns, name string) ([]egressv1.DestinationEIP, error) {
+	key := types.NamespacedName{Namespace: ns, Name: name}
+	if ns != "" {
+		obj := new(egressv1.EgressPolicy)
+		if err := r.client.Get(context.Background(), key, obj); err != nil {
+			return nil, client.IgnoreNotFound(err)
+		}
+		return obj.Spec.DestinationEIPs, nil
+	}
+	obj := new(egressv1.EgressClusterPolicy)
+	if err := r.client.Get(context.Background(), key, obj); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return obj.Spec.DestinationEIPs, nil
+}
+
+// buildPolicyDestinations returns the destination groups of the policy whose EIPs are placed
+// on the node by the controller
+func buildPolicyDestinations(groups []egressv1.DestinationEIP, placed map[string]IP) []DestinationEIP {
+	res := make([]DestinationEIP, 0, len(placed))
+	for _, group := range groups {
+		ip, ok := placed[group.Name]
+		if !ok {
+			continue
+		}
+		res = append(res, DestinationEIP{Name: group.Name, DestSubnet: group.DestSubnet, IP: ip})
+	}
+	return res
+}
+
+// buildDestinationIPSetNames returns the destination ipsets of the group of the policy
+func buildDestinationIPSetNames(ns, name, group string, enableIPv4, enableIPv6 bool) SetNames {
+	if ns != "" {
+		name = ns + "-" + name
+	}
+	name = name + "-" + group
+	res := make([]SetName, 0)
+	if enableIPv4 {
+		res = append(res, SetName{Name: formatIPSetName(destinationIPSetPrefix+"v4-", name), Stack: IPv4, Kind: IPDst})
+	}
+	if enableIPv6 {
+		res = append(res, SetName{Name: formatIPSetName(destinationIPSetPrefix+"v6-", name), Stack: IPv6, Kind: IPDst})
+	}
+	return res
+}
+
+// updateDestinationIPSets updates the destinations of the groups of the policy, and returns
+// the names of the ipsets
+func (r *policeReconciler) updateDestinationIPSets(policyNs, policyName string, groups []DestinationEIP) ([]string, error) {
+	names := make([]string, 0)
+	for _, group := range groups {
+		dstIPv4List, dstIPv6List, err := r.getDstCIDR(group.DestSubnet)
+		if err != nil {
+			return nil, err
+		}
+		setNames := buildDestinationIPSetNames(policyNs, policyName, group.Name,
+			r.cfg.FileConfig.EnableIPv4, r.cfg.FileConfig.EnableIPv6)
+		err = setNames.Map(func(set SetName) error {
+			names = append(names, set.Name)
+			newList := dstIPv4List
+			if set.Stack == IPv6 {
+				newList = dstIPv6List
+			}
+			return r.syncIPSetEntries(set, newList)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// syncPolicyDestinations updates the destinations of the groups of the policy applied on the
+// node, the groups are added or removed by the EIPs placed in the status of the gateway
+func (r *policeReconciler) syncPolicyDestinations(policyNs, policyName string, groups []egressv1.DestinationEIP) error {
+	applied := make([]DestinationEIP, 0, len(groups))
+	for _, group := range groups {
+		for _, set := range buildDestinationIPSetNames(policyNs, policyName, group.Name, true, true) {
+			if _, ok := r.ipsetMap.Load(set.Name); ok {
+				applied = append(applied, DestinationEIP{Name: group.Name, DestSubnet: group.DestSubnet})
+				break
+			}
+		}
+	}
+	_, err := r.updateDestinationIPSets(policyNs, policyName, applied)
+	return err
+}
+
+// syncIPSetEntries creates the ipset and sets its entries to the list, the entries kept in
+// the ipset are not touched
+func (r *policeReconciler) syncIPSetEntries(set SetName, list []string) error {
+	if err := r.createIPSet(r.log, set); err != nil {
+		return err
+	}
+	ipSet, ok := r.ipsetMap.Load(set.Name)
+	if !ok {
+		return nil
+	}
+	oldList, err := r.ipset.ListEntries(set.Name)
+	if err != nil {
+		return err
+	}
+	toAdd, toDel := findDiff(oldList, list)
+	for _, ip := range toAdd {
+		err := r.ipset.AddEntry(ip, ipSet, true)
+		if err != nil && !errors.Is(err, ipset.ErrAlreadyAddedEntry) {
+			return err
+		}
+	}
+	for _, ip := range toDel {
+		if err := r.ipset.DelEntry(ip, set.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forgetDestinationIPSets drops the ipsets of the groups not in the keep, so they're
+// destroyed by the cleanup of the ipsets
+func (r *policeReconciler) forgetDestinationIPSets(keep map[string]bool) {
+	r.ipsetMap.Range(func(name string, _ *ipset.IPSet) bool {
+		if strings.HasPrefix(name, destinationIPSetPrefix) && !keep[name] {
+			r.ipsetMap.Delete(name)
+		}
+		return true
+	})
+}
+
+// buildDestinationEipRules SNATs the traffic from the Pods of the policy to the destinations
+// of each group with the EIP of the group, the rules precede the rule of the policy
+func buildDestinationEipRules(policyName string, groups []DestinationEIP, snat *egressv1.SNAT, version uint8) []iptables.Rule {
+	tmp := "v4-"
+	if version == 6 {
+		tmp = "v6-"
+	}
+	srcName := formatIPSetName("egress-src-"+tmp, policyName)
+	res := make([]iptables.Rule, 0, len(groups))
+	for _, group := range groups {
+		ip := group.IP.V4
+		if version == 6 {
+			ip = group.IP.V6
+		}
+		if ip == "" {
+			continue
+		}
+		dstName := formatIPSetName(destinationIPSetPrefix+tmp, policyName+"-"+group.Name)
+		matchCriteria := iptables.MatchCriteria{}.SourceIPSet(srcName).DestIPSet(dstName).
+			CTDirectionOriginal(iptables.DirectionOriginal)
+		res = append(res, iptables.Rule{Match: matchCriteria, Action: snatAction(ip, snat), Comment: []string{
+			snatRuleCommentPrefix + policyName, "destination " + group.Name,
+		}})
+	}
+	return res
+}
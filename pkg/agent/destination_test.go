@@ -0,0 +1,71 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/ipset"
+	ipsettest "github.com/spidernet-io/egressgateway/pkg/ipset/testing"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+func TestUpdateDestinationIPSets(t *testing.T) {
+	fake := ipsettest.NewFake("v7.1")
+	cfg := &config.Config{}
+	cfg.FileConfig.EnableIPv4 = true
+	r := &policeReconciler{
+		cfg:      cfg,
+		log:      logr.Discard(),
+		ipset:    fake,
+		ipsetMap: utils.NewSyncMap[string, *ipset.IPSet](),
+	}
+	groups := []egressv1.DestinationEIP{
+		{Name: "partner-a", DestSubnet: []string{"1.1.1.0/24", "fd00::/64"}},
+		{Name: "partner-b", DestSubnet: []string{"2.2.2.0/24"}},
+	}
+	setA := buildDestinationIPSetNames("default", "test", "partner-a", true, false)[0]
+	setB := buildDestinationIPSetNames("default", "test", "partner-b", true, false)[0]
+
+	names, err := r.updateDestinationIPSets("default", "test", buildPolicyDestinations(groups, map[string]IP{
+		"partner-a": {V4: "10.6.1.22"},
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{setA.Name}, names)
+	assert.ElementsMatch(t, []string{"1.1.1.0/24"}, fake.Entries[setA.Name].UnsortedList())
+
+	// only the destinations of the applied groups are updated
+	groups[0].DestSubnet = []string{"1.1.2.0/24"}
+	assert.NoError(t, r.syncPolicyDestinations("default", "test", groups))
+	assert.ElementsMatch(t, []string{"1.1.2.0/24"}, fake.Entries[setA.Name].UnsortedList())
+	_, ok := fake.Sets[setB.Name]
+	assert.False(t, ok)
+
+	// the ipsets of the removed groups are forgotten
+	r.forgetDestinationIPSets(map[string]bool{})
+	_, ok = r.ipsetMap.Load(setA.Name)
+	assert.False(t, ok)
+}
+
+func TestBuildDestinationEipRules(t *testing.T) {
+	groups := []DestinationEIP{
+		{Name: "partner-a", IP: IP{V4: "10.6.1.22"}},
+		{Name: "partner-b", IP: IP{V6: "fd00::22"}},
+	}
+	rules := buildDestinationEipRules("default-test", groups, nil, 4)
+	assert.Len(t, rules, 1)
+	assert.Equal(t, iptables.SNATAction{ToAddr: "10.6.1.22"}, rules[0].Action)
+	assert.Contains(t, rules[0].Match.Render(), formatIPSetName("egress-gdst-v4-", "default-test-partner-a"))
+	assert.Contains(t, rules[0].Comment, snatRuleCommentPrefix+"default-test")
+
+	rules = buildDestinationEipRules("default-test", groups, &egressv1.SNAT{Mode: egressv1.SNATModeMasquerade}, 6)
+	assert.Len(t, rules, 1)
+	assert.Equal(t, iptables.MasqAction{}, rules[0].Action)
+}
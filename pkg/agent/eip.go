@@ -7,6 +7,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -18,9 +19,13 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	"github.com/spidernet-io/egressgateway/pkg/bfd"
 	"github.com/spidernet-io/egressgateway/pkg/config"
+	egresserrors "github.com/spidernet-io/egressgateway/pkg/errors"
 	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
 	"github.com/spidernet-io/egressgateway/pkg/layer2"
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+	"github.com/spidernet-io/egressgateway/pkg/privilege"
 )
 
 type eip struct {
@@ -29,6 +34,10 @@ type eip struct {
 	cfg    *config.Config
 
 	announce *layer2.Announce
+	// bfd is nil if BFD is disabled
+	bfd *bfd.Server
+	// conntrack sets the conntrack timeouts of the gateways
+	conntrack *conntrackTuner
 }
 
 func (r *eip) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
@@ -48,41 +57,89 @@ func (r *eip) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.R
 
 	if deleted {
 		r.announce.DeleteBalancer(req.NamespacedName.Name)
-		return reconcile.Result{}, nil
+		return reconcile.Result{}, r.syncNode(ctx)
 	}
 
 	ips := gateway.Status.GetNodeIPs(r.cfg.NodeName)
 	for _, status := range ips {
-		ip := net.ParseIP(status.IPv4)
-		if ip.To4() != nil {
-			adv := layer2.NewIPAdvertisement(ip, true, sets.Set[string]{})
-			r.announce.SetBalancer(gateway.Name, adv)
-		}
-		ip = net.ParseIP(status.IPv6)
-		if ip.To16() != nil {
-			adv := layer2.NewIPAdvertisement(ip, true, sets.Set[string]{})
-			r.announce.SetBalancer(gateway.Name, adv)
+		r.advertise(gateway.Name, status.IPv4, status.IPv6)
+	}
+	// the destination EIPs of the policies are placed on the gateway node of the policies
+	for _, status := range gateway.Status.GetNodeDestinationIPs(r.cfg.NodeName) {
+		r.advertise(gateway.Name, status.IPv4, status.IPv6)
+	}
+
+	return reconcile.Result{}, r.syncNode(ctx)
+}
+
+func (r *eip) advertise(name, ipv4, ipv6 string) {
+	ip := net.ParseIP(ipv4)
+	if ip.To4() != nil {
+		adv := layer2.NewIPAdvertisement(ip, true, sets.Set[string]{})
+		r.announce.SetBalancer(name, adv)
+	}
+	ip = net.ParseIP(ipv6)
+	if ip.To16() != nil {
+		adv := layer2.NewIPAdvertisement(ip, true, sets.Set[string]{})
+		r.announce.SetBalancer(name, adv)
+	}
+}
+
+// syncNode applies the node-wide states of all the gateways of the node: the BFD sessions
+// are activated when the node holds egress IPs of any gateway, and the conntrack timeouts
+// are set by the overrides of the gateways.
+func (r *eip) syncNode(ctx context.Context) error {
+	list := new(egressv1.EgressGatewayList)
+	if err := r.client.List(ctx, list); err != nil {
+		return err
+	}
+
+	if r.bfd != nil {
+		active := false
+		for _, item := range list.Items {
+			if item.DeletionTimestamp.IsZero() && len(item.Status.GetNodeIPs(r.cfg.NodeName)) > 0 {
+				active = true
+				break
+			}
 		}
+		r.bfd.SetActive(active)
 	}
 
-	return reconcile.Result{}, nil
+	return r.conntrack.apply(conntrackTimeouts(list.Items, r.cfg.NodeName))
 }
 
 // newEipCtrl return a new egress ip controller
 func newEipCtrl(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
-	an, err := layer2.New(log, cfg.FileConfig.AnnounceExcludeRegexp)
+	an, err := layer2.New(logger.ForModule(log, logger.ModuleLayer2), cfg.FileConfig.AnnounceExcludeRegexp)
 	if err != nil {
 		return err
 	}
 
 	eip := &eip{
-		cfg:      cfg,
-		log:      log,
-		client:   mgr.GetClient(),
-		announce: an,
+		cfg:       cfg,
+		log:       log,
+		client:    mgr.GetClient(),
+		announce:  an,
+		conntrack: newConntrackTuner(log, privilege.NewSysctlWriter("/proc/sys", cfg.HelperSocket)),
+	}
+
+	if conf := cfg.FileConfig.BFD; conf.Enable {
+		peers := make([]net.IP, 0, len(conf.Peers))
+		for _, peer := range conf.Peers {
+			peers = append(peers, net.ParseIP(peer))
+		}
+		eip.bfd = bfd.New(logger.ForModule(log, logger.ModuleBFD), bfd.Config{
+			Peers:         peers,
+			DesiredMinTx:  time.Millisecond * time.Duration(conf.DesiredMinTxMillis),
+			RequiredMinRx: time.Millisecond * time.Duration(conf.RequiredMinRxMillis),
+			DetectMult:    uint8(conf.DetectMultiplier),
+		})
+		if err := mgr.Add(eip.bfd); err != nil {
+			return err
+		}
 	}
 
-	c, err := controller.New("eip", mgr, controller.Options{Reconciler: eip})
+	c, err := controller.New("eip", mgr, controller.Options{Reconciler: egresserrors.NewReconciler("eip", eip)})
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,95 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// nodeExcluded returns whether the node of the agent is selected by the excludeNodes of
+// a cluster policy
+func (r *policeReconciler) nodeExcluded(ctx context.Context, excludeNodes *metav1.LabelSelector) (bool, error) {
+	if excludeNodes == nil {
+		return false, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(excludeNodes)
+	if err != nil {
+		return false, fmt.Errorf("invalid excludeNodes: %w", err)
+	}
+	node := new(corev1.Node)
+	if err := r.client.Get(ctx, types.NamespacedName{Name: r.cfg.EnvConfig.NodeName}, node); err != nil {
+		return false, fmt.Errorf("failed to get node: %w", err)
+	}
+	return selector.Matches(labels.Set(node.Labels)), nil
+}
+
+// getPolicyExcludesNode returns whether the node of the agent is excluded by the policy,
+// only the cluster policies exclude the nodes
+func (r *policeReconciler) getPolicyExcludesNode(ns, name string) (bool, error) {
+	if ns != "" {
+		return false, nil
+	}
+	obj := new(egressv1.EgressClusterPolicy)
+	if err := r.client.Get(context.Background(), types.NamespacedName{Name: name}, obj); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return r.nodeExcluded(context.Background(), obj.Spec.ExcludeNodes)
+}
+
+// syncExcludedPolicy returns whether the rules should be applied again, as the exclusion
+// of the node by the policy is changed
+func (r *policeReconciler) syncExcludedPolicy(policy egressv1.Policy, excludeNodes *metav1.LabelSelector) (bool, error) {
+	excluded, err := r.nodeExcluded(context.Background(), excludeNodes)
+	if err != nil {
+		return false, err
+	}
+	_, applied := r.excludedPolicies.Load(policy)
+	return applied != excluded, nil
+}
+
+// buildExcludedLocalRule skips the SNAT of the traffic from the Pods of the policy running
+// on its gateway node, which is excluded by the policy, so it egresses with the node IP
+func buildExcludedLocalRule(policyName string, version uint8) iptables.Rule {
+	tmp := "v4-"
+	if version == 6 {
+		tmp = "v6-"
+	}
+	srcName := formatIPSetName(localIPSetPrefix+tmp, policyName)
+	return iptables.Rule{
+		Match:   iptables.MatchCriteria{}.SourceIPSet(srcName),
+		Action:  iptables.ReturnAction{},
+		Comment: []string{"local Pods of " + policyName + " on the excluded node"},
+	}
+}
+
+// reconcileNode applies the rules again as the labels of the node are changed, which may
+// change the exclusions of the cluster policies
+func (r *policeReconciler) reconcileNode(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	return r.applyPolicy(ctx, changeKey("Node", req.NamespacedName), func(context.Context) error { return nil })
+}
+
+// nodeLabelsPredicate only accepts the changes of the labels of the node of the agent
+type nodeLabelsPredicate struct {
+	name string
+}
+
+func (p nodeLabelsPredicate) Create(_ event.CreateEvent) bool { return false }
+func (p nodeLabelsPredicate) Delete(_ event.DeleteEvent) bool { return false }
+func (p nodeLabelsPredicate) Update(e event.UpdateEvent) bool {
+	return e.ObjectNew.GetName() == p.name &&
+		!labels.Equals(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())
+}
+func (p nodeLabelsPredicate) Generic(_ event.GenericEvent) bool { return false }
@@ -0,0 +1,87 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+func TestGetPolicyExcludesNode(t *testing.T) {
+	edge := &metav1.LabelSelector{MatchLabels: map[string]string{"node-role": "edge"}}
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"node-role": "edge"}}},
+			&egressv1.EgressClusterPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "edge"},
+				Spec:       egressv1.EgressClusterPolicySpec{ExcludeNodes: edge},
+			},
+			&egressv1.EgressClusterPolicy{ObjectMeta: metav1.ObjectMeta{Name: "all"}},
+		).Build()
+	cfg := &config.Config{}
+	cfg.EnvConfig.NodeName = "node1"
+	r := &policeReconciler{
+		client:           cli,
+		cfg:              cfg,
+		log:              logr.Discard(),
+		excludedPolicies: utils.NewSyncMap[egressv1.Policy, bool](),
+	}
+
+	excluded, err := r.getPolicyExcludesNode("", "edge")
+	assert.NoError(t, err)
+	assert.True(t, excluded)
+	excluded, err = r.getPolicyExcludesNode("", "all")
+	assert.NoError(t, err)
+	assert.False(t, excluded)
+	excluded, err = r.getPolicyExcludesNode("", "deleted")
+	assert.NoError(t, err)
+	assert.False(t, excluded)
+	// the EgressPolicy never excludes the nodes
+	excluded, err = r.getPolicyExcludesNode("default", "edge")
+	assert.NoError(t, err)
+	assert.False(t, excluded)
+
+	// the rules are applied again once the exclusion is changed
+	policy := egressv1.Policy{Name: "edge"}
+	reapply, err := r.syncExcludedPolicy(policy, edge)
+	assert.NoError(t, err)
+	assert.True(t, reapply)
+	r.excludedPolicies.Store(policy, true)
+	reapply, err = r.syncExcludedPolicy(policy, edge)
+	assert.NoError(t, err)
+	assert.False(t, reapply)
+	reapply, err = r.syncExcludedPolicy(policy, nil)
+	assert.NoError(t, err)
+	assert.True(t, reapply)
+}
+
+func TestBuildExcludedLocalRule(t *testing.T) {
+	rule := buildExcludedLocalRule("edge", 4)
+	assert.Equal(t, "-m set --match-set "+formatIPSetName(localIPSetPrefix+"v4-", "edge")+" src", rule.Match.Render())
+	assert.Equal(t, "--jump RETURN", rule.Action.ToFragment(&iptables.Options{}))
+}
+
+func TestNodeLabelsPredicate(t *testing.T) {
+	p := nodeLabelsPredicate{name: "node1"}
+	node := func(name string, labels map[string]string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	assert.True(t, p.Update(event.UpdateEvent{ObjectOld: node("node1", nil), ObjectNew: node("node1", map[string]string{"a": "b"})}))
+	assert.False(t, p.Update(event.UpdateEvent{ObjectOld: node("node1", map[string]string{"a": "b"}), ObjectNew: node("node1", map[string]string{"a": "b"})}))
+	assert.False(t, p.Update(event.UpdateEvent{ObjectOld: node("node2", nil), ObjectNew: node("node2", map[string]string{"a": "b"})}))
+	assert.False(t, p.Create(event.CreateEvent{Object: node("node1", nil)}))
+}
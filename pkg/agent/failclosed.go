@@ -0,0 +1,81 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+
+	"github.com/go-logr/logr"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
+)
+
+// EgressFailClosedChain drops the traffic of the policies which would egress with the node
+// IP, it's the first chain of FORWARD in the filter table if the fail-closed mode is enabled
+const EgressFailClosedChain = "EGRESSGATEWAY-FAIL-CLOSED"
+
+// tunnelDevices matches the vxlan devices of the dedicated tunnel networks
+const tunnelDevices = "egress.+"
+
+// buildFailClosedRules builds the rules of the fail-closed chain. The traffic marked for the
+// gateway nodes always leaves through the tunnel, it's dropped once the route of the mark
+// is missing. If hold, the traffic from the tunnel is dropped as well, as it egresses with
+// the node IP on the gateway node until the SNAT rules of the policies are applied.
+func buildFailClosedRules(vxlanName string, space markallocator.Space, hold bool) []iptables.Rule {
+	rules := []iptables.Rule{
+		{
+			Match:  iptables.MatchCriteria{}.OutInterface(vxlanName),
+			Action: iptables.ReturnAction{},
+		},
+		{
+			Match:  iptables.MatchCriteria{}.OutInterface(tunnelDevices),
+			Action: iptables.ReturnAction{},
+		},
+		{
+			Match:   iptables.MatchCriteria{}.MarkMatchesWithMask(space.Base, space.GroupMask()),
+			Action:  iptables.DropAction{},
+			Comment: []string{"Drop the EgressPolicy traffic without the route to the gateway node"},
+		},
+	}
+	if !hold {
+		return rules
+	}
+	return append(rules,
+		iptables.Rule{
+			Match:   iptables.MatchCriteria{}.InInterface(vxlanName),
+			Action:  iptables.DropAction{},
+			Comment: []string{"Hold the EgressPolicy traffic until the rules of the policies are applied"},
+		},
+		iptables.Rule{
+			Match:   iptables.MatchCriteria{}.InInterface(tunnelDevices),
+			Action:  iptables.DropAction{},
+			Comment: []string{"Hold the EgressPolicy traffic until the rules of the policies are applied"},
+		},
+	)
+}
+
+func failClosedJumpRule() iptables.Rule {
+	return iptables.Rule{
+		Match:   iptables.MatchCriteria{},
+		Action:  iptables.JumpAction{Target: EgressFailClosedChain},
+		Comment: []string{"Drop the EgressPolicy traffic egressing with the node IP"},
+	}
+}
+
+// restoreFailClosed applies the fail-closed chain holding the traffic of the policies to
+// the filter tables, before any other activity of the agent as it starts. The chain stays
+// in the datapath as the agent restarts, and the hold is released by the first apply of
+// the rules of the policies.
+func restoreFailClosed(filterTables []*iptables.Table, vxlanName string, space markallocator.Space, log logr.Logger) error {
+	for _, table := range filterTables {
+		table.UpdateChain(&iptables.Chain{Name: EgressFailClosedChain, Rules: buildFailClosedRules(vxlanName, space, true)})
+		table.InsertOrAppendRules("FORWARD", []iptables.Rule{failClosedJumpRule()})
+		if _, err := table.Apply(); err != nil {
+			return fmt.Errorf("failed to restore the fail-closed rules of %s: %w", table.Name, err)
+		}
+	}
+	log.Info("hold the traffic of the policies until the rules of the policies are applied")
+	return nil
+}
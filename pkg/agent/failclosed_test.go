@@ -0,0 +1,63 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	"github.com/spidernet-io/egressgateway/pkg/iptables/testutils"
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
+)
+
+func TestBuildFailClosedRules(t *testing.T) {
+	space, err := markallocator.NewSpace("0x26000000", "")
+	assert.NoError(t, err)
+	opt := &iptables.Options{}
+
+	rules := buildFailClosedRules("egress.vxlan", space, false)
+	assert.Len(t, rules, 3)
+	assert.Equal(t, "--out-interface egress.vxlan", rules[0].Match.Render())
+	assert.Equal(t, "--out-interface egress.+", rules[1].Match.Render())
+	assert.Equal(t, "-m mark --mark 0x26000000/0xff000000", rules[2].Match.Render())
+	assert.Equal(t, "--jump DROP", rules[2].Action.ToFragment(opt))
+
+	rules = buildFailClosedRules("egress.vxlan", space, true)
+	assert.Len(t, rules, 5)
+	assert.Equal(t, "--in-interface egress.vxlan", rules[3].Match.Render())
+	assert.Equal(t, "--in-interface egress.+", rules[4].Match.Render())
+}
+
+func TestRestoreFailClosed(t *testing.T) {
+	space, err := markallocator.NewSpace("0x26000000", "")
+	assert.NoError(t, err)
+	dataplane := testutils.NewMockDataplane("filter", map[string][]string{
+		"INPUT":   {},
+		"OUTPUT":  {},
+		"FORWARD": {"-j ACCEPT"},
+	}, "legacy")
+	table, err := iptables.NewTable("filter", 4, "egw:", iptables.Options{
+		XTablesLock:      iptables.DummyLock{},
+		NewCmdOverride:   dataplane.NewCmd,
+		SleepOverride:    dataplane.Sleep,
+		NowOverride:      dataplane.Now,
+		LookPathOverride: func(file string) (string, error) { return file, nil },
+	}, logr.Discard())
+	assert.NoError(t, err)
+
+	assert.NoError(t, restoreFailClosed([]*iptables.Table{table}, "egress.vxlan", space, logr.Discard()))
+	assert.Len(t, dataplane.Chains["FORWARD"], 2)
+	assert.Contains(t, dataplane.Chains["FORWARD"][0], "--jump "+EgressFailClosedChain)
+	assert.Equal(t, "-j ACCEPT", dataplane.Chains["FORWARD"][1])
+	assert.Len(t, dataplane.Chains[EgressFailClosedChain], 5)
+
+	// the hold is released by the rules of the policies
+	table.UpdateChain(&iptables.Chain{Name: EgressFailClosedChain, Rules: buildFailClosedRules("egress.vxlan", space, false)})
+	_, err = table.Apply()
+	assert.NoError(t, err)
+	assert.Len(t, dataplane.Chains[EgressFailClosedChain], 3)
+}
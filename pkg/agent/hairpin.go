@@ -0,0 +1,130 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"sort"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// EgressHairpinChain handles the connections from the cluster to the EIPs, it's in the
+// filter table in the Reject mode, and in the nat table in the DNAT mode
+const EgressHairpinChain = "EGRESSGATEWAY-HAIRPIN"
+
+const (
+	// the EIPs of all the gateways, which are the destinations of the hairpin connections
+	hairpinIPSetV4 = "egress-eip-v4"
+	hairpinIPSetV6 = "egress-eip-v6"
+)
+
+func buildHairpinIPSetNames(enableIPv4, enableIPv6 bool) SetNames {
+	res := make([]SetName, 0)
+	if enableIPv4 {
+		res = append(res, SetName{Name: hairpinIPSetV4, Stack: IPv4, Kind: IPDst})
+	}
+	if enableIPv6 {
+		res = append(res, SetName{Name: hairpinIPSetV6, Stack: IPv6, Kind: IPDst})
+	}
+	return res
+}
+
+// hairpinEIPs returns the EIPs of the gateways on all nodes, including the EIPs of the
+// destination groups
+func hairpinEIPs(gateways []egressv1.EgressGateway) (ipv4, ipv6 []string) {
+	v4, v6 := make(map[string]struct{}), make(map[string]struct{})
+	add := func(ipv4, ipv6 string) {
+		if ipv4 != "" {
+			v4[ipv4] = struct{}{}
+		}
+		if ipv6 != "" {
+			v6[ipv6] = struct{}{}
+		}
+	}
+	for _, gateway := range gateways {
+		for _, node := range gateway.Status.NodeList {
+			for _, eip := range node.Eips {
+				add(eip.IPv4, eip.IPv6)
+			}
+			for _, item := range node.DestinationEips {
+				add(item.IPv4, item.IPv6)
+			}
+		}
+	}
+	keys := func(m map[string]struct{}) []string {
+		res := make([]string, 0, len(m))
+		for key := range m {
+			res = append(res, key)
+		}
+		sort.Strings(res)
+		return res
+	}
+	return keys(v4), keys(v6)
+}
+
+// updateHairpinIPSet syncs the EIPs of the gateways to the ipsets of the hairpin
+func (r *policeReconciler) updateHairpinIPSet(gateways []egressv1.EgressGateway) error {
+	ipv4, ipv6 := hairpinEIPs(gateways)
+	setNames := buildHairpinIPSetNames(r.cfg.FileConfig.EnableIPv4, r.cfg.FileConfig.EnableIPv6)
+	return setNames.Map(func(set SetName) error {
+		if set.Stack == IPv6 {
+			return r.syncIPSetEntries(set, ipv6)
+		}
+		return r.syncIPSetEntries(set, ipv4)
+	})
+}
+
+// buildHairpinSkipRule skips marking the connections to the EIPs, so they're not forwarded
+// to the gateway node to be SNATed with the EIPs they're sent to
+func buildHairpinSkipRule(version uint8) iptables.Rule {
+	set := hairpinIPSetV4
+	if version == 6 {
+		set = hairpinIPSetV6
+	}
+	return iptables.Rule{
+		Match:   iptables.MatchCriteria{}.DestIPSet(set),
+		Action:  iptables.ReturnAction{},
+		Comment: []string{"Skip the hairpin traffic to the EIPs"},
+	}
+}
+
+// buildHairpinRules rejects or translates the connections from the cluster to the EIPs by
+// the mode, it returns nil if the mode has no rule of the table
+func buildHairpinRules(hairpin config.Hairpin, table string, version uint8) []iptables.Rule {
+	set, cluster, target := hairpinIPSetV4, EgressClusterCIDRIPv4, hairpin.DNATTargetIPv4
+	if version == 6 {
+		set, cluster, target = hairpinIPSetV6, EgressClusterCIDRIPv6, hairpin.DNATTargetIPv6
+	}
+	match := iptables.MatchCriteria{}.SourceIPSet(cluster).DestIPSet(set)
+	switch {
+	case hairpin.Mode == config.HairpinReject && table == "filter":
+		return []iptables.Rule{{
+			Match:   match,
+			Action:  iptables.RejectAction{},
+			Comment: []string{"Reject the hairpin traffic to the EIPs"},
+		}}
+	case hairpin.Mode == config.HairpinDNAT && table == "nat" && target != "":
+		return []iptables.Rule{{
+			Match:   match,
+			Action:  iptables.DNATAction{DestAddr: target},
+			Comment: []string{"DNAT the hairpin traffic to the EIPs"},
+		}}
+	}
+	return nil
+}
+
+func hairpinJumpRule() iptables.Rule {
+	return iptables.Rule{
+		Match:   iptables.MatchCriteria{},
+		Action:  iptables.JumpAction{Target: EgressHairpinChain},
+		Comment: []string{"Handle the hairpin traffic from the cluster to the EIPs"},
+	}
+}
+
+// hairpinEnabled returns true if the connections to the EIPs are rejected or translated
+func hairpinEnabled(hairpin config.Hairpin) bool {
+	return hairpin.Mode == config.HairpinReject || hairpin.Mode == config.HairpinDNAT
+}
@@ -0,0 +1,51 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestHairpinEIPs(t *testing.T) {
+	gateways := []egressv1.EgressGateway{{
+		Status: egressv1.EgressGatewayStatus{NodeList: []egressv1.EgressIPStatus{{
+			Name: "node1",
+			Eips: []egressv1.Eips{{IPv4: "10.6.1.101", IPv6: "fd00::101"}, {IPv4: "10.6.1.100"}},
+		}, {
+			Name:            "node2",
+			Eips:            []egressv1.Eips{{IPv4: "10.6.1.100"}},
+			DestinationEips: []egressv1.DestinationEips{{IPv4: "10.6.1.200"}},
+		}}},
+	}}
+	ipv4, ipv6 := hairpinEIPs(gateways)
+	assert.Equal(t, []string{"10.6.1.100", "10.6.1.101", "10.6.1.200"}, ipv4)
+	assert.Equal(t, []string{"fd00::101"}, ipv6)
+}
+
+func TestBuildHairpinRules(t *testing.T) {
+	reject := config.Hairpin{Mode: config.HairpinReject}
+	rules := buildHairpinRules(reject, "filter", 4)
+	assert.Len(t, rules, 1)
+	assert.Equal(t, "-m set --match-set "+EgressClusterCIDRIPv4+" src -m set --match-set "+hairpinIPSetV4+" dst",
+		rules[0].Match.Render())
+	assert.Equal(t, iptables.RejectAction{}, rules[0].Action)
+	assert.Empty(t, buildHairpinRules(reject, "nat", 4))
+
+	dnat := config.Hairpin{Mode: config.HairpinDNAT, DNATTargetIPv4: "10.6.0.10"}
+	rules = buildHairpinRules(dnat, "nat", 4)
+	assert.Len(t, rules, 1)
+	assert.Equal(t, iptables.DNATAction{DestAddr: "10.6.0.10"}, rules[0].Action)
+	assert.Empty(t, buildHairpinRules(dnat, "filter", 4))
+	// the family without the target is left as it is
+	assert.Empty(t, buildHairpinRules(dnat, "nat", 6))
+
+	assert.Empty(t, buildHairpinRules(config.Hairpin{Mode: config.HairpinDisabled}, "filter", 4))
+	assert.Equal(t, "-m set --match-set "+hairpinIPSetV6+" dst", buildHairpinSkipRule(6).Match.Render())
+}
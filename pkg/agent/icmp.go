@@ -0,0 +1,35 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+)
+
+// EgressICMPErrorChain counts the ICMP errors of the flows of the policies on the gateway
+// node, e.g. the "fragmentation needed" of the upstream, which are translated back to the
+// Pods by conntrack as the RELATED packets of the SNATed flows
+const EgressICMPErrorChain = "EGRESSGATEWAY-ICMP-ERROR"
+
+// icmpRuleCommentPrefix is the comment of the rules counting the ICMP errors, followed by
+// the name of the policy
+const icmpRuleCommentPrefix = "icmp error policy "
+
+// buildICMPErrorRule counts the ICMP errors forwarded to the Pods of the policy, the
+// destination of the errors is already translated from the EIP to the Pod
+func buildICMPErrorRule(policyName string, version uint8) iptables.Rule {
+	tmp := "v4-"
+	protocol := "icmp"
+	if version == 6 {
+		tmp = "v6-"
+		protocol = "ipv6-icmp"
+	}
+	srcName := formatIPSetName("egress-src-"+tmp, policyName)
+
+	matchCriteria := iptables.MatchCriteria{}.Protocol(protocol).DestIPSet(srcName).
+		ConntrackState("RELATED").CTDirectionOriginal(iptables.DirectionReply)
+	return iptables.Rule{Match: matchCriteria, Comment: []string{
+		icmpRuleCommentPrefix + policyName,
+	}}
+}
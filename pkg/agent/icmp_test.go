@@ -0,0 +1,41 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
+)
+
+func TestBuildICMPErrorRule(t *testing.T) {
+	rule := buildICMPErrorRule("default-policy1", 4)
+	assert.Equal(t, "-p icmp -m set --match-set "+formatIPSetName("egress-src-v4-", "default-policy1")+
+		" dst -m conntrack --ctstate RELATED -m conntrack --ctdir REPLY", rule.Match.Render())
+	assert.Nil(t, rule.Action)
+	assert.Equal(t, []string{icmpRuleCommentPrefix + "default-policy1"}, rule.Comment)
+
+	rule = buildICMPErrorRule("policy2", 6)
+	assert.Contains(t, rule.Match.Render(), "-p ipv6-icmp -m set --match-set "+formatIPSetName("egress-src-v6-", "policy2")+" dst")
+}
+
+func TestReplyRoutingRestoresRelated(t *testing.T) {
+	// the ICMP errors of the connections are RELATED, they follow the connections back to the tunnel
+	rules := buildPreroutingReplyRouting("egress.vxlan", 0x27)
+	restore := rules[len(rules)-1]
+	assert.Equal(t, iptables.RestoreConnMarkAction{RestoreMask: 0}, restore.Action)
+	assert.Equal(t, "-m conntrack --ctstate ESTABLISHED,RELATED", restore.Match.Render())
+
+	space, err := markallocator.NewSpace("0x26000000", "")
+	assert.NoError(t, err)
+	forward := buildMangleStaticRule(space, true, true, 0x27)["FORWARD"]
+	assert.Contains(t, forward, iptables.Rule{
+		Match:   iptables.MatchCriteria{},
+		Action:  iptables.JumpAction{Target: EgressICMPErrorChain},
+		Comment: []string{"Counting the ICMP errors translated back to the pods of EgressPolicy"},
+	})
+}
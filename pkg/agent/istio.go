@@ -0,0 +1,56 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+)
+
+// buildIstioSkipRules returns from the chain of the policies for the traffic between the Istio
+// proxies, and the connections redirected to the sidecars, they precede the rules of the
+// policies. The chain is jumped from PREROUTING and OUTPUT, so the rules match in both.
+func buildIstioSkipRules(conf config.Istio) []iptables.Rule {
+	if !conf.Enable {
+		return nil
+	}
+	rules := make([]iptables.Rule, 0, 2)
+	if len(conf.MeshPorts) != 0 {
+		ports := make([]uint16, 0, len(conf.MeshPorts))
+		for _, port := range conf.MeshPorts {
+			ports = append(ports, uint16(port))
+		}
+		rules = append(rules, iptables.Rule{
+			Match:   iptables.MatchCriteria{}.Protocol("tcp").DestPorts(ports...),
+			Action:  iptables.ReturnAction{},
+			Comment: []string{"Skip the mesh traffic of the Istio proxies"},
+		})
+	}
+	if conf.RedirectPort != 0 {
+		rules = append(rules, iptables.Rule{
+			Match:   iptables.MatchCriteria{}.Protocol("tcp").CTReplySrcPort(uint16(conf.RedirectPort)),
+			Action:  iptables.ReturnAction{},
+			Comment: []string{"Skip the connections redirected to the Istio sidecars"},
+		})
+	}
+	return rules
+}
+
+// buildIstioOutputRules returns the rules appended to the mangle OUTPUT, which follow the
+// jump to ISTIO_OUTPUT. Only the packets from the addresses not of the node are marked, which
+// are the ones the node proxies originate from the Pod IPs, the traffic of the node and the
+// host network Pods is never forwarded by the policies. No rules are returned if the Istio
+// compatibility is disabled, so the stale ones are removed.
+func buildIstioOutputRules(conf config.Istio, markChain string) []iptables.Rule {
+	if !conf.Enable {
+		return nil
+	}
+	return []iptables.Rule{
+		{
+			Match:   iptables.MatchCriteria{}.NotSrcAddrType(iptables.AddrTypeLocal, false),
+			Action:  iptables.JumpAction{Target: markChain},
+			Comment: []string{"Mark the connections of the Istio proxies from the Pod IPs"},
+		},
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+)
+
+func TestBuildIstioRules(t *testing.T) {
+	conf := config.Istio{MeshPorts: []int{15008}, RedirectPort: 15001}
+	assert.Nil(t, buildIstioSkipRules(conf))
+	assert.Nil(t, buildIstioOutputRules(conf, "EGRESSGATEWAY-MARK-REQUEST"))
+
+	conf.Enable = true
+	rules := buildIstioSkipRules(conf)
+	assert.Len(t, rules, 2)
+	assert.Equal(t, "-p tcp -m multiport --destination-ports 15008", rules[0].Match.Render())
+	assert.Equal(t, "-p tcp -m conntrack --ctreplsrcport 15001", rules[1].Match.Render())
+	assert.Equal(t, iptables.ReturnAction{}, rules[1].Action)
+
+	// the redirected connections are marked unless the redirect port is set
+	conf.RedirectPort = 0
+	assert.Len(t, buildIstioSkipRules(conf), 1)
+
+	rules = buildIstioOutputRules(conf, "EGRESSGATEWAY-MARK-REQUEST")
+	assert.Len(t, rules, 1)
+	assert.Equal(t, "-m addrtype ! --src-type LOCAL", rules[0].Match.Render())
+	assert.Equal(t, iptables.JumpAction{Target: "EGRESSGATEWAY-MARK-REQUEST"}, rules[0].Action)
+}
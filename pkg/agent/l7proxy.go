@@ -0,0 +1,494 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/exp/slices"
+	"golang.org/x/sys/unix"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/route"
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/l7proxy"
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+// l7ProxyChain of the mangle table diverts the connections of the proxied policies to the
+// proxy, and the replies to the proxy from the targets
+const l7ProxyChain = "EGRESSGATEWAY-L7-PROXY"
+
+// l7Route is the proxied connections of a policy, the proxy connects to the targets from
+// the EIPs of the policy
+type l7Route struct {
+	Policy egressv1.Policy
+	Ports  []int32
+	EIPv4  string
+	EIPv6  string
+}
+
+// l7ProxyMark returns the mark of the diverted connections, it's 0 if the proxy is disabled
+func (r *policeReconciler) l7ProxyMark() uint32 {
+	conf := r.cfg.FileConfig.L7Proxy
+	if !conf.Enable {
+		return 0
+	}
+	mark, err := markallocator.Parse(conf.Mark)
+	if err != nil {
+		return 0
+	}
+	return uint32(mark)
+}
+
+func (r *policeReconciler) getPolicyL7Proxy(ns, name string) (*egressv1.L7Proxy, error) {
+	key := types.NamespacedName{Namespace: ns, Name: name}
+	if ns != "" {
+		obj := new(egressv1.EgressPolicy)
+		if err := r.client.Get(context.Background(), key, obj); err != nil {
+			return nil, client.IgnoreNotFound(err)
+		}
+		return obj.Spec.L7Proxy, nil
+	}
+	obj := new(egressv1.EgressClusterPolicy)
+	if err := r.client.Get(context.Background(), key, obj); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return obj.Spec.L7Proxy, nil
+}
+
+// syncL7Policy returns whether the rules should be applied again, as the proxied ports of the
+// policy SNATed on the node are changed
+func (r *policeReconciler) syncL7Policy(policy egressv1.Policy, spec *egressv1.L7Proxy) bool {
+	if !r.cfg.FileConfig.L7Proxy.Enable {
+		return false
+	}
+	applied, ok := r.l7Routes.Load(policy)
+	if spec == nil || len(spec.Ports) == 0 {
+		return ok
+	}
+	return !ok || !slices.Equal(applied.Ports, spec.Ports)
+}
+
+// buildL7Routes returns the routes of the proxied policies with EIPs
+func buildL7Routes(policies map[egressv1.Policy]*PolicyCommon) map[egressv1.Policy]l7Route {
+	routes := make(map[egressv1.Policy]l7Route)
+	for policy, val := range policies {
+		if val.L7Proxy == nil || len(val.L7Proxy.Ports) == 0 {
+			continue
+		}
+		if val.IP.V4 == "" && val.IP.V6 == "" {
+			continue
+		}
+		routes[policy] = l7Route{Policy: policy, Ports: val.L7Proxy.Ports, EIPv4: val.IP.V4, EIPv6: val.IP.V6}
+	}
+	return routes
+}
+
+// buildL7ProxySocketRules routes the packets of the transparent sockets of the proxy to the
+// local host by the mark, they're the diverted connections of the Pods and the replies to
+// the EIPs from the targets
+func buildL7ProxySocketRules(mark uint32) []iptables.Rule {
+	return []iptables.Rule{
+		{
+			Match:   iptables.MatchCriteria{}.Protocol("tcp").SocketTransparent(),
+			Action:  iptables.SetMaskedMarkAction{Mark: mark, Mask: 0xffffffff},
+			Comment: []string{"Mark the packets of the transparent sockets of the L7 proxy"},
+		},
+		{
+			Match:   iptables.MatchCriteria{}.MarkMatchesWithMask(mark, 0xffffffff),
+			Action:  iptables.AcceptAction{},
+			Comment: []string{"Accept the packets of the transparent sockets of the L7 proxy"},
+		},
+	}
+}
+
+// buildL7ProxyRule diverts the new TCP connections of the policy to the proxied ports to
+// the proxy, which has the EIP of the IP version
+func buildL7ProxyRule(policyName string, route l7Route, proxyPort uint16, mark uint32, version uint8, isIgnoreInternalCIDR bool) *iptables.Rule {
+	tmp := "v4-"
+	eip := route.EIPv4
+	ignoreName := EgressClusterCIDRIPv4
+	if version == 6 {
+		tmp = "v6-"
+		eip = route.EIPv6
+		ignoreName = EgressClusterCIDRIPv6
+	}
+	if eip == "" {
+		return nil
+	}
+	srcName := formatIPSetName("egress-src-"+tmp, policyName)
+	dstName := formatIPSetName("egress-dst-"+tmp, policyName)
+
+	ports := make([]uint16, 0, len(route.Ports))
+	for _, port := range route.Ports {
+		ports = append(ports, uint16(port))
+	}
+	match := iptables.MatchCriteria{}.Protocol("tcp").SourceIPSet(srcName)
+	if isIgnoreInternalCIDR {
+		match = match.NotDestIPSet(ignoreName)
+	} else {
+		match = match.DestIPSet(dstName)
+	}
+	return &iptables.Rule{
+		Match:   match.DestPorts(ports...),
+		Action:  iptables.TProxyAction{OnPort: proxyPort, Mark: mark, Mask: 0xffffffff},
+		Comment: []string{"Proxy the connections of " + policyName + " by the L7 proxy"},
+	}
+}
+
+func l7ProxyJumpRule() iptables.Rule {
+	return iptables.Rule{
+		Match:   iptables.MatchCriteria{},
+		Action:  iptables.JumpAction{Target: l7ProxyChain},
+		Comment: []string{"Divert the connections of the policies to the L7 proxy"},
+	}
+}
+
+// transparentControl allows the sockets to accept the diverted connections and to bind the
+// EIPs not assigned to the node
+func transparentControl(network, _ string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		if strings.HasSuffix(network, "6") {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+			return
+		}
+		err = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// ensureL7ProxyRoute routes the packets with the mark to the local host by the table
+func ensureL7ProxyRoute(families []int, table, mark int, log logr.Logger) error {
+	lo, err := netlink.LinkByName("lo")
+	if err != nil {
+		return err
+	}
+	ruleRoute := route.NewRuleRoute(log)
+	for _, family := range families {
+		if err := ruleRoute.EnsureRule(family, table, mark, log); err != nil {
+			return err
+		}
+		dst := &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}
+		if family == netlink.FAMILY_V6 {
+			dst = &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+		}
+		err := netlink.RouteReplace(&netlink.Route{
+			LinkIndex: lo.Attrs().Index,
+			Dst:       dst,
+			Type:      unix.RTN_LOCAL,
+			Scope:     unix.RT_SCOPE_HOST,
+			Table:     table,
+			Protocol:  route.Protocol,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add the local route of table %d: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// l7ProxyServer accepts the connections diverted from the Pods of the proxied policies, reads
+// their HTTP or SOCKS5 proxy requests, and connects to the requested targets from the EIPs
+// of the policies. Each request is logged.
+type l7ProxyServer struct {
+	client           client.Client
+	port             int
+	networks         []string
+	routes           *utils.SyncMap[egressv1.Policy, l7Route]
+	handshakeTimeout time.Duration
+	// deniedNets are the targets never connected, besides the loopback, the link-local,
+	// the multicast and the node addresses
+	deniedNets []*net.IPNet
+	log        logr.Logger
+
+	// setup routes the diverted connections to the proxy
+	setup func() error
+	// listen listens on the port for the diverted connections
+	listen func(ctx context.Context, network, addr string) (net.Listener, error)
+	// resolve returns the IPs of the target host
+	resolve func(ctx context.Context, host string) ([]net.IP, error)
+	// isLocal returns whether the IP is an address of the node
+	isLocal func(ip net.IP) bool
+	// dial connects to the target from the EIP
+	dial func(ctx context.Context, eip net.IP, target *net.TCPAddr) (net.Conn, error)
+}
+
+func addL7Proxy(mgr manager.Manager, cfg *config.Config, log logr.Logger, r *policeReconciler) error {
+	conf := cfg.FileConfig.L7Proxy
+	if !conf.Enable {
+		return nil
+	}
+	networks := make([]string, 0, 2)
+	families := make([]int, 0, 2)
+	if cfg.FileConfig.EnableIPv4 {
+		networks = append(networks, "tcp4")
+		families = append(families, netlink.FAMILY_V4)
+	}
+	if cfg.FileConfig.EnableIPv6 {
+		networks = append(networks, "tcp6")
+		families = append(families, netlink.FAMILY_V6)
+	}
+	denied := make([]*net.IPNet, 0)
+	cidrs := slices.Clone(cfg.FileConfig.BypassCIDRs)
+	if cfg.FileConfig.MetadataProtection.Enable {
+		cidrs = append(cidrs, cfg.FileConfig.MetadataProtection.CIDRs...)
+	}
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		denied = append(denied, ipNet)
+	}
+	timeout := time.Second * time.Duration(conf.ConnectTimeoutSecond)
+	return mgr.Add(&l7ProxyServer{
+		client:           mgr.GetClient(),
+		port:             conf.Port,
+		networks:         networks,
+		routes:           r.l7Routes,
+		handshakeTimeout: time.Second * time.Duration(conf.HandshakeTimeoutSecond),
+		deniedNets:       denied,
+		log:              log,
+		setup: func() error {
+			return ensureL7ProxyRoute(families, conf.RouteTable, int(r.l7ProxyMark()), log)
+		},
+		listen: (&net.ListenConfig{Control: transparentControl}).Listen,
+		resolve: func(ctx context.Context, host string) ([]net.IP, error) {
+			return net.DefaultResolver.LookupIP(ctx, "ip", host)
+		},
+		isLocal: isNodeAddr,
+		dial: func(ctx context.Context, eip net.IP, target *net.TCPAddr) (net.Conn, error) {
+			dialer := &net.Dialer{Timeout: timeout, LocalAddr: &net.TCPAddr{IP: eip}, Control: transparentControl}
+			return dialer.DialContext(ctx, "tcp", target.String())
+		},
+	})
+}
+
+func (p *l7ProxyServer) Start(ctx context.Context) error {
+	if err := p.setup(); err != nil {
+		return fmt.Errorf("failed to route the connections to the L7 proxy: %w", err)
+	}
+	listeners := make([]net.Listener, 0, len(p.networks))
+	for _, network := range p.networks {
+		ln, err := p.listen(ctx, network, fmt.Sprintf(":%d", p.port))
+		if err != nil {
+			for _, item := range listeners {
+				_ = item.Close()
+			}
+			return fmt.Errorf("failed to listen the L7 proxy port %d: %w", p.port, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	p.log.Info("start the L7 proxy", "port", p.port)
+
+	wg := sync.WaitGroup{}
+	for _, ln := range listeners {
+		wg.Add(1)
+		go func(ln net.Listener) {
+			defer wg.Done()
+			p.serve(ctx, ln)
+		}(ln)
+	}
+	<-ctx.Done()
+	for _, ln := range listeners {
+		_ = ln.Close()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (p *l7ProxyServer) serve(ctx context.Context, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			p.log.Error(err, "failed to accept the proxied connection")
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go p.proxy(ctx, conn.(*net.TCPConn))
+	}
+}
+
+// proxy serves the request of the connection diverted from the Pod of a proxied policy, the
+// others are closed, such as the connections to the proxy port from elsewhere
+func (p *l7ProxyServer) proxy(ctx context.Context, conn *net.TCPConn) {
+	defer conn.Close()
+	start := time.Now()
+	src := conn.RemoteAddr().(*net.TCPAddr)
+	// the diverted connections keep their destinations
+	dst := conn.LocalAddr().(*net.TCPAddr)
+	route, ok, err := policyOfSource(ctx, p.client, p.routes, src.IP, func(route l7Route) bool {
+		return slices.Contains(route.Ports, int32(dst.Port))
+	})
+	if err != nil {
+		p.log.Error(err, "failed to find the policy of the proxied connection", "src", src.String())
+		return
+	}
+	if !ok || dst.Port == p.port {
+		p.log.V(1).Info("close the connection not from the proxied policies", "src", src.String(), "dst", dst.String())
+		return
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(p.handshakeTimeout))
+	reader := bufio.NewReader(conn)
+	req, err := l7proxy.ReadRequest(reader, conn)
+	if err != nil {
+		p.log.V(1).Info("failed to read the proxy request", "policy", route.Policy, "src", src.String(), "error", err.Error())
+		return
+	}
+	log := p.log.WithValues("policy", route.Policy, "src", src.String(), "protocol", req.Protocol, "target", req.Target)
+	if req.Method != "" {
+		log = log.WithValues("method", req.Method, "url", req.URL)
+	}
+
+	upstream, eip, reply, err := p.connect(ctx, route, req.Target)
+	if err != nil {
+		_ = req.Reject(conn, reply)
+		log.Info("reject the proxy request", "reason", err.Error())
+		return
+	}
+	defer upstream.Close()
+	if err := req.Accept(conn, upstream); err != nil {
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	log = log.WithValues("eip", eip.String(), "upstream", upstream.RemoteAddr().String())
+	log.Info("proxy request")
+	pipe(&bufferedConn{TCPConn: conn, reader: reader}, upstream)
+	log.Info("proxy request done", "duration", time.Since(start).String())
+}
+
+// connect connects to the first IP of the target which the policy has an EIP of the IP
+// version for, the reply tells the client why it fails
+func (p *l7ProxyServer) connect(ctx context.Context, route l7Route, target string) (net.Conn, net.IP, l7proxy.Reply, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, nil, l7proxy.ReplyFailure, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, nil, l7proxy.ReplyFailure, fmt.Errorf("invalid port %q", portStr)
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		ips, err = p.resolve(ctx, host)
+		if err != nil {
+			return nil, nil, l7proxy.ReplyUnreachable, err
+		}
+	}
+	for _, ip := range ips {
+		eip := net.ParseIP(route.EIPv4)
+		if ip.To4() == nil {
+			eip = net.ParseIP(route.EIPv6)
+		}
+		if eip == nil {
+			continue
+		}
+		if p.denied(ip) {
+			return nil, nil, l7proxy.ReplyNotAllowed, fmt.Errorf("the target %s is not allowed", ip)
+		}
+		conn, err := p.dial(ctx, eip, &net.TCPAddr{IP: ip, Port: port})
+		if err != nil {
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				return nil, nil, l7proxy.ReplyRefused, err
+			}
+			return nil, nil, l7proxy.ReplyUnreachable, err
+		}
+		return conn, eip, l7proxy.ReplySucceeded, nil
+	}
+	return nil, nil, l7proxy.ReplyUnreachable, fmt.Errorf("the policy has no EIP of the IP version of %s", host)
+}
+
+// denied returns whether the target is an address of the node, or the address only reachable
+// from the node, such as the metadata services, which the Pods can't reach through the proxy
+func (p *l7ProxyServer) denied(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsUnspecified() || ip.Equal(net.IPv4bcast) {
+		return true
+	}
+	for _, ipNet := range p.deniedNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return p.isLocal != nil && p.isLocal(ip)
+}
+
+// isNodeAddr returns whether the IP is assigned to the node
+func isNodeAddr(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedConn reads the data buffered by the reader of the request first
+type bufferedConn struct {
+	*net.TCPConn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// WriteTo overrides the one of the connection, which io.Copy prefers to Read
+func (c *bufferedConn) WriteTo(w io.Writer) (int64, error) {
+	return c.reader.WriteTo(w)
+}
+
+// policyOfSource returns the value of the policy which the source is of, the values are
+// filtered by match before the sources of their policies are listed
+func policyOfSource[T any](ctx context.Context, cli client.Client, values *utils.SyncMap[egressv1.Policy, T],
+	ip net.IP, match func(T) bool) (T, bool, error) {
+	var res T
+	found := false
+	var err error
+	values.Range(func(policy egressv1.Policy, val T) bool {
+		if !match(val) {
+			return true
+		}
+		ipv4, ipv6, e := listPolicySrcIPs(ctx, cli, policy.Namespace, policy.Name,
+			func(egressv1.EgressEndpoint) bool { return true })
+		if e != nil {
+			err = e
+			return false
+		}
+		if slices.Contains(ipv4, ip.String()) || slices.Contains(ipv6, ip.String()) {
+			res, found = val, true
+			return false
+		}
+		return true
+	})
+	return res, found, err
+}
@@ -0,0 +1,153 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+func TestBuildL7ProxyRules(t *testing.T) {
+	policies := map[egressv1.Policy]*PolicyCommon{
+		{Name: "a", Namespace: "default"}: {IP: IP{V4: "10.6.1.21"}, L7Proxy: &egressv1.L7Proxy{Ports: []int32{3128}}},
+		{Name: "b", Namespace: "default"}: {IP: IP{V4: "10.6.1.22"}},
+		{Name: "c", Namespace: "default"}: {L7Proxy: &egressv1.L7Proxy{Ports: []int32{3128}}},
+	}
+	routes := buildL7Routes(policies)
+	assert.Len(t, routes, 1)
+	route := routes[egressv1.Policy{Name: "a", Namespace: "default"}]
+	assert.Equal(t, "10.6.1.21", route.EIPv4)
+
+	rule := buildL7ProxyRule("default-a", route, 15200, 0x29000000, 4, false)
+	assert.NotNil(t, rule)
+	assert.Equal(t, "-p tcp -m set --match-set "+formatIPSetName("egress-src-v4-", "default-a")+" src "+
+		"-m set --match-set "+formatIPSetName("egress-dst-v4-", "default-a")+" dst "+
+		"-m multiport --destination-ports 3128", rule.Match.Render())
+	assert.Equal(t, "--jump TPROXY --on-port 15200 --tproxy-mark 0x29000000/0xffffffff", rule.Action.ToFragment(&iptables.Options{}))
+	// the policy has no EIP of the IP version
+	assert.Nil(t, buildL7ProxyRule("default-a", route, 15200, 0x29000000, 6, false))
+
+	rules := buildL7ProxySocketRules(0x29000000)
+	assert.Len(t, rules, 2)
+	assert.Equal(t, "-p tcp -m socket --transparent", rules[0].Match.Render())
+	assert.Equal(t, iptables.AcceptAction{}, rules[1].Action)
+}
+
+func TestSyncL7Policy(t *testing.T) {
+	cfg := &config.Config{}
+	r := &policeReconciler{cfg: cfg, l7Routes: utils.NewSyncMap[egressv1.Policy, l7Route]()}
+	policy := egressv1.Policy{Name: "a", Namespace: "default"}
+	spec := &egressv1.L7Proxy{Ports: []int32{3128}}
+
+	assert.False(t, r.syncL7Policy(policy, spec))
+	cfg.FileConfig.L7Proxy.Enable = true
+	assert.True(t, r.syncL7Policy(policy, spec))
+	r.l7Routes.Store(policy, l7Route{Policy: policy, Ports: []int32{3128}})
+	assert.False(t, r.syncL7Policy(policy, spec))
+	assert.True(t, r.syncL7Policy(policy, nil))
+}
+
+func TestL7ProxyServer(t *testing.T) {
+	upstream, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer upstream.Close()
+
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(&egressv1.EgressEndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{Name: "a-abc", Namespace: "default", Labels: map[string]string{egressv1.LabelPolicyName: "a"}},
+			Endpoints:  []egressv1.EgressEndpoint{{Pod: "pod1", Node: "node2", IPv4: []string{"127.0.0.1"}}},
+		}).Build()
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	routes := utils.NewSyncMap[egressv1.Policy, l7Route]()
+	policy := egressv1.Policy{Name: "a", Namespace: "default"}
+	routes.Store(policy, l7Route{Policy: policy, Ports: []int32{int32(ln.Addr().(*net.TCPAddr).Port)}, EIPv4: "10.6.1.21"})
+
+	type dialed struct {
+		eip    net.IP
+		target *net.TCPAddr
+	}
+	dials := make(chan dialed, 2)
+	_, metadata, _ := net.ParseCIDR("169.254.169.254/32")
+	p := &l7ProxyServer{
+		client:           cli,
+		port:             15200,
+		routes:           routes,
+		handshakeTimeout: 5 * time.Second,
+		deniedNets:       []*net.IPNet{metadata},
+		log:              logr.Discard(),
+		resolve: func(ctx context.Context, host string) ([]net.IP, error) {
+			return []net.IP{net.ParseIP("fd00::10"), net.ParseIP("10.6.0.10")}, nil
+		},
+		dial: func(ctx context.Context, eip net.IP, target *net.TCPAddr) (net.Conn, error) {
+			dials <- dialed{eip: eip, target: target}
+			return (&net.Dialer{}).DialContext(ctx, "tcp", upstream.Addr().String())
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.serve(ctx, ln)
+
+	// the HTTP CONNECT request, the targets of the IP versions without EIPs are skipped
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	assert.NoError(t, err)
+	defer client.Close()
+	_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(client, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\nping")
+	assert.NoError(t, err)
+	reader := bufio.NewReader(client)
+	resp, err := http.ReadResponse(reader, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	d := <-dials
+	assert.Equal(t, "10.6.1.21", d.eip.String())
+	assert.Equal(t, "10.6.0.10:443", d.target.String())
+
+	server, err := upstream.Accept()
+	assert.NoError(t, err)
+	defer server.Close()
+	_ = server.SetDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(server, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+	_, err = io.WriteString(server, "pong")
+	assert.NoError(t, err)
+	_, err = io.ReadFull(reader, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "pong", string(buf))
+
+	// the SOCKS5 request to the metadata service is not allowed
+	socks, err := net.Dial("tcp4", ln.Addr().String())
+	assert.NoError(t, err)
+	defer socks.Close()
+	_ = socks.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = socks.Write([]byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x01, 169, 254, 169, 254, 0x00, 0x50})
+	assert.NoError(t, err)
+	reply := make([]byte, 12)
+	_, err = io.ReadFull(socks, reply)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x05, 0x00}, reply[:2])
+	assert.Equal(t, byte(0x02), reply[3])
+	assert.Len(t, dials, 0)
+}
@@ -0,0 +1,96 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"strings"
+
+	"github.com/spidernet-io/egressgateway/pkg/ipset"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// localIPSetPrefix is the prefix of the ipsets of the Pods running on the gateway node of
+// the policies
+const localIPSetPrefix = "egress-lsrc-"
+
+// buildLocalIPSetNames returns the ipsets of the Pods of the policy running on the node
+func buildLocalIPSetNames(ns, name string, enableIPv4, enableIPv6 bool) SetNames {
+	if ns != "" {
+		name = ns + "-" + name
+	}
+	res := make([]SetName, 0)
+	if enableIPv4 {
+		res = append(res, SetName{Name: formatIPSetName(localIPSetPrefix+"v4-", name), Stack: IPv4, Kind: IPSrc})
+	}
+	if enableIPv6 {
+		res = append(res, SetName{Name: formatIPSetName(localIPSetPrefix+"v6-", name), Stack: IPv6, Kind: IPSrc})
+	}
+	return res
+}
+
+// updateLocalIPSet updates the Pods of the policy running on the node, the node is the
+// gateway node of the policy
+func (r *policeReconciler) updateLocalIPSet(policyNs, policyName string, ipv4, ipv6 bool) error {
+	localIPv4List, localIPv6List, err := r.getPolicySrcIPs(policyNs, policyName, func(e egressv1.EgressEndpoint) bool {
+		return e.Pod != "" && e.Node == r.cfg.EnvConfig.NodeName
+	})
+	if err != nil {
+		return err
+	}
+	if !ipv4 {
+		localIPv4List = make([]string, 0)
+	}
+	if !ipv6 {
+		localIPv6List = make([]string, 0)
+	}
+	if len(localIPv4List)+len(localIPv6List) != 0 {
+		r.log.V(1).Info("policy has local Pods on its gateway node", "namespace", policyNs, "name", policyName,
+			"ipv4", localIPv4List, "ipv6", localIPv6List)
+	}
+
+	setNames := buildLocalIPSetNames(policyNs, policyName, r.cfg.FileConfig.EnableIPv4, r.cfg.FileConfig.EnableIPv6)
+	return setNames.Map(func(set SetName) error {
+		list := localIPv4List
+		if set.Stack == IPv6 {
+			list = localIPv6List
+		}
+		return r.syncIPSetEntries(set, list)
+	})
+}
+
+// forgetLocalIPSets drops the ipsets of the policies not in the keep, so they're destroyed
+// by the cleanup of the ipsets
+func (r *policeReconciler) forgetLocalIPSets(keep map[string]bool) {
+	r.ipsetMap.Range(func(name string, _ *ipset.IPSet) bool {
+		if strings.HasPrefix(name, localIPSetPrefix) && !keep[name] {
+			r.ipsetMap.Delete(name)
+		}
+		return true
+	})
+}
+
+// buildLocalRule skips the marking of the traffic from the Pods of the policy running on its
+// gateway node, so it isn't sent to other gateway nodes through the tunnel by the overlapping
+// policies, and it's SNATed with the EIP on the node
+func buildLocalRule(policyName string, version uint8, isIgnoreInternalCIDR bool) iptables.Rule {
+	tmp := "v4-"
+	ignoreInternalCIDRName := EgressClusterCIDRIPv4
+	if version == 6 {
+		tmp = "v6-"
+		ignoreInternalCIDRName = EgressClusterCIDRIPv6
+	}
+	srcName := formatIPSetName(localIPSetPrefix+tmp, policyName)
+	dstName := formatIPSetName("egress-dst-"+tmp, policyName)
+
+	matchCriteria := iptables.MatchCriteria{}.SourceIPSet(srcName).DestIPSet(dstName).
+		CTDirectionOriginal(iptables.DirectionOriginal)
+	if isIgnoreInternalCIDR {
+		matchCriteria = iptables.MatchCriteria{}.SourceIPSet(srcName).NotDestIPSet(ignoreInternalCIDRName).
+			CTDirectionOriginal(iptables.DirectionOriginal)
+	}
+	return iptables.Rule{Match: matchCriteria, Action: iptables.ReturnAction{}, Comment: []string{
+		"local Pods of " + policyName + " on the gateway node",
+	}}
+}
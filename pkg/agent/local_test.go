@@ -0,0 +1,80 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/ipset"
+	ipsettest "github.com/spidernet-io/egressgateway/pkg/ipset/testing"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+func TestUpdateLocalIPSet(t *testing.T) {
+	slice := &egressv1.EgressEndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "policy1-abc",
+			Namespace: "default",
+			Labels:    map[string]string{egressv1.LabelPolicyName: "policy1"},
+		},
+		Endpoints: []egressv1.EgressEndpoint{
+			{Pod: "pod1", Node: "node1", IPv4: []string{"10.21.0.1"}},
+			{Pod: "pod2", Node: "node2", IPv4: []string{"10.21.0.2"}},
+		},
+	}
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(slice).Build()
+	fakeIPSet := ipsettest.NewFake("v7.1")
+	cfg := &config.Config{}
+	cfg.EnvConfig.NodeName = "node1"
+	cfg.FileConfig.EnableIPv4 = true
+	r := &policeReconciler{
+		client:   cli,
+		cfg:      cfg,
+		log:      logr.Discard(),
+		ipset:    fakeIPSet,
+		ipsetMap: utils.NewSyncMap[string, *ipset.IPSet](),
+	}
+	srcName := formatIPSetName("egress-src-v4-", "default-policy1")
+	localName := buildLocalIPSetNames("default", "policy1", true, false)[0].Name
+
+	// the Pods of all nodes are SNATed on the gateway node, the local Pods are recorded
+	assert.NoError(t, r.updatePolicyIPSet("default", "policy1", true, []string{"1.1.1.0/24"}))
+	assert.ElementsMatch(t, []string{"10.21.0.1", "10.21.0.2"}, fakeIPSet.Entries[srcName].UnsortedList())
+	assert.ElementsMatch(t, []string{"10.21.0.1"}, fakeIPSet.Entries[localName].UnsortedList())
+
+	// the local Pod leaves the node
+	slice.Endpoints[0].Node = "node3"
+	assert.NoError(t, cli.Update(context.Background(), slice))
+	assert.NoError(t, r.updatePolicyIPSet("default", "policy1", true, []string{"1.1.1.0/24"}))
+	assert.Empty(t, fakeIPSet.Entries[localName].UnsortedList())
+
+	r.forgetLocalIPSets(map[string]bool{})
+	_, ok := r.ipsetMap.Load(localName)
+	assert.False(t, ok)
+}
+
+func TestBuildLocalRule(t *testing.T) {
+	// the local Pods skip the marking, and are SNATed by the rule of the policy on the node
+	rule := buildLocalRule("default-policy1", 4, false)
+	assert.Equal(t, iptables.ReturnAction{}, rule.Action)
+	assert.Contains(t, rule.Match.Render(), formatIPSetName(localIPSetPrefix+"v4-", "default-policy1"))
+	assert.Contains(t, rule.Match.Render(), formatIPSetName("egress-dst-v4-", "default-policy1"))
+	snat := buildEipRule("default-policy1", IP{V4: "10.6.1.21"}, nil, 4, false)
+	assert.Equal(t, iptables.SNATAction{ToAddr: "10.6.1.21"}, snat.Action)
+	assert.Contains(t, snat.Match.Render(), formatIPSetName("egress-src-v4-", "default-policy1"))
+
+	rule = buildLocalRule("policy2", 6, true)
+	assert.Contains(t, rule.Match.Render(), formatIPSetName(localIPSetPrefix+"v6-", "policy2"))
+	assert.Contains(t, rule.Match.Render(), EgressClusterCIDRIPv6)
+}
@@ -5,6 +5,10 @@ package metrics
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spidernet-io/egressgateway/pkg/agent/probe"
+	"github.com/spidernet-io/egressgateway/pkg/agent/vxlan"
+	"github.com/spidernet-io/egressgateway/pkg/bfd"
+	egresserrors "github.com/spidernet-io/egressgateway/pkg/errors"
 	"github.com/spidernet-io/egressgateway/pkg/iptables"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
@@ -12,6 +16,10 @@ import (
 func RegisterMetricCollectors() {
 	var metricCollectors []prometheus.Collector
 	metricCollectors = append(metricCollectors, iptables.MetricCollectors()...)
+	metricCollectors = append(metricCollectors, probe.MetricCollectors()...)
+	metricCollectors = append(metricCollectors, vxlan.MetricCollectors()...)
+	metricCollectors = append(metricCollectors, bfd.MetricCollectors()...)
+	metricCollectors = append(metricCollectors, egresserrors.MetricCollectors()...)
 	for _, collector := range metricCollectors {
 		metrics.Registry.MustRegister(collector)
 	}
@@ -0,0 +1,415 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/vishvananda/netlink"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+const (
+	natMappingStart = "start"
+	natMappingEnd   = "end"
+)
+
+// natMapping is a record of the SNAT mapping of a connection, from the original source to
+// the translated one
+type natMapping struct {
+	Time              time.Time `json:"time"`
+	Event             string    `json:"event"`
+	Node              string    `json:"node"`
+	Namespace         string    `json:"namespace,omitempty"`
+	Policy            string    `json:"policy"`
+	Protocol          string    `json:"protocol"`
+	Src               string    `json:"src"`
+	SrcPort           uint16    `json:"srcPort"`
+	Dst               string    `json:"dst"`
+	DstPort           uint16    `json:"dstPort"`
+	TranslatedSrc     string    `json:"translatedSrc"`
+	TranslatedSrcPort uint16    `json:"translatedSrcPort"`
+}
+
+// natExportTarget is a policy SNATed on the node requiring its mappings exported
+type natExportTarget struct {
+	policy  egressv1.Policy
+	eips    map[string]struct{}
+	sources map[string]struct{}
+}
+
+// natExporter samples the conntrack table of the gateway node, and records the start and
+// the end of the SNAT mappings of the policies requiring them exported
+type natExporter struct {
+	client   client.Client
+	nodeName string
+	interval time.Duration
+	families []netlink.InetFamily
+	file     *rotatingFile
+	log      logr.Logger
+
+	listFlows func(family netlink.InetFamily) ([]*netlink.ConntrackFlow, error)
+	now       func() time.Time
+	// tracked is the mappings recorded as started by their original tuples
+	tracked map[string]natMapping
+}
+
+func addNATExporter(mgr manager.Manager, cfg *config.Config, log logr.Logger) error {
+	conf := cfg.FileConfig.NATMappingExport
+	if !conf.Enable {
+		return nil
+	}
+	families := make([]netlink.InetFamily, 0, 2)
+	if cfg.FileConfig.EnableIPv4 {
+		families = append(families, netlink.FAMILY_V4)
+	}
+	if cfg.FileConfig.EnableIPv6 {
+		families = append(families, netlink.FAMILY_V6)
+	}
+	return mgr.Add(&natExporter{
+		client:   mgr.GetClient(),
+		nodeName: cfg.EnvConfig.NodeName,
+		interval: time.Second * time.Duration(conf.IntervalSecond),
+		families: families,
+		file: newRotatingFile(conf.Dir, "nat-mappings-"+cfg.EnvConfig.NodeName,
+			int64(conf.MaxFileSizeMB)<<20, conf.MaxFiles, time.Hour*time.Duration(conf.MaxAgeHours)),
+		log: log,
+		listFlows: func(family netlink.InetFamily) ([]*netlink.ConntrackFlow, error) {
+			return netlink.ConntrackTableList(netlink.ConntrackTable, family)
+		},
+		now:     time.Now,
+		tracked: make(map[string]natMapping),
+	})
+}
+
+func (e *natExporter) Start(ctx context.Context) error {
+	e.log.Info("start exporting the NAT mappings", "interval", e.interval)
+	defer e.file.Close()
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := e.export(ctx); err != nil {
+				e.log.Error(err, "failed to export the NAT mappings")
+			}
+			// the rotated files expire even if nothing is written
+			if err := e.file.prune(); err != nil {
+				e.log.Error(err, "failed to remove the expired NAT mapping files")
+			}
+		}
+	}
+}
+
+// export records the mappings started and ended since the last sample
+func (e *natExporter) export(ctx context.Context) error {
+	targets, err := e.targets(ctx)
+	if err != nil {
+		return err
+	}
+	now := e.now()
+	seen := make(map[string]struct{}, len(e.tracked))
+	if len(targets) > 0 {
+		for _, family := range e.families {
+			flows, err := e.listFlows(family)
+			if err != nil {
+				return fmt.Errorf("failed to list conntrack: %w", err)
+			}
+			for _, flow := range flows {
+				mapping, ok := matchNATMapping(flow, targets)
+				if !ok {
+					continue
+				}
+				key := natMappingKey(mapping)
+				seen[key] = struct{}{}
+				if _, ok := e.tracked[key]; ok {
+					continue
+				}
+				mapping.Time = now
+				if flow.TimeStart != 0 {
+					mapping.Time = time.Unix(0, int64(flow.TimeStart))
+				}
+				mapping.Event = natMappingStart
+				mapping.Node = e.nodeName
+				if err := e.write(mapping); err != nil {
+					return err
+				}
+				e.tracked[key] = mapping
+			}
+		}
+	}
+
+	keys := make([]string, 0)
+	for key := range e.tracked {
+		if _, ok := seen[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		mapping := e.tracked[key]
+		mapping.Time = now
+		mapping.Event = natMappingEnd
+		if err := e.write(mapping); err != nil {
+			return err
+		}
+		delete(e.tracked, key)
+	}
+	return nil
+}
+
+func (e *natExporter) write(mapping natMapping) error {
+	line, err := json.Marshal(mapping)
+	if err != nil {
+		return err
+	}
+	return e.file.Write(append(line, '\n'))
+}
+
+// targets returns the policies SNATed on the node requiring their mappings exported, the
+// policies without sources are skipped as their flows can't be told from the others
+func (e *natExporter) targets(ctx context.Context) ([]natExportTarget, error) {
+	gateways := new(egressv1.EgressGatewayList)
+	if err := e.client.List(ctx, gateways); err != nil {
+		return nil, fmt.Errorf("failed to list EgressGateway: %w", err)
+	}
+	eips := make(map[egressv1.Policy]map[string]struct{})
+	add := func(policy egressv1.Policy, ips ...string) {
+		if eips[policy] == nil {
+			eips[policy] = make(map[string]struct{})
+		}
+		for _, ip := range ips {
+			if ip != "" {
+				eips[policy][ip] = struct{}{}
+			}
+		}
+	}
+	for _, gateway := range gateways.Items {
+		for _, node := range gateway.Status.NodeList {
+			if node.Name != e.nodeName {
+				continue
+			}
+			for _, eip := range node.Eips {
+				for _, policy := range eip.Policies {
+					add(policy, eip.IPv4, eip.IPv6)
+				}
+			}
+			for _, item := range node.DestinationEips {
+				add(item.Policy, item.IPv4, item.IPv6)
+			}
+		}
+	}
+
+	res := make([]natExportTarget, 0)
+	for policy, ips := range eips {
+		required, err := e.exportRequired(ctx, policy)
+		if err != nil {
+			return nil, err
+		}
+		if !required || len(ips) == 0 {
+			continue
+		}
+		ipv4, ipv6, err := listPolicySrcIPs(ctx, e.client, policy.Namespace, policy.Name,
+			func(egressv1.EgressEndpoint) bool { return true })
+		if err != nil {
+			return nil, err
+		}
+		if len(ipv4)+len(ipv6) == 0 {
+			continue
+		}
+		sources := make(map[string]struct{}, len(ipv4)+len(ipv6))
+		for _, ip := range append(ipv4, ipv6...) {
+			sources[ip] = struct{}{}
+		}
+		res = append(res, natExportTarget{policy: policy, eips: ips, sources: sources})
+	}
+	return res, nil
+}
+
+// exportRequired returns whether the policy is labeled to export its mappings
+func (e *natExporter) exportRequired(ctx context.Context, policy egressv1.Policy) (bool, error) {
+	var obj client.Object = new(egressv1.EgressClusterPolicy)
+	if policy.Namespace != "" {
+		obj = new(egressv1.EgressPolicy)
+	}
+	key := types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name}
+	if err := e.client.Get(ctx, key, obj); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return obj.GetLabels()[egressv1.LabelNATMappingExport] == "true", nil
+}
+
+// matchNATMapping returns the mapping of the flow SNATed to an EIP of a target from its
+// sources. The reply of a SNATed flow is sent to the EIP.
+func matchNATMapping(flow *netlink.ConntrackFlow, targets []natExportTarget) (natMapping, bool) {
+	translated := flow.Reverse.DstIP
+	if translated == nil || translated.Equal(flow.Forward.SrcIP) {
+		return natMapping{}, false
+	}
+	for _, target := range targets {
+		if _, ok := target.eips[translated.String()]; !ok {
+			continue
+		}
+		if _, ok := target.sources[flow.Forward.SrcIP.String()]; !ok {
+			continue
+		}
+		return natMapping{
+			Namespace:         target.policy.Namespace,
+			Policy:            target.policy.Name,
+			Protocol:          protocolName(flow.Forward.Protocol),
+			Src:               flow.Forward.SrcIP.String(),
+			SrcPort:           flow.Forward.SrcPort,
+			Dst:               flow.Forward.DstIP.String(),
+			DstPort:           flow.Forward.DstPort,
+			TranslatedSrc:     translated.String(),
+			TranslatedSrcPort: flow.Reverse.DstPort,
+		}, true
+	}
+	return natMapping{}, false
+}
+
+func natMappingKey(m natMapping) string {
+	return strings.Join([]string{m.Protocol,
+		net.JoinHostPort(m.Src, strconv.Itoa(int(m.SrcPort))),
+		net.JoinHostPort(m.Dst, strconv.Itoa(int(m.DstPort))),
+		net.JoinHostPort(m.TranslatedSrc, strconv.Itoa(int(m.TranslatedSrcPort))),
+	}, "|")
+}
+
+func protocolName(proto uint8) string {
+	switch proto {
+	case 1:
+		return "icmp"
+	case 6:
+		return "tcp"
+	case 17:
+		return "udp"
+	case 58:
+		return "icmpv6"
+	case 132:
+		return "sctp"
+	}
+	return strconv.Itoa(int(proto))
+}
+
+// rotatingFile appends to the file <name>.log in the directory, the file is rotated to
+// <name>-<time>.log once it reaches maxSize, and the rotated files beyond maxFiles or older
+// than maxAge are removed
+type rotatingFile struct {
+	dir      string
+	name     string
+	maxSize  int64
+	maxFiles int
+	maxAge   time.Duration
+	now      func() time.Time
+
+	file *os.File
+	size int64
+}
+
+func newRotatingFile(dir, name string, maxSize int64, maxFiles int, maxAge time.Duration) *rotatingFile {
+	return &rotatingFile{dir: dir, name: name, maxSize: maxSize, maxFiles: maxFiles, maxAge: maxAge, now: time.Now}
+}
+
+func (f *rotatingFile) path() string {
+	return filepath.Join(f.dir, f.name+".log")
+}
+
+func (f *rotatingFile) Write(b []byte) error {
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	if f.size > 0 && f.size+int64(len(b)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+	return err
+}
+
+func (f *rotatingFile) open() error {
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	rotated := filepath.Join(f.dir, fmt.Sprintf("%s-%s.log", f.name, f.now().UTC().Format("20060102T150405.000000000")))
+	if err := os.Rename(f.path(), rotated); err != nil {
+		return err
+	}
+	if err := f.prune(); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// prune removes the rotated files beyond maxFiles or older than maxAge
+func (f *rotatingFile) prune() error {
+	matches, err := filepath.Glob(filepath.Join(f.dir, f.name+"-*.log"))
+	if err != nil {
+		return err
+	}
+	// the names of the rotated files are sorted by their time
+	sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+	for i, path := range matches {
+		remove := i >= f.maxFiles
+		if !remove && f.maxAge > 0 {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			remove = f.now().Sub(info.ModTime()) > f.maxAge
+		}
+		if remove {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *rotatingFile) Close() {
+	if f.file != nil {
+		_ = f.file.Close()
+		f.file = nil
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func natFlow(src string, sport uint16, dst string, dport uint16, translated string, tport uint16) *netlink.ConntrackFlow {
+	flow := &netlink.ConntrackFlow{FamilyType: netlink.FAMILY_V4}
+	flow.Forward.Protocol = 6
+	flow.Forward.SrcIP, flow.Forward.SrcPort = net.ParseIP(src), sport
+	flow.Forward.DstIP, flow.Forward.DstPort = net.ParseIP(dst), dport
+	flow.Reverse.Protocol = 6
+	flow.Reverse.SrcIP, flow.Reverse.SrcPort = net.ParseIP(dst), dport
+	flow.Reverse.DstIP, flow.Reverse.DstPort = net.ParseIP(translated), tport
+	return flow
+}
+
+func readNATMappings(t *testing.T, path string) []natMapping {
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	res := make([]natMapping, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var m natMapping
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &m))
+		res = append(res, m)
+	}
+	return res
+}
+
+func TestMatchNATMapping(t *testing.T) {
+	targets := []natExportTarget{{
+		policy:  egressv1.Policy{Name: "test", Namespace: "default"},
+		eips:    map[string]struct{}{"10.6.1.21": {}},
+		sources: map[string]struct{}{"10.21.0.1": {}},
+	}}
+
+	m, ok := matchNATMapping(natFlow("10.21.0.1", 40000, "1.1.1.1", 443, "10.6.1.21", 50000), targets)
+	assert.True(t, ok)
+	assert.Equal(t, "test", m.Policy)
+	assert.Equal(t, "tcp", m.Protocol)
+	assert.Equal(t, "10.6.1.21", m.TranslatedSrc)
+	assert.Equal(t, uint16(50000), m.TranslatedSrcPort)
+	assert.Equal(t, "tcp|10.21.0.1:40000|1.1.1.1:443|10.6.1.21:50000", natMappingKey(m))
+
+	// the flows of the other sources sharing the EIP
+	_, ok = matchNATMapping(natFlow("10.21.0.2", 40000, "1.1.1.1", 443, "10.6.1.21", 50000), targets)
+	assert.False(t, ok)
+	// the flows SNATed to another IP
+	_, ok = matchNATMapping(natFlow("10.21.0.1", 40000, "1.1.1.1", 443, "10.6.1.22", 50000), targets)
+	assert.False(t, ok)
+	// the flows not SNATed
+	_, ok = matchNATMapping(natFlow("10.6.1.21", 40000, "1.1.1.1", 443, "10.6.1.21", 40000), targets)
+	assert.False(t, ok)
+}
+
+func TestNATExporter(t *testing.T) {
+	gateway := &egressv1.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "egw"},
+		Status: egressv1.EgressGatewayStatus{NodeList: []egressv1.EgressIPStatus{{
+			Name: "node1",
+			Eips: []egressv1.Eips{{IPv4: "10.6.1.21", Policies: []egressv1.Policy{
+				{Name: "test", Namespace: "default"},
+				{Name: "other", Namespace: "default"},
+			}}},
+		}}},
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(
+			gateway,
+			&egressv1.EgressPolicy{ObjectMeta: metav1.ObjectMeta{
+				Name: "test", Namespace: "default", Labels: map[string]string{egressv1.LabelNATMappingExport: "true"},
+			}},
+			&egressv1.EgressPolicy{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}},
+			&egressv1.EgressEndpointSlice{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-abc", Namespace: "default", Labels: map[string]string{egressv1.LabelPolicyName: "test"}},
+				Endpoints:  []egressv1.EgressEndpoint{{Pod: "pod1", Node: "node2", IPv4: []string{"10.21.0.1"}}},
+			},
+			&egressv1.EgressEndpointSlice{
+				ObjectMeta: metav1.ObjectMeta{Name: "other-abc", Namespace: "default", Labels: map[string]string{egressv1.LabelPolicyName: "other"}},
+				Endpoints:  []egressv1.EgressEndpoint{{Pod: "pod2", Node: "node2", IPv4: []string{"10.21.0.2"}}},
+			},
+		).Build()
+
+	dir := t.TempDir()
+	now := time.Date(2024, 5, 6, 8, 0, 0, 0, time.UTC)
+	flows := []*netlink.ConntrackFlow{
+		natFlow("10.21.0.1", 40000, "1.1.1.1", 443, "10.6.1.21", 50000),
+		natFlow("10.21.0.2", 40000, "1.1.1.1", 443, "10.6.1.21", 50001),
+	}
+	e := &natExporter{
+		client:   cli,
+		nodeName: "node1",
+		families: []netlink.InetFamily{netlink.FAMILY_V4},
+		file:     newRotatingFile(dir, "nat-mappings-node1", 1<<20, 2, 0),
+		log:      logr.Discard(),
+		listFlows: func(netlink.InetFamily) ([]*netlink.ConntrackFlow, error) {
+			return flows, nil
+		},
+		now:     func() time.Time { return now },
+		tracked: make(map[string]natMapping),
+	}
+	defer e.file.Close()
+
+	assert.NoError(t, e.export(context.Background()))
+	// the mappings are recorded once
+	assert.NoError(t, e.export(context.Background()))
+	flows = nil
+	now = now.Add(time.Minute)
+	assert.NoError(t, e.export(context.Background()))
+
+	mappings := readNATMappings(t, filepath.Join(dir, "nat-mappings-node1.log"))
+	assert.Len(t, mappings, 2)
+	assert.Equal(t, natMappingStart, mappings[0].Event)
+	assert.Equal(t, "node1", mappings[0].Node)
+	assert.Equal(t, "default", mappings[0].Namespace)
+	assert.Equal(t, "test", mappings[0].Policy)
+	assert.Equal(t, "10.21.0.1", mappings[0].Src)
+	assert.Equal(t, uint16(50000), mappings[0].TranslatedSrcPort)
+	assert.Equal(t, natMappingEnd, mappings[1].Event)
+	assert.Equal(t, now, mappings[1].Time.UTC())
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	f := newRotatingFile(dir, "nat", 10, 2, time.Hour)
+	f.now = func() time.Time { return now }
+	defer f.Close()
+
+	for i := 0; i < 4; i++ {
+		now = now.Add(time.Second)
+		assert.NoError(t, f.Write([]byte("12345678\n")))
+	}
+	rotated, err := filepath.Glob(filepath.Join(dir, "nat-*.log"))
+	assert.NoError(t, err)
+	// the oldest rotated file is removed beyond the max files
+	assert.Len(t, rotated, 2)
+	info, err := os.Stat(filepath.Join(dir, "nat.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9), info.Size())
+
+	// the rotated files expire
+	now = now.Add(2 * time.Hour)
+	assert.NoError(t, f.prune())
+	rotated, err = filepath.Glob(filepath.Join(dir, "nat-*.log"))
+	assert.NoError(t, err)
+	assert.Len(t, rotated, 0)
+}
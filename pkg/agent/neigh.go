@@ -0,0 +1,181 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/privilege"
+)
+
+// neighGCThresh is the gc threshold sysctl of the neighbor table of the family
+func neighGCThresh(family string, n int) string {
+	return fmt.Sprintf("net/%s/neigh/default/gc_thresh%d", family, n)
+}
+
+// neighTableTuner handles the overflow of the neighbor tables of the node, which makes
+// the kernel refuse the entries of the tunnel peers silently in large clusters. The gc
+// thresholds are raised if it's permitted, otherwise the overflow is reported by the
+// NeighborTable condition of the tunnel.
+type neighTableTuner struct {
+	log     logr.Logger
+	procSys string
+	sysctl  privilege.SysctlWriter
+	conf    config.NeighborTable
+	// families is the neighbor tables of the tunnel IPs, ipv4 and ipv6
+	families []string
+
+	mutex sync.Mutex
+	// cond is the NeighborTable condition, it's nil until the tables overflow
+	cond *metav1.Condition
+}
+
+func newNeighTableTuner(log logr.Logger, sysctl privilege.SysctlWriter, cfg *config.Config) *neighTableTuner {
+	families := make([]string, 0, 2)
+	if cfg.FileConfig.EnableIPv4 {
+		families = append(families, "ipv4")
+	}
+	if cfg.FileConfig.EnableIPv6 {
+		families = append(families, "ipv6")
+	}
+	return &neighTableTuner{
+		log:      log,
+		procSys:  "/proc/sys",
+		sysctl:   sysctl,
+		conf:     cfg.FileConfig.NeighborTable,
+		families: families,
+	}
+}
+
+// Handle records the result of adding the entries of the peers. On the overflow, the
+// thresholds are raised and it returns true if the entries should be added again.
+func (t *neighTableTuner) Handle(overflow bool) bool {
+	if t == nil {
+		return false
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !overflow {
+		if t.cond != nil && t.cond.Status == metav1.ConditionFalse {
+			t.log.Info("the entries of the tunnel peers are programmed after the neighbor table overflow")
+			t.cond = &metav1.Condition{
+				Status:  metav1.ConditionTrue,
+				Reason:  egressv1.NeighborTableRecovered,
+				Message: "the entries of the tunnel peers are programmed after the overflow",
+			}
+		}
+		return false
+	}
+
+	hint := fmt.Sprintf("raise %s of the node", strings.Join(t.sysctls(3), ", "))
+	if !t.conf.AutoTune {
+		t.setOverflow("the neighbor table overflows, the entries of the tunnel peers are refused, autoTune is disabled, " + hint)
+		return false
+	}
+	raised := make([]string, 0, len(t.families))
+	for _, family := range t.families {
+		msg, err := t.raise(family)
+		if err != nil {
+			t.log.Error(err, "failed to raise the gc thresholds of the neighbor table", "family", family)
+			t.setOverflow(fmt.Sprintf("the neighbor table overflows, the entries of the tunnel peers are refused, %v, %s", err, hint))
+			return false
+		}
+		raised = append(raised, msg)
+	}
+	msg := "the neighbor table overflowed, " + strings.Join(raised, "; ")
+	t.log.Info(msg)
+	t.cond = &metav1.Condition{
+		Status:  metav1.ConditionTrue,
+		Reason:  egressv1.NeighborTableThresholdRaised,
+		Message: msg,
+	}
+	return true
+}
+
+func (t *neighTableTuner) setOverflow(msg string) {
+	if t.cond == nil || t.cond.Message != msg {
+		t.log.Info(msg)
+	}
+	t.cond = &metav1.Condition{
+		Status:  metav1.ConditionFalse,
+		Reason:  egressv1.NeighborTableOverflow,
+		Message: msg,
+	}
+}
+
+// raise doubles gc_thresh2 and gc_thresh3 of the family up to maxGCThresh3
+func (t *neighTableTuner) raise(family string) (string, error) {
+	thresh2, err := t.read(neighGCThresh(family, 2))
+	if err != nil {
+		return "", err
+	}
+	thresh3, err := t.read(neighGCThresh(family, 3))
+	if err != nil {
+		return "", err
+	}
+	if thresh3 >= t.conf.MaxGCThresh3 {
+		return "", fmt.Errorf("%s %d reaches maxGCThresh3 %d", neighGCThresh(family, 3), thresh3, t.conf.MaxGCThresh3)
+	}
+	new3 := thresh3 * 2
+	if new3 > t.conf.MaxGCThresh3 || new3 <= 0 {
+		new3 = t.conf.MaxGCThresh3
+	}
+	new2 := thresh2 * 2
+	if new2 > new3 || new2 <= 0 {
+		new2 = new3
+	}
+	// gc_thresh3 is raised first, so gc_thresh2 never exceeds it
+	if err := t.sysctl.WriteSysctl(neighGCThresh(family, 3), strconv.Itoa(new3)); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", neighGCThresh(family, 3), err)
+	}
+	if err := t.sysctl.WriteSysctl(neighGCThresh(family, 2), strconv.Itoa(new2)); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", neighGCThresh(family, 2), err)
+	}
+	return fmt.Sprintf("%s is raised from %d to %d", neighGCThresh(family, 3), thresh3, new3), nil
+}
+
+func (t *neighTableTuner) read(key string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(t.procSys, key))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+func (t *neighTableTuner) sysctls(n int) []string {
+	res := make([]string, 0, len(t.families))
+	for _, family := range t.families {
+		res = append(res, strings.ReplaceAll(neighGCThresh(family, n), "/", "."))
+	}
+	return res
+}
+
+// setCondition sets the NeighborTable condition of the tunnel once the tables overflowed
+func (t *neighTableTuner) setCondition(status *egressv1.EgressTunnelStatus, generation int64) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.cond == nil {
+		meta.RemoveStatusCondition(&status.Conditions, egressv1.TunnelConditionNeighborTable)
+		return
+	}
+	cond := *t.cond
+	cond.Type = egressv1.TunnelConditionNeighborTable
+	cond.ObservedGeneration = generation
+	meta.SetStatusCondition(&status.Conditions, cond)
+}
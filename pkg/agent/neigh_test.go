@@ -0,0 +1,96 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/vxlan"
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/privilege"
+)
+
+func TestNeighTableTuner(t *testing.T) {
+	root := t.TempDir()
+	read := func(key string) string {
+		b, err := os.ReadFile(filepath.Join(root, key))
+		assert.NoError(t, err)
+		return string(b)
+	}
+	for _, family := range []string{"ipv4", "ipv6"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(root, "net", family, "neigh/default"), 0755))
+		for n, val := range map[int]string{2: "512\n", 3: "1024\n"} {
+			assert.NoError(t, os.WriteFile(filepath.Join(root, neighGCThresh(family, n)), []byte(val), 0644))
+		}
+	}
+
+	cfg := &config.Config{}
+	cfg.FileConfig.EnableIPv4 = true
+	cfg.FileConfig.NeighborTable = config.NeighborTable{AutoTune: true, MaxGCThresh3: 3000}
+	tuner := newNeighTableTuner(logr.Discard(), privilege.NewSysctlWriter(root, ""), cfg)
+	tuner.procSys = root
+
+	status := &egressv1.EgressTunnelStatus{}
+	assert.False(t, tuner.Handle(false))
+	tuner.setCondition(status, 1)
+	assert.Nil(t, meta.FindStatusCondition(status.Conditions, egressv1.TunnelConditionNeighborTable))
+
+	// the thresholds are doubled up to maxGCThresh3
+	assert.True(t, tuner.Handle(true))
+	assert.Equal(t, "2048", read(neighGCThresh("ipv4", 3)))
+	assert.Equal(t, "1024", read(neighGCThresh("ipv4", 2)))
+	assert.Equal(t, "1024\n", read(neighGCThresh("ipv6", 3)))
+	assert.True(t, tuner.Handle(true))
+	assert.Equal(t, "3000", read(neighGCThresh("ipv4", 3)))
+	assert.Equal(t, "2048", read(neighGCThresh("ipv4", 2)))
+	tuner.setCondition(status, 1)
+	cond := meta.FindStatusCondition(status.Conditions, egressv1.TunnelConditionNeighborTable)
+	assert.Equal(t, metav1.ConditionTrue, cond.Status)
+	assert.Equal(t, egressv1.NeighborTableThresholdRaised, cond.Reason)
+	assert.Contains(t, cond.Message, "raised from 2048 to 3000")
+
+	// the overflow is reported once the thresholds can't be raised
+	assert.False(t, tuner.Handle(true))
+	tuner.setCondition(status, 2)
+	cond = meta.FindStatusCondition(status.Conditions, egressv1.TunnelConditionNeighborTable)
+	assert.Equal(t, metav1.ConditionFalse, cond.Status)
+	assert.Equal(t, egressv1.NeighborTableOverflow, cond.Reason)
+	assert.Contains(t, cond.Message, "reaches maxGCThresh3 3000")
+	assert.Contains(t, cond.Message, "raise net.ipv4.neigh.default.gc_thresh3")
+
+	assert.False(t, tuner.Handle(false))
+	tuner.setCondition(status, 2)
+	cond = meta.FindStatusCondition(status.Conditions, egressv1.TunnelConditionNeighborTable)
+	assert.Equal(t, metav1.ConditionTrue, cond.Status)
+	assert.Equal(t, egressv1.NeighborTableRecovered, cond.Reason)
+
+	// the sysctls are not written without autoTune
+	tuner.conf.AutoTune = false
+	assert.False(t, tuner.Handle(true))
+	assert.Equal(t, "3000", read(neighGCThresh("ipv4", 3)))
+	tuner.setCondition(status, 3)
+	cond = meta.FindStatusCondition(status.Conditions, egressv1.TunnelConditionNeighborTable)
+	assert.Equal(t, egressv1.NeighborTableOverflow, cond.Reason)
+	assert.Contains(t, cond.Message, "autoTune is disabled")
+
+	var nilTuner *neighTableTuner
+	assert.False(t, nilTuner.Handle(true))
+}
+
+func TestIsNeighTableOverflow(t *testing.T) {
+	assert.True(t, vxlan.IsNeighTableOverflow(fmt.Errorf("add neighbor 172.31.0.2: %w", syscall.ENOBUFS)))
+	assert.False(t, vxlan.IsNeighTableOverflow(errors.New("no buffer space available")))
+	assert.False(t, vxlan.IsNeighTableOverflow(nil))
+}
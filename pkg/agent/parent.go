@@ -0,0 +1,69 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/vishvananda/netlink"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/vxlan"
+)
+
+// keepParentAddr watches the address changes of the node, so the parent IP of the node is
+// updated in the EgressTunnel without waiting for the next sync of keepVXLAN, e.g. the IP
+// of the node is renumbered by DHCP.
+func (r *vxlanReconciler) keepParentAddr() {
+	for {
+		updates := make(chan netlink.AddrUpdate)
+		done := make(chan struct{})
+		err := netlink.AddrSubscribeWithOptions(updates, done, netlink.AddrSubscribeOptions{
+			ErrorCallback: func(err error) {
+				r.loopLog.Error(err, "watch address changes")
+			},
+		})
+		if err != nil {
+			r.loopLog.Error(err, "subscribe address changes")
+			time.Sleep(time.Second)
+			continue
+		}
+		r.loopLog.Resolved("subscribe address changes")
+
+		for update := range updates {
+			// the link local addresses are never used as the parent IP
+			if update.LinkAddress.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			r.log.V(1).Info("address of node changed", "address", update.LinkAddress.String(),
+				"index", update.LinkIndex, "new", update.NewAddr)
+			r.triggerResync()
+		}
+		close(done)
+		time.Sleep(time.Second)
+	}
+}
+
+// triggerResync wakes keepVXLAN up to sync the vxlan device, the parent IP and the peers
+func (r *vxlanReconciler) triggerResync() {
+	select {
+	case r.resync <- struct{}{}:
+	default:
+	}
+}
+
+// refreshPeerParent drops the fdb entry of the peer to its previous parent IP, the entry to
+// the new parent IP is added with the peer, and the tunnel networks are resynced.
+func (r *vxlanReconciler) refreshPeerParent(name string, peer vxlan.Peer, log logr.Logger) {
+	old, ok := r.peerMap.Load(name)
+	if !ok || old.Parent == nil || old.Parent.Equal(peer.Parent) {
+		return
+	}
+	log.Info("parent ip of peer changed", "peer", name,
+		"previous", old.Parent.String(), "current", peer.Parent.String())
+	if err := r.vxlan.DelFDB(old.MAC, old.Parent); err != nil {
+		log.Error(err, "delete stale fdb entry of peer", "peer", name)
+	}
+	r.triggerResync()
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/vxlan"
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+func TestParentIPChange(t *testing.T) {
+	ctx := context.Background()
+	tunnel := func(name, parent string) *egressv1.EgressTunnel {
+		return &egressv1.EgressTunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: egressv1.EgressTunnelStatus{
+				Phase: egressv1.EgressTunnelReady,
+				Tunnel: egressv1.Tunnel{
+					IPv4:   "172.31.0.2",
+					MAC:    "66:bf:c7:47:5c:14",
+					Parent: egressv1.Parent{Name: "eth0", IPv4: parent},
+				},
+			},
+		}
+	}
+	gateway := tunnel("node2", "10.6.1.22")
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(gateway).
+		WithStatusSubresource(gateway).
+		Build()
+
+	cfg := &config.Config{}
+	cfg.EnvConfig.NodeName = "node1"
+	cfg.FileConfig.EnableIPv4 = true
+	cfg.FileConfig.GatewayFailover.EipEvictionTimeout = 5
+	cfg.FileConfig.GatewayFailover.TunnelUpdatePeriod = 5
+	newReconciler := func(node string, parent net.IP) *vxlanReconciler {
+		cfg := *cfg
+		cfg.EnvConfig.NodeName = node
+		cfg.NodeName = node
+		return &vxlanReconciler{
+			client:         cli,
+			log:            logr.Discard(),
+			cfg:            &cfg,
+			peerMap:        utils.NewSyncMap[string, vxlan.Peer](),
+			vxlan:          vxlan.New(),
+			updateTimer:    time.NewTimer(time.Minute),
+			loopLog:        logger.NewDeduper(logr.Discard(), loopLogInterval),
+			resync:         make(chan struct{}, 1),
+			datapathErrs:   newDatapathErrors(false),
+			statusThrottle: newStatusThrottle(0, time.Minute, 0),
+			getParent: func(version int) (*vxlan.Parent, error) {
+				return &vxlan.Parent{Name: "eth0", IP: parent, Index: 2}, nil
+			},
+		}
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "node2"}}
+
+	// the peer learns the parent IP of the gateway node
+	peer := newReconciler("node1", net.ParseIP("10.6.1.21"))
+	_, err := peer.reconcileEgressTunnel(ctx, req, logr.Discard())
+	assert.NoError(t, err)
+	val, ok := peer.peerMap.Load("node2")
+	assert.True(t, ok)
+	assert.Equal(t, "10.6.1.22", val.Parent.String())
+
+	// the gateway node is renumbered, and updates its parent IP
+	gw := newReconciler("node2", net.ParseIP("10.6.1.122"))
+	assert.NoError(t, gw.updateEgressTunnelStatus(nil, 4))
+	assert.NoError(t, cli.Get(ctx, req.NamespacedName, gateway))
+	assert.Equal(t, "10.6.1.122", gateway.Status.Tunnel.Parent.IPv4)
+
+	// the peer replaces the parent IP, and resyncs the tunnel at once
+	_, err = peer.reconcileEgressTunnel(ctx, req, logr.Discard())
+	assert.NoError(t, err)
+	val, _ = peer.peerMap.Load("node2")
+	assert.Equal(t, "10.6.1.122", val.Parent.String())
+	select {
+	case <-peer.resync:
+	default:
+		t.Fatal("resync is not triggered")
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/vxlan"
+)
+
+// peerSyncReport is the fdb and the neighbor entries of the tunnel peers programmed on the
+// vxlan device of the node against the peers known by the agent
+type peerSyncReport struct {
+	Node  string           `json:"node"`
+	Peers []vxlan.PeerSync `json:"peers"`
+	// Stale is the neighbor entries of the device which belong to no peer
+	Stale []string `json:"stale"`
+}
+
+// peerSync lists the entries of the vxlan device and compares them with the peers
+func (r *vxlanReconciler) peerSync() (peerSyncReport, error) {
+	neighs, err := r.vxlan.ListNeigh()
+	if err != nil {
+		return peerSyncReport{}, err
+	}
+	fdbs, err := r.vxlan.ListFDB()
+	if err != nil {
+		return peerSyncReport{}, err
+	}
+	peers := make(map[string]vxlan.Peer)
+	r.peerMap.Range(func(key string, peer vxlan.Peer) bool {
+		if key != r.cfg.EnvConfig.NodeName {
+			peers[key] = peer
+		}
+		return true
+	})
+	res, stale := vxlan.DiffPeers(peers, neighs, fdbs)
+	return peerSyncReport{Node: r.cfg.EnvConfig.NodeName, Peers: res, Stale: stale}, nil
+}
+
+// recordPeerSync records the sync status of the peers in the metrics
+func (r *vxlanReconciler) recordPeerSync() {
+	report, err := r.peerSync()
+	if err != nil {
+		r.loopLog.Error(err, "list the tunnel peer entries")
+		return
+	}
+	r.loopLog.Resolved("list the tunnel peer entries")
+	vxlan.RecordPeerSync(report.Peers, report.Stale)
+}
+
+// peerSyncHandler serves the sync status of the tunnel peers, it's listed from the kernel
+// on each request, so a broken tunnel of a node pair shows up at once
+type peerSyncHandler struct {
+	report func() (peerSyncReport, error)
+}
+
+func (h *peerSyncHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	if h.report == nil {
+		http.Error(w, "the tunnel is not ready", http.StatusServiceUnavailable)
+		return
+	}
+	report, err := h.report()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,43 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/vxlan"
+)
+
+func TestPeerSyncHandler(t *testing.T) {
+	h := &peerSyncHandler{}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/tunnel/peers", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	report := peerSyncReport{
+		Node:  "node1",
+		Peers: []vxlan.PeerSync{{Peer: "node2", MAC: "66:5f:01:00:00:02", Parent: "10.6.0.2", MissingFDB: true}},
+		Stale: []string{},
+	}
+	h.report = func() (peerSyncReport, error) { return report, nil }
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/tunnel/peers", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	got := peerSyncReport{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, report, got)
+	assert.Contains(t, w.Body.String(), `"missingFDB":true`)
+
+	h.report = func() (peerSyncReport, error) { return peerSyncReport{}, errors.New("link not found") }
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/tunnel/peers", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
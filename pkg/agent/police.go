@@ -16,11 +16,16 @@ import (
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spidernet-io/egressgateway/pkg/config"
+	egresserrors "github.com/spidernet-io/egressgateway/pkg/errors"
 	"github.com/spidernet-io/egressgateway/pkg/ipset"
 	"github.com/spidernet-io/egressgateway/pkg/iptables"
 	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
+	"github.com/spidernet-io/egressgateway/pkg/privilege"
 	"github.com/spidernet-io/egressgateway/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
 	apierr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -28,9 +33,11 @@ import (
 	"k8s.io/utils/exec"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
@@ -54,6 +61,43 @@ type policeReconciler struct {
 	filterTables  []*iptables.Table
 	natTables     []*iptables.Table
 	policyMapNode *utils.SyncMap[egressv1.Policy, string]
+
+	// appliedEIPs is the EIPs of the policies SNATed on the node by the last apply
+	appliedEIPs map[egressv1.Policy]IP
+	// shadowPolicies is the policies in the Shadow mode applied by the last apply, the
+	// value is true if the policy has no destSubnet
+	shadowPolicies *utils.SyncMap[egressv1.Policy, bool]
+	// excludedPolicies is the cluster policies excluding the node applied by the last apply
+	excludedPolicies *utils.SyncMap[egressv1.Policy, bool]
+	// clusterDefaultPolicies is the cluster default policies applied by the last apply
+	clusterDefaultPolicies *utils.SyncMap[egressv1.Policy, bool]
+	// loggedPolicies is the policies whose new connections are logged by the last apply,
+	// by their NFLOG prefixes
+	loggedPolicies *utils.SyncMap[string, loggedPolicy]
+	// proxyRoutes is the relayed connections of the policies by the last apply
+	proxyRoutes *utils.SyncMap[egressv1.Policy, proxyRoute]
+	// l7Routes is the proxied connections of the policies by the last apply
+	l7Routes *utils.SyncMap[egressv1.Policy, l7Route]
+	// standbyIPs is the standby EIPs programmed by the last apply by the gateways
+	standbyIPs *utils.SyncMap[string, []string]
+
+	// tablesMu serializes the applies of the tables by the policies and the EIP verifier
+	tablesMu sync.Mutex
+	// markChain and snatChain are the double buffered chains of the rules of the policies
+	markChain *swapChain
+	snatChain *swapChain
+	// verifyProbes is the probes of the EIP verification in progress, which are SNATed
+	// with their EIPs
+	verifyProbes []eipProbe
+
+	readiness *datapathReadiness
+	// batcher batches the applies requested by the gateways and the policies, it's nil if
+	// the changes are applied at once
+	batcher *applyBatcher
+	// boot persists the fail-closed rules on the host if it's enabled
+	boot *bootPersistence
+	// convergence measures the durations of programming the observed changes
+	convergence *convergenceTracker
 }
 
 func (r *policeReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
@@ -65,12 +109,14 @@ func (r *policeReconciler) Reconcile(ctx context.Context, req reconcile.Request)
 			r.log.Error(err, "init policy")
 			goto redo
 		}
+		r.readiness.Done(datapathPolicy)
 	})
 	kind, newReq, err := utils.ParseKindWithReq(req)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
 	log := r.log.WithValues("kind", kind)
+	key := changeKey(kind, newReq.NamespacedName)
 	var res reconcile.Result
 	switch kind {
 	case "EgressGateway":
@@ -81,16 +127,35 @@ func (r *policeReconciler) Reconcile(ctx context.Context, req reconcile.Request)
 		res, err = r.reconcilePolicy(ctx, newReq, log)
 	case "EgressClusterInfo":
 		res, err = r.reconcileClusterInfo(ctx, newReq, log)
+	case "Node":
+		res, err = r.reconcileNode(ctx, newReq)
 	default:
 		return reconcile.Result{}, nil
 	}
+	if err == nil && !res.Requeue {
+		r.convergence.Settle(key)
+	}
 	return res, err
 }
 
 type PolicyCommon struct {
-	NodeName   string
+	NodeName string
+	// Gateway is set when the EgressGateway has a dedicated tunnel network
+	Gateway    string
 	DestSubnet []string
 	IP         IP
+	// SNAT is the source NAT options of the EgressGateway
+	SNAT *egressv1.SNAT
+	// Limits is the connection limits of the policy
+	Limits *egressv1.ConnectionLimits
+	// Destinations is the destination groups of the policy placed on the node
+	Destinations []DestinationEIP
+	// Logging is the logging of the new connections of the policy
+	Logging *egressv1.PolicyLogging
+	// ProxyProtocol is the relayed connections of the policy
+	ProxyProtocol *egressv1.ProxyProtocol
+	// L7Proxy is the proxied connections of the policy
+	L7Proxy *egressv1.L7Proxy
 }
 
 type IP struct {
@@ -106,6 +171,8 @@ type IP struct {
 // build route table rule
 // build iptables
 func (r *policeReconciler) initApplyPolicy() error {
+	r.tablesMu.Lock()
+	defer r.tablesMu.Unlock()
 	r.log.Info("apply policy")
 	ctx := context.Background()
 
@@ -115,40 +182,135 @@ func (r *policeReconciler) initApplyPolicy() error {
 		return fmt.Errorf("failed to list gateway: %v", err)
 	}
 
-	if len(gateways.Items) == 0 {
-		return nil
-	}
-
 	err = r.ensureClusterInfoIPSet()
 	if err != nil {
 		return fmt.Errorf("ensure cluster info ipset with error: %v", err)
 	}
 
+	if err := r.updateBypassIPSet(); err != nil {
+		return fmt.Errorf("failed to update the bypass ipset: %w", err)
+	}
+
+	hairpin := r.cfg.FileConfig.Hairpin
+	if hairpinEnabled(hairpin) {
+		if err := r.updateHairpinIPSet(gateways.Items); err != nil {
+			return fmt.Errorf("failed to update the hairpin ipset: %w", err)
+		}
+	}
+
 	unSnatPolicies := make(map[egressv1.Policy]*PolicyCommon)
 	snatPolicies := make(map[egressv1.Policy]*PolicyCommon)
+	// the EIPs of the destination groups of the policies placed on the node
+	placed := make(map[egressv1.Policy]map[string]IP)
+	// the policies whose EIPs the node stands by for, by their gateways
+	standbyPolicies := make(map[egressv1.Policy]*PolicyCommon)
+	standbyGateways := make(map[egressv1.Policy]string)
 	isEgressNode := false
 	for _, item := range gateways.Items {
+		gatewayNetwork := ""
+		if item.Spec.Tunnel != nil {
+			gatewayNetwork = item.Name
+		}
 		for _, list := range item.Status.NodeList {
 			if list.Name == r.cfg.NodeName {
 				isEgressNode = true
+				for _, item := range list.DestinationEips {
+					if placed[item.Policy] == nil {
+						placed[item.Policy] = make(map[string]IP)
+					}
+					placed[item.Policy][item.Destination] = IP{V4: item.IPv4, V6: item.IPv6}
+				}
 				for _, eip := range list.Eips {
 					for _, policy := range eip.Policies {
 						snatPolicies[policy] = &PolicyCommon{
 							NodeName: list.Name,
 							IP:       IP{V4: eip.IPv4, V6: eip.IPv6},
+							SNAT:     item.Spec.SNAT,
+						}
+					}
+				}
+				if r.cfg.FileConfig.GatewayFailover.WarmStandby.Enable {
+					for _, eip := range list.StandbyEips {
+						for _, policy := range eip.Policies {
+							standbyPolicies[policy] = &PolicyCommon{
+								NodeName: list.Name,
+								IP:       IP{V4: eip.IPv4, V6: eip.IPv6},
+								SNAT:     item.Spec.SNAT,
+							}
+							standbyGateways[policy] = item.Name
 						}
 					}
 				}
 			} else {
 				for _, eip := range list.Eips {
 					for _, policy := range eip.Policies {
-						unSnatPolicies[policy] = &PolicyCommon{NodeName: list.Name}
+						unSnatPolicies[policy] = &PolicyCommon{NodeName: list.Name, Gateway: gatewayNetwork}
 					}
 				}
 			}
 		}
 	}
 
+	// the policies in the Shadow mode are neither forwarded nor SNATed
+	shadowPolicies := make(map[egressv1.Policy]*PolicyCommon)
+	for _, policies := range []map[egressv1.Policy]*PolicyCommon{unSnatPolicies, snatPolicies} {
+		for policy, val := range policies {
+			mode, err := r.getPolicyMode(policy.Namespace, policy.Name)
+			if err != nil {
+				return err
+			}
+			if mode == egressv1.PolicyModeShadow {
+				shadowPolicies[policy] = val
+				delete(policies, policy)
+			}
+		}
+	}
+
+	// the Pods on the node are never redirected by the cluster policies excluding the node,
+	// and they aren't SNATed if the node is the gateway node of the policy
+	excluded := make(map[egressv1.Policy]bool)
+	for _, policies := range []map[egressv1.Policy]*PolicyCommon{unSnatPolicies, snatPolicies} {
+		for policy := range policies {
+			ok, err := r.getPolicyExcludesNode(policy.Namespace, policy.Name)
+			if err != nil {
+				return err
+			}
+			if ok {
+				excluded[policy] = true
+			}
+		}
+	}
+	for policy := range excluded {
+		delete(unSnatPolicies, policy)
+	}
+
+	// the cluster default policies are evaluated at a lower priority than the others
+	clusterDefaults := make(map[egressv1.Policy]bool)
+	for _, policies := range []map[egressv1.Policy]*PolicyCommon{unSnatPolicies, snatPolicies} {
+		for policy := range policies {
+			ok, err := r.getPolicyClusterDefault(policy.Namespace, policy.Name)
+			if err != nil {
+				return err
+			}
+			if ok {
+				clusterDefaults[policy] = true
+			}
+		}
+	}
+
+	for policy, val := range shadowPolicies {
+		val.DestSubnet, err = r.getPolicySubnet(policy.Namespace, policy.Name)
+		if err != nil {
+			return err
+		}
+		if err := r.updatePolicyIPSet(policy.Namespace, policy.Name, false, val.DestSubnet); err != nil {
+			return err
+		}
+		if err := r.updateShadowIPSet(policy.Namespace, policy.Name, val.DestSubnet); err != nil {
+			return err
+		}
+	}
+
 	for policy, val := range unSnatPolicies {
 		val.DestSubnet, err = r.getPolicySubnet(policy.Namespace, policy.Name)
 		if err != nil {
@@ -160,41 +322,161 @@ func (r *policeReconciler) initApplyPolicy() error {
 		}
 	}
 
+	// only the policies forwarded to other gateway nodes are stood by, rather than the ones
+	// in the Shadow mode, excluding the node, or without EIPs
+	standbySets := make(map[string]bool)
+	for policy, val := range standbyPolicies {
+		forwarded, ok := unSnatPolicies[policy]
+		if !ok || (val.IP.V4 == "" && val.IP.V6 == "") {
+			delete(standbyPolicies, policy)
+			continue
+		}
+		val.DestSubnet = forwarded.DestSubnet
+		if err := r.updateStandbyIPSet(policy.Namespace, policy.Name); err != nil {
+			return err
+		}
+		for _, set := range buildStandbyIPSetNames(policy.Namespace, policy.Name, true, true) {
+			standbySets[set.Name] = true
+		}
+	}
+	r.forgetStandbyIPSets(standbySets)
+
+	destinationSets := make(map[string]bool)
+	localSets := make(map[string]bool)
 	for policy, val := range snatPolicies {
 		val.DestSubnet, err = r.getPolicySubnet(policy.Namespace, policy.Name)
 		if err != nil {
 			return err
 		}
+		val.Limits, err = r.getPolicyLimits(policy.Namespace, policy.Name)
+		if err != nil {
+			return err
+		}
+		if r.cfg.FileConfig.ConnectionLog.Enable {
+			val.Logging, err = r.getPolicyLogging(policy.Namespace, policy.Name)
+			if err != nil {
+				return err
+			}
+		}
+		if r.cfg.FileConfig.ProxyProtocol.Enable {
+			val.ProxyProtocol, err = r.getPolicyProxyProtocol(policy.Namespace, policy.Name)
+			if err != nil {
+				return err
+			}
+		}
+		if r.cfg.FileConfig.L7Proxy.Enable {
+			val.L7Proxy, err = r.getPolicyL7Proxy(policy.Namespace, policy.Name)
+			if err != nil {
+				return err
+			}
+		}
+		if val.IP.V4 == "" && val.IP.V6 == "" {
+			useNodeIP, err := r.getPolicyUseNodeIP(policy.Namespace, policy.Name)
+			if err != nil {
+				return err
+			}
+			if useNodeIP {
+				val.IP, err = r.nodeIP()
+				if err != nil {
+					return err
+				}
+			}
+		}
 		err := r.updatePolicyIPSet(policy.Namespace, policy.Name, true, val.DestSubnet)
 		if err != nil {
 			return err
 		}
+		for _, set := range buildLocalIPSetNames(policy.Namespace, policy.Name, true, true) {
+			localSets[set.Name] = true
+		}
+		if len(placed[policy]) == 0 {
+			continue
+		}
+		groups, err := r.getPolicyDestinationEIPs(policy.Namespace, policy.Name)
+		if err != nil {
+			return err
+		}
+		val.Destinations = buildPolicyDestinations(groups, placed[policy])
+		names, err := r.updateDestinationIPSets(policy.Namespace, policy.Name, val.Destinations)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			destinationSets[name] = true
+		}
 	}
+	r.forgetDestinationIPSets(destinationSets)
+	r.forgetLocalIPSets(localSets)
 
-	baseMark, err := parseMark(r.cfg.FileConfig.Mark)
+	markSpace, err := markallocator.NewSpace(r.cfg.FileConfig.Mark, r.cfg.FileConfig.MarkMask)
 	if err != nil {
 		return err
 	}
 
 	for _, table := range r.filterTables {
-		chainMapRules := buildFilterStaticRule(baseMark)
+		rules := make([]iptables.Rule, 0)
+		for _, policy := range sortedPolicies(snatPolicies) {
+			val := snatPolicies[policy]
+			policyName := policy.Name
+			if policy.Namespace != "" {
+				policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+			}
+			rules = append(rules, buildLimitRules(policyName, val.Limits, table.IPVersion, len(val.DestSubnet) == 0)...)
+		}
+		table.UpdateChain(&iptables.Chain{Name: "EGRESSGATEWAY-LIMIT", Rules: rules})
+		chainMapRules := buildFilterStaticRule(markSpace)
+		if hairpinEnabled(hairpin) {
+			table.UpdateChain(&iptables.Chain{Name: EgressHairpinChain, Rules: buildHairpinRules(hairpin, table.Name, table.IPVersion)})
+			chainMapRules["FORWARD"] = append([]iptables.Rule{hairpinJumpRule()}, chainMapRules["FORWARD"]...)
+		}
+		if r.cfg.FileConfig.FailClosed.Enable {
+			table.UpdateChain(&iptables.Chain{Name: EgressFailClosedChain, Rules: buildFailClosedRules(r.cfg.FileConfig.VXLAN.Name, markSpace, false)})
+			chainMapRules["FORWARD"] = append([]iptables.Rule{failClosedJumpRule()}, chainMapRules["FORWARD"]...)
+		}
 		for chain, rules := range chainMapRules {
 			table.InsertOrAppendRules(chain, rules)
 		}
 	}
 
+	l7Routes := buildL7Routes(snatPolicies)
 	for _, table := range r.mangleTables {
 		table.UpdateChain(&iptables.Chain{Name: "EGRESSGATEWAY-REPLY-ROUTING"})
-		table.UpdateChain(&iptables.Chain{Name: "EGRESSGATEWAY-MARK-REQUEST"})
+		table.UpdateChain(&iptables.Chain{Name: EgressShadowChain})
+		table.UpdateChain(&iptables.Chain{Name: EgressICMPErrorChain})
 		chainMapRules := buildMangleStaticRule(
-			baseMark,
+			markSpace,
 			isEgressNode,
 			r.cfg.FileConfig.EnableGatewayReplyRoute,
 			uint32(r.cfg.FileConfig.GatewayReplyRouteMark),
 		)
+		chainMapRules["POSTROUTING"] = append(
+			buildTunnelPriorityRules(r.cfg.FileConfig.VXLAN.Name, r.cfg.FileConfig.VXLAN.Priority),
+			chainMapRules["POSTROUTING"]...,
+		)
+		if mark := r.l7ProxyMark(); mark != 0 {
+			// the diverted connections skip the marks of the policies
+			l7Rules := buildL7ProxySocketRules(mark)
+			for _, policy := range sortedPolicies(l7Routes) {
+				route := l7Routes[policy]
+				policyName := policy.Name
+				if policy.Namespace != "" {
+					policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+				}
+				rule := buildL7ProxyRule(policyName, route, uint16(r.cfg.FileConfig.L7Proxy.Port), mark,
+					table.IPVersion, len(snatPolicies[policy].DestSubnet) == 0)
+				if rule != nil {
+					l7Rules = append(l7Rules, *rule)
+				}
+			}
+			table.UpdateChain(&iptables.Chain{Name: l7ProxyChain, Rules: l7Rules})
+			chainMapRules["PREROUTING"] = append([]iptables.Rule{l7ProxyJumpRule()}, chainMapRules["PREROUTING"]...)
+		}
 		for chain, rules := range chainMapRules {
 			table.InsertOrAppendRules(chain, rules)
 		}
+		// the rules are appended to follow ISTIO_OUTPUT, and the refresh moves them back to the
+		// end if Istio appends its jump later
+		table.AppendRules("OUTPUT", buildIstioOutputRules(r.cfg.FileConfig.Istio, r.markChain.name))
 	}
 
 	// add forward rules for replay packet on gateway node, which should be enabled for spiderpool
@@ -212,36 +494,91 @@ func (r *policeReconciler) initApplyPolicy() error {
 	//}
 
 	for _, table := range r.mangleTables {
-		rules := make([]iptables.Rule, 0)
-		for policy, val := range unSnatPolicies {
-			node := new(egressv1.EgressTunnel)
-			err := r.client.Get(context.Background(), types.NamespacedName{Name: val.NodeName}, node)
-			if err != nil {
-				r.log.Error(err, "failed to get egress tunnel, skip building rule of policy")
-				continue
+		rules := buildBypassRules(table.IPVersion)
+		if hairpinEnabled(hairpin) {
+			rules = append(rules, buildHairpinSkipRule(table.IPVersion))
+		}
+		rules = append(rules, buildIstioSkipRules(r.cfg.FileConfig.Istio)...)
+		// the cluster default policies follow the others, and only mark the traffic not
+		// marked by them
+		for _, clusterDefault := range []bool{false, true} {
+			// the local Pods of the policies on the gateway node precede the policies on other nodes
+			for _, policy := range sortedPolicies(snatPolicies) {
+				val := snatPolicies[policy]
+				if clusterDefaults[policy] != clusterDefault {
+					continue
+				}
+				policyName := policy.Name
+				if policy.Namespace != "" {
+					policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+				}
+				rules = append(rules, buildLocalRule(policyName, table.IPVersion, len(val.DestSubnet) == 0))
+			}
+			for _, policy := range sortedPolicies(unSnatPolicies) {
+				val := unSnatPolicies[policy]
+				if clusterDefaults[policy] != clusterDefault {
+					continue
+				}
+				node := new(egressv1.EgressTunnel)
+				err := r.client.Get(context.Background(), types.NamespacedName{Name: val.NodeName}, node)
+				if err != nil {
+					r.log.Error(err, "failed to get egress tunnel, skip building rule of policy")
+					continue
+				}
+				policyName := policy.Name
+				if policy.Namespace != "" {
+					policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+				}
+
+				nodeMark := node.Status.Mark
+				if val.Gateway != "" {
+					item, ok := node.Status.GetNetwork(val.Gateway)
+					if !ok {
+						r.log.Info("tunnel network of egress tunnel not ready, skip building rule of policy",
+							"tunnel", node.Name, "gateway", val.Gateway)
+						continue
+					}
+					nodeMark = item.Mark
+				}
+				mark, err := parseMark(nodeMark)
+				if err != nil {
+					return err
+				}
+
+				isIgnoreInternalCIDR := false
+				if len(val.DestSubnet) <= 0 {
+					isIgnoreInternalCIDR = true
+				}
+
+				rule := r.buildPolicyRule(policyName, mark, markSpace.MarkMask(), table.IPVersion, isIgnoreInternalCIDR)
+				if clusterDefault {
+					rule.Match = rule.Match.MarkClear(markSpace.MarkMask())
+				}
+				rules = append(rules, *rule)
 			}
+		}
+		r.markChain.Stage(table, rules)
+
+		shadowRules := make([]iptables.Rule, 0, len(shadowPolicies))
+		for _, policy := range sortedPolicies(shadowPolicies) {
+			val := shadowPolicies[policy]
 			policyName := policy.Name
 			if policy.Namespace != "" {
 				policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
 			}
+			shadowRules = append(shadowRules, buildShadowRule(policyName, table.IPVersion, len(val.DestSubnet) == 0))
+		}
+		table.UpdateChain(&iptables.Chain{Name: EgressShadowChain, Rules: shadowRules})
 
-			mark, err := parseMark(node.Status.Mark)
-			if err != nil {
-				return err
-			}
-
-			isIgnoreInternalCIDR := false
-			if len(val.DestSubnet) <= 0 {
-				isIgnoreInternalCIDR = true
+		icmpRules := make([]iptables.Rule, 0, len(snatPolicies))
+		for _, policy := range sortedPolicies(snatPolicies) {
+			policyName := policy.Name
+			if policy.Namespace != "" {
+				policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
 			}
-
-			rule := r.buildPolicyRule(policyName, mark, table.IPVersion, isIgnoreInternalCIDR)
-			rules = append(rules, *rule)
+			icmpRules = append(icmpRules, buildICMPErrorRule(policyName, table.IPVersion))
 		}
-		table.UpdateChain(&iptables.Chain{
-			Name:  "EGRESSGATEWAY-MARK-REQUEST",
-			Rules: rules,
-		})
+		table.UpdateChain(&iptables.Chain{Name: EgressICMPErrorChain, Rules: icmpRules})
 		table.UpdateChain(&iptables.Chain{
 			Name: "EGRESSGATEWAY-REPLY-ROUTING",
 			Rules: buildPreroutingReplyRouting(r.cfg.FileConfig.VXLAN.Name,
@@ -249,27 +586,38 @@ func (r *policeReconciler) initApplyPolicy() error {
 		})
 	}
 
+	proxyRoutes, proxyEIPs := buildProxyRoutes(snatPolicies, r.proxyMark())
 	for _, table := range r.natTables {
-		rules := make([]iptables.Rule, 0)
-		for policy, val := range snatPolicies {
-			policyName := policy.Name
-			if policy.Namespace != "" {
-				policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
-			}
-
-			isIgnoreInternalCIDR := false
-			if len(val.DestSubnet) <= 0 {
-				isIgnoreInternalCIDR = true
-			}
-
-			rule := buildEipRule(policyName, val.IP, table.IPVersion, isIgnoreInternalCIDR)
-			if rule != nil {
-				rules = append(rules, *rule)
+		rules := buildSnatChainRules(snatPolicies, standbyPolicies, excluded, clusterDefaults,
+			r.cfg.FileConfig.ConnectionLog.Group, table.IPVersion)
+		r.snatChain.Stage(table, rules)
+		verifyMark := r.verifyMark()
+		if verifyMark != 0 {
+			table.UpdateChain(&iptables.Chain{Name: eipVerifyChain, Rules: buildVerifyRules(r.verifyProbes, table.IPVersion)})
+		}
+		chainMapRules := buildNatStaticRule(markSpace, verifyMark, r.proxyMark())
+		if hairpinEnabled(hairpin) {
+			table.UpdateChain(&iptables.Chain{Name: EgressHairpinChain, Rules: buildHairpinRules(hairpin, table.Name, table.IPVersion)})
+			chainMapRules["PREROUTING"] = []iptables.Rule{hairpinJumpRule()}
+		}
+		if r.cfg.FileConfig.ProxyProtocol.Enable {
+			redirectRules := make([]iptables.Rule, 0, len(proxyRoutes))
+			for _, policy := range sortedPolicies(proxyRoutes) {
+				route := proxyRoutes[policy]
+				policyName := policy.Name
+				if policy.Namespace != "" {
+					policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+				}
+				rule := buildProxyRedirectRule(policyName, route, uint16(r.cfg.FileConfig.ProxyProtocol.Port),
+					table.IPVersion, len(snatPolicies[policy].DestSubnet) == 0)
+				if rule != nil {
+					redirectRules = append(redirectRules, *rule)
+				}
 			}
+			table.UpdateChain(&iptables.Chain{Name: proxyProtocolChain, Rules: redirectRules})
+			table.UpdateChain(&iptables.Chain{Name: proxyEIPChain, Rules: buildProxyEIPRules(proxyEIPs, table.IPVersion)})
+			chainMapRules["PREROUTING"] = append(chainMapRules["PREROUTING"], proxyProtocolJumpRule())
 		}
-
-		table.UpdateChain(&iptables.Chain{Name: "EGRESSGATEWAY-SNAT-EIP", Rules: rules})
-		chainMapRules := buildNatStaticRule(baseMark)
 		for chain, rules := range chainMapRules {
 			table.InsertOrAppendRules(chain, rules)
 		}
@@ -283,6 +631,118 @@ func (r *policeReconciler) initApplyPolicy() error {
 			return fmt.Errorf("failed to apply rule %v: %v", table.Name, err)
 		}
 	}
+	// the new rules staged in the standby chains take effect by the swaps after they're applied
+	markSwapped := r.markChain.Swap()
+	snatSwapped := r.snatChain.Swap()
+	if markSwapped || snatSwapped {
+		for _, table := range allTables {
+			if _, err := table.Apply(); err != nil {
+				return fmt.Errorf("failed to swap rule %v: %v", table.Name, err)
+			}
+		}
+	}
+
+	appliedEIPs := make(map[egressv1.Policy]IP, len(snatPolicies))
+	for policy, val := range snatPolicies {
+		appliedEIPs[policy] = val.IP
+	}
+	if r.cfg.FileConfig.FlushConntrackOnEIPChange {
+		r.flushStaleConntrack(appliedEIPs)
+	}
+	r.appliedEIPs = appliedEIPs
+
+	standbyIPs := buildStandbyIPs(standbyPolicies, standbyGateways)
+	r.standbyIPs.Range(func(gateway string, _ []string) bool {
+		if _, ok := standbyIPs[gateway]; !ok {
+			r.standbyIPs.Delete(gateway)
+		}
+		return true
+	})
+	for gateway, ips := range standbyIPs {
+		r.standbyIPs.Store(gateway, ips)
+	}
+
+	if r.boot != nil {
+		if err := r.persistBoot(snatPolicies, unSnatPolicies); err != nil {
+			r.log.Error(err, "failed to persist the fail-closed rules on the host")
+		}
+	}
+
+	// the counters of the policy are dropped once it's not in the Shadow mode
+	r.shadowPolicies.Range(func(policy egressv1.Policy, _ bool) bool {
+		if _, ok := shadowPolicies[policy]; ok {
+			return true
+		}
+		r.shadowPolicies.Delete(policy)
+		_ = buildShadowIPSetNames(policy.Namespace, policy.Name, true, true).Map(func(set SetName) error {
+			if err := r.ipset.DestroySet(set.Name); err != nil && !ipset.IsNotFoundError(err) {
+				r.log.Error(err, "clean ipset", "ipset", set.Name)
+				return nil
+			}
+			r.ipsetMap.Delete(set.Name)
+			return nil
+		})
+		return true
+	})
+	for policy, val := range shadowPolicies {
+		r.shadowPolicies.Store(policy, len(val.DestSubnet) == 0)
+	}
+	r.excludedPolicies.Range(func(policy egressv1.Policy, _ bool) bool {
+		if !excluded[policy] {
+			r.excludedPolicies.Delete(policy)
+		}
+		return true
+	})
+	for policy := range excluded {
+		r.excludedPolicies.Store(policy, true)
+	}
+	r.clusterDefaultPolicies.Range(func(policy egressv1.Policy, _ bool) bool {
+		if !clusterDefaults[policy] {
+			r.clusterDefaultPolicies.Delete(policy)
+		}
+		return true
+	})
+	for policy := range clusterDefaults {
+		r.clusterDefaultPolicies.Store(policy, true)
+	}
+	logged := make(map[string]loggedPolicy)
+	for policy, val := range snatPolicies {
+		if !loggingEnabled(val.Logging) {
+			continue
+		}
+		policyName := policy.Name
+		if policy.Namespace != "" {
+			policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+		}
+		logged[connLogPrefixOf(policyName)] = loggedPolicy{Policy: policy, Logging: *val.Logging, EIP: val.IP}
+	}
+	r.loggedPolicies.Range(func(prefix string, _ loggedPolicy) bool {
+		if _, ok := logged[prefix]; !ok {
+			r.loggedPolicies.Delete(prefix)
+		}
+		return true
+	})
+	for prefix, policy := range logged {
+		r.loggedPolicies.Store(prefix, policy)
+	}
+	r.proxyRoutes.Range(func(policy egressv1.Policy, _ proxyRoute) bool {
+		if _, ok := proxyRoutes[policy]; !ok {
+			r.proxyRoutes.Delete(policy)
+		}
+		return true
+	})
+	for policy, route := range proxyRoutes {
+		r.proxyRoutes.Store(policy, route)
+	}
+	r.l7Routes.Range(func(policy egressv1.Policy, _ l7Route) bool {
+		if _, ok := l7Routes[policy]; !ok {
+			r.l7Routes.Delete(policy)
+		}
+		return true
+	})
+	for policy, route := range l7Routes {
+		r.l7Routes.Store(policy, route)
+	}
 
 	setList, err := r.ipset.ListSets()
 	if err != nil {
@@ -316,9 +776,9 @@ func (r *policeReconciler) getPolicySubnet(ns, name string) ([]string, error) {
 	getSubnet := func(obj client.Object) []string {
 		switch obj := obj.(type) {
 		case *egressv1.EgressPolicy:
-			return obj.Spec.DestSubnet
+			return obj.DestSubnets()
 		case *egressv1.EgressClusterPolicy:
-			return obj.Spec.DestSubnet
+			return obj.DestSubnets()
 		default:
 			return nil
 		}
@@ -337,9 +797,77 @@ func (r *policeReconciler) getPolicySubnet(ns, name string) ([]string, error) {
 	return getSubnet(obj), nil
 }
 
+func (r *policeReconciler) getPolicyUseNodeIP(ns, name string) (bool, error) {
+	key := types.NamespacedName{Namespace: ns, Name: name}
+	if ns != "" {
+		obj := new(egressv1.EgressPolicy)
+		if err := r.client.Get(context.Background(), key, obj); err != nil {
+			return false, client.IgnoreNotFound(err)
+		}
+		return obj.Spec.EgressIP.UseNodeIP, nil
+	}
+	obj := new(egressv1.EgressClusterPolicy)
+	if err := r.client.Get(context.Background(), key, obj); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return obj.Spec.EgressIP.UseNodeIP, nil
+}
+
+// nodeIP returns the IP of the parent interface of the tunnel of the node, which the
+// traffic of the useNodeIP policies is SNATed with
+func (r *policeReconciler) nodeIP() (IP, error) {
+	tunnel := new(egressv1.EgressTunnel)
+	err := r.client.Get(context.Background(), types.NamespacedName{Name: r.cfg.NodeName}, tunnel)
+	if err != nil {
+		return IP{}, fmt.Errorf("failed to get egress tunnel of node: %w", err)
+	}
+	parent := tunnel.Status.Tunnel.Parent
+	return IP{V4: parent.IPv4, V6: parent.IPv6}, nil
+}
+
+// getPolicyIPFamilies returns whether the IPv4 and the IPv6 traffic of the policy is sent
+// through the gateway
+func (r *policeReconciler) getPolicyIPFamilies(ns, name string) (bool, bool, error) {
+	key := types.NamespacedName{Namespace: ns, Name: name}
+	if ns != "" {
+		obj := new(egressv1.EgressPolicy)
+		if err := r.client.Get(context.Background(), key, obj); err != nil {
+			return true, true, client.IgnoreNotFound(err)
+		}
+		ipv4, ipv6 := obj.Spec.EgressIP.IPFamilies()
+		return ipv4, ipv6, nil
+	}
+	obj := new(egressv1.EgressClusterPolicy)
+	if err := r.client.Get(context.Background(), key, obj); err != nil {
+		return true, true, client.IgnoreNotFound(err)
+	}
+	ipv4, ipv6 := obj.Spec.EgressIP.IPFamilies()
+	return ipv4, ipv6, nil
+}
+
+func (r *policeReconciler) getPolicyLimits(ns, name string) (*egressv1.ConnectionLimits, error) {
+	key := types.NamespacedName{Namespace: ns, Name: name}
+	if ns != "" {
+		obj := new(egressv1.EgressPolicy)
+		if err := r.client.Get(context.Background(), key, obj); err != nil {
+			return nil, client.IgnoreNotFound(err)
+		}
+		return obj.Spec.Limits, nil
+	}
+	obj := new(egressv1.EgressClusterPolicy)
+	if err := r.client.Get(context.Background(), key, obj); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return obj.Spec.Limits, nil
+}
+
 func (r *policeReconciler) updatePolicyIPSet(policyNs string, policyName string, isEipNodeSet bool, destSubnet []string) error {
 	// calculate src ip list
 	srcIPv4List, srcIPv6List, err := r.getPolicySrcIPs(policyNs, policyName, func(e egressv1.EgressEndpoint) bool {
+		// static endpoints are not bound to any node, their traffic may enter from every node
+		if e.Pod == "" {
+			return true
+		}
 		if e.Node == r.cfg.EnvConfig.NodeName {
 			return true
 		}
@@ -353,6 +881,18 @@ func (r *policeReconciler) updatePolicyIPSet(policyNs string, policyName string,
 		return err
 	}
 
+	// the traffic of the family not sent through the gateway isn't matched by the policy
+	ipv4, ipv6, err := r.getPolicyIPFamilies(policyNs, policyName)
+	if err != nil {
+		return err
+	}
+	if !ipv4 {
+		srcIPv4List = make([]string, 0)
+	}
+	if !ipv6 {
+		srcIPv6List = make([]string, 0)
+	}
+
 	// calculate dst ip list
 	dstIPv4List, dstIPv6List, err := r.getDstCIDR(destSubnet)
 	if err != nil {
@@ -422,11 +962,19 @@ func (r *policeReconciler) updatePolicyIPSet(policyNs string, policyName string,
 		}
 	}
 
+	if isEipNodeSet {
+		return r.updateLocalIPSet(policyNs, policyName, ipv4, ipv6)
+	}
 	return nil
 }
 
 func (r *policeReconciler) getPolicySrcIPs(policyNs, policyName string, filter func(slice egressv1.EgressEndpoint) bool) ([]string, []string, error) {
-	ctx := context.Background()
+	return listPolicySrcIPs(context.Background(), r.client, policyNs, policyName, filter)
+}
+
+// listPolicySrcIPs returns the IPs of the endpoints of the policy matching the filter
+func listPolicySrcIPs(ctx context.Context, cli client.Client, policyNs, policyName string,
+	filter func(slice egressv1.EgressEndpoint) bool) ([]string, []string, error) {
 	selector, err := metav1.LabelSelectorAsSelector(&metav1.LabelSelector{
 		MatchLabels: map[string]string{egressv1.LabelPolicyName: policyName},
 	})
@@ -440,7 +988,7 @@ func (r *policeReconciler) getPolicySrcIPs(policyNs, policyName string, filter f
 
 	if policyNs == "" {
 		eps := new(egressv1.EgressClusterEndpointSliceList)
-		err = r.client.List(ctx, eps, opt)
+		err = cli.List(ctx, eps, opt)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -456,7 +1004,7 @@ func (r *policeReconciler) getPolicySrcIPs(policyNs, policyName string, filter f
 		}
 	} else {
 		eps := new(egressv1.EgressEndpointSliceList)
-		err = r.client.List(ctx, eps, opt)
+		err = cli.List(ctx, eps, opt)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -475,7 +1023,65 @@ func (r *policeReconciler) getPolicySrcIPs(policyNs, policyName string, filter f
 	return ipv4List, ipv6List, nil
 }
 
-func buildEipRule(policyName string, eip IP, version uint8, isIgnoreInternalCIDR bool) *iptables.Rule {
+// buildSnatChainRules returns the rules of the SNAT chain, the policies are in the order of
+// their names, so the unchanged policies build the same rules and are not swapped
+func buildSnatChainRules(snatPolicies, standbyPolicies map[egressv1.Policy]*PolicyCommon,
+	excluded, clusterDefaults map[egressv1.Policy]bool, logGroup uint16, version uint8) []iptables.Rule {
+	rules := buildBypassRules(version)
+	for _, policy := range sortedPolicies(snatPolicies) {
+		if !excluded[policy] {
+			continue
+		}
+		policyName := policy.Name
+		if policy.Namespace != "" {
+			policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+		}
+		rules = append(rules, buildExcludedLocalRule(policyName, version))
+	}
+	// the traffic is SNATed by the first rule it matches, the cluster default policies follow
+	for _, clusterDefault := range []bool{false, true} {
+		for _, policy := range sortedPolicies(snatPolicies) {
+			val := snatPolicies[policy]
+			if clusterDefaults[policy] != clusterDefault {
+				continue
+			}
+			policyName := policy.Name
+			if policy.Namespace != "" {
+				policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+			}
+
+			isIgnoreInternalCIDR := false
+			if len(val.DestSubnet) <= 0 {
+				isIgnoreInternalCIDR = true
+			}
+
+			logRule := buildConnectionLogRule(policyName, val.IP, val.Logging, logGroup, version, isIgnoreInternalCIDR)
+			if logRule != nil {
+				rules = append(rules, *logRule)
+			}
+			rules = append(rules, buildDestinationEipRules(policyName, val.Destinations, val.SNAT, version)...)
+			rule := buildEipRule(policyName, val.IP, val.SNAT, version, isIgnoreInternalCIDR)
+			if rule != nil {
+				rules = append(rules, *rule)
+			}
+		}
+	}
+	// the standby rules follow the rules of the policies SNATed on the node
+	for _, policy := range sortedPolicies(standbyPolicies) {
+		val := standbyPolicies[policy]
+		policyName := policy.Name
+		if policy.Namespace != "" {
+			policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+		}
+		rule := buildStandbyEipRule(policyName, val.IP, val.SNAT, version, len(val.DestSubnet) == 0)
+		if rule != nil {
+			rules = append(rules, *rule)
+		}
+	}
+	return rules
+}
+
+func buildEipRule(policyName string, eip IP, snat *egressv1.SNAT, version uint8, isIgnoreInternalCIDR bool) *iptables.Rule {
 	if eip.V4 == "" && eip.V6 == "" {
 		return nil
 	}
@@ -488,6 +1094,9 @@ func buildEipRule(policyName string, eip IP, version uint8, isIgnoreInternalCIDR
 		ip = eip.V6
 		ignoreName = EgressClusterCIDRIPv6
 	}
+	if ip == "" {
+		return nil
+	}
 	srcName := formatIPSetName("egress-src-"+tmp, policyName)
 	dstName := formatIPSetName("egress-dst-"+tmp, policyName)
 
@@ -499,13 +1108,69 @@ func buildEipRule(policyName string, eip IP, version uint8, isIgnoreInternalCIDR
 			CTDirectionOriginal(iptables.DirectionOriginal)
 	}
 
-	action := iptables.SNATAction{ToAddr: ip}
-	rule := &iptables.Rule{Match: matchCriteria, Action: action, Comment: []string{
-		fmt.Sprintf("snat policy %s", policyName),
+	rule := &iptables.Rule{Match: matchCriteria, Action: snatAction(ip, snat), Comment: []string{
+		snatRuleCommentPrefix + policyName,
 	}}
 	return rule
 }
 
+// snatAction returns the action SNATing the traffic with the ip by the SNAT options of the gateway
+func snatAction(ip string, snat *egressv1.SNAT) iptables.Action {
+	if snat == nil {
+		return iptables.SNATAction{ToAddr: ip}
+	}
+	if snat.Mode == egressv1.SNATModeMasquerade {
+		return iptables.MasqAction{RandomFully: snat.RandomFully}
+	}
+	return iptables.SNATAction{ToAddr: ip, RandomFully: snat.RandomFully, Persistent: snat.Persistent}
+}
+
+// buildLimitRules drops the new connections of the policy beyond its limits on the gateway node
+func buildLimitRules(policyName string, limits *egressv1.ConnectionLimits, version uint8, isIgnoreInternalCIDR bool) []iptables.Rule {
+	if limits == nil || (limits.MaxConnections <= 0 && limits.NewConnectionsPerSecond <= 0) {
+		return nil
+	}
+
+	tmp := "v4-"
+	ignoreName := EgressClusterCIDRIPv4
+	if version == 6 {
+		tmp = "v6-"
+		ignoreName = EgressClusterCIDRIPv6
+	}
+	srcName := formatIPSetName("egress-src-"+tmp, policyName)
+	dstName := formatIPSetName("egress-dst-"+tmp, policyName)
+
+	match := iptables.MatchCriteria{}.SourceIPSet(srcName).DestIPSet(dstName)
+	if isIgnoreInternalCIDR {
+		match = iptables.MatchCriteria{}.SourceIPSet(srcName).NotDestIPSet(ignoreName)
+	}
+	match = match.ConntrackState("NEW")
+
+	rules := make([]iptables.Rule, 0, 2)
+	if limits.MaxConnections > 0 {
+		// mask 0 counts the connections of all the sources of the policy together
+		rules = append(rules, iptables.Rule{
+			Match:   match.ConnLimitAbove(int(limits.MaxConnections), 0),
+			Action:  iptables.DropAction{},
+			Comment: []string{fmt.Sprintf("connection limit of policy %s", policyName)},
+		})
+	}
+	if limits.NewConnectionsPerSecond > 0 {
+		burst := int(limits.Burst)
+		if burst <= 0 {
+			burst = 5
+		}
+		// the name of the hashlimit bucket is at most 15 characters
+		name := formatIPSetName("egw-"+tmp, policyName)[:15]
+		rules = append(rules, iptables.Rule{
+			Match:   match.HashLimitAbove(name, int(limits.NewConnectionsPerSecond), burst),
+			Action:  iptables.DropAction{},
+			Comment: []string{fmt.Sprintf("new connection rate limit of policy %s", policyName)},
+		})
+	}
+	return rules
+}
+
 func parseMark(mark string) (uint32, error) {
 	tmp := strings.ReplaceAll(mark, "0x", "")
 	i64, err := strconv.ParseInt(tmp, 16, 32)
@@ -516,7 +1181,7 @@ func parseMark(mark string) (uint32, error) {
 	return i32, nil
 }
 
-func (r *policeReconciler) buildPolicyRule(policyName string, mark uint32, version uint8, isIgnoreInternalCIDR bool) *iptables.Rule {
+func (r *policeReconciler) buildPolicyRule(policyName string, mark, mask uint32, version uint8, isIgnoreInternalCIDR bool) *iptables.Rule {
 	tmp := "v4-"
 	ignoreInternalCIDRName := EgressClusterCIDRIPv4
 	if version == 6 {
@@ -534,17 +1199,19 @@ func (r *policeReconciler) buildPolicyRule(policyName string, mark uint32, versi
 			CTDirectionOriginal(iptables.DirectionOriginal)
 	}
 
-	action := iptables.SetMaskedMarkAction{Mark: mark, Mask: 0xffffffff}
+	action := iptables.SetMaskedMarkAction{Mark: mark, Mask: mask}
 	rule := &iptables.Rule{Match: matchCriteria, Action: action, Comment: []string{
-		fmt.Sprintf("Set mark for EgressPolicy %s", policyName),
+		markRuleCommentPrefix + policyName,
 	}}
 	return rule
 }
 
-func buildNatStaticRule(base uint32) map[string][]iptables.Rule {
+// buildNatStaticRule builds the rules of POSTROUTING, the probes of the EIP verification
+// marked by the verifyMark jump to their SNAT rules first if the verifyMark is not 0
+func buildNatStaticRule(space markallocator.Space, verifyMark, proxyMark uint32) map[string][]iptables.Rule {
 	res := map[string][]iptables.Rule{"POSTROUTING": {
 		{
-			Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(base, 0xffffffff),
+			Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(space.Base, space.MarkMask()),
 			Action: iptables.AcceptAction{},
 			Comment: []string{
 				"Accept for egress traffic from pod going to EgressTunnel",
@@ -558,6 +1225,24 @@ func buildNatStaticRule(base uint32) map[string][]iptables.Rule {
 			},
 		},
 	}}
+	if verifyMark != 0 {
+		res["POSTROUTING"] = append([]iptables.Rule{{
+			Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(verifyMark, verifyMarkMask),
+			Action: iptables.JumpAction{Target: eipVerifyChain},
+			Comment: []string{
+				"SNAT for the probes of the EIP verification",
+			},
+		}}, res["POSTROUTING"]...)
+	}
+	if proxyMark != 0 {
+		res["POSTROUTING"] = append([]iptables.Rule{{
+			Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(proxyMark, proxyMarkMask),
+			Action: iptables.JumpAction{Target: proxyEIPChain},
+			Comment: []string{
+				"SNAT for the connections relayed with the PROXY protocol",
+			},
+		}}, res["POSTROUTING"]...)
+	}
 	return res
 }
 
@@ -725,24 +1410,54 @@ func (r *policeReconciler) ensureClusterInfoIPSet() error {
 //   - iptables/ipset
 func (r *policeReconciler) reconcileGateway(ctx context.Context, req reconcile.Request, log logr.Logger) (reconcile.Result, error) {
 	log.V(1).Info("reconciling")
-	err := r.initApplyPolicy()
-	if err != nil {
+	return r.applyPolicy(ctx, changeKey("EgressGateway", req.NamespacedName), func(ctx context.Context) error {
+		err := r.reportAppliedGeneration(ctx, req.NamespacedName, new(egressv1.EgressGateway), func(obj client.Object) *[]egressv1.NodeAppliedStatus {
+			return &obj.(*egressv1.EgressGateway).Status.AppliedNodes
+		})
+		if err != nil || !r.cfg.FileConfig.GatewayFailover.WarmStandby.Enable {
+			return err
+		}
+		return r.reportStandbyIPs(ctx, req.NamespacedName)
+	})
+}
+
+// applyPolicy applies the rules of all the policies for the change of the key, and invokes
+// the applied callback after that. The change is added to the next batch if the batching
+// is enabled, otherwise it's applied at once.
+func (r *policeReconciler) applyPolicy(ctx context.Context, key string, applied func(ctx context.Context) error) (reconcile.Result, error) {
+	if r.batcher != nil {
+		r.convergence.Defer(key)
+		r.batcher.Request(key, func(ctx context.Context) error {
+			r.convergence.Programmed(key)
+			return applied(ctx)
+		})
+		return reconcile.Result{}, nil
+	}
+	if err := r.initApplyPolicy(); err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+	if err := applied(ctx); err != nil {
 		return reconcile.Result{Requeue: true}, err
 	}
 	return reconcile.Result{}, nil
 }
 
-func buildFilterStaticRule(base uint32) map[string][]iptables.Rule {
+func buildFilterStaticRule(space markallocator.Space) map[string][]iptables.Rule {
 	res := map[string][]iptables.Rule{
 		"FORWARD": {{
-			Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(base, 0xffffffff),
+			Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(space.Base, space.MarkMask()),
 			Action: iptables.AcceptAction{},
 			Comment: []string{
 				"Accept for egress traffic from pod going to EgressTunnel",
 			},
+		}, {
+			Action: iptables.JumpAction{Target: "EGRESSGATEWAY-LIMIT"},
+			Comment: []string{
+				"Limit the connections of the egress traffic on the gateway node",
+			},
 		}},
 		"OUTPUT": {{
-			Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(base, 0xffffffff),
+			Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(space.Base, space.MarkMask()),
 			Action: iptables.AcceptAction{},
 			Comment: []string{
 				"Accept for egress traffic from pod going to EgressTunnel",
@@ -752,22 +1467,29 @@ func buildFilterStaticRule(base uint32) map[string][]iptables.Rule {
 	return res
 }
 
-func buildMangleStaticRule(base uint32,
+func buildMangleStaticRule(space markallocator.Space,
 	isEgressNode bool,
 	enableGatewayReplyRoute bool, replyMark uint32) map[string][]iptables.Rule {
 
 	forward := []iptables.Rule{
 		{
-			Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(base, 0xff000000),
-			Action: iptables.SetMaskedMarkAction{Mark: base, Mask: 0xffffffff},
+			Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(space.Base, space.GroupMask()),
+			Action: iptables.SetMaskedMarkAction{Mark: space.Base, Mask: space.MarkMask()},
 			Comment: []string{
 				"Accept for egress traffic from pod going to EgressTunnel",
 			},
 		},
+		{
+			Match:  iptables.MatchCriteria{},
+			Action: iptables.JumpAction{Target: EgressICMPErrorChain},
+			Comment: []string{
+				"Counting the ICMP errors translated back to the pods of EgressPolicy",
+			},
+		},
 	}
 
 	postrouting := []iptables.Rule{{
-		Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(base, 0xffffffff),
+		Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(space.Base, space.MarkMask()),
 		Action: iptables.AcceptAction{},
 		Comment: []string{
 			"Accept for egress traffic from pod going to EgressTunnel",
@@ -782,6 +1504,13 @@ func buildMangleStaticRule(base uint32,
 				"Checking for EgressPolicy matched traffic",
 			},
 		},
+		{
+			Match:  iptables.MatchCriteria{},
+			Action: iptables.JumpAction{Target: EgressShadowChain},
+			Comment: []string{
+				"Counting for shadow EgressPolicy matched traffic",
+			},
+		},
 	}
 
 	if isEgressNode && enableGatewayReplyRoute {
@@ -800,6 +1529,13 @@ func buildMangleStaticRule(base uint32,
 					"Checking for EgressPolicy matched traffic",
 				},
 			},
+			{
+				Match:  iptables.MatchCriteria{},
+				Action: iptables.JumpAction{Target: EgressShadowChain},
+				Comment: []string{
+					"Counting for shadow EgressPolicy matched traffic",
+				},
+			},
 		}
 		postrouting = append(postrouting, iptables.Rule{
 			Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(replyMark, 0xffffffff),
@@ -818,6 +1554,30 @@ func buildMangleStaticRule(base uint32,
 	return res
 }
 
+// buildTunnelPriorityRules sets the skb priority of the packets sent through the vxlan devices,
+// which is kept by the encapsulated packets, the devices of the tunnel networks are matched
+// by the prefix of their names
+func buildTunnelPriorityRules(vxlanName string, priority uint32) []iptables.Rule {
+	if priority == 0 {
+		return nil
+	}
+	devs := []string{"egress.+"}
+	if !strings.HasPrefix(vxlanName, "egress.") {
+		devs = append(devs, vxlanName)
+	}
+	res := make([]iptables.Rule, 0, len(devs))
+	for _, dev := range devs {
+		res = append(res, iptables.Rule{
+			Match:  iptables.MatchCriteria{}.OutInterface(dev),
+			Action: iptables.SetClassAction{Priority: priority},
+			Comment: []string{
+				"set the priority of the traffic through the EgressGateway tunnel",
+			},
+		})
+	}
+	return res
+}
+
 func buildPreroutingReplyRouting(vxlanName string, replyMark uint32) []iptables.Rule {
 	return []iptables.Rule{
 		{
@@ -835,7 +1595,9 @@ func buildPreroutingReplyRouting(vxlanName string, replyMark uint32) []iptables.
 			},
 		},
 		{
-			Match:  iptables.MatchCriteria{}.ConntrackState("ESTABLISHED"),
+			// the ICMP errors of the connections, e.g. fragmentation needed, are RELATED,
+			// they are routed back to the tunnel with the connections
+			Match:  iptables.MatchCriteria{}.ConntrackState("ESTABLISHED,RELATED"),
 			Action: iptables.RestoreConnMarkAction{RestoreMask: 0},
 			Comment: []string{
 				"label for restoring connections, rule is from the EgressGateway",
@@ -864,12 +1626,11 @@ func (r *policeReconciler) reconcilePolicy(ctx context.Context, req reconcile.Re
 
 	// delete event
 	if deleted {
-		setNames := buildIPSetNamesByPolicy(req.Namespace, req.Name, true, true)
 		log.Info("request item deleted, delete related policies")
-		_ = setNames.Map(func(set SetName) error {
-			r.removeIPSet(log, set.Name)
-			return nil
-		})
+		err := r.cleanupPolicy(ctx, req.NamespacedName, policy, &policy.Status, log)
+		if err != nil {
+			return reconcile.Result{Requeue: true}, err
+		}
 		return reconcile.Result{}, nil
 	}
 
@@ -894,15 +1655,43 @@ func (r *policeReconciler) reconcilePolicy(ctx context.Context, req reconcile.Re
 	}
 
 	flag := false
-	if nodeName == r.cfg.EnvConfig.NodeName {
+	if nodeName == r.cfg.EnvConfig.NodeName && policy.Spec.Mode != egressv1.PolicyModeShadow {
 		flag = true
 	}
 
 	// update event
-	err = r.updatePolicyIPSet(policy.Namespace, policy.Name, flag, policy.Spec.DestSubnet)
+	err = r.updatePolicyIPSet(policy.Namespace, policy.Name, flag, policy.DestSubnets())
 	if err != nil {
 		return reconcile.Result{Requeue: true}, err
 	}
+	if flag {
+		err = r.syncPolicyDestinations(policy.Namespace, policy.Name, policy.Spec.DestinationEIPs)
+		if err != nil {
+			return reconcile.Result{Requeue: true}, err
+		}
+	}
+	report := func(ctx context.Context) error {
+		return r.reportAppliedGeneration(ctx, req.NamespacedName, new(egressv1.EgressPolicy), func(obj client.Object) *[]egressv1.NodeAppliedStatus {
+			return &obj.(*egressv1.EgressPolicy).Status.AppliedNodes
+		})
+	}
+	if nodeName != "" {
+		p := egressv1.Policy{Name: policy.Name, Namespace: policy.Namespace}
+		reapply, err := r.syncShadowPolicy(p, policy.Spec.Mode, policy.DestSubnets())
+		if err != nil {
+			return reconcile.Result{Requeue: true}, err
+		}
+		if !reapply && flag {
+			reapply = r.syncLoggedPolicy(p, policy.Spec.Logging) || r.syncProxyPolicy(p, policy.Spec.ProxyProtocol) ||
+				r.syncL7Policy(p, policy.Spec.L7Proxy)
+		}
+		if reapply {
+			return r.applyPolicy(ctx, changeKey("EgressPolicy", req.NamespacedName), report)
+		}
+	}
+	if err := report(ctx); err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
 	return reconcile.Result{}, nil
 }
 
@@ -926,12 +1715,11 @@ func (r *policeReconciler) reconcileClusterPolicy(ctx context.Context, req recon
 
 	// delete event
 	if deleted {
-		setNames := buildIPSetNamesByPolicy(req.Namespace, req.Name, true, true)
 		log.Info("request item deleted, delete related policies")
-		_ = setNames.Map(func(set SetName) error {
-			r.removeIPSet(log, set.Name)
-			return nil
-		})
+		err := r.cleanupPolicy(ctx, req.NamespacedName, policy, &policy.Status, log)
+		if err != nil {
+			return reconcile.Result{Requeue: true}, err
+		}
 		return reconcile.Result{}, nil
 	}
 
@@ -956,15 +1744,53 @@ func (r *policeReconciler) reconcileClusterPolicy(ctx context.Context, req recon
 	}
 
 	flag := false
-	if nodeName == r.cfg.EnvConfig.NodeName {
+	if nodeName == r.cfg.EnvConfig.NodeName && policy.Spec.Mode != egressv1.PolicyModeShadow {
 		flag = true
 	}
 
 	// update event
-	err = r.updatePolicyIPSet(policy.Namespace, policy.Name, flag, policy.Spec.DestSubnet)
+	err = r.updatePolicyIPSet(policy.Namespace, policy.Name, flag, policy.DestSubnets())
 	if err != nil {
 		return reconcile.Result{Requeue: true}, err
 	}
+	if flag {
+		err = r.syncPolicyDestinations(policy.Namespace, policy.Name, policy.Spec.DestinationEIPs)
+		if err != nil {
+			return reconcile.Result{Requeue: true}, err
+		}
+	}
+	report := func(ctx context.Context) error {
+		return r.reportAppliedGeneration(ctx, req.NamespacedName, new(egressv1.EgressClusterPolicy), func(obj client.Object) *[]egressv1.NodeAppliedStatus {
+			return &obj.(*egressv1.EgressClusterPolicy).Status.AppliedNodes
+		})
+	}
+	if nodeName != "" {
+		p := egressv1.Policy{Name: policy.Name, Namespace: policy.Namespace}
+		reapply, err := r.syncShadowPolicy(p, policy.Spec.Mode, policy.DestSubnets())
+		if err != nil {
+			return reconcile.Result{Requeue: true}, err
+		}
+		if !reapply {
+			reapply, err = r.syncExcludedPolicy(p, policy.Spec.ExcludeNodes)
+			if err != nil {
+				return reconcile.Result{Requeue: true}, err
+			}
+		}
+		if !reapply {
+			_, applied := r.clusterDefaultPolicies.Load(p)
+			reapply = applied != policy.Spec.ClusterDefault
+		}
+		if !reapply && flag {
+			reapply = r.syncLoggedPolicy(p, policy.Spec.Logging) || r.syncProxyPolicy(p, policy.Spec.ProxyProtocol) ||
+				r.syncL7Policy(p, policy.Spec.L7Proxy)
+		}
+		if reapply {
+			return r.applyPolicy(ctx, changeKey("EgressClusterPolicy", req.NamespacedName), report)
+		}
+	}
+	if err := report(ctx); err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
 	return reconcile.Result{}, nil
 }
 
@@ -1038,15 +1864,67 @@ func (r *policeReconciler) getDstCIDR(list []string) ([]string, []string, error)
 	return ipv4List, ipv6List, nil
 }
 
-func (r *policeReconciler) removeIPSet(log logr.Logger, name string) {
-	_, ok := r.ipsetMap.Load(name)
-	if ok {
-		err := r.ipset.DestroySet(name)
-		if err != nil {
-			log.Info("failed to delete ipset", "ipset", name, "warn", err)
+// cleanupPolicy destroys the ipsets of the deleted policy, including the sets created
+// before the agent restarts. If the policy is kept by the cleanup finalizer, the node
+// is recorded in the status of the policy to acknowledge the cleanup.
+func (r *policeReconciler) cleanupPolicy(ctx context.Context, key types.NamespacedName, obj client.Object,
+	status *egressv1.EgressPolicyStatus, log logr.Logger) error {
+	setNames := buildIPSetNamesByPolicy(key.Namespace, key.Name, true, true)
+	setNames = append(setNames, buildShadowIPSetNames(key.Namespace, key.Name, true, true)...)
+	setNames = append(setNames, buildLocalIPSetNames(key.Namespace, key.Name, true, true)...)
+	r.shadowPolicies.Delete(egressv1.Policy{Name: key.Name, Namespace: key.Namespace})
+	err := setNames.Map(func(set SetName) error {
+		if err := r.ipset.DestroySet(set.Name); err != nil && !ipset.IsNotFoundError(err) {
+			return err
+		}
+		r.ipsetMap.Delete(set.Name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if obj.GetDeletionTimestamp().IsZero() || !controllerutil.ContainsFinalizer(obj, egressv1.FinalizerPolicyCleanup) {
+		return nil
+	}
+	for _, node := range status.CleanedNodes {
+		if node == r.cfg.EnvConfig.NodeName {
+			return nil
+		}
+	}
+	status.CleanedNodes = append(status.CleanedNodes, r.cfg.EnvConfig.NodeName)
+	log.Info("acknowledge the cleanup of the deleted policy")
+	err = r.client.Status().Update(ctx, obj)
+	if err != nil && !apierr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// reportAppliedGeneration records the generation of the object applied by the node in the
+// status of the object, so that users can know whether the datapath of all nodes converged.
+func (r *policeReconciler) reportAppliedGeneration(ctx context.Context, key types.NamespacedName, obj client.Object,
+	applied func(obj client.Object) *[]egressv1.NodeAppliedStatus) error {
+	var err error
+	for i := 0; i < 5; i++ {
+		if err = r.client.Get(ctx, key, obj); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if !obj.GetDeletionTimestamp().IsZero() {
+			return nil
+		}
+		if !egressv1.SetNodeAppliedGeneration(applied(obj), r.cfg.EnvConfig.NodeName, obj.GetGeneration()) {
+			return nil
+		}
+		err = r.client.Status().Update(ctx, obj)
+		if err == nil || apierr.IsNotFound(err) {
+			return nil
+		}
+		if !apierr.IsConflict(err) {
+			return err
 		}
-		r.ipsetMap.Delete(name)
 	}
+	return err
 }
 
 func (r *policeReconciler) createIPSet(log logr.Logger, set SetName) error {
@@ -1065,6 +1943,7 @@ func (r *policeReconciler) createIPSet(log logr.Logger, set SetName) error {
 			SetType:    ipset.HashNet,
 			HashFamily: set.Stack.HashFamily(),
 			Comment:    "",
+			Counters:   set.Counters,
 		}
 		err := r.ipset.CreateSet(ipSet, true)
 		if err != nil {
@@ -1076,10 +1955,12 @@ func (r *policeReconciler) createIPSet(log logr.Logger, set SetName) error {
 	return nil
 }
 
-func newPolicyController(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
+// newTables returns the iptables tables of the enabled IP families
+func newTables(cfg *config.Config, log logr.Logger) (mangleTables, natTables, filterTables []*iptables.Table, err error) {
 	iptablesCfg := cfg.FileConfig.IPTables
 	opt := iptables.Options{
-		HistoricChainPrefixes:    []string{"egw"},
+		HistoricChainPrefixes:    []string{"egw", chainPrefix},
+		HistoricHashPrefixes:     historicHashPrefixes(),
 		BackendMode:              cfg.FileConfig.IPTables.BackendMode,
 		InsertMode:               "insert",
 		RefreshInterval:          time.Second * time.Duration(iptablesCfg.RefreshIntervalSecond),
@@ -1097,47 +1978,72 @@ func newPolicyController(mgr manager.Manager, log logr.Logger, cfg *config.Confi
 		lock = iptables.NewSharedLock(iptablesCfg.LockFilePath, opt.LockTimeout, opt.LockProbeInterval)
 	}
 	opt.XTablesLock = lock
+	if cfg.HelperSocket != "" {
+		log.Info("iptables commands are run by the privileged helper", "socket", cfg.HelperSocket)
+		opt.NewCmdOverride = privilege.NewCmdFactory(cfg.HelperSocket)
+	}
 
-	mangleTables := make([]*iptables.Table, 0)
-	filterTables := make([]*iptables.Table, 0)
-	natTables := make([]*iptables.Table, 0)
+	prefix := hashPrefix(datapathVersion)
+	log.Info("iptables rules are tagged with the datapath version", "version", datapathVersion, "hashPrefix", prefix)
+
+	mangleTables = make([]*iptables.Table, 0)
+	filterTables = make([]*iptables.Table, 0)
+	natTables = make([]*iptables.Table, 0)
 	if cfg.FileConfig.EnableIPv4 {
-		mangleTable, err := iptables.NewTable("mangle", 4, "egw:", opt, log)
+		mangleTable, err := iptables.NewTable("mangle", 4, prefix, opt, log)
 		if err != nil {
-			return err
+			return nil, nil, nil, err
 		}
 		mangleTables = append(mangleTables, mangleTable)
 
-		natTable, err := iptables.NewTable("nat", 4, "egw:", opt, log)
+		natTable, err := iptables.NewTable("nat", 4, prefix, opt, log)
 		if err != nil {
-			return err
+			return nil, nil, nil, err
 		}
 		natTables = append(natTables, natTable)
 
-		filterTable, err := iptables.NewTable("filter", 4, "egw:", opt, log)
+		filterTable, err := iptables.NewTable("filter", 4, prefix, opt, log)
 		if err != nil {
-			return err
+			return nil, nil, nil, err
 		}
 		filterTables = append(filterTables, filterTable)
 	}
 	if cfg.FileConfig.EnableIPv6 {
-		mangle, err := iptables.NewTable("mangle", 6, "egw:-", opt, log)
+		mangle, err := iptables.NewTable("mangle", 6, prefix, opt, log)
 		if err != nil {
-			return err
+			return nil, nil, nil, err
 		}
 		mangleTables = append(mangleTables, mangle)
-		nat, err := iptables.NewTable("nat", 6, "egw:", opt, log)
+		nat, err := iptables.NewTable("nat", 6, prefix, opt, log)
 		if err != nil {
-			return err
+			return nil, nil, nil, err
 		}
 		natTables = append(natTables, nat)
-		filter, err := iptables.NewTable("filter", 6, "egw:", opt, log)
+		filter, err := iptables.NewTable("filter", 6, prefix, opt, log)
 		if err != nil {
-			return err
+			return nil, nil, nil, err
 		}
 		filterTables = append(filterTables, filter)
 	}
 
+	return mangleTables, natTables, filterTables, nil
+}
+
+func newPolicyController(mgr manager.Manager, log logr.Logger, cfg *config.Config, readiness *datapathReadiness) error {
+	mangleTables, natTables, filterTables, err := newTables(cfg, log)
+	if err != nil {
+		return err
+	}
+	if cfg.FileConfig.FailClosed.Enable {
+		space, err := markallocator.NewSpace(cfg.FileConfig.Mark, cfg.FileConfig.MarkMask)
+		if err != nil {
+			return err
+		}
+		if err := restoreFailClosed(filterTables, cfg.FileConfig.VXLAN.Name, space, log); err != nil {
+			return err
+		}
+	}
+
 	e := exec.New()
 	r := &policeReconciler{
 		client:       mgr.GetClient(),
@@ -1150,31 +2056,153 @@ func newPolicyController(mgr manager.Manager, log logr.Logger, cfg *config.Confi
 		natTables:    natTables,
 		ruleV4Map:    utils.NewSyncMap[string, iptables.Rule](),
 		ruleV6Map:    utils.NewSyncMap[string, iptables.Rule](),
+		readiness:    readiness,
+
+		shadowPolicies:         utils.NewSyncMap[egressv1.Policy, bool](),
+		excludedPolicies:       utils.NewSyncMap[egressv1.Policy, bool](),
+		clusterDefaultPolicies: utils.NewSyncMap[egressv1.Policy, bool](),
+		loggedPolicies:         utils.NewSyncMap[string, loggedPolicy](),
+		proxyRoutes:            utils.NewSyncMap[egressv1.Policy, proxyRoute](),
+		l7Routes:               utils.NewSyncMap[egressv1.Policy, l7Route](),
+		standbyIPs:             utils.NewSyncMap[string, []string](),
+
+		markChain: newSwapChain("EGRESSGATEWAY-MARK-REQUEST"),
+		snatChain: newSwapChain("EGRESSGATEWAY-SNAT-EIP"),
+
+		convergence: newConvergenceTracker(cfg.EnvConfig.NodeName),
+	}
+
+	if conf := cfg.FileConfig.BootPersistence; conf.Enable {
+		r.boot = newBootPersistence(conf.Dir, conf.SystemdDir, filterTables, log.WithName("boot"))
+	} else if err := removeBootPersistence(conf.Dir, conf.SystemdDir); err != nil {
+		log.Error(err, "failed to remove the boot files of the fail-closed rules")
+	}
+
+	err = ctrlmetrics.Registry.Register(&shadowCollector{
+		ipset:    r.ipset,
+		policies: r.shadowPolicies,
+		ipv4:     cfg.FileConfig.EnableIPv4,
+		ipv6:     cfg.FileConfig.EnableIPv6,
+		log:      log,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register shadow policy metrics: %w", err)
+	}
+
+	if err := ctrlmetrics.Registry.Register(histogramProgrammingSeconds); err != nil {
+		return fmt.Errorf("failed to register datapath programming metrics: %w", err)
+	}
+
+	if window := cfg.FileConfig.IPTables.BatchWindowMillis; window > 0 {
+		r.batcher = newApplyBatcher(time.Millisecond*time.Duration(window), r.initApplyPolicy, log.WithName("batch"))
+		if err := mgr.Add(r.batcher); err != nil {
+			return err
+		}
+		for _, collector := range []prometheus.Collector{histogramApplyBatchSize, counterBatchedApplies} {
+			if err := ctrlmetrics.Registry.Register(collector); err != nil {
+				return fmt.Errorf("failed to register iptables batch metrics: %w", err)
+			}
+		}
+	}
+
+	if conf := cfg.FileConfig.PolicyCounters; conf.Enable {
+		counters := &policyCounters{
+			client:   mgr.GetClient(),
+			log:      log.WithName("counters"),
+			nodeName: cfg.EnvConfig.NodeName,
+			interval: time.Second * time.Duration(conf.IntervalSecond),
+		}
+		for _, table := range mangleTables {
+			counters.mangleTables = append(counters.mangleTables, swapCounterReader{table: table, chains: []*swapChain{r.markChain}})
+		}
+		for _, table := range natTables {
+			counters.natTables = append(counters.natTables, swapCounterReader{table: table, chains: []*swapChain{r.snatChain}})
+		}
+		if err := mgr.Add(counters); err != nil {
+			return err
+		}
+		if err := ctrlmetrics.Registry.Register(counters); err != nil {
+			return fmt.Errorf("failed to register policy counters metrics: %w", err)
+		}
+	}
+
+	if conf := cfg.FileConfig.ConnectionLog; conf.Enable {
+		err := mgr.Add(&connectionLogger{
+			group:    conf.Group,
+			snapLen:  conf.SnapLen,
+			policies: r.loggedPolicies,
+			log:      log.WithName("connlog"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := addNATExporter(mgr, cfg, log.WithName("natexport")); err != nil {
+		return err
+	}
+
+	if err := addProxyRelay(mgr, cfg, log.WithName("proxy"), r); err != nil {
+		return err
+	}
+
+	if err := addL7Proxy(mgr, cfg, log.WithName("l7proxy"), r); err != nil {
+		return err
+	}
+
+	if err := addEIPVerifier(mgr, cfg, log.WithName("verify"), r); err != nil {
+		return err
+	}
+
+	if conf := cfg.FileConfig.DatapathRecord; conf.Enable {
+		if err := addDatapathRecorder(mgr, cfg, log.WithName("record"), r.ipset, mangleTables, natTables, filterTables); err != nil {
+			return err
+		}
+	}
+
+	if conf := cfg.FileConfig.Hairpin; hairpinEnabled(conf) {
+		log.Info("handle the hairpin traffic to the EIPs", "mode", conf.Mode, "behavior", conf.Behavior())
+	}
+
+	if conf := cfg.FileConfig.MetadataProtection; conf.Enable {
+		log.Info("the traffic to the metadata services is never forwarded to the gateway nodes", "cidrs", conf.CIDRs)
+	}
+	if cidrs := builtinBypassedCIDRs(cfg.FileConfig.MetadataProtection.CIDRs); len(cidrs) != 0 {
+		log.Info("the metadata services in the link-local ranges are always bypassed, metadataProtection doesn't control them",
+			"cidrs", cidrs)
 	}
 
-	c, err := controller.New("policy", mgr, controller.Options{Reconciler: r})
+	if conf := cfg.FileConfig.Istio; conf.Enable {
+		log.Info("mark the connections of the Istio proxies after ISTIO_OUTPUT", "meshPorts", conf.MeshPorts, "redirectPort", conf.RedirectPort)
+	}
+
+	if !sctpConntrackSupported("/proc/sys") {
+		log.Info("the kernel doesn't track SCTP, load the nf_conntrack_proto_sctp module for the SCTP flows to be SNATed")
+	}
+
+	c, err := controller.New("policy", mgr, controller.Options{Reconciler: egresserrors.NewReconciler("policy", r)})
 	if err != nil {
 		return err
 	}
 
 	if err := c.Watch(source.Kind(mgr.GetCache(), &egressv1.EgressGateway{}),
-		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressGateway"))); err != nil {
+		handler.EnqueueRequestsFromMapFunc(r.convergence.Observe(utils.KindToMapFlat("EgressGateway")))); err != nil {
 		return fmt.Errorf("failed to watch EgressGateway: %w", err)
 	}
 
 	if err := c.Watch(source.Kind(mgr.GetCache(), &egressv1.EgressPolicy{}),
-		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressPolicy")), policyPredicate{}); err != nil {
+		handler.EnqueueRequestsFromMapFunc(r.convergence.Observe(utils.KindToMapFlat("EgressPolicy"))), policyPredicate{}); err != nil {
 		return fmt.Errorf("failed to watch EgressPolicy: %w", err)
 	}
 
 	if err := c.Watch(source.Kind(mgr.GetCache(), &egressv1.EgressClusterPolicy{}),
-		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressClusterPolicy")), policyPredicate{}); err != nil {
+		handler.EnqueueRequestsFromMapFunc(r.convergence.Observe(utils.KindToMapFlat("EgressClusterPolicy"))), policyPredicate{}); err != nil {
 		return fmt.Errorf("failed to watch EgressClusterPolicy: %w", err)
 	}
 
 	if err := c.Watch(
 		source.Kind(mgr.GetCache(), &egressv1.EgressEndpointSlice{}),
-		handler.EnqueueRequestsFromMapFunc(enqueueEndpointSlice()),
+		handler.EnqueueRequestsFromMapFunc(r.convergence.Observe(enqueueEndpointSlice())),
 		epSlicePredicate{},
 	); err != nil {
 		return fmt.Errorf("failed to watch EgressEndpointSlice: %w", err)
@@ -1182,12 +2210,18 @@ func newPolicyController(mgr manager.Manager, log logr.Logger, cfg *config.Confi
 
 	if err := c.Watch(
 		source.Kind(mgr.GetCache(), &egressv1.EgressClusterEndpointSlice{}),
-		handler.EnqueueRequestsFromMapFunc(enqueueEndpointSlice()),
+		handler.EnqueueRequestsFromMapFunc(r.convergence.Observe(enqueueEndpointSlice())),
 		epSlicePredicate{},
 	); err != nil {
 		return fmt.Errorf("failed to watch EgressClusterEndpointSlice: %w", err)
 	}
 
+	if err := c.Watch(source.Kind(mgr.GetCache(), &corev1.Node{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("Node")),
+		nodeLabelsPredicate{name: cfg.EnvConfig.NodeName}); err != nil {
+		return fmt.Errorf("failed to watch Node: %w", err)
+	}
+
 	if err := c.Watch(source.Kind(mgr.GetCache(), &egressv1.EgressClusterInfo{}),
 		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressClusterInfo"))); err != nil {
 		return fmt.Errorf("failed to watch EgressClusterInfo: %w", err)
@@ -1223,6 +2257,8 @@ type SetName struct {
 	Name  string
 	Stack IPStack
 	Kind  IPKind
+	// Counters enables the counters of the entries
+	Counters bool
 }
 
 func (m SetNames) Map(f func(name SetName) error) error {
@@ -1268,7 +2304,10 @@ func (stack IPStack) HashFamily() string {
 
 type policyPredicate struct{}
 
-func (p policyPredicate) Create(_ event.CreateEvent) bool   { return false }
+// Create only accepts the policies being deleted, they are cleaned up after the agent restarts
+func (p policyPredicate) Create(e event.CreateEvent) bool {
+	return !e.Object.GetDeletionTimestamp().IsZero()
+}
 func (p policyPredicate) Delete(_ event.DeleteEvent) bool   { return true }
 func (p policyPredicate) Update(_ event.UpdateEvent) bool   { return true }
 func (p policyPredicate) Generic(_ event.GenericEvent) bool { return false }
@@ -0,0 +1,27 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+)
+
+func TestBuildTunnelPriorityRules(t *testing.T) {
+	assert.Empty(t, buildTunnelPriorityRules("egress.vxlan", 0))
+
+	rules := buildTunnelPriorityRules("egress.vxlan", 0x10006)
+	assert.Len(t, rules, 1)
+	assert.Equal(t, "--out-interface egress.+", rules[0].Match.Render())
+	assert.Equal(t, "--jump CLASSIFY --set-class 1:6", rules[0].Action.ToFragment(nil))
+
+	// the devices of the tunnel networks are matched along with the renamed device
+	rules = buildTunnelPriorityRules("vxlan0", 6)
+	assert.Len(t, rules, 2)
+	assert.Equal(t, "--out-interface vxlan0", rules[1].Match.Render())
+	assert.Equal(t, iptables.SetClassAction{Priority: 6}, rules[1].Action)
+}
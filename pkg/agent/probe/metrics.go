@@ -0,0 +1,55 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package probe
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	gaugePeerRTT = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "egress_tunnel_peer_rtt_seconds",
+		Help: "Average round trip time of the probe packets to the tunnel peer",
+	}, []string{"peer"})
+	gaugePeerLoss = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "egress_tunnel_peer_loss_percent",
+		Help: "Percentage of the probe packets to the tunnel peer that are lost",
+	}, []string{"peer"})
+	gaugeReturnPath = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "egress_tunnel_return_path_verified",
+		Help: "1 if the replies of the return path probes from the gateway node come back through the tunnel, 0 otherwise",
+	}, []string{"peer"})
+)
+
+func MetricCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		gaugePeerRTT,
+		gaugePeerLoss,
+		gaugeReturnPath,
+	}
+}
+
+// RecordMetrics records the probe result of the peer
+func RecordMetrics(peer string, res Result) {
+	gaugePeerRTT.WithLabelValues(peer).Set(res.RTT.Seconds())
+	gaugePeerLoss.WithLabelValues(peer).Set(float64(res.Loss))
+}
+
+// DeleteMetrics deletes the metrics of the peer which is removed
+func DeleteMetrics(peer string) {
+	gaugePeerRTT.DeleteLabelValues(peer)
+	gaugePeerLoss.DeleteLabelValues(peer)
+}
+
+// RecordReturnPath records whether the return path of the gateway node is verified
+func RecordReturnPath(peer string, verified bool) {
+	val := 0.0
+	if verified {
+		val = 1
+	}
+	gaugeReturnPath.WithLabelValues(peer).Set(val)
+}
+
+// DeleteReturnPath deletes the return path metric of the gateway node which is removed
+func DeleteReturnPath(peer string) {
+	gaugeReturnPath.DeleteLabelValues(peer)
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package probe
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mdlayher/arp"
+	"github.com/mdlayher/ndp"
+	"github.com/vishvananda/netlink"
+)
+
+// NextHop is the upstream router of a default route of the node
+type NextHop struct {
+	IP        net.IP
+	Interface string
+}
+
+// DefaultNextHops returns the next hops of the default routes of the main table,
+// including every path of the multipath default routes.
+func DefaultNextHops(family int) ([]NextHop, error) {
+	routes, err := netlink.RouteListFiltered(family, &netlink.Route{Table: 254}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes: %v", err)
+	}
+
+	res := make([]NextHop, 0)
+	add := func(gw net.IP, index int) {
+		if gw == nil {
+			return
+		}
+		link, err := netlink.LinkByIndex(index)
+		if err != nil {
+			return
+		}
+		res = append(res, NextHop{IP: gw, Interface: link.Attrs().Name})
+	}
+	for _, route := range routes {
+		if route.Dst != nil {
+			if ones, _ := route.Dst.Mask.Size(); ones != 0 {
+				continue
+			}
+		}
+		add(route.Gw, route.LinkIndex)
+		for _, path := range route.MultiPath {
+			add(path.Gw, path.LinkIndex)
+		}
+	}
+	return res, nil
+}
+
+// ProbeNextHop resolves the next hop count times, by ARP for IPv4 and by NDP for IPv6,
+// and returns the average RTT of the replied requests and the percentage lost. The
+// link layer probe detects a dead upstream even if the router drops ICMP and UDP.
+func ProbeNextHop(nh NextHop, count int, timeout time.Duration) (Result, error) {
+	res := Result{Time: time.Now()}
+	if count <= 0 {
+		return res, errors.New("probe count must be greater than 0")
+	}
+	ifi, err := net.InterfaceByName(nh.Interface)
+	if err != nil {
+		return res, err
+	}
+
+	var resolve func(deadline time.Time) error
+	if nh.IP.To4() != nil {
+		client, err := arp.Dial(ifi)
+		if err != nil {
+			return res, err
+		}
+		defer client.Close()
+		resolve = func(deadline time.Time) error {
+			if err := client.SetDeadline(deadline); err != nil {
+				return err
+			}
+			_, err := client.Resolve(nh.IP)
+			return err
+		}
+	} else {
+		conn, _, err := ndp.Dial(ifi, ndp.LinkLocal)
+		if err != nil {
+			return res, err
+		}
+		defer conn.Close()
+		resolve = func(deadline time.Time) error {
+			return solicit(conn, ifi, nh.IP, deadline)
+		}
+	}
+
+	var total time.Duration
+	received := 0
+	for seq := 0; seq < count; seq++ {
+		start := time.Now()
+		if err := resolve(start.Add(timeout)); err != nil {
+			continue
+		}
+		total += time.Since(start)
+		received++
+	}
+
+	res.Loss = (count - received) * 100 / count
+	if received > 0 {
+		res.RTT = total / time.Duration(received)
+	}
+	return res, nil
+}
+
+// solicit sends a neighbor solicitation of the IP, and waits for the advertisement
+func solicit(conn *ndp.Conn, ifi *net.Interface, ip net.IP, deadline time.Time) error {
+	group, err := ndp.SolicitedNodeMulticast(ip)
+	if err != nil {
+		return err
+	}
+	msg := &ndp.NeighborSolicitation{
+		TargetAddress: ip,
+		Options: []ndp.Option{
+			&ndp.LinkLayerAddress{Direction: ndp.Source, Addr: ifi.HardwareAddr},
+		},
+	}
+	if err := conn.WriteTo(msg, nil, group); err != nil {
+		return err
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+	for {
+		reply, _, _, err := conn.ReadFrom()
+		if err != nil {
+			return err
+		}
+		na, ok := reply.(*ndp.NeighborAdvertisement)
+		if ok && na.TargetAddress.Equal(ip) {
+			return nil
+		}
+	}
+}
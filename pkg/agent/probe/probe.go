@@ -0,0 +1,107 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package probe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+)
+
+// packetSize is the size of the probe packet: 8 bytes sequence and 8 bytes send time
+const packetSize = 16
+
+// Result is the probe result of a peer
+type Result struct {
+	RTT  time.Duration
+	Loss int
+	Time time.Time
+}
+
+// Reachable returns true if at least one probe packet is replied
+func (r Result) Reachable() bool {
+	return r.Loss < 100
+}
+
+// Serve runs an UDP echo server on the port, it returns when the context is done
+func Serve(ctx context.Context, port int) error {
+	conn, err := net.ListenPacket("udp", net.JoinHostPort("", strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	buf := make([]byte, packetSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			continue
+		}
+		if n != packetSize {
+			continue
+		}
+		_, _ = conn.WriteTo(buf[:n], addr)
+	}
+}
+
+// Probe sends count packets to the peer one by one, and returns the average RTT
+// of the replied packets and the percentage of packets lost.
+func Probe(ip net.IP, port, count int, timeout time.Duration) (Result, error) {
+	res := Result{Time: time.Now()}
+	if count <= 0 {
+		return res, errors.New("probe count must be greater than 0")
+	}
+
+	conn, err := net.Dial("udp", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+	if err != nil {
+		return res, err
+	}
+	defer conn.Close()
+
+	var total time.Duration
+	received := 0
+	req := make([]byte, packetSize)
+	reply := make([]byte, packetSize)
+	for seq := 0; seq < count; seq++ {
+		start := time.Now()
+		binary.BigEndian.PutUint64(req[:8], uint64(seq))
+		binary.BigEndian.PutUint64(req[8:], uint64(start.UnixNano()))
+		if _, err := conn.Write(req); err != nil {
+			continue
+		}
+
+		deadline := start.Add(timeout)
+		for {
+			if err := conn.SetReadDeadline(deadline); err != nil {
+				return res, err
+			}
+			n, err := conn.Read(reply)
+			if err != nil {
+				break
+			}
+			// drop the late reply of the previous packet
+			if n != packetSize || binary.BigEndian.Uint64(reply[:8]) != uint64(seq) {
+				continue
+			}
+			total += time.Since(start)
+			received++
+			break
+		}
+	}
+
+	res.Loss = (count - received) * 100 / count
+	if received > 0 {
+		res.RTT = total / time.Duration(received)
+	}
+	return res, nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package probe
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func freePort(t *testing.T) int {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestProbe(t *testing.T) {
+	port := freePort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = Serve(ctx, port)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	res, err := Probe(net.ParseIP("127.0.0.1"), port, 3, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, res.Loss)
+	assert.True(t, res.Reachable())
+	assert.Greater(t, res.RTT, time.Duration(0))
+}
+
+func TestProbeUnreachable(t *testing.T) {
+	port := freePort(t)
+
+	res, err := Probe(net.ParseIP("127.0.0.1"), port, 2, 100*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, res.Loss)
+	assert.False(t, res.Reachable())
+}
+
+func TestProbeInvalidCount(t *testing.T) {
+	_, err := Probe(net.ParseIP("127.0.0.1"), 7790, 0, time.Second)
+	assert.Error(t, err)
+}
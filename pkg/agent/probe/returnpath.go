@@ -0,0 +1,219 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package probe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// the reply of the return path probe is the request followed by the verdicts of the peer
+const (
+	verdictRequestViaTunnel byte = 1 << iota
+	verdictReplyRouteViaTunnel
+)
+
+// ReturnPathResult is the return path probe result of a peer
+type ReturnPathResult struct {
+	Result
+	// RequestViaTunnel is false if a request arrives at the peer out of the tunnel
+	RequestViaTunnel bool
+	// ReplyRouteViaTunnel is false if the peer routes a reply out of the tunnel
+	ReplyRouteViaTunnel bool
+	// ReplyViaTunnel is false if a reply arrives at the node out of the tunnel
+	ReplyViaTunnel bool
+}
+
+// ReturnPathServer replies the return path probes with whether the request arrives through
+// the tunnel, and whether the reply is routed through the tunnel
+type ReturnPathServer struct {
+	// Network is udp4 or udp6
+	Network string
+	Port    int
+	// Tunnel returns the index of the tunnel device
+	Tunnel func() (int, error)
+	// RouteIndex returns the index of the output device of the route to the ip, it's
+	// looked up by netlink if it's nil
+	RouteIndex func(ip net.IP) (int, error)
+}
+
+// Serve runs the server until the context is done
+func (s ReturnPathServer) Serve(ctx context.Context) error {
+	conn, err := listenPacket(s.Network, net.JoinHostPort("", strconv.Itoa(s.Port)))
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	routeIndex := s.RouteIndex
+	if routeIndex == nil {
+		routeIndex = netlinkRouteIndex
+	}
+
+	buf := make([]byte, packetSize+1)
+	for {
+		n, ifIndex, addr, err := conn.read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			continue
+		}
+		if n != packetSize {
+			continue
+		}
+		tunnel, err := s.Tunnel()
+		if err != nil {
+			continue
+		}
+		var verdict byte
+		if ifIndex == tunnel {
+			verdict |= verdictRequestViaTunnel
+		}
+		if udp, ok := addr.(*net.UDPAddr); ok {
+			if index, err := routeIndex(udp.IP); err == nil && index == tunnel {
+				verdict |= verdictReplyRouteViaTunnel
+			}
+		}
+		buf[packetSize] = verdict
+		_ = conn.write(buf[:packetSize+1], addr)
+	}
+}
+
+// ProbeReturnPath sends count packets from the src to the peer one by one, the tunnel is the
+// index of the tunnel device of the node. The verdicts are false if any replied packet fails.
+func ProbeReturnPath(src, dst net.IP, port, count int, timeout time.Duration, tunnel int) (ReturnPathResult, error) {
+	res := ReturnPathResult{
+		Result:              Result{Time: time.Now()},
+		RequestViaTunnel:    true,
+		ReplyRouteViaTunnel: true,
+		ReplyViaTunnel:      true,
+	}
+	if count <= 0 {
+		return res, errors.New("probe count must be greater than 0")
+	}
+
+	network := "udp4"
+	if dst.To4() == nil {
+		network = "udp6"
+	}
+	conn, err := listenPacket(network, net.JoinHostPort(src.String(), "0"))
+	if err != nil {
+		return res, err
+	}
+	defer conn.Close()
+	peer := &net.UDPAddr{IP: dst, Port: port}
+
+	var total time.Duration
+	received := 0
+	req := make([]byte, packetSize)
+	reply := make([]byte, packetSize+1)
+	for seq := 0; seq < count; seq++ {
+		start := time.Now()
+		binary.BigEndian.PutUint64(req[:8], uint64(seq))
+		binary.BigEndian.PutUint64(req[8:], uint64(start.UnixNano()))
+		if err := conn.write(req, peer); err != nil {
+			continue
+		}
+
+		deadline := start.Add(timeout)
+		for {
+			if err := conn.SetReadDeadline(deadline); err != nil {
+				return res, err
+			}
+			n, ifIndex, _, err := conn.read(reply)
+			if err != nil {
+				break
+			}
+			// drop the late reply of the previous packet
+			if n != packetSize+1 || binary.BigEndian.Uint64(reply[:8]) != uint64(seq) {
+				continue
+			}
+			total += time.Since(start)
+			received++
+			res.RequestViaTunnel = res.RequestViaTunnel && reply[packetSize]&verdictRequestViaTunnel != 0
+			res.ReplyRouteViaTunnel = res.ReplyRouteViaTunnel && reply[packetSize]&verdictReplyRouteViaTunnel != 0
+			res.ReplyViaTunnel = res.ReplyViaTunnel && ifIndex == tunnel
+			break
+		}
+	}
+
+	res.Loss = (count - received) * 100 / count
+	if received > 0 {
+		res.RTT = total / time.Duration(received)
+	}
+	return res, nil
+}
+
+func netlinkRouteIndex(ip net.IP) (int, error) {
+	routes, err := netlink.RouteGet(ip)
+	if err != nil {
+		return 0, err
+	}
+	if len(routes) == 0 {
+		return 0, errors.New("no route to " + ip.String())
+	}
+	return routes[0].LinkIndex, nil
+}
+
+// packetConn is an UDP connection reporting the interface the packets arrive on
+type packetConn struct {
+	net.PacketConn
+	read  func(b []byte) (int, int, net.Addr, error)
+	write func(b []byte, dst net.Addr) error
+}
+
+func listenPacket(network, address string) (*packetConn, error) {
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	res := &packetConn{PacketConn: conn}
+	if network == "udp6" {
+		p := ipv6.NewPacketConn(conn)
+		if err := p.SetControlMessage(ipv6.FlagInterface, true); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		res.read = func(b []byte) (int, int, net.Addr, error) {
+			n, cm, addr, err := p.ReadFrom(b)
+			if cm == nil {
+				return n, 0, addr, err
+			}
+			return n, cm.IfIndex, addr, err
+		}
+		res.write = func(b []byte, dst net.Addr) error {
+			_, err := p.WriteTo(b, nil, dst)
+			return err
+		}
+		return res, nil
+	}
+	p := ipv4.NewPacketConn(conn)
+	if err := p.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	res.read = func(b []byte) (int, int, net.Addr, error) {
+		n, cm, addr, err := p.ReadFrom(b)
+		if cm == nil {
+			return n, 0, addr, err
+		}
+		return n, cm.IfIndex, addr, err
+	}
+	res.write = func(b []byte, dst net.Addr) error {
+		_, err := p.WriteTo(b, nil, dst)
+		return err
+	}
+	return res, nil
+}
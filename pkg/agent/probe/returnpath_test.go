@@ -0,0 +1,56 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package probe
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbeReturnPath(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no loopback interface")
+	}
+	port := freePort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the reply is routed out of the tunnel on the peer
+	other := lo.Index + 100
+	server := ReturnPathServer{
+		Network:    "udp4",
+		Port:       port,
+		Tunnel:     func() (int, error) { return lo.Index, nil },
+		RouteIndex: func(ip net.IP) (int, error) { return other, nil },
+	}
+	go func() {
+		_ = server.Serve(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	ip := net.ParseIP("127.0.0.1")
+	res, err := ProbeReturnPath(ip, ip, port, 2, time.Second, lo.Index)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, res.Loss)
+	assert.True(t, res.RequestViaTunnel)
+	assert.False(t, res.ReplyRouteViaTunnel)
+	assert.True(t, res.ReplyViaTunnel)
+
+	// the reply arrives out of the tunnel on the node
+	res, err = ProbeReturnPath(ip, ip, port, 1, time.Second, other)
+	assert.NoError(t, err)
+	assert.False(t, res.ReplyViaTunnel)
+}
+
+func TestProbeReturnPathLost(t *testing.T) {
+	ip := net.ParseIP("127.0.0.1")
+	res, err := ProbeReturnPath(ip, ip, freePort(t), 2, 100*time.Millisecond, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, res.Loss)
+}
@@ -0,0 +1,425 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/exp/slices"
+	"golang.org/x/sys/unix"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
+	"github.com/spidernet-io/egressgateway/pkg/proxyprotocol"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+const (
+	// proxyProtocolChain redirects the connections of the relayed policies to the relay
+	proxyProtocolChain = "EGRESSGATEWAY-PROXY-PROTOCOL"
+	// proxyEIPChain SNATs the relayed connections with the EIPs of their policies
+	proxyEIPChain = "EGRESSGATEWAY-PROXY-EIP"
+	// proxyMarkMask matches the marks of the relayed connections, the low 8 bits number
+	// the EIPs
+	proxyMarkMask = uint32(0xffffff00)
+	// maxProxyEIPs is the most EIPs of the relayed policies on the node
+	maxProxyEIPs = 255
+
+	// soOriginalDst gets the destination of the redirected connection before the DNAT,
+	// it's SO_ORIGINAL_DST of IPv4 and IP6T_SO_ORIGINAL_DST of IPv6
+	soOriginalDst = 80
+)
+
+// proxyRoute is the relayed connections of a policy, the relayed connections are marked by
+// the marks of the IP versions to be SNATed with the EIPs of the policy
+type proxyRoute struct {
+	Policy egressv1.Policy
+	Ports  []int32
+	MarkV4 uint32
+	MarkV6 uint32
+}
+
+// proxyEIP is an EIP of the relayed policies, the relayed connections with the Mark are
+// SNATed with the IP
+type proxyEIP struct {
+	IP   string
+	Mark uint32
+	SNAT *egressv1.SNAT
+}
+
+// proxyMark returns the base mark of the relayed connections, it's 0 if the relay is disabled
+func (r *policeReconciler) proxyMark() uint32 {
+	conf := r.cfg.FileConfig.ProxyProtocol
+	if !conf.Enable {
+		return 0
+	}
+	mark, err := markallocator.Parse(conf.Mark)
+	if err != nil {
+		return 0
+	}
+	return uint32(mark)
+}
+
+func (r *policeReconciler) getPolicyProxyProtocol(ns, name string) (*egressv1.ProxyProtocol, error) {
+	key := types.NamespacedName{Namespace: ns, Name: name}
+	if ns != "" {
+		obj := new(egressv1.EgressPolicy)
+		if err := r.client.Get(context.Background(), key, obj); err != nil {
+			return nil, client.IgnoreNotFound(err)
+		}
+		return obj.Spec.ProxyProtocol, nil
+	}
+	obj := new(egressv1.EgressClusterPolicy)
+	if err := r.client.Get(context.Background(), key, obj); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return obj.Spec.ProxyProtocol, nil
+}
+
+// syncProxyPolicy returns whether the rules should be applied again, as the relayed ports of
+// the policy SNATed on the node are changed
+func (r *policeReconciler) syncProxyPolicy(policy egressv1.Policy, spec *egressv1.ProxyProtocol) bool {
+	if !r.cfg.FileConfig.ProxyProtocol.Enable {
+		return false
+	}
+	applied, ok := r.proxyRoutes.Load(policy)
+	if spec == nil || len(spec.Ports) == 0 {
+		return ok
+	}
+	return !ok || !slices.Equal(applied.Ports, spec.Ports)
+}
+
+// buildProxyRoutes numbers the EIPs of the relayed policies from the base mark, the EIPs
+// beyond maxProxyEIPs are not relayed
+func buildProxyRoutes(policies map[egressv1.Policy]*PolicyCommon, base uint32) (map[egressv1.Policy]proxyRoute, []proxyEIP) {
+	snats := make(map[string]*egressv1.SNAT)
+	for _, val := range policies {
+		if val.ProxyProtocol == nil || len(val.ProxyProtocol.Ports) == 0 {
+			continue
+		}
+		for _, ip := range []string{val.IP.V4, val.IP.V6} {
+			if ip != "" {
+				snats[ip] = val.SNAT
+			}
+		}
+	}
+	ips := make([]string, 0, len(snats))
+	for ip := range snats {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	if len(ips) > maxProxyEIPs {
+		ips = ips[:maxProxyEIPs]
+	}
+	eips := make([]proxyEIP, 0, len(ips))
+	marks := make(map[string]uint32, len(ips))
+	for i, ip := range ips {
+		marks[ip] = base | uint32(i+1)
+		eips = append(eips, proxyEIP{IP: ip, Mark: marks[ip], SNAT: snats[ip]})
+	}
+
+	routes := make(map[egressv1.Policy]proxyRoute)
+	for policy, val := range policies {
+		if val.ProxyProtocol == nil || len(val.ProxyProtocol.Ports) == 0 {
+			continue
+		}
+		route := proxyRoute{Policy: policy, Ports: val.ProxyProtocol.Ports, MarkV4: marks[val.IP.V4], MarkV6: marks[val.IP.V6]}
+		if route.MarkV4 == 0 && route.MarkV6 == 0 {
+			continue
+		}
+		routes[policy] = route
+	}
+	return routes, eips
+}
+
+// buildProxyRedirectRule redirects the new TCP connections of the policy to the relayed ports
+// to the relay, which has the EIP of the IP version
+func buildProxyRedirectRule(policyName string, route proxyRoute, relayPort uint16, version uint8, isIgnoreInternalCIDR bool) *iptables.Rule {
+	tmp := "v4-"
+	mark := route.MarkV4
+	ignoreName := EgressClusterCIDRIPv4
+	if version == 6 {
+		tmp = "v6-"
+		mark = route.MarkV6
+		ignoreName = EgressClusterCIDRIPv6
+	}
+	if mark == 0 {
+		return nil
+	}
+	srcName := formatIPSetName("egress-src-"+tmp, policyName)
+	dstName := formatIPSetName("egress-dst-"+tmp, policyName)
+
+	ports := make([]uint16, 0, len(route.Ports))
+	for _, port := range route.Ports {
+		ports = append(ports, uint16(port))
+	}
+	match := iptables.MatchCriteria{}.Protocol("tcp").SourceIPSet(srcName)
+	if isIgnoreInternalCIDR {
+		match = match.NotDestIPSet(ignoreName)
+	} else {
+		match = match.DestIPSet(dstName)
+	}
+	return &iptables.Rule{
+		Match:   match.DestPorts(ports...),
+		Action:  iptables.RedirectAction{ToPort: relayPort},
+		Comment: []string{"Relay the connections of " + policyName + " with the PROXY protocol"},
+	}
+}
+
+// buildProxyEIPRules SNATs the relayed connections of the IP version with their EIPs
+func buildProxyEIPRules(eips []proxyEIP, version uint8) []iptables.Rule {
+	rules := make([]iptables.Rule, 0)
+	for _, eip := range eips {
+		isV4 := net.ParseIP(eip.IP).To4() != nil
+		if isV4 != (version == 4) {
+			continue
+		}
+		rules = append(rules, iptables.Rule{
+			Match:   iptables.MatchCriteria{}.MarkMatchesWithMask(eip.Mark, 0xffffffff),
+			Action:  snatAction(eip.IP, eip.SNAT),
+			Comment: []string{"SNAT the relayed connections with " + eip.IP},
+		})
+	}
+	return rules
+}
+
+func proxyProtocolJumpRule() iptables.Rule {
+	return iptables.Rule{
+		Match:   iptables.MatchCriteria{},
+		Action:  iptables.JumpAction{Target: proxyProtocolChain},
+		Comment: []string{"Relay the connections of the policies with the PROXY protocol"},
+	}
+}
+
+// markControl sets the mark of the connections of the dialer, 0 doesn't mark them
+func markControl(mark uint32) func(_, _ string, c syscall.RawConn) error {
+	return func(_, _ string, c syscall.RawConn) error {
+		if mark == 0 {
+			return nil
+		}
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, int(mark))
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
+
+// proxyRelay accepts the connections redirected from the Pods of the relayed policies, and
+// connects to their original destinations with the PROXY protocol v2 header, the connections
+// are marked to be SNATed with the EIPs of the policies
+type proxyRelay struct {
+	client   client.Client
+	port     int
+	networks []string
+	routes   *utils.SyncMap[egressv1.Policy, proxyRoute]
+	log      logr.Logger
+
+	// originalDst returns the destination of the redirected connection
+	originalDst func(conn *net.TCPConn) (*net.TCPAddr, error)
+	// dial connects to the destination with the mark
+	dial func(ctx context.Context, addr string, mark uint32) (net.Conn, error)
+}
+
+func addProxyRelay(mgr manager.Manager, cfg *config.Config, log logr.Logger, r *policeReconciler) error {
+	conf := cfg.FileConfig.ProxyProtocol
+	if !conf.Enable {
+		return nil
+	}
+	networks := make([]string, 0, 2)
+	if cfg.FileConfig.EnableIPv4 {
+		networks = append(networks, "tcp4")
+	}
+	if cfg.FileConfig.EnableIPv6 {
+		networks = append(networks, "tcp6")
+	}
+	timeout := time.Second * time.Duration(conf.ConnectTimeoutSecond)
+	return mgr.Add(&proxyRelay{
+		client:      mgr.GetClient(),
+		port:        conf.Port,
+		networks:    networks,
+		routes:      r.proxyRoutes,
+		log:         log,
+		originalDst: originalDst,
+		dial: func(ctx context.Context, addr string, mark uint32) (net.Conn, error) {
+			dialer := &net.Dialer{Timeout: timeout, Control: markControl(mark)}
+			return dialer.DialContext(ctx, "tcp", addr)
+		},
+	})
+}
+
+func (p *proxyRelay) Start(ctx context.Context) error {
+	listeners := make([]net.Listener, 0, len(p.networks))
+	for _, network := range p.networks {
+		// the IPv6 listener only accepts the IPv6 connections, as the original destinations
+		// of the IPv4 ones can't be got from the IPv6 sockets
+		ln, err := (&net.ListenConfig{}).Listen(ctx, network, fmt.Sprintf(":%d", p.port))
+		if err != nil {
+			for _, item := range listeners {
+				_ = item.Close()
+			}
+			return fmt.Errorf("failed to listen the relay port %d: %w", p.port, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	p.log.Info("start relaying the connections with the PROXY protocol", "port", p.port)
+
+	wg := sync.WaitGroup{}
+	for _, ln := range listeners {
+		wg.Add(1)
+		go func(ln net.Listener) {
+			defer wg.Done()
+			p.serve(ctx, ln)
+		}(ln)
+	}
+	<-ctx.Done()
+	for _, ln := range listeners {
+		_ = ln.Close()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (p *proxyRelay) serve(ctx context.Context, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			p.log.Error(err, "failed to accept the relayed connection")
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go p.relay(ctx, conn.(*net.TCPConn))
+	}
+}
+
+// relay relays the connection redirected from the Pod of a relayed policy, the others are
+// closed, such as the connections to the relay port from elsewhere
+func (p *proxyRelay) relay(ctx context.Context, conn *net.TCPConn) {
+	defer conn.Close()
+	src := conn.RemoteAddr().(*net.TCPAddr)
+	dst, err := p.originalDst(conn)
+	if err != nil {
+		p.log.V(1).Info("failed to get the original destination", "src", src.String(), "error", err.Error())
+		return
+	}
+	route, ok, err := p.route(ctx, src.IP, dst.Port)
+	if err != nil {
+		p.log.Error(err, "failed to find the policy of the relayed connection", "src", src.String())
+		return
+	}
+	mark := route.MarkV4
+	if dst.IP.To4() == nil {
+		mark = route.MarkV6
+	}
+	if !ok || mark == 0 || dst.Port == p.port {
+		p.log.V(1).Info("close the connection not from the relayed policies", "src", src.String(), "dst", dst.String())
+		return
+	}
+	header, err := proxyprotocol.HeaderV2(src, dst)
+	if err != nil {
+		p.log.V(1).Info("failed to build the PROXY protocol header", "error", err.Error())
+		return
+	}
+
+	upstream, err := p.dial(ctx, dst.String(), mark)
+	if err != nil {
+		p.log.V(1).Info("failed to connect to the destination", "policy", route.Policy, "dst", dst.String(), "error", err.Error())
+		return
+	}
+	defer upstream.Close()
+	if _, err := upstream.Write(header); err != nil {
+		return
+	}
+	pipe(conn, upstream)
+}
+
+// route returns the route of the relayed policy of the source to the port
+func (p *proxyRelay) route(ctx context.Context, ip net.IP, port int) (proxyRoute, bool, error) {
+	return policyOfSource(ctx, p.client, p.routes, ip, func(route proxyRoute) bool {
+		return slices.Contains(route.Ports, int32(port))
+	})
+}
+
+// pipe copies the data between the connections until both directions are closed
+func pipe(a net.Conn, b net.Conn) {
+	done := make(chan struct{}, 2)
+	copyHalf := func(dst, src net.Conn) {
+		_, _ = io.Copy(dst, src)
+		if conn, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = conn.CloseWrite()
+		} else {
+			_ = dst.Close()
+		}
+		done <- struct{}{}
+	}
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	<-done
+	<-done
+}
+
+// originalDst returns the destination of the connection before it's redirected
+func originalDst(conn *net.TCPConn) (*net.TCPAddr, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	isV4 := conn.LocalAddr().(*net.TCPAddr).IP.To4() != nil
+	var addr *net.TCPAddr
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		if isV4 {
+			var sa unix.RawSockaddrInet4
+			size := uint32(unix.SizeofSockaddrInet4)
+			serr = getsockopt(int(fd), unix.SOL_IP, soOriginalDst, unsafe.Pointer(&sa), &size)
+			addr = &net.TCPAddr{IP: net.IP(append([]byte(nil), sa.Addr[:]...)), Port: networkPort(sa.Port)}
+			return
+		}
+		var sa unix.RawSockaddrInet6
+		size := uint32(unix.SizeofSockaddrInet6)
+		serr = getsockopt(int(fd), unix.SOL_IPV6, soOriginalDst, unsafe.Pointer(&sa), &size)
+		addr = &net.TCPAddr{IP: net.IP(append([]byte(nil), sa.Addr[:]...)), Port: networkPort(sa.Port)}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if serr != nil {
+		return nil, serr
+	}
+	return addr, nil
+}
+
+func getsockopt(fd, level, opt int, val unsafe.Pointer, size *uint32) error {
+	_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), uintptr(level), uintptr(opt),
+		uintptr(val), uintptr(unsafe.Pointer(size)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// networkPort returns the port in the network byte order of the raw socket address
+func networkPort(port uint16) int {
+	b := (*[2]byte)(unsafe.Pointer(&port))
+	return int(b[0])<<8 | int(b[1])
+}
@@ -0,0 +1,170 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
+	"github.com/spidernet-io/egressgateway/pkg/proxyprotocol"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+func TestBuildProxyRoutes(t *testing.T) {
+	relayed := &egressv1.ProxyProtocol{Ports: []int32{5432}}
+	policies := map[egressv1.Policy]*PolicyCommon{
+		{Name: "a", Namespace: "default"}: {IP: IP{V4: "10.6.1.22", V6: "fd00::22"}, ProxyProtocol: relayed},
+		{Name: "b", Namespace: "default"}: {IP: IP{V4: "10.6.1.21"}, ProxyProtocol: relayed},
+		{Name: "c", Namespace: "default"}: {IP: IP{V4: "10.6.1.21"}},
+	}
+
+	routes, eips := buildProxyRoutes(policies, 0x28000000)
+	assert.Equal(t, []proxyEIP{
+		{IP: "10.6.1.21", Mark: 0x28000001},
+		{IP: "10.6.1.22", Mark: 0x28000002},
+		{IP: "fd00::22", Mark: 0x28000003},
+	}, eips)
+	assert.Len(t, routes, 2)
+	assert.Equal(t, proxyRoute{
+		Policy: egressv1.Policy{Name: "a", Namespace: "default"},
+		Ports:  []int32{5432},
+		MarkV4: 0x28000002,
+		MarkV6: 0x28000003,
+	}, routes[egressv1.Policy{Name: "a", Namespace: "default"}])
+
+	rules := buildProxyEIPRules(eips, 4)
+	assert.Len(t, rules, 2)
+	assert.Equal(t, "-m mark --mark 0x28000001/0xffffffff", rules[0].Match.Render())
+	assert.Equal(t, "--jump SNAT --to-source 10.6.1.21", rules[0].Action.ToFragment(&iptables.Options{}))
+	assert.Len(t, buildProxyEIPRules(eips, 6), 1)
+}
+
+func TestBuildProxyRedirectRule(t *testing.T) {
+	route := proxyRoute{Ports: []int32{5432, 3306}, MarkV4: 0x28000001}
+
+	rule := buildProxyRedirectRule("default-a", route, 15100, 4, false)
+	assert.NotNil(t, rule)
+	assert.Equal(t, "-p tcp -m set --match-set "+formatIPSetName("egress-src-v4-", "default-a")+" src "+
+		"-m set --match-set "+formatIPSetName("egress-dst-v4-", "default-a")+" dst "+
+		"-m multiport --destination-ports 5432,3306", rule.Match.Render())
+	assert.Equal(t, "--jump REDIRECT --to-ports 15100", rule.Action.ToFragment(&iptables.Options{}))
+
+	rule = buildProxyRedirectRule("default-a", route, 15100, 4, true)
+	assert.Contains(t, rule.Match.Render(), "-m set ! --match-set "+EgressClusterCIDRIPv4+" dst")
+	// the policy has no EIP of the IP version
+	assert.Nil(t, buildProxyRedirectRule("default-a", route, 15100, 6, false))
+}
+
+func TestBuildNatStaticRuleProxyMark(t *testing.T) {
+	space, err := markallocator.NewSpace("0x26000000", "")
+	assert.NoError(t, err)
+	rules := buildNatStaticRule(space, 0, 0x28000000)["POSTROUTING"]
+	assert.Len(t, rules, 3)
+	assert.Equal(t, "-m mark --mark 0x28000000/0xffffff00", rules[0].Match.Render())
+	assert.Equal(t, iptables.JumpAction{Target: proxyEIPChain}, rules[0].Action)
+	assert.Len(t, buildNatStaticRule(space, 0, 0)["POSTROUTING"], 2)
+}
+
+func TestSyncProxyPolicy(t *testing.T) {
+	cfg := &config.Config{}
+	r := &policeReconciler{cfg: cfg, proxyRoutes: utils.NewSyncMap[egressv1.Policy, proxyRoute]()}
+	policy := egressv1.Policy{Name: "a", Namespace: "default"}
+	spec := &egressv1.ProxyProtocol{Ports: []int32{5432}}
+
+	assert.False(t, r.syncProxyPolicy(policy, spec))
+	cfg.FileConfig.ProxyProtocol.Enable = true
+	assert.True(t, r.syncProxyPolicy(policy, spec))
+	assert.False(t, r.syncProxyPolicy(policy, nil))
+	r.proxyRoutes.Store(policy, proxyRoute{Policy: policy, Ports: []int32{5432}})
+	assert.False(t, r.syncProxyPolicy(policy, spec))
+	assert.True(t, r.syncProxyPolicy(policy, &egressv1.ProxyProtocol{Ports: []int32{5432, 3306}}))
+	assert.True(t, r.syncProxyPolicy(policy, nil))
+}
+
+func TestProxyRelay(t *testing.T) {
+	upstream, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer upstream.Close()
+	upstreamAddr := upstream.Addr().(*net.TCPAddr)
+
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(&egressv1.EgressEndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{Name: "a-abc", Namespace: "default", Labels: map[string]string{egressv1.LabelPolicyName: "a"}},
+			Endpoints:  []egressv1.EgressEndpoint{{Pod: "pod1", Node: "node2", IPv4: []string{"127.0.0.1"}}},
+		}).Build()
+	routes := utils.NewSyncMap[egressv1.Policy, proxyRoute]()
+	policy := egressv1.Policy{Name: "a", Namespace: "default"}
+	routes.Store(policy, proxyRoute{Policy: policy, Ports: []int32{int32(upstreamAddr.Port)}, MarkV4: 0x28000001})
+
+	dialedMark := make(chan uint32, 1)
+	relay := &proxyRelay{
+		client: cli,
+		port:   15100,
+		routes: routes,
+		log:    logr.Discard(),
+		originalDst: func(*net.TCPConn) (*net.TCPAddr, error) {
+			return upstreamAddr, nil
+		},
+		dial: func(ctx context.Context, addr string, mark uint32) (net.Conn, error) {
+			dialedMark <- mark
+			return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		},
+	}
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go relay.serve(ctx, ln)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	assert.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("ping"))
+	assert.NoError(t, err)
+
+	server, err := upstream.Accept()
+	assert.NoError(t, err)
+	defer server.Close()
+	_ = server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header, err := proxyprotocol.HeaderV2(client.LocalAddr().(*net.TCPAddr), upstreamAddr)
+	assert.NoError(t, err)
+	buf := make([]byte, len(header)+4)
+	_, err = io.ReadFull(server, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, header, buf[:len(header)])
+	assert.Equal(t, "ping", string(buf[len(header):]))
+	assert.Equal(t, uint32(0x28000001), <-dialedMark)
+
+	_, err = server.Write([]byte("pong"))
+	assert.NoError(t, err)
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(client, reply)
+	assert.NoError(t, err)
+	assert.Equal(t, "pong", string(reply))
+
+	// the connections from the sources of no relayed policy are closed
+	routes.Delete(policy)
+	other, err := net.Dial("tcp4", ln.Addr().String())
+	assert.NoError(t, err)
+	defer other.Close()
+	_ = other.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = other.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,247 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egresserrors "github.com/spidernet-io/egressgateway/pkg/errors"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+const (
+	// datapathVXLAN is converged once the vxlan device, routes and rules are ensured
+	datapathVXLAN = "vxlan"
+	// datapathPolicy is converged once the ipsets and iptables of the policies are applied
+	datapathPolicy = "policy"
+)
+
+// datapathReadiness tracks the parts of the datapath which have converged since the
+// agent started
+type datapathReadiness struct {
+	lock    sync.Mutex
+	pending map[string]struct{}
+	ready   chan struct{}
+}
+
+func newDatapathReadiness(parts ...string) *datapathReadiness {
+	d := &datapathReadiness{pending: make(map[string]struct{}), ready: make(chan struct{})}
+	for _, part := range parts {
+		d.pending[part] = struct{}{}
+	}
+	if len(d.pending) == 0 {
+		close(d.ready)
+	}
+	return d
+}
+
+// Done marks the part converged
+func (d *datapathReadiness) Done(part string) {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, ok := d.pending[part]; !ok {
+		return
+	}
+	delete(d.pending, part)
+	if len(d.pending) == 0 {
+		close(d.ready)
+	}
+}
+
+// Ready is closed once all the parts converged
+func (d *datapathReadiness) Ready() <-chan struct{} {
+	return d.ready
+}
+
+func (d *datapathReadiness) IsReady() bool {
+	select {
+	case <-d.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// datapathCondition publishes the DatapathReady condition of the node, and sets the
+// condition of the Pods on the node which have the readiness gate of it
+type datapathCondition struct {
+	client    client.Client
+	nodeName  string
+	readiness *datapathReadiness
+	log       logr.Logger
+}
+
+func (r *datapathCondition) Start(ctx context.Context) error {
+	r.setNodeCondition(ctx, false)
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-r.readiness.Ready():
+	}
+	r.log.Info("datapath converged")
+	r.setNodeCondition(ctx, true)
+
+	pods := new(corev1.PodList)
+	if err := r.client.List(ctx, pods); err != nil {
+		r.log.Error(err, "failed to list pods")
+		return nil
+	}
+	for _, pod := range pods.Items {
+		if err := r.setPodCondition(ctx, &pod); err != nil {
+			r.log.Error(err, "failed to set pod condition", "pod", client.ObjectKeyFromObject(&pod))
+		}
+	}
+	return nil
+}
+
+func (r *datapathCondition) NeedLeaderElection() bool {
+	return false
+}
+
+// setNodeCondition retries until the condition is set or the ctx is done
+func (r *datapathCondition) setNodeCondition(ctx context.Context, ready bool) {
+	cond := datapathNodeCondition(ready, time.Now())
+	for {
+		err := r.patchConditions(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: r.nodeName}}, cond)
+		if err == nil {
+			r.log.Info("set node condition", "type", cond["type"], "status", cond["status"])
+			return
+		}
+		r.log.Error(err, "failed to set node condition")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Reconcile sets the condition of the Pod which has the readiness gate
+func (r *datapathCondition) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	pod := new(corev1.Pod)
+	if err := r.client.Get(ctx, req.NamespacedName, pod); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	if err := r.setPodCondition(ctx, pod); err != nil {
+		return reconcile.Result{}, err
+	}
+	if !r.readiness.IsReady() {
+		// the condition is set once again in case it's set before the datapath converged
+		return reconcile.Result{RequeueAfter: time.Second * 5}, nil
+	}
+	return reconcile.Result{}, nil
+}
+
+func (r *datapathCondition) setPodCondition(ctx context.Context, pod *corev1.Pod) error {
+	if pod.Spec.NodeName != r.nodeName || !hasDatapathReadinessGate(pod) {
+		return nil
+	}
+	ready := r.readiness.IsReady()
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == egressv1.ConditionDatapathReady && cond.Status == status {
+			return nil
+		}
+	}
+	return r.patchConditions(ctx, pod, datapathPodCondition(ready, time.Now()))
+}
+
+// patchConditions patches the condition to the status of the object, the conditions of
+// the Node and the Pod are merged by the type in the strategic merge patch
+func (r *datapathCondition) patchConditions(ctx context.Context, obj client.Object, cond map[string]interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []interface{}{cond},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return r.client.Status().Patch(ctx, obj, client.RawPatch(types.StrategicMergePatchType, patch))
+}
+
+func datapathNodeCondition(ready bool, now time.Time) map[string]interface{} {
+	cond := datapathPodCondition(ready, now)
+	cond["lastHeartbeatTime"] = now.UTC().Format(time.RFC3339)
+	return cond
+}
+
+func datapathPodCondition(ready bool, now time.Time) map[string]interface{} {
+	cond := map[string]interface{}{
+		"type":               egressv1.ConditionDatapathReady,
+		"status":             corev1.ConditionFalse,
+		"reason":             "Converging",
+		"message":            "the datapath of egressgateway is converging",
+		"lastTransitionTime": now.UTC().Format(time.RFC3339),
+	}
+	if ready {
+		cond["status"] = corev1.ConditionTrue
+		cond["reason"] = "Converged"
+		cond["message"] = "the datapath of egressgateway converged"
+	}
+	return cond
+}
+
+func hasDatapathReadinessGate(pod *corev1.Pod) bool {
+	for _, gate := range pod.Spec.ReadinessGates {
+		if gate.ConditionType == egressv1.ConditionDatapathReady {
+			return true
+		}
+	}
+	return false
+}
+
+// newDatapathConditionController publishes the DatapathReady condition, the Pods of
+// other nodes are not cached by the manager of the agent when it's enabled
+func newDatapathConditionController(mgr manager.Manager, cfg *config.Config, readiness *datapathReadiness, log logr.Logger) error {
+	r := &datapathCondition{
+		client:    mgr.GetClient(),
+		nodeName:  cfg.EnvConfig.NodeName,
+		readiness: readiness,
+		log:       log,
+	}
+	if err := mgr.Add(r); err != nil {
+		return err
+	}
+
+	c, err := controller.New("datapathCondition", mgr, controller.Options{Reconciler: egresserrors.NewReconciler("datapathCondition", r)})
+	if err != nil {
+		return err
+	}
+	if err := c.Watch(source.Kind(mgr.GetCache(), &corev1.Pod{}), &handler.EnqueueRequestForObject{},
+		predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			pod, ok := obj.(*corev1.Pod)
+			return ok && hasDatapathReadinessGate(pod)
+		})); err != nil {
+		return fmt.Errorf("failed to watch Pod: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestDatapathReadiness(t *testing.T) {
+	d := newDatapathReadiness(datapathVXLAN, datapathPolicy)
+	assert.False(t, d.IsReady())
+	d.Done(datapathVXLAN)
+	d.Done(datapathVXLAN)
+	assert.False(t, d.IsReady())
+	d.Done(datapathPolicy)
+	assert.True(t, d.IsReady())
+
+	// the converged datapath is never converging again
+	d.Done(datapathPolicy)
+	assert.True(t, d.IsReady())
+
+	var nilReadiness *datapathReadiness
+	nilReadiness.Done(datapathVXLAN)
+}
+
+func TestDatapathCondition(t *testing.T) {
+	ctx := context.Background()
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{{
+			Type: corev1.NodeReady, Status: corev1.ConditionTrue,
+		}}},
+	}
+	gated := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "gated", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			NodeName:       "node1",
+			ReadinessGates: []corev1.PodReadinessGate{{ConditionType: egressv1.ConditionDatapathReady}},
+		},
+	}
+	other := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"},
+		Spec:       corev1.PodSpec{NodeName: "node1"},
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(node, gated, other).
+		WithStatusSubresource(node, gated, other).
+		Build()
+
+	readiness := newDatapathReadiness(datapathVXLAN)
+	r := &datapathCondition{client: cli, nodeName: "node1", readiness: readiness, log: logr.Discard()}
+
+	podCondition := func(pod *corev1.Pod) corev1.ConditionStatus {
+		assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(pod), pod))
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == egressv1.ConditionDatapathReady {
+				return cond.Status
+			}
+		}
+		return ""
+	}
+	nodeCondition := func() corev1.ConditionStatus {
+		assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(node), node))
+		assert.Len(t, node.Status.Conditions, 2)
+		for _, cond := range node.Status.Conditions {
+			if cond.Type == egressv1.ConditionDatapathReady {
+				return cond.Status
+			}
+		}
+		return ""
+	}
+
+	// the condition is False until the datapath converged
+	r.setNodeCondition(ctx, false)
+	assert.Equal(t, corev1.ConditionFalse, nodeCondition())
+	res, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "gated"}})
+	assert.NoError(t, err)
+	assert.NotZero(t, res.RequeueAfter)
+	assert.Equal(t, corev1.ConditionFalse, podCondition(gated))
+
+	readiness.Done(datapathVXLAN)
+	assert.NoError(t, r.Start(ctx))
+	assert.Equal(t, corev1.ConditionTrue, nodeCondition())
+	assert.Equal(t, corev1.ConditionTrue, podCondition(gated))
+
+	// the Pods without the readiness gate are not touched
+	assert.Equal(t, corev1.ConditionStatus(""), podCondition(other))
+}
@@ -0,0 +1,303 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/route"
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/ipset"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
+)
+
+// ruleReader reads the normalized rules of a table, it's *iptables.Table
+type ruleReader interface {
+	ReadRules() ([]string, error)
+}
+
+// recordTable is an iptables table whose rules are recorded
+type recordTable struct {
+	name      string
+	ipVersion uint8
+	reader    ruleReader
+}
+
+// datapathRecorder records the datapath programmed by the agent in the EgressNodeDatapath
+// of the node every interval: the iptables rules, the ip rules of the marks, the routes
+// and the ipsets. The status is only updated when the hash of the datapath is changed,
+// so the record is stable for the GitOps diffing until the datapath drifts.
+type datapathRecorder struct {
+	client   client.Client
+	log      logr.Logger
+	nodeName string
+	interval time.Duration
+	maxLines int
+	tables   []recordTable
+	ipset    ipset.Interface
+	space    markallocator.Space
+	// replyMark is the mark of the reply route on the gateway node, 0 if it's disabled
+	replyMark int
+	families  []int
+
+	listRules  func(family int) ([]netlink.Rule, error)
+	listRoutes func(family int) ([]netlink.Route, error)
+	linkName   func(index int) string
+}
+
+func (d *datapathRecorder) Start(ctx context.Context) error {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := d.record(ctx); err != nil {
+				d.log.Error(err, "failed to record the datapath of the node")
+			}
+		}
+	}
+}
+
+func (d *datapathRecorder) record(ctx context.Context) error {
+	sections, err := d.sections()
+	if err != nil {
+		return err
+	}
+	return d.updateStatus(ctx, sections)
+}
+
+// sections reads the datapath of the node, each section is normalized and hashed
+func (d *datapathRecorder) sections() ([]egressv1.DatapathSection, error) {
+	res := make([]egressv1.DatapathSection, 0)
+	for _, table := range d.tables {
+		lines, err := table.reader.ReadRules()
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("iptables/ipv%d/%s", table.ipVersion, table.name)
+		res = append(res, newDatapathSection(name, lines, d.maxLines))
+	}
+
+	for _, family := range d.families {
+		version := 4
+		if family == netlink.FAMILY_V6 {
+			version = 6
+		}
+		rules, err := d.listRules(family)
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("rules/ipv%d", version)
+		res = append(res, newDatapathSection(name, ruleLines(rules, d.space, d.replyMark), d.maxLines))
+
+		routes, err := d.listRoutes(family)
+		if err != nil {
+			return nil, err
+		}
+		name = fmt.Sprintf("routes/ipv%d", version)
+		res = append(res, newDatapathSection(name, routeLines(routes, d.linkName), d.maxLines))
+	}
+
+	lines, err := d.ipsetLines()
+	if err != nil {
+		return nil, err
+	}
+	res = append(res, newDatapathSection("ipsets", lines, d.maxLines))
+	return res, nil
+}
+
+// ipsetLines returns the entries of the ipsets of the agent, one line for each entry
+func (d *datapathRecorder) ipsetLines() ([]string, error) {
+	names, err := d.ipset.ListSets()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	res := make([]string, 0)
+	for _, name := range names {
+		if !strings.HasPrefix(name, "egress-") {
+			continue
+		}
+		entries, err := d.ipset.ListEntries(name)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(entries)
+		for _, entry := range entries {
+			res = append(res, name+" "+entry)
+		}
+	}
+	return res, nil
+}
+
+// ruleLines returns the ip rules of the marks of the agent, and the rule of the reply mark
+func ruleLines(rules []netlink.Rule, space markallocator.Space, replyMark int) []string {
+	res := make([]string, 0)
+	for _, rule := range rules {
+		if !space.InRange(rule.Mark) && (replyMark == 0 || rule.Mark != replyMark) {
+			continue
+		}
+		res = append(res, fmt.Sprintf("fwmark %#x/%#x lookup %d", rule.Mark, uint32(rule.Mask), rule.Table))
+	}
+	sort.Strings(res)
+	return res
+}
+
+// routeLines returns the routes created by the agent
+func routeLines(routes []netlink.Route, linkName func(index int) string) []string {
+	res := make([]string, 0)
+	for _, item := range routes {
+		if item.Protocol != route.Protocol {
+			continue
+		}
+		dst := "default"
+		if item.Dst != nil {
+			dst = item.Dst.String()
+		}
+		line := fmt.Sprintf("table %d %s", item.Table, dst)
+		if item.Gw != nil {
+			line += " via " + item.Gw.String()
+		}
+		res = append(res, line+" dev "+linkName(item.LinkIndex))
+	}
+	sort.Strings(res)
+	return res
+}
+
+// newDatapathSection hashes the lines, and keeps at most max lines in the summary
+func newDatapathSection(name string, lines []string, max int) egressv1.DatapathSection {
+	res := egressv1.DatapathSection{
+		Name:  name,
+		Hash:  hashLines(lines),
+		Count: len(lines),
+	}
+	if len(lines) > max {
+		lines = lines[:max]
+		res.Truncated = true
+	}
+	if len(lines) != 0 {
+		res.Summary = lines
+	}
+	return res
+}
+
+func hashLines(lines []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// datapathHash returns the hash of all the sections
+func datapathHash(sections []egressv1.DatapathSection) string {
+	lines := make([]string, 0, len(sections))
+	for _, item := range sections {
+		lines = append(lines, item.Name+" "+item.Hash)
+	}
+	return hashLines(lines)
+}
+
+// updateStatus creates the EgressNodeDatapath of the node owned by the node, and updates
+// the status if the datapath is changed
+func (d *datapathRecorder) updateStatus(ctx context.Context, sections []egressv1.DatapathSection) error {
+	hash := datapathHash(sections)
+	obj := new(egressv1.EgressNodeDatapath)
+	err := d.client.Get(ctx, types.NamespacedName{Name: d.nodeName}, obj)
+	if apierr.IsNotFound(err) {
+		node := new(corev1.Node)
+		if err := d.client.Get(ctx, types.NamespacedName{Name: d.nodeName}, node); err != nil {
+			return err
+		}
+		obj = &egressv1.EgressNodeDatapath{ObjectMeta: metav1.ObjectMeta{
+			Name: d.nodeName,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Node",
+				Name:       node.Name,
+				UID:        node.UID,
+			}},
+		}}
+		err = d.client.Create(ctx, obj)
+	}
+	if err != nil {
+		return err
+	}
+
+	if obj.Status.Hash == hash && obj.Status.DatapathVersion == datapathVersion {
+		return nil
+	}
+	d.log.Info("datapath of the node is changed", "hash", hash, "previous", obj.Status.Hash)
+	obj.Status = egressv1.EgressNodeDatapathStatus{
+		DatapathVersion: datapathVersion,
+		Hash:            hash,
+		UpdateTime:      metav1.Now(),
+		Sections:        sections,
+	}
+	return d.client.Status().Update(ctx, obj)
+}
+
+func addDatapathRecorder(mgr manager.Manager, cfg *config.Config, log logr.Logger, sets ipset.Interface, tableLists ...[]*iptables.Table) error {
+	space, err := markallocator.NewSpace(cfg.FileConfig.Mark, cfg.FileConfig.MarkMask)
+	if err != nil {
+		return err
+	}
+	d := &datapathRecorder{
+		client:     mgr.GetClient(),
+		log:        log,
+		nodeName:   cfg.EnvConfig.NodeName,
+		interval:   time.Second * time.Duration(cfg.FileConfig.DatapathRecord.IntervalSecond),
+		maxLines:   cfg.FileConfig.DatapathRecord.MaxSummaryLines,
+		ipset:      sets,
+		space:      space,
+		listRules:  netlink.RuleList,
+		listRoutes: listAllRoutes,
+		linkName:   linkName,
+	}
+	if cfg.FileConfig.EnableGatewayReplyRoute {
+		d.replyMark = cfg.FileConfig.GatewayReplyRouteMark
+	}
+	if cfg.FileConfig.EnableIPv4 {
+		d.families = append(d.families, netlink.FAMILY_V4)
+	}
+	if cfg.FileConfig.EnableIPv6 {
+		d.families = append(d.families, netlink.FAMILY_V6)
+	}
+	for _, list := range tableLists {
+		for _, table := range list {
+			d.tables = append(d.tables, recordTable{name: table.Name, ipVersion: table.IPVersion, reader: table})
+		}
+	}
+	return mgr.Add(d)
+}
+
+func listAllRoutes(family int) ([]netlink.Route, error) {
+	filter := &netlink.Route{Table: unix.RT_TABLE_UNSPEC}
+	return netlink.RouteListFiltered(family, filter, netlink.RT_FILTER_TABLE)
+}
+
+func linkName(index int) string {
+	link, err := netlink.LinkByIndex(index)
+	if err != nil {
+		return fmt.Sprintf("if%d", index)
+	}
+	return link.Attrs().Name
+}
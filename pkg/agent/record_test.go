@@ -0,0 +1,108 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	k8ssets "k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/route"
+	"github.com/spidernet-io/egressgateway/pkg/ipset"
+	ipsettest "github.com/spidernet-io/egressgateway/pkg/ipset/testing"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+type fakeRuleReader []string
+
+func (f *fakeRuleReader) ReadRules() ([]string, error) {
+	return *f, nil
+}
+
+func TestDatapathRecorder(t *testing.T) {
+	ctx := context.Background()
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid1"}}
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(node).
+		WithStatusSubresource(&egressv1.EgressNodeDatapath{}).
+		Build()
+
+	space, err := markallocator.NewSpace("0x26000000", "")
+	assert.NoError(t, err)
+	mangle := &fakeRuleReader{"-A PREROUTING -j EGRESSGATEWAY-MARK-REQUEST"}
+	sets := ipsettest.NewFake("v7.1")
+	for name, entries := range map[string][]string{
+		"egress-src-v4-abc":  {"10.21.0.2", "10.21.0.1"},
+		"cilium_node_set_v4": {"10.6.1.21"},
+	} {
+		sets.Sets[name] = &ipset.IPSet{Name: name}
+		sets.Entries[name] = k8ssets.New[string](entries...)
+	}
+	d := &datapathRecorder{
+		client:    cli,
+		log:       logr.Discard(),
+		nodeName:  "node1",
+		maxLines:  1,
+		tables:    []recordTable{{name: "mangle", ipVersion: 4, reader: mangle}},
+		ipset:     sets,
+		space:     space,
+		replyMark: 39,
+		families:  []int{netlink.FAMILY_V4},
+		listRules: func(family int) ([]netlink.Rule, error) {
+			return []netlink.Rule{
+				{Mark: 0x26000001, Mask: -1, Table: 0x26000001},
+				{Mark: 39, Mask: -1, Table: 600},
+				{Mark: 0x200, Mask: 0xf00, Table: 2004},
+			}, nil
+		},
+		listRoutes: func(family int) ([]netlink.Route, error) {
+			return []netlink.Route{
+				{Table: 0x26000001, Gw: net.ParseIP("172.31.0.2"), LinkIndex: 10, Protocol: route.Protocol},
+				{Table: 254, Gw: net.ParseIP("10.6.0.1"), LinkIndex: 2},
+			}, nil
+		},
+		linkName: func(index int) string { return fmt.Sprintf("if%d", index) },
+	}
+
+	assert.NoError(t, d.record(ctx))
+	obj := new(egressv1.EgressNodeDatapath)
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Name: "node1"}, obj))
+	assert.Equal(t, types.UID("uid1"), obj.OwnerReferences[0].UID)
+	assert.Equal(t, datapathVersion, obj.Status.DatapathVersion)
+	assert.Equal(t, []egressv1.DatapathSection{
+		newDatapathSection("iptables/ipv4/mangle", []string{"-A PREROUTING -j EGRESSGATEWAY-MARK-REQUEST"}, 1),
+		newDatapathSection("rules/ipv4", []string{"fwmark 0x26000001/0xffffffff lookup 637534209", "fwmark 0x27/0xffffffff lookup 600"}, 1),
+		newDatapathSection("routes/ipv4", []string{"table 637534209 default via 172.31.0.2 dev if10"}, 1),
+		newDatapathSection("ipsets", []string{"egress-src-v4-abc 10.21.0.1", "egress-src-v4-abc 10.21.0.2"}, 1),
+	}, obj.Status.Sections)
+	assert.True(t, obj.Status.Sections[1].Truncated)
+	assert.Equal(t, 2, obj.Status.Sections[1].Count)
+
+	// the status is kept if the datapath is not changed
+	version := obj.ResourceVersion
+	assert.NoError(t, d.record(ctx))
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Name: "node1"}, obj))
+	assert.Equal(t, version, obj.ResourceVersion)
+
+	// the drift of the datapath changes the hash
+	hash := obj.Status.Hash
+	*mangle = append(*mangle, "-A FORWARD -j ACCEPT")
+	assert.NoError(t, d.record(ctx))
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Name: "node1"}, obj))
+	assert.NotEqual(t, hash, obj.Status.Hash)
+	assert.Equal(t, 2, obj.Status.Sections[0].Count)
+}
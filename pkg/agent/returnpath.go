@@ -0,0 +1,217 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/probe"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// keepReturnPathProbe serves the return path probes of the peers, and probes the gateway
+// nodes at the interval of tunnelUpdatePeriod, the results are reported in the ReturnPath
+// condition of the EgressTunnel with the heartbeat.
+func (r *vxlanReconciler) keepReturnPathProbe(ctx context.Context) {
+	conf := r.cfg.FileConfig.GatewayFailover.ReturnPathProbe
+	network := "udp4"
+	if r.version() == 6 {
+		network = "udp6"
+	}
+	server := probe.ReturnPathServer{Network: network, Port: conf.Port, Tunnel: r.tunnelIndex}
+	go func() {
+		for {
+			err := server.Serve(ctx)
+			if err == nil {
+				return
+			}
+			r.log.Error(err, "serve return path probe", "port", conf.Port)
+			time.Sleep(time.Second)
+		}
+	}()
+
+	period := time.Second * time.Duration(r.cfg.FileConfig.GatewayFailover.TunnelUpdatePeriod)
+	t := time.NewTicker(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := r.probeReturnPaths(ctx); err != nil {
+				r.loopLog.Error(err, "probe return path")
+				continue
+			}
+			r.loopLog.Resolved("probe return path")
+		}
+	}
+}
+
+func (r *vxlanReconciler) tunnelIndex() (int, error) {
+	link, err := netlink.LinkByName(r.cfg.FileConfig.VXLAN.Name)
+	if err != nil {
+		return 0, err
+	}
+	return link.Attrs().Index, nil
+}
+
+// probeReturnPaths probes the gateway nodes holding the EIPs from the tunnel IP of the node
+func (r *vxlanReconciler) probeReturnPaths(ctx context.Context) error {
+	conf := r.cfg.FileConfig.GatewayFailover.ReturnPathProbe
+	timeout := time.Millisecond * time.Duration(conf.TimeoutMillis)
+
+	gateways := new(egressv1.EgressGatewayList)
+	if err := r.client.List(ctx, gateways); err != nil {
+		return err
+	}
+	self := r.cfg.EnvConfig.NodeName
+	src, ok := r.peerTunnelIP(self)
+	if !ok {
+		return nil
+	}
+	tunnel, err := r.tunnelIndex()
+	if err != nil {
+		return err
+	}
+
+	peers := make(map[string]net.IP)
+	for _, name := range returnPathPeers(gateways.Items, self) {
+		if ip, ok := r.peerTunnelIP(name); ok {
+			peers[name] = ip
+		}
+	}
+
+	var wg sync.WaitGroup
+	for name, ip := range peers {
+		wg.Add(1)
+		go func(name string, ip net.IP) {
+			defer wg.Done()
+			res, err := probe.ProbeReturnPath(src, ip, conf.Port, conf.Count, timeout, tunnel)
+			if err != nil {
+				r.log.Error(err, "probe return path", "peer", name, "ip", ip.String())
+				return
+			}
+			r.log.V(1).Info("probe return path", "peer", name, "loss", res.Loss,
+				"requestViaTunnel", res.RequestViaTunnel, "replyRouteViaTunnel", res.ReplyRouteViaTunnel,
+				"replyViaTunnel", res.ReplyViaTunnel)
+			r.returnPathResults.Store(name, res)
+			probe.RecordReturnPath(name, returnPathFailure(res) == "")
+		}(name, ip)
+	}
+	wg.Wait()
+
+	r.returnPathResults.Range(func(name string, _ probe.ReturnPathResult) bool {
+		if _, ok := peers[name]; !ok {
+			r.returnPathResults.Delete(name)
+			probe.DeleteReturnPath(name)
+		}
+		return true
+	})
+	return nil
+}
+
+func (r *vxlanReconciler) peerTunnelIP(name string) (net.IP, bool) {
+	peer, ok := r.peerMap.Load(name)
+	if !ok {
+		return nil, false
+	}
+	if r.version() == 4 && peer.IPv4 != nil {
+		return *peer.IPv4, true
+	}
+	if r.version() == 6 && peer.IPv6 != nil {
+		return *peer.IPv6, true
+	}
+	return nil, false
+}
+
+// returnPathPeers returns the gateway nodes holding the EIPs other than the node
+func returnPathPeers(gateways []egressv1.EgressGateway, self string) []string {
+	set := make(map[string]struct{})
+	for _, item := range gateways {
+		for _, node := range item.Status.NodeList {
+			if node.Name != self && len(node.Eips) != 0 {
+				set[node.Name] = struct{}{}
+			}
+		}
+	}
+	res := make([]string, 0, len(set))
+	for name := range set {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// returnPathFailure returns the reason of the failed return path, or empty if it's verified
+func returnPathFailure(res probe.ReturnPathResult) string {
+	switch {
+	case !res.Reachable():
+		return egressv1.ReturnPathReplyLost
+	case !res.RequestViaTunnel:
+		return egressv1.ReturnPathRequestNotViaTunnel
+	case !res.ReplyRouteViaTunnel:
+		return egressv1.ReturnPathReplyRouteNotViaTunnel
+	case !res.ReplyViaTunnel:
+		return egressv1.ReturnPathReplyNotViaTunnel
+	}
+	return ""
+}
+
+// returnPathCondition returns the ReturnPath condition by the results of the gateway nodes,
+// the reason is of the first failed gateway node, and the message names the suspect node of
+// each failure: the node itself drops the replies or misses the route to the gateway node,
+// or the gateway node misroutes the replies.
+func returnPathCondition(self string, results map[string]probe.ReturnPathResult, generation int64) metav1.Condition {
+	cond := metav1.Condition{
+		Type:               egressv1.TunnelConditionReturnPath,
+		Status:             metav1.ConditionTrue,
+		Reason:             egressv1.ReturnPathVerified,
+		Message:            "the replies from the gateway nodes come back through the tunnel",
+		ObservedGeneration: generation,
+	}
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failures := make([]string, 0)
+	for _, name := range names {
+		reason := returnPathFailure(results[name])
+		if reason == "" {
+			continue
+		}
+		suspect := self
+		if reason == egressv1.ReturnPathReplyRouteNotViaTunnel || reason == egressv1.ReturnPathReplyNotViaTunnel {
+			suspect = name
+		}
+		if len(failures) == 0 {
+			cond.Status = metav1.ConditionFalse
+			cond.Reason = reason
+		}
+		failures = append(failures, fmt.Sprintf("gateway node %s: %s, suspect node %s", name, reason, suspect))
+	}
+	if len(failures) != 0 {
+		cond.Message = strings.Join(failures, "; ")
+	}
+	return cond
+}
+
+func (r *vxlanReconciler) returnPathStatus(generation int64) metav1.Condition {
+	results := make(map[string]probe.ReturnPathResult)
+	r.returnPathResults.Range(func(name string, val probe.ReturnPathResult) bool {
+		results[name] = val
+		return true
+	})
+	return returnPathCondition(r.cfg.EnvConfig.NodeName, results, generation)
+}
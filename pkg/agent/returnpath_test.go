@@ -0,0 +1,49 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/probe"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestReturnPathPeers(t *testing.T) {
+	gateways := []egressv1.EgressGateway{
+		{Status: egressv1.EgressGatewayStatus{NodeList: []egressv1.EgressIPStatus{
+			{Name: "node1", Eips: []egressv1.Eips{{IPv4: "10.6.1.21"}}},
+			{Name: "node2"},
+			{Name: "node3", Eips: []egressv1.Eips{{IPv4: "10.6.1.22"}}},
+		}}},
+		{Status: egressv1.EgressGatewayStatus{NodeList: []egressv1.EgressIPStatus{
+			{Name: "node3", Eips: []egressv1.Eips{{IPv4: "10.6.2.21"}}},
+		}}},
+	}
+	assert.Equal(t, []string{"node3"}, returnPathPeers(gateways, "node1"))
+	assert.Equal(t, []string{"node1", "node3"}, returnPathPeers(gateways, "node2"))
+}
+
+func TestReturnPathCondition(t *testing.T) {
+	ok := probe.ReturnPathResult{RequestViaTunnel: true, ReplyRouteViaTunnel: true, ReplyViaTunnel: true}
+	misrouted := ok
+	misrouted.ReplyRouteViaTunnel = false
+	lost := ok
+	lost.Loss = 100
+
+	cond := returnPathCondition("node1", map[string]probe.ReturnPathResult{"node2": ok}, 1)
+	assert.Equal(t, metav1.ConditionTrue, cond.Status)
+	assert.Equal(t, egressv1.ReturnPathVerified, cond.Reason)
+
+	cond = returnPathCondition("node1", map[string]probe.ReturnPathResult{
+		"node2": ok, "node3": misrouted, "node4": lost,
+	}, 1)
+	assert.Equal(t, metav1.ConditionFalse, cond.Status)
+	assert.Equal(t, egressv1.ReturnPathReplyRouteNotViaTunnel, cond.Reason)
+	assert.Equal(t, "gateway node node3: ReplyRouteNotViaTunnel, suspect node node3; "+
+		"gateway node node4: ReplyLost, suspect node node1", cond.Message)
+}
@@ -8,29 +8,44 @@ import (
 
 	"github.com/go-logr/logr"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 
 	"github.com/spidernet-io/egressgateway/pkg/markallocator"
 )
 
-func NewRuleRoute(log logr.Logger) *RuleRoute {
-	return &RuleRoute{log: log}
-}
+// Protocol is the protocol of the routes created by the agent, it tells the routes
+// owned by the agent from the ones created by the others or the older versions
+const Protocol netlink.RouteProtocol = 0x45
 
-type RuleRoute struct {
-	log logr.Logger
+func NewRuleRoute(log logr.Logger, options ...func(*RuleRoute)) *RuleRoute {
+	r := &RuleRoute{log: log}
+	for _, option := range options {
+		option(r)
+	}
+	return r
 }
 
-func (r *RuleRoute) PurgeStaleRules(marks map[int]struct{}, baseMark string) error {
-	start, end, err := markallocator.RangeSize(baseMark)
-	if err != nil {
-		return err
+// WithMarkMask sets the mask of the rules of the marks of the gateway nodes, the bits
+// out of the mask are left to the other tools
+func WithMarkMask(mask uint32) func(*RuleRoute) {
+	return func(r *RuleRoute) {
+		if mask != ^uint32(0) {
+			r.markMask = int(mask)
+		}
 	}
+}
 
+type RuleRoute struct {
+	log      logr.Logger
+	markMask int
+}
+
+func (r *RuleRoute) PurgeStaleRules(marks map[int]struct{}, space markallocator.Space) error {
 	clean := func(rules []netlink.Rule, family int) error {
 		for _, rule := range rules {
 			rule.Family = family
 			if _, ok := marks[rule.Mark]; !ok {
-				if int(start) <= rule.Mark && int(end) >= rule.Mark {
+				if space.InRange(rule.Mark) {
 					err := netlink.RuleDel(&rule)
 					if err != nil {
 						return err
@@ -60,6 +75,56 @@ func (r *RuleRoute) PurgeStaleRules(marks map[int]struct{}, baseMark string) err
 	return nil
 }
 
+// Purge deletes the rules and routes created by the agent: the rules with the marks in
+// the range of the mark space or to the tables, and the routes owned by the agent or in
+// the tables of the rules.
+func (r *RuleRoute) Purge(space markallocator.Space, tables ...int) error {
+	ours := func(mark, table int) bool {
+		if space.InRange(mark) || space.InRange(table) {
+			return true
+		}
+		for _, item := range tables {
+			if item != 0 && item == table {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		rules, err := netlink.RuleList(family)
+		if err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			if !ours(rule.Mark, rule.Table) {
+				continue
+			}
+			rule.Family = family
+			r.log.Info("delete rule", "rule", rule.String())
+			if err := netlink.RuleDel(&rule); err != nil {
+				return err
+			}
+		}
+
+		routeFilter := &netlink.Route{Table: unix.RT_TABLE_UNSPEC}
+		routes, err := netlink.RouteListFiltered(family, routeFilter, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			return err
+		}
+		for _, route := range routes {
+			if route.Protocol != Protocol && !ours(0, route.Table) {
+				continue
+			}
+			r.log.Info("delete route", "route", route.String())
+			if err := netlink.RouteDel(&route); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (r *RuleRoute) Ensure(linkName string, ipv4, ipv6 *net.IP, table int, mark int) error {
 	if mark == 0 {
 		return nil
@@ -68,14 +133,14 @@ func (r *RuleRoute) Ensure(linkName string, ipv4, ipv6 *net.IP, table int, mark
 	log := r.log.WithValues("linkName", linkName, "table", table, "mark", mark)
 
 	if ipv4 != nil {
-		err := r.EnsureRule(netlink.FAMILY_V4, table, mark, log)
+		err := r.ensureRule(netlink.FAMILY_V4, table, mark, r.markMask, log)
 		if err != nil {
 			return err
 		}
 	}
 
 	if ipv6 != nil {
-		err := r.EnsureRule(netlink.FAMILY_V6, table, mark, log)
+		err := r.ensureRule(netlink.FAMILY_V6, table, mark, r.markMask, log)
 		if err != nil {
 			return err
 		}
@@ -112,7 +177,7 @@ func (r *RuleRoute) ensureRoute(link netlink.Link, ip *net.IP, family int, table
 	var find bool
 	for _, route := range routes {
 		if route.Table == table {
-			if ip == nil || route.Gw.String() != ip.String() {
+			if ip == nil || route.Gw.String() != ip.String() || route.Protocol != Protocol {
 				log.Info("delete route", "route", route.String())
 				err := netlink.RouteDel(&route)
 				if err != nil {
@@ -130,7 +195,7 @@ func (r *RuleRoute) ensureRoute(link netlink.Link, ip *net.IP, family int, table
 
 	if !find {
 		index := link.Attrs().Index
-		err = netlink.RouteAdd(&netlink.Route{LinkIndex: index, Gw: *ip, Table: table})
+		err = netlink.RouteAdd(&netlink.Route{LinkIndex: index, Gw: *ip, Table: table, Protocol: Protocol})
 		if err != nil {
 			return err
 		}
@@ -140,6 +205,12 @@ func (r *RuleRoute) ensureRoute(link netlink.Link, ip *net.IP, family int, table
 }
 
 func (r *RuleRoute) EnsureRule(family int, table int, mark int, log logr.Logger) error {
+	return r.ensureRule(family, table, mark, 0, log)
+}
+
+// ensureRule ensures the rule of the mark to the table, the mark is matched by all the
+// bits if the mask is 0
+func (r *RuleRoute) ensureRule(family int, table int, mark int, mask int, log logr.Logger) error {
 	log = log.WithValues("family", family)
 	log.V(1).Info("ensure rule")
 
@@ -155,7 +226,7 @@ func (r *RuleRoute) EnsureRule(family int, table int, mark int, log logr.Logger)
 	found := false
 	for _, rule := range rules {
 		del := false
-		if rule.Table != table {
+		if rule.Table != table || (mask != 0 && rule.Mask != mask) {
 			del = true
 		}
 		if found {
@@ -181,6 +252,9 @@ func (r *RuleRoute) EnsureRule(family int, table int, mark int, log logr.Logger)
 		rule.Table = table
 		rule.Mark = mark
 		rule.Family = family
+		if mask != 0 {
+			rule.Mask = mask
+		}
 
 		r.log.V(1).Info("add rule", "rule", rule.String())
 		err := netlink.RuleAdd(rule)
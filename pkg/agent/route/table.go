@@ -0,0 +1,157 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package route
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
+)
+
+// renumberAttempts is the times to move a table away from the tables of the others, the
+// table is moved by the size of the mark range each time
+const renumberAttempts = 8
+
+// Tables allocates the routing tables of the agent, and avoids the tables used by the
+// other tools, e.g. Cilium or systemd-networkd
+type Tables struct {
+	lock  sync.Mutex
+	space markallocator.Space
+	// size is the size of the range of the marks
+	size     int
+	renumber bool
+	// others is the tables used by the others, which are detected at startup
+	others map[int]bool
+	// assigned is the allocated tables, key is the preferred table
+	assigned map[int]int
+	// owners is the preferred tables of the allocated tables
+	owners map[int]int
+}
+
+// NewTables returns the allocator of the tables, the tables used by the others are
+// renumbered if renumber is true, or refused otherwise
+func NewTables(space markallocator.Space, renumber bool) *Tables {
+	return &Tables{
+		space:    space,
+		size:     space.Span(),
+		renumber: renumber,
+		others:   make(map[int]bool),
+		assigned: make(map[int]int),
+		owners:   make(map[int]int),
+	}
+}
+
+// Detect records the tables used by the others: the tables of the routes not created by
+// the agent, and the tables of the rules not with the marks of the agent. The tables in
+// the range of the marks are always ours, they're used by the older versions.
+func (t *Tables) Detect(rules map[int][]netlink.Rule, routes map[int][]netlink.Route, replyMark int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	ours := func(table int) bool {
+		return isReservedTable(table) || t.space.InRange(table)
+	}
+	for _, list := range rules {
+		for _, rule := range list {
+			if ours(rule.Table) || t.space.InRange(rule.Mark) ||
+				(replyMark != 0 && rule.Mark == replyMark) {
+				continue
+			}
+			t.others[rule.Table] = true
+		}
+	}
+	for _, list := range routes {
+		for _, route := range list {
+			if ours(route.Table) || route.Protocol == Protocol {
+				continue
+			}
+			t.others[route.Table] = true
+		}
+	}
+}
+
+// DetectFromHost records the tables used by the others on the host
+func (t *Tables) DetectFromHost(replyMark int) error {
+	rules := make(map[int][]netlink.Rule)
+	routes := make(map[int][]netlink.Route)
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		list, err := netlink.RuleList(family)
+		if err != nil {
+			return err
+		}
+		rules[family] = list
+		routeFilter := &netlink.Route{Table: unix.RT_TABLE_UNSPEC}
+		routes[family], err = netlink.RouteListFiltered(family, routeFilter, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			return err
+		}
+	}
+	t.Detect(rules, routes, replyMark)
+	return nil
+}
+
+// PeerTable returns the table of the gateway node with the mark, the tables are numbered
+// by the indexes of the marks from the base, the mark is the table if the base is 0
+func (t *Tables) PeerTable(base, mark int) (int, error) {
+	if base == 0 {
+		return t.Table(mark)
+	}
+	index, ok := t.space.Index(uint64(mark))
+	if !ok {
+		return 0, fmt.Errorf("mark %#x is not in the mark space", mark)
+	}
+	return t.Table(base + index + 1)
+}
+
+// Table returns the table allocated for the preferred table. If the preferred table is
+// used by the others, an error is returned, or it's renumbered by the size of the mark
+// range until a free table is found.
+func (t *Tables) Table(preferred int) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if table, ok := t.assigned[preferred]; ok {
+		return table, nil
+	}
+	table := preferred
+	for i := 0; ; i++ {
+		owner, used := t.owners[table]
+		if !t.others[table] && !isReservedTable(table) && (!used || owner == preferred) {
+			break
+		}
+		if !t.renumber {
+			return 0, fmt.Errorf("routing table %d is used by the others", preferred)
+		}
+		if i == renumberAttempts || table+t.size > 1<<31-1 {
+			return 0, fmt.Errorf("no free routing table to renumber table %d", preferred)
+		}
+		table += t.size
+	}
+	t.assigned[preferred] = table
+	t.owners[table] = preferred
+	return table, nil
+}
+
+// Candidates returns the tables which the preferred table may be renumbered to, including
+// the preferred table itself
+func (t *Tables) Candidates(preferred int) []int {
+	res := []int{preferred}
+	for i := 0; i < renumberAttempts && preferred+t.size <= 1<<31-1; i++ {
+		preferred += t.size
+		res = append(res, preferred)
+	}
+	return res
+}
+
+func isReservedTable(table int) bool {
+	switch table {
+	case unix.RT_TABLE_UNSPEC, unix.RT_TABLE_COMPAT, unix.RT_TABLE_DEFAULT, unix.RT_TABLE_MAIN, unix.RT_TABLE_LOCAL:
+		return true
+	}
+	return false
+}
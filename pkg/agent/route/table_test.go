@@ -0,0 +1,77 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package route
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
+)
+
+func TestTables(t *testing.T) {
+	rules := map[int][]netlink.Rule{
+		netlink.FAMILY_V4: {
+			// the rules of Cilium, and the rules of the agent
+			{Mark: 0x200, Table: 2004},
+			{Mark: 0x26000001, Table: 1001},
+			{Mark: 39, Table: 600},
+			{Table: 254},
+		},
+	}
+	routes := map[int][]netlink.Route{
+		netlink.FAMILY_V4: {
+			// the routes of systemd-networkd, and the routes of the agent
+			{Table: 600, Protocol: 4},
+			{Table: 1002, Protocol: Protocol},
+			{Table: 0x26000003, Protocol: 3},
+		},
+	}
+
+	space, err := markallocator.NewSpace("0x26000000", "")
+	assert.NoError(t, err)
+	tables := NewTables(space, true)
+	tables.Detect(rules, routes, 39)
+	assert.Equal(t, map[int]bool{2004: true, 600: true}, tables.others)
+
+	// the marks are the tables by default
+	table, err := tables.PeerTable(0, 0x26000003)
+	assert.NoError(t, err)
+	assert.Equal(t, 0x26000003, table)
+	table, err = tables.PeerTable(1000, 0x26000002)
+	assert.NoError(t, err)
+	assert.Equal(t, 1002, table)
+
+	// the tables used by the others are renumbered
+	table, err = tables.Table(600)
+	assert.NoError(t, err)
+	assert.Equal(t, 600+0x1000000, table)
+	assert.Contains(t, tables.Candidates(600), table)
+	table, err = tables.PeerTable(1000, 0x26000000+1004)
+	assert.NoError(t, err)
+	assert.Equal(t, 2004+0x1000000, table)
+	// the renumbered table is not allocated again
+	table, err = tables.Table(600 + 0x1000000)
+	assert.NoError(t, err)
+	assert.Equal(t, 600+0x2000000, table)
+
+	tables = NewTables(space, false)
+	tables.Detect(rules, routes, 39)
+	_, err = tables.Table(600)
+	assert.Error(t, err)
+	_, err = tables.Table(254)
+	assert.Error(t, err)
+
+	// the marks are numbered in the bits of the mask
+	space, err = markallocator.NewSpace("0x26000000", "0x0000ff00")
+	assert.NoError(t, err)
+	tables = NewTables(space, true)
+	table, err = tables.PeerTable(1000, 0x26000300)
+	assert.NoError(t, err)
+	assert.Equal(t, 1003, table)
+	_, err = tables.PeerTable(1000, 0x26000301)
+	assert.Error(t, err)
+}
@@ -0,0 +1,172 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/ipset"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+// EgressShadowChain counts the traffic of the policies in the Shadow mode
+const EgressShadowChain = "EGRESSGATEWAY-SHADOW"
+
+var (
+	// the destinations of the shadow policies without destSubnet, the ipsets of the hash:net
+	// type can't hold a /0 network
+	shadowAllIPv4 = []string{"0.0.0.0/1", "128.0.0.0/1"}
+	shadowAllIPv6 = []string{"::/1", "8000::/1"}
+)
+
+func (r *policeReconciler) getPolicyMode(ns, name string) (string, error) {
+	key := types.NamespacedName{Namespace: ns, Name: name}
+	if ns != "" {
+		obj := new(egressv1.EgressPolicy)
+		if err := r.client.Get(context.Background(), key, obj); err != nil {
+			return "", client.IgnoreNotFound(err)
+		}
+		return obj.Spec.Mode, nil
+	}
+	obj := new(egressv1.EgressClusterPolicy)
+	if err := r.client.Get(context.Background(), key, obj); err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	return obj.Spec.Mode, nil
+}
+
+// buildShadowIPSetNames returns the destination ipsets with counters of the shadow policy,
+// the counters of the entries count the traffic to each destination
+func buildShadowIPSetNames(ns, name string, enableIPv4, enableIPv6 bool) SetNames {
+	if ns != "" {
+		name = ns + "-" + name
+	}
+	res := make([]SetName, 0)
+	if enableIPv4 {
+		res = append(res, SetName{Name: formatIPSetName("egress-shd-v4-", name), Stack: IPv4, Kind: IPDst, Counters: true})
+	}
+	if enableIPv6 {
+		res = append(res, SetName{Name: formatIPSetName("egress-shd-v6-", name), Stack: IPv6, Kind: IPDst, Counters: true})
+	}
+	return res
+}
+
+// updateShadowIPSet updates the destinations of the shadow policy, the counters of the
+// destinations kept in the ipsets are not reset
+func (r *policeReconciler) updateShadowIPSet(policyNs, policyName string, destSubnet []string) error {
+	dstIPv4List, dstIPv6List, err := r.getDstCIDR(destSubnet)
+	if err != nil {
+		return err
+	}
+	if len(destSubnet) == 0 {
+		dstIPv4List, dstIPv6List = shadowAllIPv4, shadowAllIPv6
+	}
+
+	setNames := buildShadowIPSetNames(policyNs, policyName, r.cfg.FileConfig.EnableIPv4, r.cfg.FileConfig.EnableIPv6)
+	return setNames.Map(func(set SetName) error {
+		newList := dstIPv4List
+		if set.Stack == IPv6 {
+			newList = dstIPv6List
+		}
+		return r.syncIPSetEntries(set, newList)
+	})
+}
+
+// syncShadowPolicy returns whether the policy should be applied again as its mode is changed,
+// otherwise it updates the destinations of the shadow policy
+func (r *policeReconciler) syncShadowPolicy(policy egressv1.Policy, mode string, destSubnet []string) (bool, error) {
+	_, applied := r.shadowPolicies.Load(policy)
+	if applied != (mode == egressv1.PolicyModeShadow) {
+		return true, nil
+	}
+	if !applied {
+		return false, nil
+	}
+	return false, r.updateShadowIPSet(policy.Namespace, policy.Name, destSubnet)
+}
+
+// buildShadowRule counts the traffic from the Pods of the policy on the node, the rule has
+// no target, the counters of the matched destination in the ipset are increased
+func buildShadowRule(policyName string, version uint8, isIgnoreInternalCIDR bool) iptables.Rule {
+	tmp := "v4-"
+	ignoreInternalCIDRName := EgressClusterCIDRIPv4
+	if version == 6 {
+		tmp = "v6-"
+		ignoreInternalCIDRName = EgressClusterCIDRIPv6
+	}
+	srcName := formatIPSetName("egress-src-"+tmp, policyName)
+	dstName := formatIPSetName("egress-shd-"+tmp, policyName)
+
+	matchCriteria := iptables.MatchCriteria{}.SourceIPSet(srcName).DestIPSet(dstName)
+	if isIgnoreInternalCIDR {
+		matchCriteria = matchCriteria.NotDestIPSet(ignoreInternalCIDRName)
+	}
+	matchCriteria = matchCriteria.CTDirectionOriginal(iptables.DirectionOriginal)
+	return iptables.Rule{Match: matchCriteria, Comment: []string{
+		fmt.Sprintf("Count traffic for shadow EgressPolicy %s", policyName),
+	}}
+}
+
+var (
+	descShadowPackets = prometheus.NewDesc("egress_policy_shadow_packets_total",
+		"The number of packets matched by the policy in the Shadow mode on the node, by destination",
+		[]string{"namespace", "policy", "destination"}, nil)
+	descShadowBytes = prometheus.NewDesc("egress_policy_shadow_bytes_total",
+		"The number of bytes matched by the policy in the Shadow mode on the node, by destination",
+		[]string{"namespace", "policy", "destination"}, nil)
+)
+
+// shadowCollector exports the counters of the destinations of the shadow policies, which are
+// read from the ipsets on scrape
+type shadowCollector struct {
+	ipset    ipset.Interface
+	policies *utils.SyncMap[egressv1.Policy, bool]
+	ipv4     bool
+	ipv6     bool
+	log      logr.Logger
+}
+
+func (c *shadowCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- descShadowPackets
+	ch <- descShadowBytes
+}
+
+func (c *shadowCollector) Collect(ch chan<- prometheus.Metric) {
+	c.policies.Range(func(policy egressv1.Policy, allDestinations bool) bool {
+		for _, set := range buildShadowIPSetNames(policy.Namespace, policy.Name, c.ipv4, c.ipv6) {
+			counters, err := c.ipset.ListEntryCounters(set.Name)
+			if err != nil {
+				c.log.Error(err, "failed to list the counters of the shadow policy", "policy", policy)
+				continue
+			}
+			if allDestinations {
+				total := ipset.EntryCounters{}
+				for _, item := range counters {
+					total.Packets += item.Packets
+					total.Bytes += item.Bytes
+				}
+				dst := "0.0.0.0/0"
+				if set.Stack == IPv6 {
+					dst = "::/0"
+				}
+				counters = map[string]ipset.EntryCounters{dst: total}
+			}
+			for dst, item := range counters {
+				ch <- prometheus.MustNewConstMetric(descShadowPackets, prometheus.CounterValue,
+					float64(item.Packets), policy.Namespace, policy.Name, dst)
+				ch <- prometheus.MustNewConstMetric(descShadowBytes, prometheus.CounterValue,
+					float64(item.Bytes), policy.Namespace, policy.Name, dst)
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,65 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/ipset"
+	ipsettest "github.com/spidernet-io/egressgateway/pkg/ipset/testing"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+func TestUpdateShadowIPSet(t *testing.T) {
+	fake := ipsettest.NewFake("v7.1")
+	cfg := &config.Config{}
+	cfg.FileConfig.EnableIPv4 = true
+	r := &policeReconciler{
+		cfg:            cfg,
+		log:            logr.Discard(),
+		ipset:          fake,
+		ipsetMap:       utils.NewSyncMap[string, *ipset.IPSet](),
+		shadowPolicies: utils.NewSyncMap[egressv1.Policy, bool](),
+	}
+	set := buildShadowIPSetNames("default", "test", true, false)[0]
+
+	// all the destinations out of the cluster without destSubnet
+	assert.NoError(t, r.updateShadowIPSet("default", "test", nil))
+	assert.True(t, fake.Sets[set.Name].Counters)
+	assert.ElementsMatch(t, shadowAllIPv4, fake.Entries[set.Name].UnsortedList())
+
+	assert.NoError(t, r.updateShadowIPSet("default", "test", []string{"10.6.0.0/16", "fd00::/64"}))
+	assert.ElementsMatch(t, []string{"10.6.0.0/16"}, fake.Entries[set.Name].UnsortedList())
+
+	// the counters are exported by destination
+	r.shadowPolicies.Store(egressv1.Policy{Name: "test", Namespace: "default"}, false)
+	registry := prometheus.NewRegistry()
+	assert.NoError(t, registry.Register(&shadowCollector{
+		ipset: fake, policies: r.shadowPolicies, ipv4: true, log: logr.Discard(),
+	}))
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	assert.Len(t, families, 2)
+	for _, family := range families {
+		assert.Len(t, family.Metric, 1)
+		labels := make(map[string]string)
+		for _, label := range family.Metric[0].Label {
+			labels[label.GetName()] = label.GetValue()
+		}
+		assert.Equal(t, map[string]string{"namespace": "default", "policy": "test", "destination": "10.6.0.0/16"}, labels)
+	}
+}
+
+func TestBuildShadowRule(t *testing.T) {
+	rule := buildShadowRule("default-test", 4, true)
+	assert.Nil(t, rule.Action)
+	assert.Contains(t, rule.Match.Render(), "egress-shd-v4-")
+	assert.Contains(t, rule.Match.Render(), EgressClusterCIDRIPv4)
+}
@@ -0,0 +1,158 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/ipset"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// standbyIPSetPrefix is the prefix of the ipsets of the Pods of the policies whose EIPs the
+// node stands by for
+const standbyIPSetPrefix = "egress-ssrc-"
+
+// standbyRuleCommentPrefix is the comment of the standby SNAT rules, which are not counted
+// as the rules of the policies
+const standbyRuleCommentPrefix = "standby snat policy "
+
+// buildStandbyIPSetNames returns the ipsets of all the Pods of the policy, which are SNATed
+// by the standby rules
+func buildStandbyIPSetNames(ns, name string, enableIPv4, enableIPv6 bool) SetNames {
+	if ns != "" {
+		name = ns + "-" + name
+	}
+	res := make([]SetName, 0)
+	if enableIPv4 {
+		res = append(res, SetName{Name: formatIPSetName(standbyIPSetPrefix+"v4-", name), Stack: IPv4, Kind: IPSrc})
+	}
+	if enableIPv6 {
+		res = append(res, SetName{Name: formatIPSetName(standbyIPSetPrefix+"v6-", name), Stack: IPv6, Kind: IPSrc})
+	}
+	return res
+}
+
+// updateStandbyIPSet updates all the Pods of the policy whose EIP the node stands by for. The
+// source ipsets of the policy only have the Pods on the node until it's the gateway node, and
+// they're matched by the marks of the traffic forwarded to the gateway node.
+func (r *policeReconciler) updateStandbyIPSet(policyNs, policyName string) error {
+	srcIPv4List, srcIPv6List, err := r.getPolicySrcIPs(policyNs, policyName, func(egressv1.EgressEndpoint) bool {
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	ipv4, ipv6, err := r.getPolicyIPFamilies(policyNs, policyName)
+	if err != nil {
+		return err
+	}
+	if !ipv4 {
+		srcIPv4List = make([]string, 0)
+	}
+	if !ipv6 {
+		srcIPv6List = make([]string, 0)
+	}
+
+	setNames := buildStandbyIPSetNames(policyNs, policyName, r.cfg.FileConfig.EnableIPv4, r.cfg.FileConfig.EnableIPv6)
+	return setNames.Map(func(set SetName) error {
+		list := srcIPv4List
+		if set.Stack == IPv6 {
+			list = srcIPv6List
+		}
+		return r.syncIPSetEntries(set, list)
+	})
+}
+
+// forgetStandbyIPSets drops the ipsets of the policies not in the keep, so they're destroyed
+// by the cleanup of the ipsets
+func (r *policeReconciler) forgetStandbyIPSets(keep map[string]bool) {
+	r.ipsetMap.Range(func(name string, _ *ipset.IPSet) bool {
+		if strings.HasPrefix(name, standbyIPSetPrefix) && !keep[name] {
+			r.ipsetMap.Delete(name)
+		}
+		return true
+	})
+}
+
+// buildStandbyEipRule SNATs the traffic of the policy with the EIP the node stands by for. The
+// traffic of the local Pods forwarded to the gateway node is accepted by its mark before the
+// chain, so the rule only matches the traffic sent to the node by the other nodes, which
+// happens once the EIP is moved to the node, before the node applies it as the gateway node.
+func buildStandbyEipRule(policyName string, eip IP, snat *egressv1.SNAT, version uint8, isIgnoreInternalCIDR bool) *iptables.Rule {
+	tmp := "v4-"
+	ip := eip.V4
+	ignoreName := EgressClusterCIDRIPv4
+	if version == 6 {
+		tmp = "v6-"
+		ip = eip.V6
+		ignoreName = EgressClusterCIDRIPv6
+	}
+	if ip == "" {
+		return nil
+	}
+	srcName := formatIPSetName(standbyIPSetPrefix+tmp, policyName)
+	dstName := formatIPSetName("egress-dst-"+tmp, policyName)
+
+	matchCriteria := iptables.MatchCriteria{}.SourceIPSet(srcName).DestIPSet(dstName).
+		CTDirectionOriginal(iptables.DirectionOriginal)
+	if isIgnoreInternalCIDR {
+		matchCriteria = iptables.MatchCriteria{}.SourceIPSet(srcName).NotDestIPSet(ignoreName).
+			CTDirectionOriginal(iptables.DirectionOriginal)
+	}
+	return &iptables.Rule{Match: matchCriteria, Action: snatAction(ip, snat), Comment: []string{
+		standbyRuleCommentPrefix + policyName,
+	}}
+}
+
+// buildStandbyIPs returns the EIPs programmed by the standby rules by the gateways
+func buildStandbyIPs(policies map[egressv1.Policy]*PolicyCommon, gateways map[egressv1.Policy]string) map[string][]string {
+	res := make(map[string][]string)
+	for policy, val := range policies {
+		gateway := gateways[policy]
+		for _, ip := range []string{val.IP.V4, val.IP.V6} {
+			if ip != "" && !slices.Contains(res[gateway], ip) {
+				res[gateway] = append(res[gateway], ip)
+			}
+		}
+	}
+	for _, ips := range res {
+		slices.Sort(ips)
+	}
+	return res
+}
+
+// reportStandbyIPs records the standby EIPs of the gateway programmed by the node in the
+// status of the gateway, the controller only moves the EIPs to the nodes programmed them
+func (r *policeReconciler) reportStandbyIPs(ctx context.Context, key types.NamespacedName) error {
+	ips, _ := r.standbyIPs.Load(key.Name)
+	var err error
+	for i := 0; i < 5; i++ {
+		gateway := new(egressv1.EgressGateway)
+		if err = r.client.Get(ctx, key, gateway); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if !gateway.GetDeletionTimestamp().IsZero() {
+			return nil
+		}
+		if !egressv1.SetNodeStandbyIPs(&gateway.Status.StandbyNodes, r.cfg.EnvConfig.NodeName, ips) {
+			return nil
+		}
+		err = r.client.Status().Update(ctx, gateway)
+		if err == nil || apierr.IsNotFound(err) {
+			return nil
+		}
+		if !apierr.IsConflict(err) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestBuildStandbyEipRule(t *testing.T) {
+	rule := buildStandbyEipRule("default-a", IP{V4: "10.6.1.21"}, nil, 4, false)
+	assert.NotNil(t, rule)
+	assert.Equal(t, "-m set --match-set "+formatIPSetName("egress-ssrc-v4-", "default-a")+" src "+
+		"-m set --match-set "+formatIPSetName("egress-dst-v4-", "default-a")+" dst "+
+		"-m conntrack --ctdir ORIGINAL", rule.Match.Render())
+	assert.Equal(t, iptables.SNATAction{ToAddr: "10.6.1.21"}, rule.Action)
+	// the rule isn't counted as the rule of the policy
+	assert.False(t, strings.HasPrefix(rule.Comment[0], snatRuleCommentPrefix))
+
+	rule = buildStandbyEipRule("default-a", IP{V4: "10.6.1.21"}, nil, 4, true)
+	assert.Contains(t, rule.Match.Render(), "-m set ! --match-set "+EgressClusterCIDRIPv4+" dst")
+	assert.Nil(t, buildStandbyEipRule("default-a", IP{V4: "10.6.1.21"}, nil, 6, false))
+}
+
+func TestBuildStandbyIPs(t *testing.T) {
+	policies := map[egressv1.Policy]*PolicyCommon{
+		{Name: "a", Namespace: "default"}: {IP: IP{V4: "10.6.1.22", V6: "fd00::22"}},
+		{Name: "b", Namespace: "default"}: {IP: IP{V4: "10.6.1.22"}},
+		{Name: "c", Namespace: "default"}: {IP: IP{V4: "10.6.1.21"}},
+		{Name: "d"}:                       {IP: IP{V4: "10.7.1.21"}},
+	}
+	gateways := map[egressv1.Policy]string{
+		{Name: "a", Namespace: "default"}: "egw1",
+		{Name: "b", Namespace: "default"}: "egw1",
+		{Name: "c", Namespace: "default"}: "egw1",
+		{Name: "d"}:                       "egw2",
+	}
+	assert.Equal(t, map[string][]string{
+		"egw1": {"10.6.1.21", "10.6.1.22", "fd00::22"},
+		"egw2": {"10.7.1.21"},
+	}, buildStandbyIPs(policies, gateways))
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	egresserrors "github.com/spidernet-io/egressgateway/pkg/errors"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// maxDatapathMessage limits the message of the Datapath condition
+const maxDatapathMessage = 1024
+
+// datapathErrors tracks the failures of programming the datapath by the sources, e.g. the
+// neighbors, or the routes of a peer. In the strict mode the failures fail the reconciles
+// and the tunnel of the node, so a partially programmed datapath doesn't persist silently.
+type datapathErrors struct {
+	strict bool
+	mutex  sync.Mutex
+	errs   map[string]error
+}
+
+func newDatapathErrors(strict bool) *datapathErrors {
+	return &datapathErrors{strict: strict, errs: make(map[string]error)}
+}
+
+// Set records the error of the source, or clears it if the error is nil. It returns the
+// error in the strict mode and nil otherwise, so the callers only fail in the strict mode.
+func (d *datapathErrors) Set(source string, err error) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if err == nil {
+		delete(d.errs, source)
+		return nil
+	}
+	d.errs[source] = err
+	if !d.strict {
+		return nil
+	}
+	return egresserrors.New(egresserrors.External, err)
+}
+
+// Aggregate returns the errors of all the sources in the order of the sources, or nil
+func (d *datapathErrors) Aggregate() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if len(d.errs) == 0 {
+		return nil
+	}
+	sources := make([]string, 0, len(d.errs))
+	for source := range d.errs {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	msg := ""
+	for i, source := range sources {
+		if i != 0 {
+			msg += "; "
+		}
+		msg += source + ": " + d.errs[source].Error()
+	}
+	return fmt.Errorf("%d datapath errors: %s", len(sources), msg)
+}
+
+// setDatapathCondition sets the Datapath condition of the tunnel in the strict mode, the
+// Ready tunnel turns Failed until the datapath is fully programmed
+func (d *datapathErrors) setDatapathCondition(status *egressv1.EgressTunnelStatus, generation int64, vtepReady bool) {
+	prev := meta.FindStatusCondition(status.Conditions, egressv1.TunnelConditionDatapath)
+	if !d.strict {
+		if prev != nil && status.Phase == egressv1.EgressTunnelFailed && vtepReady {
+			status.Phase = egressv1.EgressTunnelReady
+		}
+		meta.RemoveStatusCondition(&status.Conditions, egressv1.TunnelConditionDatapath)
+		return
+	}
+
+	cond := metav1.Condition{
+		Type:               egressv1.TunnelConditionDatapath,
+		Status:             metav1.ConditionTrue,
+		Reason:             egressv1.DatapathProgrammed,
+		Message:            "the datapath of the node is programmed",
+		ObservedGeneration: generation,
+	}
+	if err := d.Aggregate(); err != nil {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = egressv1.DatapathPartialFailure
+		cond.Message = err.Error()
+		if len(cond.Message) > maxDatapathMessage {
+			cond.Message = cond.Message[:maxDatapathMessage]
+		}
+		if status.Phase == egressv1.EgressTunnelReady {
+			status.Phase = egressv1.EgressTunnelFailed
+		}
+	} else if status.Phase == egressv1.EgressTunnelFailed && vtepReady {
+		status.Phase = egressv1.EgressTunnelReady
+	}
+	meta.SetStatusCondition(&status.Conditions, cond)
+}
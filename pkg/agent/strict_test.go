@@ -0,0 +1,48 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestDatapathErrors(t *testing.T) {
+	errs := newDatapathErrors(true)
+	assert.NoError(t, errs.Aggregate())
+	assert.Error(t, errs.Set("route", fmt.Errorf("file exists")))
+	assert.Error(t, errs.Set("peer/node2", fmt.Errorf("no such device")))
+	assert.EqualError(t, errs.Aggregate(), "2 datapath errors: peer/node2: no such device; route: file exists")
+
+	status := &egressv1.EgressTunnelStatus{Phase: egressv1.EgressTunnelReady}
+	errs.setDatapathCondition(status, 1, true)
+	assert.Equal(t, egressv1.EgressTunnelFailed, status.Phase)
+	cond := meta.FindStatusCondition(status.Conditions, egressv1.TunnelConditionDatapath)
+	assert.Equal(t, metav1.ConditionFalse, cond.Status)
+	assert.Equal(t, egressv1.DatapathPartialFailure, cond.Reason)
+
+	// the tunnel is Ready again once all the failures are resolved
+	assert.NoError(t, errs.Set("route", nil))
+	assert.NoError(t, errs.Set("peer/node2", nil))
+	errs.setDatapathCondition(status, 1, true)
+	assert.Equal(t, egressv1.EgressTunnelReady, status.Phase)
+	cond = meta.FindStatusCondition(status.Conditions, egressv1.TunnelConditionDatapath)
+	assert.Equal(t, metav1.ConditionTrue, cond.Status)
+	assert.Equal(t, egressv1.DatapathProgrammed, cond.Reason)
+
+	// the failures are only recorded in the non-strict mode
+	errs = newDatapathErrors(false)
+	assert.NoError(t, errs.Set("route", fmt.Errorf("file exists")))
+	assert.Error(t, errs.Aggregate())
+	status.Phase = egressv1.EgressTunnelFailed
+	errs.setDatapathCondition(status, 1, true)
+	assert.Equal(t, egressv1.EgressTunnelReady, status.Phase)
+	assert.Nil(t, meta.FindStatusCondition(status.Conditions, egressv1.TunnelConditionDatapath))
+}
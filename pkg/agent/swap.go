@@ -0,0 +1,151 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// swapChain is a chain of the rules of all the policies which is double buffered by the
+// chains <name>-A and <name>-B. The chain <name> dispatches the traffic to the active one
+// by goto, so a new generation of the rules is written into the standby chain while the
+// active one still matches, and takes effect by switching the goto rules in one atomic
+// restore. There is no moment in which neither the old nor the new rules match, which
+// leaks the traffic of the policies without the marks or the EIPs.
+type swapChain struct {
+	name string
+
+	mutex  sync.RWMutex
+	tables map[*iptables.Table]*swapState
+}
+
+// swapState is the double buffered chain of a table
+type swapState struct {
+	// generation is increased by every swap, the even ones are in the chain A
+	generation uint64
+	// active is the rules of the active chain, staged is the rules written into the
+	// standby chain which is not swapped in yet if pending
+	active  []iptables.Rule
+	staged  []iptables.Rule
+	pending bool
+}
+
+func newSwapChain(name string) *swapChain {
+	return &swapChain{name: name, tables: make(map[*iptables.Table]*swapState)}
+}
+
+func (c *swapChain) buffer(generation uint64) string {
+	if generation%2 == 0 {
+		return c.name + "-A"
+	}
+	return c.name + "-B"
+}
+
+// dispatch returns the chain going to the active chain of the generation, the goto of the
+// standby chain is never reached, it only keeps the standby chain programmed
+func (c *swapChain) dispatch(generation uint64) *iptables.Chain {
+	return &iptables.Chain{Name: c.name, Rules: []iptables.Rule{
+		{
+			Action:  iptables.GotoAction{Target: c.buffer(generation)},
+			Comment: []string{fmt.Sprintf("EgressGateway rules generation %d", generation)},
+		},
+		{
+			Action:  iptables.GotoAction{Target: c.buffer(generation + 1)},
+			Comment: []string{"EgressGateway rules standby"},
+		},
+	}}
+}
+
+// Active returns the chain of the rules in effect in the table
+func (c *swapChain) Active(table *iptables.Table) string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	var generation uint64
+	if state, ok := c.tables[table]; ok {
+		generation = state.generation
+	}
+	return c.buffer(generation)
+}
+
+// Stage writes the rules into the standby chain of the table if they're changed. The rules
+// are written into both the chains the first time, as the agent doesn't know which one is
+// active in the datapath after it's restarted, so they're applied in one restore.
+func (c *swapChain) Stage(table *iptables.Table, rules []iptables.Rule) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	state, ok := c.tables[table]
+	if !ok {
+		table.UpdateChain(&iptables.Chain{Name: c.buffer(0), Rules: rules})
+		table.UpdateChain(&iptables.Chain{Name: c.buffer(1), Rules: rules})
+		table.UpdateChain(c.dispatch(0))
+		c.tables[table] = &swapState{active: rules}
+		return
+	}
+	if reflect.DeepEqual(state.active, rules) {
+		// the standby chain is never reached, the rules staged before are left in it
+		state.staged, state.pending = nil, false
+		return
+	}
+	table.UpdateChain(&iptables.Chain{Name: c.buffer(state.generation + 1), Rules: rules})
+	state.staged, state.pending = rules, true
+}
+
+// Swap switches the dispatch chains of the tables with the staged rules to the standby
+// chains, it should be called after the staged rules are applied, and returns whether the
+// tables should be applied again to take the swaps
+func (c *swapChain) Swap() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	swapped := false
+	for table, state := range c.tables {
+		if !state.pending {
+			continue
+		}
+		state.generation++
+		state.active, state.staged, state.pending = state.staged, nil, false
+		table.UpdateChain(c.dispatch(state.generation))
+		swapped = true
+	}
+	return swapped
+}
+
+// sortedPolicies returns the policies by their namespaces and names. The rules staged are
+// built in this order, the random order of the maps would differ from the active rules on
+// every reconcile, and swap the chains for nothing.
+func sortedPolicies[T any](policies map[egressv1.Policy]T) []egressv1.Policy {
+	res := make([]egressv1.Policy, 0, len(policies))
+	for policy := range policies {
+		res = append(res, policy)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Namespace != res[j].Namespace {
+			return res[i].Namespace < res[j].Namespace
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// swapCounterReader reads the counters of the rules of the active chains instead of the
+// dispatch chains of the swap chains
+type swapCounterReader struct {
+	table  *iptables.Table
+	chains []*swapChain
+}
+
+func (r swapCounterReader) ReadCounters(chainName string) ([]iptables.RuleCounters, error) {
+	for _, chain := range r.chains {
+		if chain.name == chainName {
+			chainName = chain.Active(r.table)
+			break
+		}
+	}
+	return r.table.ReadCounters(chainName)
+}
@@ -0,0 +1,120 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	"github.com/spidernet-io/egressgateway/pkg/iptables/testutils"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestSwapChain(t *testing.T) {
+	dataplane := testutils.NewMockDataplane("nat", map[string][]string{
+		"PREROUTING":  {},
+		"INPUT":       {},
+		"OUTPUT":      {},
+		"POSTROUTING": {},
+		// the rules written by the older version without the double buffered chains
+		"EGRESSGATEWAY-SNAT-EIP": {"-j ACCEPT"},
+	}, "legacy")
+	table, err := iptables.NewTable("nat", 4, "egw:", iptables.Options{
+		XTablesLock:      iptables.DummyLock{},
+		NewCmdOverride:   dataplane.NewCmd,
+		SleepOverride:    dataplane.Sleep,
+		NowOverride:      dataplane.Now,
+		LookPathOverride: func(file string) (string, error) { return file, nil },
+	}, logr.Discard())
+	assert.NoError(t, err)
+	table.InsertOrAppendRules("POSTROUTING", []iptables.Rule{{Action: iptables.JumpAction{Target: "EGRESSGATEWAY-SNAT-EIP"}}})
+
+	rule := func(ip string) iptables.Rule {
+		return iptables.Rule{
+			Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(0x26000001, 0xffffffff),
+			Action: iptables.SNATAction{ToAddr: ip},
+		}
+	}
+	buffer := func(generation int) string {
+		chain := "EGRESSGATEWAY-SNAT-EIP-A"
+		if generation%2 == 1 {
+			chain = "EGRESSGATEWAY-SNAT-EIP-B"
+		}
+		return chain
+	}
+	chain := newSwapChain("EGRESSGATEWAY-SNAT-EIP")
+
+	// the rules are written into both the chains the first time
+	chain.Stage(table, []iptables.Rule{rule("10.6.1.100")})
+	assert.False(t, chain.Swap())
+	_, err = table.Apply()
+	assert.NoError(t, err)
+	assert.Len(t, dataplane.Chains["EGRESSGATEWAY-SNAT-EIP"], 2)
+	assert.Contains(t, dataplane.Chains["EGRESSGATEWAY-SNAT-EIP"][0], buffer(0))
+	assert.Contains(t, dataplane.Chains["EGRESSGATEWAY-SNAT-EIP-A"][0], "10.6.1.100")
+	assert.Contains(t, dataplane.Chains["EGRESSGATEWAY-SNAT-EIP-B"][0], "10.6.1.100")
+	assert.Equal(t, "EGRESSGATEWAY-SNAT-EIP-A", chain.Active(table))
+
+	// the new rules are written into the standby chain while the active one still matches
+	chain.Stage(table, []iptables.Rule{rule("10.6.1.101")})
+	_, err = table.Apply()
+	assert.NoError(t, err)
+	assert.Contains(t, dataplane.Chains["EGRESSGATEWAY-SNAT-EIP"][0], buffer(0))
+	assert.Contains(t, dataplane.Chains["EGRESSGATEWAY-SNAT-EIP-A"][0], "10.6.1.100")
+	assert.Contains(t, dataplane.Chains["EGRESSGATEWAY-SNAT-EIP-B"][0], "10.6.1.101")
+
+	// then they take effect by the swap
+	assert.True(t, chain.Swap())
+	_, err = table.Apply()
+	assert.NoError(t, err)
+	assert.Contains(t, dataplane.Chains["EGRESSGATEWAY-SNAT-EIP"][0], buffer(1))
+	assert.Contains(t, dataplane.Chains["EGRESSGATEWAY-SNAT-EIP"][1], buffer(0))
+	assert.Equal(t, "EGRESSGATEWAY-SNAT-EIP-B", chain.Active(table))
+
+	// the unchanged rules are not swapped, and a stage reverted before the swap is dropped
+	chain.Stage(table, []iptables.Rule{rule("10.6.1.101")})
+	assert.False(t, chain.Swap())
+	chain.Stage(table, []iptables.Rule{rule("10.6.1.102")})
+	chain.Stage(table, []iptables.Rule{rule("10.6.1.101")})
+	assert.False(t, chain.Swap())
+	assert.Equal(t, "EGRESSGATEWAY-SNAT-EIP-B", chain.Active(table))
+}
+
+func TestStageSortedPolicies(t *testing.T) {
+	table, err := iptables.NewTable("nat", 4, "egw:", iptables.Options{
+		XTablesLock:      iptables.DummyLock{},
+		NewCmdOverride:   testutils.NewMockDataplane("nat", map[string][]string{"POSTROUTING": {}}, "legacy").NewCmd,
+		LookPathOverride: func(file string) (string, error) { return file, nil },
+	}, logr.Discard())
+	assert.NoError(t, err)
+
+	policies := []egressv1.Policy{
+		{Name: "b", Namespace: "default"},
+		{Name: "a", Namespace: "default"},
+		{Name: "a"},
+	}
+	assert.Equal(t, []egressv1.Policy{policies[2], policies[1], policies[0]},
+		sortedPolicies(map[egressv1.Policy]bool{policies[0]: true, policies[1]: true, policies[2]: true}))
+
+	build := func() []iptables.Rule {
+		snatPolicies := make(map[egressv1.Policy]*PolicyCommon)
+		for i, policy := range policies {
+			snatPolicies[policy] = &PolicyCommon{IP: IP{V4: fmt.Sprintf("10.6.1.%d", 100+i)}}
+		}
+		return buildSnatChainRules(snatPolicies, nil, nil, nil, 0, 4)
+	}
+
+	// the same policies build the same rules, so they're never swapped
+	chain := newSwapChain("EGRESSGATEWAY-SNAT-EIP")
+	chain.Stage(table, build())
+	for i := 0; i < 20; i++ {
+		chain.Stage(table, build())
+		assert.False(t, chain.Swap())
+	}
+	assert.Equal(t, "EGRESSGATEWAY-SNAT-EIP-A", chain.Active(table))
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// statusThrottle coalesces the updates of the EgressTunnel status of the node, so the agent
+// doesn't flood the API server with the updates during problems. The status is only written
+// when it's changed or the heartbeat is due, at most once in the min interval, and the
+// failed updates, e.g. the conflicts, are retried with exponential backoff.
+type statusThrottle struct {
+	minInterval time.Duration
+	period      time.Duration
+	// jitter is the percent of the random delay added to the deferred updates and removed
+	// from the heartbeat period, so the agents of the nodes don't update in lockstep
+	jitter int
+
+	mutex    sync.Mutex
+	rand     *rand.Rand
+	last     time.Time
+	written  *egressv1.EgressTunnelStatus
+	attempt  time.Time
+	failures int
+}
+
+func newStatusThrottle(minInterval, period time.Duration, jitter int) *statusThrottle {
+	if minInterval > period {
+		minInterval = period
+	}
+	return &statusThrottle{
+		minInterval: minInterval,
+		period:      period,
+		jitter:      jitter,
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Allow returns whether the status should be written now. If it should be written later,
+// the delay is greater than 0; if the status is not changed and it's not the heartbeat,
+// it's skipped and the delay is 0.
+func (t *statusThrottle) Allow(now time.Time, status *egressv1.EgressTunnelStatus, heartbeat bool) (bool, time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !heartbeat && t.written != nil && reflect.DeepEqual(t.written, withoutHeartbeat(status)) {
+		return false, 0
+	}
+	earliest := t.last.Add(t.minInterval)
+	if t.failures > 0 {
+		if next := t.attempt.Add(t.backoff()); next.After(earliest) {
+			earliest = next
+		}
+	}
+	if now.Before(earliest) {
+		return false, earliest.Sub(now) + t.randDuration(t.minInterval)
+	}
+	return true, 0
+}
+
+// Done records the result of writing the status, and returns the delay of the next
+// heartbeat, which is the backoff if it failed
+func (t *statusThrottle) Done(now time.Time, status *egressv1.EgressTunnelStatus, err error) time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err != nil {
+		t.attempt = now
+		t.failures++
+		return t.backoff()
+	}
+	t.last = now
+	t.written = withoutHeartbeat(status)
+	t.failures = 0
+	return t.period - t.randDuration(t.period)
+}
+
+// backoff doubles from the min interval by the failures, it's limited by the heartbeat
+// period, so the heartbeat is not delayed over the eviction timeout by the backoff
+func (t *statusThrottle) backoff() time.Duration {
+	backoff := t.minInterval
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	for i := 1; i < t.failures && backoff < t.period; i++ {
+		backoff *= 2
+	}
+	if backoff > t.period {
+		backoff = t.period
+	}
+	return backoff
+}
+
+// randDuration returns a random duration in the jitter percent of the d
+func (t *statusThrottle) randDuration(d time.Duration) time.Duration {
+	n := int64(d) * int64(t.jitter) / 100
+	if n <= 0 {
+		return 0
+	}
+	return time.Duration(t.rand.Int63n(n))
+}
+
+func withoutHeartbeat(status *egressv1.EgressTunnelStatus) *egressv1.EgressTunnelStatus {
+	res := status.DeepCopy()
+	res.LastHeartbeatTime = metav1.Time{}
+	return res
+}
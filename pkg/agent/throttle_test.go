@@ -0,0 +1,64 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestStatusThrottle(t *testing.T) {
+	throttle := newStatusThrottle(time.Second, 5*time.Second, 0)
+	now := time.Now()
+	status := &egressv1.EgressTunnelStatus{Phase: egressv1.EgressTunnelReady, LastHeartbeatTime: metav1.Now()}
+
+	ok, _ := throttle.Allow(now, status, false)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, throttle.Done(now, status, nil))
+
+	// the unchanged status is skipped, but the heartbeat is not
+	now = now.Add(2 * time.Second)
+	status.LastHeartbeatTime = metav1.NewTime(now)
+	ok, delay := throttle.Allow(now, status, false)
+	assert.False(t, ok)
+	assert.Equal(t, time.Duration(0), delay)
+	ok, _ = throttle.Allow(now, status, true)
+	assert.True(t, ok)
+	throttle.Done(now, status, nil)
+
+	// the change in the min interval is deferred
+	status.Phase = egressv1.EgressTunnelFailed
+	ok, delay = throttle.Allow(now.Add(300*time.Millisecond), status, false)
+	assert.False(t, ok)
+	assert.Equal(t, 700*time.Millisecond, delay)
+
+	// the failures back off exponentially, up to the heartbeat period
+	now = now.Add(time.Second)
+	conflict := errors.New("conflict")
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		assert.Equal(t, expected, throttle.Done(now, status, conflict))
+	}
+	ok, delay = throttle.Allow(now.Add(time.Second), status, true)
+	assert.False(t, ok)
+	assert.Equal(t, 4*time.Second, delay)
+	ok, _ = throttle.Allow(now.Add(5*time.Second), status, true)
+	assert.True(t, ok)
+	throttle.Done(now.Add(5*time.Second), status, nil)
+	assert.Equal(t, 0, throttle.failures)
+}
+
+func TestStatusThrottleJitter(t *testing.T) {
+	throttle := newStatusThrottle(time.Second, 5*time.Second, 10)
+	status := new(egressv1.EgressTunnelStatus)
+	for i := 0; i < 100; i++ {
+		next := throttle.Done(time.Now(), status, nil)
+		assert.True(t, next > 4500*time.Millisecond && next <= 5*time.Second, next)
+	}
+}
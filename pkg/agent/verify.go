@@ -0,0 +1,388 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
+)
+
+const (
+	// eipVerifyChain SNATs the probes of the EIP verification with their EIPs
+	eipVerifyChain = "EGRESSGATEWAY-VERIFY-EIP"
+	// verifyMarkMask matches the marks of the probes, the low 8 bits number the EIPs
+	verifyMarkMask = uint32(0xffffff00)
+	// maxVerifyProbes is the most EIPs probed in a round, the others are probed next round
+	maxVerifyProbes = 255
+	// maxVerifyResponse limits the response of the verification service
+	maxVerifyResponse = 256
+)
+
+// eipProbe is an egress IP probed in a round, the probe is marked by the Mark to be
+// SNATed with the IP
+type eipProbe struct {
+	IP   string
+	Mark uint32
+}
+
+// eipVerifier verifies the egress IPs of the policies assigned to the node every interval,
+// it probes the verification service with each IP as the source, and records whether the
+// service observes the IP in the EIPVerified condition of the policies. If the verification
+// is disabled, it removes the conditions left on the policies of the node and stops.
+type eipVerifier struct {
+	client   client.Client
+	log      logr.Logger
+	nodeName string
+	enable   bool
+	interval time.Duration
+	mark     uint32
+	// apply programs the SNAT rules of the probes
+	apply func(probes []eipProbe) error
+	// probe returns the source IP of the probe observed by the verification service
+	probe func(ctx context.Context, ip string, mark uint32) (string, error)
+}
+
+func addEIPVerifier(mgr manager.Manager, cfg *config.Config, log logr.Logger, r *policeReconciler) error {
+	conf := cfg.FileConfig.EIPVerification
+	v := &eipVerifier{
+		client:   mgr.GetClient(),
+		log:      log,
+		nodeName: cfg.EnvConfig.NodeName,
+		enable:   conf.Enable,
+		interval: time.Second * time.Duration(conf.IntervalSecond),
+		mark:     r.verifyMark(),
+		apply:    r.applyVerifyRules,
+		probe:    newSourceIPProbe(conf.URL, time.Second*time.Duration(conf.TimeoutSecond)),
+	}
+	if v.interval <= 0 {
+		v.interval = time.Minute
+	}
+	return mgr.Add(v)
+}
+
+func (v *eipVerifier) Start(ctx context.Context) error {
+	ticker := time.NewTicker(v.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err := v.verify(ctx)
+			if err != nil {
+				v.log.Error(err, "failed to verify the egress IPs of the policies")
+			}
+			if !v.enable && err == nil {
+				return nil
+			}
+		}
+	}
+}
+
+// verify probes the egress IPs of the policies on the node which are not verified yet, and
+// updates the EIPVerified condition of the policies by the results
+func (v *eipVerifier) verify(ctx context.Context) error {
+	policies, err := v.listPolicies(ctx)
+	if err != nil {
+		return err
+	}
+	if !v.enable {
+		for _, policy := range policies {
+			if err := v.updateStatus(ctx, policy, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ips := make(map[string]struct{})
+	for _, policy := range policies {
+		for _, ip := range egressIPs(policy.status) {
+			ips[ip] = struct{}{}
+		}
+	}
+	if len(ips) == 0 {
+		return nil
+	}
+	probes := make([]eipProbe, 0, len(ips))
+	for ip := range ips {
+		probes = append(probes, eipProbe{IP: ip})
+	}
+	sort.Slice(probes, func(i, j int) bool { return probes[i].IP < probes[j].IP })
+	if len(probes) > maxVerifyProbes {
+		probes = probes[:maxVerifyProbes]
+	}
+	for i := range probes {
+		probes[i].Mark = v.mark | uint32(i+1)
+	}
+
+	if err := v.apply(probes); err != nil {
+		return fmt.Errorf("failed to apply the snat rules of the probes: %w", err)
+	}
+	results := make(map[string]error, len(probes))
+	for _, probe := range probes {
+		observed, err := v.probe(ctx, probe.IP, probe.Mark)
+		switch {
+		case err != nil:
+			results[probe.IP] = err
+		case !net.ParseIP(observed).Equal(net.ParseIP(probe.IP)):
+			results[probe.IP] = &sourceIPMismatch{expected: probe.IP, observed: observed}
+		default:
+			results[probe.IP] = nil
+		}
+	}
+	if err := v.apply(nil); err != nil {
+		v.log.Error(err, "failed to remove the snat rules of the probes")
+	}
+
+	for _, policy := range policies {
+		if err := v.updateStatus(ctx, policy, results); err != nil {
+			v.log.Error(err, "failed to update the eip verification of the policy", "policy", policy.key)
+		}
+	}
+	return nil
+}
+
+// verifyPolicy is a policy assigned to the node
+type verifyPolicy struct {
+	key     types.NamespacedName
+	cluster bool
+	status  *egressv1.EgressPolicyStatus
+}
+
+// listPolicies lists the policies assigned to the node, if the verification is enabled,
+// only the policies whose egress IPs are not verified are returned
+func (v *eipVerifier) listPolicies(ctx context.Context) ([]verifyPolicy, error) {
+	res := make([]verifyPolicy, 0)
+	add := func(obj client.Object, status *egressv1.EgressPolicyStatus, cluster bool) {
+		if status.Node != v.nodeName || !obj.GetDeletionTimestamp().IsZero() {
+			return
+		}
+		if v.enable && status.EIPVerified() {
+			return
+		}
+		res = append(res, verifyPolicy{key: client.ObjectKeyFromObject(obj), cluster: cluster, status: status})
+	}
+	egpList := new(egressv1.EgressPolicyList)
+	if err := v.client.List(ctx, egpList); err != nil {
+		return nil, err
+	}
+	for i := range egpList.Items {
+		add(&egpList.Items[i], &egpList.Items[i].Status, false)
+	}
+	egcpList := new(egressv1.EgressClusterPolicyList)
+	if err := v.client.List(ctx, egcpList); err != nil {
+		return nil, err
+	}
+	for i := range egcpList.Items {
+		add(&egcpList.Items[i], &egcpList.Items[i].Status, true)
+	}
+	return res, nil
+}
+
+// updateStatus records the results of the egress IPs of the policy in its EIPVerified
+// condition, and updates the Ready condition by it. The condition is removed if the
+// results are nil. The policy moved to another node or to another IP is skipped.
+func (v *eipVerifier) updateStatus(ctx context.Context, policy verifyPolicy, results map[string]error) error {
+	var obj client.Object
+	var status *egressv1.EgressPolicyStatus
+	if policy.cluster {
+		egcp := new(egressv1.EgressClusterPolicy)
+		obj, status = egcp, &egcp.Status
+	} else {
+		egp := new(egressv1.EgressPolicy)
+		obj, status = egp, &egp.Status
+	}
+
+	var err error
+	for i := 0; i < 5; i++ {
+		if err = v.client.Get(ctx, policy.key, obj); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if !obj.GetDeletionTimestamp().IsZero() || status.Node != v.nodeName {
+			return nil
+		}
+		var changed bool
+		if results == nil {
+			changed = status.RemoveEIPVerifiedCondition()
+		} else {
+			if status.EgressIP() != policy.status.EgressIP() {
+				return nil
+			}
+			verified, reason, message := verifyResult(egressIPs(status), results, v.nodeName)
+			if reason == "" {
+				return nil
+			}
+			changed = status.SetEIPVerifiedCondition(obj.GetGeneration(), verified, reason, message)
+		}
+		changed = status.SetReadyCondition(obj.GetGeneration()) || changed
+		if !changed {
+			return nil
+		}
+		err = v.client.Status().Update(ctx, obj)
+		if err == nil || apierr.IsNotFound(err) {
+			return nil
+		}
+		if !apierr.IsConflict(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// verifyResult returns the EIPVerified condition of the egress IPs by the results of the
+// probes, the reason is empty if any IP is not probed in the round
+func verifyResult(ips []string, results map[string]error, node string) (bool, string, string) {
+	for _, ip := range ips {
+		err, ok := results[ip]
+		if !ok {
+			return false, "", ""
+		}
+		if err == nil {
+			continue
+		}
+		if mismatch, ok := err.(*sourceIPMismatch); ok {
+			return false, egressv1.ReasonEIPSourceIPMismatch, mismatch.Error()
+		}
+		return false, egressv1.ReasonEIPProbeFailed, fmt.Sprintf("failed to probe the verification service with %s: %v", ip, err)
+	}
+	return true, egressv1.ReasonEIPVerified,
+		fmt.Sprintf("the verification service observes %s from node %s", strings.Join(ips, ","), node)
+}
+
+// sourceIPMismatch is the source IP observed by the verification service which is not
+// the egress IP, e.g. it's rewritten by the upstream SNAT
+type sourceIPMismatch struct {
+	expected string
+	observed string
+}
+
+func (e *sourceIPMismatch) Error() string {
+	return fmt.Sprintf("the verification service observes %s instead of %s, the egress IP may be rewritten by the upstream SNAT",
+		e.observed, e.expected)
+}
+
+// egressIPs returns the IPv4 and IPv6 egress IPs of the policy
+func egressIPs(status *egressv1.EgressPolicyStatus) []string {
+	ip := status.EgressIP()
+	res := make([]string, 0, 2)
+	if ip.Ipv4 != "" {
+		res = append(res, ip.Ipv4)
+	}
+	if ip.Ipv6 != "" {
+		res = append(res, ip.Ipv6)
+	}
+	return res
+}
+
+// newSourceIPProbe returns the probe which GETs the url with the marked connection, the
+// body of the response is the source IP observed by the service
+func newSourceIPProbe(url string, timeout time.Duration) func(ctx context.Context, ip string, mark uint32) (string, error) {
+	return func(ctx context.Context, ip string, mark uint32) (string, error) {
+		network := "tcp4"
+		if net.ParseIP(ip).To4() == nil {
+			network = "tcp6"
+		}
+		dialer := &net.Dialer{Control: markControl(mark)}
+		cli := &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+					return dialer.DialContext(ctx, network, addr)
+				},
+				DisableKeepAlives: true,
+			},
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := cli.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxVerifyResponse))
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		observed := net.ParseIP(strings.TrimSpace(string(body)))
+		if observed == nil {
+			return "", fmt.Errorf("invalid source IP %q in the response", strings.TrimSpace(string(body)))
+		}
+		return observed.String(), nil
+	}
+}
+
+// verifyMark returns the base mark of the probes, it's 0 if the verification is disabled
+func (r *policeReconciler) verifyMark() uint32 {
+	conf := r.cfg.FileConfig.EIPVerification
+	if !conf.Enable {
+		return 0
+	}
+	mark, err := markallocator.Parse(conf.Mark)
+	if err != nil {
+		return 0
+	}
+	return uint32(mark)
+}
+
+// applyVerifyRules programs the SNAT rules of the probes in the nat tables
+func (r *policeReconciler) applyVerifyRules(probes []eipProbe) error {
+	r.tablesMu.Lock()
+	defer r.tablesMu.Unlock()
+	r.verifyProbes = probes
+
+	markSpace, err := markallocator.NewSpace(r.cfg.FileConfig.Mark, r.cfg.FileConfig.MarkMask)
+	if err != nil {
+		return err
+	}
+	for _, table := range r.natTables {
+		table.UpdateChain(&iptables.Chain{Name: eipVerifyChain, Rules: buildVerifyRules(probes, table.IPVersion)})
+		for chain, rules := range buildNatStaticRule(markSpace, r.verifyMark(), r.proxyMark()) {
+			table.InsertOrAppendRules(chain, rules)
+		}
+		if _, err := table.Apply(); err != nil {
+			return fmt.Errorf("failed to apply rule %v: %v", table.Name, err)
+		}
+	}
+	return nil
+}
+
+// buildVerifyRules SNATs the probes of the IP version with their egress IPs
+func buildVerifyRules(probes []eipProbe, version uint8) []iptables.Rule {
+	rules := make([]iptables.Rule, 0)
+	for _, probe := range probes {
+		isV4 := net.ParseIP(probe.IP).To4() != nil
+		if isV4 != (version == 4) {
+			continue
+		}
+		rules = append(rules, iptables.Rule{
+			Match:   iptables.MatchCriteria{}.MarkMatchesWithMask(probe.Mark, 0xffffffff),
+			Action:  iptables.SNATAction{ToAddr: probe.IP},
+			Comment: []string{"SNAT the probe of the EIP verification with " + probe.IP},
+		})
+	}
+	return rules
+}
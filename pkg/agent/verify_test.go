@@ -0,0 +1,157 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func assignedStatus(node, ipv4 string) egressv1.EgressPolicyStatus {
+	status := egressv1.EgressPolicyStatus{Node: node, Eip: egressv1.Eip{Ipv4: ipv4}}
+	status.RequireEIPVerification(1)
+	status.SetReadyCondition(1)
+	return status
+}
+
+func TestEIPVerifier(t *testing.T) {
+	ctx := context.Background()
+	verified := &egressv1.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "verified", Namespace: "default"},
+		Status:     assignedStatus("node1", "10.6.1.100"),
+	}
+	rewritten := &egressv1.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "rewritten", Namespace: "default"},
+		Status:     assignedStatus("node1", "10.6.1.101"),
+	}
+	failed := &egressv1.EgressClusterPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "failed"},
+		Status:     assignedStatus("node1", "10.6.1.102"),
+	}
+	other := &egressv1.EgressClusterPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "other"},
+		Status:     assignedStatus("node2", "10.6.1.103"),
+	}
+	assert.False(t, meta.IsStatusConditionTrue(verified.Status.Conditions, egressv1.PolicyConditionReady))
+
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(verified, rewritten, failed, other).
+		WithStatusSubresource(verified, rewritten, failed, other).
+		Build()
+
+	applied := make([][]eipProbe, 0)
+	v := &eipVerifier{
+		client:   cli,
+		log:      logr.Discard(),
+		nodeName: "node1",
+		enable:   true,
+		mark:     0x27000000,
+		apply: func(probes []eipProbe) error {
+			applied = append(applied, probes)
+			return nil
+		},
+		probe: func(ctx context.Context, ip string, mark uint32) (string, error) {
+			switch ip {
+			case "10.6.1.101":
+				return "192.0.2.1", nil
+			case "10.6.1.102":
+				return "", errors.New("timeout")
+			}
+			return ip, nil
+		},
+	}
+	assert.NoError(t, v.verify(ctx))
+	assert.Equal(t, [][]eipProbe{{
+		{IP: "10.6.1.100", Mark: 0x27000001},
+		{IP: "10.6.1.101", Mark: 0x27000002},
+		{IP: "10.6.1.102", Mark: 0x27000003},
+	}, nil}, applied)
+
+	egp := new(egressv1.EgressPolicy)
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Namespace: "default", Name: "verified"}, egp))
+	assert.True(t, egp.Status.EIPVerified())
+	assert.Equal(t, egressv1.Eip{Ipv4: "10.6.1.100"}, egp.Status.VerifiedEIP)
+	assert.True(t, meta.IsStatusConditionTrue(egp.Status.Conditions, egressv1.PolicyConditionReady))
+
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Namespace: "default", Name: "rewritten"}, egp))
+	cond := meta.FindStatusCondition(egp.Status.Conditions, egressv1.PolicyConditionEIPVerified)
+	assert.Equal(t, egressv1.ReasonEIPSourceIPMismatch, cond.Reason)
+	ready := meta.FindStatusCondition(egp.Status.Conditions, egressv1.PolicyConditionReady)
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, "EIPUnverified", ready.Reason)
+	assert.Contains(t, ready.Message, "192.0.2.1")
+
+	egcp := new(egressv1.EgressClusterPolicy)
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Name: "failed"}, egcp))
+	cond = meta.FindStatusCondition(egcp.Status.Conditions, egressv1.PolicyConditionEIPVerified)
+	assert.Equal(t, egressv1.ReasonEIPProbeFailed, cond.Reason)
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Name: "other"}, egcp))
+	assert.Equal(t, egressv1.ReasonEIPPending,
+		meta.FindStatusCondition(egcp.Status.Conditions, egressv1.PolicyConditionEIPVerified).Reason)
+
+	// the verified EIP isn't probed again, and it's unverified when the EIP is changed
+	applied = applied[:0]
+	assert.NoError(t, v.verify(ctx))
+	assert.Len(t, applied[0], 2)
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Namespace: "default", Name: "verified"}, egp))
+	egp.Status.Eip.Ipv4 = "10.6.1.104"
+	egp.Status.SetReadyCondition(egp.Generation)
+	assert.False(t, meta.IsStatusConditionTrue(egp.Status.Conditions, egressv1.PolicyConditionReady))
+
+	// the conditions are removed when the verification is disabled
+	v.enable = false
+	assert.NoError(t, v.verify(ctx))
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Namespace: "default", Name: "rewritten"}, egp))
+	assert.Nil(t, meta.FindStatusCondition(egp.Status.Conditions, egressv1.PolicyConditionEIPVerified))
+	assert.True(t, meta.IsStatusConditionTrue(egp.Status.Conditions, egressv1.PolicyConditionReady))
+}
+
+func TestSourceIPProbe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/404" {
+			http.NotFound(w, r)
+			return
+		}
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		fmt.Fprintln(w, host)
+	}))
+	defer srv.Close()
+
+	probe := newSourceIPProbe(srv.URL, time.Second)
+	observed, err := probe(context.Background(), "127.0.0.1", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", observed)
+
+	probe = newSourceIPProbe(srv.URL+"/404", time.Second)
+	_, err = probe(context.Background(), "127.0.0.1", 0)
+	assert.Error(t, err)
+}
+
+func TestBuildVerifyRules(t *testing.T) {
+	probes := []eipProbe{{IP: "10.6.1.100", Mark: 0x27000001}, {IP: "fd00::100", Mark: 0x27000002}}
+	rules := buildVerifyRules(probes, 4)
+	assert.Len(t, rules, 1)
+	assert.Contains(t, rules[0].Match.Render(), "0x27000001/0xffffffff")
+	rules = buildVerifyRules(probes, 6)
+	assert.Len(t, rules, 1)
+	assert.Equal(t, "--jump SNAT --to-source fd00::100", rules[0].Action.ToFragment(&iptables.Options{}))
+}
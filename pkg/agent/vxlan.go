@@ -7,9 +7,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"net"
 	"reflect"
-	"strconv"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -17,8 +18,10 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/vishvananda/netlink"
 	k8sErr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
@@ -27,15 +30,23 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	"github.com/spidernet-io/egressgateway/pkg/agent/probe"
 	"github.com/spidernet-io/egressgateway/pkg/agent/route"
 	"github.com/spidernet-io/egressgateway/pkg/agent/vxlan"
 	"github.com/spidernet-io/egressgateway/pkg/config"
+	egresserrors "github.com/spidernet-io/egressgateway/pkg/errors"
 	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
+	"github.com/spidernet-io/egressgateway/pkg/privilege"
 	"github.com/spidernet-io/egressgateway/pkg/utils"
 )
 
 var ErrHeartbeatTime = errors.New("heartbeat time")
 
+// loopLogInterval is the interval that the same error of the keep loops is logged
+const loopLogInterval = time.Minute
+
 type vxlanReconciler struct {
 	client client.Client
 	log    logr.Logger
@@ -46,11 +57,46 @@ type vxlanReconciler struct {
 
 	vxlan     *vxlan.Device
 	getParent func(version int) (*vxlan.Parent, error)
+	sysctl    privilege.SysctlWriter
 
 	ruleRoute      *route.RuleRoute
 	ruleRouteCache *utils.SyncMap[string, []net.IP]
+	// markSpace is the marks of the gateway nodes
+	markSpace markallocator.Space
+	// tables allocates the routing tables avoiding the tables of the others
+	tables *route.Tables
+	// replyTable is the routing table of the reply route on the gateway node
+	replyTable int
 
 	updateTimer *time.Timer
+	// statusThrottle coalesces the updates of the EgressTunnel status of the node
+	statusThrottle *statusThrottle
+
+	probeResults *utils.SyncMap[string, probe.Result]
+
+	// returnPathResults is the return path probe results of the gateway nodes, key is the node name
+	returnPathResults *utils.SyncMap[string, probe.ReturnPathResult]
+
+	// upstreamResults is the probe results of the upstream next hops, key is the next hop IP
+	upstreamResults *utils.SyncMap[string, upstreamResult]
+
+	// networkDevs is the vxlan devices of the dedicated tunnel networks, key is the device name
+	networkDevs map[string]*vxlan.Device
+
+	// loopLog logs the recurring errors of the keep loops
+	loopLog *logger.Deduper
+
+	// resync wakes keepVXLAN up before its next period
+	resync chan struct{}
+
+	readiness *datapathReadiness
+
+	// datapathErrs tracks the failures of programming the datapath, which fail the reconciles
+	// and the tunnel of the node in the strict datapath mode
+	datapathErrs *datapathErrors
+
+	// neighTable raises the gc thresholds of the neighbor tables on the overflow
+	neighTable *neighTableTuner
 }
 
 type VTEP struct {
@@ -62,6 +108,7 @@ type VTEP struct {
 type replyRoute struct {
 	tunnelIP  net.IP
 	linkIndex int
+	protocol  netlink.RouteProtocol
 }
 
 func (r *vxlanReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
@@ -119,8 +166,9 @@ func (r *vxlanReconciler) syncReplayRoute(log logr.Logger) error {
 		return nil
 	}
 
-	table := r.cfg.FileConfig.GatewayReplyRouteTable
+	table := r.replyTable
 	mark := r.cfg.FileConfig.GatewayReplyRouteMark
+	protocol := route.Protocol
 	ipv4RouteMap := make(map[string]replyRoute, 0)
 	ipv6RouteMap := make(map[string]replyRoute, 0)
 	hostIPV4RouteMap := make(map[string]replyRoute, 0)
@@ -262,12 +310,12 @@ func (r *vxlanReconciler) syncReplayRoute(log logr.Logger) error {
 
 	for _, route := range ipV4Routes {
 		if route.Table == table {
-			hostIPV4RouteMap[route.Dst.IP.String()] = replyRoute{tunnelIP: route.Gw, linkIndex: route.LinkIndex}
+			hostIPV4RouteMap[route.Dst.IP.String()] = replyRoute{tunnelIP: route.Gw, linkIndex: route.LinkIndex, protocol: route.Protocol}
 		}
 	}
 	for _, route := range ipV6Routes {
 		if route.Table == table {
-			hostIPV6RouteMap[route.Dst.IP.String()] = replyRoute{tunnelIP: route.Gw, linkIndex: route.LinkIndex}
+			hostIPV6RouteMap[route.Dst.IP.String()] = replyRoute{tunnelIP: route.Gw, linkIndex: route.LinkIndex, protocol: route.Protocol}
 		}
 	}
 
@@ -275,7 +323,7 @@ func (r *vxlanReconciler) syncReplayRoute(log logr.Logger) error {
 	if r.cfg.FileConfig.EnableIPv4 {
 		// delete unnecessary or incorrect routes from the host
 		for k, v := range hostIPV4RouteMap {
-			route := &netlink.Route{LinkIndex: index, Dst: &net.IPNet{IP: net.ParseIP(k).To4(), Mask: net.CIDRMask(32, 32)}, Gw: v.tunnelIP, Table: table}
+			route := &netlink.Route{LinkIndex: index, Dst: &net.IPNet{IP: net.ParseIP(k).To4(), Mask: net.CIDRMask(32, 32)}, Gw: v.tunnelIP, Table: table, Protocol: v.protocol}
 			if _, ok := ipv4RouteMap[k]; !ok {
 				err = netlink.RouteDel(route)
 				if err != nil {
@@ -283,7 +331,7 @@ func (r *vxlanReconciler) syncReplayRoute(log logr.Logger) error {
 					continue
 				}
 			} else {
-				if v.tunnelIP.String() != ipv4RouteMap[k].tunnelIP.String() || index != v.linkIndex {
+				if v.tunnelIP.String() != ipv4RouteMap[k].tunnelIP.String() || index != v.linkIndex || v.protocol != protocol {
 					err = netlink.RouteDel(route)
 					if err != nil {
 						log.Error(err, "failed to delete route; ", "route=", route)
@@ -293,6 +341,7 @@ func (r *vxlanReconciler) syncReplayRoute(log logr.Logger) error {
 					route.ILinkIndex = index
 					route.Dst = &net.IPNet{IP: net.ParseIP(k).To4(), Mask: net.CIDRMask(32, 32)}
 					route.Gw = ipv4RouteMap[k].tunnelIP
+					route.Protocol = protocol
 					err = netlink.RouteAdd(route)
 					if err != nil {
 						log.Error(err, "failed to add route; ", "route=", route)
@@ -305,7 +354,7 @@ func (r *vxlanReconciler) syncReplayRoute(log logr.Logger) error {
 		// add a missing route from the host
 		for k, v := range ipv4RouteMap {
 			if _, ok := hostIPV4RouteMap[k]; !ok {
-				route := &netlink.Route{LinkIndex: index, Dst: &net.IPNet{IP: net.ParseIP(k).To4(), Mask: net.CIDRMask(32, 32)}, Gw: v.tunnelIP, Table: table}
+				route := &netlink.Route{LinkIndex: index, Dst: &net.IPNet{IP: net.ParseIP(k).To4(), Mask: net.CIDRMask(32, 32)}, Gw: v.tunnelIP, Table: table, Protocol: protocol}
 				err = netlink.RouteAdd(route)
 				log.Info("add ", "route=", route)
 				if err != nil {
@@ -319,7 +368,7 @@ func (r *vxlanReconciler) syncReplayRoute(log logr.Logger) error {
 	// IPV6
 	if r.cfg.FileConfig.EnableIPv6 {
 		for k, v := range hostIPV6RouteMap {
-			route := &netlink.Route{LinkIndex: index, Dst: &net.IPNet{IP: net.ParseIP(k).To16(), Mask: net.CIDRMask(128, 128)}, Gw: v.tunnelIP, Table: table}
+			route := &netlink.Route{LinkIndex: index, Dst: &net.IPNet{IP: net.ParseIP(k).To16(), Mask: net.CIDRMask(128, 128)}, Gw: v.tunnelIP, Table: table, Protocol: v.protocol}
 			if _, ok := ipv6RouteMap[k]; !ok {
 				err = netlink.RouteDel(route)
 				if err != nil {
@@ -327,7 +376,7 @@ func (r *vxlanReconciler) syncReplayRoute(log logr.Logger) error {
 					continue
 				}
 			} else {
-				if v.tunnelIP.String() != ipv6RouteMap[k].tunnelIP.String() || index != v.linkIndex {
+				if v.tunnelIP.String() != ipv6RouteMap[k].tunnelIP.String() || index != v.linkIndex || v.protocol != protocol {
 					err = netlink.RouteDel(route)
 					if err != nil {
 						log.Error(err, "failed to delete route; ", "route=", route)
@@ -337,6 +386,7 @@ func (r *vxlanReconciler) syncReplayRoute(log logr.Logger) error {
 					route.ILinkIndex = index
 					route.Dst = &net.IPNet{IP: net.ParseIP(k).To16(), Mask: net.CIDRMask(128, 128)}
 					route.Gw = ipv6RouteMap[k].tunnelIP
+					route.Protocol = protocol
 					err = netlink.RouteAdd(route)
 					if err != nil {
 						log.Error(err, "failed to add route; ", "route=", route)
@@ -348,7 +398,7 @@ func (r *vxlanReconciler) syncReplayRoute(log logr.Logger) error {
 
 		for k, v := range ipv6RouteMap {
 			if _, ok := hostIPV6RouteMap[k]; !ok {
-				route := &netlink.Route{LinkIndex: index, Dst: &net.IPNet{IP: net.ParseIP(k).To16(), Mask: net.CIDRMask(1, 128)}, Gw: v.tunnelIP, Table: table}
+				route := &netlink.Route{LinkIndex: index, Dst: &net.IPNet{IP: net.ParseIP(k).To16(), Mask: net.CIDRMask(1, 128)}, Gw: v.tunnelIP, Table: table, Protocol: protocol}
 				err = netlink.RouteAdd(route)
 				if err != nil {
 					log.Error(err, "failed to add route; ", "route=", route)
@@ -368,17 +418,21 @@ func (r *vxlanReconciler) reconcileEgressGateway(ctx context.Context, req reconc
 		return reconcile.Result{}, err
 	}
 
+	errs := make([]error, 0)
 	r.peerMap.Range(func(key string, val vxlan.Peer) bool {
 		if _, ok := egressTunnelMap[key]; ok {
-			err = r.ruleRoute.Ensure(r.cfg.FileConfig.VXLAN.Name, val.IPv4, val.IPv6, val.Mark, val.Mark)
+			err = r.ensurePeerRoute(r.cfg.FileConfig.VXLAN.Name, val, r.cfg.FileConfig.RouteTable.Base)
 			if err != nil {
 				r.log.Error(err, "vxlan reconcile EgressGateway with error")
 			}
+			if err := r.datapathErrs.Set("peer/"+key, err); err != nil {
+				errs = append(errs, err)
+			}
 		}
 		return true
 	})
 
-	return reconcile.Result{}, nil
+	return reconcile.Result{}, utilerrors.NewAggregate(errs)
 }
 
 // reconcileEgressTunnel
@@ -401,10 +455,12 @@ func (r *vxlanReconciler) reconcileEgressTunnel(ctx context.Context, req reconci
 	if deleted {
 		if isPeer {
 			r.peerMap.Delete(req.Name)
+			_ = r.datapathErrs.Set("peer/"+req.Name, nil)
 			err := r.ensureRoute()
 			if err != nil {
 				log.Error(err, "delete egress tunnel, ensure route with error")
 			}
+			return reconcile.Result{}, r.datapathErrs.Set("route", err)
 		}
 		return reconcile.Result{}, nil
 	}
@@ -432,7 +488,7 @@ func (r *vxlanReconciler) reconcileEgressTunnel(ctx context.Context, req reconci
 		ipv4 := net.ParseIP(node.Status.Tunnel.IPv4).To4()
 		ipv6 := net.ParseIP(node.Status.Tunnel.IPv6).To16()
 
-		peer := vxlan.Peer{Parent: parentIP, MAC: mac}
+		peer := vxlan.Peer{Parent: parentIP, MAC: mac, Previous: r.previousIPs(node.Status)}
 		if ipv4 != nil {
 			peer.IPv4 = &ipv4
 		}
@@ -441,15 +497,24 @@ func (r *vxlanReconciler) reconcileEgressTunnel(ctx context.Context, req reconci
 		}
 		baseMark, err := parseMarkToInt(node.Status.Mark)
 		if err != nil {
+			// the mark is empty until it's allocated by the controller
+			if node.Status.Mark != "" {
+				log.Error(err, "invalid mark of the egress tunnel", "mark", node.Status.Mark)
+			}
 		} else {
 			peer.Mark = baseMark
 		}
 
+		r.refreshPeerParent(node.Name, peer, log)
 		r.peerMap.Store(node.Name, peer)
+		errs := make([]error, 0)
 		err = r.ensureRoute()
 		if err != nil {
 			log.Error(err, "add egress tunnel, ensure route with error")
 		}
+		if err := r.datapathErrs.Set("route", err); err != nil {
+			errs = append(errs, err)
+		}
 
 		egressTunnelMap, err := r.listEgressTunnel(ctx)
 		if err != nil {
@@ -457,13 +522,16 @@ func (r *vxlanReconciler) reconcileEgressTunnel(ctx context.Context, req reconci
 		}
 		if _, ok := egressTunnelMap[node.Name]; ok {
 			// if it is egresstunnel
-			err = r.ruleRoute.Ensure(r.cfg.FileConfig.VXLAN.Name, peer.IPv4, peer.IPv6, peer.Mark, peer.Mark)
+			err = r.ensurePeerRoute(r.cfg.FileConfig.VXLAN.Name, peer, r.cfg.FileConfig.RouteTable.Base)
 			if err != nil {
 				r.log.Error(err, "ensure vxlan link")
 			}
+			if err := r.datapathErrs.Set("peer/"+node.Name, err); err != nil {
+				errs = append(errs, err)
+			}
 		}
 
-		return reconcile.Result{}, nil
+		return reconcile.Result{}, utilerrors.NewAggregate(errs)
 	}
 
 	err = r.ensureEgressTunnelStatus(node)
@@ -532,15 +600,10 @@ func (r *vxlanReconciler) ensureEgressTunnelStatus(node *egressv1.EgressTunnel)
 }
 
 func (r *vxlanReconciler) updateEgressTunnelStatus(tunnel *egressv1.EgressTunnel, version int) error {
-	parent, err := r.getParent(version)
-	if err != nil {
-		return err
-	}
-
 	if tunnel == nil {
 		tunnel = new(egressv1.EgressTunnel)
 		ctx := context.Background()
-		err = r.client.Get(ctx, types.NamespacedName{Name: r.cfg.NodeName}, tunnel)
+		err := r.client.Get(ctx, types.NamespacedName{Name: r.cfg.NodeName}, tunnel)
 		if err != nil {
 			if k8sErr.IsNotFound(err) {
 				return nil
@@ -549,6 +612,28 @@ func (r *vxlanReconciler) updateEgressTunnelStatus(tunnel *egressv1.EgressTunnel
 		}
 	}
 
+	needUpdate, err := r.setParentStatus(tunnel, version)
+	if err != nil {
+		return err
+	}
+	if needUpdate {
+		err := r.updateTunnelStatus(tunnel, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setParentStatus sets the parent and the phase of the tunnel of the node, and returns
+// whether they are changed
+func (r *vxlanReconciler) setParentStatus(tunnel *egressv1.EgressTunnel, version int) (bool, error) {
+	parent, err := r.getParent(version)
+	if err != nil {
+		return false, err
+	}
+
 	needUpdate := false
 	if tunnel.Status.Tunnel.Parent.Name != parent.Name {
 		needUpdate = true
@@ -581,20 +666,15 @@ func (r *vxlanReconciler) updateEgressTunnelStatus(tunnel *egressv1.EgressTunnel
 		phase := egressv1.EgressTunnelReady
 		// We should not overwrite the updated state of the controller.
 		if tunnel.Status.Phase != phase &&
-			tunnel.Status.Phase != egressv1.EgressTunnelNodeNotReady {
+			tunnel.Status.Phase != egressv1.EgressTunnelFailed &&
+			tunnel.Status.Phase != egressv1.EgressTunnelNodeNotReady &&
+			tunnel.Status.Phase != egressv1.EgressTunnelUnreachable &&
+			tunnel.Status.Phase != egressv1.EgressTunnelUpstreamDown {
 			needUpdate = true
 			tunnel.Status.Phase = phase
 		}
 	}
-
-	if needUpdate {
-		err := r.updateTunnelStatus(tunnel)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return needUpdate, nil
 }
 
 func (r *vxlanReconciler) syncLastHeartbeatTime(ctx context.Context) error {
@@ -613,17 +693,20 @@ func (r *vxlanReconciler) syncLastHeartbeatTime(ctx context.Context) error {
 					break
 				}
 				r.log.Error(err, "update tunnel status")
-				r.updateTimer.Reset(time.Second)
+				r.updateTimer.Reset(r.statusThrottle.Done(time.Now(), &tunnel.Status, err))
 				break
 			}
+			// the changes of the parent deferred by the throttle are written with the heartbeat
+			if _, err := r.setParentStatus(tunnel, r.version()); err != nil {
+				r.log.V(1).Info("failed to get the parent of the tunnel", "error", err.Error())
+			}
 			r.log.V(1).Info("update tunnel last heartbeat time")
-			err = r.updateTunnelStatus(tunnel)
+			err = r.updateTunnelStatus(tunnel, true)
 			if err != nil {
 				if strings.Contains(err.Error(), "context deadline exceeded") {
 					return ErrHeartbeatTime
 				}
 				r.log.Error(err, "update tunnel status")
-				r.updateTimer.Reset(time.Second)
 				break
 			}
 		}
@@ -665,7 +748,23 @@ func (r *vxlanReconciler) parseVTEP(status egressv1.EgressTunnelStatus) *vxlan.P
 	if !ready {
 		return nil
 	}
-	return &vxlan.Peer{IPv4: ipv4, IPv6: ipv6, MAC: mac}
+	return &vxlan.Peer{IPv4: ipv4, IPv6: ipv6, MAC: mac, Previous: r.previousIPs(status)}
+}
+
+// previousIPs returns the previous tunnel IPs which are kept while the tunnel subnet is renumbered
+func (r *vxlanReconciler) previousIPs(status egressv1.EgressTunnelStatus) []net.IP {
+	previous := status.Tunnel.Previous
+	if previous == nil || !time.Now().Before(previous.ExpireTime.Time) {
+		return nil
+	}
+	res := make([]net.IP, 0, 2)
+	if ip := net.ParseIP(previous.IPv4).To4(); r.cfg.FileConfig.EnableIPv4 && ip != nil {
+		res = append(res, ip)
+	}
+	if ip := net.ParseIP(previous.IPv6).To16(); r.cfg.FileConfig.EnableIPv6 && ip != nil {
+		res = append(res, ip)
+	}
+	return res
 }
 
 func (r *vxlanReconciler) version() int {
@@ -690,7 +789,22 @@ func (r *vxlanReconciler) keepVXLAN() {
 		vni := r.cfg.FileConfig.VXLAN.ID
 		port := r.cfg.FileConfig.VXLAN.Port
 		mac := vtep.MAC
-		disableChecksumOffload := r.cfg.FileConfig.VXLAN.DisableChecksumOffload
+		opts := vxlan.LinkOptions{
+			SrcPortLow:               r.cfg.FileConfig.VXLAN.SrcPortLow,
+			SrcPortHigh:              r.cfg.FileConfig.VXLAN.SrcPortHigh,
+			DisableChecksumOffload:   r.cfg.FileConfig.VXLAN.DisableChecksumOffload,
+			DisableRXChecksumOffload: r.cfg.FileConfig.VXLAN.DisableRXChecksumOffload,
+			DisableGRO:               r.cfg.FileConfig.VXLAN.DisableGRO,
+			DisableGSO:               r.cfg.FileConfig.VXLAN.DisableGSO,
+			TOS:                      r.cfg.FileConfig.VXLAN.TOS,
+		}
+		for _, ip := range vtep.Previous {
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			opts.PreviousAddrs = append(opts.PreviousAddrs, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		}
 
 		var ipv4, ipv6 *net.IPNet
 		if r.cfg.FileConfig.EnableIPv4 && vtep.IPv4.To4() != nil {
@@ -708,52 +822,72 @@ func (r *vxlanReconciler) keepVXLAN() {
 
 		err := r.updateEgressTunnelStatus(nil, r.version())
 		if err != nil {
-			r.log.Error(err, "update EgressTunnel status")
+			r.loopLog.Error(err, "update EgressTunnel status")
 			time.Sleep(time.Second)
 			continue
 		}
+		r.loopLog.Resolved("update EgressTunnel status")
 
-		err = r.vxlan.EnsureLink(name, vni, port, mac, 0, ipv4, ipv6, disableChecksumOffload)
+		err = r.vxlan.EnsureLink(name, vni, port, mac, 0, ipv4, ipv6, opts)
 		if err != nil {
-			r.log.Error(err, "ensure vxlan link")
+			r.loopLog.Error(err, "ensure vxlan link")
 			reduce = false
 			time.Sleep(time.Second)
 			continue
 		}
+		r.loopLog.Resolved("ensure vxlan link")
 
 		r.log.V(1).Info("link ensure has completed")
 
 		err = r.ensureRoute()
+		_ = r.datapathErrs.Set("route", err)
 		if err != nil {
-			r.log.Error(err, "ensure route")
+			r.loopLog.Error(err, "ensure route")
 			reduce = false
 			time.Sleep(time.Second)
 			continue
 		}
+		r.loopLog.Resolved("ensure route")
+		r.recordPeerSync()
 
 		r.log.V(1).Info("route ensure has completed")
 
+		converged := true
 		markMap := make(map[int]struct{})
 		r.peerMap.Range(func(key string, val vxlan.Peer) bool {
 			egressTunnelMap, err := r.listEgressTunnel(context.Background())
 			if err != nil {
-				r.log.Error(err, "ensure vxlan list EgressTunnel with error")
+				r.loopLog.Error(err, "ensure vxlan list EgressTunnel with error")
 				return false
 			}
 			if _, ok := egressTunnelMap[key]; ok && val.Mark != 0 {
 				markMap[val.Mark] = struct{}{}
-				err = r.ruleRoute.Ensure(r.cfg.FileConfig.VXLAN.Name, val.IPv4, val.IPv6, val.Mark, val.Mark)
+				err = r.ensurePeerRoute(r.cfg.FileConfig.VXLAN.Name, val, r.cfg.FileConfig.RouteTable.Base)
+				_ = r.datapathErrs.Set("peer/"+key, err)
 				if err != nil {
-					r.log.Error(err, "ensure vxlan link with error")
+					r.loopLog.Error(err, "ensure vxlan link with error", "peer", key)
 					reduce = false
+					converged = false
 				}
 			}
 			return true
 		})
-		err = r.ruleRoute.PurgeStaleRules(markMap, r.cfg.FileConfig.Mark)
+		err = r.ensureNetworks(context.Background(), markMap)
+		_ = r.datapathErrs.Set("networks", err)
 		if err != nil {
-			r.log.Error(err, "purge stale rules error")
+			r.loopLog.Error(err, "ensure tunnel networks with error")
 			reduce = false
+			time.Sleep(time.Second)
+			continue
+		}
+		r.loopLog.Resolved("ensure tunnel networks with error")
+
+		err = r.ruleRoute.PurgeStaleRules(markMap, r.markSpace)
+		_ = r.datapathErrs.Set("purge", err)
+		if err != nil {
+			r.loopLog.Error(err, "purge stale rules error")
+			reduce = false
+			converged = false
 		}
 
 		r.log.V(1).Info("route rule ensure has completed")
@@ -762,16 +896,47 @@ func (r *vxlanReconciler) keepVXLAN() {
 			r.log.Info("vxlan and route has completed")
 			reduce = true
 		}
+		if converged {
+			r.readiness.Done(datapathVXLAN)
+		}
 
-		time.Sleep(time.Second * 10)
+		select {
+		case <-time.After(time.Second * 10):
+		case <-r.resync:
+		}
 	}
 }
 
-func (r *vxlanReconciler) updateTunnelStatus(tunnel *egressv1.EgressTunnel) error {
+// updateTunnelStatus writes the status of the tunnel of the node, the heartbeat is written
+// even if the status is not changed. The updates are coalesced by the status throttle, the
+// deferred ones are written by the heartbeat loop.
+func (r *vxlanReconciler) updateTunnelStatus(tunnel *egressv1.EgressTunnel, heartbeat bool) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.cfg.FileConfig.GatewayFailover.EipEvictionTimeout)*time.Second)
 	defer cancel()
 
 	tunnel.Status.LastHeartbeatTime = metav1.Now()
+	r.datapathErrs.setDatapathCondition(&tunnel.Status, tunnel.Generation, r.parseVTEP(tunnel.Status) != nil)
+	r.neighTable.setCondition(&tunnel.Status, tunnel.Generation)
+	tunnel.Status.SetReadyCondition(tunnel.Generation)
+	if r.cfg.FileConfig.GatewayFailover.TunnelProbe.Enable {
+		tunnel.Status.Peers = r.peerStatus()
+	}
+	if r.cfg.FileConfig.GatewayFailover.UpstreamProbe.Enable {
+		tunnel.Status.Upstreams = r.upstreamStatus()
+	}
+	if r.cfg.FileConfig.GatewayFailover.ReturnPathProbe.Enable {
+		meta.SetStatusCondition(&tunnel.Status.Conditions, r.returnPathStatus(tunnel.Generation))
+	} else {
+		meta.RemoveStatusCondition(&tunnel.Status.Conditions, egressv1.TunnelConditionReturnPath)
+	}
+	ok, delay := r.statusThrottle.Allow(time.Now(), &tunnel.Status, heartbeat)
+	if !ok {
+		if delay > 0 {
+			r.log.V(1).Info("defer the update of tunnel status", "delay", delay)
+			r.updateTimer.Reset(delay)
+		}
+		return nil
+	}
 	r.log.Info("update tunnel status",
 		"phase", tunnel.Status.Phase,
 		"tunnelIPv4", tunnel.Status.Tunnel.IPv4,
@@ -781,11 +946,18 @@ func (r *vxlanReconciler) updateTunnelStatus(tunnel *egressv1.EgressTunnel) erro
 		"parentIPv6", tunnel.Status.Tunnel.Parent.IPv6,
 	)
 	err := r.client.Status().Update(ctx, tunnel)
+	r.updateTimer.Reset(r.statusThrottle.Done(time.Now(), &tunnel.Status, err))
+	return err
+}
+
+// ensurePeerRoute ensures the route rule of the mark of the peer, and the route to the peer
+// in the table numbered by the mark from the base
+func (r *vxlanReconciler) ensurePeerRoute(dev string, peer vxlan.Peer, base int) error {
+	table, err := r.tables.PeerTable(base, peer.Mark)
 	if err != nil {
 		return err
 	}
-	r.updateTimer.Reset(time.Second * time.Duration(r.cfg.FileConfig.GatewayFailover.TunnelUpdatePeriod))
-	return nil
+	return r.ruleRoute.Ensure(dev, peer.IPv4, peer.IPv6, table, peer.Mark)
 }
 
 func (r *vxlanReconciler) ensureRoute() error {
@@ -804,27 +976,103 @@ func (r *vxlanReconciler) ensureRoute() error {
 	})
 
 	expected := make(map[string]struct{})
+	expectedIPs := make(map[string]struct{})
 	for _, peer := range peerMap {
 		expected[peer.MAC.String()] = struct{}{}
+		for _, ip := range peer.IPs() {
+			expectedIPs[ip.String()] = struct{}{}
+		}
 	}
 
+	errs := make([]error, 0)
 	for _, item := range neighList {
 		if _, ok := expected[item.HardwareAddr.String()]; !ok {
 			err := r.vxlan.Del(item)
 			if err != nil {
 				r.log.Error(err, "delete link layer neighbor", "item", item.String())
+				errs = append(errs, fmt.Errorf("delete neighbor %s: %w", item.String(), err))
+			}
+			continue
+		}
+		// the previous IPs of the peer are removed after renumbering
+		if _, ok := expectedIPs[item.IP.String()]; !ok && item.State&netlink.NUD_PERMANENT != 0 {
+			err := r.vxlan.DelNeigh(item)
+			if err != nil {
+				r.log.Error(err, "delete stale link layer neighbor", "item", item.String())
+				errs = append(errs, fmt.Errorf("delete stale neighbor %s: %w", item.String(), err))
 			}
 		}
 	}
 
-	for _, peer := range peerMap {
+	// the stale entries are deleted before the peers are added, so the entries of a peer
+	// whose MAC or IPs are changed are never deleted after they're added
+	addErrs, overflow := r.addPeers(peerMap)
+	if r.neighTable.Handle(overflow) {
+		// the refused entries are added again once the thresholds are raised
+		addErrs, _ = r.addPeers(peerMap)
+	}
+	errs = append(errs, addErrs...)
+
+	// the partial failures are only logged unless in the strict datapath mode
+	if !r.cfg.FileConfig.StrictDatapath {
+		return nil
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// addPeers adds the neighbor and the fdb entries of the peers, it returns whether any entry
+// is refused by the overflow of the neighbor table
+func (r *vxlanReconciler) addPeers(peerMap map[string]vxlan.Peer) ([]error, bool) {
+	start := time.Now()
+	errs, overflow := programPeers(peerMap, r.cfg.FileConfig.VXLAN.PeerConcurrency, func(name string, peer vxlan.Peer) error {
 		err := r.vxlan.Add(peer)
 		if err != nil {
 			r.log.Error(err, "add peer route", "peer", peer)
 		}
-	}
+		return err
+	})
+	vxlan.RecordPeersProgrammed(time.Since(start), len(peerMap), len(errs))
+	return errs, overflow
+}
 
-	return nil
+// programPeers programs the peers by the add with the bounded concurrency. Each peer is
+// programmed by one goroutine, so the entries of a peer are added in order, and the
+// failure of a peer doesn't stop the others. The errors are sorted by the peer names.
+func programPeers(peerMap map[string]vxlan.Peer, concurrency int, add func(name string, peer vxlan.Peer) error) ([]error, bool) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	names := make([]string, 0, len(peerMap))
+	for name := range peerMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make([]error, len(names))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, name string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = add(name, peerMap[name])
+		}(i, name)
+	}
+	wg.Wait()
+
+	errs := make([]error, 0)
+	overflow := false
+	for i, err := range results {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("add neighbor of peer %s: %w", names[i], err))
+			overflow = overflow || vxlan.IsNeighTableOverflow(err)
+		}
+	}
+	return errs, overflow
 }
 
 func (r *vxlanReconciler) initTunnelPeerMap() error {
@@ -857,42 +1105,273 @@ func (r *vxlanReconciler) keepReplayRoute() {
 	for {
 		err := r.syncReplayRoute(log)
 		if err != nil {
-			log.Error(err, "failed to keep replay route")
+			r.loopLog.Error(err, "failed to keep replay route")
 		}
 
 		time.Sleep(time.Second * 10)
 	}
 }
 
+// keepProbe probes every peer through the tunnel at the interval of tunnelUpdatePeriod,
+// the results are reported in the EgressTunnel status with the heartbeat.
+func (r *vxlanReconciler) keepProbe(ctx context.Context) {
+	conf := r.cfg.FileConfig.GatewayFailover.TunnelProbe
+	go func() {
+		for {
+			err := probe.Serve(ctx, conf.Port)
+			if err == nil {
+				return
+			}
+			r.log.Error(err, "serve tunnel probe", "port", conf.Port)
+			time.Sleep(time.Second)
+		}
+	}()
+
+	period := time.Second * time.Duration(r.cfg.FileConfig.GatewayFailover.TunnelUpdatePeriod)
+	t := time.NewTicker(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			r.probePeers()
+		}
+	}
+}
+
+func (r *vxlanReconciler) probePeers() {
+	conf := r.cfg.FileConfig.GatewayFailover.TunnelProbe
+	timeout := time.Millisecond * time.Duration(conf.TimeoutMillis)
+
+	peers := make(map[string]net.IP)
+	r.peerMap.Range(func(key string, peer vxlan.Peer) bool {
+		if key == r.cfg.EnvConfig.NodeName {
+			return true
+		}
+		if r.version() == 4 && peer.IPv4 != nil {
+			peers[key] = *peer.IPv4
+		} else if r.version() == 6 && peer.IPv6 != nil {
+			peers[key] = *peer.IPv6
+		}
+		return true
+	})
+
+	var wg sync.WaitGroup
+	for name, ip := range peers {
+		wg.Add(1)
+		go func(name string, ip net.IP) {
+			defer wg.Done()
+			res, err := probe.Probe(ip, conf.Port, conf.Count, timeout)
+			if err != nil {
+				r.log.Error(err, "probe tunnel peer", "peer", name, "ip", ip.String())
+				return
+			}
+			r.log.V(1).Info("probe tunnel peer", "peer", name, "rtt", res.RTT, "loss", res.Loss)
+			r.probeResults.Store(name, res)
+			probe.RecordMetrics(name, res)
+		}(name, ip)
+	}
+	wg.Wait()
+
+	r.probeResults.Range(func(name string, _ probe.Result) bool {
+		if _, ok := peers[name]; !ok {
+			r.probeResults.Delete(name)
+			probe.DeleteMetrics(name)
+		}
+		return true
+	})
+}
+
+func (r *vxlanReconciler) peerStatus() []egressv1.PeerStatus {
+	res := make([]egressv1.PeerStatus, 0)
+	r.probeResults.Range(func(name string, val probe.Result) bool {
+		res = append(res, egressv1.PeerStatus{
+			Name:          name,
+			Reachable:     val.Reachable(),
+			RTT:           metav1.Duration{Duration: val.RTT},
+			Loss:          val.Loss,
+			LastProbeTime: metav1.NewTime(val.Time),
+		})
+		return true
+	})
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+type upstreamResult struct {
+	probe.Result
+	Interface string
+}
+
+// keepUpstreamProbe probes the next hops of the default routes at the interval of
+// tunnelUpdatePeriod, the results are reported in the EgressTunnel status with the heartbeat.
+func (r *vxlanReconciler) keepUpstreamProbe(ctx context.Context) {
+	period := time.Second * time.Duration(r.cfg.FileConfig.GatewayFailover.TunnelUpdatePeriod)
+	t := time.NewTicker(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			r.probeUpstreams()
+		}
+	}
+}
+
+func (r *vxlanReconciler) probeUpstreams() {
+	conf := r.cfg.FileConfig.GatewayFailover.UpstreamProbe
+	timeout := time.Millisecond * time.Duration(conf.TimeoutMillis)
+
+	hops := make([]probe.NextHop, 0)
+	for version, family := range map[int]int{4: netlink.FAMILY_V4, 6: netlink.FAMILY_V6} {
+		if (version == 4 && !r.cfg.FileConfig.EnableIPv4) || (version == 6 && !r.cfg.FileConfig.EnableIPv6) {
+			continue
+		}
+		list, err := probe.DefaultNextHops(family)
+		if err != nil {
+			r.loopLog.Error(err, "list upstream next hops", "version", version)
+			continue
+		}
+		hops = append(hops, list...)
+	}
+
+	var wg sync.WaitGroup
+	for _, hop := range hops {
+		wg.Add(1)
+		go func(hop probe.NextHop) {
+			defer wg.Done()
+			res, err := probe.ProbeNextHop(hop, conf.Count, timeout)
+			if err != nil {
+				r.loopLog.Error(err, "probe upstream next hop", "nextHop", hop.IP.String(), "interface", hop.Interface)
+				return
+			}
+			r.log.V(1).Info("probe upstream next hop", "nextHop", hop.IP.String(), "rtt", res.RTT, "loss", res.Loss)
+			r.upstreamResults.Store(hop.IP.String(), upstreamResult{Result: res, Interface: hop.Interface})
+		}(hop)
+	}
+	wg.Wait()
+
+	r.upstreamResults.Range(func(key string, _ upstreamResult) bool {
+		found := false
+		for _, hop := range hops {
+			if hop.IP.String() == key {
+				found = true
+				break
+			}
+		}
+		if !found {
+			r.upstreamResults.Delete(key)
+		}
+		return true
+	})
+}
+
+func (r *vxlanReconciler) upstreamStatus() []egressv1.UpstreamStatus {
+	res := make([]egressv1.UpstreamStatus, 0)
+	r.upstreamResults.Range(func(key string, val upstreamResult) bool {
+		res = append(res, egressv1.UpstreamStatus{
+			NextHop:       key,
+			Interface:     val.Interface,
+			Reachable:     val.Reachable(),
+			RTT:           metav1.Duration{Duration: val.RTT},
+			Loss:          val.Loss,
+			LastProbeTime: metav1.NewTime(val.Time),
+		})
+		return true
+	})
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].NextHop < res[j].NextHop
+	})
+	return res
+}
+
 func (r *vxlanReconciler) Start(ctx context.Context) error {
+	// the entries of the peers are cached by the updates of the kernel, so the steady
+	// cycles of keepVXLAN don't list them
+	go r.vxlan.WatchNeighbors(ctx.Done(), r.log.WithName("neigh"))
 	if !r.cfg.FileConfig.GatewayFailover.Enable {
 		return nil
 	}
+	if r.cfg.FileConfig.GatewayFailover.TunnelProbe.Enable {
+		go r.keepProbe(ctx)
+	}
+	if r.cfg.FileConfig.GatewayFailover.UpstreamProbe.Enable {
+		go r.keepUpstreamProbe(ctx)
+	}
+	if r.cfg.FileConfig.GatewayFailover.ReturnPathProbe.Enable {
+		go r.keepReturnPathProbe(ctx)
+	}
 	return r.syncLastHeartbeatTime(ctx)
 }
 
+// parseMarkToInt parses the mark of the EgressTunnel, the mark must be in 32 bits
 func parseMarkToInt(mark string) (int, error) {
-	tmp := strings.ReplaceAll(mark, "0x", "")
-	i64, err := strconv.ParseInt(tmp, 16, 32)
+	res, err := markallocator.Parse(mark)
 	if err != nil {
 		return 0, err
 	}
-	i32 := int(i64)
-	return i32, nil
+	if res > math.MaxUint32 {
+		return 0, fmt.Errorf("mark %s is out of 32 bits", mark)
+	}
+	return int(res), nil
 }
 
-func newEgressTunnelController(mgr manager.Manager, cfg *config.Config, log logr.Logger) error {
-	ruleRoute := route.NewRuleRoute(log)
+func newEgressTunnelController(mgr manager.Manager, cfg *config.Config, readiness *datapathReadiness,
+	peerSync *peerSyncHandler, log logr.Logger) error {
+	markSpace, err := markallocator.NewSpace(cfg.FileConfig.Mark, cfg.FileConfig.MarkMask)
+	if err != nil {
+		return err
+	}
+	ruleRoute := route.NewRuleRoute(log, route.WithMarkMask(markSpace.MarkMask()))
+	tables := route.NewTables(markSpace, cfg.FileConfig.RouteTable.OnCollision == config.RouteTableCollisionRenumber)
+	replyMark := 0
+	if cfg.FileConfig.EnableGatewayReplyRoute {
+		replyMark = cfg.FileConfig.GatewayReplyRouteMark
+	}
+	if err := tables.DetectFromHost(replyMark); err != nil {
+		return fmt.Errorf("failed to detect routing tables: %w", err)
+	}
+	replyTable := cfg.FileConfig.GatewayReplyRouteTable
+	if cfg.FileConfig.EnableGatewayReplyRoute {
+		replyTable, err = tables.Table(cfg.FileConfig.GatewayReplyRouteTable)
+		if err != nil {
+			return fmt.Errorf("gateway reply route table: %w", err)
+		}
+		if replyTable != cfg.FileConfig.GatewayReplyRouteTable {
+			log.Info("gateway reply route table is used by the others, renumbered",
+				"table", cfg.FileConfig.GatewayReplyRouteTable, "renumbered", replyTable)
+		}
+	}
 
 	r := &vxlanReconciler{
-		client:         mgr.GetClient(),
-		log:            log,
-		cfg:            cfg,
-		doOnce:         sync.Once{},
-		peerMap:        utils.NewSyncMap[string, vxlan.Peer](),
-		ruleRoute:      ruleRoute,
-		ruleRouteCache: utils.NewSyncMap[string, []net.IP](),
-		updateTimer:    time.NewTimer(time.Second * time.Duration(cfg.FileConfig.GatewayFailover.TunnelUpdatePeriod)),
+		client:            mgr.GetClient(),
+		log:               log,
+		cfg:               cfg,
+		doOnce:            sync.Once{},
+		peerMap:           utils.NewSyncMap[string, vxlan.Peer](),
+		ruleRoute:         ruleRoute,
+		markSpace:         markSpace,
+		ruleRouteCache:    utils.NewSyncMap[string, []net.IP](),
+		tables:            tables,
+		replyTable:        replyTable,
+		updateTimer:       time.NewTimer(time.Second * time.Duration(cfg.FileConfig.GatewayFailover.TunnelUpdatePeriod)),
+		probeResults:      utils.NewSyncMap[string, probe.Result](),
+		upstreamResults:   utils.NewSyncMap[string, upstreamResult](),
+		returnPathResults: utils.NewSyncMap[string, probe.ReturnPathResult](),
+		networkDevs:       make(map[string]*vxlan.Device),
+		loopLog:           logger.NewDeduper(log, loopLogInterval),
+		resync:            make(chan struct{}, 1),
+		sysctl:            privilege.NewSysctlWriter("/proc/sys", cfg.HelperSocket),
+		readiness:         readiness,
+		datapathErrs:      newDatapathErrors(cfg.FileConfig.StrictDatapath),
+		statusThrottle: newStatusThrottle(
+			time.Duration(cfg.FileConfig.GatewayFailover.TunnelStatusMinIntervalMillis)*time.Millisecond,
+			time.Second*time.Duration(cfg.FileConfig.GatewayFailover.TunnelUpdatePeriod),
+			cfg.FileConfig.GatewayFailover.TunnelStatusJitterPercent),
 	}
 
 	netLink := vxlan.NetLink{
@@ -900,16 +1379,30 @@ func newEgressTunnelController(mgr manager.Manager, cfg *config.Config, log logr
 		LinkByIndex:       netlink.LinkByIndex,
 		AddrList:          netlink.AddrList,
 		LinkByName:        netlink.LinkByName,
+		LinkList:          netlink.LinkList,
 	}
-	if strings.HasPrefix(cfg.FileConfig.TunnelDetectMethod, config.TunnelInterfaceSpecific) {
-		name := strings.TrimPrefix(cfg.FileConfig.TunnelDetectMethod, config.TunnelInterfaceSpecific)
+	method := cfg.FileConfig.TunnelDetectMethod
+	switch {
+	case strings.HasPrefix(method, config.TunnelInterfaceSpecific):
+		name := strings.TrimPrefix(method, config.TunnelInterfaceSpecific)
 		r.getParent = vxlan.GetParentByName(netLink, name)
-	} else {
+	case strings.HasPrefix(method, config.TunnelInterfaceMAC):
+		mac, err := net.ParseMAC(strings.TrimPrefix(method, config.TunnelInterfaceMAC))
+		if err != nil {
+			return fmt.Errorf("invalid tunnelDetectMethod %s: %w", method, err)
+		}
+		r.getParent = vxlan.GetParentByMAC(netLink, mac)
+	case strings.HasPrefix(method, config.TunnelInterfacePCI):
+		r.getParent = vxlan.GetParentByPCI(netLink, strings.TrimPrefix(method, config.TunnelInterfacePCI))
+	default:
 		r.getParent = vxlan.GetParentByDefaultRoute(netLink)
 	}
-	r.vxlan = vxlan.New(vxlan.WithCustomGetParent(r.getParent))
+	r.vxlan = vxlan.New(vxlan.WithCustomGetParent(r.getParent), vxlan.WithSysctl(r.sysctl))
+	r.neighTable = newNeighTableTuner(log, r.sysctl, cfg)
+	// it's set before the manager starts serving the handler
+	peerSync.report = r.peerSync
 
-	c, err := controller.New("vxlan", mgr, controller.Options{Reconciler: r})
+	c, err := controller.New("vxlan", mgr, controller.Options{Reconciler: egresserrors.NewReconciler("vxlan", r)})
 	if err != nil {
 		return err
 	}
@@ -947,6 +1440,7 @@ func newEgressTunnelController(mgr manager.Manager, cfg *config.Config, log logr
 	}
 
 	go r.keepVXLAN()
+	go r.keepParentAddr()
 	go r.keepReplayRoute()
 
 	return nil
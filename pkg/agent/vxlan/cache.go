@@ -0,0 +1,219 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package vxlan
+
+import (
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// neighCacheMaxAge is the age after which the cache is listed from the kernel again, in
+// case any update is lost without an error of the subscription
+const neighCacheMaxAge = 5 * time.Minute
+
+var counterNeighRelists = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "egress_tunnel_neigh_cache_relists_total",
+	Help: "Number of the full lists of the neighbor and fdb entries of the vxlan device from the kernel, by the reason",
+}, []string{"reason"})
+
+// neighCache caches the neighbor and the fdb entries of the vxlan device, it's kept up to
+// date by the netlink subscription of the neighbor updates, so the steady ensure cycles
+// neither list the entries nor set the entries which are already programmed. It's
+// invalidated on the gaps of the subscription, then the entries are listed again.
+type neighCache struct {
+	mutex     sync.Mutex
+	linkIndex int
+	// subscribed is whether the updates are received, the cache is never valid without it
+	subscribed bool
+	valid      bool
+	// seq is increased by each update, the list overlapping with any update is not cached
+	seq      uint64
+	loadedAt time.Time
+	// reason is why the entries are listed next time
+	reason string
+	// neighs is the neighbor entries by the IPs, fdbs is the fdb entries by the MACs and
+	// the parent IPs
+	neighs map[string]netlink.Neigh
+	fdbs   map[string]netlink.Neigh
+}
+
+func newNeighCache() *neighCache {
+	return &neighCache{reason: "initial"}
+}
+
+func fdbKey(mac, ip string) string {
+	return mac + "/" + ip
+}
+
+// managedNeigh returns whether the neighbor entry is listed by ListNeigh, the kernel
+// creates the other IPv6 entries
+func managedNeigh(neigh netlink.Neigh) bool {
+	return neigh.Family == netlink.FAMILY_V4 || neigh.State&netlink.NUD_PERMANENT != 0
+}
+
+// snapshot returns the cached entries if the cache is valid, otherwise it returns the seq
+// to load the entries listed from the kernel
+func (c *neighCache) snapshot() (neighs, fdbs []netlink.Neigh, ok bool, seq uint64, reason string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.valid && time.Since(c.loadedAt) > neighCacheMaxAge {
+		c.valid = false
+		c.reason = "expired"
+	}
+	if !c.valid {
+		return nil, nil, false, c.seq, c.reason
+	}
+	for _, item := range c.neighs {
+		if managedNeigh(item) {
+			neighs = append(neighs, item)
+		}
+	}
+	for _, item := range c.fdbs {
+		fdbs = append(fdbs, item)
+	}
+	return neighs, fdbs, true, c.seq, ""
+}
+
+// load caches the entries listed from the kernel, the cache turns valid only if it's
+// subscribed and no update is received since the list started
+func (c *neighCache) load(linkIndex int, seq uint64, neighs, fdbs []netlink.Neigh) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.subscribed || seq != c.seq || linkIndex != c.linkIndex {
+		return
+	}
+	c.neighs = make(map[string]netlink.Neigh, len(neighs))
+	for _, item := range neighs {
+		c.neighs[item.IP.String()] = item
+	}
+	c.fdbs = make(map[string]netlink.Neigh, len(fdbs))
+	for _, item := range fdbs {
+		c.fdbs[fdbKey(item.HardwareAddr.String(), item.IP.String())] = item
+	}
+	c.valid = true
+	c.loadedAt = time.Now()
+}
+
+// update applies the update of the subscription to the cache
+func (c *neighCache) update(update netlink.NeighUpdate) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if update.LinkIndex != c.linkIndex || update.IP == nil {
+		return
+	}
+	c.seq++
+	if !c.valid {
+		return
+	}
+	key := update.IP.String()
+	entries := c.neighs
+	if update.Family == syscall.AF_BRIDGE {
+		key = fdbKey(update.HardwareAddr.String(), update.IP.String())
+		entries = c.fdbs
+	}
+	switch update.Type {
+	case unix.RTM_NEWNEIGH:
+		entries[key] = update.Neigh
+	case unix.RTM_DELNEIGH:
+		delete(entries, key)
+	}
+}
+
+// invalidate makes the entries listed from the kernel next time
+func (c *neighCache) invalidate(reason string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.seq++
+	c.valid = false
+	c.reason = reason
+}
+
+func (c *neighCache) setSubscribed(subscribed bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.subscribed = subscribed
+	c.seq++
+	if !subscribed {
+		c.valid = false
+		c.reason = "gap"
+	}
+}
+
+// setLink invalidates the cache once the device is recreated
+func (c *neighCache) setLink(linkIndex int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.linkIndex == linkIndex {
+		return
+	}
+	c.linkIndex = linkIndex
+	c.seq++
+	c.valid = false
+	c.reason = "link"
+}
+
+// hasNeigh returns whether the permanent entry of the IP to the MAC is cached
+func (c *neighCache) hasNeigh(ip string, mac string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	item, ok := c.neighs[ip]
+	return c.valid && ok && item.HardwareAddr.String() == mac && item.State&netlink.NUD_PERMANENT != 0
+}
+
+// hasFDB returns whether the fdb entry of the MAC to the parent IP is cached
+func (c *neighCache) hasFDB(mac string, parent string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, ok := c.fdbs[fdbKey(mac, parent)]
+	return c.valid && ok
+}
+
+// WatchNeighbors subscribes the neighbor updates of the kernel for the cache of the
+// entries of the device until the done is closed, the subscription is started again
+// after its errors, and the entries are listed again after each gap.
+func (dev *Device) WatchNeighbors(done <-chan struct{}, log logr.Logger) {
+	for {
+		ch := make(chan netlink.NeighUpdate, 1024)
+		stop := make(chan struct{})
+		err := netlink.NeighSubscribeWithOptions(ch, stop, netlink.NeighSubscribeOptions{
+			ErrorCallback: func(err error) {
+				log.Error(err, "the subscription of the neighbor updates failed, the entries are listed again")
+				dev.cache.invalidate("gap")
+			},
+		})
+		if err != nil {
+			log.Error(err, "failed to subscribe the neighbor updates")
+		} else {
+			dev.cache.setSubscribed(true)
+			dev.receive(ch, done)
+			dev.cache.setSubscribed(false)
+		}
+		close(stop)
+		select {
+		case <-done:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (dev *Device) receive(ch <-chan netlink.NeighUpdate, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case update, ok := <-ch:
+			if !ok {
+				return
+			}
+			dev.cache.update(update)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package vxlan
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestNeighCache(t *testing.T) {
+	mac1, _ := net.ParseMAC("66:5f:01:00:00:01")
+	mac2, _ := net.ParseMAC("66:5f:01:00:00:02")
+	neigh := func(ip string, mac net.HardwareAddr, state int) netlink.Neigh {
+		family := netlink.FAMILY_V4
+		if net.ParseIP(ip).To4() == nil {
+			family = netlink.FAMILY_V6
+		}
+		return netlink.Neigh{LinkIndex: 10, Family: family, IP: net.ParseIP(ip), HardwareAddr: mac, State: state}
+	}
+	fdb := netlink.Neigh{LinkIndex: 10, Family: syscall.AF_BRIDGE, IP: net.ParseIP("10.6.0.1"), HardwareAddr: mac1, State: netlink.NUD_PERMANENT}
+	neighs := []netlink.Neigh{
+		neigh("172.31.0.1", mac1, netlink.NUD_PERMANENT),
+		neigh("fd01::1", mac1, netlink.NUD_PERMANENT),
+		// the entries created by the kernel for IPv6 are not managed
+		neigh("fe80::1", mac2, netlink.NUD_REACHABLE),
+	}
+
+	c := newNeighCache()
+	c.setLink(10)
+	_, _, ok, seq, reason := c.snapshot()
+	assert.False(t, ok)
+	assert.Equal(t, "link", reason)
+
+	// the entries are not cached without the subscription
+	c.load(10, seq, neighs, []netlink.Neigh{fdb})
+	_, _, ok, _, _ = c.snapshot()
+	assert.False(t, ok)
+	assert.False(t, c.hasNeigh("172.31.0.1", mac1.String()))
+
+	// the list overlapping with an update is not cached
+	c.setSubscribed(true)
+	_, _, _, seq, _ = c.snapshot()
+	c.update(netlink.NeighUpdate{Type: unix.RTM_NEWNEIGH, Neigh: neigh("172.31.0.2", mac2, netlink.NUD_PERMANENT)})
+	c.load(10, seq, neighs, []netlink.Neigh{fdb})
+	_, _, ok, seq, _ = c.snapshot()
+	assert.False(t, ok)
+
+	c.load(10, seq, neighs, []netlink.Neigh{fdb})
+	cachedNeighs, cachedFDBs, ok, _, _ := c.snapshot()
+	assert.True(t, ok)
+	assert.Len(t, cachedNeighs, 2)
+	assert.Equal(t, []netlink.Neigh{fdb}, cachedFDBs)
+	assert.True(t, c.hasNeigh("172.31.0.1", mac1.String()))
+	assert.False(t, c.hasNeigh("172.31.0.1", mac2.String()))
+	assert.False(t, c.hasNeigh("fe80::1", mac2.String()))
+	assert.True(t, c.hasFDB(mac1.String(), "10.6.0.1"))
+	assert.False(t, c.hasFDB(mac1.String(), "10.6.0.2"))
+
+	// the updates of the subscription are applied to the cache
+	c.update(netlink.NeighUpdate{Type: unix.RTM_NEWNEIGH, Neigh: neigh("172.31.0.2", mac2, netlink.NUD_PERMANENT)})
+	c.update(netlink.NeighUpdate{Type: unix.RTM_DELNEIGH, Neigh: fdb})
+	c.update(netlink.NeighUpdate{Type: unix.RTM_DELNEIGH, Neigh: neigh("172.31.0.1", mac1, netlink.NUD_PERMANENT)})
+	// the updates of the other links are ignored
+	other := neigh("172.31.0.3", mac2, netlink.NUD_PERMANENT)
+	other.LinkIndex = 11
+	c.update(netlink.NeighUpdate{Type: unix.RTM_NEWNEIGH, Neigh: other})
+	assert.True(t, c.hasNeigh("172.31.0.2", mac2.String()))
+	assert.False(t, c.hasNeigh("172.31.0.1", mac1.String()))
+	assert.False(t, c.hasNeigh("172.31.0.3", mac2.String()))
+	assert.False(t, c.hasFDB(mac1.String(), "10.6.0.1"))
+
+	// the entries are listed again after the gaps, the recreated links and the expiry
+	c.invalidate("gap")
+	_, _, ok, seq, reason = c.snapshot()
+	assert.False(t, ok)
+	assert.Equal(t, "gap", reason)
+	c.load(10, seq, neighs, nil)
+	c.setLink(12)
+	_, _, ok, seq, reason = c.snapshot()
+	assert.False(t, ok)
+	assert.Equal(t, "link", reason)
+	c.load(10, seq, neighs, nil)
+	_, _, ok, seq, _ = c.snapshot()
+	assert.False(t, ok)
+	c.load(12, seq, neighs, nil)
+	c.loadedAt = time.Now().Add(-neighCacheMaxAge - time.Second)
+	_, _, ok, _, reason = c.snapshot()
+	assert.False(t, ok)
+	assert.Equal(t, "expired", reason)
+
+	c.setSubscribed(false)
+	assert.False(t, c.hasNeigh("172.31.0.1", mac1.String()))
+}
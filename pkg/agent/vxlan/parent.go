@@ -4,8 +4,12 @@
 package vxlan
 
 import (
+	"bytes"
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/vishvananda/netlink"
 )
@@ -15,6 +19,10 @@ type NetLink struct {
 	LinkByIndex       func(index int) (netlink.Link, error)
 	AddrList          func(link netlink.Link, family int) ([]netlink.Addr, error)
 	LinkByName        func(name string) (netlink.Link, error)
+	LinkList          func() ([]netlink.Link, error)
+	// PCIAddress returns the PCI address of the device of the link, it reads the sysfs
+	// if it's nil
+	PCIAddress func(name string) (string, error)
 }
 
 // Parent defines the parent interface information
@@ -39,13 +47,7 @@ func GetParentByDefaultRoute(cli NetLink) func(version int) (*Parent, error) {
 			return nil, fmt.Errorf("failed to list routes: %v", err)
 		}
 
-		index := -1
-		for _, route := range routes {
-			if route.Family == family {
-				index = route.LinkIndex
-				break
-			}
-		}
+		index := defaultRouteIndex(routes, family)
 		if index == -1 {
 			return nil, fmt.Errorf("not found default route link: family IPv%v", version)
 		}
@@ -54,18 +56,36 @@ func GetParentByDefaultRoute(cli NetLink) func(version int) (*Parent, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to get parent link by index: %v, %v", index, err)
 		}
-		addrs, err := cli.AddrList(link, family)
-		if err != nil {
-			return nil, fmt.Errorf("failed to list parent link addrs: %v", err)
+		return resolveParent(cli, link, family)
+	}
+}
+
+// defaultRouteIndex returns the link index of the default route, the first nexthop of the
+// multipath default route is used. It falls back to the first route of the family if
+// there is no default route.
+func defaultRouteIndex(routes []netlink.Route, family int) int {
+	index := -1
+	for _, route := range routes {
+		if route.Family != family {
+			continue
 		}
-		for _, addr := range addrs {
-			if !addr.IP.IsGlobalUnicast() {
-				continue
-			}
-			return &Parent{Name: link.Attrs().Name, IP: addr.IP, Index: link.Attrs().Index}, nil
+		linkIndex := route.LinkIndex
+		if linkIndex == 0 && len(route.MultiPath) != 0 {
+			linkIndex = route.MultiPath[0].LinkIndex
+		}
+		if route.Dst == nil || isDefaultDst(route.Dst) {
+			return linkIndex
+		}
+		if index == -1 {
+			index = linkIndex
 		}
-		return nil, fmt.Errorf("failed to find parent interface")
 	}
+	return index
+}
+
+func isDefaultDst(dst *net.IPNet) bool {
+	ones, _ := dst.Mask.Size()
+	return ones == 0 && dst.IP.IsUnspecified()
 }
 
 func GetParentByName(cli NetLink, name string) func(version int) (*Parent, error) {
@@ -78,6 +98,90 @@ func GetParentByName(cli NetLink, name string) func(version int) (*Parent, error
 		if version == 6 {
 			family = netlink.FAMILY_V6
 		}
+		return resolveParent(cli, link, family)
+	}
+}
+
+// GetParentByMAC get vxlan parent interface by the MAC address, the permanent MAC address
+// of the bond slave is matched as well
+func GetParentByMAC(cli NetLink, mac net.HardwareAddr) func(version int) (*Parent, error) {
+	return func(version int) (*Parent, error) {
+		links, err := cli.LinkList()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list links: %v", err)
+		}
+		var link netlink.Link
+		for _, item := range links {
+			if slave, ok := item.Attrs().Slave.(*netlink.BondSlave); ok && bytes.Equal(slave.PermHardwareAddr, mac) {
+				link = item
+				break
+			}
+			// the bond, its slaves and the VLANs on it share the MAC address, the device
+			// without the master is preferred
+			if bytes.Equal(item.Attrs().HardwareAddr, mac) && (link == nil || link.Attrs().MasterIndex != 0) {
+				link = item
+			}
+		}
+		if link == nil {
+			return nil, fmt.Errorf("failed to get parent link by mac: %v", mac)
+		}
+		family := netlink.FAMILY_V4
+		if version == 6 {
+			family = netlink.FAMILY_V6
+		}
+		return resolveParent(cli, link, family)
+	}
+}
+
+// GetParentByPCI get vxlan parent interface by the PCI address of the device
+func GetParentByPCI(cli NetLink, pci string) func(version int) (*Parent, error) {
+	pciAddress := cli.PCIAddress
+	if pciAddress == nil {
+		pciAddress = sysfsPCIAddress
+	}
+	return func(version int) (*Parent, error) {
+		links, err := cli.LinkList()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list links: %v", err)
+		}
+		var link netlink.Link
+		for _, item := range links {
+			addr, err := pciAddress(item.Attrs().Name)
+			if err == nil && strings.EqualFold(addr, pci) {
+				link = item
+				break
+			}
+		}
+		if link == nil {
+			return nil, fmt.Errorf("failed to get parent link by pci address: %v", pci)
+		}
+		family := netlink.FAMILY_V4
+		if version == 6 {
+			family = netlink.FAMILY_V6
+		}
+		return resolveParent(cli, link, family)
+	}
+}
+
+func sysfsPCIAddress(name string) (string, error) {
+	dev, err := os.Readlink(filepath.Join("/sys/class/net", name, "device"))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(dev), nil
+}
+
+// resolveParent returns the L3 interface of the link and its address. If the link has no
+// address, e.g. it's a slave of a bond or a port of a bridge, or the addresses are on the
+// VLANs over it, the masters and the VLANs over the link are searched level by level.
+func resolveParent(cli NetLink, link netlink.Link, family int) (*Parent, error) {
+	var links []netlink.Link
+	visited := map[int]bool{link.Attrs().Index: true}
+	queue := []netlink.Link{link}
+	for len(queue) != 0 {
+		link := queue[0]
+		queue = queue[1:]
+
 		addrs, err := cli.AddrList(link, family)
 		if err != nil {
 			return nil, fmt.Errorf("failed to list parent link addrs: %v", err)
@@ -88,6 +192,35 @@ func GetParentByName(cli NetLink, name string) func(version int) (*Parent, error
 			}
 			return &Parent{Name: link.Attrs().Name, IP: addr.IP, Index: link.Attrs().Index}, nil
 		}
-		return nil, fmt.Errorf("failed to find parent interface")
+
+		if links == nil {
+			if cli.LinkList == nil {
+				continue
+			}
+			links, err = cli.LinkList()
+			if err != nil {
+				return nil, fmt.Errorf("failed to list links: %v", err)
+			}
+		}
+		for _, item := range links {
+			attrs := item.Attrs()
+			if visited[attrs.Index] {
+				continue
+			}
+			if attrs.Index == link.Attrs().MasterIndex || (attrs.ParentIndex == link.Attrs().Index && isUpper(item)) {
+				visited[attrs.Index] = true
+				queue = append(queue, item)
+			}
+		}
+	}
+	return nil, fmt.Errorf("failed to find parent interface")
+}
+
+// isUpper returns whether the link is stacked on its parent link
+func isUpper(link netlink.Link) bool {
+	switch link.Type() {
+	case "vlan", "macvlan", "ipvlan":
+		return true
 	}
+	return false
 }
@@ -176,3 +176,72 @@ func case2() TestCase {
 		},
 	}
 }
+
+// bondNetLink is a node with eth0 and eth1 bonded as bond0, and the address on bond0.100
+func bondNetLink() NetLink {
+	mac, _ := net.ParseMAC("52:54:00:00:00:01")
+	perm, _ := net.ParseMAC("52:54:00:00:00:02")
+	links := []netlink.Link{
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: 2, Name: "eth0", MasterIndex: 4, HardwareAddr: mac,
+			Slave: &netlink.BondSlave{PermHardwareAddr: mac}}},
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: 3, Name: "eth1", MasterIndex: 4, HardwareAddr: mac,
+			Slave: &netlink.BondSlave{PermHardwareAddr: perm}}},
+		&netlink.Bond{LinkAttrs: netlink.LinkAttrs{Index: 4, Name: "bond0", HardwareAddr: mac}},
+		&netlink.Vlan{LinkAttrs: netlink.LinkAttrs{Index: 5, Name: "bond0.100", ParentIndex: 4, HardwareAddr: mac}, VlanId: 100},
+	}
+	return NetLink{
+		LinkList: func() ([]netlink.Link, error) { return links, nil },
+		LinkByName: func(name string) (netlink.Link, error) {
+			for _, link := range links {
+				if link.Attrs().Name == name {
+					return link, nil
+				}
+			}
+			return nil, errors.New("not found")
+		},
+		LinkByIndex: func(index int) (netlink.Link, error) { return links[index-2], nil },
+		AddrList: func(link netlink.Link, family int) ([]netlink.Addr, error) {
+			if link.Attrs().Name == "bond0.100" {
+				return []netlink.Addr{{IPNet: &net.IPNet{IP: net.ParseIP("fe80::1")}}, {IPNet: &net.IPNet{IP: net.ParseIP("10.6.0.1")}}}, nil
+			}
+			return []netlink.Addr{{IPNet: &net.IPNet{IP: net.ParseIP("fe80::2")}}}, nil
+		},
+		RouteListFiltered: func(family int, filter *netlink.Route, filterMask uint64) ([]netlink.Route, error) {
+			_, dst, _ := net.ParseCIDR("10.6.0.0/16")
+			return []netlink.Route{
+				{LinkIndex: 3, Dst: dst, Family: netlink.FAMILY_V4},
+				{MultiPath: []*netlink.NexthopInfo{{LinkIndex: 5}}, Family: netlink.FAMILY_V4},
+			}, nil
+		},
+		PCIAddress: func(name string) (string, error) {
+			if name == "eth1" {
+				return "0000:3b:00.1", nil
+			}
+			return "", errors.New("not pci device")
+		},
+	}
+}
+
+func TestGetParentOfBond(t *testing.T) {
+	cli := bondNetLink()
+	exp := &Parent{Name: "bond0.100", IP: net.ParseIP("10.6.0.1"), Index: 5}
+	mac, _ := net.ParseMAC("52:54:00:00:00:02")
+
+	cases := map[string]func(version int) (*Parent, error){
+		"default route": GetParentByDefaultRoute(cli),
+		"bond slave":    GetParentByName(cli, "eth0"),
+		"bond":          GetParentByName(cli, "bond0"),
+		"permanent mac": GetParentByMAC(cli, mac),
+		"pci":           GetParentByPCI(cli, "0000:3B:00.1"),
+	}
+	for name, getParent := range cases {
+		t.Run(name, func(t *testing.T) {
+			parent, err := getParent(4)
+			assert.NoError(t, err)
+			assert.Equal(t, exp, parent)
+		})
+	}
+
+	_, err := GetParentByPCI(cli, "0000:3b:00.2")(4)
+	assert.Error(t, err)
+}
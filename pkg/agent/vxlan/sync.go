@@ -0,0 +1,144 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package vxlan
+
+import (
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vishvananda/netlink"
+)
+
+var (
+	gaugePeerSynced = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "egress_tunnel_peer_entries_synced",
+		Help: "1 if the entries of the tunnel peer are programmed on the vxlan device as expected, 0 otherwise, by the peer and the entry (fdb or neigh)",
+	}, []string{"peer", "entry"})
+	gaugeStaleEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "egress_tunnel_stale_entries",
+		Help: "Number of the neighbor entries on the vxlan device which don't belong to any tunnel peer",
+	})
+	histogramPeersProgrammed = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "egress_tunnel_peers_program_duration_seconds",
+		Help:    "Time taken to program the neighbor and fdb entries of all the tunnel peers, by the result",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"result"})
+	gaugePeersProgrammed = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "egress_tunnel_peers_programmed",
+		Help: "Number of the tunnel peers programmed in the last sync",
+	})
+)
+
+func MetricCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		gaugePeerSynced,
+		gaugeStaleEntries,
+		histogramPeersProgrammed,
+		gaugePeersProgrammed,
+		counterNeighRelists,
+	}
+}
+
+// RecordPeersProgrammed records the time taken to program the peers, the sync converges
+// if no peer failed
+func RecordPeersProgrammed(d time.Duration, peers, failed int) {
+	result := "converged"
+	if failed > 0 {
+		result = "failed"
+	}
+	histogramPeersProgrammed.WithLabelValues(result).Observe(d.Seconds())
+	gaugePeersProgrammed.Set(float64(peers - failed))
+}
+
+// PeerSync is the entries of a peer programmed on the vxlan device against the expected
+// ones, the peer is synced if nothing is missing
+type PeerSync struct {
+	Peer   string `json:"peer"`
+	MAC    string `json:"mac"`
+	Parent string `json:"parent"`
+	// MissingNeighs is the tunnel IPs of the peer without the neighbor entries of its MAC
+	MissingNeighs []string `json:"missingNeighs,omitempty"`
+	// MissingFDB is whether the fdb entry of the MAC to the parent IP is not programmed
+	MissingFDB bool `json:"missingFDB,omitempty"`
+	// StaleFDB is the other parent IPs of the MAC in the fdb, e.g. the previous parent IP
+	StaleFDB []string `json:"staleFDB,omitempty"`
+	Synced   bool     `json:"synced"`
+}
+
+// ListFDB lists the fdb entries of the device to the parent IPs of the peers
+func (dev *Device) ListFDB() ([]netlink.Neigh, error) {
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if dev.notReady() {
+		return nil, nil
+	}
+	_, fdbs, err := dev.listEntries()
+	return fdbs, err
+}
+
+// DiffPeers compares the peers with the neighbor and the fdb entries of the device, it
+// returns the sync status of the peers sorted by name, and the neighbor entries whose MAC
+// belongs to no peer
+func DiffPeers(peers map[string]Peer, neighs, fdbs []netlink.Neigh) ([]PeerSync, []string) {
+	neighMACs := make(map[string]string)
+	for _, item := range neighs {
+		neighMACs[item.IP.String()] = item.HardwareAddr.String()
+	}
+	fdbIPs := make(map[string][]string)
+	for _, item := range fdbs {
+		mac := item.HardwareAddr.String()
+		fdbIPs[mac] = append(fdbIPs[mac], item.IP.String())
+	}
+
+	res := make([]PeerSync, 0, len(peers))
+	macs := make(map[string]struct{})
+	for name, peer := range peers {
+		mac := peer.MAC.String()
+		macs[mac] = struct{}{}
+		item := PeerSync{Peer: name, MAC: mac, Parent: peer.Parent.String(), MissingFDB: true}
+		for _, ip := range peer.IPs() {
+			if neighMACs[ip.String()] != mac {
+				item.MissingNeighs = append(item.MissingNeighs, ip.String())
+			}
+		}
+		for _, ip := range fdbIPs[mac] {
+			if ip == item.Parent {
+				item.MissingFDB = false
+				continue
+			}
+			item.StaleFDB = append(item.StaleFDB, ip)
+		}
+		item.Synced = len(item.MissingNeighs) == 0 && !item.MissingFDB
+		res = append(res, item)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Peer < res[j].Peer })
+
+	stale := make([]string, 0)
+	for _, item := range neighs {
+		if _, ok := macs[item.HardwareAddr.String()]; !ok {
+			stale = append(stale, item.IP.String()+" lladdr "+item.HardwareAddr.String())
+		}
+	}
+	sort.Strings(stale)
+	return res, stale
+}
+
+// RecordPeerSync records the sync status of the peers, the removed peers are dropped
+func RecordPeerSync(peers []PeerSync, stale []string) {
+	gaugePeerSynced.Reset()
+	for _, item := range peers {
+		gaugePeerSynced.WithLabelValues(item.Peer, "neigh").Set(boolToFloat(len(item.MissingNeighs) == 0))
+		gaugePeerSynced.WithLabelValues(item.Peer, "fdb").Set(boolToFloat(!item.MissingFDB))
+	}
+	gaugeStaleEntries.Set(float64(len(stale)))
+}
+
+func boolToFloat(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package vxlan
+
+import (
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func gaugeValue(t *testing.T, gauge prometheus.Gauge) float64 {
+	m := new(dto.Metric)
+	assert.NoError(t, gauge.Write(m))
+	return m.GetGauge().GetValue()
+}
+
+func TestDiffPeers(t *testing.T) {
+	mac1, _ := net.ParseMAC("66:5f:01:00:00:01")
+	mac2, _ := net.ParseMAC("66:5f:01:00:00:02")
+	mac3, _ := net.ParseMAC("66:5f:01:00:00:03")
+	ip1, ip2 := net.ParseIP("172.31.0.1").To4(), net.ParseIP("172.31.0.2").To4()
+	peers := map[string]Peer{
+		"node1": {Parent: net.ParseIP("10.6.0.1"), MAC: mac1, IPv4: &ip1},
+		"node2": {Parent: net.ParseIP("10.6.0.2"), MAC: mac2, IPv4: &ip2, Previous: []net.IP{net.ParseIP("172.30.0.2")}},
+	}
+	neighs := []netlink.Neigh{
+		{IP: ip1, HardwareAddr: mac1},
+		// the neighbor of node2 points at the MAC of node1
+		{IP: ip2, HardwareAddr: mac1},
+		{IP: net.ParseIP("172.31.0.3"), HardwareAddr: mac3},
+	}
+	fdbs := []netlink.Neigh{
+		{IP: net.ParseIP("10.6.0.1"), HardwareAddr: mac1},
+		// node2 is moved to another parent IP
+		{IP: net.ParseIP("10.6.0.20"), HardwareAddr: mac2},
+	}
+
+	res, stale := DiffPeers(peers, neighs, fdbs)
+	assert.Equal(t, []PeerSync{
+		{Peer: "node1", MAC: mac1.String(), Parent: "10.6.0.1", Synced: true},
+		{
+			Peer:          "node2",
+			MAC:           mac2.String(),
+			Parent:        "10.6.0.2",
+			MissingNeighs: []string{"172.31.0.2", "172.30.0.2"},
+			MissingFDB:    true,
+			StaleFDB:      []string{"10.6.0.20"},
+		},
+	}, res)
+	assert.Equal(t, []string{"172.31.0.3 lladdr " + mac3.String()}, stale)
+
+	RecordPeerSync(res, stale)
+	assert.Equal(t, 1.0, gaugeValue(t, gaugePeerSynced.WithLabelValues("node1", "fdb")))
+	assert.Equal(t, 0.0, gaugeValue(t, gaugePeerSynced.WithLabelValues("node2", "neigh")))
+	assert.Equal(t, 1.0, gaugeValue(t, gaugeStaleEntries))
+
+	// the removed peers are dropped from the metrics
+	RecordPeerSync(res[:1], nil)
+	assert.False(t, gaugePeerSynced.DeleteLabelValues("node2", "neigh"))
+	assert.Equal(t, 0.0, gaugeValue(t, gaugeStaleEntries))
+}
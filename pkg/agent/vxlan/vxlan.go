@@ -4,13 +4,13 @@
 package vxlan
 
 import (
+	"errors"
 	"fmt"
 	"github.com/spidernet-io/egressgateway/pkg/ethtool"
 	wlock "github.com/spidernet-io/egressgateway/pkg/lock"
+	"github.com/spidernet-io/egressgateway/pkg/privilege"
 	"github.com/vishvananda/netlink"
-	"io"
 	"net"
-	"os"
 	"reflect"
 	"syscall"
 )
@@ -20,6 +20,10 @@ type Device struct {
 	lock      wlock.RWMutex
 	link      *netlink.Vxlan
 	getParent func(version int) (*Parent, error)
+	sysctl    privilege.SysctlWriter
+	// cache is the neighbor and the fdb entries of the device, it's only used once the
+	// updates are watched
+	cache *neighCache
 }
 
 func New(options ...func(*Device)) *Device {
@@ -29,7 +33,10 @@ func New(options ...func(*Device)) *Device {
 			LinkByIndex:       netlink.LinkByIndex,
 			AddrList:          netlink.AddrList,
 			LinkByName:        netlink.LinkByName,
+			LinkList:          netlink.LinkList,
 		}),
+		sysctl: privilege.NewSysctlWriter("/proc/sys", ""),
+		cache:  newNeighCache(),
 	}
 	for _, o := range options {
 		o(d)
@@ -43,11 +50,35 @@ func WithCustomGetParent(getParent func(version int) (*Parent, error)) func(devi
 	}
 }
 
+// WithSysctl sets the writer of the sysctls, which writes /proc/sys by default
+func WithSysctl(sysctl privilege.SysctlWriter) func(device *Device) {
+	return func(d *Device) {
+		d.sysctl = sysctl
+	}
+}
+
+// LinkOptions is the optional attributes of vxlan device
+type LinkOptions struct {
+	// SrcPortLow and SrcPortHigh is the range of UDP source port,
+	// the kernel default range is used when both of them are 0
+	SrcPortLow               int
+	SrcPortHigh              int
+	DisableChecksumOffload   bool
+	DisableRXChecksumOffload bool
+	DisableGRO               bool
+	DisableGSO               bool
+	// TOS is the ToS byte of the outer header, 1 inherits the ToS of the inner packets
+	TOS int
+	// PreviousAddrs is the addresses kept on the device along with the current
+	// addresses while the tunnel subnet is being renumbered
+	PreviousAddrs []*net.IPNet
+}
+
 // EnsureLink ensure vxlan device
-// name, vni, port, mac, mtu, ipv4, ipv6, disableChecksumOffload
+// name, vni, port, mac, mtu, ipv4, ipv6, opts
 func (dev *Device) EnsureLink(name string, vni int, port int, mac net.HardwareAddr, mtu int,
 	ipv4, ipv6 *net.IPNet,
-	disableChecksumOffload bool) error {
+	opts LinkOptions) error {
 
 	dev.lock.Lock()
 	defer dev.lock.Unlock()
@@ -71,6 +102,9 @@ func (dev *Device) EnsureLink(name string, vni int, port int, mac net.HardwareAd
 		VtepDevIndex: parent.Index,
 		SrcAddr:      parent.IP,
 		Port:         port,
+		PortLow:      opts.SrcPortLow,
+		PortHigh:     opts.SrcPortHigh,
+		TOS:          opts.TOS,
 		Learning:     false,
 	}
 
@@ -78,13 +112,14 @@ func (dev *Device) EnsureLink(name string, vni int, port int, mac net.HardwareAd
 	if err != nil {
 		return err
 	}
+	dev.cache.setLink(dev.link.Index)
 
-	err = dev.ensureAddr(ipv4, link, netlink.FAMILY_V4)
+	err = dev.ensureAddr(ipv4, opts.PreviousAddrs, link, netlink.FAMILY_V4)
 	if err != nil {
 		return err
 	}
 
-	err = dev.ensureAddr(ipv6, link, netlink.FAMILY_V6)
+	err = dev.ensureAddr(ipv6, opts.PreviousAddrs, link, netlink.FAMILY_V6)
 	if err != nil {
 		return err
 	}
@@ -94,11 +129,9 @@ func (dev *Device) EnsureLink(name string, vni int, port int, mac net.HardwareAd
 		return err
 	}
 
-	if disableChecksumOffload {
-		err = ethtool.EthtoolTXOff(name)
-		if err != nil {
-			return err
-		}
+	err = ensureOffload(name, opts)
+	if err != nil {
+		return err
 	}
 
 	if err := netlink.LinkSetUp(dev.link); err != nil {
@@ -108,6 +141,30 @@ func (dev *Device) EnsureLink(name string, vni int, port int, mac net.HardwareAd
 	return nil
 }
 
+func ensureOffload(name string, opts LinkOptions) error {
+	if opts.DisableChecksumOffload {
+		if err := ethtool.EthtoolTXOff(name); err != nil {
+			return fmt.Errorf("disable tx checksum offload with error: %v", err)
+		}
+	}
+	if opts.DisableRXChecksumOffload {
+		if err := ethtool.EthtoolRXOff(name); err != nil {
+			return fmt.Errorf("disable rx checksum offload with error: %v", err)
+		}
+	}
+	if opts.DisableGRO {
+		if err := ethtool.EthtoolGROOff(name); err != nil {
+			return fmt.Errorf("disable gro with error: %v", err)
+		}
+	}
+	if opts.DisableGSO {
+		if err := ethtool.EthtoolGSOOff(name); err != nil {
+			return fmt.Errorf("disable gso with error: %v", err)
+		}
+	}
+	return nil
+}
+
 func (dev *Device) ensureLink(vxlan *netlink.Vxlan) (*netlink.Vxlan, error) {
 	err := netlink.LinkAdd(vxlan)
 	if err == syscall.EEXIST {
@@ -149,7 +206,7 @@ func (dev *Device) ensureLink(vxlan *netlink.Vxlan) (*netlink.Vxlan, error) {
 func (dev *Device) ensureFilter(ipv4, ipv6 *net.IPNet) error {
 	name := "all"
 	if ipv4 != nil {
-		err := writeProcSys(fmt.Sprintf("/proc/sys/net/ipv4/conf/%s/rp_filter", name), "2")
+		err := dev.sysctl.WriteSysctl(fmt.Sprintf("net/ipv4/conf/%s/rp_filter", name), "2")
 		if err != nil {
 			return err
 		}
@@ -163,6 +220,20 @@ type Peer struct {
 	Parent net.IP
 	MAC    net.HardwareAddr
 	Mark   int
+	// Previous is the tunnel IPs of the peer before the tunnel subnet is renumbered
+	Previous []net.IP
+}
+
+// IPs returns all tunnel IPs of the peer, including the previous IPs
+func (peer Peer) IPs() []net.IP {
+	res := make([]net.IP, 0, 2+len(peer.Previous))
+	if peer.IPv4 != nil {
+		res = append(res, *peer.IPv4)
+	}
+	if peer.IPv6 != nil {
+		res = append(res, *peer.IPv6)
+	}
+	return append(res, peer.Previous...)
 }
 
 func (dev *Device) ListNeigh() ([]netlink.Neigh, error) {
@@ -172,11 +243,50 @@ func (dev *Device) ListNeigh() ([]netlink.Neigh, error) {
 	if dev.notReady() {
 		return nil, nil
 	}
-	existingNeigh, err := netlink.NeighList(dev.link.Index, netlink.FAMILY_V4)
+	neighs, _, err := dev.listEntries()
 	if err != nil {
 		return nil, err
 	}
-	return existingNeigh, nil
+	// only the static entries are managed, the kernel creates the others for IPv6
+	res := make([]netlink.Neigh, 0, len(neighs))
+	for _, item := range neighs {
+		if managedNeigh(item) {
+			res = append(res, item)
+		}
+	}
+	return res, nil
+}
+
+// listEntries returns the neighbor and the fdb entries of the device from the cache, or
+// lists them from the kernel if the cache is not valid
+func (dev *Device) listEntries() ([]netlink.Neigh, []netlink.Neigh, error) {
+	neighs, fdbs, ok, seq, reason := dev.cache.snapshot()
+	if ok {
+		return neighs, fdbs, nil
+	}
+	neighs, err := netlink.NeighList(dev.link.Index, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, nil, err
+	}
+	neighV6, err := netlink.NeighList(dev.link.Index, netlink.FAMILY_V6)
+	if err != nil {
+		return nil, nil, err
+	}
+	neighs = append(neighs, neighV6...)
+	list, err := netlink.NeighList(dev.link.Index, syscall.AF_BRIDGE)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the entries without IPs are the local addresses of the device
+	fdbs = make([]netlink.Neigh, 0, len(list))
+	for _, item := range list {
+		if item.IP != nil {
+			fdbs = append(fdbs, item)
+		}
+	}
+	counterNeighRelists.WithLabelValues(reason).Inc()
+	dev.cache.load(dev.link.Index, seq, neighs, fdbs)
+	return neighs, fdbs, nil
 }
 
 func (dev *Device) Add(peer Peer) error {
@@ -198,7 +308,16 @@ func (dev *Device) Add(peer Peer) error {
 			return err
 		}
 	}
+	for _, ip := range peer.Previous {
+		err := dev.add(peer.MAC, ip)
+		if err != nil {
+			return err
+		}
+	}
 	// fdb
+	if dev.cache.hasFDB(peer.MAC.String(), peer.Parent.String()) {
+		return nil
+	}
 	err := netlink.NeighSet(&netlink.Neigh{
 		LinkIndex:    dev.link.Index,
 		State:        netlink.NUD_PERMANENT,
@@ -208,12 +327,21 @@ func (dev *Device) Add(peer Peer) error {
 		HardwareAddr: peer.MAC,
 	})
 	if err != nil {
-		return err
+		return fmt.Errorf("add fdb entry %s to %s: %w", peer.MAC, peer.Parent, err)
 	}
 	return nil
 }
 
+// IsNeighTableOverflow returns whether the entry is refused because the neighbor table
+// of the node is full, the kernel logs "neighbor table overflow" in that case
+func IsNeighTableOverflow(err error) bool {
+	return errors.Is(err, syscall.ENOBUFS)
+}
+
 func (dev *Device) add(mac net.HardwareAddr, ip net.IP) error {
+	if dev.cache.hasNeigh(ip.String(), mac.String()) {
+		return nil
+	}
 	// arp
 	err := netlink.NeighSet(&netlink.Neigh{
 		LinkIndex:    dev.link.Index,
@@ -223,11 +351,42 @@ func (dev *Device) add(mac net.HardwareAddr, ip net.IP) error {
 		HardwareAddr: mac,
 	})
 	if err != nil {
-		return err
+		return fmt.Errorf("add neighbor %s lladdr %s: %w", ip, mac, err)
 	}
 	return nil
 }
 
+// DelFDB deletes the fdb entry of the peer to the parent IP, it's used to drop the stale
+// entry once the parent IP of the peer changes
+func (dev *Device) DelFDB(mac net.HardwareAddr, parent net.IP) error {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+
+	if dev.notReady() {
+		return nil
+	}
+	err := netlink.NeighDel(&netlink.Neigh{
+		LinkIndex:    dev.link.Index,
+		State:        netlink.NUD_PERMANENT,
+		Family:       syscall.AF_BRIDGE,
+		Flags:        netlink.NTF_SELF,
+		IP:           parent,
+		HardwareAddr: mac,
+	})
+	if errors.Is(err, syscall.ENOENT) {
+		return nil
+	}
+	return err
+}
+
+// DelNeigh deletes the link layer neighbor of the IP without the fdb entry of the peer
+func (dev *Device) DelNeigh(neigh netlink.Neigh) error {
+	if dev.notReady() {
+		return nil
+	}
+	return netlink.NeighDel(&neigh)
+}
+
 func (dev *Device) Del(neigh netlink.Neigh) error {
 	if dev.notReady() {
 		return nil
@@ -282,6 +441,10 @@ func diffLink(l1, l2 netlink.Link) *conflictAttr {
 		return &conflictAttr{name: "group address", got: v1.Group.String(), exp: v2.Group.String()}
 	}
 
+	if v1.TOS != v2.TOS {
+		return &conflictAttr{name: "tos", got: v1.TOS, exp: v2.TOS}
+	}
+
 	if v1.L2miss != v2.L2miss {
 		return &conflictAttr{name: "l2miss", got: v1.L2miss, exp: v2.L2miss}
 	}
@@ -289,34 +452,58 @@ func diffLink(l1, l2 netlink.Link) *conflictAttr {
 	if v1.Port > 0 && v2.Port > 0 && v1.Port != v2.Port {
 		return &conflictAttr{name: "port", got: v1.Port, exp: v2.Port}
 	}
+
+	if (v1.PortLow > 0 || v1.PortHigh > 0) && (v1.PortLow != v2.PortLow || v1.PortHigh != v2.PortHigh) {
+		return &conflictAttr{
+			name: "src port range",
+			got:  fmt.Sprintf("%d-%d", v1.PortLow, v1.PortHigh),
+			exp:  fmt.Sprintf("%d-%d", v2.PortLow, v2.PortHigh),
+		}
+	}
 	return nil
 }
 
-func (dev *Device) ensureAddr(ipn *net.IPNet, link netlink.Link, family int) error {
+func (dev *Device) ensureAddr(ipn *net.IPNet, previous []*net.IPNet, link netlink.Link, family int) error {
 	if ipn == nil {
 		return nil
 	}
 
-	addr := netlink.Addr{IPNet: ipn}
+	expected := []*net.IPNet{ipn}
+	for _, item := range previous {
+		if (item.IP.To4() != nil) == (family == netlink.FAMILY_V4) {
+			expected = append(expected, item)
+		}
+	}
+
 	gotAddrs, err := netlink.AddrList(link, family)
 	if err != nil {
 		return err
 	}
 
-	needAdd := true
+	needAdd := make([]bool, len(expected))
+	for i := range needAdd {
+		needAdd[i] = true
+	}
 	for _, item := range gotAddrs {
-		if !reflect.DeepEqual(item.IPNet, addr.IPNet) {
+		found := false
+		for i, exp := range expected {
+			if reflect.DeepEqual(item.IPNet, exp) {
+				needAdd[i] = false
+				found = true
+			}
+		}
+		if !found {
 			if err := netlink.AddrDel(link, &item); err != nil {
 				return fmt.Errorf("del addr with error: %s, %v", item, err)
 			}
-			continue
 		}
-		needAdd = false
 	}
 
-	if needAdd {
-		if err := netlink.AddrAdd(link, &addr); err != nil {
-			return fmt.Errorf("add addr with error: %v", err)
+	for i, exp := range expected {
+		if needAdd[i] {
+			if err := netlink.AddrAdd(link, &netlink.Addr{IPNet: exp}); err != nil {
+				return fmt.Errorf("add addr with error: %v", err)
+			}
 		}
 	}
 	return nil
@@ -325,25 +512,3 @@ func (dev *Device) ensureAddr(ipn *net.IPNet, link netlink.Link, family int) err
 func (dev *Device) notReady() bool {
 	return dev.link == nil
 }
-
-func writeProcSys(path, value string) error {
-	f, err := os.OpenFile(path, os.O_WRONLY, 0)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
-	}
-	defer func() {
-		if cErr := f.Close(); cErr != nil && err == nil {
-			err = fmt.Errorf("failed to close file: %w", cErr)
-		}
-	}()
-
-	n, err := f.Write([]byte(value))
-	if err != nil {
-		return fmt.Errorf("failed to write value: %w", err)
-	}
-	if n < len(value) {
-		return io.ErrShortWrite
-	}
-
-	return nil
-}
@@ -78,6 +78,21 @@ func TestDiffLink(t *testing.T) {
 			l2:          &netlink.Vxlan{Port: 1235},
 			expConflict: true,
 		},
+		"case9 src port range": {
+			l1:          &netlink.Vxlan{PortLow: 10000, PortHigh: 20000},
+			l2:          &netlink.Vxlan{PortLow: 10000, PortHigh: 30000},
+			expConflict: true,
+		},
+		"case10 default src port range": {
+			l1:          &netlink.Vxlan{},
+			l2:          &netlink.Vxlan{PortLow: 32768, PortHigh: 60999},
+			expConflict: false,
+		},
+		"case11 tos": {
+			l1:          &netlink.Vxlan{TOS: 1},
+			l2:          &netlink.Vxlan{},
+			expConflict: true,
+		},
 	}
 
 	for name, linkCase := range cases {
@@ -114,7 +129,7 @@ func TestVxlan(t *testing.T) {
 
 	err = device.EnsureLink("egress",
 		101, 3456, mac, 0,
-		ipv4Net, nil, true)
+		ipv4Net, nil, LinkOptions{DisableChecksumOffload: true})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,211 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/vxlan"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// networkDeviceName returns the vxlan device name of the dedicated tunnel network
+func networkDeviceName(vni int) string {
+	return fmt.Sprintf("egress.%d", vni)
+}
+
+// networkVTEP returns the vtep of the node in the tunnel network, it returns nil
+// if the addresses of the network are not ready.
+func (r *vxlanReconciler) networkVTEP(status egressv1.EgressTunnelStatus, item egressv1.TunnelNetwork) *vxlan.Peer {
+	mac, err := net.ParseMAC(status.Tunnel.MAC)
+	if err != nil {
+		return nil
+	}
+	parent := status.Tunnel.Parent.IPv4
+	if r.version() == 6 {
+		parent = status.Tunnel.Parent.IPv6
+	}
+	peer := &vxlan.Peer{Parent: net.ParseIP(parent), MAC: mac}
+	if peer.Parent == nil {
+		return nil
+	}
+	if r.cfg.FileConfig.EnableIPv4 {
+		ip := net.ParseIP(item.IPv4).To4()
+		if ip == nil {
+			return nil
+		}
+		peer.IPv4 = &ip
+	}
+	if r.cfg.FileConfig.EnableIPv6 {
+		ip := net.ParseIP(item.IPv6).To16()
+		if ip == nil {
+			return nil
+		}
+		peer.IPv6 = &ip
+	}
+	if mark, err := parseMarkToInt(item.Mark); err == nil {
+		peer.Mark = mark
+	} else if item.Mark != "" {
+		r.log.Error(err, "invalid mark of the tunnel network", "gateway", item.Gateway, "mark", item.Mark)
+	}
+	return peer
+}
+
+// ensureNetworks ensures the vxlan devices, neighbors and route rules of the dedicated
+// tunnel networks of EgressGateways, the marks in use are added to the markMap.
+func (r *vxlanReconciler) ensureNetworks(ctx context.Context, markMap map[int]struct{}) error {
+	tunnels := new(egressv1.EgressTunnelList)
+	if err := r.client.List(ctx, tunnels); err != nil {
+		return err
+	}
+	gateways := new(egressv1.EgressGatewayList)
+	if err := r.client.List(ctx, gateways); err != nil {
+		return err
+	}
+
+	var local *egressv1.EgressTunnel
+	for i := range tunnels.Items {
+		if tunnels.Items[i].Name == r.cfg.EnvConfig.NodeName {
+			local = &tunnels.Items[i]
+		}
+	}
+
+	expected := make(map[string]struct{})
+	if local != nil {
+		for _, gateway := range gateways.Items {
+			if gateway.Spec.Tunnel == nil {
+				continue
+			}
+			item, ok := local.Status.GetNetwork(gateway.Name)
+			if !ok {
+				continue
+			}
+			vtep := r.networkVTEP(local.Status, item)
+			if vtep == nil {
+				r.log.V(1).Info("vtep of tunnel network not ready", "gateway", gateway.Name)
+				continue
+			}
+
+			gatewayNodes := make(map[string]struct{})
+			for _, node := range gateway.Status.NodeList {
+				gatewayNodes[node.Name] = struct{}{}
+			}
+			peers := make(map[string]vxlan.Peer)
+			for _, tunnel := range tunnels.Items {
+				if tunnel.Name == r.cfg.EnvConfig.NodeName {
+					continue
+				}
+				peerItem, ok := tunnel.Status.GetNetwork(gateway.Name)
+				if !ok || peerItem.VNI != item.VNI {
+					continue
+				}
+				if peer := r.networkVTEP(tunnel.Status, peerItem); peer != nil {
+					peers[tunnel.Name] = *peer
+				}
+			}
+
+			name := networkDeviceName(item.VNI)
+			expected[name] = struct{}{}
+			if err := r.ensureNetwork(name, gateway.Spec.Tunnel, *vtep, peers); err != nil {
+				r.log.Error(err, "ensure tunnel network", "gateway", gateway.Name, "device", name)
+				continue
+			}
+
+			for node, peer := range peers {
+				if _, ok := gatewayNodes[node]; !ok || peer.Mark == 0 {
+					continue
+				}
+				markMap[peer.Mark] = struct{}{}
+				base := r.cfg.FileConfig.RouteTable.Base
+				if gateway.Spec.Tunnel.RouteTableBase != 0 {
+					base = gateway.Spec.Tunnel.RouteTableBase
+				}
+				if err := r.ensurePeerRoute(name, peer, base); err != nil {
+					r.log.Error(err, "ensure tunnel network route rule", "gateway", gateway.Name, "peer", node)
+				}
+			}
+		}
+	}
+
+	for name := range r.networkDevs {
+		if _, ok := expected[name]; ok {
+			continue
+		}
+		link, err := netlink.LinkByName(name)
+		if err == nil {
+			if err := netlink.LinkDel(link); err != nil {
+				r.log.Error(err, "delete tunnel network device", "device", name)
+				continue
+			}
+		}
+		delete(r.networkDevs, name)
+	}
+	return nil
+}
+
+// ensureNetwork ensures the vxlan device of the tunnel network and the neighbors of its peers
+func (r *vxlanReconciler) ensureNetwork(name string, tunnel *egressv1.GatewayTunnel,
+	vtep vxlan.Peer, peers map[string]vxlan.Peer) error {
+	dev, ok := r.networkDevs[name]
+	if !ok {
+		dev = vxlan.New(vxlan.WithCustomGetParent(r.getParent), vxlan.WithSysctl(r.sysctl))
+		r.networkDevs[name] = dev
+	}
+
+	var ipv4, ipv6 *net.IPNet
+	if vtep.IPv4 != nil {
+		_, cidr, err := net.ParseCIDR(tunnel.IPv4Subnet)
+		if err != nil {
+			return err
+		}
+		ipv4 = &net.IPNet{IP: *vtep.IPv4, Mask: cidr.Mask}
+	}
+	if vtep.IPv6 != nil {
+		_, cidr, err := net.ParseCIDR(tunnel.IPv6Subnet)
+		if err != nil {
+			return err
+		}
+		ipv6 = &net.IPNet{IP: *vtep.IPv6, Mask: cidr.Mask}
+	}
+
+	opts := vxlan.LinkOptions{
+		SrcPortLow:               r.cfg.FileConfig.VXLAN.SrcPortLow,
+		SrcPortHigh:              r.cfg.FileConfig.VXLAN.SrcPortHigh,
+		DisableChecksumOffload:   r.cfg.FileConfig.VXLAN.DisableChecksumOffload,
+		DisableRXChecksumOffload: r.cfg.FileConfig.VXLAN.DisableRXChecksumOffload,
+		DisableGRO:               r.cfg.FileConfig.VXLAN.DisableGRO,
+		DisableGSO:               r.cfg.FileConfig.VXLAN.DisableGSO,
+		TOS:                      r.cfg.FileConfig.VXLAN.TOS,
+	}
+	err := dev.EnsureLink(name, tunnel.VNI, r.cfg.FileConfig.VXLAN.Port, vtep.MAC, 0, ipv4, ipv6, opts)
+	if err != nil {
+		return err
+	}
+
+	neighList, err := dev.ListNeigh()
+	if err != nil {
+		return err
+	}
+	expected := make(map[string]struct{})
+	for _, peer := range peers {
+		expected[peer.MAC.String()] = struct{}{}
+	}
+	for _, item := range neighList {
+		if _, ok := expected[item.HardwareAddr.String()]; !ok {
+			if err := dev.Del(item); err != nil {
+				r.log.Error(err, "delete link layer neighbor", "device", name, "item", item.String())
+			}
+		}
+	}
+	for node, peer := range peers {
+		if err := dev.Add(peer); err != nil {
+			r.log.Error(err, "add peer route", "device", name, "peer", node)
+		}
+	}
+	return nil
+}
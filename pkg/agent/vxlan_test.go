@@ -0,0 +1,113 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/vxlan"
+)
+
+func TestParseMarkToInt(t *testing.T) {
+	mark, err := parseMarkToInt("0x26000001")
+	assert.NoError(t, err)
+	assert.Equal(t, 0x26000001, mark)
+
+	mark, err = parseMarkToInt("0xf0000001")
+	assert.NoError(t, err)
+	assert.Equal(t, 0xf0000001, mark)
+
+	for _, item := range []string{"", "0x", "0x0x26000001", "10x26", "0x126000001", "-0x1"} {
+		_, err = parseMarkToInt(item)
+		assert.Error(t, err, item)
+	}
+}
+
+func FuzzParseMarkToInt(f *testing.F) {
+	for _, item := range []string{"0x26000001", "26000001", "0xffffffff", "0x100000000", "0x0x1", ""} {
+		f.Add(item)
+	}
+	f.Fuzz(func(t *testing.T, mark string) {
+		res, err := parseMarkToInt(mark)
+		if err != nil {
+			return
+		}
+		if res < 0 || res > 0xffffffff {
+			t.Fatalf("mark %q is parsed out of 32 bits: %d", mark, res)
+		}
+		again, err := parseMarkToInt(fmt.Sprintf("%#x", res))
+		if err != nil || again != res {
+			t.Fatalf("mark %q is parsed to %#x, which is parsed to %#x: %v", mark, res, again, err)
+		}
+	})
+}
+
+func TestProgramPeers(t *testing.T) {
+	peers := make(map[string]vxlan.Peer)
+	for i := 0; i < 50; i++ {
+		mac := net.HardwareAddr{0x66, 0x5f, 0, 0, 0, byte(i)}
+		peers[fmt.Sprintf("node%02d", i)] = vxlan.Peer{Parent: net.IPv4(10, 6, 0, byte(i)), MAC: mac}
+	}
+
+	var running, maxRunning int32
+	var mutex sync.Mutex
+	added := make(map[string]int)
+	errs, overflow := programPeers(peers, 4, func(name string, peer vxlan.Peer) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+
+		mutex.Lock()
+		added[name]++
+		mutex.Unlock()
+		assert.Equal(t, peers[name].MAC, peer.MAC)
+		switch name {
+		case "node07":
+			return errors.New("invalid argument")
+		case "node03":
+			return fmt.Errorf("add neighbor: %w", syscall.ENOBUFS)
+		}
+		return nil
+	})
+
+	// every peer is programmed once, even if the others failed
+	assert.Len(t, added, len(peers))
+	for name, count := range added {
+		assert.Equal(t, 1, count, name)
+	}
+	assert.LessOrEqual(t, maxRunning, int32(4))
+	assert.Greater(t, maxRunning, int32(1))
+
+	// the errors are sorted by the peer names
+	assert.Len(t, errs, 2)
+	assert.ErrorContains(t, errs[0], "peer node03")
+	assert.ErrorContains(t, errs[1], "peer node07")
+	assert.True(t, overflow)
+
+	// the peers are programmed in the order of their names one by one
+	order := make([]string, 0)
+	errs, overflow = programPeers(peers, 0, func(name string, _ vxlan.Peer) error {
+		order = append(order, name)
+		return nil
+	})
+	assert.Empty(t, errs)
+	assert.False(t, overflow)
+	assert.Equal(t, "node00", order[0])
+	assert.Equal(t, "node49", order[len(order)-1])
+}
@@ -0,0 +1,152 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	authv1 "k8s.io/api/authentication/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+var errUnauthenticated = errors.New("unauthenticated")
+
+// Auth authenticates and authorizes the clients of the debug endpoints, it's shared by all
+// the listeners of the controller and the agent
+type Auth struct {
+	mode      string
+	allowed   []string
+	clientCAs *x509.CertPool
+	client    client.Client
+	log       logr.Logger
+}
+
+// New returns the Auth by the settings
+func New(cfg config.Auth, kubeConfig *rest.Config, log logr.Logger) (*Auth, error) {
+	a := &Auth{mode: cfg.Mode, allowed: cfg.AllowedIdentities, log: log}
+	switch cfg.Mode {
+	case config.AuthModeMTLS:
+		raw, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		a.clientCAs = x509.NewCertPool()
+		if !a.clientCAs.AppendCertsFromPEM(raw) {
+			return nil, fmt.Errorf("no certificate found in client CA %s", cfg.ClientCAFile)
+		}
+	case config.AuthModeTokenReview:
+		cli, err := client.New(kubeConfig, client.Options{Scheme: schema.GetScheme()})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create client for token review: %w", err)
+		}
+		a.client = cli
+	}
+	return a, nil
+}
+
+// TLSOptions returns the options of the tls.Config of the listeners, which verify the
+// client certificates in the mtls mode. The clients without a certificate, such as
+// Prometheus, are still able to connect, and are denied by the Handler.
+func (a *Auth) TLSOptions() []func(*tls.Config) {
+	if a.mode != config.AuthModeMTLS {
+		return nil
+	}
+	return []func(*tls.Config){
+		func(c *tls.Config) {
+			c.ClientCAs = a.clientCAs
+			c.ClientAuth = tls.VerifyClientCertIfGiven
+		},
+	}
+}
+
+// Enabled reports whether the clients are authenticated, the debug endpoints are only
+// served if it's enabled
+func (a *Auth) Enabled() bool {
+	return a.mode != config.AuthModeNone
+}
+
+// Handler serves the allowed clients only, all the clients are denied if the auth is
+// not enabled
+func (a *Auth) Handler(next http.Handler) http.Handler {
+	if !a.Enabled() {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		identity, err := a.identity(req)
+		if err != nil {
+			if !errors.Is(err, errUnauthenticated) {
+				a.log.Error(err, "failed to authenticate", "path", req.URL.Path, "remote", req.RemoteAddr)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !a.allow(identity) {
+			a.log.Info("deny the request", "identity", identity, "path", req.URL.Path, "remote", req.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (a *Auth) identity(req *http.Request) (string, error) {
+	switch a.mode {
+	case config.AuthModeMTLS:
+		if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+			return "", errUnauthenticated
+		}
+		for _, uri := range req.TLS.VerifiedChains[0][0].URIs {
+			if uri.Scheme == "spiffe" {
+				return uri.String(), nil
+			}
+		}
+		return "", errUnauthenticated
+	case config.AuthModeTokenReview:
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			return "", errUnauthenticated
+		}
+		ctx, cancel := context.WithTimeout(req.Context(), time.Second*10)
+		defer cancel()
+		review := &authv1.TokenReview{Spec: authv1.TokenReviewSpec{Token: token}}
+		if err := a.client.Create(ctx, review); err != nil {
+			return "", fmt.Errorf("failed to review token: %w", err)
+		}
+		if !review.Status.Authenticated {
+			return "", errUnauthenticated
+		}
+		return review.Status.User.Username, nil
+	}
+	return "", fmt.Errorf("invalid auth mode %s", a.mode)
+}
+
+func (a *Auth) allow(identity string) bool {
+	for _, item := range a.allowed {
+		if prefix, ok := strings.CutSuffix(item, "*"); ok {
+			if strings.HasPrefix(identity, prefix) {
+				return true
+			}
+			continue
+		}
+		if item == identity {
+			return true
+		}
+	}
+	return false
+}
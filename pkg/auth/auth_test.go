@@ -0,0 +1,98 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	authv1 "k8s.io/api/authentication/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+)
+
+// tokenReviewer authenticates the token "admin-token" as the admin serviceaccount
+type tokenReviewer struct {
+	client.Client
+}
+
+func (r tokenReviewer) Create(_ context.Context, obj client.Object, _ ...client.CreateOption) error {
+	review := obj.(*authv1.TokenReview)
+	if review.Spec.Token == "admin-token" {
+		review.Status.Authenticated = true
+		review.Status.User.Username = "system:serviceaccount:default:admin"
+	}
+	return nil
+}
+
+func serve(a *Auth, req *http.Request) int {
+	rec := httptest.NewRecorder()
+	a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestMTLS(t *testing.T) {
+	a := &Auth{
+		mode:      config.AuthModeMTLS,
+		allowed:   []string{"spiffe://cluster.local/ns/egressgateway/*"},
+		clientCAs: x509.NewCertPool(),
+		log:       logr.Discard(),
+	}
+	c := &tls.Config{}
+	for _, o := range a.TLSOptions() {
+		o(c)
+	}
+	assert.Equal(t, tls.VerifyClientCertIfGiven, c.ClientAuth)
+
+	request := func(id string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/loglevel", nil)
+		if id != "" {
+			uri, _ := url.Parse(id)
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{URIs: []*url.URL{uri}}}}}
+		}
+		return req
+	}
+	assert.Equal(t, http.StatusUnauthorized, serve(a, request("")))
+	assert.Equal(t, http.StatusOK, serve(a, request("spiffe://cluster.local/ns/egressgateway/sa/admin")))
+	assert.Equal(t, http.StatusForbidden, serve(a, request("spiffe://cluster.local/ns/default/sa/admin")))
+}
+
+func TestTokenReview(t *testing.T) {
+	a := &Auth{
+		mode:    config.AuthModeTokenReview,
+		allowed: []string{"system:serviceaccount:default:admin"},
+		client:  tokenReviewer{},
+		log:     logr.Discard(),
+	}
+	assert.Empty(t, a.TLSOptions())
+
+	request := func(token string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/loglevel", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req
+	}
+	assert.Equal(t, http.StatusUnauthorized, serve(a, request("")))
+	assert.Equal(t, http.StatusUnauthorized, serve(a, request("invalid")))
+	assert.Equal(t, http.StatusOK, serve(a, request("admin-token")))
+
+	a.allowed = []string{"system:serviceaccount:kube-system:*"}
+	assert.Equal(t, http.StatusForbidden, serve(a, request("admin-token")))
+}
+
+func TestNoAuth(t *testing.T) {
+	a, err := New(config.Auth{}, nil, logr.Discard())
+	assert.NoError(t, err)
+	assert.False(t, a.Enabled())
+	assert.Equal(t, http.StatusForbidden, serve(a, httptest.NewRequest(http.MethodPut, "/loglevel", nil)))
+}
@@ -0,0 +1,230 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package bfd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// the source port range of the control packets defined in RFC 5881
+	srcPortLow  = 49152
+	srcPortHigh = 65535
+
+	// ttl is the TTL of the single hop control packets defined in RFC 5881
+	ttl = 255
+)
+
+// Config is the config of the BFD sessions
+type Config struct {
+	Peers         []net.IP
+	DesiredMinTx  time.Duration
+	RequiredMinRx time.Duration
+	DetectMult    uint8
+	// Port is the port to listen and the destination port, the default is Port
+	Port int
+}
+
+// Server runs a BFD session with every peer. The sessions are AdminDown until the
+// server is activated, so that the peers only route to the node while it is active.
+type Server struct {
+	log logr.Logger
+	cfg Config
+
+	mu       sync.RWMutex
+	active   bool
+	sessions map[string]*session
+	byDisc   map[uint32]*session
+}
+
+// New returns a server with the sessions of the peers in AdminDown
+func New(log logr.Logger, cfg Config) *Server {
+	if cfg.Port == 0 {
+		cfg.Port = Port
+	}
+	s := &Server{
+		log:      log,
+		cfg:      cfg,
+		sessions: make(map[string]*session),
+		byDisc:   make(map[uint32]*session),
+	}
+	for _, peer := range cfg.Peers {
+		disc := rand.Uint32()
+		for disc == 0 || s.byDisc[disc] != nil {
+			disc = rand.Uint32()
+		}
+		sess := newSession(peer, cfg, disc, true)
+		sess.onChange = s.onChange
+		s.sessions[peer.String()] = sess
+		s.byDisc[disc] = sess
+	}
+	return s
+}
+
+func (s *Server) onChange(peer net.IP, from, to State, diag Diag) {
+	s.log.Info("bfd session state changed", "peer", peer.String(), "from", from.String(), "to", to.String(), "diag", diag)
+	recordState(peer.String(), to)
+}
+
+// SetActive sets the sessions out of AdminDown when the node is active, e.g. it holds
+// egress IPs, or into AdminDown to let the peers withdraw the routes to the node.
+func (s *Server) SetActive(active bool) {
+	s.mu.Lock()
+	changed := s.active != active
+	s.active = active
+	s.mu.Unlock()
+	if !changed {
+		return
+	}
+	s.log.Info("set bfd sessions active", "active", active)
+	for _, sess := range s.sessions {
+		sess.setAdminDown(!active)
+	}
+}
+
+// States returns the state of the session of every peer
+func (s *Server) States() map[string]State {
+	res := make(map[string]State, len(s.sessions))
+	for peer, sess := range s.sessions {
+		res[peer] = sess.State()
+	}
+	return res
+}
+
+// Start runs the sessions until the context is done
+func (s *Server) Start(ctx context.Context) error {
+	if len(s.sessions) == 0 {
+		return nil
+	}
+	conn, err := net.ListenPacket("udp", net.JoinHostPort("", strconv.Itoa(s.cfg.Port)))
+	if err != nil {
+		return fmt.Errorf("failed to listen bfd port %d: %w", s.cfg.Port, err)
+	}
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	for _, sess := range s.sessions {
+		recordState(sess.peer.String(), sess.State())
+		go s.transmit(ctx, sess)
+	}
+
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			continue
+		}
+		p := new(Packet)
+		if err := p.Unmarshal(buf[:n]); err != nil {
+			s.log.V(1).Info("drop invalid bfd packet", "from", addr.String(), "reason", err.Error())
+			continue
+		}
+		sess := s.lookup(p, addr)
+		if sess == nil {
+			continue
+		}
+		sess.handle(p, time.Now())
+	}
+}
+
+// lookup finds the session of the packet by the discriminator, or by the source
+// address if the peer does not know the discriminator yet
+func (s *Server) lookup(p *Packet, addr net.Addr) *session {
+	if p.YourDiscriminator != 0 {
+		return s.byDisc[p.YourDiscriminator]
+	}
+	udp, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+	return s.sessions[udp.IP.String()]
+}
+
+// transmit sends the control packets of the session periodically, and checks the
+// detection time at the same time
+func (s *Server) transmit(ctx context.Context, sess *session) {
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-sess.kick:
+		}
+		sess.checkTimeout(time.Now())
+
+		interval, send := sess.txInterval()
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(interval)
+		if !send {
+			continue
+		}
+
+		if conn == nil {
+			var err error
+			conn, err = s.dial(sess.peer)
+			if err != nil {
+				s.log.Error(err, "dial bfd peer", "peer", sess.peer.String())
+				continue
+			}
+		}
+		if _, err := conn.Write(sess.packet().Marshal()); err != nil {
+			s.log.V(1).Info("send bfd packet", "peer", sess.peer.String(), "error", err.Error())
+		}
+	}
+}
+
+// dial connects to the peer from a port in the source port range with TTL 255
+func (s *Server) dial(peer net.IP) (net.Conn, error) {
+	raddr := &net.UDPAddr{IP: peer, Port: s.cfg.Port}
+	var err error
+	for i := 0; i < 10; i++ {
+		laddr := &net.UDPAddr{Port: srcPortLow + rand.Intn(srcPortHigh-srcPortLow+1)}
+		var conn *net.UDPConn
+		conn, err = net.DialUDP("udp", laddr, raddr)
+		if err != nil {
+			continue
+		}
+		if peer.To4() != nil {
+			err = ipv4.NewConn(conn).SetTTL(ttl)
+		} else {
+			err = ipv6.NewConn(conn).SetHopLimit(ttl)
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	return nil, err
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package bfd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacketMarshal(t *testing.T) {
+	p := &Packet{
+		Diag:                  DiagNeighborSignaledDown,
+		State:                 StateUp,
+		Poll:                  true,
+		DetectMult:            3,
+		MyDiscriminator:       1,
+		YourDiscriminator:     2,
+		DesiredMinTxInterval:  300000,
+		RequiredMinRxInterval: 300000,
+	}
+	b := p.Marshal()
+	assert.Len(t, b, packetLength)
+	assert.Equal(t, byte(0x23), b[0])
+
+	got := new(Packet)
+	assert.NoError(t, got.Unmarshal(b))
+	assert.Equal(t, p, got)
+
+	// an Up packet without your discriminator is invalid
+	p.YourDiscriminator = 0
+	assert.Error(t, got.Unmarshal(p.Marshal()))
+	assert.Error(t, got.Unmarshal(b[:10]))
+}
+
+func TestSessionHandshake(t *testing.T) {
+	cfg := Config{DesiredMinTx: 300 * time.Millisecond, RequiredMinRx: 300 * time.Millisecond, DetectMult: 3}
+	a := newSession(net.ParseIP("10.0.0.1"), cfg, 1, true)
+	b := newSession(net.ParseIP("10.0.0.2"), cfg, 2, false)
+	now := time.Now()
+	exchange := func() {
+		b.handle(a.packet(), now)
+		a.handle(b.packet(), now)
+	}
+
+	// the peer doesn't come up while the session is AdminDown
+	exchange()
+	assert.Equal(t, StateAdminDown, a.State())
+	assert.Equal(t, StateDown, b.State())
+
+	a.setAdminDown(false)
+	exchange()
+	exchange()
+	assert.Equal(t, StateUp, a.State())
+	assert.Equal(t, StateUp, b.State())
+	interval, send := a.txInterval()
+	assert.True(t, send)
+	assert.LessOrEqual(t, interval, 300*time.Millisecond)
+
+	// the peer is notified when the session goes AdminDown
+	a.setAdminDown(true)
+	exchange()
+	assert.Equal(t, StateDown, b.State())
+	assert.Equal(t, DiagNeighborSignaledDown, b.diag)
+
+	// the session goes Down when the detection time expires
+	a.setAdminDown(false)
+	exchange()
+	exchange()
+	assert.Equal(t, StateUp, b.State())
+	b.checkTimeout(now.Add(500 * time.Millisecond))
+	assert.Equal(t, StateUp, b.State())
+	b.checkTimeout(now.Add(time.Second))
+	assert.Equal(t, StateDown, b.State())
+	assert.Equal(t, DiagDetectionTimeExpired, b.diag)
+	assert.Equal(t, uint32(0), b.remoteDisc)
+}
+
+func TestSessionPoll(t *testing.T) {
+	cfg := Config{DesiredMinTx: time.Second, RequiredMinRx: time.Second, DetectMult: 3}
+	s := newSession(net.ParseIP("10.0.0.1"), cfg, 1, false)
+	s.handle(&Packet{State: StateDown, Poll: true, DetectMult: 3, MyDiscriminator: 2}, time.Now())
+	assert.True(t, s.packet().Final)
+	assert.False(t, s.packet().Final)
+}
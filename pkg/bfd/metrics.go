@@ -0,0 +1,21 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package bfd
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var gaugeSessionState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "egress_bfd_session_state",
+	Help: "State of the BFD session with the peer, 0 AdminDown, 1 Down, 2 Init, 3 Up",
+}, []string{"peer"})
+
+func MetricCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		gaugeSessionState,
+	}
+}
+
+func recordState(peer string, state State) {
+	gaugeSessionState.WithLabelValues(peer).Set(float64(state))
+}
@@ -0,0 +1,137 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package bfd is a minimal implementation of the asynchronous mode of single hop
+// Bidirectional Forwarding Detection (RFC 5880 and RFC 5881). The echo function and
+// authentication are not supported.
+package bfd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// Port is the UDP destination port of the single hop BFD control packets
+	Port = 3784
+
+	version      = 1
+	packetLength = 24
+)
+
+// State is the state of a BFD session
+type State uint8
+
+const (
+	StateAdminDown State = 0
+	StateDown      State = 1
+	StateInit      State = 2
+	StateUp        State = 3
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAdminDown:
+		return "AdminDown"
+	case StateDown:
+		return "Down"
+	case StateInit:
+		return "Init"
+	case StateUp:
+		return "Up"
+	}
+	return fmt.Sprintf("State(%d)", uint8(s))
+}
+
+// Diag is the diagnostic code of the last state change of the local system
+type Diag uint8
+
+const (
+	DiagNone                 Diag = 0
+	DiagDetectionTimeExpired Diag = 1
+	DiagNeighborSignaledDown Diag = 3
+	DiagAdminDown            Diag = 7
+)
+
+const (
+	flagPoll  = 1 << 5
+	flagFinal = 1 << 4
+)
+
+// Packet is a BFD control packet
+type Packet struct {
+	Diag  Diag
+	State State
+	Poll  bool
+	Final bool
+
+	DetectMult            uint8
+	MyDiscriminator       uint32
+	YourDiscriminator     uint32
+	DesiredMinTxInterval  uint32
+	RequiredMinRxInterval uint32
+}
+
+// Marshal encodes the packet, the intervals are in microseconds
+func (p *Packet) Marshal() []byte {
+	b := make([]byte, packetLength)
+	b[0] = version<<5 | uint8(p.Diag)&0x1f
+	b[1] = uint8(p.State) << 6
+	if p.Poll {
+		b[1] |= flagPoll
+	}
+	if p.Final {
+		b[1] |= flagFinal
+	}
+	b[2] = p.DetectMult
+	b[3] = packetLength
+	binary.BigEndian.PutUint32(b[4:], p.MyDiscriminator)
+	binary.BigEndian.PutUint32(b[8:], p.YourDiscriminator)
+	binary.BigEndian.PutUint32(b[12:], p.DesiredMinTxInterval)
+	binary.BigEndian.PutUint32(b[16:], p.RequiredMinRxInterval)
+	return b
+}
+
+// Unmarshal decodes and validates the packet by the reception rules of RFC 5880 6.8.6
+func (p *Packet) Unmarshal(b []byte) error {
+	if len(b) < packetLength {
+		return errors.New("packet too short")
+	}
+	if b[0]>>5 != version {
+		return fmt.Errorf("unsupported version %d", b[0]>>5)
+	}
+	length := int(b[3])
+	if length < packetLength || length > len(b) {
+		return fmt.Errorf("invalid length %d", length)
+	}
+	// the authentication is not supported
+	if b[1]&0x04 != 0 {
+		return errors.New("authentication is not supported")
+	}
+	// the multipoint bit must be zero
+	if b[1]&0x01 != 0 {
+		return errors.New("multipoint bit is set")
+	}
+
+	p.Diag = Diag(b[0] & 0x1f)
+	p.State = State(b[1] >> 6)
+	p.Poll = b[1]&flagPoll != 0
+	p.Final = b[1]&flagFinal != 0
+	p.DetectMult = b[2]
+	p.MyDiscriminator = binary.BigEndian.Uint32(b[4:])
+	p.YourDiscriminator = binary.BigEndian.Uint32(b[8:])
+	p.DesiredMinTxInterval = binary.BigEndian.Uint32(b[12:])
+	p.RequiredMinRxInterval = binary.BigEndian.Uint32(b[16:])
+
+	if p.DetectMult == 0 {
+		return errors.New("detect multiplier is zero")
+	}
+	if p.MyDiscriminator == 0 {
+		return errors.New("my discriminator is zero")
+	}
+	if p.YourDiscriminator == 0 && p.State != StateDown && p.State != StateAdminDown {
+		return errors.New("your discriminator is zero")
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package bfd
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// slowTxInterval is the minimum transmit interval when the session is not Up
+const slowTxInterval = time.Second
+
+// session is the state of the BFD session with a peer
+type session struct {
+	mu sync.Mutex
+
+	peer          net.IP
+	desiredMinTx  time.Duration
+	requiredMinRx time.Duration
+	detectMult    uint8
+
+	state      State
+	diag       Diag
+	localDisc  uint32
+	remoteDisc uint32
+
+	remoteMinRx     time.Duration
+	remoteDesiredTx time.Duration
+	remoteMult      uint8
+	lastRx          time.Time
+
+	// final is set when a poll is received, the next packet is sent with the final bit
+	final bool
+	// kick triggers an immediate transmission
+	kick chan struct{}
+
+	onChange func(peer net.IP, from, to State, diag Diag)
+}
+
+func newSession(peer net.IP, cfg Config, disc uint32, adminDown bool) *session {
+	s := &session{
+		peer:          peer,
+		desiredMinTx:  cfg.DesiredMinTx,
+		requiredMinRx: cfg.RequiredMinRx,
+		detectMult:    cfg.DetectMult,
+		state:         StateDown,
+		localDisc:     disc,
+		kick:          make(chan struct{}, 1),
+	}
+	if adminDown {
+		s.state = StateAdminDown
+		s.diag = DiagAdminDown
+	}
+	return s
+}
+
+func (s *session) setState(state State, diag Diag) {
+	if s.state == state {
+		return
+	}
+	from := s.state
+	s.state = state
+	s.diag = diag
+	if s.onChange != nil {
+		s.onChange(s.peer, from, state, diag)
+	}
+	s.triggerTx()
+}
+
+func (s *session) triggerTx() {
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+// handle processes a received control packet by RFC 5880 6.8.6
+func (s *session) handle(p *Packet, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.YourDiscriminator != 0 && p.YourDiscriminator != s.localDisc {
+		return
+	}
+	s.remoteDisc = p.MyDiscriminator
+	s.remoteMinRx = time.Duration(p.RequiredMinRxInterval) * time.Microsecond
+	s.remoteDesiredTx = time.Duration(p.DesiredMinTxInterval) * time.Microsecond
+	s.remoteMult = p.DetectMult
+	s.lastRx = now
+	if p.Poll {
+		s.final = true
+		s.triggerTx()
+	}
+
+	if s.state == StateAdminDown {
+		return
+	}
+	if p.State == StateAdminDown {
+		if s.state != StateDown {
+			s.setState(StateDown, DiagNeighborSignaledDown)
+		}
+		return
+	}
+	switch s.state {
+	case StateDown:
+		if p.State == StateDown {
+			s.setState(StateInit, DiagNone)
+		} else if p.State == StateInit {
+			s.setState(StateUp, DiagNone)
+		}
+	case StateInit:
+		if p.State == StateInit || p.State == StateUp {
+			s.setState(StateUp, DiagNone)
+		}
+	case StateUp:
+		if p.State == StateDown {
+			s.setState(StateDown, DiagNeighborSignaledDown)
+		}
+	}
+}
+
+// detectionTime returns the detection time of the asynchronous mode
+func (s *session) detectionTime() time.Duration {
+	interval := s.requiredMinRx
+	if s.remoteDesiredTx > interval {
+		interval = s.remoteDesiredTx
+	}
+	return time.Duration(s.remoteMult) * interval
+}
+
+// checkTimeout moves the session to Down when no packet is received in the detection time
+func (s *session) checkTimeout(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state != StateInit && s.state != StateUp {
+		return
+	}
+	if now.After(s.lastRx.Add(s.detectionTime())) {
+		s.remoteDisc = 0
+		s.setState(StateDown, DiagDetectionTimeExpired)
+	}
+}
+
+// setAdminDown moves the session to or out of AdminDown
+func (s *session) setAdminDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if down && s.state != StateAdminDown {
+		s.setState(StateAdminDown, DiagAdminDown)
+	} else if !down && s.state == StateAdminDown {
+		s.setState(StateDown, DiagNone)
+	}
+}
+
+func (s *session) localDesiredTx() time.Duration {
+	if s.state != StateUp && s.desiredMinTx < slowTxInterval {
+		return slowTxInterval
+	}
+	return s.desiredMinTx
+}
+
+// txInterval returns the jittered interval to the next transmission, and whether
+// the packets should be transmitted
+func (s *session) txInterval() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	interval := s.localDesiredTx()
+	if s.remoteMinRx > interval {
+		interval = s.remoteMinRx
+	}
+	// the interval is reduced by 0 to 25 percent, or 10 to 25 percent if the
+	// detect multiplier is 1
+	low := 75
+	high := 100
+	if s.detectMult == 1 {
+		high = 90
+	}
+	interval = interval * time.Duration(low+rand.Intn(high-low+1)) / 100
+	// the peer does not want to receive any periodic packets
+	send := !(s.remoteDisc != 0 && s.remoteMinRx == 0)
+	return interval, send
+}
+
+// packet returns the control packet to transmit
+func (s *session) packet() *Packet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := &Packet{
+		Diag:                  s.diag,
+		State:                 s.state,
+		Final:                 s.final,
+		DetectMult:            s.detectMult,
+		MyDiscriminator:       s.localDisc,
+		YourDiscriminator:     s.remoteDisc,
+		DesiredMinTxInterval:  uint32(s.localDesiredTx() / time.Microsecond),
+		RequiredMinRxInterval: uint32(s.requiredMinRx / time.Microsecond),
+	}
+	s.final = false
+	return p
+}
+
+func (s *session) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
@@ -0,0 +1,141 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestCRDs(t *testing.T) {
+	list, err := crds()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, list)
+	for _, crd := range list {
+		assert.Equal(t, "CustomResourceDefinition", crd.GetKind())
+		assert.Contains(t, crd.GetName(), ".egressgateway.spidernet.io")
+	}
+}
+
+// the embedded CRDs must be the copies of the chart CRDs, otherwise the fields missing in the
+// embedded ones are dropped by the API server in the bootstrap mode
+func TestCRDsMatchChart(t *testing.T) {
+	dir := filepath.Join("..", "..", "charts", "crds")
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	embedded, err := crdFS.ReadDir("crds")
+	assert.NoError(t, err)
+	assert.Equal(t, len(entries), len(embedded))
+
+	for _, entry := range entries {
+		expected, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		assert.NoError(t, err)
+		actual, err := crdFS.ReadFile("crds/" + entry.Name())
+		if !assert.NoError(t, err, "the CRD %s is not embedded", entry.Name()) {
+			continue
+		}
+		assert.True(t, bytes.Equal(expected, actual), "the embedded CRD %s differs from the chart, run tools/golang/crdControllerGen.sh", entry.Name())
+	}
+}
+
+func TestRenew(t *testing.T) {
+	names := []string{"svc", "svc.ns", "svc.ns.svc", "svc.ns.svc.cluster.local"}
+	now := time.Now()
+
+	data, renewed, err := renew(nil, names, now)
+	assert.NoError(t, err)
+	assert.True(t, renewed)
+	server, err := parseCert(data[keyServerCert])
+	assert.NoError(t, err)
+	assert.Equal(t, names, server.DNSNames)
+
+	// the valid certificates are kept
+	kept, renewed, err := renew(data, names, now)
+	assert.NoError(t, err)
+	assert.False(t, renewed)
+	assert.Equal(t, data, kept)
+
+	// the server certificate is renewed before it expires
+	later := now.Add(serverValidity - renewBefore/2)
+	next, renewed, err := renew(data, names, later)
+	assert.NoError(t, err)
+	assert.True(t, renewed)
+	assert.Equal(t, data[keyCACert], next[keyCACert])
+	assert.NotEqual(t, data[keyServerCert], next[keyServerCert])
+
+	// the previous CA is kept in the bundle after the CA is renewed
+	later = now.Add(caValidity - renewBefore/2)
+	next, renewed, err = renew(data, names, later)
+	assert.NoError(t, err)
+	assert.True(t, renewed)
+	assert.NotEqual(t, data[keyCACert], next[keyCACert])
+	assert.Equal(t, data[keyCACert], next[keyPreviousCACert])
+
+	// the server certificate is renewed if the service is changed
+	_, renewed, err = renew(data, []string{"other"}, now)
+	assert.NoError(t, err)
+	assert.True(t, renewed)
+}
+
+func TestEnsure(t *testing.T) {
+	webhook := admissionv1.WebhookClientConfig{}
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(
+		&admissionv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "egressgateway-controller"},
+			Webhooks:   []admissionv1.ValidatingWebhook{{Name: "validate", ClientConfig: webhook}},
+		},
+		&admissionv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "egressgateway-controller"},
+			Webhooks:   []admissionv1.MutatingWebhook{{Name: "mutate", ClientConfig: webhook}},
+		},
+	).Build()
+
+	certs := &Certs{
+		Client:      cli,
+		Namespace:   "kube-system",
+		Service:     "egressgateway-controller",
+		SecretName:  "egressgateway-controller-server-certs",
+		WebhookName: "egressgateway-controller",
+		CertDir:     t.TempDir(),
+		Log:         logr.Discard(),
+	}
+	ctx := context.Background()
+	assert.NoError(t, certs.Ensure(ctx))
+
+	secret := new(corev1.Secret)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKey{Namespace: "kube-system", Name: certs.SecretName}, secret))
+	for _, name := range []string{keyServerCert, keyServerKey, keyCACert} {
+		content, err := os.ReadFile(filepath.Join(certs.CertDir, name))
+		assert.NoError(t, err)
+		assert.Equal(t, secret.Data[name], content)
+	}
+
+	validating := new(admissionv1.ValidatingWebhookConfiguration)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKey{Name: certs.WebhookName}, validating))
+	assert.Equal(t, secret.Data[keyCACert], validating.Webhooks[0].ClientConfig.CABundle)
+	mutating := new(admissionv1.MutatingWebhookConfiguration)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKey{Name: certs.WebhookName}, mutating))
+	assert.Equal(t, secret.Data[keyCACert], mutating.Webhooks[0].ClientConfig.CABundle)
+
+	// the replicas share the certificates in the secret
+	rv := secret.ResourceVersion
+	certs.CertDir = t.TempDir()
+	assert.NoError(t, certs.Ensure(ctx))
+	assert.NoError(t, cli.Get(ctx, client.ObjectKey{Namespace: "kube-system", Name: certs.SecretName}, secret))
+	assert.Equal(t, rv, secret.ResourceVersion)
+}
@@ -0,0 +1,327 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	caValidity     = time.Hour * 24 * 365 * 10
+	serverValidity = time.Hour * 24 * 365
+	// renewBefore renews the certificates before they expire
+	renewBefore = time.Hour * 24 * 30
+	// checkInterval is the interval to check the certificates and the CA bundles, the
+	// CA bundles are reset once the webhook configurations are applied again, such as by
+	// the upgrade of the release
+	checkInterval = time.Minute
+
+	keyCACert         = "ca.crt"
+	keyCAKey          = "ca.key"
+	keyPreviousCACert = "ca-previous.crt"
+	keyServerCert     = corev1.TLSCertKey
+	keyServerKey      = corev1.TLSPrivateKeyKey
+)
+
+// Certs manages the serving certificates of the webhook without cert-manager. The CA and
+// the server certificate are kept in the Secret shared by the replicas, each replica
+// writes them to its CertDir where the webhook server reloads them, and the CA bundles
+// of the webhook configurations are patched to the CA. The certificates are renewed
+// before they expire, and the previous CA stays in the CA bundles after the CA is
+// renewed, so the replicas serving the previous certificate are still trusted.
+type Certs struct {
+	Client     client.Client
+	Namespace  string
+	Service    string
+	SecretName string
+	// WebhookName is the name of the ValidatingWebhookConfiguration and the
+	// MutatingWebhookConfiguration
+	WebhookName string
+	CertDir     string
+	Log         logr.Logger
+}
+
+// Start checks the certificates periodically until the ctx is done
+func (c *Certs) Start(ctx context.Context) error {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := c.Ensure(ctx); err != nil {
+			c.Log.Error(err, "failed to ensure webhook certificates")
+		}
+	}, checkInterval)
+	return nil
+}
+
+func (c *Certs) NeedLeaderElection() bool { return false }
+
+// Ensure generates or renews the certificates in the Secret if needed, writes them to
+// CertDir, and patches the CA bundles of the webhook configurations
+func (c *Certs) Ensure(ctx context.Context) error {
+	secret := new(corev1.Secret)
+	err := c.Client.Get(ctx, client.ObjectKey{Namespace: c.Namespace, Name: c.SecretName}, secret)
+	if apierrors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: c.Namespace, Name: c.SecretName},
+			Type:       corev1.SecretTypeTLS,
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to get secret %s: %w", c.SecretName, err)
+	}
+
+	data, renewed, err := renew(secret.Data, c.dnsNames(), time.Now())
+	if err != nil {
+		return err
+	}
+	if renewed {
+		secret.Data = data
+		if secret.ResourceVersion == "" {
+			err = c.Client.Create(ctx, secret)
+		} else {
+			err = c.Client.Update(ctx, secret)
+		}
+		// another replica renews it at the same time, use its certificates next time
+		if apierrors.IsAlreadyExists(err) || apierrors.IsConflict(err) {
+			return fmt.Errorf("secret %s is renewed by others, retry later", c.SecretName)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save secret %s: %w", c.SecretName, err)
+		}
+		c.Log.Info("renew webhook certificates", "secret", c.SecretName)
+	}
+
+	for name, key := range map[string]string{
+		keyServerCert: keyServerCert,
+		keyServerKey:  keyServerKey,
+		keyCACert:     keyCACert,
+	} {
+		if err := writeFile(filepath.Join(c.CertDir, name), data[key]); err != nil {
+			return err
+		}
+	}
+
+	bundle := append(append([]byte{}, data[keyCACert]...), data[keyPreviousCACert]...)
+	return c.patchCABundle(ctx, bundle)
+}
+
+func (c *Certs) dnsNames() []string {
+	return []string{
+		c.Service,
+		fmt.Sprintf("%s.%s", c.Service, c.Namespace),
+		fmt.Sprintf("%s.%s.svc", c.Service, c.Namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", c.Service, c.Namespace),
+	}
+}
+
+func (c *Certs) patchCABundle(ctx context.Context, bundle []byte) error {
+	validating := new(admissionv1.ValidatingWebhookConfiguration)
+	if err := c.Client.Get(ctx, client.ObjectKey{Name: c.WebhookName}, validating); err != nil {
+		return fmt.Errorf("failed to get validating webhook %s: %w", c.WebhookName, err)
+	}
+	changed := false
+	for i := range validating.Webhooks {
+		if !bytes.Equal(validating.Webhooks[i].ClientConfig.CABundle, bundle) {
+			validating.Webhooks[i].ClientConfig.CABundle = bundle
+			changed = true
+		}
+	}
+	if changed {
+		if err := c.Client.Update(ctx, validating); err != nil {
+			return fmt.Errorf("failed to update CA bundle of validating webhook %s: %w", c.WebhookName, err)
+		}
+		c.Log.Info("update CA bundle of validating webhook", "name", c.WebhookName)
+	}
+
+	mutating := new(admissionv1.MutatingWebhookConfiguration)
+	if err := c.Client.Get(ctx, client.ObjectKey{Name: c.WebhookName}, mutating); err != nil {
+		return fmt.Errorf("failed to get mutating webhook %s: %w", c.WebhookName, err)
+	}
+	changed = false
+	for i := range mutating.Webhooks {
+		if !bytes.Equal(mutating.Webhooks[i].ClientConfig.CABundle, bundle) {
+			mutating.Webhooks[i].ClientConfig.CABundle = bundle
+			changed = true
+		}
+	}
+	if changed {
+		if err := c.Client.Update(ctx, mutating); err != nil {
+			return fmt.Errorf("failed to update CA bundle of mutating webhook %s: %w", c.WebhookName, err)
+		}
+		c.Log.Info("update CA bundle of mutating webhook", "name", c.WebhookName)
+	}
+	return nil
+}
+
+// renew returns the certificates renewed if the CA or the server certificate in data is
+// missing, invalid, or going to expire, and the server certificate if it doesn't match
+// the dnsNames
+func renew(data map[string][]byte, dnsNames []string, now time.Time) (map[string][]byte, bool, error) {
+	res := make(map[string][]byte, len(data))
+	for k, v := range data {
+		res[k] = v
+	}
+
+	pruned := false
+	ca, caKey, err := parseKeyPair(res[keyCACert], res[keyCAKey])
+	renewCA := err != nil || now.Add(renewBefore).After(ca.NotAfter)
+	if renewCA {
+		if ca != nil && now.Before(ca.NotAfter) {
+			res[keyPreviousCACert] = res[keyCACert]
+		} else {
+			delete(res, keyPreviousCACert)
+		}
+		ca, caKey, res[keyCACert], res[keyCAKey], err = newCA(now)
+		if err != nil {
+			return nil, false, err
+		}
+	} else if previous, _ := parseCert(res[keyPreviousCACert]); previous != nil && now.After(previous.NotAfter) {
+		delete(res, keyPreviousCACert)
+		pruned = true
+	}
+
+	server, _, err := parseKeyPair(res[keyServerCert], res[keyServerKey])
+	renewServer := renewCA || err != nil || now.Add(renewBefore).After(server.NotAfter) ||
+		server.CheckSignatureFrom(ca) != nil || !equalNames(server.DNSNames, dnsNames)
+	if renewServer {
+		res[keyServerCert], res[keyServerKey], err = newServerCert(ca, caKey, dnsNames, now)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return res, pruned || renewCA || renewServer, nil
+}
+
+func newCA(now time.Time) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte, error) {
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "egressgateway-webhook-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certPEM, keyPEM, key, err := sign(template, nil, nil)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	ca, err := parseCert(certPEM)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return ca, key, certPEM, keyPEM, nil
+}
+
+func newServerCert(ca *x509.Certificate, caKey *ecdsa.PrivateKey, dnsNames []string, now time.Time) ([]byte, []byte, error) {
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:    dnsNames,
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(serverValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certPEM, keyPEM, _, err := sign(template, ca, caKey)
+	return certPEM, keyPEM, err
+}
+
+// sign signs the template by the parent, or by itself if the parent is nil
+func sign(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) ([]byte, []byte, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	template.SerialNumber = serial
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, key, nil
+}
+
+func parseCert(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parseKeyPair(certPEM, keyPEM []byte) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	cert, err := parseCert(certPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no private key found")
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !key.PublicKey.Equal(cert.PublicKey) {
+		return nil, nil, fmt.Errorf("private key does not match certificate")
+	}
+	return cert, key, nil
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeFile replaces the file at once if the content is changed, so the webhook server
+// never reads a partial certificate
+func writeFile(path string, content []byte) error {
+	current, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(current, content) {
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
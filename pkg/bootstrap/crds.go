@@ -0,0 +1,88 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package bootstrap
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"path"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// crdFS is the CRD manifests copied from the chart by `make update_crd_sdk`
+//
+//go:embed crds/*.yaml
+var crdFS embed.FS
+
+// crds returns the embedded CRDs
+func crds() ([]*unstructured.Unstructured, error) {
+	entries, err := crdFS.ReadDir("crds")
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*unstructured.Unstructured, 0, len(entries))
+	for _, entry := range entries {
+		raw, err := crdFS.ReadFile(path.Join("crds", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		obj := new(unstructured.Unstructured)
+		if err := yaml.Unmarshal(raw, &obj.Object); err != nil {
+			return nil, fmt.Errorf("failed to parse CRD %s: %w", entry.Name(), err)
+		}
+		res = append(res, obj)
+	}
+	return res, nil
+}
+
+// InstallCRDs creates the embedded CRDs, and updates the existing ones to the embedded
+// version, since Helm never upgrades the CRDs
+func InstallCRDs(ctx context.Context, cli client.Client, log logr.Logger) error {
+	list, err := crds()
+	if err != nil {
+		return err
+	}
+	for _, crd := range list {
+		existing := new(unstructured.Unstructured)
+		existing.SetGroupVersionKind(crd.GroupVersionKind())
+		err := cli.Get(ctx, client.ObjectKeyFromObject(crd), existing)
+		if apierrors.IsNotFound(err) {
+			if err := cli.Create(ctx, crd); err != nil {
+				return fmt.Errorf("failed to create CRD %s: %w", crd.GetName(), err)
+			}
+			log.Info("create CRD", "name", crd.GetName())
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get CRD %s: %w", crd.GetName(), err)
+		}
+
+		crd.SetResourceVersion(existing.GetResourceVersion())
+		crd.SetLabels(mergeMap(existing.GetLabels(), crd.GetLabels()))
+		crd.SetAnnotations(mergeMap(existing.GetAnnotations(), crd.GetAnnotations()))
+		if err := cli.Update(ctx, crd); err != nil {
+			return fmt.Errorf("failed to update CRD %s: %w", crd.GetName(), err)
+		}
+		log.Info("update CRD", "name", crd.GetName())
+	}
+	return nil
+}
+
+// mergeMap keeps the labels and annotations set by others, such as the ones of Helm
+func mergeMap(existing, desired map[string]string) map[string]string {
+	res := make(map[string]string, len(existing)+len(desired))
+	for k, v := range existing {
+		res[k] = v
+	}
+	for k, v := range desired {
+		res[k] = v
+	}
+	return res
+}
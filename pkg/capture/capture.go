@@ -0,0 +1,146 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package capture captures the packets of some IPs on all the interfaces of the node into
+// a pcap file, it's used to debug the egress traffic of a policy.
+package capture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// DefaultSnapLen is the number of bytes of each packet kept by default
+	DefaultSnapLen = 262144
+
+	// readTimeout is how often the context is checked while no packet arrives
+	readTimeout = time.Second
+)
+
+// Config is the bounds and the filter of a capture
+type Config struct {
+	// IPs are the addresses to capture, a packet from or to any of them is captured
+	IPs []net.IP
+	// Duration is the longest time the capture runs
+	Duration time.Duration
+	// MaxPackets stops the capture once the number of captured packets reaches it
+	MaxPackets int
+	// SnapLen is the number of bytes of each packet kept, the default is DefaultSnapLen
+	SnapLen int
+}
+
+// Result is the outcome of a finished capture
+type Result struct {
+	Packets int
+}
+
+// Run captures the packets matching the config on all the interfaces of the node, and
+// writes them to w in the pcap format. It returns when the duration is elapsed, the max
+// number of packets is captured, or ctx is done. The packets are captured from the IP
+// header, so a packet forwarded by the node may be captured on each interface it passes.
+func Run(ctx context.Context, cfg Config, w io.Writer) (Result, error) {
+	res := Result{}
+	if len(cfg.IPs) == 0 {
+		return res, fmt.Errorf("no ip to capture")
+	}
+	if cfg.SnapLen <= 0 {
+		cfg.SnapLen = DefaultSnapLen
+	}
+	f := newFilter(cfg.IPs)
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return res, fmt.Errorf("failed to open packet socket: %w", err)
+	}
+	defer unix.Close(fd)
+	tv := unix.NsecToTimeval(readTimeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		return res, fmt.Errorf("failed to set read timeout of packet socket: %w", err)
+	}
+
+	pw, err := newPcapWriter(w, cfg.SnapLen)
+	if err != nil {
+		return res, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	buf := make([]byte, cfg.SnapLen)
+	for ctx.Err() == nil && (cfg.MaxPackets <= 0 || res.Packets < cfg.MaxPackets) {
+		// the length of the whole packet is returned with MSG_TRUNC
+		n, _, err := unix.Recvfrom(fd, buf, unix.MSG_TRUNC)
+		if err != nil {
+			if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return res, fmt.Errorf("failed to read packet socket: %w", err)
+		}
+		data := buf[:min(n, len(buf))]
+		if !f.match(data) {
+			continue
+		}
+		if err := pw.writePacket(time.Now(), data, n); err != nil {
+			return res, err
+		}
+		res.Packets++
+	}
+	return res, nil
+}
+
+// filter matches the IPv4 and IPv6 packets from or to the IPs
+type filter map[[16]byte]struct{}
+
+func newFilter(ips []net.IP) filter {
+	f := make(filter, len(ips))
+	for _, ip := range ips {
+		if ip16 := ip.To16(); ip16 != nil {
+			f[[16]byte(ip16)] = struct{}{}
+		}
+	}
+	return f
+}
+
+func (f filter) match(packet []byte) bool {
+	if len(packet) == 0 {
+		return false
+	}
+	var src, dst net.IP
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) < 20 {
+			return false
+		}
+		src, dst = net.IP(packet[12:16]).To16(), net.IP(packet[16:20]).To16()
+	case 6:
+		if len(packet) < 40 {
+			return false
+		}
+		src, dst = net.IP(packet[8:24]), net.IP(packet[24:40])
+	default:
+		return false
+	}
+	_, ok := f[[16]byte(src)]
+	if !ok {
+		_, ok = f[[16]byte(dst)]
+	}
+	return ok
+}
+
+func htons(i uint16) uint16 {
+	return i<<8 | i>>8
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,67 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ipv4Packet(src, dst string) []byte {
+	packet := make([]byte, 28)
+	packet[0] = 0x45
+	copy(packet[12:16], net.ParseIP(src).To4())
+	copy(packet[16:20], net.ParseIP(dst).To4())
+	return packet
+}
+
+func ipv6Packet(src, dst string) []byte {
+	packet := make([]byte, 48)
+	packet[0] = 0x60
+	copy(packet[8:24], net.ParseIP(src))
+	copy(packet[24:40], net.ParseIP(dst))
+	return packet
+}
+
+func TestFilter(t *testing.T) {
+	f := newFilter([]net.IP{net.ParseIP("10.6.1.21"), net.ParseIP("fd00::21")})
+
+	assert.True(t, f.match(ipv4Packet("10.6.1.21", "10.6.2.1")))
+	assert.True(t, f.match(ipv4Packet("10.6.2.1", "10.6.1.21")))
+	assert.False(t, f.match(ipv4Packet("10.6.2.1", "10.6.2.2")))
+	assert.True(t, f.match(ipv6Packet("fd00::21", "fd00::1")))
+	assert.True(t, f.match(ipv6Packet("fd00::1", "fd00::21")))
+	assert.False(t, f.match(ipv6Packet("fd00::1", "fd00::2")))
+
+	// truncated or not IP
+	assert.False(t, f.match(nil))
+	assert.False(t, f.match(ipv4Packet("10.6.1.21", "10.6.2.1")[:16]))
+	assert.False(t, f.match([]byte{0x08, 0x06, 0x00, 0x01}))
+}
+
+func TestPcapWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, err := newPcapWriter(buf, 20)
+	assert.NoError(t, err)
+	assert.Equal(t, 24, buf.Len())
+	assert.Equal(t, uint32(pcapMagic), binary.LittleEndian.Uint32(buf.Bytes()[0:4]))
+	assert.Equal(t, uint32(linkTypeRaw), binary.LittleEndian.Uint32(buf.Bytes()[20:24]))
+
+	ts := time.Unix(1700000000, 5000)
+	packet := ipv4Packet("10.6.1.21", "10.6.2.1")
+	assert.NoError(t, w.writePacket(ts, packet, 100))
+
+	record := buf.Bytes()[24:]
+	assert.Equal(t, uint32(1700000000), binary.LittleEndian.Uint32(record[0:4]))
+	assert.Equal(t, uint32(5), binary.LittleEndian.Uint32(record[4:8]))
+	// truncated to the snap length
+	assert.Equal(t, uint32(20), binary.LittleEndian.Uint32(record[8:12]))
+	assert.Equal(t, uint32(100), binary.LittleEndian.Uint32(record[12:16]))
+	assert.Equal(t, packet[:20], record[16:])
+}
@@ -0,0 +1,54 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+const (
+	pcapMagic        = 0xa1b2c3d4
+	pcapVersionMajor = 2
+	pcapVersionMinor = 4
+	// linkTypeRaw is the link type of the packets beginning with the IPv4 or IPv6 header
+	linkTypeRaw = 101
+)
+
+// pcapWriter writes the packets in the pcap format, which is read by tcpdump and wireshark
+type pcapWriter struct {
+	w       io.Writer
+	snapLen int
+}
+
+func newPcapWriter(w io.Writer, snapLen int) (*pcapWriter, error) {
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:4], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:6], pcapVersionMajor)
+	binary.LittleEndian.PutUint16(hdr[6:8], pcapVersionMinor)
+	binary.LittleEndian.PutUint32(hdr[16:20], uint32(snapLen))
+	binary.LittleEndian.PutUint32(hdr[20:24], linkTypeRaw)
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return &pcapWriter{w: w, snapLen: snapLen}, nil
+}
+
+// writePacket writes the packet received at ts, whose length on the wire is origLen
+func (p *pcapWriter) writePacket(ts time.Time, data []byte, origLen int) error {
+	if len(data) > p.snapLen {
+		data = data[:p.snapLen]
+	}
+	hdr := make([]byte, 16)
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:8], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(hdr[8:12], uint32(len(data)))
+	binary.LittleEndian.PutUint32(hdr[12:16], uint32(origLen))
+	if _, err := p.w.Write(hdr); err != nil {
+		return err
+	}
+	_, err := p.w.Write(data)
+	return err
+}
@@ -23,6 +23,7 @@ package coalescing
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -49,9 +50,13 @@ type (
 
 	// reconciler is the caching reconciler middleware that uses the cache.
 	reconciler struct {
+		name     string
 		upstream reconcile.Reconciler
 		cache    ReconcileCacher
 		log      logr.Logger
+
+		// pending is the requests which are deferred to the end of the window
+		pending sync.Map
 	}
 )
 
@@ -82,8 +87,10 @@ func (cache *ReconcileCache) Reconciled(key string) {
 // NewReconciler returns a reconcile wrapper that will delay new reconcile.Requests
 // after the cache expiry of the request string key.
 // A successful reconciliation is defined as one where no error is returned.
-func NewReconciler(upstream reconcile.Reconciler, cache ReconcileCacher, log logr.Logger) reconcile.Reconciler {
+// The name is the controller name used as the label of the metrics.
+func NewReconciler(name string, upstream reconcile.Reconciler, cache ReconcileCacher, log logr.Logger) reconcile.Reconciler {
 	return &reconciler{
+		name:     name,
 		upstream: upstream,
 		cache:    cache,
 		log:      log,
@@ -100,8 +107,15 @@ func (rc *reconciler) Reconcile(ctx context.Context, r reconcile.Request) (recon
 		if requeueAfter < 1*time.Second {
 			requeueAfter = 1 * time.Second
 		}
+		counterCoalesced.WithLabelValues(rc.name).Inc()
+		if _, loaded := rc.pending.LoadOrStore(r.String(), struct{}{}); !loaded {
+			gaugePending.WithLabelValues(rc.name).Inc()
+		}
 		return reconcile.Result{RequeueAfter: requeueAfter}, nil
 	}
+	if _, loaded := rc.pending.LoadAndDelete(r.String()); loaded {
+		gaugePending.WithLabelValues(rc.name).Dec()
+	}
 
 	log.V(4).Info("processing")
 	result, err := rc.upstream.Reconcile(ctx, r)
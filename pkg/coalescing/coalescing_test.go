@@ -0,0 +1,59 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package coalescing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+type countReconciler struct {
+	count int
+}
+
+func (r *countReconciler) Reconcile(_ context.Context, _ reconcile.Request) (reconcile.Result, error) {
+	r.count++
+	return reconcile.Result{}, nil
+}
+
+func pendingValue(t *testing.T, name string) float64 {
+	m := new(dto.Metric)
+	assert.NoError(t, gaugePending.WithLabelValues(name).Write(m))
+	return m.GetGauge().GetValue()
+}
+
+func TestReconciler(t *testing.T) {
+	cache, err := NewRequestCache(time.Minute)
+	assert.NoError(t, err)
+	upstream := new(countReconciler)
+	r := NewReconciler("test", upstream, cache, logr.Discard())
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "p1"}}
+	ctx := context.Background()
+
+	res, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, reconcile.Result{}, res)
+	assert.Equal(t, 1, upstream.count)
+
+	// the requests in the window are deferred and marked pending once
+	for i := 0; i < 3; i++ {
+		res, err = r.Reconcile(ctx, req)
+		assert.NoError(t, err)
+		assert.Greater(t, res.RequeueAfter, time.Duration(0))
+	}
+	assert.Equal(t, 1, upstream.count)
+	assert.Equal(t, float64(1), pendingValue(t, "test"))
+
+	// other keys are not limited
+	_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "p2"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, upstream.count)
+}
@@ -0,0 +1,24 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package coalescing
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	counterCoalesced = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "egress_reconcile_coalesced_total",
+		Help: "Number of reconcile requests deferred because the key was reconciled within the window",
+	}, []string{"controller"})
+	gaugePending = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "egress_reconcile_pending",
+		Help: "Number of keys marked dirty and waiting for the end of the window to be reconciled",
+	}, []string{"controller"})
+)
+
+func MetricCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		counterCoalesced,
+		gaugePending,
+	}
+}
@@ -6,15 +6,16 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net"
+	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
-	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/yaml.v3"
 	"k8s.io/client-go/rest"
@@ -22,6 +23,7 @@ import (
 
 	"github.com/spidernet-io/egressgateway/pkg/iptables"
 	"github.com/spidernet-io/egressgateway/pkg/logger"
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
 )
 
 type Config struct {
@@ -58,6 +60,11 @@ type EnvConfig struct {
 	GolangMaxProcs            int32         `mapstructure:"GOLANG_MAX_PROCS"`
 	TLSCertDir                string        `mapstructure:"TLS_CERT_DIR"`
 	ConfigMapPath             string        `mapstructure:"CONFIGMAP_PATH"`
+	HelperSocket              string        `mapstructure:"HELPER_SOCKET"`
+	Bootstrap                 bool          `mapstructure:"BOOTSTRAP"`
+	DevMode                   bool          `mapstructure:"DEV_MODE"`
+	WebhookService            string        `mapstructure:"WEBHOOK_SERVICE"`
+	WebhookSecret             string        `mapstructure:"WEBHOOK_SECRET"`
 	UseDevMode                bool          `mapstructure:"LOG_USE_DEV_MODE"`
 	Level                     string        `mapstructure:"LOG_LEVEL"`
 	WithCaller                bool          `mapstructure:"LOG_WITH_CALLER"`
@@ -66,41 +73,646 @@ type EnvConfig struct {
 }
 
 type FileConfig struct {
-	EnableIPv4                   bool            `yaml:"enableIPv4"`
-	EnableIPv6                   bool            `yaml:"enableIPv6"`
-	IPTables                     IPTables        `yaml:"iptables"`
-	DatapathMode                 string          `yaml:"datapathMode"`
-	TunnelIpv4Subnet             string          `yaml:"tunnelIpv4Subnet"`
-	TunnelIpv6Subnet             string          `yaml:"tunnelIpv6Subnet"`
-	TunnelIPv4Net                *net.IPNet      `json:"-"`
-	TunnelIPv6Net                *net.IPNet      `json:"-"`
-	TunnelDetectMethod           string          `yaml:"tunnelDetectMethod"`
-	VXLAN                        VXLAN           `yaml:"vxlan"`
-	MaxNumberEndpointPerSlice    int             `yaml:"maxNumberEndpointPerSlice"`
-	Mark                         string          `yaml:"mark"`
+	EnableIPv4                bool              `yaml:"enableIPv4"`
+	EnableIPv6                bool              `yaml:"enableIPv6"`
+	IPTables                  IPTables          `yaml:"iptables"`
+	DatapathMode              string            `yaml:"datapathMode"`
+	TunnelIpv4Subnet          string            `yaml:"tunnelIpv4Subnet"`
+	TunnelIpv6Subnet          string            `yaml:"tunnelIpv6Subnet"`
+	TunnelIPv4Net             *net.IPNet        `json:"-"`
+	TunnelIPv6Net             *net.IPNet        `json:"-"`
+	TunnelRenumberGracePeriod int               `yaml:"tunnelRenumberGracePeriod"`
+	PolicyCleanupTimeout      int               `yaml:"policyCleanupTimeout"`
+	TunnelDetectMethod        string            `yaml:"tunnelDetectMethod"`
+	VXLAN                     VXLAN             `yaml:"vxlan"`
+	MaxNumberEndpointPerSlice int               `yaml:"maxNumberEndpointPerSlice"`
+	EndpointReconcile         EndpointReconcile `yaml:"endpointReconcile"`
+	Mark                      string            `yaml:"mark"`
+	// MarkMask is the bits numbering the marks of the gateway nodes, it's the trailing
+	// zero hex digits of the Mark if it's empty
+	MarkMask                     string          `yaml:"markMask"`
 	AnnouncedInterfacesToExclude []string        `yaml:"announcedInterfacesToExclude"`
 	AnnounceExcludeRegexp        *regexp.Regexp  `json:"-"`
 	EnableGatewayReplyRoute      bool            `yaml:"enableGatewayReplyRoute"`
 	GatewayReplyRouteTable       int             `yaml:"gatewayReplyRouteTable"`
 	GatewayReplyRouteMark        int             `yaml:"gatewayReplyRouteMark"`
+	RouteTable                   RouteTable      `yaml:"routeTable"`
 	GatewayFailover              GatewayFailover `yaml:"gatewayFailover"`
+	// PodLabelSelector limits the Pods cached by the controller, the Pods which don't
+	// match it are never selected by the policies
+	PodLabelSelector string `yaml:"podLabelSelector"`
+	// EnableGatewayColocation enables the webhook which adds the node affinity of the
+	// gateway nodes to the Pods labeled with prefer-colocate-with-egress-gateway
+	EnableGatewayColocation bool `yaml:"enableGatewayColocation"`
+	// EnableDatapathReadyCondition makes the agent publish the DatapathReady condition of
+	// its node once the datapath converged, and set it on the Pods of the node which have
+	// the readiness gate of it. The EIPs are only placed on the nodes whose DatapathReady
+	// condition is True.
+	EnableDatapathReadyCondition bool `yaml:"enableDatapathReadyCondition"`
+	// StrictDatapath makes the agent fail the reconciles on the partial failures of programming
+	// the datapath, which are retried with backoff, and mark the tunnel of the node Failed until
+	// the datapath is fully programmed
+	StrictDatapath bool `yaml:"strictDatapath"`
+	// NeighborTable raises the gc thresholds of the neighbor tables of the node when the
+	// entries of the tunnel peers overflow them
+	NeighborTable NeighborTable `yaml:"neighborTable"`
+	// FlushConntrackOnEIPChange deletes the conntrack entries SNATed to the previous EIP
+	// of a policy on the gateway node, when the EIP of the policy is changed or moved
+	FlushConntrackOnEIPChange bool `yaml:"flushConntrackOnEIPChange"`
+	// MultiCluster shares the EgressGateways with other clusters
+	MultiCluster MultiCluster `yaml:"multiCluster"`
+	// BFD runs BFD sessions with the switches on the nodes holding egress IPs
+	BFD BFD `yaml:"bfd"`
+	// Capture runs the packet captures requested by the capture annotation of the policies
+	Capture Capture `yaml:"capture"`
+	// PolicyCounters samples the counters of the rules of the policies on the node
+	PolicyCounters PolicyCounters `yaml:"policyCounters"`
+	// ConnectionLog logs the new connections of the policies with spec.logging enabled on
+	// their gateway nodes
+	ConnectionLog ConnectionLog `yaml:"connectionLog"`
+	// NATMappingExport records the SNAT mappings of the policies requiring it on their
+	// gateway nodes
+	NATMappingExport NATMappingExport `yaml:"natMappingExport"`
+	// DatapathRecord records the datapath programmed on the node in the EgressNodeDatapath
+	DatapathRecord DatapathRecord `yaml:"datapathRecord"`
+	// DestinationProviders are the external sources of the destination CIDRs, which the
+	// policies refer to by name in spec.destSubnetFrom
+	DestinationProviders []DestinationProvider `yaml:"destinationProviders"`
+	// EIPHooks are invoked by the gateway controller before the EIPs are activated on the
+	// nodes and after they are deactivated
+	EIPHooks []EIPHook `yaml:"eipHooks"`
+	// DNS publishes the DNS records of the annotated policies pointing at their EIPs
+	DNS DNS `yaml:"dns"`
+	// EIPVerification verifies the EIPs claimed by the gateway nodes by an external service
+	// before the policies turn Ready
+	EIPVerification EIPVerification `yaml:"eipVerification"`
+	// ProxyProtocol relays the TCP connections of the policies with spec.proxyProtocol on
+	// their gateway nodes, prepending the PROXY protocol v2 header
+	ProxyProtocol ProxyProtocol `yaml:"proxyProtocol"`
+	// L7Proxy proxies the HTTP and SOCKS5 proxy requests of the policies with spec.l7Proxy
+	// on their gateway nodes from the EIPs
+	L7Proxy L7Proxy `yaml:"l7Proxy"`
+	// Istio marks the connections the Istio proxies of the node originate from the Pod IPs,
+	// and never marks the mesh traffic
+	Istio Istio `yaml:"istio"`
+	// BypassCIDRs extend the destinations never forwarded to the gateway nodes, which are
+	// always the link-local, the multicast and the node addresses
+	BypassCIDRs []string `yaml:"bypassCIDRs"`
+	// MetadataProtection keeps the traffic to the metadata services of the clouds off the
+	// gateway nodes
+	MetadataProtection MetadataProtection `yaml:"metadataProtection"`
+	// FailClosed drops the traffic of the policies which would egress with the node IP
+	// while the datapath is not programmed, e.g. during the restarts of the agent
+	FailClosed FailClosed `yaml:"failClosed"`
+	// BootPersistence persists the fail-closed rules on the host, so they're restored on
+	// the boot of the node before the agent starts
+	BootPersistence BootPersistence `yaml:"bootPersistence"`
+	// Hairpin handles the connections from the cluster to the EIPs
+	Hairpin Hairpin `yaml:"hairpin"`
+	// GeoIP compiles the GeoIP selectors of spec.destSubnetFrom of the policies
+	GeoIP GeoIP `yaml:"geoIP"`
+	// EnableDestinationService resolves the Services in spec.destSubnetFrom of the policies
+	// to their external endpoints, which watches the Services and the EndpointSlices
+	EnableDestinationService bool `yaml:"enableDestinationService"`
+	// EnableNetworkPolicyCheck reports the policies whose selected Pods can't reach the
+	// gateway because of the NetworkPolicies and the CiliumNetworkPolicies
+	EnableNetworkPolicyCheck bool `yaml:"enableNetworkPolicyCheck"`
+	// EIPBindings exports the EIPs of the policies and the namespaces using them to a
+	// ConfigMap for the external automation
+	EIPBindings EIPBindings `yaml:"eipBindings"`
+	// LogLevels is the log levels of modules, such as `agent.vxlan: debug`
+	LogLevels map[string]string `yaml:"logLevels"`
+	// TLS is the TLS settings of the webhook server and the metrics servers
+	TLS TLS `yaml:"tls"`
+	// Auth authenticates and authorizes the clients of the debug endpoints
+	Auth Auth `yaml:"auth"`
+	// Debug is the runtime diagnostics served on the metrics port
+	Debug Debug `yaml:"debug"`
+	// StatusEndpoint serves the JSON summary of the gateways on the metrics port of the
+	// controller
+	StatusEndpoint StatusEndpoint `yaml:"statusEndpoint"`
+	// Reconcilers is the concurrency and the rate limiter of the reconcilers of the
+	// controller by their names, such as `egressGateway` and `endpoint`
+	Reconcilers map[string]Reconciler `yaml:"reconcilers"`
 }
 
 type GatewayFailover struct {
-	Enable              bool `yaml:"enable"`
-	TunnelMonitorPeriod int  `yaml:"tunnelMonitorPeriod"`
-	TunnelUpdatePeriod  int  `yaml:"tunnelUpdatePeriod"`
-	EipEvictionTimeout  int  `yaml:"eipEvictionTimeout"`
+	Enable              bool          `yaml:"enable"`
+	TunnelMonitorPeriod int           `yaml:"tunnelMonitorPeriod"`
+	TunnelUpdatePeriod  int           `yaml:"tunnelUpdatePeriod"`
+	EipEvictionTimeout  int           `yaml:"eipEvictionTimeout"`
+	TunnelProbe         TunnelProbe   `yaml:"tunnelProbe"`
+	UpstreamProbe       UpstreamProbe `yaml:"upstreamProbe"`
+	// TunnelStatusMinIntervalMillis is the min interval between the updates of the tunnel
+	// status by the agent, the changes in the interval are coalesced, and it's the base of
+	// the backoff of the failed updates. TunnelStatusJitterPercent is the percent of the
+	// random jitter of the updates.
+	TunnelStatusMinIntervalMillis int `yaml:"tunnelStatusMinIntervalMillis"`
+	TunnelStatusJitterPercent     int `yaml:"tunnelStatusJitterPercent"`
+	// ReturnPathProbe checks the replies from the gateway nodes come back through the tunnel
+	ReturnPathProbe ReturnPathProbe `yaml:"returnPathProbe"`
+	Cordon          Cordon          `yaml:"cordon"`
+	// HistorySize is the number of the last failover transitions kept in the status of
+	// the EgressGateways, 0 disables the history
+	HistorySize int `yaml:"historySize"`
+	// FlapDamping holds down the gateway nodes whose EIPs flap
+	FlapDamping FlapDamping `yaml:"flapDamping"`
+	// WarmStandby programs the EIPs on their standby nodes ahead of the failover
+	WarmStandby WarmStandby `yaml:"warmStandby"`
 }
 
+// MultiCluster imports the EgressGateways and EgressTunnels of the remote clusters, and
+// exports the policies using the imported EgressGateways to the remote clusters.
+type MultiCluster struct {
+	Enable             bool            `yaml:"enable"`
+	ClusterName        string          `yaml:"clusterName"`
+	SyncIntervalSecond int             `yaml:"syncIntervalSecond"`
+	Clusters           []RemoteCluster `yaml:"clusters"`
+}
+
+// RemoteCluster is a remote cluster, KubeconfigSecret is the name of the Secret in the
+// namespace of the controller, whose `kubeconfig` key is the kubeconfig of the cluster.
+// PeerAddress is the source of the underlay address of the imported EgressTunnels.
+type RemoteCluster struct {
+	Name             string `yaml:"name"`
+	KubeconfigSecret string `yaml:"kubeconfigSecret"`
+	PeerAddress      string `yaml:"peerAddress"`
+}
+
+const (
+	// PeerAddressNode uses the node IPs, the nodes of the clusters are in the same L2 domain
+	PeerAddressNode = "node"
+	// PeerAddressSubmariner uses the CNI interface IPs of the nodes annotated by Submariner,
+	// the VXLAN traffic is forwarded through the Submariner tunnel
+	PeerAddressSubmariner = "submariner"
+	// PeerAddressCiliumClusterMesh uses the CiliumInternalIPs of the nodes, the VXLAN
+	// traffic is forwarded through the Cilium cluster mesh
+	PeerAddressCiliumClusterMesh = "ciliumClusterMesh"
+)
+
+// BFD is the BFD sessions of the agent with the switches, the sessions are AdminDown
+// unless the node holds egress IPs, so the switches withdraw the routes of the EIPs as
+// soon as the node dies or loses them.
+type BFD struct {
+	Enable              bool     `yaml:"enable"`
+	Peers               []string `yaml:"peers"`
+	DesiredMinTxMillis  int      `yaml:"desiredMinTxMillis"`
+	RequiredMinRxMillis int      `yaml:"requiredMinRxMillis"`
+	DetectMultiplier    int      `yaml:"detectMultiplier"`
+}
+
+// Capture is the bounds of the packet captures of the policies, the pcap files are
+// written to Dir, which is usually the mount point of a hostPath or a PVC.
+type Capture struct {
+	Enable            bool   `yaml:"enable"`
+	Dir               string `yaml:"dir"`
+	MaxDurationSecond int    `yaml:"maxDurationSecond"`
+	MaxPackets        int    `yaml:"maxPackets"`
+	SnapLen           int    `yaml:"snapLen"`
+}
+
+// ConnectionLog sends the new connections of the policies with spec.logging enabled to the
+// NFLOG group on their gateway nodes, the agent collects them from the group into the
+// structured logs. SnapLen is the number of bytes of each packet copied to the agent, which
+// should cover the IP and the transport headers.
+type ConnectionLog struct {
+	Enable  bool   `yaml:"enable"`
+	Group   uint16 `yaml:"group"`
+	SnapLen int    `yaml:"snapLen"`
+}
+
+// NATMappingExport records the SNAT mappings of the connections of the policies labeled with
+// egressgateway.spidernet.io/nat-mapping-export on their gateway nodes, sampled from the
+// conntrack table every IntervalSecond. The records are written to the files in Dir, which
+// are rotated once they reach MaxFileSizeMB, and the rotated ones beyond MaxFiles or older
+// than MaxAgeHours are removed, 0 MaxAgeHours keeps them regardless of their age.
+type NATMappingExport struct {
+	Enable         bool   `yaml:"enable"`
+	IntervalSecond int    `yaml:"intervalSecond"`
+	Dir            string `yaml:"dir"`
+	MaxFileSizeMB  int    `yaml:"maxFileSizeMB"`
+	MaxFiles       int    `yaml:"maxFiles"`
+	MaxAgeHours    int    `yaml:"maxAgeHours"`
+}
+
+// PolicyCounters exports the counters of the rules of the policies on the node as the
+// metrics, and records them in the status of the policies every IntervalSecond.
+type PolicyCounters struct {
+	Enable         bool `yaml:"enable"`
+	IntervalSecond int  `yaml:"intervalSecond"`
+}
+
+// DatapathRecord records the normalized datapath programmed by the agent in the
+// EgressNodeDatapath of the node every IntervalSecond, the summary of each section is
+// limited to MaxSummaryLines lines.
+type DatapathRecord struct {
+	Enable          bool `yaml:"enable"`
+	IntervalSecond  int  `yaml:"intervalSecond"`
+	MaxSummaryLines int  `yaml:"maxSummaryLines"`
+}
+
+// EIPBindings is the ConfigMap in the namespace of the controller, whose `bindings.json`
+// key summarizes the policies, their EIPs and the namespaces in a stable schema.
+type EIPBindings struct {
+	Enable    bool   `yaml:"enable"`
+	ConfigMap string `yaml:"configMap"`
+}
+
+// GeoIP is the MaxMind DB files in Dir, such as GeoLite2-Country.mmdb (or a City database)
+// and GeoLite2-ASN.mmdb, which are kept up to date by geoipupdate or other means. The files
+// are checked every CheckIntervalSecond, and the selectors are compiled again once changed.
+type GeoIP struct {
+	Enable              bool   `yaml:"enable"`
+	Dir                 string `yaml:"dir"`
+	CountryDatabase     string `yaml:"countryDatabase"`
+	ASNDatabase         string `yaml:"asnDatabase"`
+	CheckIntervalSecond int    `yaml:"checkIntervalSecond"`
+}
+
+// CountryPath returns the path of the country database, or empty if it's not set
+func (g GeoIP) CountryPath() string {
+	return g.path(g.CountryDatabase)
+}
+
+// ASNPath returns the path of the ASN database, or empty if it's not set
+func (g GeoIP) ASNPath() string {
+	return g.path(g.ASNDatabase)
+}
+
+func (g GeoIP) path(name string) string {
+	if name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(g.Dir, name)
+}
+
+// DestinationProvider fetches the destination CIDRs from URL every RefreshIntervalSecond,
+// such as the IP ranges published by the cloud providers. The CIDRs are parsed by Format:
+//   - text: the CIDRs or IPs separated by spaces, commas or lines, `#` starts a comment
+//   - json: the CIDR or IP strings in the JSON document. If Filter is set, only the ones
+//     in the objects whose fields equal the values of Filter are used, such as
+//     `service: S3` for https://ip-ranges.amazonaws.com/ip-ranges.json
+type DestinationProvider struct {
+	Name                  string            `yaml:"name"`
+	Type                  string            `yaml:"type"`
+	URL                   string            `yaml:"url"`
+	Format                string            `yaml:"format"`
+	Filter                map[string]string `yaml:"filter"`
+	RefreshIntervalSecond int               `yaml:"refreshIntervalSecond"`
+}
+
+// DNS publishes the A and AAAA records of the names in the `dns-name` annotation of the
+// policies, which point at the EIPs of the policies, by the DNSEndpoints of external-dns.
+// RecordTTL is the default TTL of the records in seconds.
+type DNS struct {
+	Enable    bool `yaml:"enable"`
+	RecordTTL int  `yaml:"recordTTL"`
+}
+
+// EIPVerification probes a verification service from the gateway node with each EIP the
+// node claims as the source, the policies of the EIP are only Ready after the service
+// observes the EIP, so the upstream SNAT rewriting the EIPs is not silently ignored. The
+// URL returns the source IP of the request in the body, e.g. https://ifconfig.me/ip. The
+// probes are marked from the Mark to be SNATed with the EIPs, the low 8 bits number the
+// EIPs probed in a round.
+type EIPVerification struct {
+	Enable         bool   `yaml:"enable"`
+	URL            string `yaml:"url"`
+	IntervalSecond int    `yaml:"intervalSecond"`
+	TimeoutSecond  int    `yaml:"timeoutSecond"`
+	Mark           string `yaml:"mark"`
+}
+
+// ProxyProtocol relays the TCP connections of the policies with spec.proxyProtocol to their
+// destination ports by the agent of the gateway node, which prepends the PROXY protocol v2
+// header carrying the Pod IP. The connections are redirected to the Port of the agent, and
+// the relayed ones are marked from the Mark to be SNATed with the EIPs, the low 8 bits
+// number the EIPs of the relayed policies on the node.
+type ProxyProtocol struct {
+	Enable               bool   `yaml:"enable"`
+	Port                 int    `yaml:"port"`
+	Mark                 string `yaml:"mark"`
+	ConnectTimeoutSecond int    `yaml:"connectTimeoutSecond"`
+}
+
+// L7Proxy proxies the TCP connections of the policies with spec.l7Proxy to their destination
+// ports by the agent of the gateway node, which serves the HTTP CONNECT, the HTTP forward and
+// the SOCKS5 requests, and connects to the requested targets from the EIPs of the policies.
+// The connections are diverted to the Port of the agent by TPROXY with the Mark, which is
+// routed to the local host by the RouteTable, so are the replies to the EIPs.
+type L7Proxy struct {
+	Enable                 bool   `yaml:"enable"`
+	Port                   int    `yaml:"port"`
+	Mark                   string `yaml:"mark"`
+	RouteTable             int    `yaml:"routeTable"`
+	ConnectTimeoutSecond   int    `yaml:"connectTimeoutSecond"`
+	HandshakeTimeoutSecond int    `yaml:"handshakeTimeoutSecond"`
+}
+
+// Istio is the compatibility with the Istio sidecars and the ambient mode. The connections of
+// the policies the node proxies (ztunnel) originate from the Pod IPs never pass PREROUTING,
+// so the mangle OUTPUT jumps to the rules of the policies as well. The jump is appended to
+// follow ISTIO_OUTPUT, whose CONNMARK --restore-mark overwrites the marks set before it. The
+// traffic to the MeshPorts (HBONE) is between the proxies and never marked, neither are the
+// connections redirected to the RedirectPort (15001 of the sidecars) if it's not 0.
+type Istio struct {
+	Enable       bool  `yaml:"enable"`
+	MeshPorts    []int `yaml:"meshPorts"`
+	RedirectPort int   `yaml:"redirectPort"`
+}
+
+// MetadataProtection guarantees the traffic to the metadata services of the clouds is never
+// forwarded to the gateway nodes or SNATed with the EIPs, e.g. the IMDSv2 responses of EKS
+// are limited to 1 hop by default, so they're dropped once they pass the gateway nodes. The
+// link-local metadata services, such as 169.254.169.254, are always bypassed by the agent, so
+// it only controls the CIDRs out of the link-local ranges.
+type MetadataProtection struct {
+	Enable bool     `yaml:"enable"`
+	CIDRs  []string `yaml:"cidrs"`
+}
+
+// FailClosed keeps the rules dropping the traffic of the policies unless the path to the
+// gateway nodes is programmed. They're restored before any other activity of the agent as
+// it starts, and held until the rules of the policies are applied.
+type FailClosed struct {
+	Enable bool `yaml:"enable"`
+}
+
+// BootPersistence writes the fail-closed rules holding the traffic from the sources of the
+// policies, except the critical exclusions, i.e. the cluster CIDRs and the bypassed
+// destinations, into the iptables-restore and ipset snippets in Dir, along with the systemd
+// unit in SystemdDir restoring them on the boot of the node before kubelet starts. The
+// files are managed by the agent, and removed once it's disabled.
+type BootPersistence struct {
+	Enable     bool   `yaml:"enable"`
+	Dir        string `yaml:"dir"`
+	SystemdDir string `yaml:"systemdDir"`
+}
+
+// NeighborTable handles the overflow of the neighbor tables of the node, the kernel refuses
+// the new entries of the tunnel peers once gc_thresh3 is reached. If AutoTune is enabled,
+// gc_thresh2 and gc_thresh3 are doubled up to MaxGCThresh3, otherwise the overflow is only
+// reported by the NeighborTable condition of the EgressTunnel.
+type NeighborTable struct {
+	AutoTune     bool `yaml:"autoTune"`
+	MaxGCThresh3 int  `yaml:"maxGCThresh3"`
+}
+
+// Hairpin handles the connections from the Pods of the cluster to the EIPs, e.g. the
+// callbacks of the webhooks to the EIP of the policy. The EIPs are not assigned to any
+// interface, so the connections are blackholed if they're left as they are.
+type Hairpin struct {
+	Mode           string `yaml:"mode"`
+	DNATTargetIPv4 string `yaml:"dnatTargetIPv4"`
+	DNATTargetIPv6 string `yaml:"dnatTargetIPv6"`
+}
+
+const (
+	HairpinDisabled = "Disabled"
+	HairpinReject   = "Reject"
+	HairpinDNAT     = "DNAT"
+)
+
+// Behavior describes how the connections to the EIPs are handled in the mode
+func (h Hairpin) Behavior() string {
+	switch h.Mode {
+	case HairpinReject:
+		return "the connections to the EIPs are rejected on the nodes of the clients"
+	case HairpinDNAT:
+		return "the connections to the EIPs are translated to dnatTargetIPv4 and dnatTargetIPv6 on the nodes of the clients"
+	}
+	return "the connections to the EIPs are forwarded as the other egress traffic, and may be blackholed"
+}
+
+// EIPHook is a webhook or a command invoked before an EIP is activated on a node and after
+// it's deactivated, e.g. to update an external firewall or DNS. The webhook is POSTed the
+// JSON of the event, and the command is run with the event in the environment variables.
+// The failed preActivate hook defers the activation if the FailurePolicy is Fail, the EIP
+// is activated anyway if it's Ignore.
+type EIPHook struct {
+	Name          string   `yaml:"name"`
+	Type          string   `yaml:"type"`
+	URL           string   `yaml:"url"`
+	Command       []string `yaml:"command"`
+	Events        []string `yaml:"events"`
+	TimeoutSecond int      `yaml:"timeoutSecond"`
+	FailurePolicy string   `yaml:"failurePolicy"`
+}
+
+const (
+	EIPHookWebhook = "webhook"
+	EIPHookExec    = "exec"
+
+	EIPHookPreActivate    = "preActivate"
+	EIPHookPostDeactivate = "postDeactivate"
+
+	EIPHookFailurePolicyFail   = "Fail"
+	EIPHookFailurePolicyIgnore = "Ignore"
+)
+
+const (
+	// DestinationProviderHTTP fetches the CIDRs by HTTP GET, which also serves the
+	// objects of S3 and the other object storages by their HTTPS URLs
+	DestinationProviderHTTP = "http"
+
+	DestinationFormatText = "text"
+	DestinationFormatJSON = "json"
+)
+
+// TLS is the TLS settings of the webhook server of the controller and the metrics
+// servers of the controller and the agent. The certificate of the servers is re-read
+// once its files in TLSCertDir are changed, so the rotated certificate is served
+// without restarting.
+type TLS struct {
+	// MinVersion is the minimum TLS version, `VersionTLS12` or `VersionTLS13`
+	MinVersion string `yaml:"minVersion"`
+	// CipherSuites is the IANA names of the TLS 1.2 cipher suites, the Go defaults are
+	// used if it's empty. The cipher suites of TLS 1.3 are not configurable.
+	CipherSuites []string `yaml:"cipherSuites"`
+	// SecureMetrics serves the metrics over HTTPS, the metrics servers without the
+	// certificate in TLSCertDir use a self-signed one
+	SecureMetrics bool `yaml:"secureMetrics"`
+
+	MinVersionID   uint16   `json:"-"`
+	CipherSuiteIDs []uint16 `json:"-"`
+}
+
+const (
+	AuthModeNone        = ""
+	AuthModeMTLS        = "mtls"
+	AuthModeTokenReview = "tokenReview"
+)
+
+// Auth authenticates the clients of the debug endpoints of the controller and the agent,
+// such as /loglevel, and allows the AllowedIdentities only. The identity of a client is
+//   - the SPIFFE ID in the URI SAN of its certificate verified by ClientCAFile in the
+//     `mtls` mode, such as spiffe://cluster.local/ns/default/sa/admin
+//   - the username of its bearer token reviewed by the API server in the `tokenReview`
+//     mode, such as system:serviceaccount:default:admin
+//
+// An identity ending with `*` allows the identities with the prefix. The debug endpoints
+// are not served if the mode is empty.
+type Auth struct {
+	Mode              string   `yaml:"mode"`
+	ClientCAFile      string   `yaml:"clientCAFile"`
+	AllowedIdentities []string `yaml:"allowedIdentities"`
+}
+
+// Debug is the runtime diagnostics of the controller and the agent, which are served on
+// the metrics port along with the other debug endpoints
+type Debug struct {
+	// Pprof serves the pprof profiles, the expvar variables and the goroutine dump
+	Pprof bool `yaml:"pprof"`
+}
+
+// StatusEndpoint serves the read-only JSON summary of the gateways, the EIPs, the active
+// nodes and the recent failovers at /status. The failovers are kept in memory, at most
+// HistorySize of them in the last HistoryRetentionSecond.
+type StatusEndpoint struct {
+	Enable                 bool `yaml:"enable"`
+	HistorySize            int  `yaml:"historySize"`
+	HistoryRetentionSecond int  `yaml:"historyRetentionSecond"`
+}
+
+// EndpointReconcile limits the reconciliation of endpoint slices, the Pod events of a
+// policy in the interval are aggregated into one reconciliation. If Standalone is true,
+// the endpoint slices are reconciled by the endpoint-controller instead of the
+// controller, which caches no Pod then.
+type EndpointReconcile struct {
+	MinIntervalMillis int           `yaml:"minIntervalMillis"`
+	Workers           int           `yaml:"workers"`
+	Standalone        bool          `yaml:"standalone"`
+	Audit             EndpointAudit `yaml:"audit"`
+}
+
+// EndpointAudit checks SampleSize policies every IntervalSecond in turn, and reconciles
+// the endpoint slices of a policy again if they drift from the Pods. The standalone
+// endpoint-controller doesn't resync its cache periodically when it's enabled.
+type EndpointAudit struct {
+	Enable         bool `yaml:"enable"`
+	IntervalSecond int  `yaml:"intervalSecond"`
+	SampleSize     int  `yaml:"sampleSize"`
+}
+
+// Reconciler is the concurrency and the rate limiter of the workqueue of a reconciler, the
+// zero values keep the defaults.
+type Reconciler struct {
+	MaxConcurrentReconciles int         `yaml:"maxConcurrentReconciles"`
+	RateLimiter             RateLimiter `yaml:"rateLimiter"`
+}
+
+// RateLimiter delays the failed requests exponentially from BaseDelayMillis to
+// MaxDelaySecond, and limits all the requeued requests by the token bucket of QPS and
+// Burst.
+type RateLimiter struct {
+	BaseDelayMillis int     `yaml:"baseDelayMillis"`
+	MaxDelaySecond  int     `yaml:"maxDelaySecond"`
+	QPS             float64 `yaml:"qps"`
+	Burst           int     `yaml:"burst"`
+}
+
+type TunnelProbe struct {
+	Enable        bool `yaml:"enable"`
+	Port          int  `yaml:"port"`
+	Count         int  `yaml:"count"`
+	TimeoutMillis int  `yaml:"timeoutMillis"`
+}
+
+// UpstreamProbe probes the next hops of the default routes of the nodes by ARP or NDP
+type UpstreamProbe struct {
+	Enable        bool `yaml:"enable"`
+	Count         int  `yaml:"count"`
+	TimeoutMillis int  `yaml:"timeoutMillis"`
+}
+
+// ReturnPathProbe probes the gateway nodes holding EIPs through the tunnel, the gateway nodes
+// report whether the probes arrive and are replied through the tunnel, the node checks whether
+// the replies come back through the tunnel
+type ReturnPathProbe struct {
+	Enable        bool `yaml:"enable"`
+	Port          int  `yaml:"port"`
+	Count         int  `yaml:"count"`
+	TimeoutMillis int  `yaml:"timeoutMillis"`
+}
+
+// Cordon treats the cordoned or drained gateway nodes as failed, no new egress IP is
+// placed on them, and the egress IPs they hold are moved to other nodes once they have
+// been cordoned for GracePeriod seconds.
+type Cordon struct {
+	Enable      bool `yaml:"enable"`
+	GracePeriod int  `yaml:"gracePeriod"`
+	// Taints is the keys of the taints marking the nodes being drained, besides the
+	// unschedulable nodes
+	Taints []string `yaml:"taints"`
+}
+
+// FlapDamping holds down a gateway node from hosting new egress IPs once its egress IPs
+// are moved away Threshold times in WindowSecond because of its health. The hold down
+// starts from HoldDownSecond, and doubles up to MaxHoldDownSecond each time the node is
+// damped again before it has been stable for MaxHoldDownSecond.
+type FlapDamping struct {
+	Enable            bool `yaml:"enable"`
+	WindowSecond      int  `yaml:"windowSecond"`
+	Threshold         int  `yaml:"threshold"`
+	HoldDownSecond    int  `yaml:"holdDownSecond"`
+	MaxHoldDownSecond int  `yaml:"maxHoldDownSecond"`
+}
+
+// WarmStandby places every EIP on a standby gateway node besides its gateway node. The agent
+// of the standby node programs the SNAT rules of the EIP, which only match the traffic of the
+// policies sent to the node, and reports them in the status of the EgressGateway. On the
+// failover, the EIP is moved to its standby node first once it's programmed, so only the
+// announcement of the EIP and the routes of the other nodes are changed.
+type WarmStandby struct {
+	Enable bool `yaml:"enable"`
+}
+
+// RouteTable is the routing tables of the policy routing to the gateway nodes
+type RouteTable struct {
+	// Base is the table of the first mark, the tables of the gateway nodes are numbered
+	// by their marks from it. 0 uses the marks as the tables.
+	Base int `yaml:"base"`
+	// OnCollision is the action when a table is used by the other tools, the agent fails
+	// to start with refuse, or moves the table away with renumber
+	OnCollision string `yaml:"onCollision"`
+}
+
+const (
+	RouteTableCollisionRefuse   = "refuse"
+	RouteTableCollisionRenumber = "renumber"
+)
+
 const TunnelInterfaceDefaultRoute = "defaultRouteInterface"
 const TunnelInterfaceSpecific = "interface="
 
+// TunnelInterfaceMAC pins the tunnel interface by the MAC address, the permanent MAC
+// address of a bond slave is matched as well
+const TunnelInterfaceMAC = "mac="
+
+// TunnelInterfacePCI pins the tunnel interface by the PCI address of the device
+const TunnelInterfacePCI = "pci="
+
+var pciAddressRegexp = regexp.MustCompile(`^[0-9a-fA-F]{4}:[0-9a-fA-F]{2}:[0-9a-fA-F]{2}\.[0-7]$`)
+
 type VXLAN struct {
-	Name                   string `yaml:"name"`
-	ID                     int    `yaml:"id"`
-	Port                   int    `yaml:"port"`
-	DisableChecksumOffload bool   `yaml:"disableChecksumOffload"`
+	Name                     string `yaml:"name"`
+	ID                       int    `yaml:"id"`
+	Port                     int    `yaml:"port"`
+	SrcPortLow               int    `yaml:"srcPortLow"`
+	SrcPortHigh              int    `yaml:"srcPortHigh"`
+	DisableChecksumOffload   bool   `yaml:"disableChecksumOffload"`
+	DisableRXChecksumOffload bool   `yaml:"disableRXChecksumOffload"`
+	DisableGRO               bool   `yaml:"disableGRO"`
+	DisableGSO               bool   `yaml:"disableGSO"`
+	// TOS is the ToS byte of the outer header of the encapsulated packets, 0 leaves it
+	// unset, and 1 inherits the ToS of the inner packets
+	TOS int `yaml:"tos"`
+	// Priority is the skb priority of the packets sent through the tunnel, which is
+	// mapped to the queues and the VLAN priorities of the underlay, 0 leaves it unchanged
+	Priority uint32 `yaml:"priority"`
+	// PeerConcurrency is the number of the peers whose neighbor and fdb entries are
+	// programmed at the same time
+	PeerConcurrency int `yaml:"peerConcurrency"`
 }
 
 type IPTables struct {
@@ -112,6 +724,9 @@ type IPTables struct {
 	InitialPostWriteIntervalSecond int    `yaml:"initialPostWriteIntervalSecond"`
 	RestoreSupportsLock            bool   `yaml:"restoreSupportsLock"`
 	LockFilePath                   string `yaml:"lockFilePath"`
+	// BatchWindowMillis is the window in which the rules regenerated by the changes of the
+	// gateways and the policies are batched into one restore, 0 applies each change at once
+	BatchWindowMillis int `yaml:"batchWindowMillis"`
 }
 
 type AutoDetect struct {
@@ -145,25 +760,10 @@ func LoadConfig(isAgent bool) (*Config, error) {
 			WebhookPort:               8881,
 			GolangMaxProcs:            -1,
 			TLSCertDir:                "/etc/tls",
+			WebhookService:            "egressgateway-controller",
+			WebhookSecret:             "egressgateway-controller-server-certs",
 		},
-		FileConfig: FileConfig{
-			MaxNumberEndpointPerSlice: 100,
-			IPTables: IPTables{
-				RefreshIntervalSecond:   90,
-				PostWriteIntervalSecond: 1,
-				LockTimeoutSecond:       0,
-				LockProbeIntervalMillis: 50,
-				LockFilePath:            "/run/xtables.lock",
-				RestoreSupportsLock:     restoreSupportsLock,
-			},
-			Mark: "0x26000000",
-			GatewayFailover: GatewayFailover{
-				Enable:              true,
-				TunnelMonitorPeriod: 5,
-				TunnelUpdatePeriod:  5,
-				EipEvictionTimeout:  15,
-			},
-		},
+		FileConfig: defaultFileConfig(restoreSupportsLock),
 	}
 
 	// map environment variables to struct objects
@@ -188,22 +788,8 @@ func LoadConfig(isAgent bool) (*Config, error) {
 		if nil != err {
 			return nil, fmt.Errorf("failed to read ConfigMap file %v, error: %w", config.ConfigMapPath, err)
 		}
-		if err := yaml.Unmarshal(configmapBytes, &config.FileConfig); nil != err {
-			return nil, fmt.Errorf("failed to parse ConfigMap data, error: %w", err)
-		}
-		if config.FileConfig.EnableIPv4 {
-			_, ipn, err := net.ParseCIDR(config.FileConfig.TunnelIpv4Subnet)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse TunnelIpv4Subnet: %w", err)
-			}
-			config.FileConfig.TunnelIPv4Net = ipn
-		}
-		if config.FileConfig.EnableIPv6 {
-			_, ipn, err := net.ParseCIDR(config.FileConfig.TunnelIpv6Subnet)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse TunnelIpv6Subnet: %w", err)
-			}
-			config.FileConfig.TunnelIPv6Net = ipn
+		if err := parseFileConfig(configmapBytes, &config.FileConfig); err != nil {
+			return nil, err
 		}
 	}
 
@@ -216,19 +802,20 @@ func LoadConfig(isAgent bool) (*Config, error) {
 		WithCaller: config.WithCaller,
 		Encoder:    config.Encoder,
 	}
-	level, err := strconv.ParseInt(config.Level, 10, 8)
+	config.Logger.Level, err = logger.ParseLevel(config.Level)
 	if err != nil {
-		level, err := zap.ParseAtomicLevel(config.Level)
-		if err != nil {
-			return config, err
+		return config, err
+	}
+	config.Logger.ModuleLevels = make(map[string]zapcore.Level)
+	for module, text := range config.FileConfig.LogLevels {
+		if !logger.IsModule(module) {
+			return nil, fmt.Errorf("unknown log module %s, the modules are %s", module, strings.Join(logger.Modules, ", "))
 		}
-		config.Logger.Level = level.Level()
-	} else {
-		// compatible with zap, the minimum is the maximum log level
-		if level > 0 {
-			level = 0 - level
+		level, err := logger.ParseLevel(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level of module %s: %w", module, err)
 		}
-		config.Logger.Level = zapcore.Level(level)
+		config.Logger.ModuleLevels[module] = level
 	}
 
 	list := config.FileConfig.AnnouncedInterfacesToExclude
@@ -247,13 +834,800 @@ func LoadConfig(isAgent bool) (*Config, error) {
 	}
 
 	// validate config
-	if config.FileConfig.GatewayFailover.Enable {
-		if config.FileConfig.GatewayFailover.EipEvictionTimeout <
-			(config.FileConfig.GatewayFailover.TunnelUpdatePeriod +
-				config.FileConfig.GatewayFailover.TunnelMonitorPeriod) {
-			return nil, fmt.Errorf("eipEvictionTimeout should be greater than the sum of tunnelUpdatePeriod and tunnelMonitorPeriod")
-		}
+	if err := validateFileConfig(&config.FileConfig); err != nil {
+		return nil, err
 	}
 
 	return config, nil
 }
+
+// defaultFileConfig returns the defaults of the file config, the file overwrites them
+func defaultFileConfig(restoreSupportsLock bool) FileConfig {
+	return FileConfig{
+		MaxNumberEndpointPerSlice: 100,
+		EndpointReconcile: EndpointReconcile{
+			MinIntervalMillis: 1000,
+			Workers:           2,
+			Audit: EndpointAudit{
+				IntervalSecond: 60,
+				SampleSize:     10,
+			},
+		},
+		MultiCluster: MultiCluster{
+			SyncIntervalSecond: 10,
+		},
+		TLS: TLS{
+			MinVersion: "VersionTLS12",
+		},
+		BFD: BFD{
+			DesiredMinTxMillis:  300,
+			RequiredMinRxMillis: 300,
+			DetectMultiplier:    3,
+		},
+		VXLAN: VXLAN{
+			PeerConcurrency: 16,
+		},
+		Capture: Capture{
+			Dir:               "/var/lib/egressgateway/capture",
+			MaxDurationSecond: 300,
+			MaxPackets:        100000,
+			SnapLen:           262144,
+		},
+		PolicyCounters: PolicyCounters{
+			IntervalSecond: 60,
+		},
+		ConnectionLog: ConnectionLog{
+			Group:   100,
+			SnapLen: 128,
+		},
+		NATMappingExport: NATMappingExport{
+			IntervalSecond: 5,
+			Dir:            "/var/log/egressgateway/nat-mappings",
+			MaxFileSizeMB:  100,
+			MaxFiles:       10,
+			MaxAgeHours:    168,
+		},
+		DatapathRecord: DatapathRecord{
+			IntervalSecond:  60,
+			MaxSummaryLines: 200,
+		},
+		GeoIP: GeoIP{
+			Dir:                 "/var/lib/egressgateway/geoip",
+			CheckIntervalSecond: 60,
+		},
+		EIPBindings: EIPBindings{
+			ConfigMap: "egressgateway-eip-bindings",
+		},
+		StatusEndpoint: StatusEndpoint{
+			HistorySize:            100,
+			HistoryRetentionSecond: 86400,
+		},
+		DNS:     DNS{RecordTTL: 60},
+		Hairpin: Hairpin{Mode: HairpinDisabled},
+		NeighborTable: NeighborTable{
+			AutoTune:     true,
+			MaxGCThresh3: 16384,
+		},
+		MetadataProtection: MetadataProtection{
+			Enable: true,
+			CIDRs:  []string{"fd00:ec2::254/128"},
+		},
+		BootPersistence: BootPersistence{
+			Dir:        "/var/lib/egressgateway",
+			SystemdDir: "/etc/systemd/system",
+		},
+		EIPVerification: EIPVerification{
+			IntervalSecond: 30,
+			TimeoutSecond:  5,
+			Mark:           "0x27000000",
+		},
+		ProxyProtocol: ProxyProtocol{
+			Port:                 15100,
+			Mark:                 "0x28000000",
+			ConnectTimeoutSecond: 10,
+		},
+		L7Proxy: L7Proxy{
+			Port:                   15200,
+			Mark:                   "0x29000000",
+			RouteTable:             610,
+			ConnectTimeoutSecond:   10,
+			HandshakeTimeoutSecond: 10,
+		},
+		Istio: Istio{
+			MeshPorts: []int{15008},
+		},
+		IPTables: IPTables{
+			RefreshIntervalSecond:   90,
+			PostWriteIntervalSecond: 1,
+			LockTimeoutSecond:       0,
+			LockProbeIntervalMillis: 50,
+			LockFilePath:            "/run/xtables.lock",
+			RestoreSupportsLock:     restoreSupportsLock,
+			BatchWindowMillis:       200,
+		},
+		Mark:                      "0x26000000",
+		RouteTable:                RouteTable{OnCollision: RouteTableCollisionRenumber},
+		TunnelRenumberGracePeriod: 300,
+		PolicyCleanupTimeout:      600,
+		GatewayFailover: GatewayFailover{
+			Enable:              true,
+			TunnelMonitorPeriod: 5,
+			TunnelUpdatePeriod:  5,
+			EipEvictionTimeout:  15,
+			HistorySize:         20,
+
+			TunnelStatusMinIntervalMillis: 1000,
+			TunnelStatusJitterPercent:     10,
+			TunnelProbe: TunnelProbe{
+				Enable:        false,
+				Port:          7790,
+				Count:         3,
+				TimeoutMillis: 1000,
+			},
+			UpstreamProbe: UpstreamProbe{
+				Enable:        false,
+				Count:         3,
+				TimeoutMillis: 1000,
+			},
+			ReturnPathProbe: ReturnPathProbe{
+				Enable:        false,
+				Port:          7791,
+				Count:         3,
+				TimeoutMillis: 1000,
+			},
+			FlapDamping: FlapDamping{
+				WindowSecond:      300,
+				Threshold:         3,
+				HoldDownSecond:    60,
+				MaxHoldDownSecond: 960,
+			},
+			Cordon: Cordon{
+				GracePeriod: 60,
+				Taints: []string{
+					"node.kubernetes.io/unschedulable",
+					"ToBeDeletedByClusterAutoscaler",
+				},
+			},
+		},
+	}
+}
+
+// parseFileConfig parses the file config from the ConfigMap data over the defaults
+func parseFileConfig(data []byte, fc *FileConfig) error {
+	if err := yaml.Unmarshal(data, fc); nil != err {
+		return fmt.Errorf("failed to parse ConfigMap data, error: %w", err)
+	}
+	if fc.EnableIPv4 {
+		_, ipn, err := net.ParseCIDR(fc.TunnelIpv4Subnet)
+		if err != nil {
+			return fmt.Errorf("failed to parse TunnelIpv4Subnet: %w", err)
+		}
+		fc.TunnelIPv4Net = ipn
+	}
+	if fc.EnableIPv6 {
+		_, ipn, err := net.ParseCIDR(fc.TunnelIpv6Subnet)
+		if err != nil {
+			return fmt.Errorf("failed to parse TunnelIpv6Subnet: %w", err)
+		}
+		fc.TunnelIPv6Net = ipn
+	}
+	return nil
+}
+
+// validateFileConfig validates the file config, and sets the defaults depending on the others
+func validateFileConfig(fc *FileConfig) error {
+	if fc.TunnelRenumberGracePeriod < 0 {
+		return fmt.Errorf("tunnelRenumberGracePeriod should not be less than 0")
+	}
+	if fc.PolicyCleanupTimeout < 0 {
+		return fmt.Errorf("policyCleanupTimeout should not be less than 0")
+	}
+
+	if fc.EndpointReconcile.MinIntervalMillis <= 0 || fc.EndpointReconcile.Workers <= 0 {
+		return fmt.Errorf("endpointReconcile minIntervalMillis and workers should be greater than 0")
+	}
+
+	if audit := fc.EndpointReconcile.Audit; audit.Enable && (audit.IntervalSecond <= 0 || audit.SampleSize <= 0) {
+		return fmt.Errorf("endpointReconcile audit intervalSecond and sampleSize should be greater than 0")
+	}
+
+	if mc := fc.MultiCluster; mc.Enable {
+		if mc.ClusterName == "" {
+			return fmt.Errorf("multiCluster clusterName should not be empty")
+		}
+		if mc.SyncIntervalSecond <= 0 {
+			return fmt.Errorf("multiCluster syncIntervalSecond should be greater than 0")
+		}
+		names := make(map[string]struct{})
+		for _, item := range mc.Clusters {
+			if item.Name == "" || item.KubeconfigSecret == "" {
+				return fmt.Errorf("multiCluster cluster name and kubeconfigSecret should not be empty")
+			}
+			switch item.PeerAddress {
+			case "", PeerAddressNode, PeerAddressSubmariner, PeerAddressCiliumClusterMesh:
+			default:
+				return fmt.Errorf("invalid multiCluster peerAddress %s of cluster %s", item.PeerAddress, item.Name)
+			}
+			if _, ok := names[item.Name]; ok || item.Name == mc.ClusterName {
+				return fmt.Errorf("duplicated multiCluster cluster name %s", item.Name)
+			}
+			names[item.Name] = struct{}{}
+		}
+	}
+
+	if bfd := fc.BFD; bfd.Enable {
+		if len(bfd.Peers) == 0 {
+			return fmt.Errorf("bfd peers should not be empty")
+		}
+		for _, peer := range bfd.Peers {
+			if net.ParseIP(peer) == nil {
+				return fmt.Errorf("invalid bfd peer %s", peer)
+			}
+		}
+		if bfd.DesiredMinTxMillis <= 0 || bfd.RequiredMinRxMillis <= 0 {
+			return fmt.Errorf("bfd desiredMinTxMillis and requiredMinRxMillis should be greater than 0")
+		}
+		if bfd.DetectMultiplier <= 0 || bfd.DetectMultiplier > 255 {
+			return fmt.Errorf("bfd detectMultiplier should be in [1, 255]")
+		}
+	}
+
+	if capture := fc.Capture; capture.Enable {
+		if capture.Dir == "" {
+			return fmt.Errorf("capture dir should not be empty")
+		}
+		if capture.MaxDurationSecond <= 0 || capture.MaxPackets <= 0 || capture.SnapLen <= 0 {
+			return fmt.Errorf("capture maxDurationSecond, maxPackets and snapLen should be greater than 0")
+		}
+	}
+
+	if connLog := fc.ConnectionLog; connLog.Enable && (connLog.Group == 0 || connLog.SnapLen < 60) {
+		return fmt.Errorf("connectionLog group should be greater than 0, and snapLen should not be less than 60")
+	}
+
+	if err := validateNATMappingExport(fc.NATMappingExport); err != nil {
+		return err
+	}
+
+	if counters := fc.PolicyCounters; counters.Enable && counters.IntervalSecond <= 0 {
+		return fmt.Errorf("policyCounters intervalSecond should be greater than 0")
+	}
+	if record := fc.DatapathRecord; record.Enable && (record.IntervalSecond <= 0 || record.MaxSummaryLines < 0) {
+		return fmt.Errorf("datapathRecord intervalSecond should be greater than 0, and maxSummaryLines should not be less than 0")
+	}
+
+	if err := parseDestinationProviders(fc.DestinationProviders); err != nil {
+		return err
+	}
+
+	if err := parseEIPHooks(fc.EIPHooks); err != nil {
+		return err
+	}
+
+	if dns := fc.DNS; dns.Enable && dns.RecordTTL <= 0 {
+		return fmt.Errorf("dns recordTTL should be greater than 0")
+	}
+
+	if err := validateEIPVerification(fc.EIPVerification, fc.Mark, fc.MarkMask); err != nil {
+		return err
+	}
+
+	if err := validateProxyProtocol(fc.ProxyProtocol, fc.EIPVerification, fc.Mark, fc.MarkMask); err != nil {
+		return err
+	}
+
+	if err := validateL7Proxy(fc); err != nil {
+		return err
+	}
+
+	if err := validateIstio(fc.Istio); err != nil {
+		return err
+	}
+
+	if err := validateHairpin(fc.Hairpin); err != nil {
+		return err
+	}
+
+	if err := validateBypassCIDRs(fc.BypassCIDRs); err != nil {
+		return err
+	}
+
+	if err := validateMetadataProtection(fc.MetadataProtection); err != nil {
+		return err
+	}
+
+	if err := validateBootPersistence(fc.BootPersistence, fc.FailClosed); err != nil {
+		return err
+	}
+
+	if neigh := fc.NeighborTable; neigh.AutoTune && neigh.MaxGCThresh3 <= 0 {
+		return fmt.Errorf("neighborTable maxGCThresh3 should be greater than 0")
+	}
+
+	if geoIP := fc.GeoIP; geoIP.Enable {
+		if geoIP.CountryDatabase == "" && geoIP.ASNDatabase == "" {
+			return fmt.Errorf("geoIP requires at least one of countryDatabase and asnDatabase")
+		}
+		if geoIP.CheckIntervalSecond <= 0 {
+			return fmt.Errorf("geoIP checkIntervalSecond should be greater than 0")
+		}
+	}
+
+	if bindings := fc.EIPBindings; bindings.Enable && bindings.ConfigMap == "" {
+		return fmt.Errorf("eipBindings configMap should not be empty")
+	}
+
+	if status := fc.StatusEndpoint; status.Enable && (status.HistorySize <= 0 || status.HistoryRetentionSecond <= 0) {
+		return fmt.Errorf("statusEndpoint historySize and historyRetentionSecond should be greater than 0")
+	}
+
+	if err := parseTLS(&fc.TLS); err != nil {
+		return err
+	}
+
+	switch auth := fc.Auth; auth.Mode {
+	case AuthModeNone:
+		if fc.Debug.Pprof || fc.StatusEndpoint.Enable {
+			return fmt.Errorf("debug pprof and statusEndpoint require the auth mode")
+		}
+	case AuthModeMTLS:
+		if auth.ClientCAFile == "" {
+			return fmt.Errorf("auth clientCAFile should not be empty in mtls mode")
+		}
+		if !fc.TLS.SecureMetrics {
+			return fmt.Errorf("auth mtls mode requires tls secureMetrics")
+		}
+		fallthrough
+	case AuthModeTokenReview:
+		if len(auth.AllowedIdentities) == 0 {
+			return fmt.Errorf("auth allowedIdentities should not be empty")
+		}
+	default:
+		return fmt.Errorf("invalid auth mode %s", auth.Mode)
+	}
+
+	if tos := fc.VXLAN.TOS; tos < 0 || tos > 255 {
+		return fmt.Errorf("invalid vxlan tos %d", tos)
+	}
+	if fc.VXLAN.PeerConcurrency <= 0 {
+		return fmt.Errorf("vxlan peerConcurrency should be greater than 0")
+	}
+	if fc.IPTables.BatchWindowMillis < 0 {
+		return fmt.Errorf("invalid iptables batchWindowMillis %d", fc.IPTables.BatchWindowMillis)
+	}
+
+	if _, err := markallocator.NewSpace(fc.Mark, fc.MarkMask); err != nil {
+		return err
+	}
+
+	switch table := fc.RouteTable; {
+	case table.Base != 0 && table.Base < 256:
+		return fmt.Errorf("routeTable base should be 0 or not less than 256")
+	case table.OnCollision != RouteTableCollisionRefuse && table.OnCollision != RouteTableCollisionRenumber:
+		return fmt.Errorf("invalid routeTable onCollision %s", table.OnCollision)
+	}
+
+	if err := validateTunnelDetectMethod(fc.TunnelDetectMethod); err != nil {
+		return err
+	}
+
+	low, high := fc.VXLAN.SrcPortLow, fc.VXLAN.SrcPortHigh
+	if low != 0 || high != 0 {
+		if low <= 0 || high > 65535 || low > high {
+			return fmt.Errorf("invalid vxlan source port range %d-%d", low, high)
+		}
+	}
+
+	for name, item := range fc.Reconcilers {
+		limiter := item.RateLimiter
+		if item.MaxConcurrentReconciles < 0 || limiter.BaseDelayMillis < 0 || limiter.MaxDelaySecond < 0 ||
+			limiter.QPS < 0 || limiter.Burst < 0 {
+			return fmt.Errorf("the settings of reconciler %s should not be negative", name)
+		}
+	}
+
+	if fc.GatewayFailover.HistorySize < 0 {
+		return fmt.Errorf("gatewayFailover historySize should not be negative")
+	}
+
+	if fc.GatewayFailover.TunnelStatusMinIntervalMillis < 0 {
+		return fmt.Errorf("gatewayFailover tunnelStatusMinIntervalMillis should not be negative")
+	}
+	if fc.GatewayFailover.TunnelStatusJitterPercent < 0 || fc.GatewayFailover.TunnelStatusJitterPercent > 50 {
+		return fmt.Errorf("gatewayFailover tunnelStatusJitterPercent should be between 0 and 50")
+	}
+
+	if fc.GatewayFailover.Enable {
+		if fc.GatewayFailover.EipEvictionTimeout <
+			(fc.GatewayFailover.TunnelUpdatePeriod +
+				fc.GatewayFailover.TunnelMonitorPeriod) {
+			return fmt.Errorf("eipEvictionTimeout should be greater than the sum of tunnelUpdatePeriod and tunnelMonitorPeriod")
+		}
+		probe := fc.GatewayFailover.TunnelProbe
+		if probe.Enable {
+			if probe.Port <= 0 || probe.Port > 65535 {
+				return fmt.Errorf("invalid tunnelProbe port %d", probe.Port)
+			}
+			if probe.Count <= 0 || probe.TimeoutMillis <= 0 {
+				return fmt.Errorf("tunnelProbe count and timeoutMillis should be greater than 0")
+			}
+			if probe.Count*probe.TimeoutMillis > fc.GatewayFailover.TunnelUpdatePeriod*1000 {
+				return fmt.Errorf("the product of tunnelProbe count and timeoutMillis should not be greater than tunnelUpdatePeriod")
+			}
+		}
+		upstream := fc.GatewayFailover.UpstreamProbe
+		if upstream.Enable {
+			if upstream.Count <= 0 || upstream.TimeoutMillis <= 0 {
+				return fmt.Errorf("upstreamProbe count and timeoutMillis should be greater than 0")
+			}
+			if upstream.Count*upstream.TimeoutMillis > fc.GatewayFailover.TunnelUpdatePeriod*1000 {
+				return fmt.Errorf("the product of upstreamProbe count and timeoutMillis should not be greater than tunnelUpdatePeriod")
+			}
+		}
+		returnPath := fc.GatewayFailover.ReturnPathProbe
+		if returnPath.Enable {
+			if returnPath.Port <= 0 || returnPath.Port > 65535 {
+				return fmt.Errorf("invalid returnPathProbe port %d", returnPath.Port)
+			}
+			if probe.Enable && returnPath.Port == probe.Port {
+				return fmt.Errorf("returnPathProbe port should be different from tunnelProbe port")
+			}
+			if returnPath.Count <= 0 || returnPath.TimeoutMillis <= 0 {
+				return fmt.Errorf("returnPathProbe count and timeoutMillis should be greater than 0")
+			}
+			if returnPath.Count*returnPath.TimeoutMillis > fc.GatewayFailover.TunnelUpdatePeriod*1000 {
+				return fmt.Errorf("the product of returnPathProbe count and timeoutMillis should not be greater than tunnelUpdatePeriod")
+			}
+		}
+	}
+	if damping := fc.GatewayFailover.FlapDamping; damping.Enable {
+		if damping.WindowSecond <= 0 || damping.Threshold <= 0 || damping.HoldDownSecond <= 0 {
+			return fmt.Errorf("gatewayFailover flapDamping windowSecond, threshold and holdDownSecond should be greater than 0")
+		}
+		if damping.MaxHoldDownSecond < damping.HoldDownSecond {
+			return fmt.Errorf("gatewayFailover flapDamping maxHoldDownSecond should not be less than holdDownSecond")
+		}
+	}
+	if fc.GatewayFailover.Cordon.GracePeriod < 0 {
+		return fmt.Errorf("gatewayFailover cordon gracePeriod should not be less than 0")
+	}
+
+	return nil
+}
+
+func validateTunnelDetectMethod(method string) error {
+	switch {
+	case method == "" || method == TunnelInterfaceDefaultRoute:
+	case strings.HasPrefix(method, TunnelInterfaceSpecific):
+		if method == TunnelInterfaceSpecific {
+			return fmt.Errorf("tunnelDetectMethod interface name should not be empty")
+		}
+	case strings.HasPrefix(method, TunnelInterfaceMAC):
+		if _, err := net.ParseMAC(strings.TrimPrefix(method, TunnelInterfaceMAC)); err != nil {
+			return fmt.Errorf("invalid tunnelDetectMethod mac address: %w", err)
+		}
+	case strings.HasPrefix(method, TunnelInterfacePCI):
+		if !pciAddressRegexp.MatchString(strings.TrimPrefix(method, TunnelInterfacePCI)) {
+			return fmt.Errorf("invalid tunnelDetectMethod pci address %s", strings.TrimPrefix(method, TunnelInterfacePCI))
+		}
+	default:
+		return fmt.Errorf("invalid tunnelDetectMethod %s", method)
+	}
+	return nil
+}
+
+// parseEIPHooks validates the EIP hooks, and sets the defaults
+func parseEIPHooks(hooks []EIPHook) error {
+	names := make(map[string]struct{})
+	for i := range hooks {
+		item := &hooks[i]
+		if item.Name == "" {
+			return fmt.Errorf("eipHooks name should not be empty")
+		}
+		if _, ok := names[item.Name]; ok {
+			return fmt.Errorf("duplicated eipHooks name %s", item.Name)
+		}
+		names[item.Name] = struct{}{}
+		switch item.Type {
+		case EIPHookWebhook:
+			if item.URL == "" {
+				return fmt.Errorf("eipHooks url of %s should not be empty", item.Name)
+			}
+		case EIPHookExec:
+			if len(item.Command) == 0 {
+				return fmt.Errorf("eipHooks command of %s should not be empty", item.Name)
+			}
+		default:
+			return fmt.Errorf("invalid eipHooks type %s of %s", item.Type, item.Name)
+		}
+		if len(item.Events) == 0 {
+			item.Events = []string{EIPHookPreActivate, EIPHookPostDeactivate}
+		}
+		for _, event := range item.Events {
+			if event != EIPHookPreActivate && event != EIPHookPostDeactivate {
+				return fmt.Errorf("invalid eipHooks event %s of %s", event, item.Name)
+			}
+		}
+		if item.TimeoutSecond == 0 {
+			item.TimeoutSecond = 10
+		}
+		if item.TimeoutSecond < 0 {
+			return fmt.Errorf("eipHooks timeoutSecond of %s should be greater than 0", item.Name)
+		}
+		switch item.FailurePolicy {
+		case "":
+			item.FailurePolicy = EIPHookFailurePolicyFail
+		case EIPHookFailurePolicyFail, EIPHookFailurePolicyIgnore:
+		default:
+			return fmt.Errorf("invalid eipHooks failurePolicy %s of %s", item.FailurePolicy, item.Name)
+		}
+	}
+	return nil
+}
+
+// parseDestinationProviders validates the destination providers, and sets the defaults
+func parseDestinationProviders(providers []DestinationProvider) error {
+	names := make(map[string]struct{})
+	for i := range providers {
+		item := &providers[i]
+		if item.Name == "" {
+			return fmt.Errorf("destinationProviders name should not be empty")
+		}
+		if _, ok := names[item.Name]; ok {
+			return fmt.Errorf("duplicated destinationProviders name %s", item.Name)
+		}
+		names[item.Name] = struct{}{}
+		if item.Type == "" {
+			item.Type = DestinationProviderHTTP
+		}
+		if item.Type != DestinationProviderHTTP {
+			return fmt.Errorf("invalid destinationProviders type %s of %s", item.Type, item.Name)
+		}
+		if item.URL == "" {
+			return fmt.Errorf("destinationProviders url of %s should not be empty", item.Name)
+		}
+		switch item.Format {
+		case "":
+			item.Format = DestinationFormatText
+		case DestinationFormatText, DestinationFormatJSON:
+		default:
+			return fmt.Errorf("invalid destinationProviders format %s of %s", item.Format, item.Name)
+		}
+		if item.RefreshIntervalSecond == 0 {
+			item.RefreshIntervalSecond = 3600
+		}
+		if item.RefreshIntervalSecond < 0 {
+			return fmt.Errorf("destinationProviders refreshIntervalSecond of %s should be greater than 0", item.Name)
+		}
+	}
+	return nil
+}
+
+// validateEIPVerification checks the service and the mark of the probes, the marks of the
+// probes must be out of the mark space of the gateway nodes
+func validateEIPVerification(v EIPVerification, mark, markMask string) error {
+	if !v.Enable {
+		return nil
+	}
+	u, err := url.Parse(v.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("eipVerification url should be a http or https url")
+	}
+	if v.IntervalSecond <= 0 {
+		return fmt.Errorf("eipVerification intervalSecond should be greater than 0")
+	}
+	if v.TimeoutSecond <= 0 {
+		return fmt.Errorf("eipVerification timeoutSecond should be greater than 0")
+	}
+	base, err := markallocator.Parse(v.Mark)
+	if err != nil || base == 0 || base > math.MaxUint32 {
+		return fmt.Errorf("invalid eipVerification mark %q", v.Mark)
+	}
+	if base&0xff != 0 {
+		return fmt.Errorf("the low 8 bits of eipVerification mark %s should be 0", v.Mark)
+	}
+	space, err := markallocator.NewSpace(mark, markMask)
+	if err != nil {
+		return err
+	}
+	if space.InRange(int(base)) || space.InRange(int(base|0xff)) {
+		return fmt.Errorf("eipVerification mark %s should not overlap the mark %s", v.Mark, mark)
+	}
+	return nil
+}
+
+// validateProxyProtocol checks the port of the relay and the mark of the relayed connections,
+// the marks must be out of the mark space of the gateway nodes and the probes of the EIP
+// verification
+func validateProxyProtocol(p ProxyProtocol, verification EIPVerification, mark, markMask string) error {
+	if !p.Enable {
+		return nil
+	}
+	if p.Port <= 0 || p.Port > 65535 {
+		return fmt.Errorf("invalid proxyProtocol port %d", p.Port)
+	}
+	if p.ConnectTimeoutSecond <= 0 {
+		return fmt.Errorf("proxyProtocol connectTimeoutSecond should be greater than 0")
+	}
+	base, err := markallocator.Parse(p.Mark)
+	if err != nil || base == 0 || base > math.MaxUint32 {
+		return fmt.Errorf("invalid proxyProtocol mark %q", p.Mark)
+	}
+	if base&0xff != 0 {
+		return fmt.Errorf("the low 8 bits of proxyProtocol mark %s should be 0", p.Mark)
+	}
+	space, err := markallocator.NewSpace(mark, markMask)
+	if err != nil {
+		return err
+	}
+	if space.InRange(int(base)) || space.InRange(int(base|0xff)) {
+		return fmt.Errorf("proxyProtocol mark %s should not overlap the mark %s", p.Mark, mark)
+	}
+	if verification.Enable {
+		if verifyMark, err := markallocator.Parse(verification.Mark); err == nil && verifyMark&^0xff == base {
+			return fmt.Errorf("proxyProtocol mark %s should not overlap the eipVerification mark %s", p.Mark, verification.Mark)
+		}
+	}
+	return nil
+}
+
+// validateL7Proxy checks the port and the timeouts of the proxy, the mark of the diverted
+// connections should not overlap the other marks of the agent, and the route table should
+// not be the gateway reply route table
+func validateL7Proxy(fc *FileConfig) error {
+	p := fc.L7Proxy
+	if !p.Enable {
+		return nil
+	}
+	if p.Port <= 0 || p.Port > 65535 {
+		return fmt.Errorf("invalid l7Proxy port %d", p.Port)
+	}
+	if fc.ProxyProtocol.Enable && p.Port == fc.ProxyProtocol.Port {
+		return fmt.Errorf("l7Proxy port %d should not be the proxyProtocol port", p.Port)
+	}
+	if p.ConnectTimeoutSecond <= 0 {
+		return fmt.Errorf("l7Proxy connectTimeoutSecond should be greater than 0")
+	}
+	if p.HandshakeTimeoutSecond <= 0 {
+		return fmt.Errorf("l7Proxy handshakeTimeoutSecond should be greater than 0")
+	}
+	if p.RouteTable < 256 {
+		return fmt.Errorf("l7Proxy routeTable should not be less than 256")
+	}
+	if fc.EnableGatewayReplyRoute && p.RouteTable == fc.GatewayReplyRouteTable {
+		return fmt.Errorf("l7Proxy routeTable %d should not be the gatewayReplyRouteTable", p.RouteTable)
+	}
+	mark, err := markallocator.Parse(p.Mark)
+	if err != nil || mark == 0 || mark > math.MaxUint32 {
+		return fmt.Errorf("invalid l7Proxy mark %q", p.Mark)
+	}
+	space, err := markallocator.NewSpace(fc.Mark, fc.MarkMask)
+	if err != nil {
+		return err
+	}
+	if space.InRange(int(mark)) {
+		return fmt.Errorf("l7Proxy mark %s should not overlap the mark %s", p.Mark, fc.Mark)
+	}
+	for _, other := range []struct {
+		name   string
+		enable bool
+		mark   string
+	}{
+		{"eipVerification", fc.EIPVerification.Enable, fc.EIPVerification.Mark},
+		{"proxyProtocol", fc.ProxyProtocol.Enable, fc.ProxyProtocol.Mark},
+	} {
+		if base, err := markallocator.Parse(other.mark); other.enable && err == nil && mark&^0xff == base {
+			return fmt.Errorf("l7Proxy mark %s should not overlap the %s mark %s", p.Mark, other.name, other.mark)
+		}
+	}
+	return nil
+}
+
+// validateIstio checks the ports, the mesh ports are matched by one multiport match which
+// takes 15 ports at most
+func validateIstio(conf Istio) error {
+	if !conf.Enable {
+		return nil
+	}
+	if len(conf.MeshPorts) > 15 {
+		return fmt.Errorf("istio meshPorts should not have more than 15 ports")
+	}
+	for _, port := range conf.MeshPorts {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid istio meshPorts port %d", port)
+		}
+	}
+	if conf.RedirectPort < 0 || conf.RedirectPort > 65535 {
+		return fmt.Errorf("invalid istio redirectPort %d", conf.RedirectPort)
+	}
+	return nil
+}
+
+// validateHairpin checks the mode and the DNAT targets of the hairpin, the messages tell
+// the behaviors of the modes
+func validateHairpin(h Hairpin) error {
+	switch h.Mode {
+	case "", HairpinDisabled, HairpinReject:
+		return nil
+	case HairpinDNAT:
+	default:
+		return fmt.Errorf("invalid hairpin mode %q, it should be %s: %s; %s: %s; or %s: %s", h.Mode,
+			HairpinDisabled, Hairpin{Mode: HairpinDisabled}.Behavior(),
+			HairpinReject, Hairpin{Mode: HairpinReject}.Behavior(),
+			HairpinDNAT, Hairpin{Mode: HairpinDNAT}.Behavior())
+	}
+	if h.DNATTargetIPv4 == "" && h.DNATTargetIPv6 == "" {
+		return fmt.Errorf("hairpin mode %s requires dnatTargetIPv4 or dnatTargetIPv6, %s", h.Mode, h.Behavior())
+	}
+	if ip := net.ParseIP(h.DNATTargetIPv4); h.DNATTargetIPv4 != "" && (ip == nil || ip.To4() == nil) {
+		return fmt.Errorf("hairpin dnatTargetIPv4 %q should be an IPv4 address", h.DNATTargetIPv4)
+	}
+	if ip := net.ParseIP(h.DNATTargetIPv6); h.DNATTargetIPv6 != "" && (ip == nil || ip.To4() != nil) {
+		return fmt.Errorf("hairpin dnatTargetIPv6 %q should be an IPv6 address", h.DNATTargetIPv6)
+	}
+	return nil
+}
+
+// validateBypassCIDRs checks the CIDRs extending the built-in bypass
+func validateBypassCIDRs(cidrs []string) error {
+	for _, item := range cidrs {
+		if _, _, err := net.ParseCIDR(item); err != nil {
+			return fmt.Errorf("invalid bypassCIDRs %s, they extend the built-in bypass of the link-local, multicast and node addresses: %w", item, err)
+		}
+	}
+	return nil
+}
+
+// validateMetadataProtection checks the CIDRs of the metadata services if it's enabled
+func validateMetadataProtection(m MetadataProtection) error {
+	if !m.Enable {
+		return nil
+	}
+	if len(m.CIDRs) == 0 {
+		return fmt.Errorf("metadataProtection requires the cidrs of the metadata services")
+	}
+	for _, item := range m.CIDRs {
+		if _, _, err := net.ParseCIDR(item); err != nil {
+			return fmt.Errorf("invalid metadataProtection cidr %s: %w", item, err)
+		}
+	}
+	return nil
+}
+
+// validateBootPersistence checks the directories of the files on the host, and the
+// persisted rules are the fail-closed ones
+func validateBootPersistence(b BootPersistence, failClosed FailClosed) error {
+	if !b.Enable {
+		return nil
+	}
+	if !failClosed.Enable {
+		return fmt.Errorf("bootPersistence requires failClosed to be enabled")
+	}
+	if !filepath.IsAbs(b.Dir) {
+		return fmt.Errorf("bootPersistence dir %q should be an absolute path", b.Dir)
+	}
+	if !filepath.IsAbs(b.SystemdDir) {
+		return fmt.Errorf("bootPersistence systemdDir %q should be an absolute path", b.SystemdDir)
+	}
+	return nil
+}
+
+// validateNATMappingExport makes sure the exported files are bounded
+func validateNATMappingExport(e NATMappingExport) error {
+	if !e.Enable {
+		return nil
+	}
+	if e.IntervalSecond <= 0 {
+		return fmt.Errorf("natMappingExport intervalSecond should be greater than 0")
+	}
+	if !filepath.IsAbs(e.Dir) {
+		return fmt.Errorf("natMappingExport dir %q should be an absolute path", e.Dir)
+	}
+	if e.MaxFileSizeMB <= 0 || e.MaxFiles <= 0 {
+		return fmt.Errorf("natMappingExport maxFileSizeMB and maxFiles should be greater than 0")
+	}
+	if e.MaxAgeHours < 0 {
+		return fmt.Errorf("natMappingExport maxAgeHours should not be less than 0")
+	}
+	return nil
+}
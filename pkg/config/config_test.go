@@ -14,6 +14,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
 )
 
 var tmpConfigmapData = `
@@ -115,3 +117,235 @@ func TestLoadConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestParseTLS(t *testing.T) {
+	cfg := TLS{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}
+	assert.NoError(t, parseTLS(&cfg))
+	assert.Equal(t, uint16(0x0303), cfg.MinVersionID)
+	assert.Equal(t, []uint16{0xc02f}, cfg.CipherSuiteIDs)
+
+	assert.Error(t, parseTLS(&TLS{MinVersion: "VersionTLS10"}))
+	// the insecure cipher suites are not allowed
+	assert.Error(t, parseTLS(&TLS{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}))
+}
+
+func TestParseDestinationProviders(t *testing.T) {
+	providers := []DestinationProvider{{Name: "s3", URL: "https://ip-ranges.amazonaws.com/ip-ranges.json", Format: "json"}}
+	assert.NoError(t, parseDestinationProviders(providers))
+	assert.Equal(t, DestinationProviderHTTP, providers[0].Type)
+	assert.Equal(t, 3600, providers[0].RefreshIntervalSecond)
+
+	assert.Error(t, parseDestinationProviders([]DestinationProvider{{URL: "http://example.com"}}))
+	assert.Error(t, parseDestinationProviders([]DestinationProvider{{Name: "a", URL: "http://example.com"}, {Name: "a", URL: "http://example.com"}}))
+	assert.Error(t, parseDestinationProviders([]DestinationProvider{{Name: "a"}}))
+	assert.Error(t, parseDestinationProviders([]DestinationProvider{{Name: "a", URL: "http://example.com", Format: "yaml"}}))
+	assert.Error(t, parseDestinationProviders([]DestinationProvider{{Name: "a", URL: "http://example.com", Type: "s3"}}))
+}
+
+func TestParseEIPHooks(t *testing.T) {
+	hooks := []EIPHook{{Name: "firewall", Type: EIPHookWebhook, URL: "https://firewall.example.com/eips"}}
+	assert.NoError(t, parseEIPHooks(hooks))
+	assert.Equal(t, []string{EIPHookPreActivate, EIPHookPostDeactivate}, hooks[0].Events)
+	assert.Equal(t, 10, hooks[0].TimeoutSecond)
+	assert.Equal(t, EIPHookFailurePolicyFail, hooks[0].FailurePolicy)
+
+	assert.Error(t, parseEIPHooks([]EIPHook{{Type: EIPHookExec, Command: []string{"true"}}}))
+	assert.Error(t, parseEIPHooks([]EIPHook{{Name: "a", Type: EIPHookExec, Command: []string{"true"}}, {Name: "a", Type: EIPHookExec, Command: []string{"true"}}}))
+	assert.Error(t, parseEIPHooks([]EIPHook{{Name: "a", Type: EIPHookExec}}))
+	assert.Error(t, parseEIPHooks([]EIPHook{{Name: "a", Type: EIPHookWebhook}}))
+	assert.Error(t, parseEIPHooks([]EIPHook{{Name: "a", Type: "grpc", URL: "http://example.com"}}))
+	assert.Error(t, parseEIPHooks([]EIPHook{{Name: "a", Type: EIPHookExec, Command: []string{"true"}, Events: []string{"activate"}}}))
+	assert.Error(t, parseEIPHooks([]EIPHook{{Name: "a", Type: EIPHookExec, Command: []string{"true"}, FailurePolicy: "Retry"}}))
+}
+
+func TestValidateEIPVerification(t *testing.T) {
+	v := EIPVerification{Enable: true, URL: "https://ifconfig.me/ip", IntervalSecond: 30, TimeoutSecond: 5, Mark: "0x27000000"}
+	assert.NoError(t, validateEIPVerification(v, "0x26000000", ""))
+	assert.NoError(t, validateEIPVerification(EIPVerification{}, "0x26000000", ""))
+
+	for _, item := range []func(v *EIPVerification){
+		func(v *EIPVerification) { v.URL = "" },
+		func(v *EIPVerification) { v.URL = "ftp://example.com" },
+		func(v *EIPVerification) { v.IntervalSecond = 0 },
+		func(v *EIPVerification) { v.TimeoutSecond = 0 },
+		func(v *EIPVerification) { v.Mark = "0x27000001" },
+		func(v *EIPVerification) { v.Mark = "0x26100000" },
+	} {
+		invalid := v
+		item(&invalid)
+		assert.Error(t, validateEIPVerification(invalid, "0x26000000", ""), invalid)
+	}
+}
+
+func TestValidateProxyProtocol(t *testing.T) {
+	fc := defaultFileConfig(false)
+	p := fc.ProxyProtocol
+	assert.NoError(t, validateProxyProtocol(p, fc.EIPVerification, fc.Mark, fc.MarkMask))
+	p.Enable = true
+	assert.NoError(t, validateProxyProtocol(p, fc.EIPVerification, fc.Mark, fc.MarkMask))
+	fc.EIPVerification.Enable = true
+	assert.NoError(t, validateProxyProtocol(p, fc.EIPVerification, fc.Mark, fc.MarkMask))
+
+	p.Mark = fc.EIPVerification.Mark
+	assert.ErrorContains(t, validateProxyProtocol(p, fc.EIPVerification, fc.Mark, fc.MarkMask), "eipVerification")
+	p.Mark = fc.Mark
+	assert.ErrorContains(t, validateProxyProtocol(p, fc.EIPVerification, fc.Mark, fc.MarkMask), "overlap")
+	p.Mark = "0x28000001"
+	assert.Error(t, validateProxyProtocol(p, fc.EIPVerification, fc.Mark, fc.MarkMask))
+	p.Mark = "0x28000000"
+	p.Port = 0
+	assert.Error(t, validateProxyProtocol(p, fc.EIPVerification, fc.Mark, fc.MarkMask))
+}
+
+func TestValidateL7Proxy(t *testing.T) {
+	fc := defaultFileConfig(false)
+	assert.NoError(t, validateL7Proxy(&fc))
+	fc.L7Proxy.Enable = true
+	assert.NoError(t, validateL7Proxy(&fc))
+	fc.ProxyProtocol.Enable = true
+	fc.EIPVerification.Enable = true
+	assert.NoError(t, validateL7Proxy(&fc))
+
+	fc.L7Proxy.Mark = "0x28000000"
+	assert.ErrorContains(t, validateL7Proxy(&fc), "proxyProtocol")
+	fc.L7Proxy.Mark = "0x27000001"
+	assert.ErrorContains(t, validateL7Proxy(&fc), "eipVerification")
+	fc.L7Proxy.Mark = fc.Mark
+	assert.ErrorContains(t, validateL7Proxy(&fc), "overlap")
+	fc.L7Proxy.Mark = "0x29000000"
+
+	fc.L7Proxy.Port = fc.ProxyProtocol.Port
+	assert.ErrorContains(t, validateL7Proxy(&fc), "proxyProtocol port")
+	fc.L7Proxy.Port = 15200
+	fc.EnableGatewayReplyRoute = true
+	fc.GatewayReplyRouteTable = 610
+	assert.ErrorContains(t, validateL7Proxy(&fc), "gatewayReplyRouteTable")
+	fc.L7Proxy.RouteTable = 100
+	assert.Error(t, validateL7Proxy(&fc))
+}
+
+func TestValidateIstio(t *testing.T) {
+	fc := defaultFileConfig(false)
+	assert.NoError(t, validateIstio(fc.Istio))
+	fc.Istio.Enable = true
+	assert.NoError(t, validateIstio(fc.Istio))
+	fc.Istio.RedirectPort = 15001
+	assert.NoError(t, validateIstio(fc.Istio))
+
+	fc.Istio.RedirectPort = 65536
+	assert.ErrorContains(t, validateIstio(fc.Istio), "redirectPort")
+	fc.Istio.RedirectPort = 0
+	fc.Istio.MeshPorts = []int{15008, 0}
+	assert.ErrorContains(t, validateIstio(fc.Istio), "meshPorts port 0")
+	fc.Istio.MeshPorts = make([]int, 16)
+	assert.ErrorContains(t, validateIstio(fc.Istio), "15 ports")
+}
+
+func TestValidateHairpin(t *testing.T) {
+	assert.NoError(t, validateHairpin(Hairpin{Mode: HairpinDisabled}))
+	assert.NoError(t, validateHairpin(Hairpin{Mode: HairpinReject}))
+	assert.NoError(t, validateHairpin(Hairpin{Mode: HairpinDNAT, DNATTargetIPv4: "10.6.0.10"}))
+	assert.NoError(t, validateHairpin(Hairpin{Mode: HairpinDNAT, DNATTargetIPv6: "fd00::10"}))
+
+	// the message tells the behaviors of the modes
+	err := validateHairpin(Hairpin{Mode: "Drop"})
+	assert.ErrorContains(t, err, "Reject: the connections to the EIPs are rejected")
+	assert.ErrorContains(t, validateHairpin(Hairpin{Mode: HairpinDNAT}), "translated to dnatTargetIPv4")
+	assert.Error(t, validateHairpin(Hairpin{Mode: HairpinDNAT, DNATTargetIPv4: "fd00::10"}))
+	assert.Error(t, validateHairpin(Hairpin{Mode: HairpinDNAT, DNATTargetIPv6: "10.6.0.10"}))
+}
+
+func TestValidateBypassCIDRs(t *testing.T) {
+	assert.NoError(t, validateBypassCIDRs(nil))
+	assert.NoError(t, validateBypassCIDRs([]string{"100.100.100.200/32", "fd00:ec2::254/128"}))
+	assert.ErrorContains(t, validateBypassCIDRs([]string{"100.100.100.200"}), "built-in bypass")
+}
+
+func TestValidateMetadataProtection(t *testing.T) {
+	assert.NoError(t, validateMetadataProtection(defaultFileConfig(false).MetadataProtection))
+	assert.NoError(t, validateMetadataProtection(MetadataProtection{}))
+	assert.Error(t, validateMetadataProtection(MetadataProtection{Enable: true}))
+	assert.Error(t, validateMetadataProtection(MetadataProtection{Enable: true, CIDRs: []string{"169.254.169.254"}}))
+}
+
+func TestValidateAuth(t *testing.T) {
+	fc := defaultFileConfig(false)
+	assert.NoError(t, validateFileConfig(&fc))
+	fc.Debug.Pprof = true
+	assert.ErrorContains(t, validateFileConfig(&fc), "auth mode")
+	fc.Auth = Auth{Mode: AuthModeTokenReview, AllowedIdentities: []string{"system:serviceaccount:default:admin"}}
+	assert.NoError(t, validateFileConfig(&fc))
+}
+
+func TestValidateBootPersistence(t *testing.T) {
+	b := defaultFileConfig(false).BootPersistence
+	assert.NoError(t, validateBootPersistence(b, FailClosed{}))
+	b.Enable = true
+	assert.ErrorContains(t, validateBootPersistence(b, FailClosed{}), "failClosed")
+	assert.NoError(t, validateBootPersistence(b, FailClosed{Enable: true}))
+	b.Dir = "egressgateway"
+	assert.Error(t, validateBootPersistence(b, FailClosed{Enable: true}))
+}
+
+func TestValidateNATMappingExport(t *testing.T) {
+	e := defaultFileConfig(false).NATMappingExport
+	assert.NoError(t, validateNATMappingExport(e))
+	e.Enable = true
+	assert.NoError(t, validateNATMappingExport(e))
+	e.MaxAgeHours = 0
+	assert.NoError(t, validateNATMappingExport(e))
+	e.Dir = "nat-mappings"
+	assert.Error(t, validateNATMappingExport(e))
+	e.Dir = "/var/log/egressgateway/nat-mappings"
+	e.MaxFiles = 0
+	assert.Error(t, validateNATMappingExport(e))
+	e.MaxFiles = 10
+	e.IntervalSecond = 0
+	assert.Error(t, validateNATMappingExport(e))
+}
+
+func TestGeoIPPath(t *testing.T) {
+	geoIP := GeoIP{Dir: "/var/lib/egressgateway/geoip", CountryDatabase: "GeoLite2-Country.mmdb", ASNDatabase: "/data/GeoLite2-ASN.mmdb"}
+	assert.Equal(t, "/var/lib/egressgateway/geoip/GeoLite2-Country.mmdb", geoIP.CountryPath())
+	assert.Equal(t, "/data/GeoLite2-ASN.mmdb", geoIP.ASNPath())
+	geoIP.ASNDatabase = ""
+	assert.Equal(t, "", geoIP.ASNPath())
+}
+
+func TestValidateTunnelDetectMethod(t *testing.T) {
+	assert.NoError(t, validateTunnelDetectMethod(TunnelInterfaceDefaultRoute))
+	assert.NoError(t, validateTunnelDetectMethod("interface=bond0.100"))
+	assert.NoError(t, validateTunnelDetectMethod("mac=52:54:00:00:00:02"))
+	assert.NoError(t, validateTunnelDetectMethod("pci=0000:3b:00.1"))
+
+	assert.Error(t, validateTunnelDetectMethod("interface="))
+	assert.Error(t, validateTunnelDetectMethod("mac=52:54:00"))
+	assert.Error(t, validateTunnelDetectMethod("pci=3b:00.1"))
+	assert.Error(t, validateTunnelDetectMethod("eth0"))
+}
+
+func FuzzParseFileConfig(f *testing.F) {
+	f.Add([]byte(tmpConfigmapData))
+	f.Add([]byte("enableIPv4: true\ntunnelIpv4Subnet: 172.20.0.0\n"))
+	f.Add([]byte("mark: 0x26000000\nmarkMask: 0x00ff0000\n"))
+	f.Add([]byte("tunnelDetectMethod: mac=52:54:00:00:00:02\ngatewayFailover:\n  tunnelProbe:\n    enable: true\n    port: 0\n"))
+	f.Add([]byte("destinationProviders:\n- name: a\n  url: http://a\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fc := defaultFileConfig(false)
+		if err := parseFileConfig(data, &fc); err != nil {
+			return
+		}
+		if fc.EnableIPv4 && fc.TunnelIPv4Net == nil || fc.EnableIPv6 && fc.TunnelIPv6Net == nil {
+			t.Fatalf("tunnel subnet is not parsed")
+		}
+		if err := validateFileConfig(&fc); err != nil {
+			return
+		}
+		if _, err := markallocator.NewSpace(fc.Mark, fc.MarkMask); err != nil {
+			t.Fatalf("invalid mark is validated: %v", err)
+		}
+		if err := validateTunnelDetectMethod(fc.TunnelDetectMethod); err != nil {
+			t.Fatalf("invalid tunnelDetectMethod is validated: %v", err)
+		}
+	})
+}
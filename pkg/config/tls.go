@@ -0,0 +1,41 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+var tlsVersions = map[string]uint16{
+	"VersionTLS12": tls.VersionTLS12,
+	"VersionTLS13": tls.VersionTLS13,
+}
+
+// parseTLS parses the minimum version and the cipher suites of the TLS settings, the
+// insecure cipher suites are not allowed
+func parseTLS(t *TLS) error {
+	if t.MinVersion == "" {
+		t.MinVersion = "VersionTLS12"
+	}
+	version, ok := tlsVersions[t.MinVersion]
+	if !ok {
+		return fmt.Errorf("invalid tls minVersion %s, should be VersionTLS12 or VersionTLS13", t.MinVersion)
+	}
+	t.MinVersionID = version
+
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	t.CipherSuiteIDs = nil
+	for _, name := range t.CipherSuites {
+		id, ok := suites[name]
+		if !ok {
+			return fmt.Errorf("unsupported or insecure tls cipher suite %s", name)
+		}
+		t.CipherSuiteIDs = append(t.CipherSuiteIDs, id)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package bindings
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/queue"
+)
+
+const (
+	// DataKey is the key of the bindings in the data of the ConfigMap
+	DataKey = "bindings.json"
+	// SchemaVersion is the version of the schema of the bindings, it's changed only
+	// when the schema is changed incompatibly
+	SchemaVersion = "v1"
+)
+
+// Bindings is the exported document, the lists are sorted so the document only changes
+// when the bindings are changed
+type Bindings struct {
+	Version  string          `json:"version"`
+	Policies []PolicyBinding `json:"policies"`
+	EIPs     []EIPBinding    `json:"eips"`
+}
+
+// PolicyBinding is the EIP of a policy and the namespaces of the Pods it selects
+type PolicyBinding struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Mode      string `json:"mode"`
+	Gateway   string `json:"gateway"`
+	Node      string `json:"node,omitempty"`
+	IPv4      string `json:"ipv4,omitempty"`
+	IPv6      string `json:"ipv6,omitempty"`
+	UseNodeIP bool   `json:"useNodeIP,omitempty"`
+	// Namespaces is empty for the EgressClusterPolicy selecting the Pods by podSubnet
+	Namespaces []string `json:"namespaces"`
+}
+
+// EIPBinding is the namespaces whose egress traffic is SNATed with the IP by the policies
+// in the Enforce mode
+type EIPBinding struct {
+	IP         string   `json:"ip"`
+	Gateway    string   `json:"gateway"`
+	Node       string   `json:"node,omitempty"`
+	Namespaces []string `json:"namespaces"`
+	Policies   []string `json:"policies"`
+}
+
+// exporter maintains the ConfigMap of the bindings, every change of the policies and the
+// namespaces rebuilds the whole document, which is written by a single update
+type exporter struct {
+	client client.Client
+	// reader reads the ConfigMap from the API server, so the ConfigMaps are not cached
+	reader client.Reader
+	log    logr.Logger
+	key    types.NamespacedName
+}
+
+// NewBindingsExporter adds the controller exporting the EIP bindings if it's enabled
+func NewBindingsExporter(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
+	if !cfg.FileConfig.EIPBindings.Enable {
+		return nil
+	}
+	r := &exporter{
+		client: mgr.GetClient(),
+		reader: mgr.GetAPIReader(),
+		log:    log,
+		key:    types.NamespacedName{Namespace: cfg.PodNamespace, Name: cfg.FileConfig.EIPBindings.ConfigMap},
+	}
+	c, err := controller.New("eip-bindings", mgr, queue.Options(cfg, "eip-bindings", r, 1))
+	if err != nil {
+		return err
+	}
+	enqueue := handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: r.key}}
+	})
+	for _, obj := range []client.Object{&egressv1.EgressPolicy{}, &egressv1.EgressClusterPolicy{}} {
+		if err := c.Watch(source.Kind(mgr.GetCache(), obj), enqueue); err != nil {
+			return err
+		}
+	}
+	return c.Watch(source.Kind(mgr.GetCache(), &corev1.Namespace{}), enqueue, predicate.LabelChangedPredicate{})
+}
+
+func (r *exporter) Reconcile(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+	bindings, err := r.build(ctx)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	raw, err := json.MarshalIndent(bindings, "", "  ")
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	cm := new(corev1.ConfigMap)
+	err = r.reader.Get(ctx, r.key, cm)
+	if k8serr.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: r.key.Namespace, Name: r.key.Name},
+			Data:       map[string]string{DataKey: string(raw)},
+		}
+		if err := r.client.Create(ctx, cm); err != nil {
+			if k8serr.IsAlreadyExists(err) {
+				return reconcile.Result{Requeue: true}, nil
+			}
+			return reconcile.Result{}, err
+		}
+		r.log.Info("create the ConfigMap of the EIP bindings", "configmap", r.key)
+		return reconcile.Result{}, nil
+	}
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if cm.Data[DataKey] == string(raw) {
+		return reconcile.Result{}, nil
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[DataKey] = string(raw)
+	if err := r.client.Update(ctx, cm); err != nil {
+		if k8serr.IsConflict(err) {
+			return reconcile.Result{Requeue: true}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	r.log.V(1).Info("update the ConfigMap of the EIP bindings", "configmap", r.key)
+	return reconcile.Result{}, nil
+}
+
+// build returns the bindings of all the policies
+func (r *exporter) build(ctx context.Context) (*Bindings, error) {
+	namespaces := new(corev1.NamespaceList)
+	if err := r.client.List(ctx, namespaces); err != nil {
+		return nil, err
+	}
+	res := &Bindings{Version: SchemaVersion, Policies: make([]PolicyBinding, 0), EIPs: make([]EIPBinding, 0)}
+
+	policies := new(egressv1.EgressPolicyList)
+	if err := r.client.List(ctx, policies); err != nil {
+		return nil, err
+	}
+	for _, item := range policies.Items {
+		res.Policies = append(res.Policies, newPolicyBinding("EgressPolicy", &item.ObjectMeta,
+			item.Spec.EgressGatewayName, item.Spec.Mode, item.Spec.EgressIP, &item.Status, []string{item.Namespace}))
+	}
+
+	clusterPolicies := new(egressv1.EgressClusterPolicyList)
+	if err := r.client.List(ctx, clusterPolicies); err != nil {
+		return nil, err
+	}
+	for _, item := range clusterPolicies.Items {
+		selected, err := selectNamespaces(namespaces.Items, item.Spec)
+		if err != nil {
+			r.log.Error(err, "skip the invalid namespaceSelector", "policy", item.Name)
+		}
+		res.Policies = append(res.Policies, newPolicyBinding("EgressClusterPolicy", &item.ObjectMeta,
+			item.Spec.EgressGatewayName, item.Spec.Mode, item.Spec.EgressIP, &item.Status, selected))
+	}
+
+	sort.Slice(res.Policies, func(i, j int) bool {
+		a, b := res.Policies[i], res.Policies[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	res.EIPs = eipBindings(res.Policies)
+	return res, nil
+}
+
+func newPolicyBinding(kind string, meta *metav1.ObjectMeta, gateway, mode string,
+	egressIP egressv1.EgressIP, status *egressv1.EgressPolicyStatus, namespaces []string) PolicyBinding {
+	if mode == "" {
+		mode = egressv1.PolicyModeEnforce
+	}
+	res := PolicyBinding{
+		Kind:       kind,
+		Namespace:  meta.Namespace,
+		Name:       meta.Name,
+		Mode:       mode,
+		Gateway:    gateway,
+		Node:       status.Node,
+		IPv4:       status.Eip.Ipv4,
+		IPv6:       status.Eip.Ipv6,
+		UseNodeIP:  egressIP.UseNodeIP,
+		Namespaces: namespaces,
+	}
+	if egressIP.UseNodeIP {
+		res.IPv4, res.IPv6 = status.NodeIP.Ipv4, status.NodeIP.Ipv6
+	}
+	if res.Namespaces == nil {
+		res.Namespaces = make([]string, 0)
+	}
+	return res
+}
+
+// selectNamespaces returns the sorted namespaces selected by the namespaceSelector, all
+// the namespaces are selected without it. It returns nothing for podSubnet
+func selectNamespaces(namespaces []corev1.Namespace, spec egressv1.EgressClusterPolicySpec) ([]string, error) {
+	if spec.AppliedTo.PodSelector == nil && !spec.ClusterDefault {
+		return nil, nil
+	}
+	selector, err := spec.NamespaceLabelSelector()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0)
+	for _, item := range namespaces {
+		if selector.Matches(labels.Set(item.Labels)) {
+			res = append(res, item.Name)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// eipBindings groups the sorted policies in the Enforce mode by their IPs
+func eipBindings(policies []PolicyBinding) []EIPBinding {
+	index := make(map[string]*EIPBinding)
+	for _, item := range policies {
+		if item.Mode != egressv1.PolicyModeEnforce {
+			continue
+		}
+		name := item.Kind + "/" + item.Name
+		if item.Namespace != "" {
+			name = item.Kind + "/" + item.Namespace + "/" + item.Name
+		}
+		for _, ip := range []string{item.IPv4, item.IPv6} {
+			if ip == "" {
+				continue
+			}
+			binding, ok := index[ip]
+			if !ok {
+				binding = &EIPBinding{IP: ip, Gateway: item.Gateway, Node: item.Node}
+				index[ip] = binding
+			}
+			binding.Namespaces = append(binding.Namespaces, item.Namespaces...)
+			binding.Policies = append(binding.Policies, name)
+		}
+	}
+
+	res := make([]EIPBinding, 0, len(index))
+	for _, binding := range index {
+		binding.Namespaces = dedup(binding.Namespaces)
+		res = append(res, *binding)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].IP < res[j].IP })
+	return res
+}
+
+// dedup returns the sorted list without duplicates
+func dedup(list []string) []string {
+	res := make([]string, 0, len(list))
+	set := make(map[string]struct{}, len(list))
+	for _, item := range list {
+		if _, ok := set[item]; !ok {
+			set[item] = struct{}{}
+			res = append(res, item)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
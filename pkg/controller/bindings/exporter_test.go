@@ -0,0 +1,102 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package bindings
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestExporterReconcile(t *testing.T) {
+	ctx := context.Background()
+	objs := []client.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "a"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Labels: map[string]string{"team": "b"}}},
+		&egressv1.EgressPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+			Spec:       egressv1.EgressPolicySpec{EgressGatewayName: "gw"},
+			Status: egressv1.EgressPolicyStatus{
+				Node: "node1", Eip: egressv1.Eip{Ipv4: "10.6.1.21", Ipv6: "fd00::21"},
+			},
+		},
+		&egressv1.EgressPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "shadow"},
+			Spec:       egressv1.EgressPolicySpec{EgressGatewayName: "gw", Mode: egressv1.PolicyModeShadow},
+			Status:     egressv1.EgressPolicyStatus{Node: "node1", Eip: egressv1.Eip{Ipv4: "10.6.1.22"}},
+		},
+		&egressv1.EgressClusterPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "teams"},
+			Spec: egressv1.EgressClusterPolicySpec{
+				EgressGatewayName: "gw",
+				AppliedTo: egressv1.ClusterAppliedTo{
+					PodSelector: &metav1.LabelSelector{},
+					NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "team", Operator: metav1.LabelSelectorOpExists},
+					}},
+				},
+			},
+			Status: egressv1.EgressPolicyStatus{Node: "node1", Eip: egressv1.Eip{Ipv4: "10.6.1.21"}},
+		},
+	}
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(objs...).Build()
+	key := types.NamespacedName{Namespace: "kube-system", Name: "egressgateway-eip-bindings"}
+	r := &exporter{client: cli, reader: cli, log: logr.Discard(), key: key}
+
+	_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+	assert.NoError(t, err)
+	cm := new(corev1.ConfigMap)
+	assert.NoError(t, cli.Get(ctx, key, cm))
+	bindings := new(Bindings)
+	assert.NoError(t, json.Unmarshal([]byte(cm.Data[DataKey]), bindings))
+
+	assert.Equal(t, SchemaVersion, bindings.Version)
+	assert.Len(t, bindings.Policies, 3)
+	assert.Equal(t, "teams", bindings.Policies[0].Name)
+	assert.Equal(t, []string{"team-a", "team-b"}, bindings.Policies[0].Namespaces)
+	assert.Equal(t, "shadow", bindings.Policies[1].Name)
+	assert.Equal(t, egressv1.PolicyModeShadow, bindings.Policies[1].Mode)
+	assert.Equal(t, []EIPBinding{
+		{
+			IP: "10.6.1.21", Gateway: "gw", Node: "node1",
+			Namespaces: []string{"default", "team-a", "team-b"},
+			Policies:   []string{"EgressClusterPolicy/teams", "EgressPolicy/default/web"},
+		},
+		{
+			IP: "fd00::21", Gateway: "gw", Node: "node1",
+			Namespaces: []string{"default"},
+			Policies:   []string{"EgressPolicy/default/web"},
+		},
+	}, bindings.EIPs)
+
+	// the ConfigMap is not updated if the bindings are not changed
+	version := cm.ResourceVersion
+	_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+	assert.NoError(t, err)
+	assert.NoError(t, cli.Get(ctx, key, cm))
+	assert.Equal(t, version, cm.ResourceVersion)
+
+	policy := new(egressv1.EgressPolicy)
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, policy))
+	policy.Status.Eip.Ipv6 = ""
+	assert.NoError(t, cli.Update(ctx, policy))
+	_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+	assert.NoError(t, err)
+	assert.NoError(t, cli.Get(ctx, key, cm))
+	assert.NoError(t, json.Unmarshal([]byte(cm.Data[DataKey]), bindings))
+	assert.Len(t, bindings.EIPs, 1)
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressclusterinfo "github.com/spidernet-io/egressgateway/pkg/controller/egress_cluster_info"
+)
+
+// cacheOptions returns the cache options of the controller, the managed fields of all
+// objects are dropped, and the Pods only keep the fields used by the controllers. If
+// podLabelSelector is set, only the Pods matching it are cached. If the endpoint slices
+// are reconciled by the standalone endpoint-controller, only the kube-controller-manager
+// Pods are cached.
+func cacheOptions(cfg *config.Config) (cache.Options, error) {
+	if cfg.FileConfig.EndpointReconcile.Standalone {
+		return newCacheOptions(egressclusterinfo.KubeControllerManagerSelector()), nil
+	}
+	return endpointCacheOptions(cfg)
+}
+
+// endpointCacheOptions returns the cache options of the endpoint controllers
+func endpointCacheOptions(cfg *config.Config) (cache.Options, error) {
+	var selector labels.Selector
+	if cfg.FileConfig.PodLabelSelector != "" {
+		var err error
+		selector, err = labels.Parse(cfg.FileConfig.PodLabelSelector)
+		if err != nil {
+			return cache.Options{}, fmt.Errorf("failed to parse podLabelSelector: %w", err)
+		}
+	}
+	return newCacheOptions(selector), nil
+}
+
+func newCacheOptions(podSelector labels.Selector) cache.Options {
+	return cache.Options{
+		DefaultTransform: stripManagedFields,
+		ByObject: map[client.Object]cache.ByObject{
+			&corev1.Pod{}: {Transform: stripPod, Label: podSelector},
+		},
+	}
+}
+
+func stripManagedFields(obj interface{}) (interface{}, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return obj, nil
+	}
+	accessor.SetManagedFields(nil)
+	return obj, nil
+}
+
+// stripPod keeps the metadata, node and IPs of the Pod, which are used to build endpoints
+func stripPod(obj interface{}) (interface{}, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return obj, nil
+	}
+	return &corev1.Pod{
+		TypeMeta: pod.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              pod.Name,
+			Namespace:         pod.Namespace,
+			UID:               pod.UID,
+			ResourceVersion:   pod.ResourceVersion,
+			CreationTimestamp: pod.CreationTimestamp,
+			DeletionTimestamp: pod.DeletionTimestamp,
+			Labels:            pod.Labels,
+		},
+		Spec: corev1.PodSpec{
+			NodeName: pod.Spec.NodeName,
+		},
+		Status: corev1.PodStatus{
+			Phase:  pod.Status.Phase,
+			PodIP:  pod.Status.PodIP,
+			PodIPs: pod.Status.PodIPs,
+		},
+	}, nil
+}
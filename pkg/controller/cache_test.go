@@ -0,0 +1,84 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+)
+
+func TestStripPod(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:          "pod1",
+			Namespace:     "default",
+			Labels:        map[string]string{"app": "test"},
+			Annotations:   map[string]string{"key": "value"},
+			ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubelet"}},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:   "node1",
+			Containers: []corev1.Container{{Name: "test", Image: "test"}},
+		},
+		Status: corev1.PodStatus{
+			PodIP:      "10.6.0.1",
+			PodIPs:     []corev1.PodIP{{IP: "10.6.0.1"}, {IP: "fd00::1"}},
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady}},
+		},
+	}
+
+	obj, err := stripPod(pod)
+	assert.NoError(t, err)
+	res := obj.(*corev1.Pod)
+	assert.Equal(t, pod.Name, res.Name)
+	assert.Equal(t, pod.Labels, res.Labels)
+	assert.Equal(t, "node1", res.Spec.NodeName)
+	assert.Equal(t, pod.Status.PodIPs, res.Status.PodIPs)
+	assert.Empty(t, res.Annotations)
+	assert.Empty(t, res.ManagedFields)
+	assert.Empty(t, res.Spec.Containers)
+	assert.Empty(t, res.Status.Conditions)
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:          "node1",
+		ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubelet"}},
+	}}
+	obj, err = stripManagedFields(node)
+	assert.NoError(t, err)
+	assert.Empty(t, obj.(*corev1.Node).ManagedFields)
+}
+
+func TestCacheOptions(t *testing.T) {
+	cfg := &config.Config{}
+	_, err := cacheOptions(cfg)
+	assert.NoError(t, err)
+
+	cfg.FileConfig.PodLabelSelector = "egress.spidernet.io/enabled=true"
+	opts, err := cacheOptions(cfg)
+	assert.NoError(t, err)
+	for obj, item := range opts.ByObject {
+		_, ok := obj.(*corev1.Pod)
+		assert.True(t, ok)
+		assert.Equal(t, "egress.spidernet.io/enabled=true", item.Label.String())
+	}
+
+	cfg.FileConfig.PodLabelSelector = "a in (b"
+	_, err = cacheOptions(cfg)
+	assert.Error(t, err)
+
+	// the controller only caches the kube-controller-manager Pods in the standalone mode
+	cfg.FileConfig.EndpointReconcile.Standalone = true
+	opts, err = cacheOptions(cfg)
+	assert.NoError(t, err)
+	for _, item := range opts.ByObject {
+		assert.Equal(t, "component=kube-controller-manager", item.Label.String())
+	}
+	_, err = endpointCacheOptions(cfg)
+	assert.Error(t, err)
+}
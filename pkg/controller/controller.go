@@ -6,8 +6,14 @@ package controller
 import (
 	"context"
 	"fmt"
-	"github.com/spidernet-io/egressgateway/pkg/controller/endpoint"
+	"net/http"
+	"time"
+
+	"github.com/spidernet-io/egressgateway/pkg/controller/bindings"
+	"github.com/spidernet-io/egressgateway/pkg/controller/destination"
+	"github.com/spidernet-io/egressgateway/pkg/controller/dns"
 	"github.com/spidernet-io/egressgateway/pkg/controller/policy"
+	"github.com/spidernet-io/egressgateway/pkg/controller/status"
 	"github.com/spidernet-io/egressgateway/pkg/controller/tunnel"
 
 	"github.com/go-logr/logr"
@@ -17,14 +23,19 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	runtimeWebhook "sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	"github.com/spidernet-io/egressgateway/pkg/auth"
+	"github.com/spidernet-io/egressgateway/pkg/bootstrap"
 	"github.com/spidernet-io/egressgateway/pkg/config"
 	egressclusterinfo "github.com/spidernet-io/egressgateway/pkg/controller/egress_cluster_info"
 	"github.com/spidernet-io/egressgateway/pkg/controller/metrics"
+	"github.com/spidernet-io/egressgateway/pkg/controller/multicluster"
+	"github.com/spidernet-io/egressgateway/pkg/controller/networkpolicy"
 	"github.com/spidernet-io/egressgateway/pkg/controller/webhook"
 	"github.com/spidernet-io/egressgateway/pkg/egressgateway"
 	"github.com/spidernet-io/egressgateway/pkg/logger"
 	"github.com/spidernet-io/egressgateway/pkg/profiling"
 	"github.com/spidernet-io/egressgateway/pkg/schema"
+	"github.com/spidernet-io/egressgateway/pkg/tlsconfig"
 	"github.com/spidernet-io/egressgateway/pkg/types"
 )
 
@@ -35,7 +46,33 @@ type Controller struct {
 
 func New(cfg *config.Config) (types.Service, error) {
 	log := logger.NewLogger(cfg.EnvConfig.Logger)
+	cacheOpts, err := cacheOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var certs *bootstrap.Certs
+	if cfg.Bootstrap {
+		certs, err = bootstrapCluster(cfg, log)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tlsServer, err := tlsconfig.New(cfg.FileConfig.TLS, cfg.TLSCertDir, log)
+	if err != nil {
+		return nil, err
+	}
+	debugAuth, err := auth.New(cfg.FileConfig.Auth, cfg.KubeConfig, log)
+	if err != nil {
+		return nil, err
+	}
+	// the controller runs out-of-cluster against the kubeconfig in the dev mode, without
+	// the leader election and the webhooks
+	if cfg.DevMode {
+		log.Info("dev mode is enabled, the leader election and the webhooks are disabled")
+		cfg.LeaderElection = false
+	}
 	mgrOpts := manager.Options{
+		Cache:                   cacheOpts,
 		Scheme:                  schema.GetScheme(),
 		Logger:                  log,
 		LeaderElection:          cfg.LeaderElection,
@@ -45,11 +82,32 @@ func New(cfg *config.Config) (types.Service, error) {
 		WebhookServer: runtimeWebhook.NewServer(runtimeWebhook.Options{
 			Port:    cfg.WebhookPort,
 			CertDir: cfg.TLSCertDir,
+			TLSOpts: tlsServer.Options(),
 		}),
 	}
 
+	var statusServer *status.Server
 	if cfg.MetricsBindAddress != "" {
 		mgrOpts.Metrics.BindAddress = cfg.MetricsBindAddress
+		if debugAuth.Enabled() {
+			mgrOpts.Metrics.ExtraHandlers = map[string]http.Handler{"/loglevel": debugAuth.Handler(logger.LevelHandler())}
+			if cfg.FileConfig.Debug.Pprof {
+				for path, handler := range profiling.DebugHandlers() {
+					mgrOpts.Metrics.ExtraHandlers[path] = debugAuth.Handler(handler)
+				}
+			}
+			if cfg.FileConfig.StatusEndpoint.Enable {
+				statusServer = status.New(cfg.FileConfig.StatusEndpoint, log)
+				mgrOpts.Metrics.ExtraHandlers["/status"] = debugAuth.Handler(statusServer.Handler())
+			}
+		} else {
+			log.Info("the debug endpoints are not served without the auth mode")
+		}
+		if cfg.FileConfig.TLS.SecureMetrics {
+			mgrOpts.Metrics.SecureServing = true
+			mgrOpts.Metrics.CertDir = cfg.TLSCertDir
+			mgrOpts.Metrics.TLSOpts = append(tlsServer.Options(), debugAuth.TLSOptions()...)
+		}
 	}
 
 	if cfg.HealthProbeBindAddress != "" {
@@ -61,28 +119,62 @@ func New(cfg *config.Config) (types.Service, error) {
 		return nil, fmt.Errorf("failed to create manager: %w", err)
 	}
 
+	if err = mgr.Add(tlsServer); err != nil {
+		return nil, err
+	}
+	if certs != nil {
+		if err = mgr.Add(certs); err != nil {
+			return nil, err
+		}
+	}
+
 	if err = setManger(mgr, cfg, log); err != nil {
 		return nil, err
 	}
+	if statusServer != nil {
+		if err = statusServer.Setup(mgr); err != nil {
+			return nil, err
+		}
+	}
 
-	metrics.RegisterMetricCollectors()
+	metrics.RegisterMetricCollectors("controller")
 
-	err = egressgateway.NewEgressGatewayController(mgr, log, cfg)
+	err = egressgateway.NewEgressGatewayController(mgr, logger.ForModule(log, logger.ModuleGateway), cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create egress gateway controller: %w", err)
 	}
 
-	err = policy.NewEgressPolicyController(mgr, log, cfg)
+	err = policy.NewEgressPolicyController(mgr, logger.ForModule(log, logger.ModulePolicy), cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create egress policy controller: %w", err)
 	}
 
-	err = policy.NewEgressClusterPolicyController(mgr, log, cfg)
+	err = policy.NewEgressClusterPolicyController(mgr, logger.ForModule(log, logger.ModulePolicy), cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create egress cluster policy controller: %w", err)
 	}
 
-	err = tunnel.NewEgressTunnelController(mgr, log, cfg)
+	err = destination.NewDestinationController(mgr, logger.ForModule(log, logger.ModulePolicy), cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create destination controller: %w", err)
+	}
+
+	err = networkpolicy.NewNetworkPolicyChecker(mgr, logger.ForModule(log, logger.ModulePolicy), cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create networkpolicy checker: %w", err)
+	}
+
+	err = bindings.NewBindingsExporter(mgr, logger.ForModule(log, logger.ModulePolicy), cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create eip bindings exporter: %w", err)
+	}
+
+	err = dns.NewDNSPublisher(mgr, logger.ForModule(log, logger.ModulePolicy), cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dns publisher: %w", err)
+	}
+
+	err = tunnel.NewEgressTunnelController(mgr, logger.ForModule(log, logger.ModuleTunnel), cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create egress tunnel controller: %w", err)
 	}
@@ -91,14 +183,16 @@ func New(cfg *config.Config) (types.Service, error) {
 		return nil, fmt.Errorf("failed to create egress cluster info controller: %w", err)
 	}
 
-	err = endpoint.NewEgressEndpointSliceController(mgr, log, cfg)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create endpoint slice controller: %w", err)
+	// the endpoint slices are reconciled by the endpoint-controller in the standalone mode
+	if !cfg.FileConfig.EndpointReconcile.Standalone {
+		if err = addEndpointControllers(mgr, cfg, log); err != nil {
+			return nil, err
+		}
 	}
 
-	err = endpoint.NewEgressClusterEpSliceController(mgr, log, cfg)
+	err = multicluster.NewBroker(mgr, logger.ForModule(log, logger.ModuleMultiCluster), cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cluster endpoint slice controller: %w", err)
+		return nil, fmt.Errorf("failed to create multi-cluster broker: %w", err)
 	}
 
 	return &Controller{client: mgr.GetClient(), manager: mgr}, err
@@ -123,6 +217,9 @@ func setManger(mgr manager.Manager, cfg *config.Config, log logr.Logger) error {
 	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
 		return fmt.Errorf("failed to AddReadyzCheck: %w", err)
 	}
+	if cfg.DevMode {
+		return nil
+	}
 	mgr.GetWebhookServer().Register("/validate", webhook.ValidateHook(cli, cfg))
 	mgr.GetWebhookServer().Register("/mutate", webhook.MutateHook(cli, cfg))
 	return nil
@@ -141,3 +238,32 @@ func (c *Controller) Start(ctx context.Context) error {
 		return err
 	}
 }
+
+// bootstrapCluster installs the CRDs and the webhook certificates before the manager is
+// created, so the manager caches the custom resources and the webhook server loads the
+// certificates at once. The returned Certs renews the certificates later.
+func bootstrapCluster(cfg *config.Config, log logr.Logger) (*bootstrap.Certs, error) {
+	cli, err := client.New(cfg.KubeConfig, client.Options{Scheme: schema.GetScheme()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := bootstrap.InstallCRDs(ctx, cli, log); err != nil {
+		return nil, err
+	}
+	certs := &bootstrap.Certs{
+		Client:      cli,
+		Namespace:   cfg.PodNamespace,
+		Service:     cfg.WebhookService,
+		SecretName:  cfg.WebhookSecret,
+		WebhookName: cfg.WebhookService,
+		CertDir:     cfg.TLSCertDir,
+		Log:         log,
+	}
+	if err := certs.Ensure(ctx); err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package destination
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egresserrors "github.com/spidernet-io/egressgateway/pkg/errors"
+)
+
+const (
+	fetchTimeout = time.Minute
+	// the published IP ranges of the cloud providers are several MB
+	maxBodySize = 64 << 20
+)
+
+// Provider fetches the destination CIDRs from an external source
+type Provider interface {
+	Fetch(ctx context.Context) ([]string, error)
+}
+
+// NewProvider returns the provider of the configuration
+func NewProvider(cfg config.DestinationProvider) (Provider, error) {
+	switch cfg.Type {
+	case config.DestinationProviderHTTP:
+		return &httpProvider{
+			url:    cfg.URL,
+			format: cfg.Format,
+			filter: cfg.Filter,
+			client: &http.Client{Timeout: fetchTimeout},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported destination provider type %s", cfg.Type)
+	}
+}
+
+type httpProvider struct {
+	url    string
+	format string
+	filter map[string]string
+	client *http.Client
+}
+
+func (p *httpProvider) Fetch(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, egresserrors.New(egresserrors.External, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, egresserrors.Newf(egresserrors.External, "failed to get %s: %s", p.url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return nil, err
+	}
+
+	var list []string
+	switch p.format {
+	case config.DestinationFormatJSON:
+		list, err = parseJSON(data, p.filter)
+	default:
+		list, err = parseText(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", p.url, err)
+	}
+	return list, nil
+}
+
+// parseText parses the CIDRs or IPs separated by spaces, commas or lines, `#` starts a comment
+func parseText(data []byte) ([]string, error) {
+	set := make(map[string]struct{})
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		})
+		for _, item := range fields {
+			cidr, ok := normalize(item)
+			if !ok {
+				return nil, fmt.Errorf("invalid CIDR %s", item)
+			}
+			set[cidr] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sortedList(set), nil
+}
+
+// parseJSON parses the CIDR or IP strings in the JSON document, only the ones in the objects
+// matching the filter are used if it is set
+func parseJSON(data []byte, filter map[string]string) ([]string, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	set := make(map[string]struct{})
+	walkJSON(doc, filter, len(filter) == 0, set)
+	return sortedList(set), nil
+}
+
+func walkJSON(val interface{}, filter map[string]string, matched bool, set map[string]struct{}) {
+	switch val := val.(type) {
+	case map[string]interface{}:
+		matched = matched || matchFilter(val, filter)
+		for _, item := range val {
+			walkJSON(item, filter, matched, set)
+		}
+	case []interface{}:
+		for _, item := range val {
+			walkJSON(item, filter, matched, set)
+		}
+	case string:
+		if !matched {
+			return
+		}
+		if cidr, ok := normalize(val); ok {
+			set[cidr] = struct{}{}
+		}
+	}
+}
+
+func matchFilter(obj map[string]interface{}, filter map[string]string) bool {
+	if len(filter) == 0 {
+		return false
+	}
+	for key, want := range filter {
+		val, ok := obj[key].(string)
+		if !ok || val != want {
+			return false
+		}
+	}
+	return true
+}
+
+// normalize returns the CIDR of the CIDR or IP string, the IPs are converted to /32 or /128
+func normalize(s string) (string, bool) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return "", false
+		}
+		return ipNet.String(), true
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return "", false
+	}
+	if ip.To4() != nil {
+		return ip.String() + "/32", true
+	}
+	return ip.String() + "/128", true
+}
+
+func sortedList(set map[string]struct{}) []string {
+	res := make([]string, 0, len(set))
+	for item := range set {
+		res = append(res, item)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package destination
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+)
+
+func TestHTTPProvider(t *testing.T) {
+	documents := map[string]string{
+		"/ranges.txt": "# published ranges\n10.1.0.5/16, 1.1.1.1\n\nfd00::1 # host\n",
+		"/ranges.json": `{
+			"syncToken": "1700000000",
+			"prefixes": [
+				{"ip_prefix": "3.5.0.0/19", "region": "us-east-1", "service": "S3"},
+				{"ip_prefix": "3.4.0.0/24", "region": "us-east-1", "service": "EC2"},
+				{"ip_prefix": "52.95.0.0/20", "region": "us-west-2", "service": "S3"}
+			],
+			"ipv6_prefixes": [
+				{"ipv6_prefix": "2600:1f68::/32", "region": "us-east-1", "service": "S3"}
+			]
+		}`,
+		"/invalid.txt": "10.1.0.0/16\nexample.com\n",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc, ok := documents[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(doc))
+	}))
+	defer server.Close()
+
+	cases := map[string]struct {
+		cfg    config.DestinationProvider
+		expect []string
+		expErr bool
+	}{
+		"text": {
+			cfg:    config.DestinationProvider{URL: server.URL + "/ranges.txt", Format: config.DestinationFormatText},
+			expect: []string{"1.1.1.1/32", "10.1.0.0/16", "fd00::1/128"},
+		},
+		"json": {
+			cfg:    config.DestinationProvider{URL: server.URL + "/ranges.json", Format: config.DestinationFormatJSON},
+			expect: []string{"2600:1f68::/32", "3.4.0.0/24", "3.5.0.0/19", "52.95.0.0/20"},
+		},
+		"json with filter": {
+			cfg: config.DestinationProvider{
+				URL:    server.URL + "/ranges.json",
+				Format: config.DestinationFormatJSON,
+				Filter: map[string]string{"service": "S3", "region": "us-east-1"},
+			},
+			expect: []string{"2600:1f68::/32", "3.5.0.0/19"},
+		},
+		"invalid text": {
+			cfg:    config.DestinationProvider{URL: server.URL + "/invalid.txt", Format: config.DestinationFormatText},
+			expErr: true,
+		},
+		"not found": {
+			cfg:    config.DestinationProvider{URL: server.URL + "/none", Format: config.DestinationFormatText},
+			expErr: true,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			c.cfg.Name = name
+			c.cfg.Type = config.DestinationProviderHTTP
+			provider, err := NewProvider(c.cfg)
+			assert.NoError(t, err)
+
+			list, err := provider.Fetch(context.Background())
+			if c.expErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.expect, list)
+		})
+	}
+}
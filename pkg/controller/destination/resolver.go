@@ -0,0 +1,444 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package destination
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/geoip"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/queue"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+const (
+	kindPolicy        = "EgressPolicy"
+	kindClusterPolicy = "EgressClusterPolicy"
+)
+
+// resolver resolves spec.destSubnetFrom of the policies into status.resolvedDestSubnet by
+// the destination providers, the GeoIP databases and the Services, the agents use both
+// spec.destSubnet and the resolved CIDRs
+type resolver struct {
+	client    client.Client
+	log       logr.Logger
+	cfg       []config.DestinationProvider
+	geoIPCfg  config.GeoIP
+	providers map[string]Provider
+	lookupIP  func(ctx context.Context, host string) ([]net.IP, error)
+
+	// cidrs are the last fetched CIDRs of the providers, a provider which has not been
+	// fetched successfully is absent, and the policies referring to it are not resolved
+	lock  sync.RWMutex
+	cidrs map[string][]string
+
+	// geo is the last loaded GeoIP databases, the policies with GeoIP selectors are not
+	// resolved until it's loaded. geoCIDRs caches the CIDRs of the countries and the ASNs
+	geo        *geoip.Database
+	geoVersion string
+	geoCIDRs   map[string][]string
+
+	policyEvents        chan event.GenericEvent
+	clusterPolicyEvents chan event.GenericEvent
+}
+
+// NewDestinationController adds the controller resolving the destinations of the policies
+// if any destination provider is configured, or GeoIP or the Services are enabled
+func NewDestinationController(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
+	if len(cfg.FileConfig.DestinationProviders) == 0 && !cfg.FileConfig.GeoIP.Enable &&
+		!cfg.FileConfig.EnableDestinationService {
+		return nil
+	}
+	r, err := newResolver(mgr.GetClient(), log, cfg.FileConfig.DestinationProviders)
+	if err != nil {
+		return err
+	}
+	r.geoIPCfg = cfg.FileConfig.GeoIP
+
+	c, err := controller.New("destination", mgr, queue.Options(cfg, "destination", r, 1))
+	if err != nil {
+		return err
+	}
+	if err := c.Watch(source.Kind(mgr.GetCache(), &egressv1.EgressPolicy{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat(kindPolicy)),
+		predicate.GenerationChangedPredicate{}); err != nil {
+		return err
+	}
+	if err := c.Watch(source.Kind(mgr.GetCache(), &egressv1.EgressClusterPolicy{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat(kindClusterPolicy)),
+		predicate.GenerationChangedPredicate{}); err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Channel{Source: r.policyEvents},
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat(kindPolicy))); err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Channel{Source: r.clusterPolicyEvents},
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat(kindClusterPolicy))); err != nil {
+		return err
+	}
+	if cfg.FileConfig.EnableDestinationService {
+		if err := r.watchServices(c, mgr); err != nil {
+			return err
+		}
+	}
+	return mgr.Add(r)
+}
+
+func newResolver(cli client.Client, log logr.Logger, cfg []config.DestinationProvider) (*resolver, error) {
+	r := &resolver{
+		client:              cli,
+		log:                 log,
+		cfg:                 cfg,
+		providers:           make(map[string]Provider),
+		lookupIP:            lookupIP,
+		cidrs:               make(map[string][]string),
+		geoCIDRs:            make(map[string][]string),
+		policyEvents:        make(chan event.GenericEvent),
+		clusterPolicyEvents: make(chan event.GenericEvent),
+	}
+	for _, item := range cfg {
+		provider, err := NewProvider(item)
+		if err != nil {
+			return nil, err
+		}
+		r.providers[item.Name] = provider
+	}
+	return r, nil
+}
+
+// Start fetches the providers and checks the GeoIP databases periodically until the
+// context is done
+func (r *resolver) Start(ctx context.Context) error {
+	wg := sync.WaitGroup{}
+	if r.geoIPCfg.Enable {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.runGeoIP(ctx)
+		}()
+	}
+	for _, item := range r.cfg {
+		wg.Add(1)
+		go func(item config.DestinationProvider) {
+			defer wg.Done()
+			r.run(ctx, item)
+		}(item)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (r *resolver) run(ctx context.Context, cfg config.DestinationProvider) {
+	log := r.log.WithValues("provider", cfg.Name)
+	interval := time.Second * time.Duration(cfg.RefreshIntervalSecond)
+	log.Info("start destination provider", "url", cfg.URL, "interval", interval)
+
+	t := time.NewTimer(0)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			changed, err := r.fetch(ctx, cfg.Name)
+			if err != nil {
+				// the last fetched CIDRs are kept
+				log.Error(err, "failed to fetch the destinations")
+			} else if changed {
+				err := r.notify(ctx, func(item egressv1.DestSubnetSource) bool {
+					return item.Provider == cfg.Name
+				})
+				if err != nil {
+					log.Error(err, "failed to notify the policies of the provider")
+				}
+			}
+			t.Reset(interval)
+		}
+	}
+}
+
+// fetch fetches the CIDRs of the provider, and returns whether they are changed
+func (r *resolver) fetch(ctx context.Context, name string) (bool, error) {
+	list, err := r.providers[name].Fetch(ctx)
+	if err != nil {
+		return false, err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	old, ok := r.cidrs[name]
+	if ok && equality.Semantic.DeepEqual(old, list) {
+		return false, nil
+	}
+	r.log.Info("destinations of the provider are changed", "provider", name, "count", len(list))
+	r.cidrs[name] = list
+	return true, nil
+}
+
+// runGeoIP loads the GeoIP databases again once their files are changed
+func (r *resolver) runGeoIP(ctx context.Context) {
+	log := r.log.WithValues("countryDatabase", r.geoIPCfg.CountryPath(), "asnDatabase", r.geoIPCfg.ASNPath())
+	interval := time.Second * time.Duration(r.geoIPCfg.CheckIntervalSecond)
+	log.Info("start GeoIP databases", "interval", interval)
+
+	t := time.NewTimer(0)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			changed, err := r.loadGeoIP()
+			if err != nil {
+				// the last loaded databases are kept
+				log.Error(err, "failed to load the GeoIP databases")
+			} else if changed {
+				err := r.notify(ctx, func(item egressv1.DestSubnetSource) bool {
+					return item.GeoIP != nil
+				})
+				if err != nil {
+					log.Error(err, "failed to notify the policies with GeoIP selectors")
+				}
+			}
+			t.Reset(interval)
+		}
+	}
+}
+
+// loadGeoIP loads the GeoIP databases if their files are changed, and returns whether
+// they are loaded
+func (r *resolver) loadGeoIP() (bool, error) {
+	version := ""
+	for _, path := range []string{r.geoIPCfg.CountryPath(), r.geoIPCfg.ASNPath()} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		version += fmt.Sprintf("%s/%d/%d;", path, info.Size(), info.ModTime().UnixNano())
+	}
+	r.lock.RLock()
+	unchanged := r.geo != nil && r.geoVersion == version
+	r.lock.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	db, err := geoip.Open(r.geoIPCfg.CountryPath(), r.geoIPCfg.ASNPath())
+	if err != nil {
+		return false, err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.log.Info("GeoIP databases are loaded")
+	r.geo = db
+	r.geoVersion = version
+	r.geoCIDRs = make(map[string][]string)
+	return true, nil
+}
+
+// notify enqueues the policies with the matched sources
+func (r *resolver) notify(ctx context.Context, match func(item egressv1.DestSubnetSource) bool) error {
+	policies := new(egressv1.EgressPolicyList)
+	if err := r.client.List(ctx, policies); err != nil {
+		return err
+	}
+	for i := range policies.Items {
+		if refersTo(policies.Items[i].Spec.DestSubnetFrom, match) {
+			select {
+			case r.policyEvents <- event.GenericEvent{Object: &policies.Items[i]}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+	clusterPolicies := new(egressv1.EgressClusterPolicyList)
+	if err := r.client.List(ctx, clusterPolicies); err != nil {
+		return err
+	}
+	for i := range clusterPolicies.Items {
+		if refersTo(clusterPolicies.Items[i].Spec.DestSubnetFrom, match) {
+			select {
+			case r.clusterPolicyEvents <- event.GenericEvent{Object: &clusterPolicies.Items[i]}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func refersTo(list []egressv1.DestSubnetSource, match func(item egressv1.DestSubnetSource) bool) bool {
+	for _, item := range list {
+		if match(item) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the union of the CIDRs of the sources, it returns false if any provider
+// of the sources has not been fetched, or the GeoIP databases have not been loaded
+func (r *resolver) resolve(list []egressv1.DestSubnetSource) ([]string, bool, error) {
+	if len(list) == 0 {
+		return nil, true, nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	set := make(map[string]struct{})
+	add := func(cidrs []string) {
+		for _, cidr := range cidrs {
+			set[cidr] = struct{}{}
+		}
+	}
+	for _, item := range list {
+		if item.GeoIP != nil {
+			if r.geo == nil {
+				return nil, false, nil
+			}
+			for _, code := range item.GeoIP.Countries {
+				cidrs, err := r.geoSelect("country/"+strings.ToUpper(code), func() ([]string, error) {
+					return r.geo.Countries([]string{code})
+				})
+				if err != nil {
+					return nil, false, err
+				}
+				add(cidrs)
+			}
+			for _, asn := range item.GeoIP.ASNs {
+				cidrs, err := r.geoSelect(fmt.Sprintf("asn/%d", asn), func() ([]string, error) {
+					return r.geo.ASNs([]int64{asn})
+				})
+				if err != nil {
+					return nil, false, err
+				}
+				add(cidrs)
+			}
+		}
+		if item.Provider != "" {
+			cidrs, ok := r.cidrs[item.Provider]
+			if !ok {
+				return nil, false, nil
+			}
+			add(cidrs)
+		}
+	}
+	res := make([]string, 0, len(set))
+	for cidr := range set {
+		res = append(res, cidr)
+	}
+	sort.Strings(res)
+	return res, true, nil
+}
+
+// geoSelect returns the cached CIDRs of the key, which are compiled by fn once the GeoIP
+// databases are loaded
+func (r *resolver) geoSelect(key string, fn func() ([]string, error)) ([]string, error) {
+	if cidrs, ok := r.geoCIDRs[key]; ok {
+		return cidrs, nil
+	}
+	cidrs, err := fn()
+	if err != nil {
+		return nil, err
+	}
+	r.geoCIDRs[key] = cidrs
+	return cidrs, nil
+}
+
+func (r *resolver) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	kind, newReq, err := utils.ParseKindWithReq(req)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	log := r.log.WithValues("name", newReq.Name, "namespace", newReq.Namespace, "kind", kind)
+
+	var obj client.Object
+	var getStatus func() (*[]string, []egressv1.DestSubnetSource)
+	switch kind {
+	case kindPolicy:
+		policy := new(egressv1.EgressPolicy)
+		obj = policy
+		getStatus = func() (*[]string, []egressv1.DestSubnetSource) {
+			return &policy.Status.ResolvedDestSubnet, policy.Spec.DestSubnetFrom
+		}
+	case kindClusterPolicy:
+		policy := new(egressv1.EgressClusterPolicy)
+		obj = policy
+		getStatus = func() (*[]string, []egressv1.DestSubnetSource) {
+			return &policy.Status.ResolvedDestSubnet, policy.Spec.DestSubnetFrom
+		}
+	default:
+		return reconcile.Result{}, nil
+	}
+
+	err = r.client.Get(ctx, types.NamespacedName{Namespace: newReq.Namespace, Name: newReq.Name}, obj)
+	if err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	if !obj.GetDeletionTimestamp().IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	status, from := getStatus()
+	resolved, ok, err := r.resolve(from)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if !ok {
+		// the policy is enqueued again once the providers are fetched
+		log.V(1).Info("the destination providers are not fetched yet")
+		return reconcile.Result{}, nil
+	}
+	serviceCIDRs, externalName, err := r.resolveServices(ctx, newReq.Namespace, from)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if len(serviceCIDRs) != 0 {
+		resolved = union(resolved, serviceCIDRs)
+	}
+	res := reconcile.Result{}
+	if externalName {
+		res.RequeueAfter = externalNameRefreshInterval
+	}
+
+	if len(resolved) == 0 && len(*status) == 0 {
+		return res, nil
+	}
+	if equality.Semantic.DeepEqual(resolved, *status) {
+		return res, nil
+	}
+
+	*status = resolved
+	if err := r.client.Status().Update(ctx, obj); err != nil {
+		if k8serr.IsConflict(err) {
+			return reconcile.Result{Requeue: true}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	log.Info("update the resolved destinations", "count", len(resolved))
+	return res, nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package destination
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+type staticProvider []string
+
+func (p staticProvider) Fetch(_ context.Context) ([]string, error) { return p, nil }
+
+func TestResolverReconcile(t *testing.T) {
+	ctx := context.Background()
+	policy := &egressv1.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "default"},
+		Spec: egressv1.EgressPolicySpec{
+			DestSubnet:     []string{"10.6.0.0/16"},
+			DestSubnetFrom: []egressv1.DestSubnetSource{{Provider: "a"}, {Provider: "b"}},
+		},
+	}
+	clusterPolicy := &egressv1.EgressClusterPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-policy"},
+		Spec: egressv1.EgressClusterPolicySpec{
+			DestSubnetFrom: []egressv1.DestSubnetSource{{Provider: "a"}},
+		},
+	}
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).
+		WithStatusSubresource(&egressv1.EgressPolicy{}, &egressv1.EgressClusterPolicy{}).
+		WithObjects(policy, clusterPolicy).Build()
+
+	r, err := newResolver(cli, logr.Discard(), []config.DestinationProvider{
+		{Name: "a", Type: config.DestinationProviderHTTP, URL: "http://a"},
+		{Name: "b", Type: config.DestinationProviderHTTP, URL: "http://b"},
+	})
+	assert.NoError(t, err)
+	r.providers["a"] = staticProvider{"1.1.1.0/24", "2.2.2.0/24"}
+	r.providers["b"] = staticProvider{"2.2.2.0/24", "fd00::/64"}
+
+	policyReq := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "EgressPolicy/default", Name: "policy"}}
+	clusterPolicyReq := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "EgressClusterPolicy/", Name: "cluster-policy"}}
+
+	// provider b is not fetched, the policy is not resolved and only matches spec.destSubnet
+	changed, err := r.fetch(ctx, "a")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	_, err = r.Reconcile(ctx, policyReq)
+	assert.NoError(t, err)
+	got := new(egressv1.EgressPolicy)
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Namespace: "default", Name: "policy"}, got))
+	assert.Empty(t, got.Status.ResolvedDestSubnet)
+	assert.Equal(t, []string{"10.6.0.0/16"}, got.DestSubnets())
+	got.Spec.DestSubnet = nil
+	assert.Equal(t, egressv1.NoDestSubnet, got.DestSubnets())
+
+	_, err = r.Reconcile(ctx, clusterPolicyReq)
+	assert.NoError(t, err)
+	gotCluster := new(egressv1.EgressClusterPolicy)
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Name: "cluster-policy"}, gotCluster))
+	assert.Equal(t, []string{"1.1.1.0/24", "2.2.2.0/24"}, gotCluster.Status.ResolvedDestSubnet)
+	assert.Equal(t, []string{"1.1.1.0/24", "2.2.2.0/24"}, gotCluster.DestSubnets())
+
+	changed, err = r.fetch(ctx, "b")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = r.fetch(ctx, "b")
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	_, err = r.Reconcile(ctx, policyReq)
+	assert.NoError(t, err)
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Namespace: "default", Name: "policy"}, got))
+	assert.Equal(t, []string{"1.1.1.0/24", "2.2.2.0/24", "fd00::/64"}, got.Status.ResolvedDestSubnet)
+	assert.Equal(t, []string{"10.6.0.0/16", "1.1.1.0/24", "2.2.2.0/24", "fd00::/64"}, got.DestSubnets())
+
+	// the resolved destinations are removed with spec.destSubnetFrom
+	got.Spec.DestSubnetFrom = nil
+	assert.NoError(t, cli.Update(ctx, got))
+	_, err = r.Reconcile(ctx, policyReq)
+	assert.NoError(t, err)
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Namespace: "default", Name: "policy"}, got))
+	assert.Empty(t, got.Status.ResolvedDestSubnet)
+	assert.Equal(t, []string{"10.6.0.0/16"}, got.DestSubnets())
+}
+
+func TestResolveGeoIPNotLoaded(t *testing.T) {
+	r, err := newResolver(nil, logr.Discard(), nil)
+	assert.NoError(t, err)
+	_, ok, err := r.resolve([]egressv1.DestSubnetSource{{GeoIP: &egressv1.GeoIPSelector{Countries: []string{"US"}}}})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	r.geoIPCfg = config.GeoIP{Enable: true, CountryDatabase: "/nonexistent/GeoLite2-Country.mmdb"}
+	_, err = r.loadGeoIP()
+	assert.Error(t, err)
+}
+
+func TestResolveServices(t *testing.T) {
+	ctx := context.Background()
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).
+		WithStatusSubresource(&egressv1.EgressPolicy{}).
+		WithObjects(
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+				Spec:       corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone},
+			},
+			&discoveryv1.EndpointSlice{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "db-1", Namespace: "default",
+					Labels: map[string]string{discoveryv1.LabelServiceName: "db"},
+				},
+				AddressType: discoveryv1.AddressTypeIPv4,
+				Endpoints: []discoveryv1.Endpoint{
+					{Addresses: []string{"192.168.10.1"}},
+					{Addresses: []string{"192.168.10.2"}, Conditions: discoveryv1.EndpointConditions{Ready: pointer.Bool(false)}},
+				},
+			},
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"},
+				Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeExternalName, ExternalName: "api.example.com"},
+			},
+			&egressv1.EgressPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "default"},
+				Spec: egressv1.EgressPolicySpec{
+					DestSubnetFrom: []egressv1.DestSubnetSource{
+						{Service: &egressv1.ServiceReference{Name: "db"}},
+						{Service: &egressv1.ServiceReference{Name: "api"}},
+						{Service: &egressv1.ServiceReference{Name: "missing"}},
+					},
+				},
+			},
+		).Build()
+
+	r, err := newResolver(cli, logr.Discard(), nil)
+	assert.NoError(t, err)
+	r.lookupIP = func(_ context.Context, host string) ([]net.IP, error) {
+		assert.Equal(t, "api.example.com", host)
+		return []net.IP{net.ParseIP("203.0.113.10"), net.ParseIP("2001:db8::10")}, nil
+	}
+
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "EgressPolicy/default", Name: "policy"}}},
+		r.servicePolicies(ctx, types.NamespacedName{Namespace: "default", Name: "db"}))
+	assert.Empty(t, r.servicePolicies(ctx, types.NamespacedName{Namespace: "other", Name: "db"}))
+
+	res, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "EgressPolicy/default", Name: "policy"}})
+	assert.NoError(t, err)
+	assert.Equal(t, externalNameRefreshInterval, res.RequeueAfter)
+
+	got := new(egressv1.EgressPolicy)
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Namespace: "default", Name: "policy"}, got))
+	assert.Equal(t, []string{"192.168.10.1/32", "2001:db8::10/128", "203.0.113.10/32"}, got.Status.ResolvedDestSubnet)
+}
@@ -0,0 +1,174 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package destination
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// externalNameRefreshInterval is the interval of resolving the ExternalName Services again,
+// the DNS records are not watched
+const externalNameRefreshInterval = time.Minute
+
+// watchServices enqueues the policies referring to the Services once the Services or their
+// EndpointSlices are changed
+func (r *resolver) watchServices(c controller.Controller, mgr manager.Manager) error {
+	if err := c.Watch(source.Kind(mgr.GetCache(), &corev1.Service{}),
+		handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+			return r.servicePolicies(ctx, types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()})
+		})); err != nil {
+		return err
+	}
+	return c.Watch(source.Kind(mgr.GetCache(), &discoveryv1.EndpointSlice{}),
+		handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+			name, ok := obj.GetLabels()[discoveryv1.LabelServiceName]
+			if !ok {
+				return nil
+			}
+			return r.servicePolicies(ctx, types.NamespacedName{Namespace: obj.GetNamespace(), Name: name})
+		}))
+}
+
+// servicePolicies returns the requests of the policies referring to the Service
+func (r *resolver) servicePolicies(ctx context.Context, svc types.NamespacedName) []reconcile.Request {
+	match := func(ns string) func(item egressv1.DestSubnetSource) bool {
+		return func(item egressv1.DestSubnetSource) bool {
+			return item.Service != nil && serviceKey(ns, item.Service) == svc
+		}
+	}
+	res := make([]reconcile.Request, 0)
+	policies := new(egressv1.EgressPolicyList)
+	if err := r.client.List(ctx, policies, client.InNamespace(svc.Namespace)); err != nil {
+		r.log.Error(err, "failed to list EgressPolicies", "service", svc)
+	}
+	for _, item := range policies.Items {
+		if refersTo(item.Spec.DestSubnetFrom, match(item.Namespace)) {
+			res = append(res, reconcile.Request{NamespacedName: types.NamespacedName{
+				Namespace: kindPolicy + "/" + item.Namespace, Name: item.Name,
+			}})
+		}
+	}
+	clusterPolicies := new(egressv1.EgressClusterPolicyList)
+	if err := r.client.List(ctx, clusterPolicies); err != nil {
+		r.log.Error(err, "failed to list EgressClusterPolicies", "service", svc)
+	}
+	for _, item := range clusterPolicies.Items {
+		if refersTo(item.Spec.DestSubnetFrom, match("")) {
+			res = append(res, reconcile.Request{NamespacedName: types.NamespacedName{
+				Namespace: kindClusterPolicy + "/", Name: item.Name,
+			}})
+		}
+	}
+	return res
+}
+
+// serviceKey returns the Service of the reference, the namespace defaults to the namespace
+// of the EgressPolicy
+func serviceKey(ns string, ref *egressv1.ServiceReference) types.NamespacedName {
+	if ref.Namespace != "" {
+		ns = ref.Namespace
+	}
+	return types.NamespacedName{Namespace: ns, Name: ref.Name}
+}
+
+// resolveServices returns the CIDRs of the Services of the sources, and whether any of them
+// is an ExternalName Service, which should be resolved again periodically
+func (r *resolver) resolveServices(ctx context.Context, ns string, list []egressv1.DestSubnetSource) ([]string, bool, error) {
+	res := make([]string, 0)
+	externalName := false
+	for _, item := range list {
+		if item.Service == nil {
+			continue
+		}
+		key := serviceKey(ns, item.Service)
+		svc := new(corev1.Service)
+		if err := r.client.Get(ctx, key, svc); err != nil {
+			// the policy is enqueued again once the Service is created
+			if client.IgnoreNotFound(err) != nil {
+				return nil, false, err
+			}
+			continue
+		}
+
+		if svc.Spec.Type == corev1.ServiceTypeExternalName {
+			externalName = true
+			ips, err := r.lookupExternalName(ctx, svc.Spec.ExternalName)
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to resolve the ExternalName of Service %s: %w", key, err)
+			}
+			res = append(res, ips...)
+			continue
+		}
+
+		slices := new(discoveryv1.EndpointSliceList)
+		err := r.client.List(ctx, slices, client.InNamespace(key.Namespace),
+			client.MatchingLabels{discoveryv1.LabelServiceName: key.Name})
+		if err != nil {
+			return nil, false, err
+		}
+		for _, slice := range slices.Items {
+			if slice.AddressType != discoveryv1.AddressTypeIPv4 && slice.AddressType != discoveryv1.AddressTypeIPv6 {
+				continue
+			}
+			for _, ep := range slice.Endpoints {
+				if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+					continue
+				}
+				for _, addr := range ep.Addresses {
+					if cidr, ok := normalize(addr); ok {
+						res = append(res, cidr)
+					}
+				}
+			}
+		}
+	}
+	return res, externalName, nil
+}
+
+func (r *resolver) lookupExternalName(ctx context.Context, host string) ([]string, error) {
+	if cidr, ok := normalize(host); ok {
+		return []string{cidr}, nil
+	}
+	ips, err := r.lookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if cidr, ok := normalize(ip.String()); ok {
+			res = append(res, cidr)
+		}
+	}
+	return res, nil
+}
+
+func lookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
+
+// union returns the sorted CIDRs of the lists without duplicates
+func union(lists ...[]string) []string {
+	set := make(map[string]struct{})
+	for _, list := range lists {
+		for _, item := range list {
+			set[item] = struct{}{}
+		}
+	}
+	return sortedList(set)
+}
@@ -0,0 +1,231 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package dns
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/queue"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+const (
+	kindPolicy        = "EgressPolicy"
+	kindClusterPolicy = "EgressClusterPolicy"
+)
+
+// DNSEndpointGVK is the DNSEndpoint of the CRD source of external-dns, which publishes its
+// endpoints to the DNS providers
+var DNSEndpointGVK = schema.GroupVersionKind{
+	Group:   "externaldns.k8s.io",
+	Version: "v1alpha1",
+	Kind:    "DNSEndpoint",
+}
+
+// publisher publishes the DNS records of the policies annotated with the DNS names, which
+// point at the EIPs of the policies, by the DNSEndpoints of external-dns
+type publisher struct {
+	client   client.Client
+	scheme   *runtime.Scheme
+	log      logr.Logger
+	recorder record.EventRecorder
+	cfg      *config.Config
+}
+
+// NewDNSPublisher adds the controller publishing the DNS records of the EIPs if it's
+// enabled, it's skipped if the DNSEndpoint CRD of external-dns is not installed
+func NewDNSPublisher(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
+	if !cfg.FileConfig.DNS.Enable {
+		return nil
+	}
+	if _, err := mgr.GetRESTMapper().RESTMapping(DNSEndpointGVK.GroupKind(), DNSEndpointGVK.Version); err != nil {
+		log.Error(err, "the DNSEndpoint CRD of external-dns is not installed, the dns records of the EIPs are not published")
+		return nil
+	}
+	r := &publisher{
+		client:   mgr.GetClient(),
+		scheme:   mgr.GetScheme(),
+		log:      log,
+		recorder: mgr.GetEventRecorderFor("egress-dns"),
+		cfg:      cfg,
+	}
+	c, err := controller.New("egress-dns", mgr, queue.Options(cfg, "egress-dns", r, 1))
+	if err != nil {
+		return err
+	}
+	if err := c.Watch(source.Kind(mgr.GetCache(), &egressv1.EgressPolicy{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat(kindPolicy))); err != nil {
+		return err
+	}
+	return c.Watch(source.Kind(mgr.GetCache(), &egressv1.EgressClusterPolicy{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat(kindClusterPolicy)))
+}
+
+func (r *publisher) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	kind, newReq, err := utils.ParseKindWithReq(req)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	var policy client.Object
+	var eip, nodeIP egressv1.Eip
+	var useNodeIP bool
+	key := types.NamespacedName{Namespace: newReq.Namespace, Name: newReq.Name}
+	switch kind {
+	case kindPolicy:
+		item := new(egressv1.EgressPolicy)
+		if err := r.client.Get(ctx, key, item); err != nil {
+			return reconcile.Result{}, client.IgnoreNotFound(err)
+		}
+		policy, eip, nodeIP, useNodeIP = item, item.Status.Eip, item.Status.NodeIP, item.Spec.EgressIP.UseNodeIP
+	case kindClusterPolicy:
+		item := new(egressv1.EgressClusterPolicy)
+		if err := r.client.Get(ctx, types.NamespacedName{Name: newReq.Name}, item); err != nil {
+			return reconcile.Result{}, client.IgnoreNotFound(err)
+		}
+		policy, eip, nodeIP, useNodeIP = item, item.Status.Eip, item.Status.NodeIP, item.Spec.EgressIP.UseNodeIP
+	default:
+		return reconcile.Result{}, nil
+	}
+	if !policy.GetDeletionTimestamp().IsZero() {
+		return reconcile.Result{}, nil
+	}
+	if useNodeIP {
+		eip = nodeIP
+	}
+
+	name := types.NamespacedName{Namespace: policy.GetNamespace(), Name: "egress-" + policy.GetName()}
+	if kind == kindClusterPolicy {
+		name = types.NamespacedName{Namespace: r.cfg.PodNamespace, Name: "egress-cluster-" + policy.GetName()}
+	}
+	endpoints, err := r.endpoints(policy.GetAnnotations(), eip)
+	if err != nil {
+		r.recorder.Eventf(policy, corev1.EventTypeWarning, "InvalidDNSRecord", "%v", err)
+		return reconcile.Result{}, nil
+	}
+
+	obj := new(unstructured.Unstructured)
+	obj.SetGroupVersionKind(DNSEndpointGVK)
+	err = r.client.Get(ctx, name, obj)
+	if err != nil && !k8serr.IsNotFound(err) {
+		return reconcile.Result{}, err
+	}
+	exists := err == nil
+
+	// the record is removed with the annotation or the EIP of the policy
+	if len(endpoints) == 0 {
+		if !exists {
+			return reconcile.Result{}, nil
+		}
+		if err := r.client.Delete(ctx, obj); err != nil {
+			return reconcile.Result{}, client.IgnoreNotFound(err)
+		}
+		r.log.Info("delete the dns record of the policy", "kind", kind, "policy", key, "dnsEndpoint", name)
+		return reconcile.Result{}, nil
+	}
+
+	current, _, _ := unstructured.NestedSlice(obj.Object, "spec", "endpoints")
+	if exists && reflect.DeepEqual(current, endpoints) {
+		return reconcile.Result{}, nil
+	}
+	if !exists {
+		obj.SetNamespace(name.Namespace)
+		obj.SetName(name.Name)
+		obj.SetLabels(map[string]string{egressv1.LabelPolicyName: policy.GetName()})
+		if err := controllerutil.SetOwnerReference(policy, obj, r.scheme); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	if err := unstructured.SetNestedSlice(obj.Object, endpoints, "spec", "endpoints"); err != nil {
+		return reconcile.Result{}, err
+	}
+	if exists {
+		err = r.client.Update(ctx, obj)
+	} else {
+		err = r.client.Create(ctx, obj)
+	}
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	r.log.Info("update the dns record of the policy", "kind", kind, "policy", key, "dnsEndpoint", name, "ipv4", eip.Ipv4, "ipv6", eip.Ipv6)
+	r.recorder.Eventf(policy, corev1.EventTypeNormal, "DNSRecordUpdated",
+		"the dns record %s points at %s", policy.GetAnnotations()[egressv1.AnnotationDNSName], strings.Join(targets(eip), ","))
+	return reconcile.Result{}, nil
+}
+
+// endpoints returns the endpoints of the DNSEndpoint, the A and AAAA records of the names
+// in the annotation of the policy, which point at the EIP
+func (r *publisher) endpoints(annotations map[string]string, eip egressv1.Eip) ([]interface{}, error) {
+	value := strings.TrimSpace(annotations[egressv1.AnnotationDNSName])
+	if value == "" {
+		return nil, nil
+	}
+	ttl := int64(r.cfg.FileConfig.DNS.RecordTTL)
+	if item, ok := annotations[egressv1.AnnotationDNSTTL]; ok {
+		val, err := strconv.ParseInt(item, 10, 64)
+		if err != nil || val <= 0 {
+			return nil, fmt.Errorf("invalid %s %s", egressv1.AnnotationDNSTTL, item)
+		}
+		ttl = val
+	}
+
+	names := strings.Split(value, ",")
+	sort.Strings(names)
+	res := make([]interface{}, 0)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		for _, record := range []struct {
+			typ    string
+			target string
+		}{{"A", eip.Ipv4}, {"AAAA", eip.Ipv6}} {
+			if record.target == "" {
+				continue
+			}
+			res = append(res, map[string]interface{}{
+				"dnsName":    name,
+				"recordType": record.typ,
+				"recordTTL":  ttl,
+				"targets":    []interface{}{record.target},
+			})
+		}
+	}
+	return res, nil
+}
+
+func targets(eip egressv1.Eip) []string {
+	res := make([]string, 0, 2)
+	if eip.Ipv4 != "" {
+		res = append(res, eip.Ipv4)
+	}
+	if eip.Ipv6 != "" {
+		res = append(res, eip.Ipv6)
+	}
+	return res
+}
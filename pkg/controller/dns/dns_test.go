@@ -0,0 +1,99 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package dns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestPublisher(t *testing.T) {
+	ctx := context.Background()
+	policy := &egressv1.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "policy1",
+			Namespace:   "default",
+			Annotations: map[string]string{egressv1.AnnotationDNSName: "egress.example.com"},
+		},
+		Status: egressv1.EgressPolicyStatus{Eip: egressv1.Eip{Ipv4: "10.6.1.100", Ipv6: "fd00::100"}},
+	}
+	clusterPolicy := &egressv1.EgressClusterPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "cluster-policy",
+			Annotations: map[string]string{
+				egressv1.AnnotationDNSName: "a.example.com,b.example.com",
+				egressv1.AnnotationDNSTTL:  "30",
+			},
+		},
+		Spec:   egressv1.EgressClusterPolicySpec{EgressIP: egressv1.EgressIP{UseNodeIP: true}},
+		Status: egressv1.EgressPolicyStatus{Eip: egressv1.Eip{Ipv4: "10.6.1.101"}, NodeIP: egressv1.Eip{Ipv4: "10.6.0.1"}},
+	}
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(policy, clusterPolicy).Build()
+	cfg := &config.Config{}
+	cfg.PodNamespace = "kube-system"
+	cfg.FileConfig.DNS = config.DNS{Enable: true, RecordTTL: 60}
+	r := &publisher{client: cli, scheme: schema.GetScheme(), log: logr.Discard(), recorder: record.NewFakeRecorder(10), cfg: cfg}
+
+	get := func(key types.NamespacedName) (*unstructured.Unstructured, error) {
+		obj := new(unstructured.Unstructured)
+		obj.SetGroupVersionKind(DNSEndpointGVK)
+		return obj, cli.Get(ctx, key, obj)
+	}
+	reconcilePolicy := func(kind, ns, name string) {
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: kind + "/" + ns, Name: name}})
+		assert.NoError(t, err)
+	}
+
+	reconcilePolicy(kindPolicy, "default", "policy1")
+	obj, err := get(types.NamespacedName{Namespace: "default", Name: "egress-policy1"})
+	assert.NoError(t, err)
+	endpoints, _, _ := unstructured.NestedSlice(obj.Object, "spec", "endpoints")
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"dnsName": "egress.example.com", "recordType": "A", "recordTTL": int64(60), "targets": []interface{}{"10.6.1.100"}},
+		map[string]interface{}{"dnsName": "egress.example.com", "recordType": "AAAA", "recordTTL": int64(60), "targets": []interface{}{"fd00::100"}},
+	}, endpoints)
+	assert.Equal(t, "policy1", obj.GetOwnerReferences()[0].Name)
+
+	// the record follows the EIP of the policy
+	policy.Status.Eip = egressv1.Eip{Ipv4: "10.6.1.102"}
+	assert.NoError(t, cli.Update(ctx, policy))
+	reconcilePolicy(kindPolicy, "default", "policy1")
+	obj, err = get(types.NamespacedName{Namespace: "default", Name: "egress-policy1"})
+	assert.NoError(t, err)
+	endpoints, _, _ = unstructured.NestedSlice(obj.Object, "spec", "endpoints")
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"dnsName": "egress.example.com", "recordType": "A", "recordTTL": int64(60), "targets": []interface{}{"10.6.1.102"}},
+	}, endpoints)
+
+	// the cluster policy using the node IP
+	reconcilePolicy(kindClusterPolicy, "", "cluster-policy")
+	obj, err = get(types.NamespacedName{Namespace: "kube-system", Name: "egress-cluster-cluster-policy"})
+	assert.NoError(t, err)
+	endpoints, _, _ = unstructured.NestedSlice(obj.Object, "spec", "endpoints")
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"dnsName": "a.example.com", "recordType": "A", "recordTTL": int64(30), "targets": []interface{}{"10.6.0.1"}},
+		map[string]interface{}{"dnsName": "b.example.com", "recordType": "A", "recordTTL": int64(30), "targets": []interface{}{"10.6.0.1"}},
+	}, endpoints)
+
+	// the record is removed with the annotation
+	policy.Annotations = nil
+	assert.NoError(t, cli.Update(ctx, policy))
+	reconcilePolicy(kindPolicy, "default", "policy1")
+	_, err = get(types.NamespacedName{Namespace: "default", Name: "egress-policy1"})
+	assert.True(t, k8serr.IsNotFound(err))
+}
@@ -6,17 +6,23 @@ package egressclusterinfo
 import (
 	"context"
 	"fmt"
+	"net"
 	"reflect"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
@@ -24,6 +30,7 @@ import (
 
 	calicov1 "github.com/tigera/operator/pkg/apis/crd.projectcalico.org/v1"
 
+	egresserrors "github.com/spidernet-io/egressgateway/pkg/errors"
 	egressv1beta1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
 	"github.com/spidernet-io/egressgateway/pkg/lock"
 	"github.com/spidernet-io/egressgateway/pkg/utils"
@@ -36,10 +43,12 @@ type eciReconciler struct {
 	isWatchCalico                atomic.Bool // whether controller need to watch calico
 	isWatchingCalico             atomic.Bool // whether controller is watching calico
 	isWatchingNode               atomic.Bool // whether controller need to watch node
+	isWatchingClusterCIDR        atomic.Bool // whether the ClusterCIDR API is served and watched
 	k8sPodCidr                   map[string]egressv1beta1.IPListPair
 	v4ClusterCidr, v6ClusterCidr []string
 	eci                          *egressv1beta1.EgressClusterInfo
 	client                       client.Client
+	reader                       client.Reader // reads Pods which may not be cached by the client
 	log                          logr.Logger
 	eciMutex                     lock.RWMutex
 }
@@ -55,15 +64,36 @@ const (
 	kindNode         = "Node"
 	kindCalicoIPPool = "CalicoIPPool"
 	kindEGCI         = "EGCI"
+	kindKCM          = "KubeControllerManager"
+	kindClusterCIDR  = "ClusterCIDR"
 )
 
+// clusterCIDRPrefix and nodePodCIDRPrefix prefix the names of the ClusterCIDRs and the
+// nodes in status.podCIDR, to tell them from the one of the kube-controller-manager
+const (
+	clusterCIDRPrefix = "clustercidr-"
+	nodePodCIDRPrefix = "node-"
+)
+
+// clusterCIDRGVK is the ClusterCIDR API served from Kubernetes 1.25 to 1.28 behind the
+// MultiCIDRRangeAllocator feature gate, it's accessed as unstructured objects as its
+// types are removed from the client
+var clusterCIDRGVK = schema.GroupVersionKind{Group: "networking.k8s.io", Version: "v1alpha1", Kind: "ClusterCIDR"}
+
 var kubeControllerManagerPodLabel = map[string]string{"component": "kube-controller-manager"}
 
+// KubeControllerManagerSelector returns the label selector of the kube-controller-manager
+// Pods, which are the only Pods watched by the controller
+func KubeControllerManagerSelector() labels.Selector {
+	return labels.SelectorFromSet(kubeControllerManagerPodLabel)
+}
+
 func NewEgressClusterInfoController(mgr manager.Manager, log logr.Logger) error {
 	r := &eciReconciler{
 		mgr:           mgr,
 		eci:           new(egressv1beta1.EgressClusterInfo),
 		client:        mgr.GetClient(),
+		reader:        mgr.GetAPIReader(),
 		log:           log,
 		k8sPodCidr:    make(map[string]egressv1beta1.IPListPair),
 		v4ClusterCidr: make([]string, 0),
@@ -72,14 +102,37 @@ func NewEgressClusterInfoController(mgr manager.Manager, log logr.Logger) error
 
 	log.Info("new egressClusterInfo controller")
 	c, err := controller.New("egressClusterInfo", mgr,
-		controller.Options{Reconciler: r})
+		controller.Options{Reconciler: egresserrors.NewReconciler("egressClusterInfo", r)})
 	if err != nil {
 		return err
 	}
 	r.c = c
 
 	log.Info("egressClusterInfo controller watch EgressClusterInfo")
-	return watchSource(c, source.Kind(mgr.GetCache(), &egressv1beta1.EgressClusterInfo{}), kindEGCI)
+	if err := watchSource(c, source.Kind(mgr.GetCache(), &egressv1beta1.EgressClusterInfo{}), kindEGCI); err != nil {
+		return err
+	}
+
+	// the service CIDR and the pod CIDR are parsed from the arguments of the
+	// kube-controller-manager, watch it to pick up the changes without restart
+	log.Info("egressClusterInfo controller watch kube-controller-manager")
+	isKCM := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return labels.SelectorFromSet(kubeControllerManagerPodLabel).Matches(labels.Set(obj.GetLabels()))
+	})
+	if err := watchSource(c, source.Kind(mgr.GetCache(), &corev1.Pod{}), kindKCM, isKCM); err != nil {
+		return err
+	}
+
+	if _, err := mgr.GetRESTMapper().RESTMapping(clusterCIDRGVK.GroupKind(), clusterCIDRGVK.Version); err == nil {
+		log.Info("egressClusterInfo controller watch ClusterCIDR")
+		clusterCIDR := new(unstructured.Unstructured)
+		clusterCIDR.SetGroupVersionKind(clusterCIDRGVK)
+		if err := watchSource(c, source.Kind(mgr.GetCache(), clusterCIDR), kindClusterCIDR); err != nil {
+			return err
+		}
+		r.isWatchingClusterCIDR.Store(true)
+	}
+	return nil
 }
 
 // Reconcile support to reconcile of nodes, calicoIPPool and egressClusterInfo
@@ -111,6 +164,13 @@ func (r *eciReconciler) Reconcile(ctx context.Context, req reconcile.Request) (r
 		err = r.reconcileCalicoIPPool(ctx, newReq, log)
 	case kindEGCI:
 		err = r.reconcileEgressClusterInfo(ctx, newReq, log)
+	case kindKCM:
+		err = r.reconcileKubeControllerManager(ctx, newReq, log)
+	case kindClusterCIDR:
+		if r.eci.Status.PodCidrMode != egressv1beta1.CniTypeK8s {
+			return reconcile.Result{}, nil
+		}
+		err = r.reconcileClusterCIDR(ctx, newReq, log)
 	default:
 		return reconcile.Result{}, nil
 	}
@@ -140,12 +200,11 @@ func (r *eciReconciler) reconcileEgressClusterInfo(ctx context.Context, req reco
 
 	// ignore nodeIP
 	if r.eci.Spec.AutoDetect.NodeIP {
-		if !r.isWatchingNode.Load() {
-			// need watch node
-			if err := watchSource(r.c, source.Kind(r.mgr.GetCache(), &corev1.Node{}), kindNode); err != nil {
-				return err
-			}
-			r.isWatchingNode.Store(true)
+		started, err := r.watchNode()
+		if err != nil {
+			return err
+		}
+		if started || r.eci.Status.NodeIP == nil {
 			// need to list all node
 			nodesIP, err := r.listNodeIPs(ctx)
 			if err != nil {
@@ -186,14 +245,15 @@ func (r *eciReconciler) reconcileEgressClusterInfo(ctx context.Context, req reco
 			r.eci.Status.PodCidrMode = egressv1beta1.CniTypeCalico
 		}
 	case egressv1beta1.CniTypeK8s:
-		if _, ok := r.k8sPodCidr[k8s]; !ok {
-			cidr, err := r.getK8sPodCidr()
-			if err != nil {
-				return err
-			}
-			r.k8sPodCidr = cidr
+		podCIDR, err := r.k8sPodCIDRs(ctx)
+		if err != nil {
+			return err
+		}
+		// the podCIDRs of the nodes are watched
+		if _, err := r.watchNode(); err != nil {
+			return err
 		}
-		r.eci.Status.PodCIDR = r.k8sPodCidr
+		r.eci.Status.PodCIDR = podCIDR
 		r.eci.Status.PodCidrMode = egressv1beta1.CniTypeK8s
 	case egressv1beta1.CniTypeEmpty:
 		r.eci.Status.PodCIDR = nil
@@ -230,6 +290,162 @@ func (r *eciReconciler) reconcileEgressClusterInfo(ctx context.Context, req reco
 	return nil
 }
 
+// reconcileKubeControllerManager parses the pod CIDR and the service CIDR again once the
+// kube-controller-manager is changed, such as its arguments are updated
+func (r *eciReconciler) reconcileKubeControllerManager(ctx context.Context, req reconcile.Request, log logr.Logger) error {
+	log = log.WithValues("name", req.Name, "namespace", req.Namespace)
+	log.Info("reconciling")
+
+	if r.eci.Status.PodCidrMode == egressv1beta1.CniTypeK8s {
+		cidr, err := r.getK8sPodCidr()
+		if err != nil {
+			return err
+		}
+		r.k8sPodCidr = cidr
+		podCIDR, err := r.k8sPodCIDRs(ctx)
+		if err != nil {
+			return err
+		}
+		r.eci.Status.PodCIDR = podCIDR
+	}
+
+	if r.eci.Spec.AutoDetect.ClusterIP {
+		v4Cidr, v6Cidr, err := r.getServiceClusterIPRange()
+		if err != nil {
+			return err
+		}
+		r.v4ClusterCidr = v4Cidr
+		r.v6ClusterCidr = v6Cidr
+		r.eci.Status.ClusterIP = &egressv1beta1.IPListPair{IPv4: v4Cidr, IPv6: v6Cidr}
+	}
+	return nil
+}
+
+// reconcileClusterCIDR reconcile the ClusterCIDR, which adds the pod CIDRs of the nodes
+// besides the one of the kube-controller-manager. The podCIDRs of the nodes are checked
+// again, as they may be covered by the ClusterCIDR or not anymore.
+func (r *eciReconciler) reconcileClusterCIDR(ctx context.Context, req reconcile.Request, log logr.Logger) error {
+	log = log.WithValues("name", req.Name)
+	log.Info("reconciling")
+
+	podCIDR, err := r.k8sPodCIDRs(ctx)
+	if err != nil {
+		return err
+	}
+	r.eci.Status.PodCIDR = podCIDR
+	return nil
+}
+
+// k8sPodCIDRs returns the pod CIDRs of the k8s mode, which are the one of the
+// kube-controller-manager, the ones of the ClusterCIDRs if the API is served, and the
+// podCIDRs of the nodes not in them, such as the ones allocated manually
+func (r *eciReconciler) k8sPodCIDRs(ctx context.Context) (map[string]egressv1beta1.IPListPair, error) {
+	if _, ok := r.k8sPodCidr[k8s]; !ok {
+		cidr, err := r.getK8sPodCidr()
+		if err != nil {
+			return nil, err
+		}
+		r.k8sPodCidr = cidr
+	}
+	res := make(map[string]egressv1beta1.IPListPair, len(r.k8sPodCidr))
+	for key, val := range r.k8sPodCidr {
+		res[key] = val
+	}
+
+	if r.isWatchingClusterCIDR.Load() {
+		list := new(unstructured.UnstructuredList)
+		list.SetGroupVersionKind(clusterCIDRGVK.GroupVersion().WithKind(clusterCIDRGVK.Kind + "List"))
+		if err := r.client.List(ctx, list); err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			if !list.Items[i].GetDeletionTimestamp().IsZero() {
+				continue
+			}
+			res[clusterCIDRPrefix+list.Items[i].GetName()] = parseClusterCIDR(&list.Items[i])
+		}
+	}
+
+	nodeList := new(corev1.NodeList)
+	if err := r.client.List(ctx, nodeList); err != nil {
+		return nil, err
+	}
+	covering := coveringPodCIDRs(res)
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		if !node.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+		if pair, ok := uncoveredNodePodCIDRs(node, covering); ok {
+			res[nodePodCIDRPrefix+node.Name] = pair
+		}
+	}
+	return res, nil
+}
+
+// coveringPodCIDRs parses the pod CIDRs other than the ones of the nodes
+func coveringPodCIDRs(podCIDR map[string]egressv1beta1.IPListPair) []*net.IPNet {
+	res := make([]*net.IPNet, 0)
+	for key, pair := range podCIDR {
+		if strings.HasPrefix(key, nodePodCIDRPrefix) {
+			continue
+		}
+		for _, item := range append(append([]string{}, pair.IPv4...), pair.IPv6...) {
+			if _, cidr, err := net.ParseCIDR(item); err == nil {
+				res = append(res, cidr)
+			}
+		}
+	}
+	return res
+}
+
+// uncoveredNodePodCIDRs returns the podCIDRs of the node not in any of the covering
+// CIDRs, it returns false if there is none
+func uncoveredNodePodCIDRs(node *corev1.Node, covering []*net.IPNet) (egressv1beta1.IPListPair, bool) {
+	podCIDRs := node.Spec.PodCIDRs
+	if len(podCIDRs) == 0 && node.Spec.PodCIDR != "" {
+		podCIDRs = []string{node.Spec.PodCIDR}
+	}
+
+	pair := egressv1beta1.IPListPair{}
+	for _, item := range podCIDRs {
+		_, cidr, err := net.ParseCIDR(item)
+		if err != nil {
+			continue
+		}
+		ones, bits := cidr.Mask.Size()
+		isCovered := false
+		for _, c := range covering {
+			cOnes, cBits := c.Mask.Size()
+			if cBits == bits && cOnes <= ones && c.Contains(cidr.IP) {
+				isCovered = true
+				break
+			}
+		}
+		if isCovered {
+			continue
+		}
+		if cidr.IP.To4() != nil {
+			pair.IPv4 = append(pair.IPv4, cidr.String())
+		} else {
+			pair.IPv6 = append(pair.IPv6, cidr.String())
+		}
+	}
+	return pair, len(pair.IPv4)+len(pair.IPv6) > 0
+}
+
+// parseClusterCIDR returns the IPv4 and IPv6 CIDRs of the ClusterCIDR
+func parseClusterCIDR(obj *unstructured.Unstructured) egressv1beta1.IPListPair {
+	pair := egressv1beta1.IPListPair{}
+	if cidr, _, _ := unstructured.NestedString(obj.Object, "spec", "ipv4"); cidr != "" {
+		pair.IPv4 = []string{cidr}
+	}
+	if cidr, _, _ := unstructured.NestedString(obj.Object, "spec", "ipv6"); cidr != "" {
+		pair.IPv6 = []string{cidr}
+	}
+	return pair
+}
+
 // reconcileCalicoIPPool reconcile calico IPPool
 func (r *eciReconciler) reconcileCalicoIPPool(ctx context.Context, req reconcile.Request, log logr.Logger) error {
 	log = log.WithValues("name", req.Name, "namespace", req.Namespace)
@@ -286,9 +502,13 @@ func (r *eciReconciler) reconcileNode(ctx context.Context, req reconcile.Request
 	if deleted {
 		log.Info("delete event of node", "delete", req.Name)
 		delete(r.eci.Status.NodeIP, req.Name)
-	} else {
-		// not delete event
-		log.Info("update event of node", "update", req.Name)
+		delete(r.eci.Status.PodCIDR, nodePodCIDRPrefix+req.Name)
+		return nil
+	}
+
+	// not delete event
+	log.Info("update event of node", "update", req.Name)
+	if r.eci.Spec.AutoDetect.NodeIP {
 		nodeIPMap, err := r.getNodeIPs(ctx, req.Name)
 		if err != nil {
 			return err
@@ -299,9 +519,33 @@ func (r *eciReconciler) reconcileNode(ctx context.Context, req reconcile.Request
 		r.eci.Status.NodeIP[req.Name] = nodeIPMap[req.Name]
 	}
 
+	// the podCIDRs of the node not in the pod CIDRs of the cluster
+	if r.eci.Status.PodCidrMode == egressv1beta1.CniTypeK8s {
+		key := nodePodCIDRPrefix + req.Name
+		if pair, ok := uncoveredNodePodCIDRs(node, coveringPodCIDRs(r.eci.Status.PodCIDR)); ok {
+			if r.eci.Status.PodCIDR == nil {
+				r.eci.Status.PodCIDR = make(map[string]egressv1beta1.IPListPair)
+			}
+			r.eci.Status.PodCIDR[key] = pair
+		} else {
+			delete(r.eci.Status.PodCIDR, key)
+		}
+	}
 	return nil
 }
 
+// watchNode starts to watch the nodes if not yet, it returns true if it's started
+func (r *eciReconciler) watchNode() (bool, error) {
+	if r.isWatchingNode.Load() {
+		return false, nil
+	}
+	if err := watchSource(r.c, source.Kind(r.mgr.GetCache(), &corev1.Node{}), kindNode); err != nil {
+		return false, err
+	}
+	r.isWatchingNode.Store(true)
+	return true, nil
+}
+
 // listCalicoIPPools list all calico ippools
 func (r *eciReconciler) listCalicoIPPools(ctx context.Context) (map[string]egressv1beta1.IPListPair, error) {
 	ippoolList := new(calicov1.IPPoolList)
@@ -377,14 +621,7 @@ func (r *eciReconciler) listNodeIPs(ctx context.Context) (map[string]egressv1bet
 	}
 
 	for _, item := range nodeList.Items {
-		var ipv4s, ipv6s []string
-		nodeIPv4, nodeIPv6 := utils.GetNodeIP(&item)
-		if len(nodeIPv4) != 0 {
-			ipv4s = []string{nodeIPv4}
-		}
-		if len(nodeIPv6) != 0 {
-			ipv6s = []string{nodeIPv6}
-		}
+		ipv4s, ipv6s := utils.GetNodeIPs(&item)
 		nodesIPMap[item.Name] = egressv1beta1.IPListPair{IPv4: ipv4s, IPv6: ipv6s}
 	}
 	return nodesIPMap, nil
@@ -400,14 +637,7 @@ func (r *eciReconciler) getNodeIPs(ctx context.Context, nodeName string) (map[st
 		return nil, err
 	}
 
-	nodeIPv4, nodeIPv6 := utils.GetNodeIP(node)
-	var ipv4s, ipv6s []string
-	if len(nodeIPv4) != 0 {
-		ipv4s = []string{nodeIPv4}
-	}
-	if len(nodeIPv6) != 0 {
-		ipv6s = []string{nodeIPv6}
-	}
+	ipv4s, ipv6s := utils.GetNodeIPs(node)
 	nodesIPMap[nodeName] = egressv1beta1.IPListPair{IPv4: ipv4s, IPv6: ipv6s}
 	return nodesIPMap, nil
 }
@@ -417,9 +647,17 @@ func (r *eciReconciler) getEgressClusterInfo(ctx context.Context) error {
 	return r.client.Get(ctx, types.NamespacedName{Name: defaultEgressClusterInfoName}, r.eci)
 }
 
+// podReader returns the reader of Pods, the cached Pods are stripped of the spec
+func (r *eciReconciler) podReader() client.Reader {
+	if r.reader != nil {
+		return r.reader
+	}
+	return r.client
+}
+
 // getServiceClusterIPRange get service-cluster-ip-range from kube controller manager
 func (r *eciReconciler) getServiceClusterIPRange() (ipv4Range, ipv6Range []string, err error) {
-	pod, err := GetPodByLabel(r.client, kubeControllerManagerPodLabel)
+	pod, err := GetPodByLabel(r.podReader(), kubeControllerManagerPodLabel)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -428,7 +666,7 @@ func (r *eciReconciler) getServiceClusterIPRange() (ipv4Range, ipv6Range []strin
 
 // getK8sPodCidr get k8s default podCidr
 func (r *eciReconciler) getK8sPodCidr() (map[string]egressv1beta1.IPListPair, error) {
-	v4Cidr, v6Cidr, err := GetClusterCidr(r.client)
+	v4Cidr, v6Cidr, err := GetClusterCidr(r.podReader())
 	if err != nil {
 		return nil, err
 	}
@@ -511,14 +749,21 @@ func (r *eciReconciler) checkSomeCniExists() error {
 		return err
 	}
 	r.k8sPodCidr = k8sCidr
-	r.eci.Status.PodCIDR = k8sCidr
+	podCIDR, err := r.k8sPodCIDRs(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := r.watchNode(); err != nil {
+		return err
+	}
+	r.eci.Status.PodCIDR = podCIDR
 	r.eci.Status.PodCidrMode = egressv1beta1.CniTypeK8s
 	return nil
 }
 
 // watchSource controller watch given resource
-func watchSource(c controller.Controller, source source.Source, kind string) error {
-	if err := c.Watch(source, handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat(kind))); err != nil {
+func watchSource(c controller.Controller, source source.Source, kind string, predicates ...predicate.Predicate) error {
+	if err := c.Watch(source, handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat(kind)), predicates...); err != nil {
 		return fmt.Errorf("failed to watch %s: %w", kind, err)
 	}
 	return nil
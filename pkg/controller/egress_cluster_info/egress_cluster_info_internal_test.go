@@ -12,6 +12,7 @@ import (
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -298,6 +299,7 @@ var _ = Describe("EgressClusterInfo", Serial, Label("EgressClusterInfo UT"), fun
 				egci.Spec.AutoDetect.PodCidrMode = egressv1.CniTypeK8s
 
 				// set eciReconciler
+				r.isWatchingNode.Store(true)
 				objs = []client.Object{controllerManagerPod, egci}
 				builder.WithObjects(objs...)
 				builder.WithStatusSubresource(objs...)
@@ -310,6 +312,7 @@ var _ = Describe("EgressClusterInfo", Serial, Label("EgressClusterInfo UT"), fun
 				egci.Spec.AutoDetect.PodCidrMode = egressv1.CniTypeK8s
 
 				// set eciReconciler
+				r.isWatchingNode.Store(true)
 				objs = []client.Object{controllerManagerPodV4, egci}
 				builder.WithObjects(objs...)
 				builder.WithStatusSubresource(objs...)
@@ -322,6 +325,7 @@ var _ = Describe("EgressClusterInfo", Serial, Label("EgressClusterInfo UT"), fun
 				egci.Spec.AutoDetect.PodCidrMode = egressv1.CniTypeK8s
 
 				// set eciReconciler
+				r.isWatchingNode.Store(true)
 				objs = []client.Object{controllerManagerPodV6, egci}
 				builder.WithObjects(objs...)
 				builder.WithStatusSubresource(objs...)
@@ -431,6 +435,144 @@ var _ = Describe("EgressClusterInfo", Serial, Label("EgressClusterInfo UT"), fun
 
 	})
 
+	// reconcileKubeControllerManager
+	Context("reconcileKubeControllerManager", func() {
+		It("will update the cidrs when the arguments changed", func() {
+			egci.Spec.AutoDetect.ClusterIP = true
+			egci.Status.PodCidrMode = egressv1.CniTypeK8s
+
+			// set client
+			objs = []client.Object{egci, controllerManagerPodV4}
+			builder.WithObjects(objs...)
+			builder.WithStatusSubresource(objs...)
+			r.client = builder.Build()
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{
+				Namespace: kindKCM + "/" + controllerManagerPodV4.Namespace, Name: controllerManagerPodV4.Name}}
+			res, err := r.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res).To(Equal(reconcile.Result{}))
+			Expect(r.eci.Status.PodCIDR[k8s].IPv4).To(Equal([]string{"172.40.0.0/16"}))
+			Expect(r.eci.Status.PodCIDR[k8s].IPv6).To(BeEmpty())
+			Expect(r.eci.Status.ClusterIP.IPv4).To(Equal([]string{"172.41.0.0/16"}))
+			Expect(r.eci.Status.ClusterIP.IPv6).To(BeEmpty())
+
+			// the kube-controller-manager is updated to dual stack
+			pod := controllerManagerPodV4.DeepCopy()
+			Expect(r.client.Get(ctx, client.ObjectKeyFromObject(pod), pod)).NotTo(HaveOccurred())
+			pod.Spec.Containers = controllerManagerPod.Spec.Containers
+			Expect(r.client.Update(ctx, pod)).NotTo(HaveOccurred())
+
+			res, err = r.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res).To(Equal(reconcile.Result{}))
+			Expect(r.eci.Status.PodCIDR).To(Equal(map[string]egressv1.IPListPair{k8s: {IPv4: []string{"172.40.0.0/16"}, IPv6: []string{"fd40::/48"}}}))
+			Expect(r.eci.Status.ClusterIP).To(Equal(&egressv1.IPListPair{IPv4: []string{"172.41.0.0/16"}, IPv6: []string{"fd41::/108"}}))
+		})
+
+		It("will fail without kube-controller-manager", func() {
+			egci.Spec.AutoDetect.ClusterIP = true
+
+			// set client
+			objs = append(objs, egci)
+			builder.WithObjects(objs...)
+			builder.WithStatusSubresource(objs...)
+			r.client = builder.Build()
+
+			res, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: kindKCM + "/kube-system", Name: "xxx"}})
+			Expect(err).To(HaveOccurred())
+			Expect(res).To(Equal(reconcile.Result{Requeue: true}))
+		})
+	})
+
+	// reconcileClusterCIDR
+	Context("reconcileClusterCIDR", func() {
+		It("will add and delete the cidrs of the ClusterCIDR", func() {
+			egci.Status.PodCidrMode = egressv1.CniTypeK8s
+			clusterCIDR := new(unstructured.Unstructured)
+			clusterCIDR.SetGroupVersionKind(clusterCIDRGVK)
+			clusterCIDR.SetName("extra")
+			Expect(unstructured.SetNestedField(clusterCIDR.Object, "10.20.0.0/16", "spec", "ipv4")).NotTo(HaveOccurred())
+
+			// set client
+			objs = []client.Object{egci, clusterCIDR}
+			builder.WithObjects(objs...)
+			builder.WithStatusSubresource(egci)
+			r.client = builder.Build()
+			r.k8sPodCidr = map[string]egressv1.IPListPair{k8s: {IPv4: []string{"172.40.0.0/16"}}}
+			r.isWatchingClusterCIDR.Store(true)
+
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: kindClusterCIDR + "/", Name: "extra"}}
+			res, err := r.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res).To(Equal(reconcile.Result{}))
+			Expect(r.eci.Status.PodCIDR).To(HaveKeyWithValue(clusterCIDRPrefix+"extra", egressv1.IPListPair{IPv4: []string{"10.20.0.0/16"}}))
+
+			Expect(r.client.Delete(ctx, clusterCIDR)).NotTo(HaveOccurred())
+			res, err = r.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res).To(Equal(reconcile.Result{}))
+			Expect(r.eci.Status.PodCIDR).NotTo(HaveKey(clusterCIDRPrefix + "extra"))
+		})
+
+		It("will add the podCIDRs of the nodes not in the ClusterCIDRs", func() {
+			egci.Status.PodCidrMode = egressv1.CniTypeK8s
+			clusterCIDR := new(unstructured.Unstructured)
+			clusterCIDR.SetGroupVersionKind(clusterCIDRGVK)
+			clusterCIDR.SetName("extra")
+			Expect(unstructured.SetNestedField(clusterCIDR.Object, "10.20.0.0/16", "spec", "ipv4")).NotTo(HaveOccurred())
+			node := testNode.DeepCopy()
+			node.Spec.PodCIDRs = []string{"10.20.1.0/24", "fd50::/64"}
+
+			// set client
+			objs = []client.Object{egci, node}
+			builder.WithObjects(objs...)
+			builder.WithStatusSubresource(egci)
+			r.client = builder.Build()
+			r.k8sPodCidr = map[string]egressv1.IPListPair{k8s: {IPv4: []string{"172.40.0.0/16"}}}
+			r.isWatchingClusterCIDR.Store(true)
+
+			// the podCIDRs of the node are not in the pod CIDRs of the cluster
+			nodeReq := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: kindNode + "/", Name: node.Name}}
+			res, err := r.Reconcile(ctx, nodeReq)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res).To(Equal(reconcile.Result{}))
+			Expect(r.eci.Status.PodCIDR).To(HaveKeyWithValue(nodePodCIDRPrefix+node.Name,
+				egressv1.IPListPair{IPv4: []string{"10.20.1.0/24"}, IPv6: []string{"fd50::/64"}}))
+			Expect(r.eci.Status.NodeIP).To(BeNil())
+
+			// the IPv4 podCIDR is covered by the ClusterCIDR
+			Expect(r.client.Create(ctx, clusterCIDR)).NotTo(HaveOccurred())
+			res, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: kindClusterCIDR + "/", Name: "extra"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res).To(Equal(reconcile.Result{}))
+			Expect(r.eci.Status.PodCIDR).To(HaveKeyWithValue(nodePodCIDRPrefix+node.Name,
+				egressv1.IPListPair{IPv6: []string{"fd50::/64"}}))
+
+			// the node is deleted
+			Expect(r.client.Delete(ctx, node)).NotTo(HaveOccurred())
+			res, err = r.Reconcile(ctx, nodeReq)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res).To(Equal(reconcile.Result{}))
+			Expect(r.eci.Status.PodCIDR).NotTo(HaveKey(nodePodCIDRPrefix + node.Name))
+		})
+
+		It("will ignore the ClusterCIDR when the podCidrMode is not k8s", func() {
+			egci.Status.PodCidrMode = egressv1.CniTypeCalico
+
+			// set client
+			objs = append(objs, egci)
+			builder.WithObjects(objs...)
+			builder.WithStatusSubresource(objs...)
+			r.client = builder.Build()
+
+			res, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: kindClusterCIDR + "/", Name: "extra"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res).To(Equal(reconcile.Result{}))
+			Expect(r.eci.Status.PodCIDR).To(BeNil())
+		})
+	})
+
 	// reconcileNode
 	Context("reconcileNode", func() {
 		It("will success when delete event", func() {
@@ -51,7 +51,7 @@ func ParseCidrFromControllerManager(pod *corev1.Pod, param string) (ipv4Range, i
 }
 
 // GetPodByLabel get pod by label
-func GetPodByLabel(c client.Client, label map[string]string) (*corev1.Pod, error) {
+func GetPodByLabel(c client.Reader, label map[string]string) (*corev1.Pod, error) {
 	podList := corev1.PodList{}
 	opts := client.MatchingLabels(label)
 	err := c.List(context.Background(), &podList, opts)
@@ -66,7 +66,7 @@ func GetPodByLabel(c client.Client, label map[string]string) (*corev1.Pod, error
 }
 
 // GetClusterCidr get k8s default podCidr
-func GetClusterCidr(c client.Client) (ipv4Range, ipv6Range []string, err error) {
+func GetClusterCidr(c client.Reader) (ipv4Range, ipv6Range []string, err error) {
 	pod, err := GetPodByLabel(c, kubeControllerManagerPodLabel)
 	if err != nil {
 		return nil, nil, err
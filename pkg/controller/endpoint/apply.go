@@ -0,0 +1,70 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package endpoint
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils/apply"
+)
+
+// sliceFields is the fields of the endpoint slices owned by the endpoint controllers
+var sliceFields = apply.Fields{
+	"f:metadata": apply.Fields{
+		"f:labels":          apply.Fields{},
+		"f:ownerReferences": apply.Fields{},
+	},
+	"f:endpoints": apply.Fields{},
+}
+
+func newSliceMigrator(mgr manager.Manager) *apply.Migrator {
+	return apply.NewMigrator(mgr.GetAPIReader(), mgr.GetClient(), apply.FieldOwnerEndpoint, "", sliceFields)
+}
+
+// applySlice applies the endpoints of the slice by the server-side apply, the slice
+// updated by get-modify-update before is migrated first
+func applySlice(ctx context.Context, cli client.Client, migrator *apply.Migrator, slice client.Object) error {
+	if migrator != nil {
+		if err := migrator.Migrate(ctx, slice); err != nil {
+			return err
+		}
+	}
+	meta := metav1.ObjectMeta{
+		Namespace:       slice.GetNamespace(),
+		Name:            slice.GetName(),
+		Labels:          slice.GetLabels(),
+		OwnerReferences: slice.GetOwnerReferences(),
+	}
+	var obj client.Object
+	switch item := slice.(type) {
+	case *v1beta1.EgressEndpointSlice:
+		obj = &v1beta1.EgressEndpointSlice{
+			TypeMeta:   metav1.TypeMeta{APIVersion: v1beta1.GroupVersion.String(), Kind: "EgressEndpointSlice"},
+			ObjectMeta: meta,
+			Endpoints:  item.Endpoints,
+		}
+	case *v1beta1.EgressClusterEndpointSlice:
+		obj = &v1beta1.EgressClusterEndpointSlice{
+			TypeMeta:   metav1.TypeMeta{APIVersion: v1beta1.GroupVersion.String(), Kind: "EgressClusterEndpointSlice"},
+			ObjectMeta: meta,
+			Endpoints:  item.Endpoints,
+		}
+	default:
+		return nil
+	}
+	return apply.Object(ctx, cli, obj, apply.FieldOwnerEndpoint)
+}
+
+// deleteSlice deletes the slice and forgets its migration
+func deleteSlice(ctx context.Context, cli client.Client, migrator *apply.Migrator, slice client.Object) error {
+	if migrator != nil {
+		migrator.Forget(slice.GetUID())
+	}
+	return cli.Delete(ctx, slice)
+}
@@ -0,0 +1,272 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package endpoint
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+var (
+	counterAudited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "egress_endpoint_audit_checked_total",
+		Help: "Number of policies whose endpoint slices are checked by the audit",
+	}, []string{"controller"})
+	counterCorrections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "egress_endpoint_audit_corrections_total",
+		Help: "Number of policies whose endpoint slices drift from the Pods and are reconciled again by the audit",
+	}, []string{"controller"})
+)
+
+func MetricCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		counterAudited,
+		counterCorrections,
+	}
+}
+
+// sliceAuditor checks the endpoint slices of a few policies in turn periodically, and
+// enqueues the policies whose slices drift from the Pods, which may happen when an event
+// is lost, instead of reconciling all policies by the resync of the cache
+type sliceAuditor struct {
+	name       string
+	log        logr.Logger
+	interval   time.Duration
+	sampleSize int
+
+	// list returns the keys of all policies
+	list func(ctx context.Context) ([]types.NamespacedName, error)
+	// drift returns true if the endpoint slices of the policy drift from the Pods
+	drift func(ctx context.Context, key types.NamespacedName) (bool, error)
+	// newObject returns the policy of the key to enqueue
+	newObject func(key types.NamespacedName) client.Object
+
+	cursor int
+	events chan event.GenericEvent
+}
+
+func newSliceAuditor(name string, log logr.Logger, cfg config.EndpointAudit) *sliceAuditor {
+	return &sliceAuditor{
+		name:       name,
+		log:        log.WithName("audit"),
+		interval:   time.Second * time.Duration(cfg.IntervalSecond),
+		sampleSize: cfg.SampleSize,
+		events:     make(chan event.GenericEvent),
+	}
+}
+
+// Start audits the endpoint slices every interval until the context is done
+func (a *sliceAuditor) Start(ctx context.Context) error {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := a.audit(ctx); err != nil {
+				a.log.Error(err, "failed to audit endpoint slices")
+			}
+		}
+	}
+}
+
+// audit checks the next sampleSize policies, and enqueues the drifted ones
+func (a *sliceAuditor) audit(ctx context.Context) error {
+	keys, err := a.list(ctx)
+	if err != nil {
+		return err
+	}
+	for _, key := range a.sample(keys) {
+		drift, err := a.drift(ctx, key)
+		if err != nil {
+			a.log.Error(err, "failed to audit endpoint slices", "policy", key)
+			continue
+		}
+		counterAudited.WithLabelValues(a.name).Inc()
+		if !drift {
+			continue
+		}
+		a.log.Info("endpoint slices drift from the Pods, reconcile them again", "policy", key)
+		counterCorrections.WithLabelValues(a.name).Inc()
+		select {
+		case a.events <- event.GenericEvent{Object: a.newObject(key)}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// sample returns the sampleSize keys after the last audited one, so all policies are
+// audited in turn
+func (a *sliceAuditor) sample(keys []types.NamespacedName) []types.NamespacedName {
+	if len(keys) <= a.sampleSize {
+		return keys
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	start := a.cursor % len(keys)
+	res := make([]types.NamespacedName, 0, a.sampleSize)
+	for i := 0; i < a.sampleSize; i++ {
+		res = append(res, keys[(start+i)%len(keys)])
+	}
+	a.cursor = (start + a.sampleSize) % len(keys)
+	return res
+}
+
+// newPolicyAuditor returns the auditor of the endpoint slices of the EgressPolicies
+func newPolicyAuditor(name string, cli client.Client, log logr.Logger, cfg *config.Config) *sliceAuditor {
+	a := newSliceAuditor(name, log, cfg.FileConfig.EndpointReconcile.Audit)
+	a.list = func(ctx context.Context) ([]types.NamespacedName, error) {
+		policies := new(v1beta1.EgressPolicyList)
+		if err := cli.List(ctx, policies); err != nil {
+			return nil, err
+		}
+		res := make([]types.NamespacedName, 0, len(policies.Items))
+		for _, item := range policies.Items {
+			res = append(res, types.NamespacedName{Namespace: item.Namespace, Name: item.Name})
+		}
+		return res, nil
+	}
+	a.drift = func(ctx context.Context, key types.NamespacedName) (bool, error) {
+		policy := new(v1beta1.EgressPolicy)
+		if err := cli.Get(ctx, key, policy); err != nil {
+			return false, client.IgnoreNotFound(err)
+		}
+		if !policy.DeletionTimestamp.IsZero() {
+			return false, nil
+		}
+		pods, err := listPodsByPolicy(ctx, cli, policy)
+		if err != nil {
+			return false, err
+		}
+		pods.Items, err = filterRolloutPods(policy, pods.Items)
+		if err != nil {
+			return false, err
+		}
+		slices, err := listEndpointSlices(ctx, cli, policy.Namespace, policy.Name)
+		if err != nil {
+			return false, err
+		}
+		actual := make([]v1beta1.EgressEndpoint, 0)
+		for _, item := range slices.Items {
+			actual = append(actual, item.Endpoints...)
+		}
+		return endpointsDrift(expectedEndpoints(pods.Items, policy.Spec.AppliedTo.StaticEndpoints), actual), nil
+	}
+	a.newObject = func(key types.NamespacedName) client.Object {
+		return &v1beta1.EgressPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name}}
+	}
+	return a
+}
+
+// newClusterPolicyAuditor returns the auditor of the endpoint slices of the EgressClusterPolicies
+func newClusterPolicyAuditor(name string, cli client.Client, log logr.Logger, cfg *config.Config) *sliceAuditor {
+	a := newSliceAuditor(name, log, cfg.FileConfig.EndpointReconcile.Audit)
+	a.list = func(ctx context.Context) ([]types.NamespacedName, error) {
+		policies := new(v1beta1.EgressClusterPolicyList)
+		if err := cli.List(ctx, policies); err != nil {
+			return nil, err
+		}
+		res := make([]types.NamespacedName, 0, len(policies.Items))
+		for _, item := range policies.Items {
+			res = append(res, types.NamespacedName{Name: item.Name})
+		}
+		return res, nil
+	}
+	a.drift = func(ctx context.Context, key types.NamespacedName) (bool, error) {
+		policy := new(v1beta1.EgressClusterPolicy)
+		if err := cli.Get(ctx, key, policy); err != nil {
+			return false, client.IgnoreNotFound(err)
+		}
+		if !policy.DeletionTimestamp.IsZero() {
+			return false, nil
+		}
+		pods, err := listPodsByClusterPolicy(ctx, cli, policy)
+		if err != nil {
+			return false, err
+		}
+		slices, err := listClusterEndpointSlices(ctx, cli, policy.Name)
+		if err != nil {
+			return false, err
+		}
+		actual := make([]v1beta1.EgressEndpoint, 0)
+		for _, item := range slices.Items {
+			actual = append(actual, item.Endpoints...)
+		}
+		return endpointsDrift(expectedEndpoints(pods, policy.Spec.AppliedTo.StaticEndpoints), actual), nil
+	}
+	a.newObject = func(key types.NamespacedName) client.Object {
+		return &v1beta1.EgressClusterPolicy{ObjectMeta: metav1.ObjectMeta{Name: key.Name}}
+	}
+	return a
+}
+
+// expectedEndpoints returns the endpoints of the Pods and the static endpoints of a policy
+func expectedEndpoints(pods []corev1.Pod, static []string) []v1beta1.EgressEndpoint {
+	res := make([]v1beta1.EgressEndpoint, 0, len(pods)+len(static))
+	for _, pod := range pods {
+		if ep := newEndpoint(pod); ep != nil {
+			res = append(res, *ep)
+		}
+	}
+	for _, ep := range buildStaticEndpoints(static) {
+		res = append(res, ep)
+	}
+	return res
+}
+
+// endpointsDrift returns true if the endpoints in the slices are missing, duplicated,
+// unexpected or outdated
+func endpointsDrift(expected, actual []v1beta1.EgressEndpoint) bool {
+	if len(expected) != len(actual) {
+		return true
+	}
+	index := make(map[string]v1beta1.EgressEndpoint, len(expected))
+	for _, ep := range expected {
+		index[endpointKey(ep)] = ep
+	}
+	seen := make(map[string]bool, len(actual))
+	for _, ep := range actual {
+		key := endpointKey(ep)
+		exp, ok := index[key]
+		if !ok || seen[key] {
+			return true
+		}
+		seen[key] = true
+		if exp.Node != ep.Node || !sameIPs(exp.IPv4, ep.IPv4) || !sameIPs(exp.IPv6, ep.IPv6) {
+			return true
+		}
+	}
+	return false
+}
+
+func endpointKey(ep v1beta1.EgressEndpoint) string {
+	if isStaticEndpoint(ep) {
+		return staticEndpointKey(ep)
+	}
+	return types.NamespacedName{Namespace: ep.Namespace, Name: ep.Pod}.String()
+}
+
+func sameIPs(a, b []string) bool {
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return sliceEqual(a, b)
+}
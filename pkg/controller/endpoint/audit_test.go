@@ -0,0 +1,94 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package endpoint
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestEndpointsDrift(t *testing.T) {
+	pod := v1beta1.EgressEndpoint{Namespace: "default", Pod: "pod1", IPv4: []string{"10.6.0.2"}, Node: "node1"}
+	static := v1beta1.EgressEndpoint{IPv4: []string{"10.7.0.0/24"}}
+	cases := []struct {
+		name   string
+		actual []v1beta1.EgressEndpoint
+		drift  bool
+	}{
+		{name: "same", actual: []v1beta1.EgressEndpoint{static, pod}},
+		{name: "missing", actual: []v1beta1.EgressEndpoint{pod}, drift: true},
+		{name: "duplicated", actual: []v1beta1.EgressEndpoint{pod, pod}, drift: true},
+		{name: "unexpected", actual: []v1beta1.EgressEndpoint{pod, {Namespace: "default", Pod: "pod2"}}, drift: true},
+		{name: "outdated", actual: []v1beta1.EgressEndpoint{static, {
+			Namespace: "default", Pod: "pod1", IPv4: []string{"10.6.0.3"}, Node: "node1",
+		}}, drift: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.drift, endpointsDrift([]v1beta1.EgressEndpoint{pod, static}, c.actual))
+		})
+	}
+}
+
+func TestAuditorSample(t *testing.T) {
+	a := newSliceAuditor("endpoint", logr.Discard(), config.EndpointAudit{IntervalSecond: 1, SampleSize: 2})
+	keys := []types.NamespacedName{{Name: "c"}, {Name: "a"}, {Name: "b"}}
+	assert.Equal(t, []types.NamespacedName{{Name: "a"}, {Name: "b"}}, a.sample(keys))
+	assert.Equal(t, []types.NamespacedName{{Name: "c"}, {Name: "a"}}, a.sample(keys))
+	assert.Equal(t, []types.NamespacedName{{Name: "b"}, {Name: "c"}}, a.sample(keys))
+	assert.Len(t, a.sample(keys[:1]), 1)
+}
+
+func TestPolicyAuditor(t *testing.T) {
+	ctx := context.Background()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "default", Labels: map[string]string{"app": "mock"}},
+		Spec:       corev1.PodSpec{NodeName: "node1"},
+		Status:     corev1.PodStatus{PodIPs: []corev1.PodIP{{IP: "10.6.0.2"}}},
+	}
+	policy := func(name string) *v1beta1.EgressPolicy {
+		return &v1beta1.EgressPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: v1beta1.EgressPolicySpec{AppliedTo: v1beta1.AppliedTo{
+				PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "mock"}},
+			}},
+		}
+	}
+	slice := &v1beta1.EgressEndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "p1-abcde", Namespace: "default",
+			Labels: map[string]string{v1beta1.LabelPolicyName: "p1"},
+		},
+		Endpoints: []v1beta1.EgressEndpoint{{Namespace: "default", Pod: "pod1", IPv4: []string{"10.6.0.2"}, Node: "node1"}},
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(pod, policy("p1"), policy("p2"), slice).
+		Build()
+	cfg := new(config.Config)
+	cfg.FileConfig.EndpointReconcile.Audit = config.EndpointAudit{Enable: true, IntervalSecond: 1, SampleSize: 10}
+
+	a := newPolicyAuditor("endpoint", cli, logr.Discard(), cfg)
+	drift, err := a.drift(ctx, types.NamespacedName{Namespace: "default", Name: "p1"})
+	assert.NoError(t, err)
+	assert.False(t, drift)
+
+	// p2 has no endpoint slice, so it's enqueued
+	done := make(chan error)
+	go func() { done <- a.audit(ctx) }()
+	e := <-a.events
+	assert.Equal(t, "p2", e.Object.GetName())
+	assert.NoError(t, <-done)
+}
@@ -7,12 +7,13 @@ import (
 	"context"
 	"fmt"
 	"reflect"
-	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/spidernet-io/egressgateway/pkg/coalescing"
 	"github.com/spidernet-io/egressgateway/pkg/config"
 	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/queue"
+	"github.com/spidernet-io/egressgateway/pkg/utils/apply"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -30,9 +31,10 @@ import (
 )
 
 type endpointClusterReconciler struct {
-	client client.Client
-	log    logr.Logger
-	config *config.Config
+	client   client.Client
+	log      logr.Logger
+	config   *config.Config
+	migrator *apply.Migrator
 }
 
 func (r *endpointClusterReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
@@ -72,7 +74,10 @@ func (r *endpointClusterReconciler) Reconcile(ctx context.Context, req reconcile
 		return reconcile.Result{}, err
 	}
 
+	staticMap := buildStaticEndpoints(policy.Spec.AppliedTo.StaticEndpoints)
+
 	existingKeyMap := make(map[types.NamespacedName]bool)
+	existingStaticMap := make(map[string]bool)
 	slicesToUpdate := make([]v1beta1.EgressClusterEndpointSlice, 0)
 	slicesToCreate := make([]v1beta1.EgressClusterEndpointSlice, 0)
 	slicesToDelete := make([]v1beta1.EgressClusterEndpointSlice, 0)
@@ -83,6 +88,17 @@ func (r *endpointClusterReconciler) Reconcile(ctx context.Context, req reconcile
 		index := 0
 		for i := 0; i < len(epSlice.Endpoints); i++ {
 			ep := epSlice.Endpoints[i]
+			if isStaticEndpoint(ep) {
+				key := staticEndpointKey(ep)
+				if _, ok := staticMap[key]; ok && !existingStaticMap[key] {
+					existingStaticMap[key] = true
+					epSlice.Endpoints[index] = ep
+					index = index + 1
+				} else {
+					needUpdate = true
+				}
+				continue
+			}
 			key := types.NamespacedName{Namespace: ep.Namespace, Name: ep.Pod}
 			if pod, ok := podMap[key]; ok {
 				if needUpdateEndpoint(pod, &ep) {
@@ -116,6 +132,12 @@ func (r *endpointClusterReconciler) Reconcile(ctx context.Context, req reconcile
 		}
 	}
 
+	for key, ep := range staticMap {
+		if _, ok := existingStaticMap[key]; !ok {
+			needToCreateEp = append(needToCreateEp, ep)
+		}
+	}
+
 	if len(needToCreateEp) > 0 {
 		for i, slice := range slicesToUpdate {
 			if len(slice.Endpoints) < r.config.FileConfig.MaxNumberEndpointPerSlice {
@@ -171,7 +193,7 @@ func (r *endpointClusterReconciler) Reconcile(ctx context.Context, req reconcile
 			slicesToDelete = append(slicesToDelete, slice)
 			continue
 		}
-		err := r.client.Update(ctx, &slice)
+		err := applySlice(ctx, r.client, r.migrator, &slice)
 		if err != nil {
 			errs = append(errs, fmt.Errorf("failed to update endpoint slice %v/%v: %v",
 				slice.Namespace, slice.Name, err))
@@ -179,7 +201,7 @@ func (r *endpointClusterReconciler) Reconcile(ctx context.Context, req reconcile
 	}
 
 	for _, slice := range slicesToCreate {
-		err := r.client.Create(ctx, &slice)
+		err := r.client.Create(ctx, &slice, client.FieldOwner(apply.FieldOwnerEndpoint))
 		if err != nil {
 			errs = append(errs, fmt.Errorf("failed to create endpoint slice %v/%v: %v",
 				slice.Namespace, slice.Name, err))
@@ -187,7 +209,7 @@ func (r *endpointClusterReconciler) Reconcile(ctx context.Context, req reconcile
 	}
 
 	for _, slice := range slicesToDelete {
-		err := r.client.Delete(ctx, &slice)
+		err := deleteSlice(ctx, r.client, r.migrator, &slice)
 		if err != nil {
 			errs = append(errs, fmt.Errorf("failed to delete endpoint slice %v/%v: %v",
 				slice.Namespace, slice.Name, err))
@@ -219,7 +241,7 @@ func newClusterEndpointSlice(policy *v1beta1.EgressClusterPolicy) *v1beta1.Egres
 }
 
 func listPodsByClusterPolicy(ctx context.Context, cli client.Client, policy *v1beta1.EgressClusterPolicy) ([]corev1.Pod, error) {
-	if policy.Spec.AppliedTo.NamespaceSelector == nil {
+	if policy.Spec.AppliedTo.NamespaceSelector == nil && !policy.Spec.ClusterDefault {
 		pods := new(corev1.PodList)
 		selector, err := metav1.LabelSelectorAsSelector(policy.Spec.AppliedTo.PodSelector)
 		if err != nil {
@@ -236,7 +258,7 @@ func listPodsByClusterPolicy(ctx context.Context, cli client.Client, policy *v1b
 	}
 
 	nsList := new(corev1.NamespaceList)
-	nsSelector, err := metav1.LabelSelectorAsSelector(policy.Spec.AppliedTo.NamespaceSelector)
+	nsSelector, err := policy.Spec.NamespaceLabelSelector()
 	if err != nil {
 		return nil, err
 	}
@@ -253,7 +275,7 @@ func listPodsByClusterPolicy(ctx context.Context, cli client.Client, policy *v1b
 
 	for _, ns := range nsList.Items {
 		pods := new(corev1.PodList)
-		selector, err := metav1.LabelSelectorAsSelector(policy.Spec.AppliedTo.PodSelector)
+		selector, err := policy.Spec.PodLabelSelector()
 		if err != nil {
 			return nil, err
 		}
@@ -289,27 +311,28 @@ func listClusterEndpointSlices(ctx context.Context, cli client.Client, policyNam
 
 func NewEgressClusterEpSliceController(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
 	r := &endpointClusterReconciler{
-		client: mgr.GetClient(),
-		log:    log,
-		config: cfg,
+		client:   mgr.GetClient(),
+		log:      log,
+		config:   cfg,
+		migrator: newSliceMigrator(mgr),
 	}
 
 	name := "cluster-endpoint"
 	log.Info("new egress cluster endpoint slice controller")
 
-	cache, err := coalescing.NewRequestCache(time.Second)
+	cache, err := coalescing.NewRequestCache(reconcileInterval(cfg))
 	if err != nil {
 		return err
 	}
-	reduce := coalescing.NewReconciler(r, cache, log)
+	reduce := coalescing.NewReconciler(name, r, cache, log)
 
-	c, err := controller.New(name, mgr, controller.Options{Reconciler: reduce})
+	c, err := controller.New(name, mgr, queue.Options(cfg, name, reduce, reconcileWorkers(cfg)))
 	if err != nil {
 		return err
 	}
 
 	if err = c.Watch(source.Kind(mgr.GetCache(), &corev1.Pod{}),
-		handler.EnqueueRequestsFromMapFunc(enqueueEGCP(r.client)), podPredicate{}); err != nil {
+		enqueuePodChange(r.client, enqueueEGCP(r.client)), podPredicate{}); err != nil {
 		return fmt.Errorf("failed to watch pod: %v", err)
 	}
 
@@ -332,6 +355,16 @@ func NewEgressClusterEpSliceController(mgr manager.Manager, log logr.Logger, cfg
 		return fmt.Errorf("failed to watch EgressClusterEndpointSlice: %v", err)
 	}
 
+	if cfg.FileConfig.EndpointReconcile.Audit.Enable {
+		audit := newClusterPolicyAuditor(name, r.client, log, cfg)
+		if err = c.Watch(&source.Channel{Source: audit.events}, &handler.EnqueueRequestForObject{}); err != nil {
+			return fmt.Errorf("failed to watch audit events: %v", err)
+		}
+		if err = mgr.Add(audit); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -388,7 +421,8 @@ func enqueueNS(cli client.Client) handler.MapFunc {
 			if err != nil {
 				return nil
 			}
-			match := selPods.Matches(labels.Set(ns.Labels))
+			// the namespace may be opted in or out of the cluster default policy
+			match := policy.Spec.ClusterDefault || selPods.Matches(labels.Set(ns.Labels))
 			if match {
 				res = append(res, reconcile.Request{
 					NamespacedName: types.NamespacedName{
@@ -424,19 +458,19 @@ func enqueueEGCP(cli client.Client) handler.MapFunc {
 		}
 
 		for _, policy := range policyList.Items {
-			selPods, err := metav1.LabelSelectorAsSelector(policy.Spec.AppliedTo.PodSelector)
+			selPods, err := policy.Spec.PodLabelSelector()
 			if err != nil {
 				return nil
 			}
 			match := selPods.Matches(labels.Set(pod.Labels))
 			if match {
-				if policy.Spec.AppliedTo.NamespaceSelector != nil {
+				if policy.Spec.AppliedTo.NamespaceSelector != nil || policy.Spec.ClusterDefault {
 					ns := new(corev1.Namespace)
 					err := cli.Get(context.Background(), types.NamespacedName{Name: pod.Namespace}, ns)
 					if err != nil {
 						return nil
 					}
-					selNS, err := metav1.LabelSelectorAsSelector(policy.Spec.AppliedTo.NamespaceSelector)
+					selNS, err := policy.Spec.NamespaceLabelSelector()
 					if err != nil {
 						return nil
 					}
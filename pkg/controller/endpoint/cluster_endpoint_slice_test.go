@@ -582,3 +582,34 @@ func TestNewEgressClusterEpSliceController(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestListPodsByClusterDefaultPolicy(t *testing.T) {
+	ns := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	pod := func(namespace, name string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(
+			ns("team-a", nil),
+			ns("team-b", map[string]string{v1beta1.LabelNamespaceClusterDefaultOptOut: "true"}),
+			pod("team-a", "pod1"),
+			pod("team-b", "pod2"),
+		).Build()
+
+	policy := &v1beta1.EgressClusterPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec:       v1beta1.EgressClusterPolicySpec{ClusterDefault: true},
+	}
+	pods, err := listPodsByClusterPolicy(context.Background(), cli, policy)
+	assert.NoError(t, err)
+	assert.Len(t, pods, 1)
+	assert.Equal(t, "pod1", pods[0].Name)
+
+	// the opted out namespace enqueues the cluster default policy
+	reqs := enqueueNS(fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(policy).Build())(
+		context.Background(), ns("team-b", map[string]string{v1beta1.LabelNamespaceClusterDefaultOptOut: "true"}))
+	assert.Equal(t, []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "default"}}}, reqs)
+}
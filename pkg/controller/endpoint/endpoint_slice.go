@@ -31,12 +31,15 @@ import (
 	"github.com/spidernet-io/egressgateway/pkg/coalescing"
 	"github.com/spidernet-io/egressgateway/pkg/config"
 	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/queue"
+	"github.com/spidernet-io/egressgateway/pkg/utils/apply"
 )
 
 type endpointReconciler struct {
-	client client.Client
-	log    logr.Logger
-	config *config.Config
+	client   client.Client
+	log      logr.Logger
+	config   *config.Config
+	migrator *apply.Migrator
 }
 
 func (r *endpointReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
@@ -65,6 +68,10 @@ func (r *endpointReconciler) Reconcile(ctx context.Context, req reconcile.Reques
 	if err != nil {
 		return reconcile.Result{}, err
 	}
+	pods.Items, err = filterRolloutPods(policy, pods.Items)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
 
 	podMap := make(map[types.NamespacedName]corev1.Pod)
 	for _, pod := range pods.Items {
@@ -76,7 +83,10 @@ func (r *endpointReconciler) Reconcile(ctx context.Context, req reconcile.Reques
 		return reconcile.Result{}, err
 	}
 
+	staticMap := buildStaticEndpoints(policy.Spec.AppliedTo.StaticEndpoints)
+
 	existingKeyMap := make(map[types.NamespacedName]bool)
+	existingStaticMap := make(map[string]bool)
 	slicesToUpdate := make([]v1beta1.EgressEndpointSlice, 0)
 	slicesToCreate := make([]v1beta1.EgressEndpointSlice, 0)
 	slicesToDelete := make([]v1beta1.EgressEndpointSlice, 0)
@@ -87,6 +97,17 @@ func (r *endpointReconciler) Reconcile(ctx context.Context, req reconcile.Reques
 		index := 0
 		for i := 0; i < len(epSlice.Endpoints); i++ {
 			ep := epSlice.Endpoints[i]
+			if isStaticEndpoint(ep) {
+				key := staticEndpointKey(ep)
+				if _, ok := staticMap[key]; ok && !existingStaticMap[key] {
+					existingStaticMap[key] = true
+					epSlice.Endpoints[index] = ep
+					index = index + 1
+				} else {
+					needUpdate = true
+				}
+				continue
+			}
 			key := types.NamespacedName{Namespace: ep.Namespace, Name: ep.Pod}
 			if pod, ok := podMap[key]; ok {
 				if needUpdateEndpoint(pod, &ep) {
@@ -120,6 +141,12 @@ func (r *endpointReconciler) Reconcile(ctx context.Context, req reconcile.Reques
 		}
 	}
 
+	for key, ep := range staticMap {
+		if _, ok := existingStaticMap[key]; !ok {
+			needToCreateEp = append(needToCreateEp, ep)
+		}
+	}
+
 	if len(needToCreateEp) > 0 {
 		for i, slice := range slicesToUpdate {
 			if len(slice.Endpoints) < r.config.FileConfig.MaxNumberEndpointPerSlice {
@@ -173,7 +200,7 @@ func (r *endpointReconciler) Reconcile(ctx context.Context, req reconcile.Reques
 			slicesToDelete = append(slicesToDelete, slice)
 			continue
 		}
-		err := r.client.Update(ctx, &slice)
+		err := applySlice(ctx, r.client, r.migrator, &slice)
 		if err != nil {
 			errs = append(errs, fmt.Errorf("failed to update endpoint slice %v/%v: %v",
 				slice.Namespace, slice.Name, err))
@@ -181,7 +208,7 @@ func (r *endpointReconciler) Reconcile(ctx context.Context, req reconcile.Reques
 	}
 
 	for _, slice := range slicesToCreate {
-		err := r.client.Create(ctx, &slice)
+		err := r.client.Create(ctx, &slice, client.FieldOwner(apply.FieldOwnerEndpoint))
 		if err != nil {
 			errs = append(errs, fmt.Errorf("failed to create endpoint slice %v/%v: %v",
 				slice.Namespace, slice.Name, err))
@@ -189,7 +216,7 @@ func (r *endpointReconciler) Reconcile(ctx context.Context, req reconcile.Reques
 	}
 
 	for _, slice := range slicesToDelete {
-		err := r.client.Delete(ctx, &slice)
+		err := deleteSlice(ctx, r.client, r.migrator, &slice)
 		if err != nil {
 			errs = append(errs, fmt.Errorf("failed to delete endpoint slice %v/%v: %v",
 				slice.Namespace, slice.Name, err))
@@ -253,6 +280,64 @@ func newEndpoint(pod corev1.Pod) *v1beta1.EgressEndpoint {
 	}
 }
 
+// newStaticEndpoint builds an endpoint without Pod and Node from an IP or CIDR,
+// the address is stored in the form listed by ipset, so it can be compared directly.
+func newStaticEndpoint(item string) *v1beta1.EgressEndpoint {
+	var ipNet *net.IPNet
+	if ip := net.ParseIP(item); ip != nil {
+		bits := 128
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	} else {
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil
+		}
+		ipNet = n
+	}
+
+	addr := ipNet.String()
+	if ones, bits := ipNet.Mask.Size(); ones == bits {
+		addr = ipNet.IP.String()
+	}
+
+	ep := &v1beta1.EgressEndpoint{}
+	if ipNet.IP.To4() != nil {
+		ep.IPv4 = []string{addr}
+	} else {
+		ep.IPv6 = []string{addr}
+	}
+	return ep
+}
+
+// buildStaticEndpoints returns the static endpoints of the policy, keyed by address
+func buildStaticEndpoints(list []string) map[string]v1beta1.EgressEndpoint {
+	res := make(map[string]v1beta1.EgressEndpoint)
+	for _, item := range list {
+		if ep := newStaticEndpoint(item); ep != nil {
+			res[staticEndpointKey(*ep)] = *ep
+		}
+	}
+	return res
+}
+
+func isStaticEndpoint(ep v1beta1.EgressEndpoint) bool {
+	return ep.Pod == ""
+}
+
+func staticEndpointKey(ep v1beta1.EgressEndpoint) string {
+	if len(ep.IPv4) > 0 {
+		return ep.IPv4[0]
+	}
+	if len(ep.IPv6) > 0 {
+		return ep.IPv6[0]
+	}
+	return ""
+}
+
 func needUpdateEndpoint(pod corev1.Pod, ep *v1beta1.EgressEndpoint) bool {
 	expIPv4List := make([]string, 0)
 	expIPv6List := make([]string, 0)
@@ -282,6 +367,11 @@ func needUpdateEndpoint(pod corev1.Pod, ep *v1beta1.EgressEndpoint) bool {
 		ep.IPv6 = expIPv6List
 	}
 
+	if ep.Node != pod.Spec.NodeName {
+		needUpdate = true
+		ep.Node = pod.Spec.NodeName
+	}
+
 	return needUpdate
 }
 
@@ -334,27 +424,44 @@ func listEndpointSlices(ctx context.Context, cli client.Client, namespace, polic
 	return slices, err
 }
 
+// reconcileInterval returns the minimum interval between two reconciliations of a policy
+func reconcileInterval(cfg *config.Config) time.Duration {
+	if cfg.FileConfig.EndpointReconcile.MinIntervalMillis <= 0 {
+		return time.Second
+	}
+	return time.Millisecond * time.Duration(cfg.FileConfig.EndpointReconcile.MinIntervalMillis)
+}
+
+// reconcileWorkers returns the number of policies reconciled concurrently
+func reconcileWorkers(cfg *config.Config) int {
+	if cfg.FileConfig.EndpointReconcile.Workers <= 0 {
+		return 1
+	}
+	return cfg.FileConfig.EndpointReconcile.Workers
+}
+
 func NewEgressEndpointSliceController(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
 	r := &endpointReconciler{
-		client: mgr.GetClient(),
-		log:    log,
-		config: cfg,
+		client:   mgr.GetClient(),
+		log:      log,
+		config:   cfg,
+		migrator: newSliceMigrator(mgr),
 	}
 	log.Info("new endpoint controller")
 
-	cache, err := coalescing.NewRequestCache(time.Second)
+	cache, err := coalescing.NewRequestCache(reconcileInterval(cfg))
 	if err != nil {
 		return err
 	}
-	reduce := coalescing.NewReconciler(r, cache, log)
+	reduce := coalescing.NewReconciler("endpoint", r, cache, log)
 
-	c, err := controller.New("endpoint", mgr, controller.Options{Reconciler: reduce})
+	c, err := controller.New("endpoint", mgr, queue.Options(cfg, "endpoint", reduce, reconcileWorkers(cfg)))
 	if err != nil {
 		return err
 	}
 
 	if err = c.Watch(source.Kind(mgr.GetCache(), &corev1.Pod{}),
-		handler.EnqueueRequestsFromMapFunc(enqueuePod(r.client)), podPredicate{}); err != nil {
+		enqueuePodChange(r.client, enqueuePod(r.client)), podPredicate{}); err != nil {
 		return fmt.Errorf("failed to watch Pod: %v", err)
 	}
 
@@ -369,6 +476,16 @@ func NewEgressEndpointSliceController(mgr manager.Manager, log logr.Logger, cfg
 		return fmt.Errorf("failed to watch EgressEndpointSlice: %v", err)
 	}
 
+	if cfg.FileConfig.EndpointReconcile.Audit.Enable {
+		audit := newPolicyAuditor("endpoint", r.client, log, cfg)
+		if err = c.Watch(&source.Channel{Source: audit.events}, &handler.EnqueueRequestForObject{}); err != nil {
+			return fmt.Errorf("failed to watch audit events: %v", err)
+		}
+		if err = mgr.Add(audit); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -390,6 +507,8 @@ func (p podPredicate) Delete(_ event.DeleteEvent) bool {
 	return true
 }
 
+// Update returns true if the labels, IPs or node of the Pod are changed, which
+// are used to build the endpoints.
 func (p podPredicate) Update(updateEvent event.UpdateEvent) bool {
 	oldPod, ok := updateEvent.ObjectOld.(*corev1.Pod)
 	if !ok {
@@ -400,14 +519,9 @@ func (p podPredicate) Update(updateEvent event.UpdateEvent) bool {
 		return false
 	}
 
-	// case by pods labels are changed
-	if reflect.DeepEqual(oldPod.Labels, newPod.Labels) &&
-		reflect.DeepEqual(oldPod.Status.PodIPs, newPod.Status.PodIPs) &&
-		oldPod.Spec.NodeName != newPod.Spec.NodeName {
-		return false
-	}
-
-	return true
+	return !reflect.DeepEqual(oldPod.Labels, newPod.Labels) ||
+		!reflect.DeepEqual(oldPod.Status.PodIPs, newPod.Status.PodIPs) ||
+		oldPod.Spec.NodeName != newPod.Spec.NodeName
 }
 
 func (p podPredicate) Generic(_ event.GenericEvent) bool {
@@ -430,6 +544,9 @@ func enqueuePod(cli client.Client) handler.MapFunc {
 		res := make([]reconcile.Request, 0)
 
 		for _, policy := range policyList.Items {
+			if policy.Namespace != pod.Namespace {
+				continue
+			}
 			selPods, err := metav1.LabelSelectorAsSelector(policy.Spec.AppliedTo.PodSelector)
 			if err != nil {
 				return nil
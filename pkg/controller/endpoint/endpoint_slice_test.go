@@ -499,6 +499,69 @@ func caseDeletePod() TestCaseEPS {
 	}
 }
 
+func TestReconcilerStaticEndpoints(t *testing.T) {
+	policy := &v1beta1.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "policy1",
+			Namespace: "default",
+		},
+		Spec: v1beta1.EgressPolicySpec{
+			AppliedTo: v1beta1.AppliedTo{
+				StaticEndpoints: []string{"10.7.0.1", "10.8.0.0/24", "10.9.0.1/32", "fd00::1", "invalid"},
+			},
+		},
+	}
+
+	builder := fake.NewClientBuilder()
+	builder.WithScheme(schema.GetScheme())
+	builder.WithObjects(policy)
+	cli := builder.Build()
+
+	conf := &config.Config{
+		FileConfig: config.FileConfig{
+			MaxNumberEndpointPerSlice: 2,
+		},
+	}
+	reconciler := endpointReconciler{
+		client: cli,
+		log:    logger.NewLogger(conf.EnvConfig.Logger),
+		config: conf,
+	}
+
+	ctx := context.Background()
+	nn := types.NamespacedName{Namespace: "default", Name: "policy1"}
+	_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: nn})
+	assert.NoError(t, err)
+
+	epList, err := listEndpointSlices(ctx, cli, "default", "policy1")
+	assert.NoError(t, err)
+	exp := map[string]struct{}{"10.7.0.1": {}, "10.8.0.0/24": {}, "10.9.0.1": {}, "fd00::1": {}}
+	got := make(map[string]struct{})
+	for _, item := range epList.Items {
+		for _, ep := range item.Endpoints {
+			assert.True(t, isStaticEndpoint(ep))
+			got[staticEndpointKey(ep)] = struct{}{}
+		}
+	}
+	assert.Equal(t, exp, got)
+
+	// remove a static endpoint
+	policy.Spec.AppliedTo.StaticEndpoints = []string{"10.7.0.1"}
+	assert.NoError(t, cli.Update(ctx, policy))
+	_, err = reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: nn})
+	assert.NoError(t, err)
+
+	epList, err = listEndpointSlices(ctx, cli, "default", "policy1")
+	assert.NoError(t, err)
+	got = make(map[string]struct{})
+	for _, item := range epList.Items {
+		for _, ep := range item.Endpoints {
+			got[staticEndpointKey(ep)] = struct{}{}
+		}
+	}
+	assert.Equal(t, map[string]struct{}{"10.7.0.1": {}}, got)
+}
+
 func TestPodPredicate(t *testing.T) {
 	p := podPredicate{}
 	if !p.Create(event.CreateEvent{
@@ -0,0 +1,80 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package endpoint
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+// PodIPIndex is the field index of the IPs of Pods
+const PodIPIndex = "status.podIPs"
+
+// IndexPodIP registers the field index of Pod IPs, it must be called only once for
+// the manager before the endpoint controllers are started.
+func IndexPodIP(ctx context.Context, indexer client.FieldIndexer) error {
+	return indexer.IndexField(ctx, &corev1.Pod{}, PodIPIndex, podIPs)
+}
+
+func podIPs(obj client.Object) []string {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil
+	}
+	res := make([]string, 0, len(pod.Status.PodIPs))
+	for _, item := range pod.Status.PodIPs {
+		res = append(res, item.IP)
+	}
+	return res
+}
+
+// enqueuePodChange returns the handler of Pod events, which enqueues the policies
+// returned by toReq for:
+//   - both the old and the new Pod of the update event, so that a Pod which leaves
+//     a policy by changing labels is removed from the endpoint slices of the policy
+//   - the other Pods holding the IPs of the Pod, so that an IP reused by another
+//     Pod is not left in the stale endpoint of the previous Pod
+func enqueuePodChange(cli client.Client, toReq handler.MapFunc) handler.EventHandler {
+	add := func(ctx context.Context, q workqueue.RateLimitingInterface, obj client.Object) {
+		for _, req := range toReq(ctx, obj) {
+			q.Add(req)
+		}
+	}
+	addIPHolders := func(ctx context.Context, q workqueue.RateLimitingInterface, obj client.Object) {
+		for _, ip := range podIPs(obj) {
+			pods := new(corev1.PodList)
+			if err := cli.List(ctx, pods, client.MatchingFields{PodIPIndex: ip}); err != nil {
+				return
+			}
+			for i := range pods.Items {
+				if pods.Items[i].UID == obj.GetUID() {
+					continue
+				}
+				add(ctx, q, &pods.Items[i])
+			}
+		}
+	}
+	return handler.Funcs{
+		CreateFunc: func(ctx context.Context, e event.CreateEvent, q workqueue.RateLimitingInterface) {
+			add(ctx, q, e.Object)
+			addIPHolders(ctx, q, e.Object)
+		},
+		UpdateFunc: func(ctx context.Context, e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			add(ctx, q, e.ObjectOld)
+			add(ctx, q, e.ObjectNew)
+			addIPHolders(ctx, q, e.ObjectNew)
+		},
+		DeleteFunc: func(ctx context.Context, e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			add(ctx, q, e.Object)
+		},
+		GenericFunc: func(ctx context.Context, e event.GenericEvent, q workqueue.RateLimitingInterface) {
+			add(ctx, q, e.Object)
+		},
+	}
+}
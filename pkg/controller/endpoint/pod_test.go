@@ -0,0 +1,105 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package endpoint
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func newTestPod(name, ip string, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       types.UID(name),
+			Labels:    labels,
+		},
+		Status: corev1.PodStatus{PodIPs: []corev1.PodIP{{IP: ip}}},
+	}
+}
+
+func newTestPolicy(name string, labels map[string]string) *v1beta1.EgressPolicy {
+	return &v1beta1.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: v1beta1.EgressPolicySpec{
+			AppliedTo: v1beta1.AppliedTo{
+				PodSelector: &metav1.LabelSelector{MatchLabels: labels},
+			},
+		},
+	}
+}
+
+func TestPodPredicateUpdate(t *testing.T) {
+	p := podPredicate{}
+	labels := map[string]string{"app": "a"}
+	old := newTestPod("pod1", "10.6.0.1", labels)
+
+	cases := []struct {
+		name   string
+		update func(pod *corev1.Pod)
+		expect bool
+	}{
+		{name: "no change", update: func(pod *corev1.Pod) { pod.Annotations = map[string]string{"k": "v"} }},
+		{name: "ip", update: func(pod *corev1.Pod) { pod.Status.PodIPs = []corev1.PodIP{{IP: "10.6.0.2"}} }, expect: true},
+		{name: "node", update: func(pod *corev1.Pod) { pod.Spec.NodeName = "node2" }, expect: true},
+		{name: "labels", update: func(pod *corev1.Pod) { pod.Labels = map[string]string{"app": "b"} }, expect: true},
+	}
+	for _, c := range cases {
+		pod := old.DeepCopy()
+		c.update(pod)
+		assert.Equal(t, c.expect, p.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: pod}), c.name)
+	}
+}
+
+func TestEnqueuePodChange(t *testing.T) {
+	podA := newTestPod("pod-a", "10.6.0.1", map[string]string{"app": "a"})
+	podB := newTestPod("pod-b", "10.6.0.1", map[string]string{"app": "b"})
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(
+			podA, podB,
+			newTestPolicy("policy-a", map[string]string{"app": "a"}),
+			newTestPolicy("policy-b", map[string]string{"app": "b"}),
+			newTestPolicy("policy-c", map[string]string{"app": "c"}),
+		).
+		WithIndex(&corev1.Pod{}, PodIPIndex, podIPs).
+		Build()
+	h := enqueuePodChange(cli, enqueuePod(cli))
+	ctx := context.Background()
+
+	queued := func(q workqueue.RateLimitingInterface) []string {
+		res := make([]string, 0)
+		for q.Len() > 0 {
+			item, _ := q.Get()
+			res = append(res, item.(reconcile.Request).Name)
+			q.Done(item)
+		}
+		return res
+	}
+
+	// the policy of the pod which held the IP before is enqueued
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	h.Create(ctx, event.CreateEvent{Object: podA}, q)
+	assert.ElementsMatch(t, []string{"policy-a", "policy-b"}, queued(q))
+
+	// the policies matched by both the old and new labels are enqueued
+	podC := podA.DeepCopy()
+	podC.Labels = map[string]string{"app": "c"}
+	podC.Status.PodIPs = []corev1.PodIP{{IP: "10.6.0.3"}}
+	h.Update(ctx, event.UpdateEvent{ObjectOld: podA, ObjectNew: podC}, q)
+	assert.ElementsMatch(t, []string{"policy-a", "policy-c"}, queued(q))
+}
@@ -0,0 +1,49 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package endpoint
+
+import (
+	"hash/fnv"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// filterRolloutPods returns the Pods in the rollout of the policy
+func filterRolloutPods(policy *v1beta1.EgressPolicy, pods []corev1.Pod) ([]corev1.Pod, error) {
+	rollout := policy.Spec.Rollout
+	if rollout == nil {
+		return pods, nil
+	}
+
+	selector := labels.Nothing()
+	if rollout.PodSelector != nil {
+		var err error
+		selector, err = metav1.LabelSelectorAsSelector(rollout.PodSelector)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res := make([]corev1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if selector.Matches(labels.Set(pod.Labels)) ||
+			(rollout.Percentage != nil && rolloutBucket(policy, pod) < *rollout.Percentage) {
+			res = append(res, pod)
+		}
+	}
+	return res, nil
+}
+
+// rolloutBucket maps the Pod to one of the 100 buckets of the policy, the Pods in the
+// buckets less than the percentage are in the rollout. The policy is hashed together,
+// so that the policies don't choose the same Pods.
+func rolloutBucket(policy *v1beta1.EgressPolicy, pod corev1.Pod) int32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(policy.Namespace + "/" + policy.Name + "/" + pod.Name))
+	return int32(h.Sum32() % 100)
+}
@@ -0,0 +1,130 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestFilterRolloutPods(t *testing.T) {
+	policy := &v1beta1.EgressPolicy{ObjectMeta: metav1.ObjectMeta{Name: "policy1", Namespace: "default"}}
+	pods := make([]corev1.Pod, 0, 200)
+	for i := 0; i < 200; i++ {
+		pods = append(pods, corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("pod-%d", i),
+			Namespace: "default",
+			Labels:    map[string]string{"canary": fmt.Sprint(i == 0)},
+		}})
+	}
+
+	// all Pods without a rollout
+	res, err := filterRolloutPods(policy, pods)
+	assert.NoError(t, err)
+	assert.Len(t, res, 200)
+
+	policy.Spec.Rollout = &v1beta1.PolicyRollout{Percentage: pointer.Int32(0)}
+	res, err = filterRolloutPods(policy, pods)
+	assert.NoError(t, err)
+	assert.Empty(t, res)
+
+	// a larger percentage always includes the Pods of a smaller one
+	policy.Spec.Rollout.Percentage = pointer.Int32(10)
+	small, err := filterRolloutPods(policy, pods)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, small)
+	policy.Spec.Rollout.Percentage = pointer.Int32(50)
+	large, err := filterRolloutPods(policy, pods)
+	assert.NoError(t, err)
+	assert.Greater(t, len(large), len(small))
+	assert.Less(t, len(large), 200)
+	names := make(map[string]bool)
+	for _, pod := range large {
+		names[pod.Name] = true
+	}
+	for _, pod := range small {
+		assert.True(t, names[pod.Name], pod.Name)
+	}
+
+	policy.Spec.Rollout.Percentage = pointer.Int32(100)
+	res, err = filterRolloutPods(policy, pods)
+	assert.NoError(t, err)
+	assert.Len(t, res, 200)
+
+	// the explicit subset
+	policy.Spec.Rollout = &v1beta1.PolicyRollout{PodSelector: &metav1.LabelSelector{
+		MatchLabels: map[string]string{"canary": "true"},
+	}}
+	res, err = filterRolloutPods(policy, pods)
+	assert.NoError(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, "pod-0", res[0].Name)
+}
+
+func TestReconcilerRollout(t *testing.T) {
+	labels := map[string]string{"app": "test"}
+	policy := &v1beta1.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy1", Namespace: "default"},
+		Spec: v1beta1.EgressPolicySpec{
+			AppliedTo: v1beta1.AppliedTo{PodSelector: &metav1.LabelSelector{MatchLabels: labels}},
+			Rollout: &v1beta1.PolicyRollout{PodSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"canary": "true"},
+			}},
+		},
+	}
+	pod := func(name, ip string, canary bool) client.Object {
+		podLabels := map[string]string{"app": "test", "canary": fmt.Sprint(canary)}
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: podLabels},
+			Status:     corev1.PodStatus{PodIPs: []corev1.PodIP{{IP: ip}}},
+		}
+	}
+
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(policy, pod("pod1", "10.6.0.1", true), pod("pod2", "10.6.0.2", false)).
+		Build()
+	conf := &config.Config{FileConfig: config.FileConfig{MaxNumberEndpointPerSlice: 100}}
+	reconciler := endpointReconciler{client: cli, log: logger.NewLogger(conf.EnvConfig.Logger), config: conf}
+
+	ctx := context.Background()
+	nn := types.NamespacedName{Namespace: "default", Name: "policy1"}
+	_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: nn})
+	assert.NoError(t, err)
+
+	endpoints := func() []string {
+		epList, err := listEndpointSlices(ctx, cli, "default", "policy1")
+		assert.NoError(t, err)
+		res := make([]string, 0)
+		for _, item := range epList.Items {
+			for _, ep := range item.Endpoints {
+				res = append(res, ep.Pod)
+			}
+		}
+		return res
+	}
+	assert.Equal(t, []string{"pod1"}, endpoints())
+
+	// shift all the traffic to the policy
+	policy.Spec.Rollout = nil
+	assert.NoError(t, cli.Update(ctx, policy))
+	_, err = reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: nn})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"pod1", "pod2"}, endpoints())
+}
@@ -0,0 +1,92 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/controller/endpoint"
+	"github.com/spidernet-io/egressgateway/pkg/controller/metrics"
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+	"github.com/spidernet-io/egressgateway/pkg/profiling"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+	"github.com/spidernet-io/egressgateway/pkg/types"
+)
+
+// endpointLeaderElectionSuffix is appended to the leader election ID of the endpoint-controller,
+// so it's elected independently of the controller
+const endpointLeaderElectionSuffix = "-endpoint"
+
+// NewEndpointController returns the standalone endpoint-controller, which only reconciles
+// the endpoint slices of the policies. It has its own leader election, and its cache only
+// holds the Pods, Namespaces, policies and endpoint slices.
+func NewEndpointController(cfg *config.Config) (types.Service, error) {
+	log := logger.NewLogger(cfg.EnvConfig.Logger)
+	cacheOpts, err := endpointCacheOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.FileConfig.EndpointReconcile.Audit.Enable {
+		// the audit repairs the drifted endpoint slices instead of the resync
+		noResync := time.Duration(0)
+		cacheOpts.SyncPeriod = &noResync
+	}
+	mgrOpts := manager.Options{
+		Cache:                   cacheOpts,
+		Scheme:                  schema.GetScheme(),
+		Logger:                  log,
+		LeaderElection:          cfg.LeaderElection,
+		HealthProbeBindAddress:  cfg.HealthProbeBindAddress,
+		LeaderElectionID:        cfg.LeaderElectionID + endpointLeaderElectionSuffix,
+		LeaderElectionNamespace: cfg.LeaderElectionNamespace,
+	}
+	mgrOpts.Metrics.BindAddress = cfg.MetricsBindAddress
+
+	mgr, err := ctrl.NewManager(cfg.KubeConfig, mgrOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create manager: %w", err)
+	}
+	if err = mgr.Add(&profiling.GoPS{Port: cfg.GopsPort, Log: log}); err != nil {
+		return nil, err
+	}
+	if err = mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		return nil, fmt.Errorf("failed to AddHealthzCheck: %w", err)
+	}
+	if err = mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+		return nil, fmt.Errorf("failed to AddReadyzCheck: %w", err)
+	}
+	metrics.RegisterMetricCollectors("endpoint-controller")
+
+	if err = addEndpointControllers(mgr, cfg, log); err != nil {
+		return nil, err
+	}
+	return &Controller{client: mgr.GetClient(), manager: mgr}, nil
+}
+
+// addEndpointControllers adds the controllers of the endpoint slices of the policies
+func addEndpointControllers(mgr manager.Manager, cfg *config.Config, log logr.Logger) error {
+	err := endpoint.IndexPodIP(context.Background(), mgr.GetFieldIndexer())
+	if err != nil {
+		return fmt.Errorf("failed to index pod ips: %w", err)
+	}
+
+	err = endpoint.NewEgressEndpointSliceController(mgr, logger.ForModule(log, logger.ModuleEndpoint), cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create endpoint slice controller: %w", err)
+	}
+
+	err = endpoint.NewEgressClusterEpSliceController(mgr, logger.ForModule(log, logger.ModuleEndpoint), cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create cluster endpoint slice controller: %w", err)
+	}
+	return nil
+}
@@ -5,13 +5,25 @@ package metrics
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spidernet-io/egressgateway/pkg/coalescing"
+	"github.com/spidernet-io/egressgateway/pkg/controller/endpoint"
 	"github.com/spidernet-io/egressgateway/pkg/controller/tunnel"
+	"github.com/spidernet-io/egressgateway/pkg/egressgateway"
+	egresserrors "github.com/spidernet-io/egressgateway/pkg/errors"
+	"github.com/spidernet-io/egressgateway/pkg/queue"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
-func RegisterMetricCollectors() {
+// RegisterMetricCollectors registers the metrics of the controllers, the component is the
+// name of the binary running them
+func RegisterMetricCollectors(component string) {
 	var metricCollectors []prometheus.Collector
 	metricCollectors = append(metricCollectors, tunnel.EgressTunnelControllerMetricCollectors...)
+	metricCollectors = append(metricCollectors, coalescing.MetricCollectors()...)
+	metricCollectors = append(metricCollectors, egressgateway.MetricCollectors()...)
+	metricCollectors = append(metricCollectors, endpoint.MetricCollectors()...)
+	metricCollectors = append(metricCollectors, egresserrors.MetricCollectors()...)
+	metricCollectors = append(metricCollectors, queue.NewCollector(component))
 	for _, collector := range metricCollectors {
 		metrics.Registry.MustRegister(collector)
 	}
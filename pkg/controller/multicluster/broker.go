@@ -0,0 +1,516 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package multicluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+const kubeconfigKey = "kubeconfig"
+
+// Broker shares the EgressGateways between clusters. For each remote cluster, it
+//   - imports the EgressTunnels of the remote cluster, so that the agents can forward
+//     the egress traffic to the remote gateway nodes
+//   - imports the EgressGateways of the remote cluster as `<cluster>.<name>`
+//   - exports the local policies using the imported EgressGateways to the remote
+//     cluster as EgressClusterPolicies, whose static endpoints are the IPs of the
+//     local endpoints of the policies, and copies their status back
+type Broker struct {
+	client client.Client
+	reader client.Reader
+	cfg    *config.Config
+	log    logr.Logger
+
+	newClient func(kubeconfig []byte) (client.Client, error)
+
+	lock    sync.Mutex
+	remotes map[string]remoteClient
+}
+
+type remoteClient struct {
+	resourceVersion string
+	client          client.Client
+}
+
+// NewBroker adds the multi-cluster broker to the manager if multi-cluster is enabled
+func NewBroker(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
+	if !cfg.FileConfig.MultiCluster.Enable {
+		return nil
+	}
+	b := &Broker{
+		client:    mgr.GetClient(),
+		reader:    mgr.GetAPIReader(),
+		cfg:       cfg,
+		log:       log,
+		newClient: newRemoteClient,
+		remotes:   make(map[string]remoteClient),
+	}
+	return mgr.Add(b)
+}
+
+func newRemoteClient(kubeconfig []byte) (client.Client, error) {
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return client.New(restConfig, client.Options{Scheme: schema.GetScheme()})
+}
+
+// Start synchronizes the remote clusters periodically until the context is done
+func (b *Broker) Start(ctx context.Context) error {
+	interval := time.Second * time.Duration(b.cfg.FileConfig.MultiCluster.SyncIntervalSecond)
+	b.log.Info("start multi-cluster broker", "cluster", b.cfg.FileConfig.MultiCluster.ClusterName, "interval", interval)
+
+	t := time.NewTimer(0)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			b.sync(ctx)
+			t.Reset(interval)
+		}
+	}
+}
+
+func (b *Broker) sync(ctx context.Context) {
+	for _, item := range b.cfg.FileConfig.MultiCluster.Clusters {
+		log := b.log.WithValues("remote", item.Name)
+		remote, err := b.remoteClient(ctx, item)
+		if err != nil {
+			log.Error(err, "failed to get the client of remote cluster")
+			continue
+		}
+		if err := b.syncCluster(ctx, item, remote, log); err != nil {
+			log.Error(err, "failed to sync remote cluster")
+		}
+	}
+}
+
+// remoteClient returns the client of the remote cluster, which is rebuilt when the
+// kubeconfig Secret changes
+func (b *Broker) remoteClient(ctx context.Context, cluster config.RemoteCluster) (client.Client, error) {
+	secret := new(corev1.Secret)
+	key := types.NamespacedName{Namespace: b.cfg.EnvConfig.PodNamespace, Name: cluster.KubeconfigSecret}
+	if err := b.reader.Get(ctx, key, secret); err != nil {
+		return nil, err
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if item, ok := b.remotes[cluster.Name]; ok && item.resourceVersion == secret.ResourceVersion {
+		return item.client, nil
+	}
+	kubeconfig, ok := secret.Data[kubeconfigKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s has no %s key", key, kubeconfigKey)
+	}
+	cli, err := b.newClient(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	b.remotes[cluster.Name] = remoteClient{resourceVersion: secret.ResourceVersion, client: cli}
+	return cli, nil
+}
+
+func (b *Broker) syncCluster(ctx context.Context, cluster config.RemoteCluster, remote client.Client, log logr.Logger) error {
+	name := cluster.Name
+	if err := b.importTunnels(ctx, cluster, remote, log); err != nil {
+		return fmt.Errorf("failed to import EgressTunnels: %w", err)
+	}
+
+	exported := new(egressv1.EgressClusterPolicyList)
+	err := remote.List(ctx, exported, client.MatchingLabels{egressv1.LabelCluster: b.cfg.FileConfig.MultiCluster.ClusterName})
+	if err != nil {
+		return fmt.Errorf("failed to list exported policies: %w", err)
+	}
+
+	if err := b.importGateways(ctx, name, remote, exported.Items); err != nil {
+		return fmt.Errorf("failed to import EgressGateways: %w", err)
+	}
+	if err := b.exportPolicies(ctx, name, remote, exported.Items, log); err != nil {
+		return fmt.Errorf("failed to export policies: %w", err)
+	}
+	return nil
+}
+
+// importTunnels keeps the local copies of the EgressTunnels of the remote cluster, the
+// EgressTunnels imported by the remote cluster are not imported again.
+func (b *Broker) importTunnels(ctx context.Context, cluster config.RemoteCluster, remote client.Client, log logr.Logger) error {
+	name := cluster.Name
+	remoteList := new(egressv1.EgressTunnelList)
+	if err := remote.List(ctx, remoteList); err != nil {
+		return err
+	}
+	localList := new(egressv1.EgressTunnelList)
+	if err := b.client.List(ctx, localList); err != nil {
+		return err
+	}
+	locals := make(map[string]*egressv1.EgressTunnel)
+	for i := range localList.Items {
+		locals[localList.Items[i].Name] = &localList.Items[i]
+	}
+
+	wanted := make(map[string]struct{})
+	for _, item := range remoteList.Items {
+		if egressv1.IsImported(item.Labels) {
+			continue
+		}
+		wanted[item.Name] = struct{}{}
+
+		local, ok := locals[item.Name]
+		if ok && local.Labels[egressv1.LabelCluster] != name {
+			log.Info("skip importing EgressTunnel, the name is used by another cluster", "tunnel", item.Name)
+			continue
+		}
+		if !ok {
+			local = &egressv1.EgressTunnel{ObjectMeta: metav1.ObjectMeta{
+				Name:   item.Name,
+				Labels: map[string]string{egressv1.LabelCluster: name},
+			}}
+			if err := b.client.Create(ctx, local); err != nil {
+				return err
+			}
+		}
+		parent, ready, err := peerParent(ctx, remote, cluster.PeerAddress, item)
+		if err != nil || !ready {
+			log.Info("skip updating imported EgressTunnel, the peer address is not ready", "tunnel", item.Name, "error", err)
+			continue
+		}
+		status := *item.Status.DeepCopy()
+		status.Tunnel.Parent = parent
+		if equality.Semantic.DeepEqual(local.Status, status) {
+			continue
+		}
+		local.Status = status
+		if err := b.client.Status().Update(ctx, local); err != nil {
+			return err
+		}
+	}
+
+	for _, item := range localList.Items {
+		if _, ok := wanted[item.Name]; ok || item.Labels[egressv1.LabelCluster] != name {
+			continue
+		}
+		if err := b.client.Delete(ctx, &item); err != nil && !k8serr.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// importedGatewayName returns the local name of the imported EgressGateway
+func importedGatewayName(cluster, name string) string {
+	return cluster + "." + name
+}
+
+// importGateways keeps the local copies of the EgressGateways of the remote cluster.
+// The policies in the status are translated to the local policies which they are
+// exported for, and the policies of the remote cluster are dropped.
+func (b *Broker) importGateways(ctx context.Context, name string, remote client.Client, exported []egressv1.EgressClusterPolicy) error {
+	sources := make(map[string]egressv1.Policy)
+	for _, item := range exported {
+		sources[item.Name] = egressv1.Policy{
+			Name:      item.Annotations[egressv1.AnnotationSourceName],
+			Namespace: item.Annotations[egressv1.AnnotationSourceNamespace],
+		}
+	}
+
+	remoteList := new(egressv1.EgressGatewayList)
+	if err := remote.List(ctx, remoteList); err != nil {
+		return err
+	}
+	localList := new(egressv1.EgressGatewayList)
+	if err := b.client.List(ctx, localList, client.MatchingLabels{egressv1.LabelCluster: name}); err != nil {
+		return err
+	}
+	locals := make(map[string]*egressv1.EgressGateway)
+	for i := range localList.Items {
+		locals[localList.Items[i].Name] = &localList.Items[i]
+	}
+
+	wanted := make(map[string]struct{})
+	for _, item := range remoteList.Items {
+		if egressv1.IsImported(item.Labels) {
+			continue
+		}
+		localName := importedGatewayName(name, item.Name)
+		wanted[localName] = struct{}{}
+
+		local, ok := locals[localName]
+		if !ok {
+			local = &egressv1.EgressGateway{ObjectMeta: metav1.ObjectMeta{
+				Name:   localName,
+				Labels: map[string]string{egressv1.LabelCluster: name},
+			}}
+			local.Spec = item.Spec
+			local.Spec.ClusterDefault = false
+			if err := b.client.Create(ctx, local); err != nil {
+				return err
+			}
+		}
+
+		status := translateGatewayStatus(item.Status, sources)
+		if equality.Semantic.DeepEqual(local.Status, status) {
+			continue
+		}
+		local.Status = status
+		if err := b.client.Status().Update(ctx, local); err != nil {
+			return err
+		}
+	}
+
+	for _, item := range localList.Items {
+		if _, ok := wanted[item.Name]; ok {
+			continue
+		}
+		if err := b.client.Delete(ctx, &item); err != nil && !k8serr.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func translateGatewayStatus(status egressv1.EgressGatewayStatus, sources map[string]egressv1.Policy) egressv1.EgressGatewayStatus {
+	res := *status.DeepCopy()
+	for i, node := range res.NodeList {
+		for j, eip := range node.Eips {
+			policies := make([]egressv1.Policy, 0)
+			for _, p := range eip.Policies {
+				if source, ok := sources[p.Name]; ok && p.Namespace == "" {
+					policies = append(policies, source)
+				}
+			}
+			res.NodeList[i].Eips[j].Policies = policies
+		}
+	}
+	return res
+}
+
+// exportedPolicyName returns the name of the EgressClusterPolicy exported for the policy
+func exportedPolicyName(cluster string, policy egressv1.Policy) string {
+	if policy.Namespace == "" {
+		return cluster + "." + policy.Name
+	}
+	return cluster + "." + policy.Namespace + "." + policy.Name
+}
+
+// exportedPolicy is the EgressClusterPolicy exported for a local policy
+type exportedPolicy struct {
+	source egressv1.Policy
+	spec   egressv1.EgressClusterPolicySpec
+	status *egressv1.EgressPolicyStatus
+	object client.Object
+}
+
+// exportPolicies keeps the EgressClusterPolicies in the remote cluster for the local
+// policies using the EgressGateways imported from it, and copies the egress IP and
+// the gateway node of the exported policies to the local policies.
+func (b *Broker) exportPolicies(ctx context.Context, name string, remote client.Client, exported []egressv1.EgressClusterPolicy, log logr.Logger) error {
+	local := b.cfg.FileConfig.MultiCluster.ClusterName
+	gateways := make(map[string]string)
+	gatewayList := new(egressv1.EgressGatewayList)
+	if err := b.client.List(ctx, gatewayList, client.MatchingLabels{egressv1.LabelCluster: name}); err != nil {
+		return err
+	}
+	prefix := importedGatewayName(name, "")
+	for _, item := range gatewayList.Items {
+		gateways[item.Name] = item.Name[len(prefix):]
+	}
+
+	wanted := make(map[string]exportedPolicy)
+	policies := new(egressv1.EgressPolicyList)
+	if err := b.client.List(ctx, policies); err != nil {
+		return err
+	}
+	for i := range policies.Items {
+		item := &policies.Items[i]
+		gateway, ok := gateways[item.Spec.EgressGatewayName]
+		if !ok || !item.DeletionTimestamp.IsZero() {
+			continue
+		}
+		source := egressv1.Policy{Name: item.Name, Namespace: item.Namespace}
+		ips, err := b.endpointIPs(ctx, source, item.Spec.AppliedTo.StaticEndpoints)
+		if err != nil {
+			return err
+		}
+		wanted[exportedPolicyName(local, source)] = exportedPolicy{
+			source: source,
+			spec:   exportedSpec(gateway, item.Spec.EgressIP, ips, item.DestSubnets(), item.Spec.Priority, item.Spec.Limits),
+			status: &item.Status,
+			object: item,
+		}
+	}
+	clusterPolicies := new(egressv1.EgressClusterPolicyList)
+	if err := b.client.List(ctx, clusterPolicies); err != nil {
+		return err
+	}
+	for i := range clusterPolicies.Items {
+		item := &clusterPolicies.Items[i]
+		gateway, ok := gateways[item.Spec.EgressGatewayName]
+		if !ok || !item.DeletionTimestamp.IsZero() {
+			continue
+		}
+		source := egressv1.Policy{Name: item.Name}
+		ips, err := b.endpointIPs(ctx, source, item.Spec.AppliedTo.StaticEndpoints)
+		if err != nil {
+			return err
+		}
+		wanted[exportedPolicyName(local, source)] = exportedPolicy{
+			source: source,
+			spec:   exportedSpec(gateway, item.Spec.EgressIP, ips, item.DestSubnets(), item.Spec.Priority, item.Spec.Limits),
+			status: &item.Status,
+			object: item,
+		}
+	}
+
+	existing := make(map[string]*egressv1.EgressClusterPolicy)
+	for i := range exported {
+		existing[exported[i].Name] = &exported[i]
+	}
+
+	for exportedName, want := range wanted {
+		// the remote cluster denies the policies without any endpoints
+		if len(want.spec.AppliedTo.StaticEndpoints) == 0 {
+			continue
+		}
+		obj, ok := existing[exportedName]
+		if !ok {
+			obj = &egressv1.EgressClusterPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   exportedName,
+					Labels: map[string]string{egressv1.LabelCluster: local},
+					Annotations: map[string]string{
+						egressv1.AnnotationSourceNamespace: want.source.Namespace,
+						egressv1.AnnotationSourceName:      want.source.Name,
+					},
+				},
+				Spec: want.spec,
+			}
+			if err := remote.Create(ctx, obj); err != nil {
+				log.Error(err, "failed to export policy", "policy", want.source)
+			}
+			continue
+		}
+		if obj.Annotations[egressv1.AnnotationSourceNamespace] != want.source.Namespace ||
+			obj.Annotations[egressv1.AnnotationSourceName] != want.source.Name {
+			log.Info("skip exporting policy, the name is used by another policy", "policy", want.source, "exported", exportedName)
+			continue
+		}
+		// the egress IP of policies is immutable
+		if !equality.Semantic.DeepEqual(obj.Spec.AppliedTo, want.spec.AppliedTo) ||
+			!equality.Semantic.DeepEqual(obj.Spec.DestSubnet, want.spec.DestSubnet) ||
+			obj.Spec.Priority != want.spec.Priority ||
+			!equality.Semantic.DeepEqual(obj.Spec.Limits, want.spec.Limits) {
+			obj.Spec.AppliedTo = want.spec.AppliedTo
+			obj.Spec.DestSubnet = want.spec.DestSubnet
+			obj.Spec.Priority = want.spec.Priority
+			obj.Spec.Limits = want.spec.Limits
+			if err := remote.Update(ctx, obj); err != nil {
+				log.Error(err, "failed to update exported policy", "policy", want.source)
+				continue
+			}
+		}
+		if err := b.updateSourceStatus(ctx, want, obj.Status); err != nil {
+			log.Error(err, "failed to update policy status", "policy", want.source)
+		}
+	}
+
+	for exportedName, obj := range existing {
+		if want, ok := wanted[exportedName]; ok && len(want.spec.AppliedTo.StaticEndpoints) != 0 {
+			continue
+		}
+		if err := remote.Delete(ctx, obj); err != nil && !k8serr.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportedSpec(gateway string, eip egressv1.EgressIP, ips, destSubnet []string, priority uint64,
+	limits *egressv1.ConnectionLimits) egressv1.EgressClusterPolicySpec {
+	return egressv1.EgressClusterPolicySpec{
+		EgressGatewayName: gateway,
+		EgressIP:          eip,
+		AppliedTo:         egressv1.ClusterAppliedTo{StaticEndpoints: ips},
+		DestSubnet:        destSubnet,
+		Priority:          priority,
+		Limits:            limits,
+	}
+}
+
+// endpointIPs returns the IPs of the endpoint slices and the static endpoints of the policy
+func (b *Broker) endpointIPs(ctx context.Context, policy egressv1.Policy, static []string) ([]string, error) {
+	set := make(map[string]struct{})
+	for _, item := range static {
+		set[item] = struct{}{}
+	}
+	add := func(endpoints []egressv1.EgressEndpoint) {
+		for _, ep := range endpoints {
+			for _, ip := range ep.IPv4 {
+				set[ip] = struct{}{}
+			}
+			for _, ip := range ep.IPv6 {
+				set[ip] = struct{}{}
+			}
+		}
+	}
+
+	selector := client.MatchingLabels{egressv1.LabelPolicyName: policy.Name}
+	if policy.Namespace == "" {
+		slices := new(egressv1.EgressClusterEndpointSliceList)
+		if err := b.client.List(ctx, slices, selector); err != nil {
+			return nil, err
+		}
+		for _, item := range slices.Items {
+			add(item.Endpoints)
+		}
+	} else {
+		slices := new(egressv1.EgressEndpointSliceList)
+		if err := b.client.List(ctx, slices, selector, client.InNamespace(policy.Namespace)); err != nil {
+			return nil, err
+		}
+		for _, item := range slices.Items {
+			add(item.Endpoints)
+		}
+	}
+
+	res := make([]string, 0, len(set))
+	for ip := range set {
+		res = append(res, ip)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// updateSourceStatus copies the egress IP and the gateway node of the exported policy
+// to the local policy
+func (b *Broker) updateSourceStatus(ctx context.Context, want exportedPolicy, status egressv1.EgressPolicyStatus) error {
+	if want.status.Eip == status.Eip && want.status.Node == status.Node {
+		return nil
+	}
+	want.status.Eip = status.Eip
+	want.status.Node = status.Node
+	want.status.SetReadyCondition(want.object.GetGeneration())
+	return b.client.Status().Update(ctx, want.object)
+}
@@ -0,0 +1,192 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package multicluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func newFakeClient(objs ...client.Object) client.Client {
+	return fake.NewClientBuilder().WithScheme(schema.GetScheme()).
+		WithStatusSubresource(&egressv1.EgressTunnel{}, &egressv1.EgressGateway{},
+			&egressv1.EgressPolicy{}, &egressv1.EgressClusterPolicy{}).
+		WithObjects(objs...).Build()
+}
+
+func TestBrokerSyncCluster(t *testing.T) {
+	ctx := context.TODO()
+
+	remote := newFakeClient(
+		&egressv1.EgressTunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-b"},
+			Status: egressv1.EgressTunnelStatus{
+				Tunnel: egressv1.Tunnel{IPv4: "192.200.0.2", MAC: "66:50:00:00:00:02"},
+				Phase:  egressv1.EgressTunnelReady,
+			},
+		},
+		&egressv1.EgressGateway{
+			ObjectMeta: metav1.ObjectMeta{Name: "egw"},
+			Status: egressv1.EgressGatewayStatus{
+				NodeList: []egressv1.EgressIPStatus{{
+					Name:   "node-b",
+					Status: string(egressv1.EgressTunnelReady),
+					Eips: []egressv1.Eips{{
+						IPv4:     "10.6.1.21",
+						Policies: []egressv1.Policy{{Name: "remote-policy", Namespace: "default"}},
+					}},
+				}},
+			},
+		},
+	)
+	local := newFakeClient(
+		&egressv1.EgressPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "default"},
+			Spec: egressv1.EgressPolicySpec{
+				EgressGatewayName: "b.egw",
+				DestSubnet:        []string{"10.10.0.0/16"},
+			},
+		},
+		&egressv1.EgressEndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "policy-abcde",
+				Namespace: "default",
+				Labels:    map[string]string{egressv1.LabelPolicyName: "policy"},
+			},
+			Endpoints: []egressv1.EgressEndpoint{
+				{Pod: "pod1", IPv4: []string{"10.21.0.5"}},
+				{Pod: "pod2", IPv4: []string{"10.21.0.3"}},
+			},
+		},
+	)
+
+	b := &Broker{
+		client: local,
+		cfg: &config.Config{FileConfig: config.FileConfig{
+			MultiCluster: config.MultiCluster{Enable: true, ClusterName: "a"},
+		}},
+		log: logr.Discard(),
+	}
+
+	// import the tunnels and gateways, and export the policy
+	err := b.syncCluster(ctx, config.RemoteCluster{Name: "b"}, remote, b.log)
+	assert.NoError(t, err)
+	err = b.syncCluster(ctx, config.RemoteCluster{Name: "b"}, remote, b.log)
+	assert.NoError(t, err)
+
+	tunnel := new(egressv1.EgressTunnel)
+	assert.NoError(t, local.Get(ctx, types.NamespacedName{Name: "node-b"}, tunnel))
+	assert.Equal(t, "b", tunnel.Labels[egressv1.LabelCluster])
+	assert.Equal(t, "192.200.0.2", tunnel.Status.Tunnel.IPv4)
+
+	exported := new(egressv1.EgressClusterPolicy)
+	assert.NoError(t, remote.Get(ctx, types.NamespacedName{Name: "a.default.policy"}, exported))
+	assert.Equal(t, "egw", exported.Spec.EgressGatewayName)
+	assert.Equal(t, []string{"10.21.0.3", "10.21.0.5"}, exported.Spec.AppliedTo.StaticEndpoints)
+	assert.Equal(t, []string{"10.10.0.0/16"}, exported.Spec.DestSubnet)
+
+	// the remote cluster assigns the exported policy
+	gateway := new(egressv1.EgressGateway)
+	assert.NoError(t, remote.Get(ctx, types.NamespacedName{Name: "egw"}, gateway))
+	gateway.Status.NodeList[0].Eips[0].Policies = append(gateway.Status.NodeList[0].Eips[0].Policies,
+		egressv1.Policy{Name: "a.default.policy"})
+	assert.NoError(t, remote.Status().Update(ctx, gateway))
+	exported.Status.Eip.Ipv4 = "10.6.1.21"
+	exported.Status.Node = "node-b"
+	assert.NoError(t, remote.Status().Update(ctx, exported))
+
+	err = b.syncCluster(ctx, config.RemoteCluster{Name: "b"}, remote, b.log)
+	assert.NoError(t, err)
+
+	policy := new(egressv1.EgressPolicy)
+	assert.NoError(t, local.Get(ctx, types.NamespacedName{Name: "policy", Namespace: "default"}, policy))
+	assert.Equal(t, "node-b", policy.Status.Node)
+	assert.Equal(t, "10.6.1.21", policy.Status.Eip.Ipv4)
+
+	imported := new(egressv1.EgressGateway)
+	assert.NoError(t, local.Get(ctx, types.NamespacedName{Name: "b.egw"}, imported))
+	assert.Equal(t, []egressv1.Policy{{Name: "policy", Namespace: "default"}},
+		imported.Status.NodeList[0].Eips[0].Policies)
+
+	// the exported policy is deleted with the local policy
+	assert.NoError(t, local.Delete(ctx, policy))
+	err = b.syncCluster(ctx, config.RemoteCluster{Name: "b"}, remote, b.log)
+	assert.NoError(t, err)
+	list := new(egressv1.EgressClusterPolicyList)
+	assert.NoError(t, remote.List(ctx, list))
+	assert.Empty(t, list.Items)
+
+	// the imported objects are deleted with the remote objects
+	assert.NoError(t, remote.Delete(ctx, gateway))
+	assert.NoError(t, remote.Delete(ctx, &egressv1.EgressTunnel{ObjectMeta: metav1.ObjectMeta{Name: "node-b"}}))
+	err = b.syncCluster(ctx, config.RemoteCluster{Name: "b"}, remote, b.log)
+	assert.NoError(t, err)
+	tunnels := new(egressv1.EgressTunnelList)
+	assert.NoError(t, local.List(ctx, tunnels))
+	assert.Empty(t, tunnels.Items)
+	gateways := new(egressv1.EgressGatewayList)
+	assert.NoError(t, local.List(ctx, gateways))
+	assert.Empty(t, gateways.Items)
+}
+
+func TestPeerParent(t *testing.T) {
+	ctx := context.TODO()
+
+	ciliumNode := new(unstructured.Unstructured)
+	ciliumNode.SetGroupVersionKind(ciliumNodeGVK)
+	ciliumNode.SetName("node-b")
+	assert.NoError(t, unstructured.SetNestedSlice(ciliumNode.Object, []interface{}{
+		map[string]interface{}{"type": "InternalIP", "ip": "172.18.0.3"},
+		map[string]interface{}{"type": "CiliumInternalIP", "ip": "10.244.1.250"},
+	}, "spec", "addresses"))
+
+	remote := newFakeClient(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        "node-b",
+			Annotations: map[string]string{annotationSubmarinerCNIIP: "10.245.1.1"},
+		}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-c"}},
+		ciliumNode,
+	)
+	tunnel := func(name string) egressv1.EgressTunnel {
+		return egressv1.EgressTunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: egressv1.EgressTunnelStatus{Tunnel: egressv1.Tunnel{
+				Parent: egressv1.Parent{Name: "eth0", IPv4: "172.18.0.3"},
+			}},
+		}
+	}
+
+	parent, ready, err := peerParent(ctx, remote, config.PeerAddressNode, tunnel("node-b"))
+	assert.NoError(t, err)
+	assert.True(t, ready)
+	assert.Equal(t, "172.18.0.3", parent.IPv4)
+
+	parent, ready, err = peerParent(ctx, remote, config.PeerAddressSubmariner, tunnel("node-b"))
+	assert.NoError(t, err)
+	assert.True(t, ready)
+	assert.Equal(t, egressv1.Parent{Name: "eth0", IPv4: "10.245.1.1"}, parent)
+
+	_, ready, err = peerParent(ctx, remote, config.PeerAddressSubmariner, tunnel("node-c"))
+	assert.NoError(t, err)
+	assert.False(t, ready)
+
+	parent, ready, err = peerParent(ctx, remote, config.PeerAddressCiliumClusterMesh, tunnel("node-b"))
+	assert.NoError(t, err)
+	assert.True(t, ready)
+	assert.Equal(t, "10.244.1.250", parent.IPv4)
+}
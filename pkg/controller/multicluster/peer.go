@@ -0,0 +1,91 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package multicluster
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+const (
+	// annotationSubmarinerCNIIP is the IP of the CNI interface of the node annotated by
+	// the route agent of Submariner, which is reachable from the peer clusters
+	annotationSubmarinerCNIIP = "submariner.io/cni-iface-ip"
+
+	ciliumInternalIP = "CiliumInternalIP"
+)
+
+var ciliumNodeGVK = schema.GroupVersionKind{Group: "cilium.io", Version: "v2", Kind: "CiliumNode"}
+
+// peerParent returns the underlay address of the imported EgressTunnel of the node. With
+// Submariner or Cilium cluster mesh, the address routed through the inter-cluster tunnel
+// is used, so that the VXLAN traffic to the remote gateway node goes through it. The
+// second result is false if the address of the node is not ready.
+func peerParent(ctx context.Context, remote client.Client, method string, tunnel egressv1.EgressTunnel) (egressv1.Parent, bool, error) {
+	parent := tunnel.Status.Tunnel.Parent
+	switch method {
+	case "", config.PeerAddressNode:
+		return parent, true, nil
+
+	case config.PeerAddressSubmariner:
+		node := new(corev1.Node)
+		if err := remote.Get(ctx, types.NamespacedName{Name: tunnel.Name}, node); err != nil {
+			return parent, false, err
+		}
+		ip := net.ParseIP(node.Annotations[annotationSubmarinerCNIIP])
+		if ip == nil {
+			return parent, false, nil
+		}
+		return setParentIP(parent, []net.IP{ip}), true, nil
+
+	case config.PeerAddressCiliumClusterMesh:
+		node := new(unstructured.Unstructured)
+		node.SetGroupVersionKind(ciliumNodeGVK)
+		if err := remote.Get(ctx, types.NamespacedName{Name: tunnel.Name}, node); err != nil {
+			return parent, false, err
+		}
+		addresses, _, err := unstructured.NestedSlice(node.Object, "spec", "addresses")
+		if err != nil {
+			return parent, false, err
+		}
+		ips := make([]net.IP, 0)
+		for _, item := range addresses {
+			address, ok := item.(map[string]interface{})
+			if !ok || address["type"] != ciliumInternalIP {
+				continue
+			}
+			if ip := net.ParseIP(fmt.Sprint(address["ip"])); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+		if len(ips) == 0 {
+			return parent, false, nil
+		}
+		return setParentIP(parent, ips), true, nil
+	}
+	return parent, false, fmt.Errorf("unknown peer address %s", method)
+}
+
+// setParentIP replaces the addresses of the parent with the IPs of the same family
+func setParentIP(parent egressv1.Parent, ips []net.IP) egressv1.Parent {
+	res := egressv1.Parent{Name: parent.Name}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			res.IPv4 = ip.String()
+		} else {
+			res.IPv6 = ip.String()
+		}
+	}
+	return res
+}
@@ -0,0 +1,345 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package networkpolicy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ciliumNamespaceLabel is the label of the namespace of the endpoints in Cilium
+const ciliumNamespaceLabel = "io.kubernetes.pod.namespace"
+
+// netPolicy is a NetworkPolicy or a CiliumNetworkPolicy isolating the egress of the Pods
+// it selects, only the destinations out of the cluster are considered
+type netPolicy struct {
+	name      string
+	namespace string
+	cilium    bool
+	selector  labels.Selector
+	allow     []egressRule
+	deny      []egressRule
+}
+
+// egressRule is the destinations which an egress rule allows or denies
+type egressRule struct {
+	all    bool
+	blocks []ipBlock
+}
+
+type ipBlock struct {
+	cidr   *net.IPNet
+	except []*net.IPNet
+}
+
+// fromNetworkPolicy returns the policy if it isolates the egress of the Pods it selects.
+// The peers selecting the Pods and the namespaces are in the cluster, so they are skipped
+func fromNetworkPolicy(np *networkingv1.NetworkPolicy) (*netPolicy, error) {
+	isolated := len(np.Spec.PolicyTypes) == 0 && len(np.Spec.Egress) != 0
+	for _, item := range np.Spec.PolicyTypes {
+		if item == networkingv1.PolicyTypeEgress {
+			isolated = true
+		}
+	}
+	if !isolated {
+		return nil, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(&np.Spec.PodSelector)
+	if err != nil {
+		return nil, err
+	}
+	res := &netPolicy{
+		name:      fmt.Sprintf("NetworkPolicy %s/%s", np.Namespace, np.Name),
+		namespace: np.Namespace,
+		selector:  selector,
+	}
+	for _, rule := range np.Spec.Egress {
+		item := egressRule{all: len(rule.To) == 0}
+		for _, peer := range rule.To {
+			if peer.IPBlock == nil {
+				continue
+			}
+			block, err := parseIPBlock(peer.IPBlock.CIDR, peer.IPBlock.Except)
+			if err != nil {
+				return nil, err
+			}
+			item.blocks = append(item.blocks, block)
+		}
+		res.allow = append(res.allow, item)
+	}
+	return res, nil
+}
+
+// ciliumSpec is the part of the spec of CiliumNetworkPolicy about the egress to the
+// destinations out of the cluster
+type ciliumSpec struct {
+	EndpointSelector *metav1.LabelSelector `json:"endpointSelector,omitempty"`
+	Egress           []ciliumEgressRule    `json:"egress,omitempty"`
+	EgressDeny       []ciliumEgressRule    `json:"egressDeny,omitempty"`
+}
+
+type ciliumEgressRule struct {
+	ToEntities  []string         `json:"toEntities,omitempty"`
+	ToCIDR      []string         `json:"toCIDR,omitempty"`
+	ToCIDRSet   []ciliumCIDRRule `json:"toCIDRSet,omitempty"`
+	ToEndpoints []interface{}    `json:"toEndpoints,omitempty"`
+	ToNodes     []interface{}    `json:"toNodes,omitempty"`
+	ToGroups    []interface{}    `json:"toGroups,omitempty"`
+	ToRequires  []interface{}    `json:"toRequires,omitempty"`
+	ToServices  []interface{}    `json:"toServices,omitempty"`
+	ToFQDNs     []interface{}    `json:"toFQDNs,omitempty"`
+}
+
+type ciliumCIDRRule struct {
+	CIDR   string   `json:"cidr,omitempty"`
+	Except []string `json:"except,omitempty"`
+}
+
+// fromCiliumNetworkPolicy returns the policies in spec and specs of the CiliumNetworkPolicy
+// which isolate the egress of the endpoints they select
+func fromCiliumNetworkPolicy(obj *unstructured.Unstructured) ([]*netPolicy, error) {
+	specs := make([]interface{}, 0)
+	if spec, ok := obj.Object["spec"]; ok {
+		specs = append(specs, spec)
+	}
+	if list, ok := obj.Object["specs"].([]interface{}); ok {
+		specs = append(specs, list...)
+	}
+
+	res := make([]*netPolicy, 0)
+	for _, item := range specs {
+		raw, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		spec := new(ciliumSpec)
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, spec); err != nil {
+			return nil, err
+		}
+		if spec.EndpointSelector == nil || (spec.Egress == nil && spec.EgressDeny == nil) {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(ciliumSelector(spec.EndpointSelector))
+		if err != nil {
+			return nil, err
+		}
+		policy := &netPolicy{
+			name:      fmt.Sprintf("CiliumNetworkPolicy %s/%s", obj.GetNamespace(), obj.GetName()),
+			namespace: obj.GetNamespace(),
+			cilium:    true,
+			selector:  selector,
+		}
+		for _, rule := range spec.Egress {
+			item, err := rule.toEgressRule(true)
+			if err != nil {
+				return nil, err
+			}
+			policy.allow = append(policy.allow, item)
+		}
+		for _, rule := range spec.EgressDeny {
+			item, err := rule.toEgressRule(false)
+			if err != nil {
+				return nil, err
+			}
+			policy.deny = append(policy.deny, item)
+		}
+		res = append(res, policy)
+	}
+	return res, nil
+}
+
+// ciliumSelector removes the source prefixes of the keys, such as `k8s:`
+func ciliumSelector(in *metav1.LabelSelector) *metav1.LabelSelector {
+	trim := func(key string) string {
+		for _, prefix := range []string{"k8s:", "any:"} {
+			key = strings.TrimPrefix(key, prefix)
+		}
+		return key
+	}
+	out := &metav1.LabelSelector{MatchLabels: make(map[string]string)}
+	for key, val := range in.MatchLabels {
+		out.MatchLabels[trim(key)] = val
+	}
+	for _, item := range in.MatchExpressions {
+		item.Key = trim(item.Key)
+		out.MatchExpressions = append(out.MatchExpressions, item)
+	}
+	return out
+}
+
+// toEgressRule converts the rule, the rules of the allowed FQDNs and Services are taken as
+// allowing all the destinations since they can't be evaluated here
+func (rule ciliumEgressRule) toEgressRule(allow bool) (egressRule, error) {
+	res := egressRule{}
+	selectors := len(rule.ToEntities) + len(rule.ToCIDR) + len(rule.ToCIDRSet) + len(rule.ToEndpoints) +
+		len(rule.ToNodes) + len(rule.ToGroups) + len(rule.ToRequires) + len(rule.ToServices) + len(rule.ToFQDNs)
+	if selectors == 0 || (allow && (len(rule.ToFQDNs) != 0 || len(rule.ToServices) != 0)) {
+		res.all = true
+		return res, nil
+	}
+	for _, entity := range rule.ToEntities {
+		switch entity {
+		case "all", "world":
+			res.all = true
+		case "world-ipv4":
+			block, _ := parseIPBlock("0.0.0.0/0", nil)
+			res.blocks = append(res.blocks, block)
+		case "world-ipv6":
+			block, _ := parseIPBlock("::/0", nil)
+			res.blocks = append(res.blocks, block)
+		}
+	}
+	for _, cidr := range rule.ToCIDR {
+		block, err := parseIPBlock(cidr, nil)
+		if err != nil {
+			return res, err
+		}
+		res.blocks = append(res.blocks, block)
+	}
+	for _, item := range rule.ToCIDRSet {
+		if item.CIDR == "" {
+			continue
+		}
+		block, err := parseIPBlock(item.CIDR, item.Except)
+		if err != nil {
+			return res, err
+		}
+		res.blocks = append(res.blocks, block)
+	}
+	return res, nil
+}
+
+func parseIPBlock(cidr string, except []string) (ipBlock, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return ipBlock{}, err
+	}
+	res := ipBlock{cidr: ipNet}
+	for _, item := range except {
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return ipBlock{}, err
+		}
+		res.except = append(res.except, ipNet)
+	}
+	return res, nil
+}
+
+// allows returns true if the rule allows any part of the destination
+func (rule egressRule) allows(dst *net.IPNet) bool {
+	if rule.all {
+		return true
+	}
+	for _, block := range rule.blocks {
+		if !overlaps(block.cidr, dst) {
+			continue
+		}
+		excepted := false
+		for _, item := range block.except {
+			if contains(item, dst) {
+				excepted = true
+				break
+			}
+		}
+		if !excepted {
+			return true
+		}
+	}
+	return false
+}
+
+// denies returns true if the rule denies the whole destination
+func (rule egressRule) denies(dst *net.IPNet) bool {
+	if rule.all {
+		return true
+	}
+	for _, block := range rule.blocks {
+		if !contains(block.cidr, dst) {
+			continue
+		}
+		excepted := false
+		for _, item := range block.except {
+			if overlaps(item, dst) {
+				excepted = true
+				break
+			}
+		}
+		if !excepted {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns true if a contains the whole b
+func contains(a, b *net.IPNet) bool {
+	onesA, bitsA := a.Mask.Size()
+	onesB, bitsB := b.Mask.Size()
+	return bitsA == bitsB && onesA <= onesB && a.Contains(b.IP)
+}
+
+func overlaps(a, b *net.IPNet) bool {
+	return contains(a, b) || contains(b, a)
+}
+
+// check returns the destinations of the Pod which are entirely blocked, and the policies
+// isolating the egress of the Pod. The egress of the Pod is isolated once any policy of
+// its namespace selects it, then only the destinations allowed by them are allowed
+func check(namespace string, podLabels map[string]string, dsts []*net.IPNet, policies []*netPolicy) ([]string, []string) {
+	k8sLabels := labels.Set(podLabels)
+	ciliumLabels := labels.Set{ciliumNamespaceLabel: namespace}
+	for key, val := range podLabels {
+		ciliumLabels[key] = val
+	}
+
+	selected := make([]*netPolicy, 0)
+	for _, item := range policies {
+		if item.namespace != namespace {
+			continue
+		}
+		set := k8sLabels
+		if item.cilium {
+			set = ciliumLabels
+		}
+		if item.selector.Matches(set) {
+			selected = append(selected, item)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, nil
+	}
+
+	blocked := make([]string, 0)
+	for _, dst := range dsts {
+		allowed, denied := false, false
+		for _, policy := range selected {
+			for _, rule := range policy.allow {
+				allowed = allowed || rule.allows(dst)
+			}
+			for _, rule := range policy.deny {
+				denied = denied || rule.denies(dst)
+			}
+		}
+		if !allowed || denied {
+			blocked = append(blocked, dst.String())
+		}
+	}
+	if len(blocked) == 0 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(selected))
+	for _, item := range selected {
+		names = append(names, item.name)
+	}
+	sort.Strings(names)
+	return blocked, names
+}
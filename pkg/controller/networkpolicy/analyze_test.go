@@ -0,0 +1,155 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package networkpolicy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func cidrs(list ...string) []*net.IPNet {
+	res := make([]*net.IPNet, 0, len(list))
+	for _, item := range list {
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			panic(err)
+		}
+		res = append(res, ipNet)
+	}
+	return res
+}
+
+func TestCheckNetworkPolicy(t *testing.T) {
+	denyAll := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deny-all"},
+		Spec: networkingv1.NetworkPolicySpec{
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+		},
+	}
+	allowOffice := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "allow-office"},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			Egress: []networkingv1.NetworkPolicyEgressRule{{
+				To: []networkingv1.NetworkPolicyPeer{
+					{PodSelector: &metav1.LabelSelector{}},
+					{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.6.0.0/16"}}},
+				},
+			}},
+		},
+	}
+	ingressOnly := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ingress-only"},
+		Spec: networkingv1.NetworkPolicySpec{
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		},
+	}
+
+	policies := make([]*netPolicy, 0)
+	for _, item := range []*networkingv1.NetworkPolicy{allowOffice, ingressOnly} {
+		policy, err := fromNetworkPolicy(item)
+		assert.NoError(t, err)
+		if policy != nil {
+			policies = append(policies, policy)
+		}
+	}
+	assert.Len(t, policies, 1)
+
+	// the Pods not selected are not isolated
+	blocked, by := check("default", map[string]string{"app": "db"}, cidrs("0.0.0.0/0"), policies)
+	assert.Nil(t, blocked)
+	assert.Nil(t, by)
+	// the Pods of other namespaces are not isolated
+	blocked, _ = check("kube-system", map[string]string{"app": "web"}, cidrs("0.0.0.0/0"), policies)
+	assert.Nil(t, blocked)
+
+	dsts := cidrs("10.1.0.0/16", "10.6.1.0/24", "192.168.0.0/16", "0.0.0.0/0")
+	blocked, by = check("default", map[string]string{"app": "web"}, dsts, policies)
+	assert.Equal(t, []string{"10.6.1.0/24", "192.168.0.0/16"}, blocked)
+	assert.Equal(t, []string{"NetworkPolicy default/allow-office"}, by)
+
+	policy, err := fromNetworkPolicy(denyAll)
+	assert.NoError(t, err)
+	blocked, by = check("default", map[string]string{"app": "db"}, cidrs("1.1.1.1/32"), append(policies, policy))
+	assert.Equal(t, []string{"1.1.1.1/32"}, blocked)
+	assert.Equal(t, []string{"NetworkPolicy default/deny-all"}, by)
+	// the policies are additive
+	blocked, by = check("default", map[string]string{"app": "web"}, cidrs("10.1.0.0/16", "1.1.1.1/32"), append(policies, policy))
+	assert.Equal(t, []string{"1.1.1.1/32"}, blocked)
+	assert.Equal(t, []string{"NetworkPolicy default/allow-office", "NetworkPolicy default/deny-all"}, by)
+}
+
+func TestCheckCiliumNetworkPolicy(t *testing.T) {
+	cnp := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cilium.io/v2",
+		"kind":       "CiliumNetworkPolicy",
+		"metadata":   map[string]interface{}{"namespace": "default", "name": "web"},
+		"specs": []interface{}{
+			map[string]interface{}{
+				"endpointSelector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{"k8s:app": "web"},
+				},
+				"egress": []interface{}{
+					map[string]interface{}{"toEndpoints": []interface{}{map[string]interface{}{}}},
+					map[string]interface{}{"toEntities": []interface{}{"world-ipv4"}},
+				},
+				"egressDeny": []interface{}{
+					map[string]interface{}{"toCIDRSet": []interface{}{
+						map[string]interface{}{"cidr": "192.168.0.0/16", "except": []interface{}{"192.168.1.0/24"}},
+					}},
+				},
+			},
+			map[string]interface{}{
+				"endpointSelector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{"k8s:io.kubernetes.pod.namespace": "default"},
+				},
+				"ingress": []interface{}{map[string]interface{}{}},
+			},
+		},
+	}}
+	policies, err := fromCiliumNetworkPolicy(cnp)
+	assert.NoError(t, err)
+	assert.Len(t, policies, 1)
+
+	dsts := cidrs("8.8.8.8/32", "192.168.2.0/24", "192.168.1.0/24", "2001:db8::/32")
+	blocked, by := check("default", map[string]string{"app": "web"}, dsts, policies)
+	assert.Equal(t, []string{"192.168.2.0/24", "2001:db8::/32"}, blocked)
+	assert.Equal(t, []string{"CiliumNetworkPolicy default/web"}, by)
+
+	blocked, _ = check("default", map[string]string{"app": "db"}, dsts, policies)
+	assert.Nil(t, blocked)
+
+	// the FQDNs can't be evaluated, so they are taken as allowing all
+	cnp.Object["specs"] = nil
+	cnp.Object["spec"] = map[string]interface{}{
+		"endpointSelector": map[string]interface{}{},
+		"egress": []interface{}{
+			map[string]interface{}{"toFQDNs": []interface{}{map[string]interface{}{"matchName": "example.com"}}},
+		},
+	}
+	policies, err = fromCiliumNetworkPolicy(cnp)
+	assert.NoError(t, err)
+	blocked, _ = check("default", map[string]string{"app": "db"}, dsts, policies)
+	assert.Nil(t, blocked)
+}
+
+func TestEgressRule(t *testing.T) {
+	block, err := parseIPBlock("10.0.0.0/8", []string{"10.6.0.0/16"})
+	assert.NoError(t, err)
+	rule := egressRule{blocks: []ipBlock{block}}
+	assert.True(t, rule.allows(cidrs("10.0.0.0/8")[0]))
+	assert.True(t, rule.allows(cidrs("0.0.0.0/0")[0]))
+	assert.False(t, rule.allows(cidrs("10.6.1.0/24")[0]))
+	assert.False(t, rule.allows(cidrs("::/0")[0]))
+	assert.False(t, rule.denies(cidrs("10.0.0.0/8")[0]))
+	assert.True(t, rule.denies(cidrs("10.7.0.0/16")[0]))
+
+	_, err = parseIPBlock("10.0.0.0", nil)
+	assert.Error(t, err)
+}
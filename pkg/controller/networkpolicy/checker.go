@@ -0,0 +1,383 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package networkpolicy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egresserrors "github.com/spidernet-io/egressgateway/pkg/errors"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/queue"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+const (
+	kindPolicy        = "EgressPolicy"
+	kindClusterPolicy = "EgressClusterPolicy"
+)
+
+// recheckInterval is the interval of checking the policies again, the labels of the Pods
+// are not watched
+const recheckInterval = 10 * time.Minute
+
+var ciliumNetworkPolicyGVK = schema.GroupVersionKind{
+	Group:   "cilium.io",
+	Version: "v2",
+	Kind:    "CiliumNetworkPolicy",
+}
+
+// checker checks whether the NetworkPolicies and the CiliumNetworkPolicies block the egress
+// of the Pods selected by the policies before it reaches the gateway, and reports it by the
+// NetworkPolicyAllowed condition and the events of the policies
+type checker struct {
+	client   client.Client
+	log      logr.Logger
+	recorder record.EventRecorder
+	cfg      *config.Config
+	cilium   bool
+}
+
+// NewNetworkPolicyChecker adds the controller checking the policies against the
+// NetworkPolicies if it's enabled
+func NewNetworkPolicyChecker(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
+	if !cfg.FileConfig.EnableNetworkPolicyCheck {
+		return nil
+	}
+	r := &checker{
+		client:   mgr.GetClient(),
+		log:      log,
+		recorder: mgr.GetEventRecorderFor("networkpolicy-checker"),
+		cfg:      cfg,
+	}
+	c, err := controller.New("networkpolicy-checker", mgr, queue.Options(cfg, "networkpolicy-checker", r, 1))
+	if err != nil {
+		return err
+	}
+
+	if err := c.Watch(source.Kind(mgr.GetCache(), &egressv1.EgressPolicy{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat(kindPolicy)),
+		predicate.GenerationChangedPredicate{}); err != nil {
+		return err
+	}
+	if err := c.Watch(source.Kind(mgr.GetCache(), &egressv1.EgressClusterPolicy{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat(kindClusterPolicy)),
+		predicate.GenerationChangedPredicate{}); err != nil {
+		return err
+	}
+	if err := c.Watch(source.Kind(mgr.GetCache(), &egressv1.EgressEndpointSlice{}),
+		handler.EnqueueRequestsFromMapFunc(sliceToPolicy(kindPolicy))); err != nil {
+		return err
+	}
+	if err := c.Watch(source.Kind(mgr.GetCache(), &egressv1.EgressClusterEndpointSlice{}),
+		handler.EnqueueRequestsFromMapFunc(sliceToPolicy(kindClusterPolicy))); err != nil {
+		return err
+	}
+	if err := c.Watch(source.Kind(mgr.GetCache(), &networkingv1.NetworkPolicy{}),
+		handler.EnqueueRequestsFromMapFunc(r.namespacePolicies)); err != nil {
+		return err
+	}
+
+	if _, err := mgr.GetRESTMapper().RESTMapping(ciliumNetworkPolicyGVK.GroupKind(), ciliumNetworkPolicyGVK.Version); err == nil {
+		log.Info("networkpolicy checker watch CiliumNetworkPolicy")
+		cnp := new(unstructured.Unstructured)
+		cnp.SetGroupVersionKind(ciliumNetworkPolicyGVK)
+		if err := c.Watch(source.Kind(mgr.GetCache(), cnp),
+			handler.EnqueueRequestsFromMapFunc(r.namespacePolicies)); err != nil {
+			return err
+		}
+		r.cilium = true
+	}
+	return nil
+}
+
+// sliceToPolicy maps the endpoint slices to their policies
+func sliceToPolicy(kind string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		name, ok := obj.GetLabels()[egressv1.LabelPolicyName]
+		if !ok {
+			return nil
+		}
+		ns := obj.GetNamespace()
+		if kind == kindClusterPolicy {
+			ns = ""
+		}
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{
+			Namespace: kind + "/" + ns, Name: name,
+		}}}
+	}
+}
+
+// namespacePolicies maps the NetworkPolicies to the EgressPolicies of their namespaces and
+// all the EgressClusterPolicies, which may select the Pods of the namespaces
+func (r *checker) namespacePolicies(ctx context.Context, obj client.Object) []reconcile.Request {
+	res := make([]reconcile.Request, 0)
+	policies := new(egressv1.EgressPolicyList)
+	if err := r.client.List(ctx, policies, client.InNamespace(obj.GetNamespace())); err != nil {
+		r.log.Error(err, "failed to list EgressPolicies", "namespace", obj.GetNamespace())
+	}
+	for _, item := range policies.Items {
+		res = append(res, reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: kindPolicy + "/" + item.Namespace, Name: item.Name,
+		}})
+	}
+	clusterPolicies := new(egressv1.EgressClusterPolicyList)
+	if err := r.client.List(ctx, clusterPolicies); err != nil {
+		r.log.Error(err, "failed to list EgressClusterPolicies")
+	}
+	for _, item := range clusterPolicies.Items {
+		res = append(res, reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: kindClusterPolicy + "/", Name: item.Name,
+		}})
+	}
+	return res
+}
+
+func (r *checker) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	kind, newReq, err := utils.ParseKindWithReq(req)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	log := r.log.WithValues("name", newReq.Name, "namespace", newReq.Namespace, "kind", kind)
+
+	var obj client.Object
+	var status *egressv1.EgressPolicyStatus
+	var dsts []string
+	var endpoints []egressv1.EgressEndpoint
+	switch kind {
+	case kindPolicy:
+		policy := new(egressv1.EgressPolicy)
+		if err := r.client.Get(ctx, newReq.NamespacedName, policy); err != nil {
+			return reconcile.Result{}, client.IgnoreNotFound(err)
+		}
+		obj, status, dsts = policy, &policy.Status, policy.DestSubnets()
+		slices := new(egressv1.EgressEndpointSliceList)
+		if err := r.client.List(ctx, slices, client.InNamespace(policy.Namespace),
+			client.MatchingLabels{egressv1.LabelPolicyName: policy.Name}); err != nil {
+			return reconcile.Result{}, err
+		}
+		for _, item := range slices.Items {
+			endpoints = append(endpoints, item.Endpoints...)
+		}
+	case kindClusterPolicy:
+		policy := new(egressv1.EgressClusterPolicy)
+		if err := r.client.Get(ctx, types.NamespacedName{Name: newReq.Name}, policy); err != nil {
+			return reconcile.Result{}, client.IgnoreNotFound(err)
+		}
+		obj, status, dsts = policy, &policy.Status, policy.DestSubnets()
+		slices := new(egressv1.EgressClusterEndpointSliceList)
+		if err := r.client.List(ctx, slices,
+			client.MatchingLabels{egressv1.LabelPolicyName: policy.Name}); err != nil {
+			return reconcile.Result{}, err
+		}
+		for _, item := range slices.Items {
+			endpoints = append(endpoints, item.Endpoints...)
+		}
+	default:
+		return reconcile.Result{}, nil
+	}
+	if !obj.GetDeletionTimestamp().IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	message, err := r.check(ctx, dsts, endpoints)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	res := reconcile.Result{RequeueAfter: recheckInterval}
+	if !status.SetNetworkPolicyCondition(obj.GetGeneration(), message) {
+		return res, nil
+	}
+	if err := r.client.Status().Update(ctx, obj); err != nil {
+		if k8serr.IsConflict(err) {
+			return reconcile.Result{Requeue: true}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	if message != "" {
+		log.Info("the egress of the policy is blocked", "message", message)
+		r.recorder.Event(obj, corev1.EventTypeWarning, egressv1.ReasonBlockedByNetworkPolicy, message)
+	}
+	return res, nil
+}
+
+// check returns the message describing the blocked egress of the Pods, the Pods with the
+// same namespace and labels are checked once
+func (r *checker) check(ctx context.Context, subnets []string, endpoints []egressv1.EgressEndpoint) (string, error) {
+	dsts, err := r.destinations(subnets)
+	if err != nil || len(dsts) == 0 || len(endpoints) == 0 {
+		return "", err
+	}
+
+	policies := make(map[string][]*netPolicy)
+	type result struct {
+		dsts    []string
+		by      []string
+		example string
+		count   int
+	}
+	checked := make(map[string]*result)
+	keys := make([]string, 0)
+	for _, ep := range endpoints {
+		if _, ok := policies[ep.Namespace]; !ok {
+			list, err := r.listPolicies(ctx, ep.Namespace)
+			if err != nil {
+				return "", err
+			}
+			policies[ep.Namespace] = list
+		}
+		if len(policies[ep.Namespace]) == 0 {
+			continue
+		}
+
+		pod := new(corev1.Pod)
+		if err := r.client.Get(ctx, types.NamespacedName{Namespace: ep.Namespace, Name: ep.Pod}, pod); err != nil {
+			if k8serr.IsNotFound(err) {
+				continue
+			}
+			return "", err
+		}
+		key := ep.Namespace + "/" + labelsKey(pod.Labels)
+		if item, ok := checked[key]; ok {
+			if item.dsts != nil {
+				item.count++
+			}
+			continue
+		}
+		blocked, by := check(ep.Namespace, pod.Labels, dsts, policies[ep.Namespace])
+		checked[key] = &result{dsts: blocked, by: by, example: ep.Namespace + "/" + ep.Pod, count: 1}
+		if blocked != nil {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return "", nil
+	}
+
+	sort.Strings(keys)
+	count := 0
+	for _, key := range keys {
+		count += checked[key].count
+	}
+	first := checked[keys[0]]
+	return fmt.Sprintf("the egress of %d Pods to %s is blocked by %s, e.g. Pod %s",
+		count, strings.Join(first.dsts, ","), strings.Join(first.by, ","), first.example), nil
+}
+
+// destinations returns the destinations of the policy, which are all the destinations of
+// the enabled IP families if it has no destination subnet
+func (r *checker) destinations(subnets []string) ([]*net.IPNet, error) {
+	if equalStrings(subnets, egressv1.NoDestSubnet) {
+		return nil, nil
+	}
+	if len(subnets) == 0 {
+		if r.cfg.FileConfig.EnableIPv4 {
+			subnets = append(subnets, "0.0.0.0/0")
+		}
+		if r.cfg.FileConfig.EnableIPv6 {
+			subnets = append(subnets, "::/0")
+		}
+	}
+	res := make([]*net.IPNet, 0, len(subnets))
+	for _, item := range subnets {
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, egresserrors.Newf(egresserrors.Invalid, "invalid destination subnet %s", item)
+			}
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+		res = append(res, ipNet)
+	}
+	return res, nil
+}
+
+// listPolicies returns the policies of the namespace isolating the egress of the Pods
+func (r *checker) listPolicies(ctx context.Context, namespace string) ([]*netPolicy, error) {
+	res := make([]*netPolicy, 0)
+	list := new(networkingv1.NetworkPolicyList)
+	if err := r.client.List(ctx, list, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		item, err := fromNetworkPolicy(&list.Items[i])
+		if err != nil {
+			r.log.Error(err, "skip the invalid NetworkPolicy", "namespace", namespace, "name", list.Items[i].Name)
+			continue
+		}
+		if item != nil {
+			res = append(res, item)
+		}
+	}
+
+	if !r.cilium {
+		return res, nil
+	}
+	cnps := new(unstructured.UnstructuredList)
+	cnps.SetGroupVersionKind(ciliumNetworkPolicyGVK.GroupVersion().WithKind(ciliumNetworkPolicyGVK.Kind + "List"))
+	if err := r.client.List(ctx, cnps, client.InNamespace(namespace)); err != nil {
+		if meta.IsNoMatchError(err) {
+			return res, nil
+		}
+		return nil, err
+	}
+	for i := range cnps.Items {
+		items, err := fromCiliumNetworkPolicy(&cnps.Items[i])
+		if err != nil {
+			r.log.Error(err, "skip the invalid CiliumNetworkPolicy", "namespace", namespace, "name", cnps.Items[i].GetName())
+			continue
+		}
+		res = append(res, items...)
+	}
+	return res, nil
+}
+
+// labelsKey returns the sorted labels as a string
+func labelsKey(labels map[string]string) string {
+	list := make([]string, 0, len(labels))
+	for key, val := range labels {
+		list = append(list, key+"="+val)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils/apply"
+)
+
+// statusFields is the fields of the status of the policies owned by the policy controllers,
+// the other fields are written by the agents and the other controllers
+var statusFields = apply.Fields{
+	"f:status": apply.Fields{
+		"f:eip":                apply.Fields{},
+		"f:node":               apply.Fields{},
+		"f:nodeIP":             apply.Fields{},
+		"f:observedGeneration": apply.Fields{},
+		"f:selectedGateway":    apply.Fields{},
+		"f:conditions": apply.Fields{
+			`k:{"type":"` + v1beta1.PolicyConditionReady + `"}`: apply.Fields{},
+		},
+	},
+}
+
+func newStatusMigrator(mgr manager.Manager) *apply.Migrator {
+	return apply.NewMigrator(mgr.GetAPIReader(), mgr.GetClient(), apply.FieldOwnerPolicy, "status", statusFields)
+}
+
+// applyStatus applies the EIP, the node, the selected gateway and the Ready condition in the status of the
+// policy by the server-side apply, the status updated by get-modify-update before is
+// migrated first
+func applyStatus(ctx context.Context, cli client.Client, migrator *apply.Migrator,
+	obj client.Object, kind string, status v1beta1.EgressPolicyStatus) error {
+	if migrator != nil {
+		if err := migrator.Migrate(ctx, obj); err != nil {
+			return err
+		}
+	}
+	owned := v1beta1.EgressPolicyStatus{
+		Eip:                status.Eip,
+		Node:               status.Node,
+		NodeIP:             status.NodeIP,
+		ObservedGeneration: status.ObservedGeneration,
+		SelectedGateway:    status.SelectedGateway,
+	}
+	if ready := meta.FindStatusCondition(status.Conditions, v1beta1.PolicyConditionReady); ready != nil {
+		owned.Conditions = []metav1.Condition{*ready}
+	}
+	res, err := apply.StatusObject(obj, v1beta1.GroupVersion.WithKind(kind), &owned)
+	if err != nil {
+		return err
+	}
+	return apply.Status(ctx, cli, res, apply.FieldOwnerPolicy)
+}
@@ -0,0 +1,123 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// cleanupCheckPeriod is the period to check whether the agents have cleaned up the
+// deleted policy, the nodes may be removed without any policy event.
+const cleanupCheckPeriod = time.Second * 10
+
+// reconcilePolicyCleanup reconcile EgressPolicy and EgressClusterPolicy
+// goal:
+// - add the cleanup finalizer to the policy
+// - update the observed generation and the Ready condition of the policy
+// - remove the finalizer after the agents of all nodes have cleaned up the deleted policy
+// - wait for the agents of the nodes whose tunnels are not ready only until the timeout
+func reconcilePolicyCleanup(ctx context.Context, cli client.Client, recorder record.EventRecorder,
+	obj client.Object, status *v1beta1.EgressPolicyStatus, timeout time.Duration,
+	log logr.Logger) (reconcile.Result, error) {
+	err := cli.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{Requeue: true}, err
+	}
+
+	if obj.GetDeletionTimestamp().IsZero() {
+		if controllerutil.AddFinalizer(obj, v1beta1.FinalizerPolicyCleanup) {
+			log.V(1).Info("add policy cleanup finalizer")
+			if err := cli.Update(ctx, obj); err != nil {
+				return reconcile.Result{Requeue: true}, err
+			}
+		}
+		changed := status.SetReadyCondition(obj.GetGeneration())
+		if changed || status.ObservedGeneration != obj.GetGeneration() {
+			status.ObservedGeneration = obj.GetGeneration()
+			if err := cli.Status().Update(ctx, obj); err != nil {
+				return reconcile.Result{Requeue: true}, err
+			}
+		}
+		return reconcile.Result{}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(obj, v1beta1.FinalizerPolicyCleanup) {
+		return reconcile.Result{}, nil
+	}
+
+	pending, notReady, err := pendingCleanupNodes(ctx, cli, status.CleanedNodes)
+	if err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+	if len(pending) > 0 {
+		log.V(1).Info("wait for agents to clean up the policy", "nodes", pending, "notReadyNodes", notReady)
+		return reconcile.Result{RequeueAfter: cleanupCheckPeriod}, nil
+	}
+
+	if len(notReady) > 0 {
+		remaining := obj.GetDeletionTimestamp().Add(timeout).Sub(time.Now())
+		if remaining > 0 {
+			log.V(1).Info("wait for agents of the nodes whose tunnels are not ready to clean up the policy",
+				"nodes", notReady, "remaining", remaining)
+			if remaining > cleanupCheckPeriod {
+				remaining = cleanupCheckPeriod
+			}
+			return reconcile.Result{RequeueAfter: remaining}, nil
+		}
+		log.Info("timed out waiting for the agents of the nodes whose tunnels are not ready, "+
+			"they clean up the policy once they start", "nodes", notReady)
+		recorder.Eventf(obj, corev1.EventTypeWarning, "CleanupTimeout",
+			"Timed out waiting for the agents of the nodes %s to clean up the policy", strings.Join(notReady, ","))
+	}
+	log.Info("all agents have cleaned up the policy, remove the finalizer")
+	controllerutil.RemoveFinalizer(obj, v1beta1.FinalizerPolicyCleanup)
+	if err := cli.Update(ctx, obj); err != nil && !errors.IsNotFound(err) {
+		return reconcile.Result{Requeue: true}, err
+	}
+	return reconcile.Result{}, nil
+}
+
+// pendingCleanupNodes returns the nodes which have not cleaned up the policy, every node
+// running the agent has an EgressTunnel. The nodes whose tunnels are not Ready are returned
+// separately, they are only waited for until the cleanup timeout, so the policy can be
+// deleted while an agent is down. The agent removes the rules of the deleted policies when
+// it starts.
+func pendingCleanupNodes(ctx context.Context, cli client.Client, cleaned []string) ([]string, []string, error) {
+	tunnels := new(v1beta1.EgressTunnelList)
+	if err := cli.List(ctx, tunnels); err != nil {
+		return nil, nil, err
+	}
+	cleanedMap := make(map[string]struct{}, len(cleaned))
+	for _, node := range cleaned {
+		cleanedMap[node] = struct{}{}
+	}
+	pending := make([]string, 0)
+	notReady := make([]string, 0)
+	for _, item := range tunnels.Items {
+		if _, ok := cleanedMap[item.Name]; ok {
+			continue
+		}
+		if item.Status.Phase != v1beta1.EgressTunnelReady {
+			notReady = append(notReady, item.Name)
+			continue
+		}
+		pending = append(pending, item.Name)
+	}
+	return pending, notReady, nil
+}
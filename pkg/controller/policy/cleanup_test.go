@@ -0,0 +1,79 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestReconcilePolicyCleanup(t *testing.T) {
+	ctx := context.Background()
+	policy := &v1beta1.EgressPolicy{ObjectMeta: metav1.ObjectMeta{Name: "p1", Namespace: "default", Generation: 2}}
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(
+			policy,
+			&v1beta1.EgressTunnel{ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+				Status: v1beta1.EgressTunnelStatus{Phase: v1beta1.EgressTunnelReady}},
+			&v1beta1.EgressTunnel{ObjectMeta: metav1.ObjectMeta{Name: "node2"},
+				Status: v1beta1.EgressTunnelStatus{Phase: v1beta1.EgressTunnelReady}},
+			&v1beta1.EgressTunnel{ObjectMeta: metav1.ObjectMeta{Name: "node3"},
+				Status: v1beta1.EgressTunnelStatus{Phase: v1beta1.EgressTunnelHeartbeatTimeout}},
+		).
+		WithStatusSubresource(policy).
+		Build()
+	key := client.ObjectKeyFromObject(policy)
+	recorder := record.NewFakeRecorder(10)
+	timeout := time.Hour
+	reconcile := func() {
+		obj := &v1beta1.EgressPolicy{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+		_, err := reconcilePolicyCleanup(ctx, cli, recorder, obj, &obj.Status, timeout, logr.Discard())
+		assert.NoError(t, err)
+	}
+
+	// the finalizer is added to the policy, and the generation is observed
+	reconcile()
+	assert.NoError(t, cli.Get(ctx, key, policy))
+	assert.True(t, controllerutil.ContainsFinalizer(policy, v1beta1.FinalizerPolicyCleanup))
+	assert.Equal(t, int64(2), policy.Status.ObservedGeneration)
+	assert.True(t, meta.IsStatusConditionFalse(policy.Status.Conditions, v1beta1.PolicyConditionReady))
+
+	// the finalizer is kept until the agents of all ready nodes have cleaned up the policy
+	assert.NoError(t, cli.Delete(ctx, policy))
+	assert.NoError(t, cli.Get(ctx, key, policy))
+	policy.Status.CleanedNodes = []string{"node1"}
+	assert.NoError(t, cli.Status().Update(ctx, policy))
+	reconcile()
+	assert.NoError(t, cli.Get(ctx, key, policy))
+	assert.True(t, controllerutil.ContainsFinalizer(policy, v1beta1.FinalizerPolicyCleanup))
+
+	// the agent of node3 is down, it's waited for until the timeout
+	policy.Status.CleanedNodes = append(policy.Status.CleanedNodes, "node2")
+	assert.NoError(t, cli.Status().Update(ctx, policy))
+	reconcile()
+	assert.NoError(t, cli.Get(ctx, key, policy))
+	assert.True(t, controllerutil.ContainsFinalizer(policy, v1beta1.FinalizerPolicyCleanup))
+	assert.Empty(t, recorder.Events)
+
+	timeout = 0
+	reconcile()
+	assert.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "CleanupTimeout")
+	err := cli.Get(ctx, key, policy)
+	assert.True(t, client.IgnoreNotFound(err) == nil && err != nil, "policy should be deleted")
+}
@@ -6,9 +6,12 @@ package policy
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -19,13 +22,17 @@ import (
 	"github.com/spidernet-io/egressgateway/pkg/config"
 	"github.com/spidernet-io/egressgateway/pkg/egressgateway"
 	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/queue"
 	"github.com/spidernet-io/egressgateway/pkg/utils"
+	"github.com/spidernet-io/egressgateway/pkg/utils/apply"
 )
 
 type egcpReconciler struct {
-	client client.Client
-	log    logr.Logger
-	config *config.Config
+	client   client.Client
+	log      logr.Logger
+	config   *config.Config
+	migrator *apply.Migrator
+	recorder record.EventRecorder
 }
 
 func (r *egcpReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
@@ -39,6 +46,10 @@ func (r *egcpReconciler) Reconcile(ctx context.Context, req reconcile.Request) (
 	switch kind {
 	case "EgressGateway":
 		return r.reconcileEGW(ctx, newReq, log)
+	case "EgressClusterPolicy":
+		policy := &v1beta1.EgressClusterPolicy{ObjectMeta: metav1.ObjectMeta{Name: newReq.Name}}
+		timeout := time.Second * time.Duration(r.config.FileConfig.PolicyCleanupTimeout)
+		return reconcilePolicyCleanup(ctx, r.client, r.recorder, policy, &policy.Status, timeout, log)
 	default:
 		return reconcile.Result{}, nil
 	}
@@ -76,6 +87,8 @@ func (r *egcpReconciler) reconcileEGW(ctx context.Context, req reconcile.Request
 			newEGCP.Status.Eip.Ipv4 = ""
 			newEGCP.Status.Eip.Ipv6 = ""
 			newEGCP.Status.Node = ""
+			newEGCP.Status.NodeIP = v1beta1.Eip{}
+			newEGCP.Status.ObservedGeneration = item.Generation
 
 			policy := v1beta1.Policy{Name: item.Name, Namespace: item.Namespace}
 			eipStatus, isExist := egressgateway.GetEIPStatusByPolicy(policy, *egw)
@@ -90,9 +103,16 @@ func (r *egcpReconciler) reconcileEGW(ctx context.Context, req reconcile.Request
 					}
 				}
 			}
+			if item.Spec.EgressIP.UseNodeIP && newEGCP.Status.Node != "" {
+				newEGCP.Status.NodeIP, err = gatewayNodeIP(ctx, r.client, newEGCP.Status.Node)
+				if err != nil {
+					return reconcile.Result{Requeue: true}, err
+				}
+			}
+			newEGCP.Status.SetReadyCondition(item.Generation)
 
 			log.V(1).Info("update egressclusterpolicy status", "status", newEGCP.Status)
-			err = r.client.Status().Update(ctx, newEGCP)
+			err = applyStatus(ctx, r.client, r.migrator, newEGCP, "EgressClusterPolicy", newEGCP.Status)
 			if err != nil {
 				log.Error(err, "update egressclusterpolicy status", "status", newEGCP.Status)
 				return reconcile.Result{Requeue: true}, err
@@ -110,8 +130,9 @@ func NewEgressClusterPolicyController(mgr manager.Manager, log logr.Logger, cfg
 
 	log.Info("new egressclusterpolicy controller")
 
-	r := &egcpReconciler{client: mgr.GetClient(), log: log, config: cfg}
-	c, err := controller.New("egressclusterpolicy", mgr, controller.Options{Reconciler: r})
+	r := &egcpReconciler{client: mgr.GetClient(), log: log, config: cfg, migrator: newStatusMigrator(mgr),
+		recorder: mgr.GetEventRecorderFor("egressclusterpolicy")}
+	c, err := controller.New("egressclusterpolicy", mgr, queue.Options(cfg, "egressclusterpolicy", r, 1))
 	if err != nil {
 		return err
 	}
@@ -121,5 +142,10 @@ func NewEgressClusterPolicyController(mgr manager.Manager, log logr.Logger, cfg
 		return fmt.Errorf("failed to watch EgressGateway: %w", err)
 	}
 
+	if err := c.Watch(source.Kind(mgr.GetCache(), &v1beta1.EgressClusterPolicy{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressClusterPolicy"))); err != nil {
+		return fmt.Errorf("failed to watch EgressClusterPolicy: %w", err)
+	}
+
 	return nil
 }
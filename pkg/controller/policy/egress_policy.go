@@ -6,9 +6,13 @@ package policy
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -19,13 +23,17 @@ import (
 	"github.com/spidernet-io/egressgateway/pkg/config"
 	"github.com/spidernet-io/egressgateway/pkg/egressgateway"
 	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/queue"
 	"github.com/spidernet-io/egressgateway/pkg/utils"
+	"github.com/spidernet-io/egressgateway/pkg/utils/apply"
 )
 
 type egpReconciler struct {
-	client client.Client
-	log    logr.Logger
-	config *config.Config
+	client   client.Client
+	log      logr.Logger
+	config   *config.Config
+	migrator *apply.Migrator
+	recorder record.EventRecorder
 }
 
 func (r *egpReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
@@ -39,6 +47,13 @@ func (r *egpReconciler) Reconcile(ctx context.Context, req reconcile.Request) (r
 	switch kind {
 	case "EgressGateway":
 		return r.reconcileEGW(ctx, newReq, log)
+	case "EgressPolicy":
+		if err := r.reconcileSelection(ctx, newReq.NamespacedName, log); err != nil {
+			return reconcile.Result{Requeue: true}, err
+		}
+		policy := &v1beta1.EgressPolicy{ObjectMeta: metav1.ObjectMeta{Name: newReq.Name, Namespace: newReq.Namespace}}
+		timeout := time.Second * time.Duration(r.config.FileConfig.PolicyCleanupTimeout)
+		return reconcilePolicyCleanup(ctx, r.client, r.recorder, policy, &policy.Status, timeout, log)
 	default:
 		return reconcile.Result{}, nil
 	}
@@ -59,6 +74,11 @@ func (r *egpReconciler) reconcileEGW(ctx context.Context, req reconcile.Request,
 	}
 	deleted = deleted || !egw.GetDeletionTimestamp().IsZero()
 
+	// the changes of the gateway may change the selections of the policies
+	if err := r.reselectGateways(ctx, log); err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+
 	if deleted {
 		return reconcile.Result{Requeue: false}, nil
 	}
@@ -76,6 +96,8 @@ func (r *egpReconciler) reconcileEGW(ctx context.Context, req reconcile.Request,
 			newEGP.Status.Eip.Ipv4 = ""
 			newEGP.Status.Eip.Ipv6 = ""
 			newEGP.Status.Node = ""
+			newEGP.Status.NodeIP = v1beta1.Eip{}
+			newEGP.Status.ObservedGeneration = item.Generation
 
 			policy := v1beta1.Policy{Name: item.Name, Namespace: item.Namespace}
 			eipStatus, isExist := egressgateway.GetEIPStatusByPolicy(policy, *egw)
@@ -90,9 +112,16 @@ func (r *egpReconciler) reconcileEGW(ctx context.Context, req reconcile.Request,
 					}
 				}
 			}
+			if item.Spec.EgressIP.UseNodeIP && newEGP.Status.Node != "" {
+				newEGP.Status.NodeIP, err = gatewayNodeIP(ctx, r.client, newEGP.Status.Node)
+				if err != nil {
+					return reconcile.Result{Requeue: true}, err
+				}
+			}
+			newEGP.Status.SetReadyCondition(item.Generation)
 
 			log.V(1).Info("update EgressPolicy status", "status", newEGP.Status)
-			err = r.client.Status().Update(ctx, newEGP)
+			err = applyStatus(ctx, r.client, r.migrator, newEGP, "EgressPolicy", newEGP.Status)
 			if err != nil {
 				log.Error(err, "update EgressPolicy status", "status", newEGP.Status)
 				return reconcile.Result{Requeue: true}, err
@@ -103,19 +132,101 @@ func (r *egpReconciler) reconcileEGW(ctx context.Context, req reconcile.Request,
 	return reconcile.Result{Requeue: false}, nil
 }
 
+// reselectGateways selects the EgressGateways of all the policies with the selectors
+func (r *egpReconciler) reselectGateways(ctx context.Context, log logr.Logger) error {
+	egpList := &v1beta1.EgressPolicyList{}
+	if err := r.client.List(ctx, egpList); err != nil {
+		return err
+	}
+	for _, item := range egpList.Items {
+		if item.Spec.EgressGatewaySelector == nil {
+			continue
+		}
+		if err := r.reconcileSelection(ctx, types.NamespacedName{Namespace: item.Namespace, Name: item.Name}, log); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileSelection selects the EgressGateway of the policy by spec.egressGatewaySelector,
+// the policy is rebound to the selected gateway, and the selection is recorded in the status.
+// The gateway is kept when there is no candidate.
+func (r *egpReconciler) reconcileSelection(ctx context.Context, req types.NamespacedName, log logr.Logger) error {
+	policy := new(v1beta1.EgressPolicy)
+	if err := r.client.Get(ctx, req, policy); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	selector := policy.Spec.EgressGatewaySelector
+	if selector == nil || !policy.DeletionTimestamp.IsZero() {
+		return nil
+	}
+
+	egwList := &v1beta1.EgressGatewayList{}
+	if err := r.client.List(ctx, egwList); err != nil {
+		return err
+	}
+	name, candidates, err := egressgateway.SelectGateway(egwList.Items, selector, policy.Spec.EgressGatewayName)
+	if err != nil {
+		log.Error(err, "invalid egressGatewaySelector", "policy", req)
+		return nil
+	}
+	if name == "" {
+		log.Info("no Ready EgressGateway matches egressGatewaySelector, keep the gateway",
+			"policy", req, "gateway", policy.Spec.EgressGatewayName)
+		return nil
+	}
+
+	strategy := selector.Strategy
+	if strategy == "" {
+		strategy = v1beta1.GatewaySelectionFirstReady
+	}
+	selection := &v1beta1.GatewaySelection{Name: name, Strategy: strategy, Candidates: candidates, LastSelectionTime: metav1.Now()}
+	if last := policy.Status.SelectedGateway; last != nil && last.Name == name {
+		if last.Strategy == strategy && last.Candidates == candidates {
+			return nil
+		}
+		selection.LastSelectionTime = last.LastSelectionTime
+	}
+
+	if name != policy.Spec.EgressGatewayName {
+		log.Info("select EgressGateway", "policy", req, "from", policy.Spec.EgressGatewayName, "to", name, "strategy", strategy)
+		patch := client.MergeFrom(policy.DeepCopy())
+		policy.Spec.EgressGatewayName = name
+		if err := r.client.Patch(ctx, policy, patch); err != nil {
+			return err
+		}
+	}
+	policy.Status.SelectedGateway = selection
+	return applyStatus(ctx, r.client, r.migrator, policy, "EgressPolicy", policy.Status)
+}
+
+// gatewayNodeIP returns the IP which the agent of the gateway node SNATs the traffic
+// of the useNodeIP policies with, it's the IP of the parent interface of the tunnel.
+func gatewayNodeIP(ctx context.Context, cli client.Client, node string) (v1beta1.Eip, error) {
+	tunnel := new(v1beta1.EgressTunnel)
+	if err := cli.Get(ctx, types.NamespacedName{Name: node}, tunnel); err != nil {
+		return v1beta1.Eip{}, client.IgnoreNotFound(err)
+	}
+	parent := tunnel.Status.Tunnel.Parent
+	return v1beta1.Eip{Ipv4: parent.IPv4, Ipv6: parent.IPv6}, nil
+}
+
 func NewEgressPolicyController(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
 	if cfg == nil {
 		return fmt.Errorf("cfg can not be nil")
 	}
 
 	r := &egpReconciler{
-		client: mgr.GetClient(),
-		log:    log,
-		config: cfg,
+		client:   mgr.GetClient(),
+		log:      log,
+		config:   cfg,
+		migrator: newStatusMigrator(mgr),
+		recorder: mgr.GetEventRecorderFor("egresspolicy"),
 	}
 
 	log.Info("new egress policy controller")
-	c, err := controller.New("egresspolicy", mgr, controller.Options{Reconciler: r})
+	c, err := controller.New("egresspolicy", mgr, queue.Options(cfg, "egresspolicy", r, 1))
 	if err != nil {
 		return err
 	}
@@ -125,5 +236,10 @@ func NewEgressPolicyController(mgr manager.Manager, log logr.Logger, cfg *config
 		return fmt.Errorf("failed to watch EgressGateway: %w", err)
 	}
 
+	if err := c.Watch(source.Kind(mgr.GetCache(), &v1beta1.EgressPolicy{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressPolicy"))); err != nil {
+		return fmt.Errorf("failed to watch EgressPolicy: %w", err)
+	}
+
 	return nil
 }
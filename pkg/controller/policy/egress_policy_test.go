@@ -0,0 +1,121 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestReconcileEGWNodeIP(t *testing.T) {
+	ctx := context.Background()
+	egw := &v1beta1.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "egw1"},
+		Status: v1beta1.EgressGatewayStatus{NodeList: []v1beta1.EgressIPStatus{{
+			Name: "node1",
+			Eips: []v1beta1.Eips{{Policies: []v1beta1.Policy{
+				{Name: "p1", Namespace: "default"},
+				{Name: "p2", Namespace: "default"},
+			}}},
+		}}},
+	}
+	policy := func(name string, useNodeIP bool) *v1beta1.EgressPolicy {
+		return &v1beta1.EgressPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: egw.Name,
+				EgressIP:          v1beta1.EgressIP{UseNodeIP: useNodeIP},
+			},
+		}
+	}
+	p1, p2 := policy("p1", true), policy("p2", false)
+	tunnel := &v1beta1.EgressTunnel{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: v1beta1.EgressTunnelStatus{Tunnel: v1beta1.Tunnel{
+			Parent: v1beta1.Parent{Name: "eth0", IPv4: "172.18.0.2", IPv6: "fc00:f853:ccd:e793::2"},
+		}},
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(egw, p1, p2, tunnel).
+		WithStatusSubresource(p1, p2).
+		Build()
+
+	r := &egpReconciler{client: cli, log: logr.Discard()}
+	_, err := r.reconcileEGW(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: egw.Name}}, logr.Discard())
+	assert.NoError(t, err)
+
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(p1), p1))
+	assert.Equal(t, "node1", p1.Status.Node)
+	assert.Equal(t, v1beta1.Eip{Ipv4: "172.18.0.2", Ipv6: "fc00:f853:ccd:e793::2"}, p1.Status.NodeIP)
+
+	// the node IP is only reported for the useNodeIP policies
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(p2), p2))
+	assert.Equal(t, "node1", p2.Status.Node)
+	assert.Equal(t, v1beta1.Eip{}, p2.Status.NodeIP)
+}
+
+func TestReconcileSelection(t *testing.T) {
+	ctx := context.Background()
+	gateway := func(name, pool string) *v1beta1.EgressGateway {
+		return &v1beta1.EgressGateway{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"pool": pool}},
+			Status: v1beta1.EgressGatewayStatus{Conditions: []metav1.Condition{
+				{Type: v1beta1.GatewayConditionReady, Status: metav1.ConditionTrue},
+			}},
+		}
+	}
+	egw1, egw2 := gateway("egw1", "blue"), gateway("egw2", "blue")
+	policy := &v1beta1.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "p1", Namespace: "default"},
+		Spec: v1beta1.EgressPolicySpec{
+			EgressGatewayName: egw2.Name,
+			EgressGatewaySelector: &v1beta1.GatewaySelector{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "blue"}},
+			},
+		},
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(egw1, egw2, policy).
+		WithStatusSubresource(policy).
+		Build()
+	r := &egpReconciler{client: cli, log: logr.Discard()}
+
+	// the gateway selected by the webhook is kept
+	assert.NoError(t, r.reconcileSelection(ctx, client.ObjectKeyFromObject(policy), logr.Discard()))
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(policy), policy))
+	assert.Equal(t, "egw2", policy.Spec.EgressGatewayName)
+	assert.NotNil(t, policy.Status.SelectedGateway)
+	assert.Equal(t, "egw2", policy.Status.SelectedGateway.Name)
+	assert.Equal(t, v1beta1.GatewaySelectionFirstReady, policy.Status.SelectedGateway.Strategy)
+	assert.Equal(t, 2, policy.Status.SelectedGateway.Candidates)
+
+	// the policy is rebound once its gateway leaves the pool
+	egw2.Labels["pool"] = "green"
+	assert.NoError(t, cli.Update(ctx, egw2))
+	_, err := r.reconcileEGW(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: egw2.Name}}, logr.Discard())
+	assert.NoError(t, err)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(policy), policy))
+	assert.Equal(t, "egw1", policy.Spec.EgressGatewayName)
+	assert.Equal(t, "egw1", policy.Status.SelectedGateway.Name)
+	assert.Equal(t, 1, policy.Status.SelectedGateway.Candidates)
+
+	// the gateway is kept when there is no candidate
+	assert.NoError(t, cli.Delete(ctx, egw1))
+	assert.NoError(t, r.reconcileSelection(ctx, client.ObjectKeyFromObject(policy), logr.Discard()))
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(policy), policy))
+	assert.Equal(t, "egw1", policy.Spec.EgressGatewayName)
+}
@@ -0,0 +1,315 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// Server serves the read-only JSON summary of the gateways, their EIPs and the recent
+// failovers. The failovers are observed from the changes of the EgressGateways by every
+// replica of the controller, so they are lost once the controller restarts.
+type Server struct {
+	cfg config.StatusEndpoint
+	log logr.Logger
+
+	lock    sync.RWMutex
+	reader  client.Reader
+	cache   cache.Cache
+	history []Event
+	now     func() time.Time
+}
+
+// Summary is the response of the status endpoint
+type Summary struct {
+	Time            time.Time `json:"time"`
+	Gateways        []Gateway `json:"gateways"`
+	ActiveNodes     []string  `json:"activeNodes"`
+	FailoverHistory []Event   `json:"failoverHistory"`
+}
+
+// Gateway is the summary of an EgressGateway
+type Gateway struct {
+	Name       string      `json:"name"`
+	Ready      bool        `json:"ready"`
+	ReadyNodes int         `json:"readyNodes"`
+	Nodes      []Node      `json:"nodes"`
+	EIPs       []EIP       `json:"eips"`
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// Node is a node of the gateway, it's active if it's Ready and holds any EIP
+type Node struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	EIPs   int    `json:"eips"`
+	Active bool   `json:"active"`
+}
+
+// EIP is an EIP in use and its node
+type EIP struct {
+	IPv4     string   `json:"ipv4,omitempty"`
+	IPv6     string   `json:"ipv6,omitempty"`
+	Node     string   `json:"node"`
+	Policies []string `json:"policies"`
+}
+
+type Condition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	// EventEIPMoved is an EIP moved to another node
+	EventEIPMoved = "EIPMoved"
+	// EventNodeStatusChanged is the status of a gateway node changed
+	EventNodeStatusChanged = "NodeStatusChanged"
+)
+
+// Event is a failover event of a gateway
+type Event struct {
+	Time    time.Time `json:"time"`
+	Gateway string    `json:"gateway"`
+	Type    string    `json:"type"`
+	// IP is the EIP of EIPMoved, which is its IPv4 if any
+	IP string `json:"ip,omitempty"`
+	// Node is the node of NodeStatusChanged
+	Node string `json:"node,omitempty"`
+	// From and To are the nodes of EIPMoved, or the statuses of NodeStatusChanged
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// New returns the status server, its handler is registered before the manager is created,
+// and it serves nothing until Setup is called
+func New(cfg config.StatusEndpoint, log logr.Logger) *Server {
+	return &Server{cfg: cfg, log: log, now: time.Now}
+}
+
+// Setup reads the EgressGateways from the cache of the manager and observes the failovers
+func (s *Server) Setup(mgr manager.Manager) error {
+	s.lock.Lock()
+	s.reader = mgr.GetClient()
+	s.cache = mgr.GetCache()
+	s.lock.Unlock()
+	return mgr.Add(s)
+}
+
+// Start observes the failovers from the changes of the EgressGateways until the context
+// is done
+func (s *Server) Start(ctx context.Context) error {
+	informer, err := s.cache.GetInformer(ctx, &egressv1.EgressGateway{})
+	if err != nil {
+		return err
+	}
+	_, err = informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldGW, ok1 := oldObj.(*egressv1.EgressGateway)
+			newGW, ok2 := newObj.(*egressv1.EgressGateway)
+			if ok1 && ok2 {
+				s.record(diff(oldGW, newGW, s.now()))
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// NeedLeaderElection makes every replica of the controller serve the status
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// diff returns the events between the statuses of the gateway
+func diff(oldGW, newGW *egressv1.EgressGateway, now time.Time) []Event {
+	res := make([]Event, 0)
+	oldStatus := make(map[string]string)
+	oldEIPs := make(map[string]string)
+	for _, node := range oldGW.Status.NodeList {
+		oldStatus[node.Name] = node.Status
+		for _, eip := range node.Eips {
+			oldEIPs[eipKey(eip)] = node.Name
+		}
+	}
+
+	nodes := append([]egressv1.EgressIPStatus(nil), newGW.Status.NodeList...)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	for _, node := range nodes {
+		if from, ok := oldStatus[node.Name]; ok && from != node.Status {
+			res = append(res, Event{
+				Time: now, Gateway: newGW.Name, Type: EventNodeStatusChanged,
+				Node: node.Name, From: from, To: node.Status,
+			})
+		}
+	}
+	for _, node := range nodes {
+		for _, eip := range node.Eips {
+			from, ok := oldEIPs[eipKey(eip)]
+			if !ok || from == node.Name {
+				continue
+			}
+			res = append(res, Event{
+				Time: now, Gateway: newGW.Name, Type: EventEIPMoved,
+				IP: eipKey(eip), From: from, To: node.Name,
+			})
+		}
+	}
+	return res
+}
+
+func eipKey(eip egressv1.Eips) string {
+	if eip.IPv4 != "" {
+		return eip.IPv4
+	}
+	return eip.IPv6
+}
+
+// record appends the events and drops the events beyond the retention
+func (s *Server) record(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, item := range events {
+		s.log.Info("observe failover event", "gateway", item.Gateway, "type", item.Type,
+			"ip", item.IP, "node", item.Node, "from", item.From, "to", item.To)
+	}
+	s.history = append(s.history, events...)
+	s.history = s.retain(s.history)
+}
+
+// retain returns the events in the retention period, at most HistorySize of them
+func (s *Server) retain(list []Event) []Event {
+	if size := s.cfg.HistorySize; len(list) > size {
+		list = append([]Event(nil), list[len(list)-size:]...)
+	}
+	deadline := s.now().Add(-time.Duration(s.cfg.HistoryRetentionSecond) * time.Second)
+	i := sort.Search(len(list), func(i int) bool { return !list[i].Time.Before(deadline) })
+	return list[i:]
+}
+
+// Summary returns the summary of the gateways
+func (s *Server) Summary(ctx context.Context) (*Summary, error) {
+	s.lock.RLock()
+	reader := s.reader
+	history := append([]Event(nil), s.retain(s.history)...)
+	s.lock.RUnlock()
+
+	res := &Summary{
+		Time:            s.now(),
+		Gateways:        make([]Gateway, 0),
+		ActiveNodes:     make([]string, 0),
+		FailoverHistory: history,
+	}
+	gateways := new(egressv1.EgressGatewayList)
+	if err := reader.List(ctx, gateways); err != nil {
+		return nil, err
+	}
+	sort.Slice(gateways.Items, func(i, j int) bool { return gateways.Items[i].Name < gateways.Items[j].Name })
+
+	active := make(map[string]struct{})
+	for _, item := range gateways.Items {
+		gw := summarize(&item)
+		for _, node := range gw.Nodes {
+			if node.Active {
+				active[node.Name] = struct{}{}
+			}
+		}
+		res.Gateways = append(res.Gateways, gw)
+	}
+	for name := range active {
+		res.ActiveNodes = append(res.ActiveNodes, name)
+	}
+	sort.Strings(res.ActiveNodes)
+	return res, nil
+}
+
+func summarize(egw *egressv1.EgressGateway) Gateway {
+	gw := Gateway{
+		Name:       egw.Name,
+		ReadyNodes: egw.Status.ReadyNodes,
+		Nodes:      make([]Node, 0, len(egw.Status.NodeList)),
+		EIPs:       make([]EIP, 0),
+	}
+	for _, cond := range egw.Status.Conditions {
+		gw.Conditions = append(gw.Conditions, Condition{
+			Type: cond.Type, Status: string(cond.Status), Reason: cond.Reason, Message: cond.Message,
+		})
+		if cond.Type == egressv1.GatewayConditionReady {
+			gw.Ready = cond.Status == "True"
+		}
+	}
+	for _, node := range egw.Status.NodeList {
+		gw.Nodes = append(gw.Nodes, Node{
+			Name:   node.Name,
+			Status: node.Status,
+			EIPs:   len(node.Eips),
+			Active: node.Status == string(egressv1.EgressTunnelReady) && len(node.Eips) != 0,
+		})
+		for _, eip := range node.Eips {
+			item := EIP{IPv4: eip.IPv4, IPv6: eip.IPv6, Node: node.Name, Policies: make([]string, 0, len(eip.Policies))}
+			for _, policy := range eip.Policies {
+				name := policy.Name
+				if policy.Namespace != "" {
+					name = policy.Namespace + "/" + policy.Name
+				}
+				item.Policies = append(item.Policies, name)
+			}
+			sort.Strings(item.Policies)
+			gw.EIPs = append(gw.EIPs, item)
+		}
+	}
+	sort.Slice(gw.Nodes, func(i, j int) bool { return gw.Nodes[i].Name < gw.Nodes[j].Name })
+	sort.Slice(gw.EIPs, func(i, j int) bool {
+		if gw.EIPs[i].IPv4 != gw.EIPs[j].IPv4 {
+			return gw.EIPs[i].IPv4 < gw.EIPs[j].IPv4
+		}
+		return gw.EIPs[i].IPv6 < gw.EIPs[j].IPv6
+	})
+	return gw
+}
+
+// Handler serves the summary by GET
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.lock.RLock()
+		ready := s.reader != nil
+		s.lock.RUnlock()
+		if !ready {
+			http.Error(w, "the controller is not ready", http.StatusServiceUnavailable)
+			return
+		}
+		res, err := s.Summary(req.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	})
+}
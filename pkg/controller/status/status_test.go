@@ -0,0 +1,109 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package status
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func gateway(nodes ...egressv1.EgressIPStatus) *egressv1.EgressGateway {
+	return &egressv1.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Status: egressv1.EgressGatewayStatus{
+			NodeList:   nodes,
+			ReadyNodes: 1,
+			Conditions: []metav1.Condition{{Type: egressv1.GatewayConditionReady, Status: metav1.ConditionTrue, Reason: "NodesReady"}},
+		},
+	}
+}
+
+func TestDiff(t *testing.T) {
+	now := time.Now()
+	eip := egressv1.Eips{IPv4: "10.6.1.21", Policies: []egressv1.Policy{{Namespace: "default", Name: "test"}}}
+	oldGW := gateway(
+		egressv1.EgressIPStatus{Name: "node1", Status: "Ready", Eips: []egressv1.Eips{eip}},
+		egressv1.EgressIPStatus{Name: "node2", Status: "Ready"},
+	)
+	newGW := gateway(
+		egressv1.EgressIPStatus{Name: "node1", Status: "HeartbeatTimeout"},
+		egressv1.EgressIPStatus{Name: "node2", Status: "Ready", Eips: []egressv1.Eips{eip}},
+	)
+	assert.Equal(t, []Event{
+		{Time: now, Gateway: "default", Type: EventNodeStatusChanged, Node: "node1", From: "Ready", To: "HeartbeatTimeout"},
+		{Time: now, Gateway: "default", Type: EventEIPMoved, IP: "10.6.1.21", From: "node1", To: "node2"},
+	}, diff(oldGW, newGW, now))
+	assert.Empty(t, diff(newGW, newGW, now))
+}
+
+func TestRetain(t *testing.T) {
+	now := time.Now()
+	s := New(config.StatusEndpoint{HistorySize: 2, HistoryRetentionSecond: 60}, logr.Discard())
+	s.now = func() time.Time { return now }
+
+	s.record([]Event{
+		{Time: now.Add(-2 * time.Minute), Type: EventEIPMoved, IP: "10.6.1.21"},
+		{Time: now.Add(-time.Second), Type: EventEIPMoved, IP: "10.6.1.22"},
+	})
+	assert.Len(t, s.history, 1)
+	assert.Equal(t, "10.6.1.22", s.history[0].IP)
+
+	s.record([]Event{
+		{Time: now, Type: EventEIPMoved, IP: "10.6.1.23"},
+		{Time: now, Type: EventEIPMoved, IP: "10.6.1.24"},
+	})
+	assert.Len(t, s.history, 2)
+	assert.Equal(t, "10.6.1.23", s.history[0].IP)
+
+	now = now.Add(2 * time.Minute)
+	assert.Empty(t, s.retain(s.history))
+}
+
+func TestHandler(t *testing.T) {
+	s := New(config.StatusEndpoint{HistorySize: 10, HistoryRetentionSecond: 60}, logr.Discard())
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	gw := gateway(
+		egressv1.EgressIPStatus{Name: "node2", Status: "Ready", Eips: []egressv1.Eips{
+			{IPv4: "10.6.1.22", Policies: []egressv1.Policy{{Name: "cluster"}, {Namespace: "default", Name: "test"}}},
+		}},
+		egressv1.EgressIPStatus{Name: "node1", Status: "HeartbeatTimeout"},
+	)
+	s.reader = fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(gw).Build()
+	s.record([]Event{{Time: time.Now(), Gateway: "default", Type: EventEIPMoved, IP: "10.6.1.22", From: "node1", To: "node2"}})
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	res := new(Summary)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), res))
+	assert.Equal(t, []string{"node2"}, res.ActiveNodes)
+	assert.Len(t, res.FailoverHistory, 1)
+	assert.Len(t, res.Gateways, 1)
+	assert.True(t, res.Gateways[0].Ready)
+	assert.Equal(t, []Node{
+		{Name: "node1", Status: "HeartbeatTimeout"},
+		{Name: "node2", Status: "Ready", EIPs: 1, Active: true},
+	}, res.Gateways[0].Nodes)
+	assert.Equal(t, []EIP{{IPv4: "10.6.1.22", Node: "node2", Policies: []string{"cluster", "default/test"}}}, res.Gateways[0].EIPs)
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/status", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
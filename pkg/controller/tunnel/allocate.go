@@ -0,0 +1,132 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+
+	"github.com/cilium/ipam/service/ipallocator"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
+)
+
+// allocateByHash allocates the tunnel IP derived from the hash of the node name, the
+// following addresses are probed when it is used by other nodes. So a node gets the
+// same tunnel IP across restarts as long as there is no collision.
+func allocateByHash(allocator *ipallocator.Range, name string) (net.IP, error) {
+	cidr := allocator.CIDR()
+	// the network address and the broadcast address are not used
+	size := int(ipallocator.RangeSize(&cidr)) - 2
+	if size <= 0 {
+		return nil, ipallocator.ErrFull
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	start := int(h.Sum64() % uint64(size))
+
+	for i := 0; i < size; i++ {
+		ip, err := ipallocator.GetIndexedIP(&cidr, (start+i)%size+1)
+		if err != nil {
+			return nil, err
+		}
+		if ipv4 := ip.To4(); ipv4 != nil {
+			ip = ipv4
+		}
+		err = allocator.Allocate(ip)
+		if err == nil {
+			return ip, nil
+		}
+		if !errors.Is(err, ipallocator.ErrAllocated) {
+			return nil, err
+		}
+	}
+	return nil, ipallocator.ErrFull
+}
+
+// conflictTunnelIPs returns whether the tunnel IPs of the node are also claimed by an
+// EgressTunnel created earlier, the earliest one keeps the IP and the others give it up.
+func conflictTunnelIPs(node *egressv1.EgressTunnel, tunnels []egressv1.EgressTunnel) (ipv4, ipv6 bool) {
+	earlier := func(other *egressv1.EgressTunnel) bool {
+		t1, t2 := other.CreationTimestamp, node.CreationTimestamp
+		if t1.Equal(&t2) {
+			return other.Name < node.Name
+		}
+		return t1.Before(&t2)
+	}
+	for i := range tunnels {
+		other := &tunnels[i]
+		if other.Name == node.Name || !earlier(other) {
+			continue
+		}
+		if node.Status.Tunnel.IPv4 != "" && node.Status.Tunnel.IPv4 == other.Status.Tunnel.IPv4 {
+			ipv4 = true
+		}
+		if node.Status.Tunnel.IPv6 != "" && node.Status.Tunnel.IPv6 == other.Status.Tunnel.IPv6 {
+			ipv6 = true
+		}
+	}
+	return ipv4, ipv6
+}
+
+// resolveTunnelIPConflict clears the tunnel IPs of the node which are claimed by other
+// EgressTunnels, so new ones are allocated to it. The IPs are not released, because
+// they are still used by the earlier EgressTunnel.
+func (r *egReconciler) resolveTunnelIPConflict(node *egressv1.EgressTunnel, log logr.Logger) error {
+	if node.Status.Tunnel.IPv4 == "" && node.Status.Tunnel.IPv6 == "" {
+		return nil
+	}
+	tunnels := new(egressv1.EgressTunnelList)
+	if err := r.client.List(context.Background(), tunnels); err != nil {
+		return fmt.Errorf("failed to list egress tunnel: %v", err)
+	}
+
+	ipv4, ipv6 := conflictTunnelIPs(node, tunnels.Items)
+	if ipv4 {
+		log.Info("tunnel ipv4 conflicts with other egress tunnel, reallocate it", "ipv4", node.Status.Tunnel.IPv4)
+		r.recordConflict(node, node.Status.Tunnel.IPv4)
+		countNumTunnelIPConflicts.WithLabelValues("ipv4").Inc()
+		node.Status.Tunnel.IPv4 = ""
+	}
+	if ipv6 {
+		log.Info("tunnel ipv6 conflicts with other egress tunnel, reallocate it", "ipv6", node.Status.Tunnel.IPv6)
+		r.recordConflict(node, node.Status.Tunnel.IPv6)
+		countNumTunnelIPConflicts.WithLabelValues("ipv6").Inc()
+		node.Status.Tunnel.IPv6 = ""
+	}
+	return nil
+}
+
+func (r *egReconciler) recordConflict(node *egressv1.EgressTunnel, ip string) {
+	if r.recorder == nil {
+		return
+	}
+	r.recorder.Event(node, corev1.EventTypeWarning, egressv1.ReasonTunnelIPConflict,
+		fmt.Sprintf("Tunnel IP %s is used by other EgressTunnel, a new one is allocated.", ip))
+}
+
+// allocateNextMark allocates the next mark for the node, the exhausted mark space is
+// reported by the metric and the event of the node
+func (r *egReconciler) allocateNextMark(node *egressv1.EgressTunnel) (string, error) {
+	mark, err := r.mark.AllocateNext()
+	if errors.Is(err, markallocator.ErrFull) {
+		countNumMarkExhausted.Inc()
+		if r.recorder != nil {
+			r.recorder.Event(node, corev1.EventTypeWarning, egressv1.ReasonMarkExhausted,
+				fmt.Sprintf("All the %d marks are allocated, widen the markMask to allocate more.", r.mark.Size()))
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("can't allocate next mark: %v", err)
+	}
+	countNumMarkAllocateNextCalls.Inc()
+	return mark, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package tunnel
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cilium/ipam/service/ipallocator"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func newRange(t *testing.T, subnet string) *ipallocator.Range {
+	_, cidr, err := net.ParseCIDR(subnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := ipallocator.NewCIDRRange(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestAllocateByHash(t *testing.T) {
+	for _, subnet := range []string{"172.31.0.0/16", "fd11::/112"} {
+		t.Run(subnet, func(t *testing.T) {
+			// the same node gets the same IP across restarts
+			ip1, err := allocateByHash(newRange(t, subnet), "node1")
+			assert.NoError(t, err)
+			ip2, err := allocateByHash(newRange(t, subnet), "node1")
+			assert.NoError(t, err)
+			assert.Equal(t, ip1.String(), ip2.String())
+
+			// the next address is probed on collision
+			r := newRange(t, subnet)
+			assert.NoError(t, r.Allocate(ip1))
+			ip3, err := allocateByHash(r, "node1")
+			assert.NoError(t, err)
+			assert.NotEqual(t, ip1.String(), ip3.String())
+			assert.True(t, r.Has(ip3))
+		})
+	}
+}
+
+func TestAllocateByHashFull(t *testing.T) {
+	r := newRange(t, "10.6.0.0/30")
+	ip, err := allocateByHash(r, "node1")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(ip))
+	_, err = allocateByHash(r, "node2")
+	assert.NoError(t, err)
+	_, err = allocateByHash(r, "node3")
+	assert.ErrorIs(t, err, ipallocator.ErrFull)
+}
+
+func TestConflictTunnelIPs(t *testing.T) {
+	now := metav1.Now()
+	later := metav1.NewTime(now.Add(time.Minute))
+	newTunnel := func(name string, created metav1.Time, ipv4, ipv6 string) egressv1.EgressTunnel {
+		return egressv1.EgressTunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: created},
+			Status: egressv1.EgressTunnelStatus{
+				Tunnel: egressv1.Tunnel{IPv4: ipv4, IPv6: ipv6},
+			},
+		}
+	}
+	tunnels := []egressv1.EgressTunnel{
+		newTunnel("node1", now, "172.31.0.1", "fd11::1"),
+		newTunnel("node2", later, "172.31.0.1", "fd11::2"),
+		newTunnel("node3", now, "172.31.0.3", "fd11::1"),
+	}
+
+	tests := []struct {
+		name       string
+		node       egressv1.EgressTunnel
+		expectIPv4 bool
+		expectIPv6 bool
+	}{
+		{name: "earliest keeps the ip", node: tunnels[0]},
+		{name: "later created gives up ipv4", node: tunnels[1], expectIPv4: true},
+		{name: "same creation time compares name", node: tunnels[2], expectIPv6: true},
+		{name: "no conflict", node: newTunnel("node4", later, "172.31.0.4", "fd11::4")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipv4, ipv6 := conflictTunnelIPs(&tt.node, tunnels)
+			assert.Equal(t, tt.expectIPv4, ipv4)
+			assert.Equal(t, tt.expectIPv6, ipv6)
+		})
+	}
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/spidernet-io/egressgateway/pkg/config"
 	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
 	"github.com/spidernet-io/egressgateway/pkg/markallocator"
+	"github.com/spidernet-io/egressgateway/pkg/queue"
 	"github.com/spidernet-io/egressgateway/pkg/utils"
 )
 
@@ -58,6 +59,21 @@ var (
 		Name: "egress_mark_release_calls",
 		Help: "Total number of mark release calls",
 	})
+
+	countNumMarkExhausted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "egress_mark_exhausted",
+		Help: "Total number of mark allocations failed for no mark left",
+	})
+
+	gaugeMarkFree = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "egress_mark_free",
+		Help: "Number of the marks left to allocate",
+	})
+
+	countNumTunnelIPConflicts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "egress_tunnel_ip_conflicts",
+		Help: "Total number of tunnel ip conflicts detected between EgressTunnels",
+	}, []string{"version"})
 )
 
 var (
@@ -69,6 +85,9 @@ var EgressTunnelControllerMetricCollectors = []prometheus.Collector{
 	countNumIPReleaseCalls,
 	countNumMarkAllocateNextCalls,
 	countNumMarkReleaseCalls,
+	countNumMarkExhausted,
+	gaugeMarkFree,
+	countNumTunnelIPConflicts,
 }
 
 type egReconciler struct {
@@ -81,6 +100,8 @@ type egReconciler struct {
 	allocatorV6 *ipallocator.Range
 	initDone    chan struct{}
 	recorder    record.EventRecorder
+	// networks is the dedicated tunnel networks of EgressGateways, key is the gateway name
+	networks map[string]*network
 }
 
 func (r *egReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
@@ -103,6 +124,9 @@ func (r *egReconciler) Reconcile(ctx context.Context, req reconcile.Request) (re
 
 	log := r.log.WithValues("name", newReq.Name, "kind", kind)
 	log.V(1).Info("reconciling")
+	defer func() {
+		gaugeMarkFree.Set(float64(r.mark.Free()))
+	}()
 	switch kind {
 	case "EgressTunnel":
 		return r.reconcileEGN(ctx, newReq, log)
@@ -128,6 +152,11 @@ func (r *egReconciler) reconcileEGN(ctx context.Context, req reconcile.Request,
 	}
 	deleted = deleted || !egresstunnel.GetDeletionTimestamp().IsZero()
 
+	// the imported EgressTunnels are managed by the multi-cluster broker
+	if !deleted && egressv1.IsImported(egresstunnel.Labels) {
+		return reconcile.Result{}, nil
+	}
+
 	if deleted {
 		if len(egresstunnel.Finalizers) > 0 {
 			// For the existence of Node, when the user manually deletes EgressTunnel,
@@ -153,6 +182,13 @@ func (r *egReconciler) reconcileEGN(ctx context.Context, req reconcile.Request,
 		return reconcile.Result{Requeue: true}, err
 	}
 
+	// requeue to remove the previous tunnel address when it expires
+	if previous := egresstunnel.Status.Tunnel.Previous; previous != nil {
+		if wait := time.Until(previous.ExpireTime.Time); wait > 0 {
+			return reconcile.Result{RequeueAfter: wait}, nil
+		}
+	}
+
 	return reconcile.Result{Requeue: false}, nil
 }
 
@@ -221,8 +257,10 @@ func (r *egReconciler) reconcileNode(ctx context.Context, req reconcile.Request,
 				}
 			}
 		}
-		// don't overwrite the EgressTunnelHeartbeatTimeout status
-		if egressTunnel.Status.Phase != egressv1.EgressTunnelHeartbeatTimeout {
+		// don't overwrite the EgressTunnelHeartbeatTimeout status, and the EgressTunnelFailed
+		// status set by the agent in the strict datapath mode
+		if egressTunnel.Status.Phase != egressv1.EgressTunnelHeartbeatTimeout &&
+			egressTunnel.Status.Phase != egressv1.EgressTunnelFailed {
 			if egressTunnel.Status.Phase != phase {
 				log.Info("update egress tunnel", "status", egressTunnel.Status)
 				egressTunnel.Status.Phase = phase
@@ -310,6 +348,10 @@ func (r *egReconciler) releaseEgressTunnel(node egressv1.EgressTunnel, log logr.
 		})
 	}
 
+	for _, item := range node.Status.Networks {
+		r.releaseNetwork(item, r.getNetwork(item), log)
+	}
+
 	return commit()
 }
 
@@ -347,7 +389,8 @@ func (r *egReconciler) reBuildCache(node egressv1.EgressTunnel, log logr.Logger)
 		log.V(1).Info("rebuild mark cache", "mark", newNode.Status.Mark)
 		err := r.mark.Allocate(newNode.Status.Mark)
 		if err != nil {
-			newNode.Status.Tunnel.MAC = ""
+			// the mark is reallocated if it's out of the mark space, e.g. the mask is changed
+			newNode.Status.Mark = ""
 			needUpdate = true
 			log.V(1).Error(err, "can't reused mark")
 		} else {
@@ -367,6 +410,9 @@ func (r *egReconciler) reBuildCache(node egressv1.EgressTunnel, log logr.Logger)
 			err := r.allocatorV4.Allocate(ipv4)
 			if err != nil {
 				log.Error(err, "can't reused ipv4", "ipv4", ipv4)
+				if isNotInRange(err) {
+					r.setPreviousTunnel(newNode, newNode.Status.Tunnel.IPv4, "")
+				}
 				newNode.Status.Tunnel.IPv4 = ""
 				needUpdate = true
 			} else {
@@ -393,6 +439,11 @@ func (r *egReconciler) reBuildCache(node egressv1.EgressTunnel, log logr.Logger)
 					log.Error(err, "can't reused ipv6", "ipv6", ipv6)
 					newNode.Status.Tunnel.IPv6 = ""
 					needUpdate = true
+				} else if isNotInRange(err) {
+					log.Error(err, "can't reused ipv6", "ipv6", ipv6)
+					r.setPreviousTunnel(newNode, "", newNode.Status.Tunnel.IPv6)
+					newNode.Status.Tunnel.IPv6 = ""
+					needUpdate = true
 				} else {
 					log.Info("rebuild ipv6 cache succeeded")
 					rollback = append(rollback, func() {
@@ -408,6 +459,12 @@ func (r *egReconciler) reBuildCache(node egressv1.EgressTunnel, log logr.Logger)
 		newNode.Status.Tunnel.IPv6 = ""
 	}
 
+	networkChanged, err := r.reBuildNetworkCache(newNode, log)
+	if err != nil {
+		return fmt.Errorf("failed to rebuild network cache: %v", err)
+	}
+	needUpdate = needUpdate || networkChanged
+
 	if needUpdate {
 		log.V(1).Info("try to update egress tunnel")
 		err := r.updateEgressTunnel(*newNode)
@@ -445,11 +502,10 @@ func (r *egReconciler) keepEgressTunnel(node egressv1.EgressTunnel, log logr.Log
 
 	if newNode.Status.Mark == "" {
 		log.V(1).Info("try to allocate next mark")
-		newNode.Status.Mark, err = r.mark.AllocateNext()
+		newNode.Status.Mark, err = r.allocateNextMark(newNode)
 		if err != nil {
-			return fmt.Errorf("can't allocate next mark: %v", err)
+			return err
 		}
-		countNumMarkAllocateNextCalls.Inc()
 		needUpdate = true
 		rollback = append(rollback, func() {
 			if err := r.mark.Release(newNode.Status.Mark); err != nil {
@@ -459,11 +515,21 @@ func (r *egReconciler) keepEgressTunnel(node egressv1.EgressTunnel, log logr.Log
 		log.V(1).Info("allocate next ipv4 address succeeded", "ipv4", newNode.Status.Tunnel.IPv4)
 	}
 
+	if previous := newNode.Status.Tunnel.Previous; previous != nil && !time.Now().Before(previous.ExpireTime.Time) {
+		log.Info("remove the expired previous tunnel address", "ipv4", previous.IPv4, "ipv6", previous.IPv6)
+		newNode.Status.Tunnel.Previous = nil
+		needUpdate = true
+	}
+
+	if err := r.resolveTunnelIPConflict(newNode, log); err != nil {
+		return err
+	}
+
 	if newNode.Status.Tunnel.IPv4 == "" && r.allocatorV4 != nil {
-		log.V(1).Info("try to allocate next ipv4")
-		ip, err := r.allocatorV4.AllocateNext()
+		log.V(1).Info("try to allocate ipv4")
+		ip, err := allocateByHash(r.allocatorV4, newNode.Name)
 		if err != nil {
-			return fmt.Errorf("can't allocate next ipv4: %v", err)
+			return fmt.Errorf("can't allocate ipv4: %v", err)
 		}
 		countNumIPAllocateNextCallsIpv4.Inc()
 		newNode.Status.Tunnel.IPv4 = ip.String()
@@ -476,10 +542,10 @@ func (r *egReconciler) keepEgressTunnel(node egressv1.EgressTunnel, log logr.Log
 	}
 
 	if newNode.Status.Tunnel.IPv6 == "" && r.allocatorV6 != nil {
-		log.V(1).Info("try to allocate next ipv6")
-		ip, err := r.allocatorV6.AllocateNext()
+		log.V(1).Info("try to allocate ipv6")
+		ip, err := allocateByHash(r.allocatorV6, newNode.Name)
 		if err != nil {
-			log.Error(err, "can't allocate next ipv6")
+			return fmt.Errorf("can't allocate ipv6: %v", err)
 		}
 		countNumIPAllocateNextCallsIpv6.Inc()
 		newNode.Status.Tunnel.IPv6 = ip.String()
@@ -489,8 +555,15 @@ func (r *egReconciler) keepEgressTunnel(node egressv1.EgressTunnel, log logr.Log
 				log.Error(err, "rollback can't release ipv6", "ip", ip)
 			}
 		})
-		log.V(1).Info("allocate next ipv6 address succeeded", "ipv6", ip)
+		log.V(1).Info("allocate ipv6 address succeeded", "ipv6", ip)
+	}
+
+	networkChanged, networkRollback, err := r.keepNetworks(newNode, log)
+	rollback = append(rollback, networkRollback...)
+	if err != nil {
+		return fmt.Errorf("failed to keep tunnel networks: %v", err)
 	}
+	needUpdate = needUpdate || networkChanged
 
 	if needUpdate {
 		err := r.updateEgressTunnel(*newNode)
@@ -522,6 +595,7 @@ func (r *egReconciler) updateEgressTunnel(node egressv1.EgressTunnel) error {
 		node.Status.Phase = egressv1.EgressTunnelPending
 	}
 
+	node.Status.SetReadyCondition(node.Generation)
 	err := r.client.Status().Update(context.Background(), &node)
 	if err != nil {
 		return fmt.Errorf("rebuild failed to update egress tunnel: %v", err)
@@ -529,6 +603,32 @@ func (r *egReconciler) updateEgressTunnel(node egressv1.EgressTunnel) error {
 	return nil
 }
 
+// setPreviousTunnel keeps the tunnel address which is not in the tunnel subnet
+// after renumbering, the agent keeps it on the node until the grace period expires.
+func (r *egReconciler) setPreviousTunnel(node *egressv1.EgressTunnel, ipv4, ipv6 string) {
+	grace := r.config.FileConfig.TunnelRenumberGracePeriod
+	if grace <= 0 {
+		return
+	}
+	previous := node.Status.Tunnel.Previous
+	if previous == nil {
+		previous = new(egressv1.PreviousTunnel)
+		node.Status.Tunnel.Previous = previous
+	}
+	if ipv4 != "" {
+		previous.IPv4 = ipv4
+	}
+	if ipv6 != "" {
+		previous.IPv6 = ipv6
+	}
+	previous.ExpireTime = metav1.NewTime(time.Now().Add(time.Duration(grace) * time.Second))
+}
+
+func isNotInRange(err error) bool {
+	var notInRange *ipallocator.ErrNotInRange
+	return errors.As(err, &notInRange)
+}
+
 func generateMACAddress(nodeName string) (string, error) {
 	h := sha1.New()
 	_, err := h.Write([]byte(nodeName + "egress"))
@@ -550,6 +650,9 @@ func (r *egReconciler) initEgressTunnel() error {
 	start := time.Now()
 
 	for _, node := range nodes.Items {
+		if egressv1.IsImported(node.Labels) {
+			continue
+		}
 		log := r.log.WithValues("name", node.Name, "kind", "EgressTunnel")
 
 		i := 0
@@ -609,7 +712,15 @@ func (r *egReconciler) tunnelListCheck(ctx context.Context) error {
 		return err
 	}
 
+	unreachableMap := make(map[string]bool)
+	if r.config.FileConfig.GatewayFailover.TunnelProbe.Enable {
+		unreachableMap = peerUnreachableMap(tunnels.Items, timeout)
+	}
+
 	for _, item := range tunnels.Items {
+		if egressv1.IsImported(item.Labels) {
+			continue
+		}
 		tunnel := new(egressv1.EgressTunnel)
 		key := types.NamespacedName{Name: item.Name}
 		err := r.client.Get(ctx, key, tunnel)
@@ -623,6 +734,7 @@ func (r *egReconciler) tunnelListCheck(ctx context.Context) error {
 				continue
 			}
 			tunnel.Status.Phase = egressv1.EgressTunnelHeartbeatTimeout
+			tunnel.Status.SetReadyCondition(tunnel.Generation)
 			r.log.Info("update tunnel status to HeartbeatTimeout", "tunnel", tunnel.Name)
 			err := r.client.Status().Update(ctx, tunnel)
 			if err != nil {
@@ -635,11 +747,86 @@ func (r *egReconciler) tunnelListCheck(ctx context.Context) error {
 				egressv1.ReasonStatusChanged,
 				"EgressTunnel status changes to HeartbeatTimeout.",
 			)
+			continue
+		}
+
+		upstreamDown := r.config.FileConfig.GatewayFailover.UpstreamProbe.Enable &&
+			isUpstreamDown(tunnel.Status.Upstreams, timeout)
+		unreachable, ok := unreachableMap[tunnel.Name]
+		var phase egressv1.EgressTunnelPhase
+		if ok && unreachable && tunnel.Status.Phase == egressv1.EgressTunnelReady {
+			phase = egressv1.EgressTunnelUnreachable
+		} else if ok && !unreachable && tunnel.Status.Phase == egressv1.EgressTunnelUnreachable {
+			phase = egressv1.EgressTunnelReady
+		} else if upstreamDown && tunnel.Status.Phase == egressv1.EgressTunnelReady {
+			phase = egressv1.EgressTunnelUpstreamDown
+		} else if !upstreamDown && tunnel.Status.Phase == egressv1.EgressTunnelUpstreamDown {
+			phase = egressv1.EgressTunnelReady
+		} else {
+			continue
+		}
+		tunnel.Status.Phase = phase
+		tunnel.Status.SetReadyCondition(tunnel.Generation)
+		r.log.Info("update tunnel status by probe results", "tunnel", tunnel.Name, "phase", phase)
+		err = r.client.Status().Update(ctx, tunnel)
+		if err != nil {
+			r.log.Error(err, "update tunnel status by probe results")
+			continue
 		}
+
+		r.recorder.Event(
+			tunnel, corev1.EventTypeNormal,
+			egressv1.ReasonStatusChanged,
+			fmt.Sprintf("EgressTunnel status changes to %s by probe results.", phase),
+		)
 	}
 	return nil
 }
 
+// peerUnreachableMap aggregates the probe results reported by the peers of each tunnel,
+// a tunnel is unreachable when all the fresh reports of its peers failed to reach it.
+// Tunnels without any fresh report are not included in the result.
+func peerUnreachableMap(tunnels []egressv1.EgressTunnel, timeout time.Duration) map[string]bool {
+	res := make(map[string]bool)
+	now := time.Now()
+	for _, reporter := range tunnels {
+		// the tunnel of a node whose upstream is down still works
+		if (reporter.Status.Phase != egressv1.EgressTunnelReady &&
+			reporter.Status.Phase != egressv1.EgressTunnelUpstreamDown) ||
+			now.After(reporter.Status.LastHeartbeatTime.Add(timeout)) {
+			continue
+		}
+		for _, peer := range reporter.Status.Peers {
+			if peer.Name == reporter.Name || now.After(peer.LastProbeTime.Add(timeout)) {
+				continue
+			}
+			unreachable, ok := res[peer.Name]
+			if !ok {
+				unreachable = true
+			}
+			res[peer.Name] = unreachable && !peer.Reachable
+		}
+	}
+	return res
+}
+
+// isUpstreamDown returns true when the node reports fresh probe results of its upstream
+// next hops, and none of them is reachable.
+func isUpstreamDown(upstreams []egressv1.UpstreamStatus, timeout time.Duration) bool {
+	now := time.Now()
+	fresh := 0
+	for _, upstream := range upstreams {
+		if now.After(upstream.LastProbeTime.Add(timeout)) {
+			continue
+		}
+		if upstream.Reachable {
+			return false
+		}
+		fresh++
+	}
+	return fresh > 0
+}
+
 func (r *egReconciler) Start(ctx context.Context) error {
 	if r.config.FileConfig.GatewayFailover.Enable {
 		go func() {
@@ -659,10 +846,11 @@ func NewEgressTunnelController(mgr manager.Manager, log logr.Logger, cfg *config
 		return fmt.Errorf("cfg can not be nil")
 	}
 
-	mark, err := markallocator.NewAllocatorMarkRange(cfg.FileConfig.Mark)
+	space, err := markallocator.NewSpace(cfg.FileConfig.Mark, cfg.FileConfig.MarkMask)
 	if err != nil {
-		return fmt.Errorf("markallocator.NewAllocatorCID with error: %v", err)
+		return fmt.Errorf("markallocator.NewSpace with error: %v", err)
 	}
+	mark := markallocator.NewAllocatorMarkSpace(space)
 
 	r := &egReconciler{
 		client:   mgr.GetClient(),
@@ -671,6 +859,7 @@ func NewEgressTunnelController(mgr manager.Manager, log logr.Logger, cfg *config
 		doOnce:   sync.Once{},
 		mark:     mark,
 		initDone: make(chan struct{}, 1),
+		networks: make(map[string]*network),
 	}
 
 	if cfg.FileConfig.EnableIPv4 {
@@ -695,7 +884,7 @@ func NewEgressTunnelController(mgr manager.Manager, log logr.Logger, cfg *config
 	}
 
 	log.Info("new egresstunnel controller")
-	c, err := controller.New("egresstunnel", mgr, controller.Options{Reconciler: r})
+	c, err := controller.New("egresstunnel", mgr, queue.Options(cfg, "egresstunnel", r, 1))
 	if err != nil {
 		return err
 	}
@@ -716,6 +905,13 @@ func NewEgressTunnelController(mgr manager.Manager, log logr.Logger, cfg *config
 		return fmt.Errorf("failed to watch Node: %w", err)
 	}
 
+	log.Info("egresstunnel controller watch EgressGateway")
+	if err := c.Watch(source.Kind(mgr.GetCache(), &egressv1.EgressGateway{}),
+		handler.EnqueueRequestsFromMapFunc(enqueueAllEgressTunnel(r.client)),
+		gatewayTunnelPredicate{}); err != nil {
+		return fmt.Errorf("failed to watch EgressGateway: %w", err)
+	}
+
 	r.recorder = mgr.GetEventRecorderFor("egress-tunnel")
 
 	return nil
@@ -6,6 +6,7 @@ package tunnel
 import (
 	"context"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
@@ -290,6 +291,69 @@ func TestHealthCheck(t *testing.T) {
 	time.Sleep(time.Second * 6)
 }
 
+func TestPeerUnreachableMap(t *testing.T) {
+	now := v1.Now()
+	old := v1.NewTime(now.Add(-time.Minute))
+	newTunnel := func(name string, phase egressv1.EgressTunnelPhase, heartbeat v1.Time, peers ...egressv1.PeerStatus) egressv1.EgressTunnel {
+		return egressv1.EgressTunnel{
+			ObjectMeta: v1.ObjectMeta{Name: name},
+			Status: egressv1.EgressTunnelStatus{
+				Phase:             phase,
+				LastHeartbeatTime: heartbeat,
+				Peers:             peers,
+			},
+		}
+	}
+
+	tunnels := []egressv1.EgressTunnel{
+		newTunnel("node1", egressv1.EgressTunnelReady, now,
+			egressv1.PeerStatus{Name: "node2", Reachable: true, LastProbeTime: now},
+			egressv1.PeerStatus{Name: "node3", Reachable: false, Loss: 100, LastProbeTime: now},
+			egressv1.PeerStatus{Name: "node4", Reachable: false, Loss: 100, LastProbeTime: old},
+		),
+		newTunnel("node2", egressv1.EgressTunnelReady, now,
+			egressv1.PeerStatus{Name: "node1", Reachable: false, Loss: 100, LastProbeTime: now},
+			egressv1.PeerStatus{Name: "node3", Reachable: false, Loss: 100, LastProbeTime: now},
+		),
+		newTunnel("node3", egressv1.EgressTunnelReady, now,
+			egressv1.PeerStatus{Name: "node1", Reachable: true, LastProbeTime: now},
+		),
+		// reports of the tunnel which is not ready are ignored
+		newTunnel("node4", egressv1.EgressTunnelHeartbeatTimeout, old,
+			egressv1.PeerStatus{Name: "node2", Reachable: false, Loss: 100, LastProbeTime: old},
+		),
+	}
+
+	got := peerUnreachableMap(tunnels, time.Second*15)
+	exp := map[string]bool{
+		"node1": false,
+		"node2": false,
+		"node3": true,
+	}
+	assert.Equal(t, exp, got)
+}
+
+func TestIsUpstreamDown(t *testing.T) {
+	now := v1.Now()
+	old := v1.NewTime(now.Add(-time.Minute))
+	timeout := time.Second * 15
+
+	assert.False(t, isUpstreamDown(nil, timeout))
+	assert.True(t, isUpstreamDown([]egressv1.UpstreamStatus{
+		{NextHop: "172.18.0.1", Reachable: false, Loss: 100, LastProbeTime: now},
+		{NextHop: "172.19.0.1", Reachable: true, LastProbeTime: old},
+	}, timeout))
+	// one of the multipath next hops is alive
+	assert.False(t, isUpstreamDown([]egressv1.UpstreamStatus{
+		{NextHop: "172.18.0.1", Reachable: false, Loss: 100, LastProbeTime: now},
+		{NextHop: "172.19.0.1", Reachable: true, LastProbeTime: now},
+	}, timeout))
+	// stale results are ignored
+	assert.False(t, isUpstreamDown([]egressv1.UpstreamStatus{
+		{NextHop: "172.18.0.1", Reachable: false, Loss: 100, LastProbeTime: old},
+	}, timeout))
+}
+
 func TestNewEgressTunnelController(t *testing.T) {
 	labels := map[string]string{"app": "nginx1"}
 	initialObjects := []client.Object{
@@ -352,3 +416,114 @@ func TestNewEgressTunnelController(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestKeepNetworks(t *testing.T) {
+	cfg := &config.Config{
+		FileConfig: config.FileConfig{EnableIPv4: true, EnableIPv6: false},
+	}
+	gateway := &egressv1.EgressGateway{
+		ObjectMeta: v1.ObjectMeta{Name: "egw1"},
+		Spec: egressv1.EgressGatewaySpec{
+			Tunnel: &egressv1.GatewayTunnel{VNI: 200, IPv4Subnet: "192.200.0.0/24"},
+		},
+	}
+	builder := fake.NewClientBuilder()
+	builder.WithScheme(schema.GetScheme())
+	builder.WithObjects(gateway)
+
+	mark, err := markallocator.NewAllocatorMarkRange("0x26000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := egReconciler{
+		client:   builder.Build(),
+		log:      logger.NewLogger(cfg.EnvConfig.Logger),
+		config:   cfg,
+		mark:     mark,
+		networks: make(map[string]*network),
+	}
+	log := r.log
+
+	node := &egressv1.EgressTunnel{ObjectMeta: v1.ObjectMeta{Name: "node1"}}
+	changed, _, err := r.keepNetworks(node, log)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	item, ok := node.Status.GetNetwork("egw1")
+	assert.True(t, ok)
+	assert.Equal(t, 200, item.VNI)
+	assert.NotEmpty(t, item.Mark)
+	assert.True(t, strings.HasPrefix(item.IPv4, "192.200.0."))
+	allocated := item.Mark
+
+	changed, _, err = r.keepNetworks(node, log)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// the network is released after the gateway is deleted
+	assert.NoError(t, r.client.Delete(context.Background(), gateway))
+	changed, _, err = r.keepNetworks(node, log)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Empty(t, node.Status.Networks)
+	assert.False(t, r.mark.Has(allocated))
+}
+
+func TestRenumberTunnel(t *testing.T) {
+	cfg := &config.Config{
+		FileConfig: config.FileConfig{
+			EnableIPv4:                true,
+			TunnelRenumberGracePeriod: 60,
+		},
+	}
+	node := &egressv1.EgressTunnel{
+		ObjectMeta: v1.ObjectMeta{Name: "node1"},
+		Status: egressv1.EgressTunnelStatus{
+			Tunnel: egressv1.Tunnel{IPv4: "10.6.0.5", MAC: "66:d5:78:f5:8a:59"},
+			Mark:   "0x26000001",
+		},
+	}
+	builder := fake.NewClientBuilder()
+	builder.WithScheme(schema.GetScheme())
+	builder.WithObjects(node)
+	builder.WithStatusSubresource(node)
+
+	mark, err := markallocator.NewAllocatorMarkRange("0x26000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cidr, err := net.ParseCIDR("10.7.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocatorV4, err := ipallocator.NewCIDRRange(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := egReconciler{
+		client:      builder.Build(),
+		log:         logger.NewLogger(cfg.EnvConfig.Logger),
+		config:      cfg,
+		mark:        mark,
+		allocatorV4: allocatorV4,
+		networks:    make(map[string]*network),
+	}
+
+	assert.NoError(t, r.reBuildCache(*node, r.log))
+	assert.NoError(t, r.client.Get(context.Background(), types.NamespacedName{Name: "node1"}, node))
+	assert.Empty(t, node.Status.Tunnel.IPv4)
+	if assert.NotNil(t, node.Status.Tunnel.Previous) {
+		assert.Equal(t, "10.6.0.5", node.Status.Tunnel.Previous.IPv4)
+		assert.True(t, node.Status.Tunnel.Previous.ExpireTime.After(time.Now()))
+	}
+
+	assert.NoError(t, r.keepEgressTunnel(*node, r.log))
+	assert.NoError(t, r.client.Get(context.Background(), types.NamespacedName{Name: "node1"}, node))
+	assert.True(t, cidr.Contains(net.ParseIP(node.Status.Tunnel.IPv4)))
+	assert.NotNil(t, node.Status.Tunnel.Previous)
+
+	// the previous address is removed after it expires
+	node.Status.Tunnel.Previous.ExpireTime = metav1.NewTime(time.Now().Add(-time.Second))
+	assert.NoError(t, r.keepEgressTunnel(*node, r.log))
+	assert.NoError(t, r.client.Get(context.Background(), types.NamespacedName{Name: "node1"}, node))
+	assert.Nil(t, node.Status.Tunnel.Previous)
+}
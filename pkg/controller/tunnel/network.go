@@ -0,0 +1,282 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+
+	"github.com/cilium/ipam/service/ipallocator"
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+// network is the dedicated tunnel network of an EgressGateway
+type network struct {
+	vni         int
+	allocatorV4 *ipallocator.Range
+	allocatorV6 *ipallocator.Range
+}
+
+func newNetwork(tunnel egressv1.GatewayTunnel, enableIPv4, enableIPv6 bool) (*network, error) {
+	n := &network{vni: tunnel.VNI}
+	if enableIPv4 {
+		_, cidr, err := net.ParseCIDR(tunnel.IPv4Subnet)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ipv4Subnet: %v", err)
+		}
+		n.allocatorV4, err = ipallocator.NewCIDRRange(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("ipallocator.NewCIDRRange with error: %v", err)
+		}
+	}
+	if enableIPv6 {
+		_, cidr, err := net.ParseCIDR(tunnel.IPv6Subnet)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ipv6Subnet: %v", err)
+		}
+		n.allocatorV6, err = ipallocator.NewCIDRRange(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("ipallocator.NewCIDRRange with error: %v", err)
+		}
+	}
+	return n, nil
+}
+
+// listNetworks returns the tunnel networks of all EgressGateways, the allocators
+// of the new networks are created, and the removed networks are dropped.
+func (r *egReconciler) listNetworks(ctx context.Context) (map[string]*network, error) {
+	gateways := new(egressv1.EgressGatewayList)
+	if err := r.client.List(ctx, gateways); err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]*network)
+	for _, gateway := range gateways.Items {
+		if gateway.Spec.Tunnel == nil {
+			continue
+		}
+		n, ok := r.networks[gateway.Name]
+		if !ok || n.vni != gateway.Spec.Tunnel.VNI {
+			var err error
+			n, err = newNetwork(*gateway.Spec.Tunnel, r.config.FileConfig.EnableIPv4, r.config.FileConfig.EnableIPv6)
+			if err != nil {
+				r.log.Error(err, "invalid tunnel network of EgressGateway", "gateway", gateway.Name)
+				continue
+			}
+		}
+		res[gateway.Name] = n
+	}
+	r.networks = res
+	return res, nil
+}
+
+// releaseNetwork releases the tunnel addresses and mark of the node in the network,
+// the addresses are not released if the network is nil.
+func (r *egReconciler) releaseNetwork(item egressv1.TunnelNetwork, n *network, log logr.Logger) {
+	if item.Mark != "" {
+		if err := r.mark.Release(item.Mark); err != nil {
+			log.Error(err, "failed to release network mark", "gateway", item.Gateway, "mark", item.Mark)
+		} else {
+			countNumMarkReleaseCalls.Inc()
+		}
+	}
+	if n == nil {
+		return
+	}
+	if ip := net.ParseIP(item.IPv4); ip != nil && n.allocatorV4 != nil {
+		if err := n.allocatorV4.Release(ip); err == nil {
+			countNumIPReleaseCallsIpv4.Inc()
+		}
+	}
+	if ip := net.ParseIP(item.IPv6); ip != nil && n.allocatorV6 != nil {
+		if err := n.allocatorV6.Release(ip); err == nil {
+			countNumIPReleaseCallsIpv6.Inc()
+		}
+	}
+}
+
+// getNetwork returns the network of the item if it is not changed
+func (r *egReconciler) getNetwork(item egressv1.TunnelNetwork) *network {
+	n, ok := r.networks[item.Gateway]
+	if !ok || n.vni != item.VNI {
+		return nil
+	}
+	return n
+}
+
+// keepNetworks ensures the node has the tunnel addresses and mark in every network,
+// it returns true if the networks of the node are changed, and the rollback functions
+// of the new allocated networks.
+func (r *egReconciler) keepNetworks(node *egressv1.EgressTunnel, log logr.Logger) (bool, []func(), error) {
+	rollback := make([]func(), 0)
+	networks, err := r.listNetworks(context.Background())
+	if err != nil {
+		return false, rollback, err
+	}
+
+	needUpdate := false
+	res := make([]egressv1.TunnelNetwork, 0, len(networks))
+	exists := make(map[string]struct{})
+	for _, item := range node.Status.Networks {
+		n := r.getNetwork(item)
+		if n == nil {
+			log.V(1).Info("release tunnel network", "gateway", item.Gateway, "vni", item.VNI)
+			r.releaseNetwork(item, nil, log)
+			needUpdate = true
+			continue
+		}
+		exists[item.Gateway] = struct{}{}
+		res = append(res, item)
+	}
+
+	for name, n := range networks {
+		if _, ok := exists[name]; ok {
+			continue
+		}
+		item, err := r.allocateNetwork(node, name, n, log)
+		if err != nil {
+			return false, rollback, err
+		}
+		log.V(1).Info("allocate tunnel network", "gateway", name, "vni", n.vni,
+			"ipv4", item.IPv4, "ipv6", item.IPv6, "mark", item.Mark)
+		rollback = append(rollback, func() {
+			r.releaseNetwork(item, n, log)
+		})
+		res = append(res, item)
+		needUpdate = true
+	}
+
+	node.Status.Networks = res
+	return needUpdate, rollback, nil
+}
+
+func (r *egReconciler) allocateNetwork(node *egressv1.EgressTunnel, gateway string, n *network, log logr.Logger) (egressv1.TunnelNetwork, error) {
+	item := egressv1.TunnelNetwork{Gateway: gateway, VNI: n.vni}
+
+	mark, err := r.allocateNextMark(node)
+	if err != nil {
+		return item, err
+	}
+	item.Mark = mark
+
+	if n.allocatorV4 != nil {
+		ip, err := allocateByHash(n.allocatorV4, node.Name)
+		if err != nil {
+			r.releaseNetwork(item, n, log)
+			return item, fmt.Errorf("can't allocate next ipv4 of network %s: %v", gateway, err)
+		}
+		countNumIPAllocateNextCallsIpv4.Inc()
+		item.IPv4 = ip.String()
+	}
+	if n.allocatorV6 != nil {
+		ip, err := allocateByHash(n.allocatorV6, node.Name)
+		if err != nil {
+			r.releaseNetwork(item, n, log)
+			return item, fmt.Errorf("can't allocate next ipv6 of network %s: %v", gateway, err)
+		}
+		countNumIPAllocateNextCallsIpv6.Inc()
+		item.IPv6 = ip.String()
+	}
+	return item, nil
+}
+
+// reBuildNetworkCache rebuilds the allocators of networks by the status of node,
+// the networks which can't be reused are removed from the status.
+func (r *egReconciler) reBuildNetworkCache(node *egressv1.EgressTunnel, log logr.Logger) (bool, error) {
+	if len(node.Status.Networks) == 0 {
+		return false, nil
+	}
+	networks, err := r.listNetworks(context.Background())
+	if err != nil {
+		return false, err
+	}
+
+	needUpdate := false
+	res := make([]egressv1.TunnelNetwork, 0, len(node.Status.Networks))
+	for _, item := range node.Status.Networks {
+		n, ok := networks[item.Gateway]
+		if !ok || n.vni != item.VNI {
+			needUpdate = true
+			continue
+		}
+		if err := r.mark.Allocate(item.Mark); err != nil {
+			log.Error(err, "can't reused network mark", "gateway", item.Gateway, "mark", item.Mark)
+			needUpdate = true
+			continue
+		}
+		if ip := net.ParseIP(item.IPv4); ip != nil && n.allocatorV4 != nil {
+			if err := n.allocatorV4.Allocate(ip); err != nil {
+				log.Error(err, "can't reused network ipv4", "gateway", item.Gateway, "ipv4", item.IPv4)
+				_ = r.mark.Release(item.Mark)
+				needUpdate = true
+				continue
+			}
+		}
+		if ip := net.ParseIP(item.IPv6); ip != nil && n.allocatorV6 != nil {
+			if err := n.allocatorV6.Allocate(ip); err != nil {
+				log.Error(err, "can't reused network ipv6", "gateway", item.Gateway, "ipv6", item.IPv6)
+				_ = r.mark.Release(item.Mark)
+				if ip := net.ParseIP(item.IPv4); ip != nil && n.allocatorV4 != nil {
+					_ = n.allocatorV4.Release(ip)
+				}
+				needUpdate = true
+				continue
+			}
+		}
+		res = append(res, item)
+	}
+	node.Status.Networks = res
+	return needUpdate, nil
+}
+
+// enqueueAllEgressTunnel enqueues all EgressTunnels when the tunnel network of EgressGateway changes
+func enqueueAllEgressTunnel(cli client.Client) handler.MapFunc {
+	toReq := utils.KindToMapFlat("EgressTunnel")
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		tunnels := new(egressv1.EgressTunnelList)
+		if err := cli.List(ctx, tunnels); err != nil {
+			return nil
+		}
+		res := make([]reconcile.Request, 0, len(tunnels.Items))
+		for i := range tunnels.Items {
+			res = append(res, toReq(ctx, &tunnels.Items[i])...)
+		}
+		return res
+	}
+}
+
+type gatewayTunnelPredicate struct{}
+
+func (p gatewayTunnelPredicate) Create(createEvent event.CreateEvent) bool {
+	gateway, ok := createEvent.Object.(*egressv1.EgressGateway)
+	return ok && gateway.Spec.Tunnel != nil
+}
+
+func (p gatewayTunnelPredicate) Delete(deleteEvent event.DeleteEvent) bool {
+	gateway, ok := deleteEvent.Object.(*egressv1.EgressGateway)
+	return ok && gateway.Spec.Tunnel != nil
+}
+
+func (p gatewayTunnelPredicate) Update(updateEvent event.UpdateEvent) bool {
+	oldGateway, ok := updateEvent.ObjectOld.(*egressv1.EgressGateway)
+	if !ok {
+		return false
+	}
+	newGateway, ok := updateEvent.ObjectNew.(*egressv1.EgressGateway)
+	if !ok {
+		return false
+	}
+	return !reflect.DeepEqual(oldGateway.Spec.Tunnel, newGateway.Spec.Tunnel)
+}
+
+func (p gatewayTunnelPredicate) Generic(_ event.GenericEvent) bool { return false }
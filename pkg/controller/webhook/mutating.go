@@ -33,6 +33,10 @@ func MutateHook(client client.Client, cfg *config.Config) *webhook.Admission {
 				return mutateHookEgressPolicy(ctx, req, client)
 			case EgressClusterPolicy:
 				return mutateHookEgressClusterPolicy(ctx, req, client)
+			case Pod:
+				if cfg.FileConfig.EnableGatewayColocation {
+					return mutateHookPod(ctx, req, client, cfg.FileConfig.EnableDatapathReadyCondition)
+				}
 			}
 
 			return webhook.Allowed("checked")
@@ -49,7 +53,13 @@ func mutateHookEgressPolicy(ctx context.Context, req webhook.AdmissionRequest, c
 
 	patchList := make([]jsonpatch.JsonPatchOperation, 0)
 
-	if policy.Spec.EgressGatewayName == "" {
+	if policy.Spec.EgressGatewayName == "" && policy.Spec.EgressGatewaySelector != nil {
+		p, err := getSelectedEgwPatch(ctx, cli, policy.Spec.EgressGatewaySelector)
+		if err != nil {
+			return webhook.Denied(err.Error())
+		}
+		patchList = append(patchList, *p)
+	} else if policy.Spec.EgressGatewayName == "" {
 		ns := &corev1.Namespace{}
 		err := cli.Get(ctx, types.NamespacedName{Name: policy.Namespace}, ns)
 		if err != nil {
@@ -118,6 +128,31 @@ func getGlobalDefaultEgwPatch(ctx context.Context, cli client.Client) (*jsonpatc
 	return patch, nil
 }
 
+// getSelectedEgwPatch sets the EgressGateway selected by the selector of the policy
+func getSelectedEgwPatch(ctx context.Context, cli client.Client, selector *egressv1.GatewaySelector) (*jsonpatch.JsonPatchOperation, error) {
+	if selector.Selector == nil {
+		return nil, fmt.Errorf("egressGatewaySelector.selector cannot be empty")
+	}
+	egwList := &egressv1.EgressGatewayList{}
+	if err := cli.List(ctx, egwList); err != nil {
+		return nil, fmt.Errorf("failed to list EgressGateway: %v", err)
+	}
+	name, _, err := egressgateway.SelectGateway(egwList.Items, selector, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid egressGatewaySelector: %v", err)
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no Ready EgressGateway matches egressGatewaySelector")
+	}
+
+	patch := &jsonpatch.JsonPatchOperation{
+		Operation: "add",
+		Path:      "/spec/egressGatewayName",
+		Value:     name,
+	}
+	return patch, nil
+}
+
 func mutateHookEgressClusterPolicy(ctx context.Context, req webhook.AdmissionRequest, cli client.Client) webhook.AdmissionResponse {
 	policy := new(egressv1.EgressClusterPolicy)
 	err := json.Unmarshal(req.Object.Raw, policy)
@@ -201,3 +201,150 @@ func TestMutateHook(t *testing.T) {
 		})
 	}
 }
+
+func TestMutateHookPod(t *testing.T) {
+	ctx := context.TODO()
+
+	policy := &v1beta1.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "default"},
+		Spec: v1beta1.EgressPolicySpec{
+			AppliedTo: v1beta1.AppliedTo{
+				PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "chatty"}},
+			},
+		},
+		Status: v1beta1.EgressPolicyStatus{Node: "node1"},
+	}
+	clusterPolicy := &v1beta1.EgressClusterPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-policy"},
+		Spec: v1beta1.EgressClusterPolicySpec{
+			AppliedTo: v1beta1.ClusterAppliedTo{
+				PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "chatty"}},
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+			},
+		},
+		Status: v1beta1.EgressPolicyStatus{Node: "node2"},
+	}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"team": "a"}}}
+
+	cases := map[string]struct {
+		enabled       bool
+		readinessGate bool
+		labels        map[string]string
+		patched       bool
+	}{
+		"colocate pod": {
+			enabled: true,
+			labels:  map[string]string{"app": "chatty", v1beta1.LabelPreferColocateWithGateway: "true"},
+			patched: true,
+		},
+		"colocate pod with readiness gate": {
+			enabled:       true,
+			readinessGate: true,
+			labels:        map[string]string{"app": "chatty", v1beta1.LabelPreferColocateWithGateway: "true"},
+			patched:       true,
+		},
+		"pod without label": {
+			enabled: true,
+			labels:  map[string]string{"app": "chatty"},
+		},
+		"pod not selected by policies": {
+			enabled: true,
+			labels:  map[string]string{"app": "other", v1beta1.LabelPreferColocateWithGateway: "true"},
+		},
+		"colocation disabled": {
+			labels: map[string]string{"app": "chatty", v1beta1.LabelPreferColocateWithGateway: "true"},
+		},
+	}
+
+	for k, v := range cases {
+		t.Run(k, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Labels: v.labels}}
+			raw, err := json.Marshal(pod)
+			assert.NoError(t, err)
+
+			cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).
+				WithObjects(policy, clusterPolicy, ns).Build()
+			conf := &config.Config{FileConfig: config.FileConfig{
+				EnableGatewayColocation:      v.enabled,
+				EnableDatapathReadyCondition: v.readinessGate,
+			}}
+
+			resp := MutateHook(cli, conf).Handle(ctx, admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Kind:      metav1.GroupVersionKind{Kind: "Pod"},
+					Namespace: "default",
+					Operation: admissionv1.Create,
+					Object:    runtime.RawExtension{Raw: raw},
+				},
+			})
+			assert.True(t, resp.Allowed)
+			if !v.patched {
+				assert.Empty(t, resp.Patches)
+				return
+			}
+			affinity, ok := resp.Patches[0].Value.(*corev1.Affinity)
+			assert.True(t, ok)
+			terms := affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+			assert.Len(t, terms, 1)
+			assert.Equal(t, []string{"node1", "node2"}, terms[0].Preference.MatchExpressions[0].Values)
+			if !v.readinessGate {
+				assert.Len(t, resp.Patches, 1)
+				return
+			}
+			assert.Len(t, resp.Patches, 2)
+			assert.Equal(t, "/spec/readinessGates", resp.Patches[1].Path)
+			assert.Equal(t, []corev1.PodReadinessGate{{ConditionType: v1beta1.ConditionDatapathReady}}, resp.Patches[1].Value)
+		})
+	}
+}
+
+func TestMutateHookEgressPolicySelector(t *testing.T) {
+	ctx := context.TODO()
+	egw := &v1beta1.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "egw-blue", Labels: map[string]string{"pool": "blue"}},
+		Status: v1beta1.EgressGatewayStatus{Conditions: []metav1.Condition{
+			{Type: v1beta1.GatewayConditionReady, Status: metav1.ConditionTrue},
+		}},
+	}
+
+	cases := map[string]struct {
+		pool    string
+		allowed bool
+	}{
+		"the matching gateway is selected": {pool: "blue", allowed: true},
+		"denied without candidate":         {pool: "green", allowed: false},
+	}
+
+	for k, v := range cases {
+		t.Run(k, func(t *testing.T) {
+			policy := &v1beta1.EgressPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "eg-test", Namespace: "default"},
+				Spec: v1beta1.EgressPolicySpec{
+					EgressGatewaySelector: &v1beta1.GatewaySelector{
+						Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"pool": v.pool}},
+					},
+					EgressIP: v1beta1.EgressIP{AllocatorPolicy: "default"},
+				},
+			}
+			raw, err := json.Marshal(policy)
+			assert.NoError(t, err)
+
+			cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(egw).Build()
+			resp := MutateHook(cli, &config.Config{}).Handle(ctx, admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Kind:      metav1.GroupVersionKind{Kind: "EgressPolicy"},
+					Namespace: "default",
+					Operation: admissionv1.Create,
+					Object:    runtime.RawExtension{Raw: raw},
+				},
+			})
+			assert.Equal(t, v.allowed, resp.Allowed)
+			if !v.allowed {
+				return
+			}
+			assert.Len(t, resp.Patches, 1)
+			assert.Equal(t, "/spec/egressGatewayName", resp.Patches[0].Path)
+			assert.Equal(t, "egw-blue", resp.Patches[0].Value)
+		})
+	}
+}
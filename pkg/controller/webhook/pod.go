@@ -0,0 +1,155 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"gomodules.xyz/jsonpatch/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// colocateWeight is the weight of the preferred node affinity of the gateway nodes
+const colocateWeight = 50
+
+// mutateHookPod adds the preferred node affinity of the gateway nodes of the policies
+// which select the Pod, so that the Pod is scheduled to its gateway node if possible,
+// and its egress traffic is not forwarded through the tunnel. The readiness gate of the
+// DatapathReady condition is also added if readinessGate is true, so the Pod is not ready
+// until the datapath of its node converged. The Pod is never denied, it is admitted as
+// is if the gateway nodes are unknown.
+func mutateHookPod(ctx context.Context, req webhook.AdmissionRequest, cli client.Client, readinessGate bool) webhook.AdmissionResponse {
+	pod := new(corev1.Pod)
+	if err := json.Unmarshal(req.Object.Raw, pod); err != nil {
+		return webhook.Allowed("skipped")
+	}
+	if pod.Labels[egressv1.LabelPreferColocateWithGateway] != "true" {
+		return webhook.Allowed("skipped")
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = req.Namespace
+	}
+
+	nodes, err := gatewayNodesOfPod(ctx, cli, pod)
+	if err != nil || len(nodes) == 0 {
+		return webhook.Allowed("skipped")
+	}
+
+	affinity := pod.Spec.Affinity
+	if affinity == nil {
+		affinity = new(corev1.Affinity)
+	}
+	if affinity.NodeAffinity == nil {
+		affinity.NodeAffinity = new(corev1.NodeAffinity)
+	}
+	affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(
+		affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
+		corev1.PreferredSchedulingTerm{
+			Weight: colocateWeight,
+			Preference: corev1.NodeSelectorTerm{
+				MatchExpressions: []corev1.NodeSelectorRequirement{{
+					Key:      corev1.LabelHostname,
+					Operator: corev1.NodeSelectorOpIn,
+					Values:   nodes,
+				}},
+			},
+		})
+
+	patches := []jsonpatch.JsonPatchOperation{{
+		Operation: "add",
+		Path:      "/spec/affinity",
+		Value:     affinity,
+	}}
+	if readinessGate && !hasReadinessGate(pod, egressv1.ConditionDatapathReady) {
+		patches = append(patches, jsonpatch.JsonPatchOperation{
+			Operation: "add",
+			Path:      "/spec/readinessGates",
+			Value: append(pod.Spec.ReadinessGates, corev1.PodReadinessGate{
+				ConditionType: egressv1.ConditionDatapathReady,
+			}),
+		})
+	}
+	return webhook.Patched("patched", patches...)
+}
+
+func hasReadinessGate(pod *corev1.Pod, conditionType corev1.PodConditionType) bool {
+	for _, gate := range pod.Spec.ReadinessGates {
+		if gate.ConditionType == conditionType {
+			return true
+		}
+	}
+	return false
+}
+
+// gatewayNodesOfPod returns the nodes of the egress IPs of the policies selecting the Pod
+func gatewayNodesOfPod(ctx context.Context, cli client.Client, pod *corev1.Pod) ([]string, error) {
+	set := make(map[string]struct{})
+
+	policies := new(egressv1.EgressPolicyList)
+	if err := cli.List(ctx, policies, client.InNamespace(pod.Namespace)); err != nil {
+		return nil, err
+	}
+	for _, item := range policies.Items {
+		if item.Status.Node != "" && selectorMatches(item.Spec.AppliedTo.PodSelector, pod.Labels) {
+			set[item.Status.Node] = struct{}{}
+		}
+	}
+
+	clusterPolicies := new(egressv1.EgressClusterPolicyList)
+	if err := cli.List(ctx, clusterPolicies); err != nil {
+		return nil, err
+	}
+	var ns *corev1.Namespace
+	for _, item := range clusterPolicies.Items {
+		if item.Status.Node == "" {
+			continue
+		}
+		podSelector, err := item.Spec.PodLabelSelector()
+		if err != nil || !podSelector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		if item.Spec.AppliedTo.NamespaceSelector != nil || item.Spec.ClusterDefault {
+			if ns == nil {
+				ns = new(corev1.Namespace)
+				if err := cli.Get(ctx, types.NamespacedName{Name: pod.Namespace}, ns); err != nil {
+					return nil, err
+				}
+			}
+			nsSelector, err := item.Spec.NamespaceLabelSelector()
+			if err != nil || !nsSelector.Matches(labels.Set(ns.Labels)) {
+				continue
+			}
+		}
+		set[item.Status.Node] = struct{}{}
+	}
+
+	nodes := make([]string, 0, len(set))
+	for node := range set {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes, nil
+}
+
+// selectorMatches returns whether the nil-able selector selects the labels, the policies
+// selecting Pods by subnets are ignored because the IP of the new Pod is unknown
+func selectorMatches(selector *metav1.LabelSelector, set map[string]string) bool {
+	if selector == nil {
+		return false
+	}
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false
+	}
+	return s.Matches(labels.Set(set))
+}
@@ -7,10 +7,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net"
 
+	"golang.org/x/exp/slices"
 	v1 "k8s.io/api/admission/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -20,6 +23,7 @@ import (
 	"github.com/spidernet-io/egressgateway/pkg/constant"
 	"github.com/spidernet-io/egressgateway/pkg/egressgateway"
 	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schedule"
 	"github.com/spidernet-io/egressgateway/pkg/utils/ip"
 )
 
@@ -28,6 +32,8 @@ const (
 	EgressGateway       = "EgressGateway"
 	EgressPolicy        = "EgressPolicy"
 	EgressClusterPolicy = "EgressClusterPolicy"
+	EgressIPClaim       = "EgressIPClaim"
+	Pod                 = "Pod"
 )
 
 // ValidateHook ValidateHook
@@ -47,6 +53,8 @@ func ValidateHook(client client.Client, cfg *config.Config) *webhook.Admission {
 				return validateEgressClusterPolicy(ctx, client, req, cfg)
 			case EgressPolicy:
 				return validateEgressPolicy(ctx, client, req, cfg)
+			case EgressIPClaim:
+				return validateEgressIPClaim(ctx, client, req)
 			}
 
 			return webhook.Allowed("checked")
@@ -75,6 +83,14 @@ func validateEgressPolicy(ctx context.Context, client client.Client, req webhook
 		}
 	}
 
+	if resp := validateClaimName(ctx, client, egp.Spec.EgressIP, egp.Spec.EgressGatewayName); !resp.Allowed {
+		return resp
+	}
+
+	if resp := validateIPFamilyPolicy(egp.Spec.EgressIP, cfg); !resp.Allowed {
+		return resp
+	}
+
 	if len(egp.Spec.EgressIP.IPv4) != 0 && !isIPv4(egp.Spec.EgressIP.IPv4) {
 		return webhook.Denied("invalid ipv4 format")
 	}
@@ -86,13 +102,47 @@ func validateEgressPolicy(ctx context.Context, client client.Client, req webhook
 		return webhook.Denied("podSelector and podSubnet cannot be used together")
 	}
 
-	// denied when both PodSelector and PodSubnet are empty
-	if egp.Spec.AppliedTo.PodSubnet == nil || len(egp.Spec.AppliedTo.PodSubnet) == 0 {
+	// denied when PodSelector, PodSubnet and StaticEndpoints are all empty
+	if (egp.Spec.AppliedTo.PodSubnet == nil || len(egp.Spec.AppliedTo.PodSubnet) == 0) && len(egp.Spec.AppliedTo.StaticEndpoints) == 0 {
 		if egp.Spec.AppliedTo.PodSelector == nil || (len(egp.Spec.AppliedTo.PodSelector.MatchLabels) == 0 && len(egp.Spec.AppliedTo.PodSelector.MatchExpressions) == 0) {
-			return webhook.Denied("invalid EgressPolicy, spec.appliedTo field requires at least one of spec.appliedTo.podSubnet, .spec.appliedTo.podSelector.matchLabels or .spec.appliedTo.podSelector.matchExpressions to be specified.")
+			return webhook.Denied("invalid EgressPolicy, spec.appliedTo field requires at least one of spec.appliedTo.podSubnet, .spec.appliedTo.podSelector.matchLabels, .spec.appliedTo.podSelector.matchExpressions or .spec.appliedTo.staticEndpoints to be specified.")
 		}
 	}
 
+	if resp := validateStaticEndpoints(egp.Spec.AppliedTo.StaticEndpoints); !resp.Allowed {
+		return resp
+	}
+
+	if resp := validateSchedule(egp.Spec.Schedule); !resp.Allowed {
+		return resp
+	}
+
+	if resp := validateProxyProtocol(egp.Spec.ProxyProtocol, cfg); !resp.Allowed {
+		return resp
+	}
+
+	if resp := validateL7Proxy(egp.Spec.L7Proxy, egp.Spec.ProxyProtocol, cfg); !resp.Allowed {
+		return resp
+	}
+
+	if resp := validateDestSubnetFrom(egp.Spec.DestSubnetFrom, egp.Namespace, cfg); !resp.Allowed {
+		return resp
+	}
+
+	if resp := validateRollout(egp.Spec); !resp.Allowed {
+		return resp
+	}
+
+	if resp := validateGatewaySelector(egp.Spec.EgressGatewaySelector); !resp.Allowed {
+		return resp
+	}
+
+	if resp := validateDestinationEIPs(ctx, client, egp.Spec.DestinationEIPs, egp.Spec.EgressIP, egp.Spec.EgressGatewayName, cfg); !resp.Allowed {
+		return resp
+	}
+
+	// the policy is rebound when its gateway is changed, the EIP is checked as creating
+	rebind := false
 	if req.Operation == v1.Update {
 		oldEgp := new(egressv1.EgressPolicy)
 		err := json.Unmarshal(req.OldObject.Raw, oldEgp)
@@ -100,28 +150,34 @@ func validateEgressPolicy(ctx context.Context, client client.Client, req webhook
 			return webhook.Denied(fmt.Sprintf("json unmarshal EgressPolicy with error: %v", err))
 		}
 
-		if egp.Spec.EgressGatewayName != oldEgp.Spec.EgressGatewayName {
-			return webhook.Denied("'spec.EgressGatewayName' field is immutable")
-		}
+		rebind = egp.Spec.EgressGatewayName != oldEgp.Spec.EgressGatewayName
 
 		if egp.Spec.EgressIP.UseNodeIP != oldEgp.Spec.EgressIP.UseNodeIP {
 			return webhook.Denied("the UseNodeIP field cannot be modified")
 		}
 
-		if egp.Spec.EgressIP.IPv4 != oldEgp.Spec.EgressIP.IPv4 {
-			return webhook.Denied("the EgressIP.IPv4 field cannot be modified")
+		if !rebind && egp.Spec.EgressIP.IPv4 != oldEgp.Spec.EgressIP.IPv4 {
+			return webhook.Denied("the EgressIP.IPv4 field can only be modified together with spec.egressGatewayName")
 		}
 
-		if egp.Spec.EgressIP.IPv6 != oldEgp.Spec.EgressIP.IPv6 {
-			return webhook.Denied("the EgressIP.IPv6 field cannot be modified")
+		if !rebind && egp.Spec.EgressIP.IPv6 != oldEgp.Spec.EgressIP.IPv6 {
+			return webhook.Denied("the EgressIP.IPv6 field can only be modified together with spec.egressGatewayName")
 		}
 
 		if egp.Spec.EgressIP.AllocatorPolicy != oldEgp.Spec.EgressIP.AllocatorPolicy {
 			return webhook.Denied("the EgressIP.AllocatorPolicy field cannot be modified")
 		}
+
+		if !rebind && egp.Spec.EgressIP.ClaimName != oldEgp.Spec.EgressIP.ClaimName {
+			return webhook.Denied("the EgressIP.ClaimName field can only be modified together with spec.egressGatewayName")
+		}
 	}
 
-	if req.Operation == v1.Create {
+	if req.Operation == v1.Create || rebind {
+		if resp := validateSelectedGateway(ctx, client, egp.Spec.EgressGatewaySelector, egp.Spec.EgressGatewayName); !resp.Allowed {
+			return resp
+		}
+
 		if cfg.FileConfig.EnableIPv4 || cfg.FileConfig.EnableIPv6 {
 			if ok, err := checkEIP(client, ctx, egp.Spec.EgressIP.IPv4, egp.Spec.EgressIP.IPv6, egp.Spec.EgressGatewayName, cfg); !ok {
 				return webhook.Denied(err.Error())
@@ -167,6 +223,14 @@ func validateEgressClusterPolicy(ctx context.Context, client client.Client, req
 		}
 	}
 
+	if resp := validateClaimName(ctx, client, policy.Spec.EgressIP, policy.Spec.EgressGatewayName); !resp.Allowed {
+		return resp
+	}
+
+	if resp := validateIPFamilyPolicy(policy.Spec.EgressIP, cfg); !resp.Allowed {
+		return resp
+	}
+
 	if len(policy.Spec.EgressIP.IPv4) != 0 && !isIPv4(policy.Spec.EgressIP.IPv4) {
 		return webhook.Denied("invalid ipv4 format")
 	}
@@ -179,13 +243,51 @@ func validateEgressClusterPolicy(ctx context.Context, client client.Client, req
 		return webhook.Denied("podSelector and podSubnet cannot be used together")
 	}
 
-	// denied when both PodSelector and PodSubnet are empty
-	if policy.Spec.AppliedTo.PodSubnet == nil || len(*policy.Spec.AppliedTo.PodSubnet) == 0 {
+	// the cluster default policy selects the Pods by the namespaces rather than the subnets
+	if policy.Spec.ClusterDefault && policy.Spec.AppliedTo.PodSubnet != nil && len(*policy.Spec.AppliedTo.PodSubnet) != 0 {
+		return webhook.Denied("clusterDefault and podSubnet cannot be used together")
+	}
+
+	// denied when PodSelector, PodSubnet and StaticEndpoints are all empty, unless the policy
+	// is the cluster default policy selecting all the Pods
+	if !policy.Spec.ClusterDefault && (policy.Spec.AppliedTo.PodSubnet == nil || len(*policy.Spec.AppliedTo.PodSubnet) == 0) && len(policy.Spec.AppliedTo.StaticEndpoints) == 0 {
 		if policy.Spec.AppliedTo.PodSelector == nil || (len(policy.Spec.AppliedTo.PodSelector.MatchLabels) == 0 && len(policy.Spec.AppliedTo.PodSelector.MatchExpressions) == 0) {
-			return webhook.Denied("invalid EgressClusterPolicy, spec.appliedTo field requires at least one of spec.appliedTo.podSubnet, .spec.appliedTo.podSelector.matchLabels or .spec.appliedTo.podSelector.matchExpressions to be specified.")
+			return webhook.Denied("invalid EgressClusterPolicy, spec.appliedTo field requires at least one of spec.appliedTo.podSubnet, .spec.appliedTo.podSelector.matchLabels, .spec.appliedTo.podSelector.matchExpressions or .spec.appliedTo.staticEndpoints to be specified.")
+		}
+	}
+
+	if resp := validateStaticEndpoints(policy.Spec.AppliedTo.StaticEndpoints); !resp.Allowed {
+		return resp
+	}
+
+	if policy.Spec.ExcludeNodes != nil {
+		if _, err := metav1.LabelSelectorAsSelector(policy.Spec.ExcludeNodes); err != nil {
+			return webhook.Denied(fmt.Sprintf("invalid excludeNodes: %v", err))
 		}
 	}
 
+	if resp := validateSchedule(policy.Spec.Schedule); !resp.Allowed {
+		return resp
+	}
+
+	if resp := validateProxyProtocol(policy.Spec.ProxyProtocol, cfg); !resp.Allowed {
+		return resp
+	}
+
+	if resp := validateL7Proxy(policy.Spec.L7Proxy, policy.Spec.ProxyProtocol, cfg); !resp.Allowed {
+		return resp
+	}
+
+	if resp := validateDestSubnetFrom(policy.Spec.DestSubnetFrom, "", cfg); !resp.Allowed {
+		return resp
+	}
+
+	if resp := validateDestinationEIPs(ctx, client, policy.Spec.DestinationEIPs, policy.Spec.EgressIP, policy.Spec.EgressGatewayName, cfg); !resp.Allowed {
+		return resp
+	}
+
+	// the policy is rebound when its gateway is changed, the EIP is checked as creating
+	rebind := false
 	if req.Operation == v1.Update {
 		oldPolicy := new(egressv1.EgressClusterPolicy)
 		err := json.Unmarshal(req.OldObject.Raw, oldPolicy)
@@ -193,28 +295,30 @@ func validateEgressClusterPolicy(ctx context.Context, client client.Client, req
 			return webhook.Denied(fmt.Sprintf("json unmarshal EgressClusterPolicy with error: %v", err))
 		}
 
-		if policy.Spec.EgressGatewayName != oldPolicy.Spec.EgressGatewayName {
-			return webhook.Denied("'spec.EgressGatewayName' field is immutable")
-		}
+		rebind = policy.Spec.EgressGatewayName != oldPolicy.Spec.EgressGatewayName
 
 		if policy.Spec.EgressIP.UseNodeIP != oldPolicy.Spec.EgressIP.UseNodeIP {
 			return webhook.Denied("the UseNodeIP field cannot be modified")
 		}
 
-		if policy.Spec.EgressIP.IPv4 != oldPolicy.Spec.EgressIP.IPv4 {
-			return webhook.Denied("the EgressIP.IPv4 field cannot be modified")
+		if !rebind && policy.Spec.EgressIP.IPv4 != oldPolicy.Spec.EgressIP.IPv4 {
+			return webhook.Denied("the EgressIP.IPv4 field can only be modified together with spec.egressGatewayName")
 		}
 
-		if policy.Spec.EgressIP.IPv6 != oldPolicy.Spec.EgressIP.IPv6 {
-			return webhook.Denied("the EgressIP.IPv6 field cannot be modified")
+		if !rebind && policy.Spec.EgressIP.IPv6 != oldPolicy.Spec.EgressIP.IPv6 {
+			return webhook.Denied("the EgressIP.IPv6 field can only be modified together with spec.egressGatewayName")
 		}
 
 		if policy.Spec.EgressIP.AllocatorPolicy != oldPolicy.Spec.EgressIP.AllocatorPolicy {
 			return webhook.Denied("the EgressIP.AllocatorPolicy field cannot be modified")
 		}
+
+		if !rebind && policy.Spec.EgressIP.ClaimName != oldPolicy.Spec.EgressIP.ClaimName {
+			return webhook.Denied("the EgressIP.ClaimName field can only be modified together with spec.egressGatewayName")
+		}
 	}
 
-	if req.Operation == v1.Create {
+	if req.Operation == v1.Create || rebind {
 		if cfg.FileConfig.EnableIPv4 || cfg.FileConfig.EnableIPv6 {
 			if ok, err := checkEIP(client, ctx, policy.Spec.EgressIP.IPv4, policy.Spec.EgressIP.IPv6, policy.Spec.EgressGatewayName, cfg); !ok {
 				return webhook.Denied(err.Error())
@@ -241,6 +345,146 @@ func validateEgressClusterPolicy(ctx context.Context, client client.Client, req
 	return validateSubnet(policy.Spec.DestSubnet)
 }
 
+// validateIPFamilyPolicy checks the family sent through the gateway is enabled, and the EIP of
+// the other family isn't specified
+func validateIPFamilyPolicy(eip egressv1.EgressIP, cfg *config.Config) webhook.AdmissionResponse {
+	switch eip.IPFamilyPolicy {
+	case egressv1.IPFamilyPolicyIPv4Only:
+		if !cfg.FileConfig.EnableIPv4 {
+			return webhook.Denied("ipFamilyPolicy IPv4Only cannot be used when IPv4 is disabled")
+		}
+		if len(eip.IPv6) != 0 {
+			return webhook.Denied("ipFamilyPolicy IPv4Only cannot be used with egressIP.ipv6 at the same time")
+		}
+	case egressv1.IPFamilyPolicyIPv6Only:
+		if !cfg.FileConfig.EnableIPv6 {
+			return webhook.Denied("ipFamilyPolicy IPv6Only cannot be used when IPv6 is disabled")
+		}
+		if len(eip.IPv4) != 0 {
+			return webhook.Denied("ipFamilyPolicy IPv6Only cannot be used with egressIP.ipv4 at the same time")
+		}
+	}
+	return webhook.Allowed("checked")
+}
+
+// validateDestinationEIPs checks the destinations and the EIPs of each group, the EIPs are of
+// the enabled families and within the ippools of the gateway of the policy
+func validateDestinationEIPs(ctx context.Context, client client.Client, list []egressv1.DestinationEIP,
+	eip egressv1.EgressIP, egwName string, cfg *config.Config) webhook.AdmissionResponse {
+	if len(list) != 0 && eip.UseNodeIP {
+		return webhook.Denied("destinationEIPs cannot be used with useNodeIP")
+	}
+	names := make(map[string]struct{}, len(list))
+	for _, item := range list {
+		if len(item.Name) == 0 {
+			return webhook.Denied("the name of destinationEIPs cannot be empty")
+		}
+		if _, ok := names[item.Name]; ok {
+			return webhook.Denied(fmt.Sprintf("destinationEIPs %v is duplicated", item.Name))
+		}
+		names[item.Name] = struct{}{}
+
+		if len(item.DestSubnet) == 0 {
+			return webhook.Denied(fmt.Sprintf("destinationEIPs %v requires at least one destSubnet", item.Name))
+		}
+		if resp := validateSubnet(item.DestSubnet); !resp.Allowed {
+			return resp
+		}
+		if len(item.IPv4) == 0 && len(item.IPv6) == 0 {
+			return webhook.Denied(fmt.Sprintf("destinationEIPs %v requires at least one of ipv4 and ipv6", item.Name))
+		}
+		if len(item.IPv4) != 0 {
+			if !isIPv4(item.IPv4) {
+				return webhook.Denied(fmt.Sprintf("invalid ipv4 format of destinationEIPs %v", item.Name))
+			}
+			if !cfg.FileConfig.EnableIPv4 || eip.IPFamilyPolicy == egressv1.IPFamilyPolicyIPv6Only {
+				return webhook.Denied(fmt.Sprintf("the ipv4 of destinationEIPs %v cannot be used when IPv4 is not sent through the gateway", item.Name))
+			}
+		}
+		if len(item.IPv6) != 0 {
+			if !isIPv6(item.IPv6) {
+				return webhook.Denied(fmt.Sprintf("invalid ipv6 format of destinationEIPs %v", item.Name))
+			}
+			if !cfg.FileConfig.EnableIPv6 || eip.IPFamilyPolicy == egressv1.IPFamilyPolicyIPv4Only {
+				return webhook.Denied(fmt.Sprintf("the ipv6 of destinationEIPs %v cannot be used when IPv6 is not sent through the gateway", item.Name))
+			}
+		}
+		if ok, err := checkEIPIncluded(client, ctx, item.IPv4, item.IPv6, egwName); !ok {
+			if err != nil {
+				return webhook.Denied(err.Error())
+			}
+			return webhook.Denied(fmt.Sprintf("the EIPs of destinationEIPs %v are not within the ip ranges defined in the ippools of the egressgateway", item.Name))
+		}
+	}
+	return webhook.Allowed("checked")
+}
+
+// validateClaimName checks the EgressIPClaim referenced by the policy exists and belongs to the
+// gateway of the policy, the EIP of the policy is only decided by the claim
+func validateClaimName(ctx context.Context, client client.Client, eip egressv1.EgressIP, egwName string) webhook.AdmissionResponse {
+	if len(eip.ClaimName) == 0 {
+		return webhook.Allowed("checked")
+	}
+	if eip.UseNodeIP || len(eip.IPv4) != 0 || len(eip.IPv6) != 0 {
+		return webhook.Denied("egressIP.claimName cannot be used with egressIP.ipv4, egressIP.ipv6 or egressIP.useNodeIP at the same time")
+	}
+
+	claim := new(egressv1.EgressIPClaim)
+	err := client.Get(ctx, types.NamespacedName{Name: eip.ClaimName}, claim)
+	if err != nil {
+		return webhook.Denied(fmt.Sprintf("failed to get the EgressIPClaim %s: %v", eip.ClaimName, err))
+	}
+	if !claim.DeletionTimestamp.IsZero() {
+		return webhook.Denied(fmt.Sprintf("the EgressIPClaim %s is being deleted", eip.ClaimName))
+	}
+	if claim.Spec.EgressGatewayName != egwName {
+		return webhook.Denied(fmt.Sprintf("the EgressIPClaim %s belongs to the EgressGateway %s rather than %s",
+			eip.ClaimName, claim.Spec.EgressGatewayName, egwName))
+	}
+	return webhook.Allowed("checked")
+}
+
+func validateEgressIPClaim(ctx context.Context, client client.Client, req webhook.AdmissionRequest) webhook.AdmissionResponse {
+	if req.Operation == v1.Delete {
+		return webhook.Allowed("checked")
+	}
+
+	claim := new(egressv1.EgressIPClaim)
+	err := json.Unmarshal(req.Object.Raw, claim)
+	if err != nil {
+		return webhook.Denied(fmt.Sprintf("json unmarshal EgressIPClaim with error: %v", err))
+	}
+
+	if len(claim.Spec.EgressGatewayName) == 0 {
+		return webhook.Denied("egressGatewayName cannot be empty")
+	}
+	if len(claim.Spec.IPv4) != 0 && !isIPv4(claim.Spec.IPv4) {
+		return webhook.Denied("invalid ipv4 format")
+	}
+	if len(claim.Spec.IPv6) != 0 && !isIPv6(claim.Spec.IPv6) {
+		return webhook.Denied("invalid ipv6 format")
+	}
+
+	if req.Operation == v1.Update {
+		oldClaim := new(egressv1.EgressIPClaim)
+		err := json.Unmarshal(req.OldObject.Raw, oldClaim)
+		if err != nil {
+			return webhook.Denied(fmt.Sprintf("json unmarshal EgressIPClaim with error: %v", err))
+		}
+		if claim.Spec.EgressGatewayName != oldClaim.Spec.EgressGatewayName {
+			return webhook.Denied("'spec.EgressGatewayName' field is immutable")
+		}
+	}
+
+	if ok, err := checkEIPIncluded(client, ctx, claim.Spec.IPv4, claim.Spec.IPv6, claim.Spec.EgressGatewayName); !ok {
+		if err != nil {
+			return webhook.Denied(err.Error())
+		}
+		return webhook.Denied("the Spec.IPv4 or Spec.IPv6 is not within the ip ranges defined in the ippools of the egressgateway")
+	}
+	return webhook.Allowed("checked")
+}
+
 // checkEGWIppools when creating the policy with the value of the field .Spec.EgressIP.UseNodeIP set to be false, the ippools of the gateway should not be empty
 func checkEGWIppools(client client.Client, cfg *config.Config, ctx context.Context, name, allocatorPolicy string) error {
 
@@ -390,6 +634,233 @@ func validateSubnet(subnet []string) webhook.AdmissionResponse {
 	return webhook.Allowed("checked")
 }
 
+// validateDestSubnetFrom checks each source of the destinations is one of a provider in the
+// controller configuration, a GeoIP selector and a Service, whose features are enabled. The
+// Services of an EgressPolicy are in its namespace, which is empty for EgressClusterPolicy
+func validateDestSubnetFrom(list []egressv1.DestSubnetSource, namespace string, cfg *config.Config) webhook.AdmissionResponse {
+	for _, item := range list {
+		count := 0
+		if item.Provider != "" {
+			count++
+		}
+		if item.GeoIP != nil {
+			count++
+		}
+		if item.Service != nil {
+			count++
+		}
+		if count != 1 {
+			return webhook.Denied("destSubnetFrom requires exactly one of destSubnetFrom.provider, destSubnetFrom.geoIP and destSubnetFrom.service")
+		}
+
+		switch {
+		case item.GeoIP != nil:
+			if resp := validateGeoIPSelector(item.GeoIP, cfg.FileConfig.GeoIP); !resp.Allowed {
+				return resp
+			}
+		case item.Service != nil:
+			if !cfg.FileConfig.EnableDestinationService {
+				return webhook.Denied("destSubnetFrom.service requires feature.enableDestinationService")
+			}
+			if item.Service.Name == "" {
+				return webhook.Denied("destSubnetFrom.service.name cannot be empty")
+			}
+			if namespace == "" && item.Service.Namespace == "" {
+				return webhook.Denied("destSubnetFrom.service.namespace cannot be empty in EgressClusterPolicy")
+			}
+			if namespace != "" && item.Service.Namespace != "" && item.Service.Namespace != namespace {
+				return webhook.Denied("destSubnetFrom.service.namespace must be the namespace of the EgressPolicy")
+			}
+		default:
+			found := false
+			for _, provider := range cfg.FileConfig.DestinationProviders {
+				if provider.Name == item.Provider {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return webhook.Denied(fmt.Sprintf("unknown destination provider %s in destSubnetFrom", item.Provider))
+			}
+		}
+	}
+	return webhook.Allowed("checked")
+}
+
+func validateGeoIPSelector(selector *egressv1.GeoIPSelector, cfg config.GeoIP) webhook.AdmissionResponse {
+	if !cfg.Enable {
+		return webhook.Denied("destSubnetFrom.geoIP requires feature.geoIP.enable")
+	}
+	if len(selector.Countries) == 0 && len(selector.ASNs) == 0 {
+		return webhook.Denied("destSubnetFrom.geoIP requires at least one of countries and asns")
+	}
+	if len(selector.Countries) != 0 && cfg.CountryDatabase == "" {
+		return webhook.Denied("destSubnetFrom.geoIP.countries requires feature.geoIP.countryDatabase")
+	}
+	if len(selector.ASNs) != 0 && cfg.ASNDatabase == "" {
+		return webhook.Denied("destSubnetFrom.geoIP.asns requires feature.geoIP.asnDatabase")
+	}
+	for _, code := range selector.Countries {
+		if !isCountryCode(code) {
+			return webhook.Denied(fmt.Sprintf("invalid country code %s, it should be an ISO 3166-1 alpha-2 code", code))
+		}
+	}
+	for _, asn := range selector.ASNs {
+		if asn <= 0 || asn > math.MaxUint32 {
+			return webhook.Denied(fmt.Sprintf("invalid ASN %d", asn))
+		}
+	}
+	return webhook.Allowed("checked")
+}
+
+func isCountryCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	for _, c := range code {
+		if (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+func validateStaticEndpoints(list []string) webhook.AdmissionResponse {
+	invalidList := make([]string, 0)
+	for _, item := range list {
+		if net.ParseIP(item) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(item); err != nil {
+			invalidList = append(invalidList, item)
+		}
+	}
+	if len(invalidList) > 0 {
+		return webhook.Denied(fmt.Sprintf("invalid staticEndpoints list: %v", invalidList))
+	}
+	return webhook.Allowed("checked")
+}
+
+func validateSchedule(spec *egressv1.PolicySchedule) webhook.AdmissionResponse {
+	if spec == nil {
+		return webhook.Allowed("checked")
+	}
+	if _, err := schedule.New(spec); err != nil {
+		return webhook.Denied(fmt.Sprintf("invalid schedule: %v", err))
+	}
+	return webhook.Allowed("checked")
+}
+
+// validateProxyProtocol checks the ports of the relayed connections, which are relayed only
+// if the agents run the relay
+func validateProxyProtocol(spec *egressv1.ProxyProtocol, cfg *config.Config) webhook.AdmissionResponse {
+	if spec == nil {
+		return webhook.Allowed("checked")
+	}
+	if !cfg.FileConfig.ProxyProtocol.Enable {
+		return webhook.Denied("proxyProtocol requires feature.proxyProtocol.enable")
+	}
+	seen := make(map[int32]struct{}, len(spec.Ports))
+	for _, port := range spec.Ports {
+		if port <= 0 || port > 65535 {
+			return webhook.Denied(fmt.Sprintf("invalid proxyProtocol port %d", port))
+		}
+		if _, ok := seen[port]; ok {
+			return webhook.Denied(fmt.Sprintf("duplicate proxyProtocol port %d", port))
+		}
+		seen[port] = struct{}{}
+	}
+	return webhook.Allowed("checked")
+}
+
+// validateL7Proxy checks the ports of the proxied connections, which are proxied only if the
+// agents run the proxy, a port can't be both proxied and relayed with the PROXY protocol
+func validateL7Proxy(spec *egressv1.L7Proxy, relayed *egressv1.ProxyProtocol, cfg *config.Config) webhook.AdmissionResponse {
+	if spec == nil {
+		return webhook.Allowed("checked")
+	}
+	if !cfg.FileConfig.L7Proxy.Enable {
+		return webhook.Denied("l7Proxy requires feature.l7Proxy.enable")
+	}
+	seen := make(map[int32]struct{}, len(spec.Ports))
+	for _, port := range spec.Ports {
+		if port <= 0 || port > 65535 {
+			return webhook.Denied(fmt.Sprintf("invalid l7Proxy port %d", port))
+		}
+		if _, ok := seen[port]; ok {
+			return webhook.Denied(fmt.Sprintf("duplicate l7Proxy port %d", port))
+		}
+		if relayed != nil && slices.Contains(relayed.Ports, port) {
+			return webhook.Denied(fmt.Sprintf("l7Proxy port %d is also in proxyProtocol", port))
+		}
+		seen[port] = struct{}{}
+	}
+	return webhook.Allowed("checked")
+}
+
+// validateRollout checks the rollout of the EgressPolicy, which only chooses the Pods
+// selected by spec.appliedTo.podSelector
+func validateRollout(spec egressv1.EgressPolicySpec) webhook.AdmissionResponse {
+	rollout := spec.Rollout
+	if rollout == nil {
+		return webhook.Allowed("checked")
+	}
+	if (rollout.Percentage == nil) == (rollout.PodSelector == nil) {
+		return webhook.Denied("rollout requires exactly one of rollout.percentage and rollout.podSelector")
+	}
+	if rollout.Percentage != nil && (*rollout.Percentage < 0 || *rollout.Percentage > 100) {
+		return webhook.Denied("rollout.percentage must be in the range [0, 100]")
+	}
+	if rollout.PodSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(rollout.PodSelector); err != nil {
+			return webhook.Denied(fmt.Sprintf("invalid rollout.podSelector: %v", err))
+		}
+	}
+	if spec.AppliedTo.PodSelector == nil || len(spec.AppliedTo.PodSubnet) != 0 {
+		return webhook.Denied("rollout can only be used with spec.appliedTo.podSelector")
+	}
+	return webhook.Allowed("checked")
+}
+
+// validateGatewaySelector checks the selector of the EgressGateway of the EgressPolicy
+func validateGatewaySelector(selector *egressv1.GatewaySelector) webhook.AdmissionResponse {
+	if selector == nil {
+		return webhook.Allowed("checked")
+	}
+	if selector.Selector == nil {
+		return webhook.Denied("egressGatewaySelector.selector cannot be empty")
+	}
+	if _, err := metav1.LabelSelectorAsSelector(selector.Selector); err != nil {
+		return webhook.Denied(fmt.Sprintf("invalid egressGatewaySelector: %v", err))
+	}
+	switch selector.Strategy {
+	case "", egressv1.GatewaySelectionFirstReady, egressv1.GatewaySelectionLeastLoaded:
+	default:
+		return webhook.Denied(fmt.Sprintf("invalid egressGatewaySelector.strategy %q", selector.Strategy))
+	}
+	return webhook.Allowed("checked")
+}
+
+// validateSelectedGateway checks the EgressGateway of the EgressPolicy is selected by its
+// selector, as the policy is created or rebound
+func validateSelectedGateway(ctx context.Context, client client.Client, selector *egressv1.GatewaySelector, egwName string) webhook.AdmissionResponse {
+	if selector == nil {
+		return webhook.Allowed("checked")
+	}
+	egw := new(egressv1.EgressGateway)
+	if err := client.Get(ctx, types.NamespacedName{Name: egwName}, egw); err != nil {
+		return webhook.Denied(fmt.Sprintf("failed to get EgressGateway %s: %v", egwName, err))
+	}
+	ok, err := egressgateway.GatewayMatches(*egw, selector)
+	if err != nil {
+		return webhook.Denied(fmt.Sprintf("invalid egressGatewaySelector: %v", err))
+	}
+	if !ok {
+		return webhook.Denied(fmt.Sprintf("the EgressGateway %s is not selected by egressGatewaySelector", egwName))
+	}
+	return webhook.Allowed("checked")
+}
+
 func isIPv4(ip string) bool {
 	if netIP := net.ParseIP(ip); netIP != nil && netIP.To4() != nil {
 		return true
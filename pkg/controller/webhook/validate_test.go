@@ -7,11 +7,13 @@ import (
 	"context"
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	admissionv1 "k8s.io/api/admission/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/pointer"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -45,6 +47,50 @@ func TestValidateEgressGateway(t *testing.T) {
 			},
 			expAllow: false,
 		},
+		"EgressGateway the ippools overlap another EgressGateway": {
+			existingResources: []runtime.Object{
+				&v1beta1.EgressGateway{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "eg-exist",
+					},
+					Spec: v1beta1.EgressGatewaySpec{
+						Ippools: v1beta1.Ippools{
+							IPv4: []string{"10.6.1.50-10.6.1.55"},
+						},
+					},
+				},
+			},
+			newResource: &v1beta1.EgressGateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "eg-test",
+				},
+				Spec: v1beta1.EgressGatewaySpec{
+					Ippools: v1beta1.Ippools{
+						IPv4: []string{"10.6.1.55-10.6.1.60"},
+					},
+					NodeSelector: v1beta1.NodeSelector{
+						Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"egress": "true"}},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "ippools 10.6.1.55-10.6.1.60 overlaps with 10.6.1.50-10.6.1.55 of EgressGateway eg-exist",
+		},
+		"EgressGateway persistent with MASQUERADE": {
+			newResource: &v1beta1.EgressGateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "eg-test",
+				},
+				Spec: v1beta1.EgressGatewaySpec{
+					NodeSelector: v1beta1.NodeSelector{
+						Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"egress": "true"}},
+					},
+					SNAT: &v1beta1.SNAT{Mode: v1beta1.SNATModeMasquerade, Persistent: true},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "spec.snat.persistent is not supported by the MASQUERADE mode",
+		},
 	}
 	for name, c := range cases {
 		t.Run(name, func(t *testing.T) {
@@ -53,6 +99,7 @@ func TestValidateEgressGateway(t *testing.T) {
 
 			builder := fake.NewClientBuilder()
 			builder.WithScheme(schema.GetScheme())
+			builder.WithRuntimeObjects(c.existingResources...)
 			cli := builder.Build()
 			conf := &config.Config{
 				FileConfig: config.FileConfig{
@@ -125,6 +172,98 @@ func TestValidateEgressPolicy(t *testing.T) {
 			},
 			expAllow: true,
 		},
+		"case, ipFamilyPolicy with ipv6": {
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					IPv6:           "fc00:f853:ccd:e793:a::3",
+					IPFamilyPolicy: v1beta1.IPFamilyPolicyIPv4Only,
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "ipFamilyPolicy IPv4Only cannot be used with egressIP.ipv6 at the same time",
+		},
+		"case, ipFamilyPolicy with ipv4": {
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					IPv4:           "172.18.1.2",
+					IPFamilyPolicy: v1beta1.IPFamilyPolicyIPv6Only,
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "ipFamilyPolicy IPv6Only cannot be used with egressIP.ipv4 at the same time",
+		},
+		"case, destinationEIPs": {
+			existingResources: []client.Object{
+				&v1beta1.EgressGateway{
+					ObjectMeta: metav1.ObjectMeta{Name: "test"},
+					Spec: v1beta1.EgressGatewaySpec{
+						Ippools: v1beta1.Ippools{IPv4: []string{"172.18.1.2-172.18.1.5"}},
+					},
+				},
+			},
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				DestinationEIPs: []v1beta1.DestinationEIP{
+					{Name: "partner-a", DestSubnet: []string{"1.1.1.0/24"}, IPv4: "172.18.1.3"},
+				},
+			},
+			expAllow: true,
+		},
+		"case, destinationEIPs out of the ippools": {
+			existingResources: []client.Object{
+				&v1beta1.EgressGateway{
+					ObjectMeta: metav1.ObjectMeta{Name: "test"},
+					Spec: v1beta1.EgressGatewaySpec{
+						Ippools: v1beta1.Ippools{IPv4: []string{"172.18.1.2-172.18.1.5"}},
+					},
+				},
+			},
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				DestinationEIPs: []v1beta1.DestinationEIP{
+					{Name: "partner-a", DestSubnet: []string{"1.1.1.0/24"}, IPv4: "172.18.2.3"},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "the EIPs of destinationEIPs partner-a are not within the ip ranges defined in the ippools of the egressgateway",
+		},
+		"case, destinationEIPs without EIP": {
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				DestinationEIPs: []v1beta1.DestinationEIP{
+					{Name: "partner-a", DestSubnet: []string{"1.1.1.0/24"}},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "destinationEIPs partner-a requires at least one of ipv4 and ipv6",
+		},
 		"case1, not valid": {
 			existingResources: nil,
 			spec: v1beta1.EgressPolicySpec{
@@ -296,13 +435,309 @@ func TestValidateEgressPolicy(t *testing.T) {
 			},
 			expAllow: false,
 		},
+		"case3, valid claim": {
+			existingResources: []client.Object{
+				&v1beta1.EgressGateway{
+					ObjectMeta: metav1.ObjectMeta{Name: "test"},
+					Spec: v1beta1.EgressGatewaySpec{
+						Ippools: v1beta1.Ippools{IPv4: []string{"172.18.1.2-172.18.1.5"}},
+					},
+				},
+				&v1beta1.EgressIPClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: "claim"},
+					Spec:       v1beta1.EgressIPClaimSpec{EgressGatewayName: "test"},
+				},
+			},
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP:          v1beta1.EgressIP{ClaimName: "claim"},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      true,
+			expErrMessage: "",
+		},
+		"case4, claim of another gateway": {
+			existingResources: []client.Object{
+				&v1beta1.EgressGateway{
+					ObjectMeta: metav1.ObjectMeta{Name: "test"},
+					Spec: v1beta1.EgressGatewaySpec{
+						Ippools: v1beta1.Ippools{IPv4: []string{"172.18.1.2-172.18.1.5"}},
+					},
+				},
+				&v1beta1.EgressIPClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: "claim"},
+					Spec:       v1beta1.EgressIPClaimSpec{EgressGatewayName: "other"},
+				},
+			},
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP:          v1beta1.EgressIP{ClaimName: "claim"},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "the EgressIPClaim claim belongs to the EgressGateway other rather than test",
+		},
+		"case5, claim with ipv4": {
+			existingResources: []client.Object{
+				&v1beta1.EgressGateway{
+					ObjectMeta: metav1.ObjectMeta{Name: "test"},
+					Spec: v1beta1.EgressGatewaySpec{
+						Ippools: v1beta1.Ippools{IPv4: []string{"172.18.1.2-172.18.1.5"}},
+					},
+				},
+				&v1beta1.EgressIPClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: "claim"},
+					Spec:       v1beta1.EgressIPClaimSpec{EgressGatewayName: "test"},
+				},
+			},
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP:          v1beta1.EgressIP{ClaimName: "claim", IPv4: "172.18.1.2"},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "egressIP.claimName cannot be used with egressIP.ipv4, egressIP.ipv6 or egressIP.useNodeIP at the same time",
+		},
+		"case, invalid schedule": {
+			existingResources: nil,
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				Schedule: &v1beta1.PolicySchedule{
+					Windows: []v1beta1.ScheduleWindow{
+						{Start: "0 9 * *", Duration: metav1.Duration{Duration: time.Hour}},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: `invalid schedule: window 0: cron expression "0 9 * *" must have 5 fields, got 4`,
+		},
+		"case, l7Proxy without the feature": {
+			existingResources: nil,
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				L7Proxy: &v1beta1.L7Proxy{Ports: []int32{3128}},
+			},
+			expAllow:      false,
+			expErrMessage: "l7Proxy requires feature.l7Proxy.enable",
+		},
+		"case, valid rollout": {
+			existingResources: []client.Object{
+				&v1beta1.EgressGateway{
+					ObjectMeta: metav1.ObjectMeta{Name: "test"},
+					Spec: v1beta1.EgressGatewaySpec{
+						Ippools: v1beta1.Ippools{
+							IPv4: []string{"172.18.1.2-172.18.1.5"},
+							IPv6: []string{"fc00:f853:ccd:e793:a::3-fc00:f853:ccd:e793:a::6"},
+						},
+					},
+				},
+			},
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				Rollout: &v1beta1.PolicyRollout{Percentage: pointer.Int32(10)},
+			},
+			expAllow: true,
+		},
+		"case, rollout without percentage and podSelector": {
+			existingResources: nil,
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				Rollout: &v1beta1.PolicyRollout{},
+			},
+			expAllow:      false,
+			expErrMessage: "rollout requires exactly one of rollout.percentage and rollout.podSelector",
+		},
+		"case, rollout with podSubnet": {
+			existingResources: nil,
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.AppliedTo{
+					PodSubnet: []string{"172.29.16.0/24"},
+				},
+				Rollout: &v1beta1.PolicyRollout{Percentage: pointer.Int32(10)},
+			},
+			expAllow:      false,
+			expErrMessage: "rollout can only be used with spec.appliedTo.podSelector",
+		},
+		"case, invalid egressGatewaySelector strategy": {
+			existingResources: nil,
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				EgressGatewaySelector: &v1beta1.GatewaySelector{
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "blue"}},
+					Strategy: "RoundRobin",
+				},
+			},
+			expAllow:      false,
+			expErrMessage: `invalid egressGatewaySelector.strategy "RoundRobin"`,
+		},
+		"case, egressGatewayName not selected by egressGatewaySelector": {
+			existingResources: []client.Object{
+				&v1beta1.EgressGateway{
+					ObjectMeta: metav1.ObjectMeta{Name: "test", Labels: map[string]string{"pool": "green"}},
+				},
+			},
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				EgressGatewaySelector: &v1beta1.GatewaySelector{
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "blue"}},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "the EgressGateway test is not selected by egressGatewaySelector",
+		},
+		"case, valid destSubnetFrom": {
+			existingResources: []client.Object{
+				&v1beta1.EgressGateway{
+					ObjectMeta: metav1.ObjectMeta{Name: "test"},
+					Spec: v1beta1.EgressGatewaySpec{
+						Ippools: v1beta1.Ippools{
+							IPv4: []string{"172.18.1.2-172.18.1.5"},
+							IPv6: []string{"fc00:f853:ccd:e793:a::3-fc00:f853:ccd:e793:a::6"},
+						},
+					},
+				},
+			},
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				DestSubnetFrom: []v1beta1.DestSubnetSource{{Provider: "aws-s3"}},
+			},
+			expAllow: true,
+		},
+		"case, unknown destination provider": {
+			existingResources: nil,
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				DestSubnetFrom: []v1beta1.DestSubnetSource{{Provider: "gcp"}},
+			},
+			expAllow:      false,
+			expErrMessage: "unknown destination provider gcp in destSubnetFrom",
+		},
+		"case, valid geoIP": {
+			existingResources: []client.Object{
+				&v1beta1.EgressGateway{
+					ObjectMeta: metav1.ObjectMeta{Name: "test"},
+					Spec: v1beta1.EgressGatewaySpec{
+						Ippools: v1beta1.Ippools{
+							IPv4: []string{"172.18.1.2-172.18.1.5"},
+							IPv6: []string{"fc00:f853:ccd:e793:a::3-fc00:f853:ccd:e793:a::6"},
+						},
+					},
+				},
+			},
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				DestSubnetFrom: []v1beta1.DestSubnetSource{{GeoIP: &v1beta1.GeoIPSelector{Countries: []string{"DE", "fr"}}}},
+			},
+			expAllow: true,
+		},
+		"case, invalid country code": {
+			existingResources: nil,
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				DestSubnetFrom: []v1beta1.DestSubnetSource{{GeoIP: &v1beta1.GeoIPSelector{Countries: []string{"USA"}}}},
+			},
+			expAllow:      false,
+			expErrMessage: "invalid country code USA, it should be an ISO 3166-1 alpha-2 code",
+		},
+		"case, geoIP asns without database": {
+			existingResources: nil,
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				DestSubnetFrom: []v1beta1.DestSubnetSource{{GeoIP: &v1beta1.GeoIPSelector{ASNs: []int64{15169}}}},
+			},
+			expAllow:      false,
+			expErrMessage: "destSubnetFrom.geoIP.asns requires feature.geoIP.asnDatabase",
+		},
+		"case, service of another namespace": {
+			existingResources: nil,
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				DestSubnetFrom: []v1beta1.DestSubnetSource{{Service: &v1beta1.ServiceReference{Namespace: "other", Name: "db"}}},
+			},
+			expAllow:      false,
+			expErrMessage: "destSubnetFrom.service.namespace must be the namespace of the EgressPolicy",
+		},
 	}
 	for name, c := range cases {
 		t.Run(name, func(t *testing.T) {
 
 			policy := &v1beta1.EgressPolicy{
 				ObjectMeta: metav1.ObjectMeta{
-					Name: "policy",
+					Name:      "policy",
+					Namespace: "default",
 				},
 				Spec: c.spec,
 			}
@@ -319,6 +754,11 @@ func TestValidateEgressPolicy(t *testing.T) {
 				FileConfig: config.FileConfig{
 					EnableIPv4: true,
 					EnableIPv6: true,
+					DestinationProviders: []config.DestinationProvider{
+						{Name: "aws-s3", URL: "https://ip-ranges.amazonaws.com/ip-ranges.json"},
+					},
+					GeoIP:                    config.GeoIP{Enable: true, CountryDatabase: "GeoLite2-Country.mmdb"},
+					EnableDestinationService: true,
 				},
 			}
 
@@ -439,9 +879,38 @@ func TestUpdateEgressPolicy(t *testing.T) {
 					},
 				}, DestSubnet: nil,
 			},
-			expAllow:      false,
+			expAllow:      true,
 			expErrMessage: "",
 		},
+		"change egress gateway name with an ipv4 outside the new gateway": {
+			existingResources: []runtime.Object{
+				&v1beta1.EgressGateway{
+					ObjectMeta: metav1.ObjectMeta{Name: "b"},
+					Spec: v1beta1.EgressGatewaySpec{
+						Ippools: v1beta1.Ippools{IPv4: []string{"10.6.2.2-10.6.2.5"}},
+					},
+				},
+			},
+			old: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "a",
+				EgressIP:          v1beta1.EgressIP{IPv4: "10.6.1.2"},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			new: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "b",
+				EgressIP:          v1beta1.EgressIP{IPv4: "10.6.1.2"},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow: false,
+		},
 		"change ipv6": {
 			existingResources: nil,
 			old: v1beta1.EgressPolicySpec{
@@ -494,6 +963,7 @@ func TestUpdateEgressPolicy(t *testing.T) {
 
 			builder := fake.NewClientBuilder()
 			builder.WithScheme(schema.GetScheme())
+			builder.WithRuntimeObjects(c.existingResources...)
 			cli := builder.Build()
 			conf := &config.Config{
 				FileConfig: config.FileConfig{
@@ -753,6 +1223,109 @@ func TestValidateEgressClusterPolicy(t *testing.T) {
 			},
 			expAllow: false,
 		},
+		"case, invalid schedule": {
+			existingResources: nil,
+			spec: v1beta1.EgressClusterPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.ClusterAppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				Schedule: &v1beta1.PolicySchedule{
+					Windows: []v1beta1.ScheduleWindow{
+						{Start: "0 9 * * 1-5", Duration: metav1.Duration{Duration: time.Hour * 200}},
+					},
+				},
+			},
+			expAllow: false,
+		},
+		"case, invalid schedule time zone": {
+			existingResources: nil,
+			spec: v1beta1.EgressClusterPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.ClusterAppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				Schedule: &v1beta1.PolicySchedule{
+					Windows: []v1beta1.ScheduleWindow{
+						{Start: "0 9 * * 1-5", Duration: metav1.Duration{Duration: time.Hour}},
+					},
+					TimeZone: "Mars/Base",
+				},
+			},
+			expAllow: false,
+		},
+		"case, empty destination provider": {
+			existingResources: nil,
+			spec: v1beta1.EgressClusterPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.ClusterAppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				DestSubnetFrom: []v1beta1.DestSubnetSource{{}},
+			},
+			expAllow:      false,
+			expErrMessage: "destSubnetFrom requires exactly one of destSubnetFrom.provider, destSubnetFrom.geoIP and destSubnetFrom.service",
+		},
+		"case, geoIP disabled": {
+			existingResources: nil,
+			spec: v1beta1.EgressClusterPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.ClusterAppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				DestSubnetFrom: []v1beta1.DestSubnetSource{{GeoIP: &v1beta1.GeoIPSelector{Countries: []string{"US"}}}},
+			},
+			expAllow:      false,
+			expErrMessage: "destSubnetFrom.geoIP requires feature.geoIP.enable",
+		},
+		"case, service without namespace": {
+			existingResources: nil,
+			spec: v1beta1.EgressClusterPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.ClusterAppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				DestSubnetFrom: []v1beta1.DestSubnetSource{{Service: &v1beta1.ServiceReference{Name: "db"}}},
+			},
+			expAllow:      false,
+			expErrMessage: "destSubnetFrom.service.namespace cannot be empty in EgressClusterPolicy",
+		},
+		"case: Not valid when excludeNodes is invalid": {
+			spec: v1beta1.EgressClusterPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.ClusterAppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				ExcludeNodes: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "node-role", Operator: "Unknown"}},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: `invalid excludeNodes: "Unknown" is not a valid label selector operator`,
+		},
+		"case: Not valid when clusterDefault is used with podSubnet": {
+			spec: v1beta1.EgressClusterPolicySpec{
+				EgressGatewayName: "test",
+				AppliedTo: v1beta1.ClusterAppliedTo{
+					PodSubnet: &[]string{"172.29.16.0/24"},
+				},
+				ClusterDefault: true,
+			},
+			expAllow:      false,
+			expErrMessage: "clusterDefault and podSubnet cannot be used together",
+		},
 	}
 	for name, c := range cases {
 		t.Run(name, func(t *testing.T) {
@@ -774,8 +1347,9 @@ func TestValidateEgressClusterPolicy(t *testing.T) {
 			cli := builder.Build()
 			conf := &config.Config{
 				FileConfig: config.FileConfig{
-					EnableIPv4: true,
-					EnableIPv6: true,
+					EnableIPv4:               true,
+					EnableIPv6:               true,
+					EnableDestinationService: true,
 				},
 			}
 
@@ -896,7 +1470,7 @@ func TestUpdateEgressClusterPolicy(t *testing.T) {
 					},
 				}, DestSubnet: nil,
 			},
-			expAllow:      false,
+			expAllow:      true,
 			expErrMessage: "",
 		},
 		"change ipv6": {
@@ -0,0 +1,86 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"bytes"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"sort"
+
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils/ip"
+)
+
+// selectEIP selects the EIP of the policy from the pool by the strategy, the used IPs
+// are the EIPs allocated and quarantined, and usage is the number of the policies of
+// each allocated EIP. It returns nil if there is no EIP available.
+func selectEIP(strategy v1beta1.IPAllocationStrategy, pool, used []net.IP, usage map[string]int, policy v1beta1.Policy) net.IP {
+	free := ip.IPsDiffSet(pool, used, true)
+
+	switch strategy {
+	case v1beta1.IPAllocationSequential:
+		if len(free) > 0 {
+			return free[0]
+		}
+	case v1beta1.IPAllocationLeastUsed:
+		if len(free) > 0 {
+			return free[0]
+		}
+		return leastUsedIP(usage)
+	case v1beta1.IPAllocationHashByPolicyName:
+		if len(free) == 0 {
+			return nil
+		}
+		isFree := make(map[string]struct{}, len(free))
+		for _, item := range free {
+			isFree[item.String()] = struct{}{}
+		}
+		sorted := sortIPs(pool)
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(policy.Namespace + "/" + policy.Name))
+		start := int(h.Sum32() % uint32(len(sorted)))
+		for i := range sorted {
+			item := sorted[(start+i)%len(sorted)]
+			if _, ok := isFree[item.String()]; ok {
+				return item
+			}
+		}
+	default:
+		if len(free) > 0 {
+			return free[rand.Intn(len(free))]
+		}
+	}
+	return nil
+}
+
+// leastUsedIP returns the lowest IP used by the least policies
+func leastUsedIP(usage map[string]int) net.IP {
+	var res net.IP
+	least := 0
+	for item, count := range usage {
+		cur := net.ParseIP(item)
+		if cur == nil {
+			continue
+		}
+		if res == nil || count < least || (count == least && bytes.Compare(cur.To16(), res.To16()) < 0) {
+			res, least = cur, count
+		}
+	}
+	return res
+}
+
+func sortIPs(ips []net.IP) []net.IP {
+	res := make([]net.IP, 0, len(ips))
+	for _, item := range ips {
+		if item != nil {
+			res = append(res, item)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return bytes.Compare(res[i].To16(), res[j].To16()) < 0
+	})
+	return res
+}
@@ -0,0 +1,50 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestSelectEIP(t *testing.T) {
+	ips := func(items ...string) []net.IP {
+		res := make([]net.IP, 0, len(items))
+		for _, item := range items {
+			res = append(res, net.ParseIP(item))
+		}
+		return res
+	}
+	pool := ips("10.6.1.24", "10.6.1.21", "10.6.1.23", "10.6.1.22")
+	used := ips("10.6.1.21", "10.6.1.23")
+	usage := map[string]int{"10.6.1.21": 2, "10.6.1.23": 1}
+	p1 := v1beta1.Policy{Name: "p1", Namespace: "default"}
+
+	got := selectEIP(v1beta1.IPAllocationRandom, pool, used, usage, p1)
+	assert.Contains(t, []string{"10.6.1.22", "10.6.1.24"}, got.String())
+	assert.Nil(t, selectEIP(v1beta1.IPAllocationRandom, pool, pool, usage, p1))
+
+	assert.Equal(t, "10.6.1.22", selectEIP(v1beta1.IPAllocationSequential, pool, used, usage, p1).String())
+	assert.Nil(t, selectEIP(v1beta1.IPAllocationSequential, pool, pool, usage, p1))
+
+	// the least used EIP is shared when all EIPs are used
+	assert.Equal(t, "10.6.1.22", selectEIP(v1beta1.IPAllocationLeastUsed, pool, used, usage, p1).String())
+	assert.Equal(t, "10.6.1.23", selectEIP(v1beta1.IPAllocationLeastUsed, pool, pool, usage, p1).String())
+	usage["10.6.1.21"] = 1
+	assert.Equal(t, "10.6.1.21", selectEIP(v1beta1.IPAllocationLeastUsed, pool, pool, usage, p1).String())
+
+	// the same policy gets the same EIP as long as it's free, regardless of the order
+	// of the pool, and the next free one otherwise
+	first := selectEIP(v1beta1.IPAllocationHashByPolicyName, pool, nil, usage, p1)
+	assert.NotNil(t, first)
+	assert.Equal(t, first, selectEIP(v1beta1.IPAllocationHashByPolicyName, ips("10.6.1.21", "10.6.1.22", "10.6.1.23", "10.6.1.24"), nil, usage, p1))
+	next := selectEIP(v1beta1.IPAllocationHashByPolicyName, pool, []net.IP{first}, usage, p1)
+	assert.NotNil(t, next)
+	assert.NotEqual(t, first, next)
+	assert.Nil(t, selectEIP(v1beta1.IPAllocationHashByPolicyName, pool, pool, usage, p1))
+}
@@ -0,0 +1,335 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/constant"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils/ip"
+)
+
+var errClaimNotReady = errors.New("the EIP of the claim is not allocated")
+
+// specEIP returns the EIP requested by the spec of the policy, which is the EIP of the
+// claim if the policy references one
+func (r egnReconciler) specEIP(ctx context.Context, eip egress.EgressIP, egwName string) (string, string, error) {
+	if eip.ClaimName == "" {
+		return eip.IPv4, eip.IPv6, nil
+	}
+	claim := new(egress.EgressIPClaim)
+	if err := r.client.Get(ctx, types.NamespacedName{Name: eip.ClaimName}, claim); err != nil {
+		if apierr.IsNotFound(err) {
+			return "", "", fmt.Errorf("EgressIPClaim %s not found: %w", eip.ClaimName, errClaimNotReady)
+		}
+		return "", "", err
+	}
+	if claim.Spec.EgressGatewayName != egwName {
+		return "", "", fmt.Errorf("EgressIPClaim %s belongs to EgressGateway %s: %w",
+			eip.ClaimName, claim.Spec.EgressGatewayName, errClaimNotReady)
+	}
+	if claim.Status.Eip.Ipv4 == "" && claim.Status.Eip.Ipv6 == "" {
+		return "", "", fmt.Errorf("EgressIPClaim %s: %w", eip.ClaimName, errClaimNotReady)
+	}
+	return claim.Status.Eip.Ipv4, claim.Status.Eip.Ipv6, nil
+}
+
+// claimedIPs returns the EIPs owned or requested by the claims of the gateway except
+// the excluded one, they are not allocated to the other policies and claims
+func (r egnReconciler) claimedIPs(ctx context.Context, egwName, exclude string) ([]net.IP, error) {
+	claims := new(egress.EgressIPClaimList)
+	if err := r.client.List(ctx, claims); err != nil {
+		return nil, err
+	}
+	var res []net.IP
+	for _, claim := range claims.Items {
+		if claim.Name == exclude || claim.Spec.EgressGatewayName != egwName {
+			continue
+		}
+		for _, item := range []string{claim.Status.Eip.Ipv4, claim.Status.Eip.Ipv6, claim.Spec.IPv4, claim.Spec.IPv6} {
+			if item != "" {
+				res = append(res, net.ParseIP(item))
+			}
+		}
+	}
+	return res, nil
+}
+
+// claimPolicies returns the policies referencing the claim
+func (r egnReconciler) claimPolicies(ctx context.Context, name string) ([]egress.Policy, error) {
+	var res []egress.Policy
+	egpList := new(egress.EgressPolicyList)
+	if err := r.client.List(ctx, egpList); err != nil {
+		return nil, err
+	}
+	for _, item := range egpList.Items {
+		if item.Spec.EgressIP.ClaimName == name {
+			res = append(res, egress.Policy{Name: item.Name, Namespace: item.Namespace})
+		}
+	}
+	egcpList := new(egress.EgressClusterPolicyList)
+	if err := r.client.List(ctx, egcpList); err != nil {
+		return nil, err
+	}
+	for _, item := range egcpList.Items {
+		if item.Spec.EgressIP.ClaimName == name {
+			res = append(res, egress.Policy{Name: item.Name})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Namespace != res[j].Namespace {
+			return res[i].Namespace < res[j].Namespace
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res, nil
+}
+
+// reconcileClaim allocates the EIP of the EgressIPClaim, and keeps the claim by the
+// finalizer until no policy references it
+func (r egnReconciler) reconcileClaim(ctx context.Context, req reconcile.Request, log logr.Logger) (reconcile.Result, error) {
+	log = log.WithValues("name", req.Name)
+	claim := new(egress.EgressIPClaim)
+	if err := r.client.Get(ctx, req.NamespacedName, claim); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+
+	policies, err := r.claimPolicies(ctx, claim.Name)
+	if err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+	status := claim.Status.DeepCopy()
+	status.Policies = policies
+	status.PolicyCount = len(policies)
+
+	if !claim.DeletionTimestamp.IsZero() {
+		if len(policies) == 0 {
+			if controllerutil.RemoveFinalizer(claim, egress.FinalizerClaimInUse) {
+				log.Info("no policy references the claim, release the EIP", "eip", claim.Status.Eip)
+				if err := r.client.Update(ctx, claim); err != nil && !apierr.IsNotFound(err) {
+					return reconcile.Result{Requeue: true}, err
+				}
+			}
+			return reconcile.Result{}, nil
+		}
+		log.V(1).Info("wait for the policies to stop referencing the claim", "policies", policies)
+		return reconcile.Result{}, r.updateClaimStatus(ctx, claim, status)
+	}
+
+	if controllerutil.AddFinalizer(claim, egress.FinalizerClaimInUse) {
+		if err := r.client.Update(ctx, claim); err != nil {
+			return reconcile.Result{Requeue: true}, err
+		}
+	}
+
+	ready := metav1.Condition{
+		Type:               egress.ClaimConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Allocated",
+		Message:            "the EIP is allocated",
+		ObservedGeneration: claim.Generation,
+	}
+	egw := new(egress.EgressGateway)
+	err = r.client.Get(ctx, types.NamespacedName{Name: claim.Spec.EgressGatewayName}, egw)
+	if err != nil && !apierr.IsNotFound(err) {
+		return reconcile.Result{Requeue: true}, err
+	}
+	if err != nil {
+		status.Eip = egress.Eip{}
+		ready.Status, ready.Reason = metav1.ConditionFalse, "GatewayNotFound"
+		ready.Message = fmt.Sprintf("EgressGateway %s is not found", claim.Spec.EgressGatewayName)
+	} else if eip, err := r.allocateClaimEIP(ctx, claim, egw, policies); err != nil {
+		status.Eip = egress.Eip{}
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, "AllocationFailed", err.Error()
+	} else {
+		status.Eip = eip
+	}
+	meta.SetStatusCondition(&status.Conditions, ready)
+
+	eipChanged := status.Eip != claim.Status.Eip
+	if err := r.updateClaimStatus(ctx, claim, status); err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+	if !eipChanged {
+		return reconcile.Result{}, nil
+	}
+
+	// the policies referencing the claim are assigned with the new EIP
+	log.Info("the EIP of the claim is changed", "eip", status.Eip, "policies", policies)
+	for _, policy := range policies {
+		_, err := r.reconcileEGP(ctx, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name},
+		}, log)
+		if err != nil {
+			return reconcile.Result{Requeue: true}, err
+		}
+	}
+	return reconcile.Result{}, nil
+}
+
+func (r egnReconciler) updateClaimStatus(ctx context.Context, claim *egress.EgressIPClaim, status *egress.EgressIPClaimStatus) error {
+	if equality.Semantic.DeepEqual(&claim.Status, status) {
+		return nil
+	}
+	claim.Status = *status
+	return r.client.Status().Update(ctx, claim)
+}
+
+// allocateClaimEIP returns the EIP of the claim, the EIP in the status is kept as long
+// as it is valid, and a free EIP is allocated by the strategy of the gateway otherwise
+func (r egnReconciler) allocateClaimEIP(ctx context.Context, claim *egress.EgressIPClaim,
+	egw *egress.EgressGateway, policies []egress.Policy) (egress.Eip, error) {
+	claimed, err := r.claimedIPs(ctx, egw.Name, claim.Name)
+	if err != nil {
+		return egress.Eip{}, err
+	}
+	referencing := make(map[egress.Policy]struct{}, len(policies))
+	for _, policy := range policies {
+		referencing[policy] = struct{}{}
+	}
+
+	allocate := func(version constant.IPVersion, pools []string, requested, current string) (string, error) {
+		if len(pools) == 0 {
+			if requested != "" {
+				return "", fmt.Errorf("EgressGateway %s has no ippools of IPv%d", egw.Name, version)
+			}
+			return "", nil
+		}
+		ranges, err := ip.MergeIPRanges(version, pools)
+		if err != nil {
+			return "", err
+		}
+
+		// the EIPs used by the policies not referencing the claim are not available
+		var used []net.IP
+		for _, node := range egw.Status.NodeList {
+			for _, eip := range node.Eips {
+				item := eip.IPv4
+				if version == constant.IPv6 {
+					item = eip.IPv6
+				}
+				if item == "" {
+					continue
+				}
+				for _, policy := range eip.Policies {
+					if _, ok := referencing[policy]; !ok {
+						used = append(used, net.ParseIP(item))
+						break
+					}
+				}
+			}
+		}
+		used = append(used, claimed...)
+		for _, item := range egw.Status.QuarantinedIPs {
+			used = append(used, net.ParseIP(item))
+		}
+		isUsed := func(item string) bool {
+			target := net.ParseIP(item)
+			for _, u := range used {
+				if u.Equal(target) {
+					return true
+				}
+			}
+			return false
+		}
+		isValid := func(item string) error {
+			included, err := ip.IsIPIncludedRange(version, item, ranges)
+			if err != nil {
+				return err
+			}
+			if !included {
+				return fmt.Errorf("%s is not within the EIP range of EgressGateway %s", item, egw.Name)
+			}
+			if isUsed(item) {
+				return fmt.Errorf("%s is used by another policy or claim of EgressGateway %s", item, egw.Name)
+			}
+			return nil
+		}
+
+		if requested != "" {
+			return requested, isValid(requested)
+		}
+		if current != "" && isValid(current) == nil {
+			return current, nil
+		}
+		pool, err := ip.ParseIPRanges(version, ranges)
+		if err != nil {
+			return "", err
+		}
+		// the EIP of a claim is owned exclusively, so it's never shared
+		selected := selectEIP(egw.Spec.Ippools.Policy, pool, used, nil, egress.Policy{Name: claim.Name})
+		if selected == nil {
+			return "", fmt.Errorf("no EIP of IPv%d is available in EgressGateway %s", version, egw.Name)
+		}
+		return selected.String(), nil
+	}
+
+	var res egress.Eip
+	res.Ipv4, err = allocate(constant.IPv4, egw.Spec.Ippools.IPv4, claim.Spec.IPv4, claim.Status.Eip.Ipv4)
+	if err != nil {
+		return egress.Eip{}, err
+	}
+	res.Ipv6, err = allocate(constant.IPv6, egw.Spec.Ippools.IPv6, claim.Spec.IPv6, claim.Status.Eip.Ipv6)
+	if err != nil {
+		return egress.Eip{}, err
+	}
+	return res, nil
+}
+
+// enqueueClaimOfPolicy enqueues the claim referenced by the policy to count its references
+func enqueueClaimOfPolicy() handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		var name string
+		switch policy := obj.(type) {
+		case *egress.EgressPolicy:
+			name = policy.Spec.EgressIP.ClaimName
+		case *egress.EgressClusterPolicy:
+			name = policy.Spec.EgressIP.ClaimName
+		}
+		if name == "" {
+			return nil
+		}
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "EgressIPClaim/", Name: name}}}
+	}
+}
+
+// enqueueClaimsOfGateway enqueues the claims of the gateway, whose EIPs may be no longer
+// valid with the ippools of the gateway
+func enqueueClaimsOfGateway(cli client.Client) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		claims := new(egress.EgressIPClaimList)
+		if err := cli.List(ctx, claims); err != nil {
+			return nil
+		}
+		var res []reconcile.Request
+		for _, claim := range claims.Items {
+			if claim.Spec.EgressGatewayName == obj.GetName() {
+				res = append(res, reconcile.Request{
+					NamespacedName: types.NamespacedName{Namespace: "EgressIPClaim/", Name: claim.Name},
+				})
+			}
+		}
+		return res
+	}
+}
+
+func isClaimNotReady(err error) bool {
+	return errors.Is(err, errClaimNotReady)
+}
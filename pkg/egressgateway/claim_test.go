@@ -0,0 +1,139 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestReconcileClaim(t *testing.T) {
+	ctx := context.Background()
+	other := egress.Policy{Name: "other", Namespace: "default"}
+	egw := &egress.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "egw1"},
+		Spec: egress.EgressGatewaySpec{Ippools: egress.Ippools{
+			IPv4:   []string{"10.6.1.10-10.6.1.12"},
+			Policy: egress.IPAllocationSequential,
+		}},
+		Status: egress.EgressGatewayStatus{NodeList: []egress.EgressIPStatus{{
+			Name:   "node1",
+			Status: string(egress.EgressTunnelReady),
+			Eips:   []egress.Eips{{IPv4: "10.6.1.10", Policies: []egress.Policy{other}}},
+		}}},
+	}
+	claim := &egress.EgressIPClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "c1"},
+		Spec:       egress.EgressIPClaimSpec{EgressGatewayName: egw.Name},
+	}
+	policy := func(name string) *egress.EgressPolicy {
+		return &egress.EgressPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: egress.EgressPolicySpec{
+				EgressGatewayName: egw.Name,
+				EgressIP:          egress.EgressIP{ClaimName: claim.Name},
+			},
+		}
+	}
+	p1, p2 := policy("p1"), policy("p2")
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(egw, claim, p1, p2).
+		WithStatusSubresource(egw, claim, p1, p2).
+		Build()
+	r := egnReconciler{client: cli, log: logr.Discard()}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: claim.Name}}
+
+	// the policies are skipped until the EIP of the claim is allocated
+	_, _, err := r.specEIP(ctx, p1.Spec.EgressIP, p1.Spec.EgressGatewayName)
+	assert.True(t, isClaimNotReady(err))
+
+	_, err = r.reconcileClaim(ctx, req, logr.Discard())
+	assert.NoError(t, err)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(claim), claim))
+	assert.Equal(t, egress.Eip{Ipv4: "10.6.1.11"}, claim.Status.Eip)
+	assert.Equal(t, 2, claim.Status.PolicyCount)
+	assert.Equal(t, []egress.Policy{{Name: "p1", Namespace: "default"}, {Name: "p2", Namespace: "default"}}, claim.Status.Policies)
+	assert.True(t, meta.IsStatusConditionTrue(claim.Status.Conditions, egress.ClaimConditionReady))
+	assert.Contains(t, claim.Finalizers, egress.FinalizerClaimInUse)
+
+	ipv4, _, err := r.specEIP(ctx, p1.Spec.EgressIP, p1.Spec.EgressGatewayName)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.6.1.11", ipv4)
+
+	// both policies share the EIP of the claim
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(egw), egw))
+	eip := GetEipByIPV4("10.6.1.11", *egw)
+	assert.ElementsMatch(t, []egress.Policy{{Name: "p1", Namespace: "default"}, {Name: "p2", Namespace: "default"}}, eip.Policies)
+
+	// the EIP of the claim is not allocated to the other policies
+	ipv4, _, err = r.allocatorEIP("", "node1", policyInfo{policy: egress.Policy{Name: "p3"}}, *egw)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.6.1.12", ipv4)
+
+	// the claim is kept until no policy references it
+	assert.NoError(t, cli.Delete(ctx, claim))
+	_, err = r.reconcileClaim(ctx, req, logr.Discard())
+	assert.NoError(t, err)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(claim), claim))
+
+	assert.NoError(t, cli.Delete(ctx, p1))
+	assert.NoError(t, cli.Delete(ctx, p2))
+	_, err = r.reconcileClaim(ctx, req, logr.Discard())
+	assert.NoError(t, err)
+	assert.True(t, apierr.IsNotFound(cli.Get(ctx, client.ObjectKeyFromObject(claim), claim)))
+}
+
+func TestReconcileClaimRequestedIP(t *testing.T) {
+	ctx := context.Background()
+	egw := &egress.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "egw1"},
+		Spec:       egress.EgressGatewaySpec{Ippools: egress.Ippools{IPv4: []string{"10.6.1.10-10.6.1.12"}}},
+		Status: egress.EgressGatewayStatus{NodeList: []egress.EgressIPStatus{{
+			Name:   "node1",
+			Status: string(egress.EgressTunnelReady),
+			Eips:   []egress.Eips{{IPv4: "10.6.1.10", Policies: []egress.Policy{{Name: "other"}}}},
+		}}},
+	}
+	claim := func(name, ipv4 string) *egress.EgressIPClaim {
+		return &egress.EgressIPClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       egress.EgressIPClaimSpec{EgressGatewayName: egw.Name, IPv4: ipv4},
+		}
+	}
+	c1, c2 := claim("c1", "10.6.1.12"), claim("c2", "10.6.1.10")
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(egw, c1, c2).
+		WithStatusSubresource(egw, c1, c2).
+		Build()
+	r := egnReconciler{client: cli, log: logr.Discard()}
+
+	_, err := r.reconcileClaim(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: c1.Name}}, logr.Discard())
+	assert.NoError(t, err)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(c1), c1))
+	assert.Equal(t, egress.Eip{Ipv4: "10.6.1.12"}, c1.Status.Eip)
+
+	// the requested EIP is used by another policy
+	_, err = r.reconcileClaim(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: c2.Name}}, logr.Discard())
+	assert.NoError(t, err)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(c2), c2))
+	assert.Equal(t, egress.Eip{}, c2.Status.Eip)
+	cond := meta.FindStatusCondition(c2.Status.Conditions, egress.ClaimConditionReady)
+	assert.Equal(t, metav1.ConditionFalse, cond.Status)
+	assert.Equal(t, "AllocationFailed", cond.Reason)
+}
@@ -0,0 +1,179 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"net"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/constant"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+	"github.com/spidernet-io/egressgateway/pkg/utils/ip"
+)
+
+// gatewayIPs returns the IPs of the ippools of the gateway
+func gatewayIPs(egw *egress.EgressGateway) ([]net.IP, error) {
+	res := make([]net.IP, 0)
+	for version, pool := range map[constant.IPVersion][]string{
+		constant.IPv4: egw.Spec.Ippools.IPv4,
+		constant.IPv6: egw.Spec.Ippools.IPv6,
+	} {
+		if len(pool) == 0 {
+			continue
+		}
+		ranges, err := ip.MergeIPRanges(version, pool)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := ip.ParseIPRanges(version, ranges)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, ips...)
+	}
+	return res, nil
+}
+
+// claimedEarlier returns whether the gateway a claims its IPs before the gateway b
+func claimedEarlier(a, b *egress.EgressGateway) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return a.Name < b.Name
+}
+
+// quarantinedIPs returns the sorted IPs of the ippools of the gateway which are also in the
+// ippools of an earlier gateway, or are allocated by an earlier gateway. The earlier
+// claimant keeps the IPs, so an IP is never announced by the nodes of two gateways.
+func quarantinedIPs(egw *egress.EgressGateway, list []egress.EgressGateway) ([]string, error) {
+	own, err := gatewayIPs(egw)
+	if err != nil {
+		return nil, err
+	}
+	ownSet := make(map[string]struct{}, len(own))
+	for _, item := range own {
+		ownSet[item.String()] = struct{}{}
+	}
+
+	conflicts := make(map[string]struct{})
+	check := func(s string) {
+		if parsed := net.ParseIP(s); parsed != nil {
+			if _, ok := ownSet[parsed.String()]; ok {
+				conflicts[parsed.String()] = struct{}{}
+			}
+		}
+	}
+	for i := range list {
+		other := &list[i]
+		if other.Name == egw.Name || egress.IsImported(other.Labels) || !claimedEarlier(other, egw) {
+			continue
+		}
+		ips, err := gatewayIPs(other)
+		if err != nil {
+			continue
+		}
+		for _, item := range ips {
+			check(item.String())
+		}
+		for _, node := range other.Status.NodeList {
+			for _, eip := range node.Eips {
+				check(eip.IPv4)
+				check(eip.IPv6)
+			}
+		}
+	}
+
+	res := make([]string, 0, len(conflicts))
+	for item := range conflicts {
+		res = append(res, item)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// isQuarantined returns whether the IP is quarantined in the gateway
+func isQuarantined(egw *egress.EgressGateway, s string) bool {
+	if s == "" {
+		return false
+	}
+	parsed := net.ParseIP(s)
+	for _, item := range egw.Status.QuarantinedIPs {
+		if parsed != nil && parsed.Equal(net.ParseIP(item)) {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueueOtherGateways enqueues the other gateways, whose quarantined IPs may change
+func enqueueOtherGateways(cli client.Client) handler.MapFunc {
+	toReq := utils.KindToMapFlat("EgressGateway")
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		list := new(egress.EgressGatewayList)
+		if err := cli.List(ctx, list); err != nil {
+			return nil
+		}
+		res := make([]reconcile.Request, 0)
+		for i := range list.Items {
+			if list.Items[i].Name != obj.GetName() {
+				res = append(res, toReq(ctx, &list.Items[i])...)
+			}
+		}
+		return res
+	}
+}
+
+// quarantineConflictIPs updates the quarantined IPs of the gateway, releases the EIPs
+// which are quarantined from the node map, and reallocates the policies using them.
+// It returns whether the status of the gateway is changed.
+func (r egnReconciler) quarantineConflictIPs(ctx context.Context, log logr.Logger, egw *egress.EgressGateway, nodeMap map[string]egress.EgressIPStatus) (bool, error) {
+	list := new(egress.EgressGatewayList)
+	if err := r.client.List(ctx, list); err != nil {
+		return false, err
+	}
+	ips, err := quarantinedIPs(egw, list.Items)
+	if err != nil {
+		return false, err
+	}
+
+	changed := len(ips) != len(egw.Status.QuarantinedIPs)
+	for i := 0; !changed && i < len(ips); i++ {
+		changed = ips[i] != egw.Status.QuarantinedIPs[i]
+	}
+	if changed {
+		log.Info("update quarantined IPs", "ips", ips)
+	}
+	egw.Status.QuarantinedIPs = ips
+	if len(ips) == 0 {
+		return changed, nil
+	}
+
+	policies := make([]egress.Policy, 0)
+	for name, node := range nodeMap {
+		eips := make([]egress.Eips, 0, len(node.Eips))
+		for _, eip := range node.Eips {
+			if isQuarantined(egw, eip.IPv4) || isQuarantined(egw, eip.IPv6) {
+				policies = append(policies, eip.Policies...)
+				continue
+			}
+			eips = append(eips, eip)
+		}
+		node.Eips = eips
+		nodeMap[name] = node
+	}
+	for _, policy := range policies {
+		log.Info("reallocate the policy using a quarantined IP", "policy", policy)
+		if err := r.reAllocatorPolicy(ctx, log, policy, egw, nodeMap); err != nil {
+			return true, err
+		}
+	}
+	return changed || len(policies) > 0, nil
+}
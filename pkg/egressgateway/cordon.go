@@ -0,0 +1,172 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// cordonTracker records when the gateway nodes are found cordoned. The time a node is
+// cordoned is not recorded by the Node, so the grace period starts when the controller
+// finds it, and it starts again after the controller restarts.
+type cordonTracker struct {
+	lock  sync.RWMutex
+	since map[string]time.Time
+}
+
+func newCordonTracker() *cordonTracker {
+	return &cordonTracker{since: make(map[string]time.Time)}
+}
+
+// observe records whether the node is cordoned, and returns the time it is found cordoned
+func (t *cordonTracker) observe(name string, cordoned bool, now time.Time) time.Time {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !cordoned {
+		delete(t.since, name)
+		return time.Time{}
+	}
+	since, ok := t.since[name]
+	if !ok {
+		since = now
+		t.since[name] = since
+	}
+	return since
+}
+
+func (t *cordonTracker) get(name string) (time.Time, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	since, ok := t.since[name]
+	return since, ok
+}
+
+// isNodeCordoned returns true if the node is unschedulable, or it has any of the taints
+// added when the node is drained
+func isNodeCordoned(node *corev1.Node, taints []string) bool {
+	if node.Spec.Unschedulable {
+		return true
+	}
+	for _, taint := range node.Spec.Taints {
+		for _, key := range taints {
+			if taint.Key == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r egnReconciler) cordonEnabled() bool {
+	return r.cordon != nil && r.config != nil && r.config.FileConfig.GatewayFailover.Cordon.Enable
+}
+
+func (r egnReconciler) cordonGracePeriod() time.Duration {
+	return time.Second * time.Duration(r.config.FileConfig.GatewayFailover.Cordon.GracePeriod)
+}
+
+// isCordoned returns true if the node is cordoned, no new EIP is placed on it
+func (r egnReconciler) isCordoned(name string) bool {
+	if !r.cordonEnabled() {
+		return false
+	}
+	_, ok := r.cordon.get(name)
+	return ok
+}
+
+// nodeStatus returns the status of the gateway node by the phase of its tunnel, the
+// node which has been cordoned longer than the grace period is Cordoned
+func (r egnReconciler) nodeStatus(name string, phase egress.EgressTunnelPhase) string {
+	if phase != egress.EgressTunnelReady || !r.cordonEnabled() {
+		return string(phase)
+	}
+	since, ok := r.cordon.get(name)
+	if ok && time.Since(since) >= r.cordonGracePeriod() {
+		return egress.NodeStatusCordoned
+	}
+	return string(phase)
+}
+
+// observeCordon records whether the node is cordoned, and returns the remaining grace
+// period of the cordoned node
+func (r egnReconciler) observeCordon(node *corev1.Node) time.Duration {
+	if !r.cordonEnabled() {
+		return 0
+	}
+	cordoned := isNodeCordoned(node, r.config.FileConfig.GatewayFailover.Cordon.Taints)
+	since := r.cordon.observe(node.Name, cordoned, time.Now())
+	if !cordoned {
+		return 0
+	}
+	remaining := r.cordonGracePeriod() - time.Since(since)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// syncNodeStatus updates the status of the node in the gateway when the node is cordoned
+// or uncordoned, the policies on the node are reassigned once it's not Ready
+func (r egnReconciler) syncNodeStatus(ctx context.Context, log logr.Logger, nodeName string, egw egress.EgressGateway) error {
+	phase := egress.EgressTunnelFailed
+	egt := new(egress.EgressTunnel)
+	if err := r.client.Get(ctx, types.NamespacedName{Name: nodeName}, egt); err == nil {
+		phase = egt.Status.Phase
+	}
+	status := r.nodeStatus(nodeName, phase)
+
+	perNodeMap := make(map[string]egress.EgressIPStatus)
+	for _, node := range egw.Status.NodeList {
+		perNodeMap[node.Name] = node
+	}
+	current, ok := perNodeMap[nodeName]
+	if !ok || current.Status == status {
+		return nil
+	}
+	current.Status = status
+	perNodeMap[nodeName] = current
+
+	if status != string(egress.EgressTunnelReady) {
+		perNodeMap[nodeName] = egress.EgressIPStatus{Name: nodeName, Status: status}
+		policies, _ := GetPoliciesByNode(nodeName, egw)
+		for _, policy := range policies {
+			if err := r.reAllocatorPolicy(ctx, log, policy, &egw, perNodeMap); err != nil {
+				log.Error(err, "failed to reassign a gateway node for EgressPolicy", "policy", policy)
+				return err
+			}
+		}
+	}
+
+	var perNodeList []egress.EgressIPStatus
+	for _, node := range perNodeMap {
+		perNodeList = append(perNodeList, node)
+	}
+	egw.Status.NodeList = perNodeList
+
+	ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal, err := countGatewayIP(&egw)
+	if err != nil {
+		log.Error(err, "count egress gateway ippools", "nodeList", egw.Status.NodeList)
+		return err
+	}
+	egw.Status.IPUsage.IPv4Free = ipv4sFree
+	egw.Status.IPUsage.IPv4Total = ipv4sTotal
+	egw.Status.IPUsage.IPv6Free = ipv6sFree
+	egw.Status.IPUsage.IPv6Total = ipv6sTotal
+	setGatewayConditions(&egw)
+
+	log.Info("update the status of the gateway node", "egressGateway", egw.Name, "node", nodeName, "status", status)
+	if err := r.updateGatewayStatus(ctx, &egw); err != nil {
+		log.Error(err, "update egress gateway status", "status", egw.Status)
+		return err
+	}
+	return nil
+}
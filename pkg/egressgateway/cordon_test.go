@@ -0,0 +1,129 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestIsNodeCordoned(t *testing.T) {
+	taints := []string{"node.kubernetes.io/unschedulable", "ToBeDeletedByClusterAutoscaler"}
+
+	node := &corev1.Node{}
+	assert.False(t, isNodeCordoned(node, taints))
+
+	node.Spec.Unschedulable = true
+	assert.True(t, isNodeCordoned(node, taints))
+
+	node.Spec.Unschedulable = false
+	node.Spec.Taints = []corev1.Taint{{Key: "ToBeDeletedByClusterAutoscaler", Effect: corev1.TaintEffectNoSchedule}}
+	assert.True(t, isNodeCordoned(node, taints))
+	assert.False(t, isNodeCordoned(node, nil))
+}
+
+func TestReconcileCordonedNode(t *testing.T) {
+	ctx := context.Background()
+	policy := egress.Policy{Name: "p1", Namespace: "default"}
+	labels := map[string]string{"egress": "true"}
+	node := func(name string, unschedulable bool) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			Spec:       corev1.NodeSpec{Unschedulable: unschedulable},
+		}
+	}
+	tunnel := func(name string) *egress.EgressTunnel {
+		return &egress.EgressTunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     egress.EgressTunnelStatus{Phase: egress.EgressTunnelReady},
+		}
+	}
+	egw := &egress.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "egw"},
+		Spec: egress.EgressGatewaySpec{
+			Ippools:      egress.Ippools{IPv4: []string{"10.6.1.10-10.6.1.12"}},
+			NodeSelector: egress.NodeSelector{Selector: &metav1.LabelSelector{MatchLabels: labels}},
+		},
+		Status: egress.EgressGatewayStatus{NodeList: []egress.EgressIPStatus{
+			{
+				Name:   "node-a",
+				Status: string(egress.EgressTunnelReady),
+				Eips:   []egress.Eips{{IPv4: "10.6.1.10", Policies: []egress.Policy{policy}}},
+			},
+			{Name: "node-b", Status: string(egress.EgressTunnelReady)},
+		}},
+	}
+	egp := &egress.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: policy.Name, Namespace: policy.Namespace},
+		Spec:       egress.EgressPolicySpec{EgressGatewayName: egw.Name},
+		Status: egress.EgressPolicyStatus{
+			Eip:  egress.Eip{Ipv4: "10.6.1.10"},
+			Node: "node-a",
+		},
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(node("node-a", true), node("node-b", false), tunnel("node-a"), tunnel("node-b"), egw, egp).
+		WithStatusSubresource(egw, egp).
+		Build()
+
+	cfg := &config.Config{}
+	cfg.FileConfig.GatewayFailover.Cordon = config.Cordon{Enable: true, GracePeriod: 60}
+	r := egnReconciler{client: cli, log: logr.Discard(), config: cfg, cordon: newCordonTracker()}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "node-a"}}
+
+	// the EIP stays on the node during the grace period, but no new EIP is placed on it
+	res, err := r.reconcileNode(ctx, req, logr.Discard())
+	assert.NoError(t, err)
+	assert.True(t, res.RequeueAfter > 0 && res.RequeueAfter <= time.Minute)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(egw), egw))
+	status, _ := GetEIPStatusByPolicy(policy, *egw)
+	assert.Equal(t, "node-a", status.Name)
+
+	nodeMap := map[string]egress.EgressIPStatus{
+		"node-a": {Name: "node-a", Status: string(egress.EgressTunnelReady)},
+		"node-b": {Name: "node-b", Status: string(egress.EgressTunnelReady), Eips: egw.Status.NodeList[0].Eips},
+	}
+	selected, err := r.allocatorNode("rr", nodeMap)
+	assert.NoError(t, err)
+	assert.Equal(t, "node-b", selected)
+
+	// the EIP is moved once the grace period is over
+	r.cordon.since["node-a"] = time.Now().Add(-time.Minute)
+	res, err = r.reconcileNode(ctx, req, logr.Discard())
+	assert.NoError(t, err)
+	assert.Zero(t, res.RequeueAfter)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(egw), egw))
+	status, _ = GetEIPStatusByPolicy(policy, *egw)
+	assert.Equal(t, "node-b", status.Name)
+	for _, item := range egw.Status.NodeList {
+		if item.Name == "node-a" {
+			assert.Equal(t, egress.NodeStatusCordoned, item.Status)
+			assert.Empty(t, item.Eips)
+		}
+	}
+
+	// the node is Ready again once it's uncordoned
+	assert.NoError(t, cli.Update(ctx, node("node-a", false)))
+	_, err = r.reconcileNode(ctx, req, logr.Discard())
+	assert.NoError(t, err)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(egw), egw))
+	for _, item := range egw.Status.NodeList {
+		assert.Equal(t, string(egress.EgressTunnelReady), item.Status)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils/ip"
+)
+
+// syncDestinationEIPs places the destination EIPs of the policies on the gateway nodes of
+// the policies. The EIPs out of the ippools, used as the EIPs of the policies or by another
+// destination group are skipped.
+func (r egnReconciler) syncDestinationEIPs(ctx context.Context, egw *egress.EgressGateway) error {
+	used := make(map[string]bool)
+	for _, node := range egw.Status.NodeList {
+		for _, eip := range node.Eips {
+			used[eip.IPv4] = true
+			used[eip.IPv6] = true
+		}
+	}
+	for _, item := range egw.Status.QuarantinedIPs {
+		used[item] = true
+	}
+	ippools := append(append([]string{}, egw.Spec.Ippools.IPv4...), egw.Spec.Ippools.IPv6...)
+	available := func(policy egress.Policy, group, addr string) bool {
+		if len(addr) == 0 {
+			return false
+		}
+		if used[addr] {
+			r.log.Info("skip the destination EIP, it is used by another policy or destination group",
+				"egressGateway", egw.Name, "policy", policy, "destination", group, "ip", addr)
+			return false
+		}
+		if ok, _ := ip.CheckIPIncluded(addr, ippools); !ok {
+			r.log.Info("skip the destination EIP, it is not within the ippools",
+				"egressGateway", egw.Name, "policy", policy, "destination", group, "ip", addr)
+			return false
+		}
+		used[addr] = true
+		return true
+	}
+
+	for i, node := range egw.Status.NodeList {
+		var list []egress.DestinationEips
+		for _, eip := range node.Eips {
+			for _, policy := range eip.Policies {
+				groups, err := r.destinationEIPs(ctx, policy)
+				if err != nil {
+					return err
+				}
+				for _, group := range groups {
+					item := egress.DestinationEips{Policy: policy, Destination: group.Name}
+					if available(policy, group.Name, group.IPv4) {
+						item.IPv4 = group.IPv4
+					}
+					if available(policy, group.Name, group.IPv6) {
+						item.IPv6 = group.IPv6
+					}
+					if len(item.IPv4) == 0 && len(item.IPv6) == 0 {
+						continue
+					}
+					list = append(list, item)
+				}
+			}
+		}
+		egw.Status.NodeList[i].DestinationEips = list
+	}
+	return nil
+}
+
+// destinationEIPs returns the destination groups in the spec of the policy
+func (r egnReconciler) destinationEIPs(ctx context.Context, policy egress.Policy) ([]egress.DestinationEIP, error) {
+	key := types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name}
+	if len(policy.Namespace) == 0 {
+		egcp := new(egress.EgressClusterPolicy)
+		if err := r.client.Get(ctx, key, egcp); err != nil {
+			if errors.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return egcp.Spec.DestinationEIPs, nil
+	}
+	egp := new(egress.EgressPolicy)
+	if err := r.client.Get(ctx, key, egp); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return egp.Spec.DestinationEIPs, nil
+}
+
+// destinationIPs returns the IPs of the destination EIPs of the gateway
+func destinationIPs(egw *egress.EgressGateway) []string {
+	res := make([]string, 0)
+	for _, node := range egw.Status.NodeList {
+		for _, item := range node.DestinationEips {
+			if len(item.IPv4) != 0 {
+				res = append(res, item.IPv4)
+			}
+			if len(item.IPv6) != 0 {
+				res = append(res, item.IPv6)
+			}
+		}
+	}
+	return res
+}
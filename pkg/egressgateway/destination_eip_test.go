@@ -0,0 +1,71 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestSyncDestinationEIPs(t *testing.T) {
+	ctx := context.Background()
+	p1 := &egress.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "p1", Namespace: "default"},
+		Spec: egress.EgressPolicySpec{
+			EgressGatewayName: "egw",
+			DestinationEIPs: []egress.DestinationEIP{
+				{Name: "partner-a", DestSubnet: []string{"1.1.1.0/24"}, IPv4: "10.6.1.22"},
+				// used as the EIP of a policy
+				{Name: "partner-b", DestSubnet: []string{"2.2.2.0/24"}, IPv4: "10.6.1.21"},
+				// out of the ippools
+				{Name: "partner-c", DestSubnet: []string{"3.3.3.0/24"}, IPv4: "10.6.2.22"},
+			},
+		},
+	}
+	p2 := &egress.EgressClusterPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "p2"},
+		Spec: egress.EgressClusterPolicySpec{
+			EgressGatewayName: "egw",
+			DestinationEIPs: []egress.DestinationEIP{
+				// used by the group of p1
+				{Name: "partner-a", DestSubnet: []string{"1.1.1.0/24"}, IPv4: "10.6.1.22"},
+				{Name: "partner-d", DestSubnet: []string{"4.4.4.0/24"}, IPv4: "10.6.1.23"},
+			},
+		},
+	}
+	egw := &egress.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "egw"},
+		Spec: egress.EgressGatewaySpec{
+			Ippools: egress.Ippools{IPv4: []string{"10.6.1.21-10.6.1.30"}},
+		},
+		Status: egress.EgressGatewayStatus{NodeList: []egress.EgressIPStatus{
+			{Name: "node1", Eips: []egress.Eips{{IPv4: "10.6.1.21", Policies: []egress.Policy{{Name: "p1", Namespace: "default"}}}}},
+			{Name: "node2", Eips: []egress.Eips{{IPv4: "10.6.1.24", Policies: []egress.Policy{{Name: "p2"}}}}},
+		}},
+	}
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(p1, p2).Build()
+	r := egnReconciler{client: cli, log: logr.Discard()}
+
+	assert.NoError(t, r.syncDestinationEIPs(ctx, egw))
+	assert.Equal(t, []egress.DestinationEips{
+		{Policy: egress.Policy{Name: "p1", Namespace: "default"}, Destination: "partner-a", IPv4: "10.6.1.22"},
+	}, egw.Status.GetNodeDestinationIPs("node1"))
+	assert.Equal(t, []egress.DestinationEips{
+		{Policy: egress.Policy{Name: "p2"}, Destination: "partner-d", IPv4: "10.6.1.23"},
+	}, egw.Status.GetNodeDestinationIPs("node2"))
+	assert.ElementsMatch(t, []string{"10.6.1.22", "10.6.1.23"}, destinationIPs(egw))
+
+	ipv4Free, _, ipv4Total, _, err := countGatewayIP(egw)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, ipv4Total)
+	assert.Equal(t, 6, ipv4Free)
+}
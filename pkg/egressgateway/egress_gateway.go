@@ -6,13 +6,14 @@ package egressgateway
 import (
 	"context"
 	"fmt"
-	"math/rand"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
@@ -20,12 +21,14 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/spidernet-io/egressgateway/pkg/config"
 	"github.com/spidernet-io/egressgateway/pkg/constant"
 	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/queue"
 	"github.com/spidernet-io/egressgateway/pkg/utils"
 	"github.com/spidernet-io/egressgateway/pkg/utils/ip"
 	"github.com/spidernet-io/egressgateway/pkg/utils/slice"
@@ -35,6 +38,9 @@ type egnReconciler struct {
 	client client.Client
 	log    logr.Logger
 	config *config.Config
+	cordon *cordonTracker
+	flap   *flapTracker
+	hooks  *eipHooks
 }
 
 type policyInfo struct {
@@ -45,6 +51,7 @@ type policyInfo struct {
 	policy          egress.Policy
 	isUseNodeIP     bool
 	allocatorPolicy string
+	schedule        *egress.PolicySchedule
 }
 
 func (r egnReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
@@ -66,6 +73,8 @@ func (r egnReconciler) Reconcile(ctx context.Context, req reconcile.Request) (re
 		return r.reconcileNode(ctx, newReq, log)
 	case "EgressTunnel":
 		return r.reconcileEGT(ctx, newReq, log)
+	case "EgressIPClaim":
+		return r.reconcileClaim(ctx, newReq, log)
 	default:
 		return reconcile.Result{}, nil
 	}
@@ -92,6 +101,12 @@ func (r egnReconciler) reconcileNode(ctx context.Context, req reconcile.Request,
 	// Node NoReady event, complete in reconcile EgressTunnel event
 	if deleted {
 		r.log.Info("request item is deleted")
+		if r.cordon != nil {
+			r.cordon.observe(req.Name, false, time.Now())
+		}
+		if r.flap != nil {
+			r.flap.forget(req.Name)
+		}
 		err := r.deleteNodeFromEGs(ctx, log, req.Name, egwList)
 		if err != nil {
 			return reconcile.Result{Requeue: true}, nil
@@ -100,8 +115,19 @@ func (r egnReconciler) reconcileNode(ctx context.Context, req reconcile.Request,
 		return reconcile.Result{}, nil
 	}
 
+	// The cordoned node is checked again when its grace period is over
+	remaining := r.observeCordon(node)
+	// The damped node is checked again when its hold down is over
+	if hold := r.holdDownRemaining(node.Name); hold > 0 && (remaining == 0 || hold < remaining) {
+		remaining = hold
+	}
+	released := r.releaseDamped(node.Name)
+
 	// Checking the node label
 	for _, egw := range egwList.Items {
+		if egress.IsImported(egw.Labels) {
+			continue
+		}
 		selNode, err := metav1.LabelSelectorAsSelector(egw.Spec.NodeSelector.Selector)
 		if err != nil {
 			return reconcile.Result{Requeue: true}, nil
@@ -114,7 +140,7 @@ func (r egnReconciler) reconcileNode(ctx context.Context, req reconcile.Request,
 				egt := new(egress.EgressTunnel)
 				err := r.client.Get(ctx, types.NamespacedName{Name: node.Name}, egt)
 				if err == nil {
-					egw.Status.NodeList = append(egw.Status.NodeList, egress.EgressIPStatus{Name: node.Name, Status: string(egt.Status.Phase)})
+					egw.Status.NodeList = append(egw.Status.NodeList, egress.EgressIPStatus{Name: node.Name, Status: r.nodeStatus(node.Name, egt.Status.Phase)})
 				} else {
 					egw.Status.NodeList = append(egw.Status.NodeList, egress.EgressIPStatus{Name: node.Name, Status: string(egress.EgressTunnelFailed)})
 				}
@@ -128,13 +154,26 @@ func (r egnReconciler) reconcileNode(ctx context.Context, req reconcile.Request,
 				egw.Status.IPUsage.IPv4Total = ipv4sTotal
 				egw.Status.IPUsage.IPv6Free = ipv6sFree
 				egw.Status.IPUsage.IPv6Total = ipv6sTotal
+				setGatewayConditions(&egw)
 
 				r.log.V(1).Info("update egress gateway status", "status", egw.Status)
-				err = r.client.Status().Update(ctx, &egw)
+				err = r.updateGatewayStatus(ctx, &egw)
 				if err != nil {
 					r.log.Error(err, "update egress gateway status", "status", egw.Status)
 					return reconcile.Result{Requeue: true}, nil
 				}
+			} else {
+				if r.cordonEnabled() {
+					if err := r.syncNodeStatus(ctx, log, node.Name, egw); err != nil {
+						return reconcile.Result{Requeue: true}, nil
+					}
+				}
+				// the policies left unassigned are assigned once the node converged
+				if (r.datapathReadyEnabled() && nodeDatapathReady(node)) || released {
+					if err := r.assignPendingPolicies(ctx, log, egw.Name); err != nil {
+						return reconcile.Result{Requeue: true}, nil
+					}
+				}
 			}
 		} else {
 			// Labels do not match. If there is a node in status, delete the node from status and reallocate the policy
@@ -148,6 +187,9 @@ func (r egnReconciler) reconcileNode(ctx context.Context, req reconcile.Request,
 		}
 	}
 
+	if remaining > 0 {
+		return reconcile.Result{RequeueAfter: remaining}, nil
+	}
 	return reconcile.Result{}, nil
 }
 
@@ -165,6 +207,11 @@ func (r egnReconciler) reconcileEGW(ctx context.Context, req reconcile.Request,
 	}
 	deleted = deleted || !egw.GetDeletionTimestamp().IsZero()
 
+	// the imported EgressGateways are managed by the multi-cluster broker
+	if !deleted && egress.IsImported(egw.Labels) {
+		return reconcile.Result{}, nil
+	}
+
 	if deleted {
 		log.Info("request item is deleted")
 		p, err := getEgressGatewayPolicies(r.client, ctx, egw)
@@ -232,7 +279,7 @@ func (r egnReconciler) reconcileEGW(ctx context.Context, req reconcile.Request,
 			egt := new(egress.EgressTunnel)
 			err := r.client.Get(ctx, types.NamespacedName{Name: node.Name}, egt)
 			if err == nil {
-				perNodeMap[node.Name] = egress.EgressIPStatus{Name: node.Name, Status: string(egt.Status.Phase)}
+				perNodeMap[node.Name] = egress.EgressIPStatus{Name: node.Name, Status: r.nodeStatus(node.Name, egt.Status.Phase)}
 			} else {
 				perNodeMap[node.Name] = egress.EgressIPStatus{Name: node.Name, Status: string(egress.EgressTunnelFailed)}
 			}
@@ -314,6 +361,15 @@ func (r egnReconciler) reconcileEGW(ctx context.Context, req reconcile.Request,
 		isUpdate = true
 	}
 
+	quarantineChanged, err := r.quarantineConflictIPs(ctx, log, egw, perNodeMap)
+	if err != nil {
+		log.Error(err, "failed to quarantine the conflict IPs")
+		return reconcile.Result{Requeue: true}, err
+	}
+	isUpdate = isUpdate || quarantineChanged
+	// the standby EIPs are assigned again by the nodes updated by the status update
+	isUpdate = r.assignStandby(egw) || isUpdate
+
 	if isUpdate {
 		var perNodeList []egress.EgressIPStatus
 		for _, node := range perNodeMap {
@@ -330,9 +386,10 @@ func (r egnReconciler) reconcileEGW(ctx context.Context, req reconcile.Request,
 		egw.Status.IPUsage.IPv4Total = ipv4sTotal
 		egw.Status.IPUsage.IPv6Free = ipv6sFree
 		egw.Status.IPUsage.IPv6Total = ipv6sTotal
+		setGatewayConditions(egw)
 
 		log.V(1).Info("update egress gateway status", "status", egw.Status)
-		err = r.client.Status().Update(ctx, egw)
+		err = r.updateGatewayStatus(ctx, egw)
 		if err != nil {
 			log.Error(err, "update egress gateway status", "status", egw.Status)
 			return reconcile.Result{Requeue: true}, err
@@ -368,18 +425,22 @@ func (r egnReconciler) reconcileEGT(ctx context.Context, req reconcile.Request,
 	}
 
 	for _, item := range egwList.Items {
+		if egress.IsImported(item.Labels) {
+			continue
+		}
 		policies, isExist := GetPoliciesByNode(egt.Name, item)
 		if isExist {
 			perNodeMap := make(map[string]egress.EgressIPStatus)
 			egw := item.DeepCopy()
+			status := r.nodeStatus(egt.Name, egt.Status.Phase)
 
 			// If the node is not in success state, the policy on the node is reassigned
-			if egt.Status.Phase != egress.EgressTunnelReady {
+			if status != string(egress.EgressTunnelReady) {
 				for _, node := range egw.Status.NodeList {
 					if node.Name != egt.Name {
 						perNodeMap[node.Name] = node
 					} else {
-						perNodeMap[node.Name] = egress.EgressIPStatus{Name: node.Name, Status: string(egt.Status.Phase)}
+						perNodeMap[node.Name] = egress.EgressIPStatus{Name: node.Name, Status: status}
 					}
 				}
 
@@ -465,9 +526,10 @@ func (r egnReconciler) reconcileEGT(ctx context.Context, req reconcile.Request,
 			egw.Status.IPUsage.IPv4Total = ipv4sTotal
 			egw.Status.IPUsage.IPv6Free = ipv6sFree
 			egw.Status.IPUsage.IPv6Total = ipv6sTotal
+			setGatewayConditions(egw)
 
 			log.V(1).Info("update egress gateway status", "status", egw.Status)
-			err = r.client.Status().Update(ctx, egw)
+			err = r.updateGatewayStatus(ctx, egw)
 			if err != nil {
 				log.Error(err, "update egress gateway status", "status", egw.Status)
 				return reconcile.Result{Requeue: true}, err
@@ -505,20 +567,30 @@ func (r egnReconciler) reconcileEGP(ctx context.Context, req reconcile.Request,
 		deleted = deleted || !egcp.GetDeletionTimestamp().IsZero()
 		pi.policy = egress.Policy{Name: req.Name}
 		if !deleted {
-			if len(egcp.Spec.EgressIP.IPv4) != 0 {
-				pi.ipv4 = egcp.Spec.EgressIP.IPv4
+			specIPv4, specIPv6, err := r.specEIP(ctx, egcp.Spec.EgressIP, egcp.Spec.EgressGatewayName)
+			if err != nil {
+				if isClaimNotReady(err) {
+					// the policy is assigned once the EIP of the claim is allocated
+					log.Info("skip the policy", "reason", err.Error())
+					return reconcile.Result{}, nil
+				}
+				return reconcile.Result{Requeue: true}, err
+			}
+			if len(specIPv4) != 0 {
+				pi.ipv4 = specIPv4
 			} else {
 				pi.ipv4 = egcp.Status.Eip.Ipv4
 			}
 
-			if len(egcp.Spec.EgressIP.IPv6) != 0 {
-				pi.ipv6 = egcp.Spec.EgressIP.IPv6
+			if len(specIPv6) != 0 {
+				pi.ipv6 = specIPv6
 			} else {
 				pi.ipv6 = egcp.Status.Eip.Ipv6
 			}
 
 			pi.isUseNodeIP = egcp.Spec.EgressIP.UseNodeIP
 			pi.egw = egcp.Spec.EgressGatewayName
+			pi.schedule = egcp.Spec.Schedule
 		}
 	} else {
 		err := r.client.Get(ctx, req.NamespacedName, egp)
@@ -532,62 +604,80 @@ func (r egnReconciler) reconcileEGP(ctx context.Context, req reconcile.Request,
 		deleted = deleted || !egp.GetDeletionTimestamp().IsZero()
 		pi.policy = egress.Policy{Name: req.Name, Namespace: req.Namespace}
 		if !deleted {
-			if len(egp.Spec.EgressIP.IPv4) != 0 {
-				pi.ipv4 = egp.Spec.EgressIP.IPv4
+			specIPv4, specIPv6, err := r.specEIP(ctx, egp.Spec.EgressIP, egp.Spec.EgressGatewayName)
+			if err != nil {
+				if isClaimNotReady(err) {
+					// the policy is assigned once the EIP of the claim is allocated
+					log.Info("skip the policy", "reason", err.Error())
+					return reconcile.Result{}, nil
+				}
+				return reconcile.Result{Requeue: true}, err
+			}
+			if len(specIPv4) != 0 {
+				pi.ipv4 = specIPv4
 			} else {
 				pi.ipv4 = egp.Status.Eip.Ipv4
 			}
 
-			if len(egp.Spec.EgressIP.IPv6) != 0 {
-				pi.ipv6 = egp.Spec.EgressIP.IPv6
+			if len(specIPv6) != 0 {
+				pi.ipv6 = specIPv6
 			} else {
 				pi.ipv6 = egp.Status.Eip.Ipv6
 			}
 
 			pi.isUseNodeIP = egp.Spec.EgressIP.UseNodeIP
 			pi.egw = egp.Spec.EgressGatewayName
+			pi.schedule = egp.Spec.Schedule
 		}
 	}
 
 	policy := pi.policy
 	if deleted {
-		egwList := &egress.EgressGatewayList{}
-		if err := r.client.List(ctx, egwList); err != nil {
-			return reconcile.Result{Requeue: true}, nil
+		if _, err := r.releasePolicy(ctx, log, policy, ""); err != nil {
+			return reconcile.Result{Requeue: true}, err
 		}
-		for _, egw := range egwList.Items {
-			_, isExist := GetEIPStatusByPolicy(policy, egw)
-			if isExist {
-				log.Info("delete policy", "policy", policy, "egw", egw.Name)
-				// Delete the policy from the EgressGateway. If the referenced EIP is not used by any other policy,
-				// the system reclaims the EIP.
-				DeletePolicyFromEG(log, policy, &egw)
+		return reconcile.Result{}, nil
+	}
 
-				ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal, err := countGatewayIP(&egw)
-				if err != nil {
-					r.log.Error(err, "count egress gateway ippools", "nodeList", egw.Status.NodeList)
-					return reconcile.Result{Requeue: true}, nil
-				}
-				egw.Status.IPUsage.IPv4Free = ipv4sFree
-				egw.Status.IPUsage.IPv4Total = ipv4sTotal
-				egw.Status.IPUsage.IPv6Free = ipv6sFree
-				egw.Status.IPUsage.IPv6Total = ipv6sTotal
+	// The policy is released from its gateway node out of its time windows, and the
+	// agents stop programming it then.
+	var obj client.Object = egp
+	status := &egp.Status
+	if len(policy.Namespace) == 0 {
+		obj, status = egcp, &egcp.Status
+	}
+	active, nextCheck, err := r.syncPolicySchedule(ctx, log, policy, obj, pi.schedule, status)
+	if err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+	if !active {
+		return reconcile.Result{RequeueAfter: nextCheck}, nil
+	}
 
-				log.V(1).Info("update egress gateway status", "status", egw.Status)
-				err = r.client.Status().Update(ctx, &egw)
-				if err != nil {
-					log.Error(err, "update egress gateway status", "status", egw.Status)
-					return reconcile.Result{Requeue: true}, err
-				}
-				return reconcile.Result{}, nil
-			}
+	// When the egressGatewayName of the policy is changed, the policy is released from the
+	// previous gateway before it is bound to the new one, so that the agents never see it
+	// on both gateways.
+	released, err := r.releasePolicy(ctx, log, policy, pi.egw)
+	if err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+	if released {
+		if len(policy.Namespace) == 0 {
+			resetPolicyStatus(&egcp.Status)
+			err = r.client.Status().Update(ctx, egcp)
+		} else {
+			resetPolicyStatus(&egp.Status)
+			err = r.client.Status().Update(ctx, egp)
+		}
+		if err != nil {
+			log.Error(err, "reset the status of the rebound policy")
+			return reconcile.Result{Requeue: true}, err
 		}
-		return reconcile.Result{}, nil
 	}
 
 	egwName := pi.egw
 	egw := &egress.EgressGateway{}
-	err := r.client.Get(ctx, types.NamespacedName{Name: egwName}, egw)
+	err = r.client.Get(ctx, types.NamespacedName{Name: egwName}, egw)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			return reconcile.Result{}, err
@@ -595,6 +685,9 @@ func (r egnReconciler) reconcileEGP(ctx context.Context, req reconcile.Request,
 		log.Error(err, "get EgressGateway")
 		return reconcile.Result{Requeue: true}, err
 	}
+	if egress.IsImported(egw.Labels) {
+		return reconcile.Result{}, nil
+	}
 
 	// Assigned if the policy does not have a gateway node
 	eipStatus, isExist := GetEIPStatusByPolicy(policy, *egw)
@@ -660,14 +753,19 @@ func (r egnReconciler) reconcileEGP(ctx context.Context, req reconcile.Request,
 						egw.Status.NodeList = perNodeList
 					} else {
 						// check policy status
-						var policyStatus egress.EgressPolicyStatus
-						policyStatus.Eip.Ipv4 = eip.IPv4
-						policyStatus.Eip.Ipv6 = eip.IPv6
-						policyStatus.Node = eipStatus.Name
+						setPolicyStatus := func(status *egress.EgressPolicyStatus, generation int64) {
+							status.Eip.Ipv4 = eip.IPv4
+							status.Eip.Ipv6 = eip.IPv6
+							status.Node = eipStatus.Name
+							if r.config != nil && r.config.FileConfig.EIPVerification.Enable {
+								status.RequireEIPVerification(generation)
+							}
+							status.SetReadyCondition(generation)
+						}
 
 						if len(policy.Namespace) == 0 {
 							if len(egcp.Status.Node) == 0 {
-								egcp.Status = policyStatus
+								setPolicyStatus(&egcp.Status, egcp.Generation)
 								log.V(1).Info("update egressclusterpolicy status", "status", egcp.Status)
 								err = r.client.Status().Update(ctx, egcp)
 								if err != nil {
@@ -677,7 +775,7 @@ func (r egnReconciler) reconcileEGP(ctx context.Context, req reconcile.Request,
 							}
 						} else {
 							if len(egp.Status.Node) == 0 {
-								egp.Status = policyStatus
+								setPolicyStatus(&egp.Status, egp.Generation)
 								log.V(1).Info("update egresspolicy status", "status", egp.Status)
 								err = r.client.Status().Update(ctx, egp)
 								if err != nil {
@@ -707,18 +805,68 @@ update:
 		egw.Status.IPUsage.IPv4Total = ipv4sTotal
 		egw.Status.IPUsage.IPv6Free = ipv6sFree
 		egw.Status.IPUsage.IPv6Total = ipv6sTotal
+		setGatewayConditions(egw)
 		r.log.V(1).Info("update egress gateway status", "status", egw.Status)
-		err = r.client.Status().Update(ctx, egw)
+		err = r.updateGatewayStatus(ctx, egw)
 		if err != nil {
 			r.log.Error(err, "update egress gateway status", "status", egw.Status)
 			return reconcile.Result{Requeue: true}, err
 		}
 	}
-	return reconcile.Result{}, nil
+	return reconcile.Result{RequeueAfter: nextCheck}, nil
+}
+
+// releasePolicy deletes the policy from the gateways other than keep. If the referenced EIP
+// is not used by any other policy, the system reclaims the EIP.
+func (r egnReconciler) releasePolicy(ctx context.Context, log logr.Logger, policy egress.Policy, keep string) (bool, error) {
+	egwList := &egress.EgressGatewayList{}
+	if err := r.client.List(ctx, egwList); err != nil {
+		return false, err
+	}
+	released := false
+	for _, egw := range egwList.Items {
+		if egw.Name == keep || egress.IsImported(egw.Labels) {
+			continue
+		}
+		if _, isExist := GetEIPStatusByPolicy(policy, egw); !isExist {
+			continue
+		}
+		log.Info("delete policy", "policy", policy, "egw", egw.Name)
+		DeletePolicyFromEG(log, policy, &egw)
+
+		ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal, err := countGatewayIP(&egw)
+		if err != nil {
+			r.log.Error(err, "count egress gateway ippools", "nodeList", egw.Status.NodeList)
+			return false, err
+		}
+		egw.Status.IPUsage.IPv4Free = ipv4sFree
+		egw.Status.IPUsage.IPv4Total = ipv4sTotal
+		egw.Status.IPUsage.IPv6Free = ipv6sFree
+		egw.Status.IPUsage.IPv6Total = ipv6sTotal
+		setGatewayConditions(&egw)
+
+		log.V(1).Info("update egress gateway status", "status", egw.Status)
+		if err := r.updateGatewayStatus(ctx, &egw); err != nil {
+			log.Error(err, "update egress gateway status", "status", egw.Status)
+			return false, err
+		}
+		released = true
+	}
+	return released, nil
+}
+
+// resetPolicyStatus clears the assignment of the policy released from its previous gateway
+func resetPolicyStatus(status *egress.EgressPolicyStatus) {
+	status.Eip = egress.Eip{}
+	status.NodeIP = egress.Eip{}
+	status.Node = ""
 }
 
 func (r egnReconciler) deleteNodeFromEGs(ctx context.Context, log logr.Logger, nodeName string, egwList *egress.EgressGatewayList) error {
 	for _, egw := range egwList.Items {
+		if egress.IsImported(egw.Labels) {
+			continue
+		}
 		for _, eipStatus := range egw.Status.NodeList {
 			if nodeName == eipStatus.Name {
 				err := r.deleteNodeFromEG(ctx, log, nodeName, egw)
@@ -770,9 +918,10 @@ func (r egnReconciler) deleteNodeFromEG(ctx context.Context, log logr.Logger, no
 		egw.Status.IPUsage.IPv4Total = ipv4sTotal
 		egw.Status.IPUsage.IPv6Free = ipv6sFree
 		egw.Status.IPUsage.IPv6Total = ipv6sTotal
+		setGatewayConditions(&egw)
 
 		r.log.V(1).Info("update egress gateway status", "status", egw.Status)
-		err = r.client.Status().Update(ctx, &egw)
+		err = r.updateGatewayStatus(ctx, &egw)
 		if err != nil {
 			r.log.Error(err, "update egress gateway status", "status", egw.Status)
 			return err
@@ -801,21 +950,43 @@ func (r egnReconciler) reAllocatorPolicy(ctx context.Context, log logr.Logger, p
 			return err
 		}
 
-		if len(egcp.Spec.EgressIP.IPv4) != 0 {
-			pi.ipv4 = egcp.Spec.EgressIP.IPv4
+		if !policyInSchedule(egcp.Spec.Schedule) {
+			log.Info("skip the policy", "policy", pi.policy, "reason", "out of its time windows")
+			return nil
+		}
+		specIPv4, specIPv6, err := r.specEIP(ctx, egcp.Spec.EgressIP, egcp.Spec.EgressGatewayName)
+		if err != nil {
+			if isClaimNotReady(err) {
+				log.Info("skip the policy", "policy", pi.policy, "reason", err.Error())
+				return nil
+			}
+			return err
+		}
+		if len(specIPv4) != 0 {
+			pi.ipv4 = specIPv4
 		} else {
-			pi.ipv4 = egcp.Status.Eip.Ipv4
+			// the quarantined EIP is allocated again
+			if !isQuarantined(egw, egcp.Status.Eip.Ipv4) {
+				pi.ipv4 = egcp.Status.Eip.Ipv4
+			}
 		}
 
-		if len(egcp.Spec.EgressIP.IPv6) != 0 {
-			pi.ipv6 = egcp.Spec.EgressIP.IPv6
+		if len(specIPv6) != 0 {
+			pi.ipv6 = specIPv6
 		} else {
-			pi.ipv6 = egcp.Status.Eip.Ipv6
+			// the quarantined EIP is allocated again
+			if !isQuarantined(egw, egcp.Status.Eip.Ipv6) {
+				pi.ipv6 = egcp.Status.Eip.Ipv6
+			}
 		}
 
 		pi.isUseNodeIP = egcp.Spec.EgressIP.UseNodeIP
 		pi.egw = egcp.Spec.EgressGatewayName
 		pi.allocatorPolicy = egcp.Spec.EgressIP.AllocatorPolicy
+		if egcp.Spec.EgressIP.ClaimName != "" {
+			// the policy uses the EIP of the claim rather than the default EIP
+			pi.allocatorPolicy = egress.EipAllocatorRR
+		}
 	} else {
 		egp := &egress.EgressPolicy{}
 		err := r.client.Get(ctx, types.NamespacedName{Namespace: pi.policy.Namespace, Name: pi.policy.Name}, egp)
@@ -823,28 +994,50 @@ func (r egnReconciler) reAllocatorPolicy(ctx context.Context, log logr.Logger, p
 			return err
 		}
 
-		if len(egp.Spec.EgressIP.IPv4) != 0 {
-			pi.ipv4 = egp.Spec.EgressIP.IPv4
+		if !policyInSchedule(egp.Spec.Schedule) {
+			log.Info("skip the policy", "policy", pi.policy, "reason", "out of its time windows")
+			return nil
+		}
+		specIPv4, specIPv6, err := r.specEIP(ctx, egp.Spec.EgressIP, egp.Spec.EgressGatewayName)
+		if err != nil {
+			if isClaimNotReady(err) {
+				log.Info("skip the policy", "policy", pi.policy, "reason", err.Error())
+				return nil
+			}
+			return err
+		}
+		if len(specIPv4) != 0 {
+			pi.ipv4 = specIPv4
 		} else {
-			pi.ipv4 = egp.Status.Eip.Ipv4
+			// the quarantined EIP is allocated again
+			if !isQuarantined(egw, egp.Status.Eip.Ipv4) {
+				pi.ipv4 = egp.Status.Eip.Ipv4
+			}
 		}
 
-		if len(egp.Spec.EgressIP.IPv6) != 0 {
-			pi.ipv6 = egp.Spec.EgressIP.IPv6
+		if len(specIPv6) != 0 {
+			pi.ipv6 = specIPv6
 		} else {
-			pi.ipv6 = egp.Status.Eip.Ipv6
+			// the quarantined EIP is allocated again
+			if !isQuarantined(egw, egp.Status.Eip.Ipv6) {
+				pi.ipv6 = egp.Status.Eip.Ipv6
+			}
 		}
 
 		pi.isUseNodeIP = egp.Spec.EgressIP.UseNodeIP
 		pi.egw = egp.Spec.EgressGatewayName
 		pi.allocatorPolicy = egp.Spec.EgressIP.AllocatorPolicy
+		if egp.Spec.EgressIP.ClaimName != "" {
+			// the policy uses the EIP of the claim rather than the default EIP
+			pi.allocatorPolicy = egress.EipAllocatorRR
+		}
 	}
 
 	ipv4 = pi.ipv4
 	if len(ipv4) != 0 {
 		perNode = GetNodeByIP(ipv4, *egw)
 		if nodeMap[perNode].Status != string(egress.EgressTunnelReady) {
-			perNode = ""
+			perNode = r.standbyNode(ipv4, pi.ipv6, *egw, nodeMap)
 		}
 
 		if len(perNode) == 0 {
@@ -860,7 +1053,8 @@ func (r egnReconciler) reAllocatorPolicy(ctx context.Context, log logr.Logger, p
 		}
 	} else {
 		allocatorPolicy := pi.allocatorPolicy
-		if allocatorPolicy == egress.EipAllocatorRR {
+		if allocatorPolicy == egress.EipAllocatorRR ||
+			isQuarantined(egw, egw.Spec.Ippools.Ipv4DefaultEIP) || isQuarantined(egw, egw.Spec.Ippools.Ipv6DefaultEIP) {
 			perNode, err = r.allocatorNode("rr", nodeMap)
 			if err != nil {
 				return err
@@ -870,13 +1064,19 @@ func (r egnReconciler) reAllocatorPolicy(ctx context.Context, log logr.Logger, p
 			if err != nil {
 				return err
 			}
+			// the EIP shared with other policies by the leastUsed strategy stays on its node
+			if len(ipv4) != 0 {
+				if node := GetNodeByIP(ipv4, *egw); nodeMap[node].Status == string(egress.EgressTunnelReady) {
+					perNode = node
+				}
+			}
 		} else {
 			ipv4 = egw.Spec.Ippools.Ipv4DefaultEIP
 			ipv6 = egw.Spec.Ippools.Ipv6DefaultEIP
 
 			perNode = GetNodeByIP(ipv4, *egw)
 			if nodeMap[perNode].Status != string(egress.EgressTunnelReady) {
-				perNode = ""
+				perNode = r.standbyNode(ipv4, ipv6, *egw, nodeMap)
 			}
 
 			if len(perNode) == 0 {
@@ -909,7 +1109,7 @@ func (r egnReconciler) allocatorNode(selNodePolicy string, nodeMap map[string]eg
 	perNodePolicyNum := 0
 	i := 0
 	for _, node := range nodeMap {
-		if node.Status != string(egress.EgressTunnelReady) {
+		if !r.allocatable(node) {
 			continue
 		}
 
@@ -938,7 +1138,11 @@ func (r egnReconciler) allocatorEIP(selEipLolicy string, nodeName string, pi pol
 	}
 	var perIpv4 string
 	var perIpv6 string
-	rander := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	claimed, err := r.claimedIPs(context.Background(), egw.Name, "")
+	if err != nil {
+		return "", "", err
+	}
 
 	if len(egw.Spec.Ippools.IPv4) > 0 {
 		var useIpv4s []net.IP
@@ -953,41 +1157,34 @@ func (r egnReconciler) allocatorEIP(selEipLolicy string, nodeName string, pi pol
 			if !result {
 				return "", "", fmt.Errorf("%v is not within the EIP range of EgressGateway %v", perIpv4, egw.Name)
 			}
+			if isQuarantined(&egw, perIpv4) {
+				return "", "", fmt.Errorf("%v is quarantined in EgressGateway %v, it is claimed by another EgressGateway", perIpv4, egw.Name)
+			}
 		} else {
+			usage := make(map[string]int)
 			for _, node := range egw.Status.NodeList {
 				for _, eip := range node.Eips {
 					if len(eip.IPv4) != 0 {
 						useIpv4s = append(useIpv4s, net.ParseIP(eip.IPv4))
+						usage[eip.IPv4] += len(eip.Policies)
 					}
 				}
 			}
+			for _, item := range egw.Status.QuarantinedIPs {
+				useIpv4s = append(useIpv4s, net.ParseIP(item))
+			}
+			for _, item := range destinationIPs(&egw) {
+				useIpv4s = append(useIpv4s, net.ParseIP(item))
+			}
+			// the EIPs of the claims are only used by the policies referencing them
+			useIpv4s = append(useIpv4s, claimed...)
 
 			ipv4s, _ := ip.ParseIPRanges(constant.IPv4, ipv4Ranges)
-			freeIpv4s := ip.IPsDiffSet(ipv4s, useIpv4s, false)
-
-			if len(freeIpv4s) == 0 {
+			selected := selectEIP(egw.Spec.Ippools.Policy, ipv4s, useIpv4s, usage, pi.policy)
+			if selected == nil {
 				return "", "", fmt.Errorf("No Egress IPV4 is available; policy=%v egw=%v", pi.policy, egw.Name)
-
-				// save it for later policy
-				// var useIpv4sByNode []net.IP
-				// for _, node := range egw.Status.NodeList {
-				// 	if node.Name == nodeName {
-				// 		for _, eip := range node.Eips {
-				// 			if len(eip.IPv4) != 0 {
-				// 				useIpv4sByNode = append(useIpv4sByNode, net.ParseIP(eip.IPv4))
-				// 			}
-				// 		}
-				// 	}
-				// }
-
-				// if len(useIpv4sByNode) == 0 {
-				// 	return "", "", fmt.Errorf("No EIP meeting requirements is found on node %v; EG %v", nodeName, egw.Name)
-				// }
-
-				// perIpv4 = useIpv4sByNode[rander.Intn(len(useIpv4sByNode))].String()
-			} else {
-				perIpv4 = freeIpv4s[rander.Intn(len(freeIpv4s))].String()
 			}
+			perIpv4 = selected.String()
 		}
 	}
 
@@ -1009,40 +1206,34 @@ func (r egnReconciler) allocatorEIP(selEipLolicy string, nodeName string, pi pol
 			if !result {
 				return "", "", fmt.Errorf("%v is not within the EIP range of EgressGateway %v", perIpv6, egw.Name)
 			}
+			if isQuarantined(&egw, perIpv6) {
+				return "", "", fmt.Errorf("%v is quarantined in EgressGateway %v, it is claimed by another EgressGateway", perIpv6, egw.Name)
+			}
 		} else {
+			usage := make(map[string]int)
 			for _, node := range egw.Status.NodeList {
 				for _, eip := range node.Eips {
 					if len(eip.IPv6) != 0 {
 						useIpv6s = append(useIpv6s, net.ParseIP(eip.IPv6))
+						usage[eip.IPv6] += len(eip.Policies)
 					}
 				}
 			}
+			for _, item := range egw.Status.QuarantinedIPs {
+				useIpv6s = append(useIpv6s, net.ParseIP(item))
+			}
+			for _, item := range destinationIPs(&egw) {
+				useIpv6s = append(useIpv6s, net.ParseIP(item))
+			}
+			// the EIPs of the claims are only used by the policies referencing them
+			useIpv6s = append(useIpv6s, claimed...)
 
 			ipv6s, _ := ip.ParseIPRanges(constant.IPv6, ipv6Ranges)
-			freeIpv6s := ip.IPsDiffSet(ipv6s, useIpv6s, false)
-
-			if len(freeIpv6s) == 0 {
+			selected := selectEIP(egw.Spec.Ippools.Policy, ipv6s, useIpv6s, usage, pi.policy)
+			if selected == nil {
 				return "", "", fmt.Errorf("No Egress IPV6 is available; policy=%v egw=%v", pi.policy, egw.Name)
-
-				// save it for later policy
-				// var useIpv6sByNode []net.IP
-				// for _, node := range egw.Status.NodeList {
-				// 	if node.Name == nodeName {
-				// 		for _, eip := range node.Eips {
-				// 			if len(eip.IPv6) != 0 {
-				// 				useIpv6sByNode = append(useIpv6sByNode, net.ParseIP(eip.IPv6))
-				// 			}
-				// 		}
-				// 	}
-				// }
-
-				// if len(useIpv6sByNode) == 0 {
-				// 	return "", "", fmt.Errorf("No EIP meeting requirements is found on node %v; EG %v", nodeName, egw.Name)
-				// }
-				// perIpv6 = useIpv6sByNode[rander.Intn(len(useIpv6sByNode))].String()
-			} else {
-				perIpv6 = freeIpv6s[rander.Intn(len(freeIpv6s))].String()
 			}
+			perIpv6 = selected.String()
 		}
 	}
 
@@ -1057,10 +1248,13 @@ func NewEgressGatewayController(mgr manager.Manager, log logr.Logger, cfg *confi
 		client: mgr.GetClient(),
 		log:    log,
 		config: cfg,
+		cordon: newCordonTracker(),
+		flap:   newFlapTracker(),
+		hooks:  newEIPHooks(cfg.FileConfig.EIPHooks, log.WithName("eipHooks")),
 	}
 
 	c, err := controller.New("egressGateway", mgr,
-		controller.Options{Reconciler: r})
+		queue.Options(cfg, "egressGateway", r, 1))
 	if err != nil {
 		return err
 	}
@@ -1070,6 +1264,13 @@ func NewEgressGatewayController(mgr manager.Manager, log logr.Logger, cfg *confi
 		return fmt.Errorf("failed to watch EgressGateway: %w", err)
 	}
 
+	// the IPs quarantined by the other gateways may change with the ippools of the gateway
+	if err = c.Watch(source.Kind(mgr.GetCache(), &egress.EgressGateway{}),
+		handler.EnqueueRequestsFromMapFunc(enqueueOtherGateways(r.client)),
+		predicate.GenerationChangedPredicate{}); err != nil {
+		return fmt.Errorf("failed to watch EgressGateway: %w", err)
+	}
+
 	if err = c.Watch(source.Kind(mgr.GetCache(), &corev1.Node{}),
 		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("Node"))); err != nil {
 		return fmt.Errorf("failed to watch Node: %w", err)
@@ -1090,6 +1291,28 @@ func NewEgressGatewayController(mgr manager.Manager, log logr.Logger, cfg *confi
 		return fmt.Errorf("failed to watch EgressTunnel: %w", err)
 	}
 
+	if err = c.Watch(source.Kind(mgr.GetCache(), &egress.EgressIPClaim{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressIPClaim"))); err != nil {
+		return fmt.Errorf("failed to watch EgressIPClaim: %w", err)
+	}
+
+	// the claims count the policies referencing them
+	if err = c.Watch(source.Kind(mgr.GetCache(), &egress.EgressPolicy{}),
+		handler.EnqueueRequestsFromMapFunc(enqueueClaimOfPolicy())); err != nil {
+		return fmt.Errorf("failed to watch EgressPolicy: %w", err)
+	}
+
+	if err = c.Watch(source.Kind(mgr.GetCache(), &egress.EgressClusterPolicy{}),
+		handler.EnqueueRequestsFromMapFunc(enqueueClaimOfPolicy())); err != nil {
+		return fmt.Errorf("failed to watch EgressClusterPolicy: %w", err)
+	}
+
+	if err = c.Watch(source.Kind(mgr.GetCache(), &egress.EgressGateway{}),
+		handler.EnqueueRequestsFromMapFunc(enqueueClaimsOfGateway(r.client)),
+		predicate.GenerationChangedPredicate{}); err != nil {
+		return fmt.Errorf("failed to watch EgressGateway: %w", err)
+	}
+
 	return nil
 }
 
@@ -1277,6 +1500,14 @@ func countGatewayIP(egw *egress.EgressGateway) (ipv4sFree, ipv6sFree, ipv4sTotal
 				useIpv6s = append(useIpv6s, net.ParseIP(eip.IPv6))
 			}
 		}
+		for _, item := range node.DestinationEips {
+			if len(item.IPv4) != 0 {
+				useIpv4s = append(useIpv4s, net.ParseIP(item.IPv4))
+			}
+			if len(item.IPv6) != 0 {
+				useIpv6s = append(useIpv6s, net.ParseIP(item.IPv6))
+			}
+		}
 	}
 
 	ipv4sFree = len(ipv4s) - len(useIpv4s)
@@ -1286,6 +1517,87 @@ func countGatewayIP(egw *egress.EgressGateway) (ipv4sFree, ipv6sFree, ipv4sTotal
 	return
 }
 
+// setGatewayConditions summarizes the node list and ip usage of the gateway into
+// the ready node count, default EIP usage and conditions.
+func setGatewayConditions(egw *egress.EgressGateway) {
+	readyNodes := 0
+	failover := make([]string, 0)
+	defaultEIPPolicies := 0
+	for _, node := range egw.Status.NodeList {
+		ready := node.Status == string(egress.EgressTunnelReady)
+		if ready {
+			readyNodes++
+		}
+		holding := false
+		for _, eip := range node.Eips {
+			holding = holding || len(eip.Policies) > 0
+			if (eip.IPv4 != "" && eip.IPv4 == egw.Spec.Ippools.Ipv4DefaultEIP) ||
+				(eip.IPv6 != "" && eip.IPv6 == egw.Spec.Ippools.Ipv6DefaultEIP) {
+				defaultEIPPolicies += len(eip.Policies)
+			}
+		}
+		if !ready && holding {
+			failover = append(failover, node.Name)
+		}
+	}
+	egw.Status.ObservedGeneration = egw.Generation
+	egw.Status.ReadyNodes = readyNodes
+	egw.Status.IPUsage.DefaultEIPPolicies = defaultEIPPolicies
+
+	ready := metav1.Condition{
+		Type:               egress.GatewayConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "NodesReady",
+		Message:            fmt.Sprintf("%d of %d nodes are ready", readyNodes, len(egw.Status.NodeList)),
+		ObservedGeneration: egw.Generation,
+	}
+	if readyNodes == 0 {
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = "NoReadyNode"
+	}
+	meta.SetStatusCondition(&egw.Status.Conditions, ready)
+
+	usage := egw.Status.IPUsage
+	exhausted := metav1.Condition{
+		Type:               egress.GatewayConditionIPPoolExhausted,
+		Status:             metav1.ConditionFalse,
+		Reason:             "IPAvailable",
+		Message:            fmt.Sprintf("ipv4 %d/%d free, ipv6 %d/%d free", usage.IPv4Free, usage.IPv4Total, usage.IPv6Free, usage.IPv6Total),
+		ObservedGeneration: egw.Generation,
+	}
+	if (usage.IPv4Total > 0 && usage.IPv4Free <= 0) || (usage.IPv6Total > 0 && usage.IPv6Free <= 0) {
+		exhausted.Status = metav1.ConditionTrue
+		exhausted.Reason = "NoFreeIP"
+	}
+	meta.SetStatusCondition(&egw.Status.Conditions, exhausted)
+
+	failoverCond := metav1.Condition{
+		Type:               egress.GatewayConditionFailoverInProgress,
+		Status:             metav1.ConditionFalse,
+		Reason:             "NoFailover",
+		ObservedGeneration: egw.Generation,
+	}
+	if len(failover) > 0 {
+		failoverCond.Status = metav1.ConditionTrue
+		failoverCond.Reason = "NodeNotReady"
+		failoverCond.Message = fmt.Sprintf("EIPs are held by nodes that are not ready: %s", strings.Join(failover, ","))
+	}
+	meta.SetStatusCondition(&egw.Status.Conditions, failoverCond)
+
+	conflict := metav1.Condition{
+		Type:               egress.GatewayConditionIPConflict,
+		Status:             metav1.ConditionFalse,
+		Reason:             "NoConflict",
+		ObservedGeneration: egw.Generation,
+	}
+	if len(egw.Status.QuarantinedIPs) > 0 {
+		conflict.Status = metav1.ConditionTrue
+		conflict.Reason = "IPQuarantined"
+		conflict.Message = fmt.Sprintf("IPs claimed by an earlier EgressGateway are quarantined: %s", strings.Join(egw.Status.QuarantinedIPs, ","))
+	}
+	meta.SetStatusCondition(&egw.Status.Conditions, conflict)
+}
+
 // removeEgressGatewayFinalizer if the egress gateway is being deleted
 func removeEgressGatewayFinalizer(egw *egress.EgressGateway) {
 	if !egw.DeletionTimestamp.IsZero() {
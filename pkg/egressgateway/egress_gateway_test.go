@@ -0,0 +1,156 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestSetGatewayConditions(t *testing.T) {
+	policy := egress.Policy{Name: "p1", Namespace: "default"}
+	egw := &egress.EgressGateway{
+		Spec: egress.EgressGatewaySpec{
+			Ippools: egress.Ippools{Ipv4DefaultEIP: "10.6.1.55"},
+		},
+		Status: egress.EgressGatewayStatus{
+			NodeList: []egress.EgressIPStatus{
+				{
+					Name:   "node1",
+					Status: string(egress.EgressTunnelReady),
+					Eips:   []egress.Eips{{IPv4: "10.6.1.55", Policies: []egress.Policy{policy}}},
+				},
+				{
+					Name:   "node2",
+					Status: string(egress.EgressTunnelHeartbeatTimeout),
+					Eips:   []egress.Eips{{IPv4: "10.6.1.56", Policies: []egress.Policy{policy}}},
+				},
+			},
+			IPUsage: egress.IPUsage{IPv4Total: 2, IPv4Free: 0},
+		},
+	}
+
+	setGatewayConditions(egw)
+	assert.Equal(t, 1, egw.Status.ReadyNodes)
+	assert.Equal(t, 1, egw.Status.IPUsage.DefaultEIPPolicies)
+	assert.True(t, meta.IsStatusConditionTrue(egw.Status.Conditions, egress.GatewayConditionReady))
+	assert.True(t, meta.IsStatusConditionTrue(egw.Status.Conditions, egress.GatewayConditionIPPoolExhausted))
+	cond := meta.FindStatusCondition(egw.Status.Conditions, egress.GatewayConditionFailoverInProgress)
+	if assert.NotNil(t, cond) {
+		assert.Equal(t, metav1.ConditionTrue, cond.Status)
+		assert.Contains(t, cond.Message, "node2")
+	}
+
+	egw.Status.NodeList = nil
+	egw.Status.IPUsage.IPv4Free = 2
+	setGatewayConditions(egw)
+	assert.Equal(t, 0, egw.Status.ReadyNodes)
+	assert.True(t, meta.IsStatusConditionFalse(egw.Status.Conditions, egress.GatewayConditionReady))
+	assert.True(t, meta.IsStatusConditionFalse(egw.Status.Conditions, egress.GatewayConditionIPPoolExhausted))
+	assert.True(t, meta.IsStatusConditionFalse(egw.Status.Conditions, egress.GatewayConditionFailoverInProgress))
+	assert.True(t, meta.IsStatusConditionFalse(egw.Status.Conditions, egress.GatewayConditionIPConflict))
+
+	egw.Status.QuarantinedIPs = []string{"10.6.1.56"}
+	setGatewayConditions(egw)
+	assert.True(t, meta.IsStatusConditionTrue(egw.Status.Conditions, egress.GatewayConditionIPConflict))
+}
+
+func TestQuarantinedIPs(t *testing.T) {
+	now := metav1.Now()
+	earlier := metav1.NewTime(now.Add(-time.Hour))
+	gateway := func(name string, created metav1.Time, pool ...string) egress.EgressGateway {
+		return egress.EgressGateway{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: created},
+			Spec:       egress.EgressGatewaySpec{Ippools: egress.Ippools{IPv4: pool}},
+		}
+	}
+
+	first := gateway("first", earlier, "10.6.1.50-10.6.1.52")
+	first.Status.NodeList = []egress.EgressIPStatus{{Name: "node1", Eips: []egress.Eips{{IPv4: "10.6.1.60"}}}}
+	second := gateway("second", now, "10.6.1.52-10.6.1.54", "10.6.1.60")
+	imported := gateway("b.egw", earlier, "10.6.1.53")
+	imported.Labels = map[string]string{egress.LabelCluster: "b"}
+	list := []egress.EgressGateway{first, second, imported}
+
+	// the later claimant quarantines the IPs in the ippools or allocated by the earlier one
+	ips, err := quarantinedIPs(&second, list)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.6.1.52", "10.6.1.60"}, ips)
+
+	ips, err = quarantinedIPs(&first, list)
+	assert.NoError(t, err)
+	assert.Empty(t, ips)
+
+	second.Status.QuarantinedIPs = ips
+	assert.False(t, isQuarantined(&second, "10.6.1.52"))
+	second.Status.QuarantinedIPs = []string{"10.6.1.52"}
+	assert.True(t, isQuarantined(&second, "10.6.1.52"))
+	assert.False(t, isQuarantined(&second, ""))
+}
+
+func TestRebindPolicy(t *testing.T) {
+	ctx := context.Background()
+	policy := egress.Policy{Name: "p1", Namespace: "default"}
+	gateway := func(name, pool string, eips []egress.Eips) *egress.EgressGateway {
+		return &egress.EgressGateway{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       egress.EgressGatewaySpec{Ippools: egress.Ippools{IPv4: []string{pool}}},
+			Status: egress.EgressGatewayStatus{NodeList: []egress.EgressIPStatus{{
+				Name:   "node-" + name,
+				Status: string(egress.EgressTunnelReady),
+				Eips:   eips,
+			}}},
+		}
+	}
+	egwA := gateway("a", "10.6.1.10-10.6.1.12", []egress.Eips{{IPv4: "10.6.1.10", Policies: []egress.Policy{policy}}})
+	egwB := gateway("b", "10.6.2.10-10.6.2.12", nil)
+	egp := &egress.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: policy.Name, Namespace: policy.Namespace},
+		Spec:       egress.EgressPolicySpec{EgressGatewayName: "b"},
+		Status: egress.EgressPolicyStatus{
+			Eip:  egress.Eip{Ipv4: "10.6.1.10"},
+			Node: "node-a",
+		},
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(egwA, egwB, egp).
+		WithStatusSubresource(egwA, egwB, egp).
+		Build()
+	r := egnReconciler{client: cli, log: logr.Discard()}
+
+	_, err := r.reconcileEGP(ctx, reconcile.Request{
+		NamespacedName: types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name},
+	}, logr.Discard())
+	assert.NoError(t, err)
+
+	// the policy and its EIP are released from the previous gateway
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(egwA), egwA))
+	_, exist := GetEIPStatusByPolicy(policy, *egwA)
+	assert.False(t, exist)
+
+	// and it is bound to the new gateway
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(egwB), egwB))
+	status, exist := GetEIPStatusByPolicy(policy, *egwB)
+	assert.True(t, exist)
+	assert.Equal(t, "node-b", status.Name)
+
+	// the assignment of the previous gateway is no longer reported
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(egp), egp))
+	assert.NotEqual(t, "node-a", egp.Status.Node)
+	assert.NotEqual(t, "10.6.1.10", egp.Status.Eip.Ipv4)
+}
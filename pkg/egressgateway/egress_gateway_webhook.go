@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"reflect"
 	"time"
 
 	"github.com/spidernet-io/egressgateway/pkg/utils/ip"
@@ -49,11 +50,20 @@ func (egw *EgressGatewayWebhook) EgressGatewayValidate(ctx context.Context, req
 		return webhook.Denied(fmt.Sprintf("json unmarshal EgressGateway with error: %v", err))
 	}
 
+	// the imported EgressGateways are validated by the remote cluster
+	if egress.IsImported(newEg.Labels) {
+		return webhook.Allowed("checked")
+	}
+
 	if newEg.Spec.NodeSelector.Selector == nil ||
 		(len(newEg.Spec.NodeSelector.Selector.MatchLabels) == 0 && len(newEg.Spec.NodeSelector.Selector.MatchExpressions) == 0) {
 		return webhook.Denied("The field spec.nodeSelector.selector is not set")
 	}
 
+	if snat := newEg.Spec.SNAT; snat != nil && snat.Mode == egress.SNATModeMasquerade && snat.Persistent {
+		return webhook.Denied("spec.snat.persistent is not supported by the MASQUERADE mode")
+	}
+
 	if egw.Config.FileConfig.EnableIPv4 && !egw.Config.FileConfig.EnableIPv6 {
 		if len(newEg.Spec.Ippools.IPv6) != 0 {
 			return webhook.Denied("Please do not configure spec.ippools.ipv6, as the current installation settings have not enabled IPv6")
@@ -113,6 +123,10 @@ func (egw *EgressGatewayWebhook) EgressGatewayValidate(ctx context.Context, req
 		}
 	}
 
+	if err := egw.checkIPPoolsOverlap(ctx, newEg.Name, ipv4Ranges, ipv6Ranges); err != nil {
+		return webhook.Denied(err.Error())
+	}
+
 	eg := new(egress.EgressGateway)
 	err = egw.Client.Get(ctx, types.NamespacedName{Name: req.Name, Namespace: req.Namespace}, eg)
 	if err != nil {
@@ -121,6 +135,15 @@ func (egw *EgressGatewayWebhook) EgressGatewayValidate(ctx context.Context, req
 		}
 	}
 
+	if req.Operation == v1.Update && !reflect.DeepEqual(eg.Spec.Tunnel, newEg.Spec.Tunnel) {
+		return webhook.Denied("the 'spec.tunnel' field is immutable")
+	}
+	if req.Operation == v1.Create && newEg.Spec.Tunnel != nil {
+		if err := egw.checkTunnel(ctx, newEg); err != nil {
+			return webhook.Denied(err.Error())
+		}
+	}
+
 	// it should be denied when the single IPv4 or IPv6 is updated to the other type
 	if req.Operation == v1.Update {
 		if len(eg.Spec.Ippools.IPv4) == 0 && len(newEg.Spec.Ippools.IPv4) > 0 {
@@ -174,6 +197,111 @@ func (egw *EgressGatewayWebhook) EgressGatewayValidate(ctx context.Context, req
 	return webhook.Allowed("checked")
 }
 
+// checkTunnel checks the dedicated tunnel network of the gateway does not conflict
+// with the default tunnel network and the networks of other gateways
+func (egw *EgressGatewayWebhook) checkTunnel(ctx context.Context, eg *egress.EgressGateway) error {
+	tunnel := eg.Spec.Tunnel
+	fileConfig := egw.Config.FileConfig
+	if tunnel.VNI <= 0 || tunnel.VNI > 16777215 {
+		return fmt.Errorf("invalid spec.tunnel.vni %d", tunnel.VNI)
+	}
+	if tunnel.VNI == fileConfig.VXLAN.ID {
+		return fmt.Errorf("spec.tunnel.vni %d is used by the default tunnel network", tunnel.VNI)
+	}
+
+	subnets := make([]*net.IPNet, 0)
+	check := func(name, subnet, defaultSubnet string, enabled bool) error {
+		if !enabled {
+			return nil
+		}
+		_, cidr, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return fmt.Errorf("invalid spec.tunnel.%s: %v", name, err)
+		}
+		if _, defaultCIDR, err := net.ParseCIDR(defaultSubnet); err == nil && ip.IsCIDROverlap(cidr, defaultCIDR) {
+			return fmt.Errorf("spec.tunnel.%s %s overlaps with the default tunnel subnet %s", name, subnet, defaultSubnet)
+		}
+		subnets = append(subnets, cidr)
+		return nil
+	}
+	if err := check("ipv4Subnet", tunnel.IPv4Subnet, fileConfig.TunnelIpv4Subnet, fileConfig.EnableIPv4); err != nil {
+		return err
+	}
+	if err := check("ipv6Subnet", tunnel.IPv6Subnet, fileConfig.TunnelIpv6Subnet, fileConfig.EnableIPv6); err != nil {
+		return err
+	}
+
+	egwList := new(egress.EgressGatewayList)
+	if err := egw.Client.List(ctx, egwList); err != nil {
+		return fmt.Errorf("failed to list EgressGateway: %v", err)
+	}
+	for _, item := range egwList.Items {
+		if item.Name == eg.Name || item.Spec.Tunnel == nil {
+			continue
+		}
+		if item.Spec.Tunnel.VNI == tunnel.VNI {
+			return fmt.Errorf("spec.tunnel.vni %d is used by EgressGateway %s", tunnel.VNI, item.Name)
+		}
+		for _, subnet := range []string{item.Spec.Tunnel.IPv4Subnet, item.Spec.Tunnel.IPv6Subnet} {
+			_, other, err := net.ParseCIDR(subnet)
+			if err != nil {
+				continue
+			}
+			for _, cidr := range subnets {
+				if ip.IsCIDROverlap(cidr, other) {
+					return fmt.Errorf("spec.tunnel subnet %s overlaps with the tunnel subnet of EgressGateway %s", cidr, item.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkIPPoolsOverlap checks that the ippools of the gateway do not overlap the
+// ippools of the other gateways, an IP can only be claimed by one gateway
+func (egw *EgressGatewayWebhook) checkIPPoolsOverlap(ctx context.Context, name string, ipv4Ranges, ipv6Ranges []string) error {
+	if len(ipv4Ranges) == 0 && len(ipv6Ranges) == 0 {
+		return nil
+	}
+	egwList := new(egress.EgressGatewayList)
+	if err := egw.Client.List(ctx, egwList); err != nil {
+		return fmt.Errorf("failed to list EgressGateway: %v", err)
+	}
+	for _, item := range egwList.Items {
+		if item.Name == name || egress.IsImported(item.Labels) {
+			continue
+		}
+		for version, ranges := range map[constant.IPVersion][]string{
+			constant.IPv4: ipv4Ranges,
+			constant.IPv6: ipv6Ranges,
+		} {
+			pool := item.Spec.Ippools.IPv4
+			if version == constant.IPv6 {
+				pool = item.Spec.Ippools.IPv6
+			}
+			if len(ranges) == 0 || len(pool) == 0 {
+				continue
+			}
+			others, err := ip.MergeIPRanges(version, pool)
+			if err != nil {
+				continue
+			}
+			for _, r1 := range ranges {
+				for _, r2 := range others {
+					overlap, err := ip.IsIPRangeOverlap(version, r1, r2)
+					if err != nil {
+						return fmt.Errorf("failed to check IP: %v", err)
+					}
+					if overlap {
+						return fmt.Errorf("ippools %s overlaps with %s of EgressGateway %s", r1, r2, item.Name)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func (egw *EgressGatewayWebhook) EgressGatewayMutate(ctx context.Context, req webhook.AdmissionRequest) webhook.AdmissionResponse {
 	rander := rand.New(rand.NewSource(time.Now().UnixNano()))
 	eg := new(egress.EgressGateway)
@@ -181,6 +309,9 @@ func (egw *EgressGatewayWebhook) EgressGatewayMutate(ctx context.Context, req we
 	if err != nil {
 		return webhook.Denied(fmt.Sprintf("json unmarshal EgressGateway with error: %v", err))
 	}
+	if egress.IsImported(eg.Labels) {
+		return webhook.Allowed("skipped")
+	}
 
 	reviewResponse := webhook.AdmissionResponse{}
 	var patchList []patchOperation
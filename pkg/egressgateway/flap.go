@@ -0,0 +1,192 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+var (
+	counterFlaps = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "egress_gateway_eip_flaps_total",
+		Help: "Number of the times the EIPs are moved away from the gateway node because of its health",
+	}, []string{"gateway", "node"})
+	gaugeDamped = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "egress_gateway_node_damped",
+		Help: "Whether the flapping gateway node is held down from hosting EIPs",
+	}, []string{"node"})
+)
+
+// flapTracker records the EIP moves away from the gateway nodes. A node whose EIPs are
+// moved Threshold times in the window is held down from hosting new EIPs, and the hold
+// down doubles each time the node is damped again before it has been stable for
+// MaxHoldDownSecond.
+type flapTracker struct {
+	lock  sync.Mutex
+	flaps map[string][]time.Time
+	damps map[string]*damping
+}
+
+type damping struct {
+	level int
+	until time.Time
+	// held is true until the node is released after the hold down
+	held bool
+}
+
+func newFlapTracker() *flapTracker {
+	return &flapTracker{
+		flaps: make(map[string][]time.Time),
+		damps: make(map[string]*damping),
+	}
+}
+
+// observe records a flap of the node, and returns the end of the hold down if the node
+// is damped by it
+func (t *flapTracker) observe(name string, now time.Time, cfg config.FlapDamping) (time.Time, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	window := now.Add(-time.Duration(cfg.WindowSecond) * time.Second)
+	list := append(t.flaps[name], now)
+	for len(list) > 0 && list[0].Before(window) {
+		list = list[1:]
+	}
+	t.flaps[name] = list
+
+	d, ok := t.damps[name]
+	if !ok {
+		d = new(damping)
+		t.damps[name] = d
+	}
+	if d.held && now.Before(d.until) {
+		return d.until, false
+	}
+	if len(list) < cfg.Threshold {
+		return time.Time{}, false
+	}
+
+	// the node has been stable long enough, it starts over from the shortest hold down
+	maxHoldDown := time.Duration(cfg.MaxHoldDownSecond) * time.Second
+	if d.level > 0 && now.Sub(d.until) > maxHoldDown {
+		d.level = 0
+	}
+	holdDown := time.Duration(cfg.HoldDownSecond) * time.Second
+	for i := 0; i < d.level && holdDown < maxHoldDown; i++ {
+		holdDown *= 2
+	}
+	if holdDown > maxHoldDown {
+		holdDown = maxHoldDown
+	}
+	d.level++
+	d.until = now.Add(holdDown)
+	d.held = true
+	delete(t.flaps, name)
+	return d.until, true
+}
+
+// remaining returns the remaining hold down of the node
+func (t *flapTracker) remaining(name string, now time.Time) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	d, ok := t.damps[name]
+	if !ok || !d.held || !now.Before(d.until) {
+		return 0
+	}
+	return d.until.Sub(now)
+}
+
+// release returns true if the hold down of the node is over and it's not released yet
+func (t *flapTracker) release(name string, now time.Time) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	d, ok := t.damps[name]
+	if !ok || !d.held || now.Before(d.until) {
+		return false
+	}
+	d.held = false
+	return true
+}
+
+func (t *flapTracker) forget(name string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.flaps, name)
+	delete(t.damps, name)
+}
+
+func (r egnReconciler) flapDampingEnabled() bool {
+	return r.flap != nil && r.config != nil && r.config.FileConfig.GatewayFailover.FlapDamping.Enable
+}
+
+// isDamped returns true if the node is held down, no new EIP is placed on it
+func (r egnReconciler) isDamped(name string) bool {
+	if !r.flapDampingEnabled() {
+		return false
+	}
+	return r.flap.remaining(name, time.Now()) > 0
+}
+
+// holdDownRemaining returns the remaining hold down of the node
+func (r egnReconciler) holdDownRemaining(name string) time.Duration {
+	if !r.flapDampingEnabled() {
+		return 0
+	}
+	return r.flap.remaining(name, time.Now())
+}
+
+// releaseDamped returns true if the hold down of the node is just over
+func (r egnReconciler) releaseDamped(name string) bool {
+	if !r.flapDampingEnabled() {
+		return false
+	}
+	if !r.flap.release(name, time.Now()) {
+		return false
+	}
+	r.log.Info("release the gateway node after the hold down", "node", name)
+	gaugeDamped.WithLabelValues(name).Set(0)
+	return true
+}
+
+// observeFlaps records the nodes whose EIPs are moved away because of their health
+func (r egnReconciler) observeFlaps(gateway string, records []egress.FailoverRecord) {
+	if !r.flapDampingEnabled() {
+		return
+	}
+	cfg := r.config.FileConfig.GatewayFailover.FlapDamping
+	seen := make(map[string]struct{})
+	for _, item := range records {
+		if item.ToNode == "" || !isFlap(item.Reason) {
+			continue
+		}
+		if _, ok := seen[item.Node]; ok {
+			continue
+		}
+		seen[item.Node] = struct{}{}
+		counterFlaps.WithLabelValues(gateway, item.Node).Inc()
+		if until, ok := r.flap.observe(item.Node, item.Time.Time, cfg); ok {
+			r.log.Info("the EIPs of the gateway node are flapping, hold it down",
+				"egressGateway", gateway, "node", item.Node, "until", until)
+			gaugeDamped.WithLabelValues(item.Node).Set(1)
+		}
+	}
+}
+
+// isFlap returns true if the EIPs are moved because of the health of the node, the
+// moves of the drained, removed or rebalanced nodes are expected
+func isFlap(reason string) bool {
+	switch reason {
+	case egress.FailoverReasonManualDrain, egress.FailoverReasonNodeRemoved,
+		egress.FailoverReasonRebalanced, egress.FailoverReasonNodeRecovered:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestFlapTracker(t *testing.T) {
+	cfg := config.FlapDamping{Enable: true, WindowSecond: 60, Threshold: 3, HoldDownSecond: 10, MaxHoldDownSecond: 30}
+	tracker := newFlapTracker()
+	now := time.Now()
+
+	// the flaps out of the window are dropped
+	_, damped := tracker.observe("node1", now, cfg)
+	assert.False(t, damped)
+	_, damped = tracker.observe("node1", now.Add(61*time.Second), cfg)
+	assert.False(t, damped)
+	_, damped = tracker.observe("node1", now.Add(62*time.Second), cfg)
+	assert.False(t, damped)
+
+	now = now.Add(63 * time.Second)
+	until, damped := tracker.observe("node1", now, cfg)
+	assert.True(t, damped)
+	assert.Equal(t, now.Add(10*time.Second), until)
+	assert.Equal(t, 10*time.Second, tracker.remaining("node1", now))
+	assert.False(t, tracker.release("node1", now))
+
+	// the hold down doubles when the node flaps again soon
+	now = now.Add(10 * time.Second)
+	assert.True(t, tracker.release("node1", now))
+	assert.False(t, tracker.release("node1", now))
+	for i := 0; i < 2; i++ {
+		_, damped = tracker.observe("node1", now, cfg)
+		assert.False(t, damped)
+	}
+	until, damped = tracker.observe("node1", now, cfg)
+	assert.True(t, damped)
+	assert.Equal(t, now.Add(20*time.Second), until)
+
+	// and it's at most MaxHoldDownSecond
+	now = until
+	assert.True(t, tracker.release("node1", now))
+	for i := 0; i < 3; i++ {
+		until, _ = tracker.observe("node1", now, cfg)
+	}
+	assert.Equal(t, now.Add(30*time.Second), until)
+
+	// the node starts over after it has been stable
+	now = until.Add(31 * time.Second)
+	assert.True(t, tracker.release("node1", now))
+	for i := 0; i < 3; i++ {
+		until, _ = tracker.observe("node1", now, cfg)
+	}
+	assert.Equal(t, now.Add(10*time.Second), until)
+
+	tracker.forget("node1")
+	assert.Zero(t, tracker.remaining("node1", now))
+}
+
+func TestObserveFlaps(t *testing.T) {
+	cfg := new(config.Config)
+	cfg.FileConfig.GatewayFailover.FlapDamping = config.FlapDamping{
+		Enable: true, WindowSecond: 60, Threshold: 2, HoldDownSecond: 60, MaxHoldDownSecond: 60,
+	}
+	r := egnReconciler{log: logr.Discard(), config: cfg, flap: newFlapTracker()}
+	now := metav1.Now()
+	moved := func(reason string) []egress.FailoverRecord {
+		return []egress.FailoverRecord{
+			{Time: now, Node: "node1", Reason: reason},
+			{Time: now, Node: "node1", ToNode: "node2", IPv4: "10.6.1.21", Reason: reason},
+			{Time: now, Node: "node1", ToNode: "node2", IPv4: "10.6.1.22", Reason: reason},
+		}
+	}
+
+	r.observeFlaps("egw", moved(egress.FailoverReasonManualDrain))
+	r.observeFlaps("egw", moved(egress.FailoverReasonTunnelTimeout))
+	assert.False(t, r.isDamped("node1"))
+
+	r.observeFlaps("egw", moved(egress.FailoverReasonNodeNotReady))
+	assert.True(t, r.isDamped("node1"))
+	assert.False(t, r.isDamped("node2"))
+
+	selected, err := r.allocatorNode("rr", map[string]egress.EgressIPStatus{
+		"node1": {Name: "node1", Status: string(egress.EgressTunnelReady)},
+		"node2": {Name: "node2", Status: string(egress.EgressTunnelReady), Eips: []egress.Eips{{IPv4: "10.6.1.21"}}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "node2", selected)
+}
@@ -0,0 +1,161 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egresserrors "github.com/spidernet-io/egressgateway/pkg/errors"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+var (
+	counterFailovers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "egress_gateway_failover_transitions_total",
+		Help: "Number of the transitions of the gateway nodes and the EIPs by reason",
+	}, []string{"gateway", "reason"})
+	gaugeLastFailover = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "egress_gateway_last_failover_timestamp_seconds",
+		Help: "Unix time of the last transition of the gateway nodes and the EIPs",
+	}, []string{"gateway"})
+)
+
+func MetricCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		counterFailovers,
+		gaugeLastFailover,
+		counterFlaps,
+		gaugeDamped,
+		counterHooks,
+	}
+}
+
+// updateGatewayStatus records the transitions between the status of the gateway in the
+// cache and the new one, places the destination EIPs, then updates the status. The
+// preActivate hooks of the activated EIPs are invoked before the update, which is deferred
+// if they fail, and the postDeactivate hooks of the deactivated EIPs after it.
+func (r egnReconciler) updateGatewayStatus(ctx context.Context, egw *egress.EgressGateway) error {
+	var records []egress.FailoverRecord
+	old := new(egress.EgressGateway)
+	if err := r.client.Get(ctx, types.NamespacedName{Name: egw.Name}, old); err == nil {
+		records = failoverRecords(old.Status.NodeList, egw.Status.NodeList, metav1.Now())
+		egw.Status.FailoverHistory = appendHistory(egw.Status.FailoverHistory, records, r.historySize())
+	}
+	activated, deactivated := eipTransitions(egw.Name, old.Status.NodeList, egw.Status.NodeList)
+	if err := r.hooks.Run(ctx, config.EIPHookPreActivate, activated); err != nil {
+		return egresserrors.New(egresserrors.External, err)
+	}
+	if err := r.syncDestinationEIPs(ctx, egw); err != nil {
+		return err
+	}
+	r.assignStandby(egw)
+	if err := r.client.Status().Update(ctx, egw); err != nil {
+		return err
+	}
+	// the EIPs are deactivated already, the failed hooks are not retried
+	if err := r.hooks.Run(ctx, config.EIPHookPostDeactivate, deactivated); err != nil {
+		r.log.Error(err, "failed to run the postDeactivate eip hook", "egressGateway", egw.Name)
+	}
+	for _, item := range records {
+		r.log.Info("gateway failover transition", "egressGateway", egw.Name, "node", item.Node,
+			"toNode", item.ToNode, "ipv4", item.IPv4, "ipv6", item.IPv6, "reason", item.Reason)
+		counterFailovers.WithLabelValues(egw.Name, item.Reason).Inc()
+		gaugeLastFailover.WithLabelValues(egw.Name).Set(float64(item.Time.Unix()))
+	}
+	r.observeFlaps(egw.Name, records)
+	return nil
+}
+
+func (r egnReconciler) historySize() int {
+	if r.config == nil {
+		return 0
+	}
+	return r.config.FileConfig.GatewayFailover.HistorySize
+}
+
+// failoverRecords returns the status changes of the nodes between Ready and not Ready, and
+// the EIPs moved to other nodes
+func failoverRecords(oldNodes, newNodes []egress.EgressIPStatus, now metav1.Time) []egress.FailoverRecord {
+	res := make([]egress.FailoverRecord, 0)
+	ready := string(egress.EgressTunnelReady)
+
+	newStatus := make(map[string]string, len(newNodes))
+	newEIPs := make(map[string]string)
+	for _, node := range newNodes {
+		newStatus[node.Name] = node.Status
+		for _, eip := range node.Eips {
+			newEIPs[eip.IPv4+"/"+eip.IPv6] = node.Name
+		}
+	}
+
+	nodes := append([]egress.EgressIPStatus(nil), oldNodes...)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	for _, node := range nodes {
+		status, ok := newStatus[node.Name]
+		switch {
+		case !ok:
+			res = append(res, egress.FailoverRecord{Time: now, Node: node.Name, Reason: egress.FailoverReasonNodeRemoved})
+		case node.Status == ready && status != ready:
+			res = append(res, egress.FailoverRecord{Time: now, Node: node.Name, Reason: failoverReason(status)})
+		case node.Status != ready && status == ready:
+			res = append(res, egress.FailoverRecord{Time: now, Node: node.Name, Reason: egress.FailoverReasonNodeRecovered})
+		}
+	}
+	for _, node := range nodes {
+		reason := egress.FailoverReasonNodeRemoved
+		if status, ok := newStatus[node.Name]; ok {
+			reason = failoverReason(status)
+		}
+		for _, eip := range node.Eips {
+			to, ok := newEIPs[eip.IPv4+"/"+eip.IPv6]
+			if !ok || to == node.Name {
+				continue
+			}
+			res = append(res, egress.FailoverRecord{
+				Time: now, Node: node.Name, ToNode: to, IPv4: eip.IPv4, IPv6: eip.IPv6, Reason: reason,
+			})
+		}
+	}
+	return res
+}
+
+// failoverReason returns the reason of the failover from the node with the status
+func failoverReason(status string) string {
+	switch status {
+	case string(egress.EgressTunnelReady):
+		return egress.FailoverReasonRebalanced
+	case string(egress.EgressTunnelNodeNotReady):
+		return egress.FailoverReasonNodeNotReady
+	case string(egress.EgressTunnelHeartbeatTimeout):
+		return egress.FailoverReasonTunnelTimeout
+	case string(egress.EgressTunnelUnreachable):
+		return egress.FailoverReasonTunnelUnreachable
+	case string(egress.EgressTunnelUpstreamDown):
+		return egress.FailoverReasonUpstreamDown
+	case egress.NodeStatusCordoned:
+		return egress.FailoverReasonManualDrain
+	case "":
+		return egress.FailoverReasonNodeNotReady
+	default:
+		return status
+	}
+}
+
+// appendHistory appends the records and keeps the last size ones
+func appendHistory(history, records []egress.FailoverRecord, size int) []egress.FailoverRecord {
+	if size <= 0 {
+		return nil
+	}
+	history = append(history, records...)
+	if len(history) > size {
+		history = append([]egress.FailoverRecord(nil), history[len(history)-size:]...)
+	}
+	return history
+}
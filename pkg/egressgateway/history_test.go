@@ -0,0 +1,102 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestFailoverRecords(t *testing.T) {
+	now := metav1.Now()
+	eip := egress.Eips{IPv4: "10.6.1.21"}
+
+	cases := map[string]struct {
+		oldNodes []egress.EgressIPStatus
+		newNodes []egress.EgressIPStatus
+		expect   []egress.FailoverRecord
+	}{
+		"heartbeat timeout": {
+			oldNodes: []egress.EgressIPStatus{
+				{Name: "node1", Status: "Ready", Eips: []egress.Eips{eip}},
+				{Name: "node2", Status: "Ready"},
+			},
+			newNodes: []egress.EgressIPStatus{
+				{Name: "node1", Status: "HeartbeatTimeout"},
+				{Name: "node2", Status: "Ready", Eips: []egress.Eips{eip}},
+			},
+			expect: []egress.FailoverRecord{
+				{Time: now, Node: "node1", Reason: egress.FailoverReasonTunnelTimeout},
+				{Time: now, Node: "node1", ToNode: "node2", IPv4: "10.6.1.21", Reason: egress.FailoverReasonTunnelTimeout},
+			},
+		},
+		"cordoned": {
+			oldNodes: []egress.EgressIPStatus{
+				{Name: "node1", Status: "Ready", Eips: []egress.Eips{eip}},
+				{Name: "node2", Status: "Ready"},
+			},
+			newNodes: []egress.EgressIPStatus{
+				{Name: "node1", Status: egress.NodeStatusCordoned},
+				{Name: "node2", Status: "Ready", Eips: []egress.Eips{eip}},
+			},
+			expect: []egress.FailoverRecord{
+				{Time: now, Node: "node1", Reason: egress.FailoverReasonManualDrain},
+				{Time: now, Node: "node1", ToNode: "node2", IPv4: "10.6.1.21", Reason: egress.FailoverReasonManualDrain},
+			},
+		},
+		"removed": {
+			oldNodes: []egress.EgressIPStatus{
+				{Name: "node1", Status: "Ready", Eips: []egress.Eips{eip}},
+				{Name: "node2", Status: "Ready"},
+			},
+			newNodes: []egress.EgressIPStatus{
+				{Name: "node2", Status: "Ready", Eips: []egress.Eips{eip}},
+			},
+			expect: []egress.FailoverRecord{
+				{Time: now, Node: "node1", Reason: egress.FailoverReasonNodeRemoved},
+				{Time: now, Node: "node1", ToNode: "node2", IPv4: "10.6.1.21", Reason: egress.FailoverReasonNodeRemoved},
+			},
+		},
+		"recovered": {
+			oldNodes: []egress.EgressIPStatus{
+				{Name: "node1", Status: "HeartbeatTimeout"},
+			},
+			newNodes: []egress.EgressIPStatus{
+				{Name: "node1", Status: "Ready"},
+			},
+			expect: []egress.FailoverRecord{
+				{Time: now, Node: "node1", Reason: egress.FailoverReasonNodeRecovered},
+			},
+		},
+		"unchanged": {
+			oldNodes: []egress.EgressIPStatus{
+				{Name: "node1", Status: "Ready", Eips: []egress.Eips{eip}},
+			},
+			newNodes: []egress.EgressIPStatus{
+				{Name: "node1", Status: "Ready", Eips: []egress.Eips{eip}},
+			},
+			expect: []egress.FailoverRecord{},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, c.expect, failoverRecords(c.oldNodes, c.newNodes, now))
+		})
+	}
+}
+
+func TestAppendHistory(t *testing.T) {
+	history := []egress.FailoverRecord{{Node: "node1"}, {Node: "node2"}}
+	records := []egress.FailoverRecord{{Node: "node3"}, {Node: "node4"}}
+
+	res := appendHistory(history, records, 3)
+	assert.Equal(t, []egress.FailoverRecord{{Node: "node2"}, {Node: "node3"}, {Node: "node4"}}, res)
+	assert.Len(t, appendHistory(history, records, 10), 4)
+	assert.Nil(t, appendHistory(history, records, 0))
+}
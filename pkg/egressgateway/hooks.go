@@ -0,0 +1,199 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// maxHookOutput limits the output of the failed hooks in the errors
+const maxHookOutput = 512
+
+var counterHooks = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "egress_eip_hook_invocations_total",
+	Help: "Number of the invocations of the EIP hooks by the hook, the event and the result",
+}, []string{"hook", "event", "result"})
+
+// eipHookEvent is the event of an EIP activated on or deactivated from a node, which is
+// the body of the webhooks, and the environment variables of the commands
+type eipHookEvent struct {
+	Event   string `json:"event"`
+	Gateway string `json:"gateway"`
+	Node    string `json:"node"`
+	IPv4    string `json:"ipv4,omitempty"`
+	IPv6    string `json:"ipv6,omitempty"`
+}
+
+func (e eipHookEvent) env() []string {
+	return []string{
+		"EGRESS_HOOK_EVENT=" + e.Event,
+		"EGRESS_GATEWAY=" + e.Gateway,
+		"EGRESS_NODE=" + e.Node,
+		"EGRESS_IPV4=" + e.IPv4,
+		"EGRESS_IPV6=" + e.IPv6,
+	}
+}
+
+// eipHooks invokes the hooks of the config for the EIPs activated on the nodes and the
+// EIPs deactivated from the nodes by the status updates of the gateways
+type eipHooks struct {
+	hooks  []config.EIPHook
+	client *http.Client
+	log    logr.Logger
+}
+
+func newEIPHooks(hooks []config.EIPHook, log logr.Logger) *eipHooks {
+	return &eipHooks{hooks: hooks, client: &http.Client{}, log: log}
+}
+
+// Enabled returns whether any hook of the event is configured
+func (h *eipHooks) Enabled(event string) bool {
+	if h == nil {
+		return false
+	}
+	for _, hook := range h.hooks {
+		if hasEvent(hook, event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run invokes the hooks of the event for each one of the events in order. It returns the
+// first error of the hooks whose failure policy is Fail, the failures of the others are
+// only logged.
+func (h *eipHooks) Run(ctx context.Context, event string, events []eipHookEvent) error {
+	if !h.Enabled(event) {
+		return nil
+	}
+	for _, item := range events {
+		item.Event = event
+		for _, hook := range h.hooks {
+			if !hasEvent(hook, event) {
+				continue
+			}
+			err := h.invoke(ctx, hook, item)
+			if err == nil {
+				counterHooks.WithLabelValues(hook.Name, event, "success").Inc()
+				continue
+			}
+			counterHooks.WithLabelValues(hook.Name, event, "failure").Inc()
+			err = fmt.Errorf("eip hook %s of %s %s/%s on node %s failed: %w",
+				hook.Name, event, item.IPv4, item.IPv6, item.Node, err)
+			if hook.FailurePolicy == config.EIPHookFailurePolicyFail {
+				return err
+			}
+			h.log.Error(err, "ignore the failed eip hook", "egressGateway", item.Gateway)
+		}
+	}
+	return nil
+}
+
+func (h *eipHooks) invoke(ctx context.Context, hook config.EIPHook, event eipHookEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(hook.TimeoutSecond)*time.Second)
+	defer cancel()
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if hook.Type == config.EIPHookExec {
+		cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+		cmd.Env = append(os.Environ(), event.env()...)
+		cmd.Stdin = bytes.NewReader(body)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, truncate(out))
+		}
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		out, _ := io.ReadAll(io.LimitReader(resp.Body, maxHookOutput))
+		return fmt.Errorf("%s: %s", resp.Status, truncate(out))
+	}
+	return nil
+}
+
+func hasEvent(hook config.EIPHook, event string) bool {
+	for _, item := range hook.Events {
+		if item == event {
+			return true
+		}
+	}
+	return false
+}
+
+func truncate(out []byte) string {
+	if len(out) > maxHookOutput {
+		out = out[:maxHookOutput]
+	}
+	return string(bytes.TrimSpace(out))
+}
+
+// eipTransitions returns the EIPs activated on the nodes and the EIPs deactivated from the
+// nodes between the old and the new status of the gateway, an EIP moved to another node is
+// deactivated from the old node and activated on the new one
+func eipTransitions(gateway string, oldNodes, newNodes []egress.EgressIPStatus) (activated, deactivated []eipHookEvent) {
+	nodesOf := func(nodes []egress.EgressIPStatus) map[eipHookEvent]struct{} {
+		res := make(map[eipHookEvent]struct{})
+		for _, node := range nodes {
+			for _, eip := range node.Eips {
+				res[eipHookEvent{Gateway: gateway, Node: node.Name, IPv4: eip.IPv4, IPv6: eip.IPv6}] = struct{}{}
+			}
+		}
+		return res
+	}
+	oldEIPs, newEIPs := nodesOf(oldNodes), nodesOf(newNodes)
+	for item := range newEIPs {
+		if _, ok := oldEIPs[item]; !ok {
+			activated = append(activated, item)
+		}
+	}
+	for item := range oldEIPs {
+		if _, ok := newEIPs[item]; !ok {
+			deactivated = append(deactivated, item)
+		}
+	}
+	sortEvents(activated)
+	sortEvents(deactivated)
+	return activated, deactivated
+}
+
+func sortEvents(events []eipHookEvent) {
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Node != events[j].Node {
+			return events[i].Node < events[j].Node
+		}
+		if events[i].IPv4 != events[j].IPv4 {
+			return events[i].IPv4 < events[j].IPv4
+		}
+		return events[i].IPv6 < events[j].IPv6
+	})
+}
@@ -0,0 +1,106 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestEIPTransitions(t *testing.T) {
+	oldNodes := []egress.EgressIPStatus{
+		{Name: "node1", Eips: []egress.Eips{{IPv4: "10.6.1.100"}, {IPv4: "10.6.1.101"}}},
+		{Name: "node2", Eips: []egress.Eips{{IPv4: "10.6.1.102"}}},
+	}
+	newNodes := []egress.EgressIPStatus{
+		{Name: "node1", Eips: []egress.Eips{{IPv4: "10.6.1.100"}}},
+		{Name: "node2", Eips: []egress.Eips{{IPv4: "10.6.1.101"}, {IPv4: "10.6.1.102"}}},
+		{Name: "node3", Eips: []egress.Eips{{IPv4: "10.6.1.103", IPv6: "fd00::103"}}},
+	}
+	activated, deactivated := eipTransitions("gateway", oldNodes, newNodes)
+	assert.Equal(t, []eipHookEvent{
+		{Gateway: "gateway", Node: "node2", IPv4: "10.6.1.101"},
+		{Gateway: "gateway", Node: "node3", IPv4: "10.6.1.103", IPv6: "fd00::103"},
+	}, activated)
+	assert.Equal(t, []eipHookEvent{{Gateway: "gateway", Node: "node1", IPv4: "10.6.1.101"}}, deactivated)
+
+	activated, deactivated = eipTransitions("gateway", nil, nil)
+	assert.Empty(t, activated)
+	assert.Empty(t, deactivated)
+}
+
+func TestEIPHooks(t *testing.T) {
+	ctx := context.Background()
+	events := []eipHookEvent{{Gateway: "gateway", Node: "node2", IPv4: "10.6.1.101"}}
+
+	var received []eipHookEvent
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		event := eipHookEvent{}
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&event))
+		received = append(received, event)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	h := newEIPHooks([]config.EIPHook{{
+		Name:          "firewall",
+		Type:          config.EIPHookWebhook,
+		URL:           server.URL,
+		Events:        []string{config.EIPHookPreActivate, config.EIPHookPostDeactivate},
+		TimeoutSecond: 10,
+		FailurePolicy: config.EIPHookFailurePolicyFail,
+	}}, logr.Discard())
+	assert.True(t, h.Enabled(config.EIPHookPreActivate))
+	assert.NoError(t, h.Run(ctx, config.EIPHookPreActivate, events))
+	assert.Equal(t, []eipHookEvent{{Event: config.EIPHookPreActivate, Gateway: "gateway", Node: "node2", IPv4: "10.6.1.101"}}, received)
+
+	// the failed hook defers the activation unless it's ignored
+	status = http.StatusServiceUnavailable
+	assert.Error(t, h.Run(ctx, config.EIPHookPreActivate, events))
+	h.hooks[0].FailurePolicy = config.EIPHookFailurePolicyIgnore
+	assert.NoError(t, h.Run(ctx, config.EIPHookPreActivate, events))
+
+	// the hooks without the event are not invoked
+	h.hooks[0].Events = []string{config.EIPHookPostDeactivate}
+	received = nil
+	assert.NoError(t, h.Run(ctx, config.EIPHookPreActivate, events))
+	assert.Empty(t, received)
+
+	var nilHooks *eipHooks
+	assert.False(t, nilHooks.Enabled(config.EIPHookPreActivate))
+	assert.NoError(t, nilHooks.Run(ctx, config.EIPHookPreActivate, events))
+}
+
+func TestEIPHookExec(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	h := newEIPHooks([]config.EIPHook{{
+		Name:          "dns",
+		Type:          config.EIPHookExec,
+		Command:       []string{"sh", "-c", `echo "$EGRESS_HOOK_EVENT $EGRESS_NODE $EGRESS_IPV4" > ` + out},
+		Events:        []string{config.EIPHookPostDeactivate},
+		TimeoutSecond: 10,
+		FailurePolicy: config.EIPHookFailurePolicyFail,
+	}}, logr.Discard())
+	ctx := context.Background()
+	assert.NoError(t, h.Run(ctx, config.EIPHookPostDeactivate, []eipHookEvent{{Gateway: "gateway", Node: "node1", IPv4: "10.6.1.101"}}))
+	data, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "postDeactivate node1 10.6.1.101\n", string(data))
+
+	h.hooks[0].Command = []string{"sh", "-c", "echo denied; exit 1"}
+	err = h.Run(ctx, config.EIPHookPostDeactivate, []eipHookEvent{{Gateway: "gateway", Node: "node1", IPv4: "10.6.1.101"}})
+	assert.ErrorContains(t, err, "denied")
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func (r egnReconciler) datapathReadyEnabled() bool {
+	return r.config != nil && r.config.FileConfig.EnableDatapathReadyCondition
+}
+
+// isDatapathReady returns false if the DatapathReady condition of the node is not True,
+// the EIPs are not placed on the node until its datapath converged
+func (r egnReconciler) isDatapathReady(name string) bool {
+	if !r.datapathReadyEnabled() {
+		return true
+	}
+	node := new(corev1.Node)
+	if err := r.client.Get(context.Background(), types.NamespacedName{Name: name}, node); err != nil {
+		return false
+	}
+	return nodeDatapathReady(node)
+}
+
+func nodeDatapathReady(node *corev1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == egress.ConditionDatapathReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// assignPendingPolicies assigns the policies of the gateway which have no gateway node,
+// they are left unassigned while none of the nodes has converged
+func (r egnReconciler) assignPendingPolicies(ctx context.Context, log logr.Logger, name string) error {
+	egw := egress.EgressGateway{}
+	if err := r.client.Get(ctx, types.NamespacedName{Name: name}, &egw); err != nil {
+		return err
+	}
+
+	var policies []egress.Policy
+	egpList := &egress.EgressPolicyList{}
+	if err := r.client.List(ctx, egpList); err != nil {
+		return err
+	}
+	for _, egp := range egpList.Items {
+		if egp.Spec.EgressGatewayName == egw.Name {
+			policies = append(policies, egress.Policy{Name: egp.Name, Namespace: egp.Namespace})
+		}
+	}
+	egcpList := &egress.EgressClusterPolicyList{}
+	if err := r.client.List(ctx, egcpList); err != nil {
+		return err
+	}
+	for _, egcp := range egcpList.Items {
+		if egcp.Spec.EgressGatewayName == egw.Name {
+			policies = append(policies, egress.Policy{Name: egcp.Name})
+		}
+	}
+
+	perNodeMap := make(map[string]egress.EgressIPStatus)
+	for _, node := range egw.Status.NodeList {
+		perNodeMap[node.Name] = node
+	}
+	assigned := false
+	for _, policy := range policies {
+		if _, ok := GetEIPStatusByPolicy(policy, egw); ok {
+			continue
+		}
+		if err := r.reAllocatorPolicy(ctx, log, policy, &egw, perNodeMap); err != nil {
+			log.Error(err, "failed to assign a gateway node for EgressPolicy", "policy", policy)
+			return err
+		}
+		assigned = true
+	}
+	if !assigned {
+		return nil
+	}
+
+	var perNodeList []egress.EgressIPStatus
+	for _, node := range perNodeMap {
+		perNodeList = append(perNodeList, node)
+	}
+	egw.Status.NodeList = perNodeList
+
+	ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal, err := countGatewayIP(&egw)
+	if err != nil {
+		log.Error(err, "count egress gateway ippools", "nodeList", egw.Status.NodeList)
+		return err
+	}
+	egw.Status.IPUsage.IPv4Free = ipv4sFree
+	egw.Status.IPUsage.IPv4Total = ipv4sTotal
+	egw.Status.IPUsage.IPv6Free = ipv6sFree
+	egw.Status.IPUsage.IPv6Total = ipv6sTotal
+	setGatewayConditions(&egw)
+
+	log.V(1).Info("update egress gateway status", "status", egw.Status)
+	if err := r.updateGatewayStatus(ctx, &egw); err != nil {
+		log.Error(err, "update egress gateway status", "status", egw.Status)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestReconcileDatapathReadyNode(t *testing.T) {
+	ctx := context.Background()
+	policy := egress.Policy{Name: "p1", Namespace: "default"}
+	labels := map[string]string{"egress": "true"}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: labels},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{{
+			Type:   egress.ConditionDatapathReady,
+			Status: corev1.ConditionFalse,
+		}}},
+	}
+	egw := &egress.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "egw"},
+		Spec: egress.EgressGatewaySpec{
+			Ippools:      egress.Ippools{IPv4: []string{"10.6.1.10-10.6.1.12"}},
+			NodeSelector: egress.NodeSelector{Selector: &metav1.LabelSelector{MatchLabels: labels}},
+		},
+		Status: egress.EgressGatewayStatus{NodeList: []egress.EgressIPStatus{
+			{Name: "node-a", Status: string(egress.EgressTunnelReady)},
+		}},
+	}
+	egp := &egress.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: policy.Name, Namespace: policy.Namespace},
+		Spec: egress.EgressPolicySpec{
+			EgressGatewayName: egw.Name,
+			EgressIP:          egress.EgressIP{AllocatorPolicy: egress.EipAllocatorRR},
+		},
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(node, egw, egp).
+		WithStatusSubresource(node, egw, egp).
+		Build()
+
+	cfg := &config.Config{}
+	cfg.FileConfig.EnableDatapathReadyCondition = true
+	r := egnReconciler{client: cli, log: logr.Discard(), config: cfg}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: node.Name}}
+
+	// no EIP is placed on the node until its datapath converged
+	assert.False(t, r.isDatapathReady(node.Name))
+	selected, err := r.allocatorNode("rr", map[string]egress.EgressIPStatus{"node-a": egw.Status.NodeList[0]})
+	assert.NoError(t, err)
+	assert.Empty(t, selected)
+	_, err = r.reconcileNode(ctx, req, logr.Discard())
+	assert.NoError(t, err)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(egw), egw))
+	_, exist := GetEIPStatusByPolicy(policy, *egw)
+	assert.False(t, exist)
+
+	// the pending policy is assigned once the node converged
+	node.Status.Conditions[0].Status = corev1.ConditionTrue
+	assert.NoError(t, cli.Status().Update(ctx, node))
+	assert.True(t, r.isDatapathReady(node.Name))
+	_, err = r.reconcileNode(ctx, req, logr.Discard())
+	assert.NoError(t, err)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(egw), egw))
+	status, exist := GetEIPStatusByPolicy(policy, *egw)
+	assert.True(t, exist)
+	assert.Equal(t, "node-a", status.Name)
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schedule"
+)
+
+// syncPolicySchedule releases the policy out of its time windows, and sets the Active
+// condition of it. It returns whether the policy is active, and when to check the schedule
+// again, the policy without a schedule is always active.
+func (r egnReconciler) syncPolicySchedule(ctx context.Context, log logr.Logger, policy egress.Policy,
+	obj client.Object, spec *egress.PolicySchedule, status *egress.EgressPolicyStatus) (bool, time.Duration, error) {
+	if spec == nil {
+		if meta.FindStatusCondition(status.Conditions, egress.PolicyConditionActive) == nil {
+			return true, 0, nil
+		}
+		meta.RemoveStatusCondition(&status.Conditions, egress.PolicyConditionActive)
+		return true, 0, r.client.Status().Update(ctx, obj)
+	}
+
+	s, err := schedule.New(spec)
+	if err != nil {
+		log.Error(err, "invalid schedule of the policy")
+		return false, 0, err
+	}
+	now := time.Now()
+	active, next := s.Active(now)
+
+	message := "the policy is in its time windows"
+	if !active {
+		message = "the policy is out of its time windows"
+	}
+	changed := status.SetActiveCondition(obj.GetGeneration(), active, message)
+	if !active {
+		released, err := r.releasePolicy(ctx, log, policy, "")
+		if err != nil {
+			return false, 0, err
+		}
+		if released {
+			log.Info("release the policy out of its time windows", "next", next)
+		}
+		if status.Node != "" {
+			status.Node = ""
+			status.NodeIP = egress.Eip{}
+			changed = true
+		}
+		changed = status.SetReadyCondition(obj.GetGeneration()) || changed
+	}
+	if changed {
+		log.V(1).Info("update the schedule status of the policy", "status", status)
+		if err := r.client.Status().Update(ctx, obj); err != nil {
+			return active, 0, err
+		}
+	}
+	return active, next.Sub(now), nil
+}
+
+// policyInSchedule returns false if the policy is out of its time windows, the policy is
+// not assigned to any gateway node then
+func policyInSchedule(spec *egress.PolicySchedule) bool {
+	if spec == nil {
+		return true
+	}
+	s, err := schedule.New(spec)
+	if err != nil {
+		return false
+	}
+	active, _ := s.Active(time.Now())
+	return active
+}
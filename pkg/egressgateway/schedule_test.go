@@ -0,0 +1,96 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestReconcileScheduledPolicy(t *testing.T) {
+	ctx := context.Background()
+	policy := egress.Policy{Name: "p1", Namespace: "default"}
+	labels := map[string]string{"egress": "true"}
+	egw := &egress.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "egw"},
+		Spec: egress.EgressGatewaySpec{
+			Ippools:      egress.Ippools{IPv4: []string{"10.6.1.10-10.6.1.12"}},
+			NodeSelector: egress.NodeSelector{Selector: &metav1.LabelSelector{MatchLabels: labels}},
+		},
+		Status: egress.EgressGatewayStatus{NodeList: []egress.EgressIPStatus{
+			{
+				Name:   "node-a",
+				Status: string(egress.EgressTunnelReady),
+				Eips:   []egress.Eips{{IPv4: "10.6.1.10", Policies: []egress.Policy{policy}}},
+			},
+		}},
+	}
+	// the window only begins at 00:00 on January 1st
+	egp := &egress.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: policy.Name, Namespace: policy.Namespace},
+		Spec: egress.EgressPolicySpec{
+			EgressGatewayName: egw.Name,
+			EgressIP:          egress.EgressIP{AllocatorPolicy: egress.EipAllocatorRR},
+			Schedule: &egress.PolicySchedule{Windows: []egress.ScheduleWindow{
+				{Start: "0 0 1 1 *", Duration: metav1.Duration{Duration: time.Minute}},
+			}},
+		},
+		Status: egress.EgressPolicyStatus{Eip: egress.Eip{Ipv4: "10.6.1.10"}, Node: "node-a"},
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(egw, egp).
+		WithStatusSubresource(egw, egp).
+		Build()
+
+	r := egnReconciler{client: cli, log: logr.Discard()}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: policy.Name, Namespace: policy.Namespace}}
+
+	// the policy is released out of its time windows
+	res, err := r.reconcileEGP(ctx, req, logr.Discard())
+	assert.NoError(t, err)
+	assert.True(t, res.RequeueAfter > 0 && res.RequeueAfter <= time.Hour*24)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(egw), egw))
+	_, exist := GetEIPStatusByPolicy(policy, *egw)
+	assert.False(t, exist)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(egp), egp))
+	assert.Empty(t, egp.Status.Node)
+	assert.True(t, meta.IsStatusConditionFalse(egp.Status.Conditions, egress.PolicyConditionActive))
+	assert.True(t, meta.IsStatusConditionFalse(egp.Status.Conditions, egress.PolicyConditionReady))
+
+	// the policy is assigned again in its time windows
+	egp.Spec.Schedule.Windows[0].Start = "* * * * *"
+	assert.NoError(t, cli.Update(ctx, egp))
+	res, err = r.reconcileEGP(ctx, req, logr.Discard())
+	assert.NoError(t, err)
+	assert.True(t, res.RequeueAfter > 0 && res.RequeueAfter <= time.Minute)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(egw), egw))
+	status, exist := GetEIPStatusByPolicy(policy, *egw)
+	assert.True(t, exist)
+	assert.Equal(t, "node-a", status.Name)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(egp), egp))
+	assert.True(t, meta.IsStatusConditionTrue(egp.Status.Conditions, egress.PolicyConditionActive))
+
+	// the condition is removed with the schedule
+	egp.Spec.Schedule = nil
+	assert.NoError(t, cli.Update(ctx, egp))
+	res, err = r.reconcileEGP(ctx, req, logr.Discard())
+	assert.NoError(t, err)
+	assert.Zero(t, res.RequeueAfter)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(egp), egp))
+	assert.Nil(t, meta.FindStatusCondition(egp.Status.Conditions, egress.PolicyConditionActive))
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// GatewayMatches returns whether the gateway is selected by the labels of the selector
+func GatewayMatches(egw egress.EgressGateway, selector *egress.GatewaySelector) (bool, error) {
+	sel, err := metav1.LabelSelectorAsSelector(selector.Selector)
+	if err != nil {
+		return false, err
+	}
+	return sel.Matches(labels.Set(egw.Labels)), nil
+}
+
+// SelectGateway selects a gateway from the Ready gateways matching the selector by the
+// strategy of the selector, the current gateway is kept while it's a candidate. It returns
+// the name of the selected gateway and the number of the candidates, the name is empty if
+// there is no candidate.
+func SelectGateway(gateways []egress.EgressGateway, selector *egress.GatewaySelector, current string) (string, int, error) {
+	candidates := make([]egress.EgressGateway, 0)
+	for _, egw := range gateways {
+		if !egw.DeletionTimestamp.IsZero() || !meta.IsStatusConditionTrue(egw.Status.Conditions, egress.GatewayConditionReady) {
+			continue
+		}
+		ok, err := GatewayMatches(egw, selector)
+		if err != nil {
+			return "", 0, err
+		}
+		if ok {
+			candidates = append(candidates, egw)
+		}
+	}
+	for _, egw := range candidates {
+		if egw.Name == current {
+			return current, len(candidates), nil
+		}
+	}
+	if len(candidates) == 0 {
+		return "", 0, nil
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Name < candidates[j].Name })
+
+	selected := candidates[0]
+	if selector.Strategy == egress.GatewaySelectionLeastLoaded {
+		for _, egw := range candidates[1:] {
+			if countGatewayPolicies(egw) < countGatewayPolicies(selected) {
+				selected = egw
+			}
+		}
+	}
+	return selected.Name, len(candidates), nil
+}
+
+// countGatewayPolicies returns the number of the policies assigned to the gateway
+func countGatewayPolicies(egw egress.EgressGateway) int {
+	policies := make(map[egress.Policy]struct{})
+	for _, node := range egw.Status.NodeList {
+		for _, eip := range node.Eips {
+			for _, policy := range eip.Policies {
+				policies[policy] = struct{}{}
+			}
+		}
+	}
+	return len(policies)
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestSelectGateway(t *testing.T) {
+	gateway := func(name, pool string, ready bool, policies ...string) egress.EgressGateway {
+		egw := egress.EgressGateway{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"pool": pool}}}
+		status := metav1.ConditionFalse
+		if ready {
+			status = metav1.ConditionTrue
+		}
+		egw.Status.Conditions = []metav1.Condition{{Type: egress.GatewayConditionReady, Status: status}}
+		eip := egress.Eips{}
+		for _, policy := range policies {
+			eip.Policies = append(eip.Policies, egress.Policy{Name: policy, Namespace: "default"})
+		}
+		egw.Status.NodeList = []egress.EgressIPStatus{{Name: "node1", Eips: []egress.Eips{eip}}}
+		return egw
+	}
+	gateways := []egress.EgressGateway{
+		gateway("egw-c", "blue", true, "p1"),
+		gateway("egw-b", "blue", true, "p1", "p2"),
+		gateway("egw-a", "blue", false),
+		gateway("egw-d", "green", true),
+	}
+	blue := &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "blue"}}
+
+	// the first Ready gateway by name
+	name, candidates, err := SelectGateway(gateways, &egress.GatewaySelector{Selector: blue}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "egw-b", name)
+	assert.Equal(t, 2, candidates)
+
+	// the Ready gateway with the fewest policies
+	name, _, err = SelectGateway(gateways, &egress.GatewaySelector{Selector: blue, Strategy: egress.GatewaySelectionLeastLoaded}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "egw-c", name)
+
+	// the current gateway is kept while it's a candidate
+	name, _, err = SelectGateway(gateways, &egress.GatewaySelector{Selector: blue, Strategy: egress.GatewaySelectionLeastLoaded}, "egw-b")
+	assert.NoError(t, err)
+	assert.Equal(t, "egw-b", name)
+	name, _, err = SelectGateway(gateways, &egress.GatewaySelector{Selector: blue}, "egw-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "egw-b", name)
+
+	name, candidates, err = SelectGateway(gateways, &egress.GatewaySelector{
+		Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "red"}},
+	}, "egw-b")
+	assert.NoError(t, err)
+	assert.Equal(t, "", name)
+	assert.Equal(t, 0, candidates)
+
+	_, _, err = SelectGateway(gateways, &egress.GatewaySelector{Selector: &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "pool", Operator: "Unknown"}},
+	}}, "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,142 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"reflect"
+	"sort"
+
+	"golang.org/x/exp/slices"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func (r egnReconciler) warmStandbyEnabled() bool {
+	return r.config != nil && r.config.FileConfig.GatewayFailover.WarmStandby.Enable
+}
+
+// allocatable returns true if the EIPs can be placed on the node
+func (r egnReconciler) allocatable(node egress.EgressIPStatus) bool {
+	return node.Status == string(egress.EgressTunnelReady) && !r.isCordoned(node.Name) &&
+		!r.isDamped(node.Name) && r.isDatapathReady(node.Name)
+}
+
+// assignStandby places the EIPs of the gateway on their standby nodes, and drops the
+// programmed standby EIPs of the nodes no longer in the gateway. All the standby EIPs are
+// removed if the warm standby is disabled. It returns true if the status is changed.
+func (r egnReconciler) assignStandby(egw *egress.EgressGateway) bool {
+	eligible := func(egress.EgressIPStatus) bool { return false }
+	if r.warmStandbyEnabled() {
+		eligible = r.allocatable
+	}
+	changed := assignStandbyEips(egw.Status.NodeList, eligible)
+
+	nodes := make([]egress.NodeStandbyStatus, 0, len(egw.Status.StandbyNodes))
+	for _, item := range egw.Status.StandbyNodes {
+		if slices.ContainsFunc(egw.Status.NodeList, func(node egress.EgressIPStatus) bool {
+			return node.Name == item.Name
+		}) {
+			nodes = append(nodes, item)
+		}
+	}
+	if len(nodes) != len(egw.Status.StandbyNodes) {
+		egw.Status.StandbyNodes = nodes
+		changed = true
+	}
+	return changed
+}
+
+// assignStandbyEips sets the standby EIPs of the nodes, every EIP stands by on an eligible
+// node other than its gateway node. The standby node of an EIP is kept while it's eligible,
+// so the programmed rules are not moved, otherwise the eligible node standing by for the
+// least policies is picked. The EIPs of the policies using the node IPs have no standby.
+func assignStandbyEips(nodes []egress.EgressIPStatus, eligible func(egress.EgressIPStatus) bool) bool {
+	order := make([]int, len(nodes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return nodes[order[i]].Name < nodes[order[j]].Name })
+
+	// the standby nodes of the EIPs by their IPv4 and IPv6 addresses
+	previous := make(map[[2]string]string)
+	candidates := make([]string, 0, len(nodes))
+	for _, i := range order {
+		for _, eip := range nodes[i].StandbyEips {
+			previous[[2]string{eip.IPv4, eip.IPv6}] = nodes[i].Name
+		}
+		if eligible(nodes[i]) {
+			candidates = append(candidates, nodes[i].Name)
+		}
+	}
+
+	standby := make(map[string][]egress.Eips)
+	load := make(map[string]int)
+	for _, i := range order {
+		for _, eip := range nodes[i].Eips {
+			if eip.IPv4 == "" && eip.IPv6 == "" {
+				continue
+			}
+			name := previous[[2]string{eip.IPv4, eip.IPv6}]
+			if name == nodes[i].Name || !slices.Contains(candidates, name) {
+				name = ""
+				for _, candidate := range candidates {
+					if candidate != nodes[i].Name && (name == "" || load[candidate] < load[name]) {
+						name = candidate
+					}
+				}
+			}
+			if name == "" {
+				continue
+			}
+			standby[name] = append(standby[name], *eip.DeepCopy())
+			load[name] += len(eip.Policies)
+		}
+	}
+
+	changed := false
+	for i := range nodes {
+		if !reflect.DeepEqual(nodes[i].StandbyEips, standby[nodes[i].Name]) {
+			nodes[i].StandbyEips = standby[nodes[i].Name]
+			changed = true
+		}
+	}
+	return changed
+}
+
+// standbyNode returns the standby node of the EIP which has programmed it and can be placed
+// on, the EIP is moved to it first on the failover
+func (r egnReconciler) standbyNode(ipv4, ipv6 string, egw egress.EgressGateway, nodeMap map[string]egress.EgressIPStatus) string {
+	if !r.warmStandbyEnabled() {
+		return ""
+	}
+	ip := ipv4
+	if ip == "" {
+		ip = ipv6
+	}
+	if ip == "" {
+		return ""
+	}
+	for _, node := range nodeMap {
+		if !slices.ContainsFunc(node.StandbyEips, func(eip egress.Eips) bool {
+			return eip.IPv4 == ip || eip.IPv6 == ip
+		}) {
+			continue
+		}
+		if !standbyProgrammed(egw.Status.StandbyNodes, node.Name, ip) || !r.allocatable(node) {
+			return ""
+		}
+		return node.Name
+	}
+	return ""
+}
+
+// standbyProgrammed returns true if the agent of the node reported the standby EIP programmed
+func standbyProgrammed(nodes []egress.NodeStandbyStatus, name, ip string) bool {
+	for _, item := range nodes {
+		if item.Name == name {
+			return slices.Contains(item.IPs, ip)
+		}
+	}
+	return false
+}
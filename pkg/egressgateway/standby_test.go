@@ -0,0 +1,74 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestAssignStandbyEips(t *testing.T) {
+	ready := string(egress.EgressTunnelReady)
+	policy := egress.Policy{Name: "a", Namespace: "default"}
+	nodes := []egress.EgressIPStatus{
+		{Name: "node3", Status: ready},
+		{Name: "node1", Status: ready, Eips: []egress.Eips{
+			{IPv4: "10.6.1.21", Policies: []egress.Policy{policy}},
+			{Policies: []egress.Policy{{Name: "node-ip"}}},
+		}},
+		{Name: "node2", Status: ready, Eips: []egress.Eips{{IPv4: "10.6.1.22", Policies: []egress.Policy{policy, policy}}}},
+	}
+	eligible := func(node egress.EgressIPStatus) bool { return node.Status == ready }
+
+	assert.True(t, assignStandbyEips(nodes, eligible))
+	// the EIPs stand by on the least loaded nodes other than their gateway nodes by the
+	// order of the names, and the policies using the node IPs have no standby
+	assert.Nil(t, nodes[0].StandbyEips)
+	assert.Equal(t, []egress.Eips{{IPv4: "10.6.1.22", Policies: []egress.Policy{policy, policy}}}, nodes[1].StandbyEips)
+	assert.Equal(t, []egress.Eips{{IPv4: "10.6.1.21", Policies: []egress.Policy{policy}}}, nodes[2].StandbyEips)
+	assert.False(t, assignStandbyEips(nodes, eligible))
+
+	// the standby is kept while it's eligible, and moved away once it's not
+	nodes = append(nodes, egress.EgressIPStatus{Name: "node0", Status: ready})
+	assert.False(t, assignStandbyEips(nodes, eligible))
+	nodes[2].Status = string(egress.EgressTunnelFailed)
+	assert.True(t, assignStandbyEips(nodes, eligible))
+	assert.Nil(t, nodes[2].StandbyEips)
+	assert.Equal(t, "10.6.1.21", nodes[3].StandbyEips[0].IPv4)
+	assert.Equal(t, "10.6.1.22", nodes[1].StandbyEips[0].IPv4)
+
+	assert.True(t, assignStandbyEips(nodes, func(egress.EgressIPStatus) bool { return false }))
+	for _, node := range nodes {
+		assert.Nil(t, node.StandbyEips)
+	}
+}
+
+func TestStandbyNode(t *testing.T) {
+	cfg := new(config.Config)
+	r := egnReconciler{log: logr.Discard(), config: cfg}
+	egw := egress.EgressGateway{}
+	nodeMap := map[string]egress.EgressIPStatus{
+		"node1": {Name: "node1", Status: string(egress.EgressTunnelFailed)},
+		"node2": {Name: "node2", Status: string(egress.EgressTunnelReady), StandbyEips: []egress.Eips{{IPv4: "10.6.1.21", IPv6: "fd00::21"}}},
+	}
+	assert.Empty(t, r.standbyNode("10.6.1.21", "", egw, nodeMap))
+
+	// the EIP is only moved to the standby node which has programmed it
+	cfg.FileConfig.GatewayFailover.WarmStandby.Enable = true
+	assert.Empty(t, r.standbyNode("10.6.1.21", "", egw, nodeMap))
+	egw.Status.StandbyNodes = []egress.NodeStandbyStatus{{Name: "node2", IPs: []string{"10.6.1.21", "fd00::21"}}}
+	assert.Equal(t, "node2", r.standbyNode("10.6.1.21", "", egw, nodeMap))
+	assert.Equal(t, "node2", r.standbyNode("", "fd00::21", egw, nodeMap))
+	assert.Empty(t, r.standbyNode("10.6.1.22", "", egw, nodeMap))
+
+	// the programmed standby EIPs of the nodes no longer in the gateway are dropped
+	egw.Status.NodeList = []egress.EgressIPStatus{nodeMap["node1"]}
+	r.assignStandby(&egw)
+	assert.Empty(t, egw.Status.StandbyNodes)
+}
@@ -0,0 +1,102 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package errors classifies the errors of the controllers and the agent, so the retries,
+// the Reasons of the conditions and the metrics of the errors are consistent.
+package errors
+
+import (
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Class is the class of an error, which decides how the reconcile is retried
+type Class string
+
+const (
+	// Transient the error is temporary, e.g. a timeout or the throttling of the API
+	// server, the reconcile is retried with backoff
+	Transient Class = "Transient"
+	// Conflict the object was changed by others, e.g. the resource version is stale or
+	// the object already exists, the reconcile is requeued without logging the error
+	Conflict Class = "Conflict"
+	// Invalid the spec or the config is invalid, the reconcile is not retried until the
+	// object is changed
+	Invalid Class = "Invalid"
+	// External the system out of the cluster failed, e.g. netlink, iptables or the cloud,
+	// the reconcile is retried with backoff
+	External Class = "External"
+)
+
+// Classes are all the classes of the errors
+var Classes = []Class{Transient, Conflict, Invalid, External}
+
+// Error is an error with its class
+type Error struct {
+	Class Class
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New returns the error of the class, it returns nil if the err is nil
+func New(class Class, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Class: class, Err: err}
+}
+
+// Newf returns the error of the class with the formatted message, the `%w` verb wraps
+// the error like fmt.Errorf
+func Newf(class Class, format string, a ...any) error {
+	return &Error{Class: class, Err: fmt.Errorf(format, a...)}
+}
+
+// ClassOf returns the class of the error, it is the class of the outermost Error in the
+// chain, or it is inferred from the errors of the API server. The other errors are
+// Transient, so they are retried as before.
+func ClassOf(err error) Class {
+	if err == nil {
+		return ""
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Class
+	}
+	switch {
+	case apierrors.IsConflict(err), apierrors.IsAlreadyExists(err):
+		return Conflict
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		return Invalid
+	}
+	return Transient
+}
+
+// IsRetryable returns whether the reconcile of the error should be retried
+func IsRetryable(err error) bool {
+	return err != nil && ClassOf(err) != Invalid
+}
+
+// Reason returns the Reason of the condition of the error
+func Reason(err error) string {
+	switch ClassOf(err) {
+	case Transient:
+		return "TransientError"
+	case Conflict:
+		return "Conflict"
+	case Invalid:
+		return "Invalid"
+	case External:
+		return "ExternalError"
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package errors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestClassOf(t *testing.T) {
+	resource := schema.GroupResource{Group: "egressgateway.spidernet.io", Resource: "egresstunnels"}
+	kind := schema.GroupKind{Group: "egressgateway.spidernet.io", Kind: "EgressTunnel"}
+	cases := []struct {
+		err    error
+		class  Class
+		reason string
+	}{
+		{err: nil, class: "", reason: ""},
+		{err: errors.New("timeout"), class: Transient, reason: "TransientError"},
+		{err: apierrors.NewConflict(resource, "node1", errors.New("stale")), class: Conflict, reason: "Conflict"},
+		{err: apierrors.NewAlreadyExists(resource, "node1"), class: Conflict, reason: "Conflict"},
+		{err: apierrors.NewInvalid(kind, "node1", nil), class: Invalid, reason: "Invalid"},
+		{err: apierrors.NewTooManyRequests("throttled", 1), class: Transient, reason: "TransientError"},
+		{err: New(External, errors.New("file exists")), class: External, reason: "ExternalError"},
+		// the outermost class wins
+		{err: fmt.Errorf("failed to add route: %w", New(External, New(Invalid, errors.New("bad")))), class: External, reason: "ExternalError"},
+		{err: Newf(Invalid, "invalid destination subnet %s", "a"), class: Invalid, reason: "Invalid"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.class, ClassOf(c.err), "%v", c.err)
+		assert.Equal(t, c.reason, Reason(c.err), "%v", c.err)
+		assert.Equal(t, c.err != nil && c.class != Invalid, IsRetryable(c.err), "%v", c.err)
+	}
+	assert.Nil(t, New(External, nil))
+
+	inner := errors.New("file exists")
+	assert.True(t, errors.Is(New(External, inner), inner))
+	assert.Equal(t, "file exists", New(External, inner).Error())
+}
+
+func TestReconciler(t *testing.T) {
+	var err error
+	r := NewReconciler("test", reconcile.Func(func(context.Context, reconcile.Request) (reconcile.Result, error) {
+		return reconcile.Result{}, err
+	}))
+	ctx := context.Background()
+
+	res, got := r.Reconcile(ctx, reconcile.Request{})
+	assert.NoError(t, got)
+	assert.Equal(t, reconcile.Result{}, res)
+
+	err = apierrors.NewConflict(schema.GroupResource{Resource: "egresstunnels"}, "node1", errors.New("stale"))
+	res, got = r.Reconcile(ctx, reconcile.Request{})
+	assert.NoError(t, got)
+	assert.True(t, res.Requeue)
+
+	err = New(Invalid, errors.New("bad"))
+	_, got = r.Reconcile(ctx, reconcile.Request{})
+	assert.True(t, errors.Is(got, reconcile.TerminalError(nil)))
+
+	err = New(External, errors.New("file exists"))
+	_, got = r.Reconcile(ctx, reconcile.Request{})
+	assert.Equal(t, err, got)
+}
@@ -0,0 +1,17 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package errors
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var counterErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "egress_reconcile_errors_total",
+	Help: "Number of errors returned by the reconciles of the controller by the class of the errors",
+}, []string{"controller", "class"})
+
+func MetricCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		counterErrors,
+	}
+}
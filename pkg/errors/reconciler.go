@@ -0,0 +1,40 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package errors
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+type reconciler struct {
+	name     string
+	upstream reconcile.Reconciler
+}
+
+// NewReconciler returns a reconcile wrapper that counts the errors of the upstream by
+// their classes and retries them by the class: the Conflict errors are requeued by the
+// rate limiter without being logged as errors, the Invalid errors are terminal, and the
+// others are retried with backoff. The name is the controller name used as the label of
+// the metrics.
+func NewReconciler(name string, upstream reconcile.Reconciler) reconcile.Reconciler {
+	return &reconciler{name: name, upstream: upstream}
+}
+
+func (r *reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	res, err := r.upstream.Reconcile(ctx, req)
+	if err == nil {
+		return res, nil
+	}
+	class := ClassOf(err)
+	counterErrors.WithLabelValues(r.name, string(class)).Inc()
+	switch class {
+	case Conflict:
+		return reconcile.Result{Requeue: true}, nil
+	case Invalid:
+		return res, reconcile.TerminalError(err)
+	}
+	return res, err
+}
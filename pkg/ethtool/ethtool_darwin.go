@@ -7,3 +7,18 @@ package ethtool
 func EthtoolTXOff(name string) error {
 	return nil
 }
+
+// EthtoolRXOff ethtool RX Off
+func EthtoolRXOff(name string) error {
+	return nil
+}
+
+// EthtoolGROOff ethtool GRO Off
+func EthtoolGROOff(name string) error {
+	return nil
+}
+
+// EthtoolGSOOff ethtool GSO Off
+func EthtoolGSOOff(name string) error {
+	return nil
+}
@@ -40,6 +40,26 @@ func ioctlEthtool(fd int, argp *IFReqData) error {
 
 // EthtoolTXOff disables the TX checksum offload on the specified interface
 func EthtoolTXOff(name string) error {
+	return ethtoolOff(name, unix.ETHTOOL_GTXCSUM, unix.ETHTOOL_STXCSUM)
+}
+
+// EthtoolRXOff disables the RX checksum offload on the specified interface
+func EthtoolRXOff(name string) error {
+	return ethtoolOff(name, unix.ETHTOOL_GRXCSUM, unix.ETHTOOL_SRXCSUM)
+}
+
+// EthtoolGROOff disables the generic receive offload on the specified interface
+func EthtoolGROOff(name string) error {
+	return ethtoolOff(name, unix.ETHTOOL_GGRO, unix.ETHTOOL_SGRO)
+}
+
+// EthtoolGSOOff disables the generic segmentation offload on the specified interface
+func EthtoolGSOOff(name string) error {
+	return ethtoolOff(name, unix.ETHTOOL_GGSO, unix.ETHTOOL_SGSO)
+}
+
+// ethtoolOff gets the feature by getCmd, and turns it off by setCmd if it is on
+func ethtoolOff(name string, getCmd, setCmd uint32) error {
 	if len(name)+1 > unix.IFNAMSIZ {
 		return fmt.Errorf("name too long")
 	}
@@ -82,7 +102,7 @@ func EthtoolTXOff(name string) error {
 	value := (*EthtoolValue)(valueUPtr)
 
 	// Get the current value, so we only set it if it needs to change.
-	*value = EthtoolValue{Cmd: unix.ETHTOOL_GTXCSUM}
+	*value = EthtoolValue{Cmd: getCmd}
 	request := IFReqData{Data: uintptr(valueUPtr)}
 	copy(request.Name[:], name)
 	if err := ioctlEthtool(socket, &request); err != nil {
@@ -93,6 +113,6 @@ func EthtoolTXOff(name string) error {
 	}
 
 	// Set the value.
-	*value = EthtoolValue{Cmd: unix.ETHTOOL_STXCSUM, Data: 0 /* off */}
+	*value = EthtoolValue{Cmd: setCmd, Data: 0 /* off */}
 	return ioctlEthtool(socket, &request)
 }
@@ -0,0 +1,165 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package geoip
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Database compiles the countries and the ASNs to CIDRs by the GeoLite2/GeoIP2 Country
+// (or City) database and the ASN database, either of them is optional
+type Database struct {
+	country *Reader
+	asn     *Reader
+}
+
+// Open reads the databases of the paths, the empty paths are skipped
+func Open(countryPath, asnPath string) (*Database, error) {
+	db := &Database{}
+	var err error
+	if countryPath != "" {
+		if db.country, err = open(countryPath); err != nil {
+			return nil, err
+		}
+	}
+	if asnPath != "" {
+		if db.asn, err = open(asnPath); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+func open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Countries returns the CIDRs of the countries by their ISO 3166-1 alpha-2 codes, such as `US`
+func (db *Database) Countries(codes []string) ([]string, error) {
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	if db.country == nil {
+		return nil, fmt.Errorf("no country database")
+	}
+	set := make(map[string]struct{}, len(codes))
+	for _, code := range codes {
+		set[strings.ToUpper(code)] = struct{}{}
+	}
+	return selectNetworks(db.country, func(val interface{}) bool {
+		record, _ := val.(map[string]interface{})
+		country, _ := record["country"].(map[string]interface{})
+		code, _ := country["iso_code"].(string)
+		_, ok := set[code]
+		return ok
+	})
+}
+
+// ASNs returns the CIDRs announced by the autonomous systems
+func (db *Database) ASNs(asns []int64) ([]string, error) {
+	if len(asns) == 0 {
+		return nil, nil
+	}
+	if db.asn == nil {
+		return nil, fmt.Errorf("no ASN database")
+	}
+	set := make(map[uint64]struct{}, len(asns))
+	for _, asn := range asns {
+		set[uint64(asn)] = struct{}{}
+	}
+	return selectNetworks(db.asn, func(val interface{}) bool {
+		record, _ := val.(map[string]interface{})
+		asn, _ := record["autonomous_system_number"].(uint64)
+		_, ok := set[asn]
+		return ok
+	})
+}
+
+// selectNetworks returns the aggregated CIDRs of the networks whose data matches
+func selectNetworks(r *Reader, match func(val interface{}) bool) ([]string, error) {
+	matched := make(map[uint64]bool)
+	list := make([]*net.IPNet, 0)
+	err := r.Networks(func(ipNet *net.IPNet, offset uint64) error {
+		ok, found := matched[offset]
+		if !found {
+			val, err := r.Decode(offset)
+			if err != nil {
+				return err
+			}
+			ok = match(val)
+			matched[offset] = ok
+		}
+		if ok {
+			list = append(list, ipNet)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return aggregate(list), nil
+}
+
+// aggregate merges the adjacent networks and drops the covered ones
+func aggregate(list []*net.IPNet) []string {
+	sort.Slice(list, func(i, j int) bool {
+		if len(list[i].IP) != len(list[j].IP) {
+			return len(list[i].IP) < len(list[j].IP)
+		}
+		if c := bytes.Compare(list[i].IP, list[j].IP); c != 0 {
+			return c < 0
+		}
+		onesI, _ := list[i].Mask.Size()
+		onesJ, _ := list[j].Mask.Size()
+		return onesI < onesJ
+	})
+
+	stack := make([]*net.IPNet, 0, len(list))
+	for _, item := range list {
+		if n := len(stack); n > 0 && len(stack[n-1].IP) == len(item.IP) && stack[n-1].Contains(item.IP) {
+			continue
+		}
+		stack = append(stack, item)
+		for len(stack) >= 2 {
+			parent, ok := siblings(stack[len(stack)-2], stack[len(stack)-1])
+			if !ok {
+				break
+			}
+			stack = append(stack[:len(stack)-2], parent)
+		}
+	}
+
+	res := make([]string, 0, len(stack))
+	for _, item := range stack {
+		res = append(res, item.String())
+	}
+	return res
+}
+
+// siblings returns the parent of the networks if they are the two halves of it
+func siblings(a, b *net.IPNet) (*net.IPNet, bool) {
+	onesA, bits := a.Mask.Size()
+	onesB, _ := b.Mask.Size()
+	if len(a.IP) != len(b.IP) || onesA != onesB || onesA == 0 {
+		return nil, false
+	}
+	mask := net.CIDRMask(onesA-1, bits)
+	if !a.IP.Mask(mask).Equal(b.IP.Mask(mask)) || a.IP.Equal(b.IP) {
+		return nil, false
+	}
+	return &net.IPNet{IP: a.IP.Mask(mask), Mask: mask}, true
+}
@@ -0,0 +1,202 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package geoip
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testDB writes a MaxMind DB with the record size 24
+type testDB struct {
+	ipVersion int
+	nodes     [][2]uint64
+	refs      [][2]int // -1 empty, 0 node, 1 data
+	data      bytes.Buffer
+}
+
+func newTestDB(ipVersion int) *testDB {
+	db := &testDB{ipVersion: ipVersion}
+	db.newNode()
+	return db
+}
+
+func (db *testDB) newNode() uint64 {
+	db.nodes = append(db.nodes, [2]uint64{})
+	db.refs = append(db.refs, [2]int{-1, -1})
+	return uint64(len(db.nodes) - 1)
+}
+
+// insert sets the child of the path of the network to the data offset or the node
+func (db *testDB) insert(cidr string, kind int, val uint64) {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	ones, bits := ipNet.Mask.Size()
+	ip = ip.Mask(ipNet.Mask)
+	if db.ipVersion == 6 && bits == 32 {
+		ip = append(make(net.IP, 12), ip...)
+		ones += 96
+	} else if bits == 32 {
+		ip = ip.To4()
+	}
+	node := uint64(0)
+	for i := 0; i < ones; i++ {
+		bit := ip[i/8] >> (7 - i%8) & 1
+		if i == ones-1 {
+			db.nodes[node][bit], db.refs[node][bit] = val, kind
+			return
+		}
+		if db.refs[node][bit] != 0 {
+			next := db.newNode()
+			db.nodes[node][bit], db.refs[node][bit] = next, 0
+		}
+		node = db.nodes[node][bit]
+	}
+}
+
+func (db *testDB) node(cidr string) uint64 {
+	_, ipNet, _ := net.ParseCIDR(cidr)
+	ones, _ := ipNet.Mask.Size()
+	node := uint64(0)
+	for i := 0; i < ones; i++ {
+		bit := ipNet.IP[i/8] >> (7 - i%8) & 1
+		node = db.nodes[node][bit]
+	}
+	return node
+}
+
+func (db *testDB) add(cidr string, val interface{}) uint64 {
+	offset := uint64(db.data.Len())
+	encode(&db.data, val)
+	db.insert(cidr, 1, offset)
+	return offset
+}
+
+func (db *testDB) bytes() []byte {
+	buf := bytes.Buffer{}
+	count := uint64(len(db.nodes))
+	for i, node := range db.nodes {
+		for bit := 0; bit < 2; bit++ {
+			val := count
+			switch db.refs[i][bit] {
+			case 0:
+				val = node[bit]
+			case 1:
+				val = count + dataSectionSeparator + node[bit]
+			}
+			buf.Write([]byte{byte(val >> 16), byte(val >> 8), byte(val)})
+		}
+	}
+	buf.Write(make([]byte, dataSectionSeparator))
+	buf.Write(db.data.Bytes())
+	buf.Write(metadataMarker)
+	encode(&buf, map[string]interface{}{
+		"node_count":    uint64(count),
+		"record_size":   uint64(24),
+		"ip_version":    uint64(db.ipVersion),
+		"database_type": "Test",
+	})
+	return buf.Bytes()
+}
+
+type pointer uint64
+
+func encode(buf *bytes.Buffer, val interface{}) {
+	switch val := val.(type) {
+	case string:
+		buf.WriteByte(typeString<<5 | byte(len(val)))
+		buf.WriteString(val)
+	case uint64:
+		b := []byte{byte(val >> 24), byte(val >> 16), byte(val >> 8), byte(val)}
+		for len(b) > 0 && b[0] == 0 {
+			b = b[1:]
+		}
+		buf.WriteByte(typeUint32<<5 | byte(len(b)))
+		buf.Write(b)
+	case pointer:
+		buf.WriteByte(typePointer<<5 | byte(val>>8&0x7))
+		buf.WriteByte(byte(val))
+	case map[string]interface{}:
+		buf.WriteByte(typeMap<<5 | byte(len(val)))
+		keys := make([]string, 0, len(val))
+		for key := range val {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			encode(buf, key)
+			encode(buf, val[key])
+		}
+	}
+}
+
+func country(code string) map[string]interface{} {
+	return map[string]interface{}{"country": map[string]interface{}{"iso_code": code}}
+}
+
+func writeFile(t *testing.T, name string, buf []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, buf, 0600))
+	return path
+}
+
+func TestCountries(t *testing.T) {
+	db := newTestDB(6)
+	us := db.add("1.1.1.0/24", country("US"))
+	db.add("1.1.0.0/24", country("AU"))
+	db.add("1.1.2.0/24", map[string]interface{}{"country": pointer(us + 9)})
+	db.add("1.1.3.0/24", country("US"))
+	db.add("2001:db8::/32", country("US"))
+	db.add("2001:db9::/32", country("CN"))
+	// the IPv4 networks are also in ::ffff:0:0/96
+	db.insert("::ffff:0:0/96", 0, db.node("::/96"))
+
+	r, err := NewReader(db.bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(6), r.Metadata.IPVersion)
+	assert.Equal(t, "Test", r.Metadata.DatabaseType)
+
+	geo, err := Open(writeFile(t, "country.mmdb", db.bytes()), "")
+	assert.NoError(t, err)
+	list, err := geo.Countries([]string{"us"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.1.1.0/24", "1.1.2.0/23", "2001:db8::/32"}, list)
+
+	list, err = geo.Countries([]string{"AU", "CN"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.1.0.0/24", "2001:db9::/32"}, list)
+
+	_, err = geo.ASNs([]int64{15169})
+	assert.Error(t, err)
+}
+
+func TestASNs(t *testing.T) {
+	db := newTestDB(4)
+	db.add("8.8.8.0/24", map[string]interface{}{"autonomous_system_number": uint64(15169)})
+	db.add("8.8.4.0/24", map[string]interface{}{"autonomous_system_number": uint64(15169)})
+	db.add("1.0.0.0/24", map[string]interface{}{"autonomous_system_number": uint64(13335)})
+
+	geo, err := Open("", writeFile(t, "asn.mmdb", db.bytes()))
+	assert.NoError(t, err)
+	list, err := geo.ASNs([]int64{15169})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"8.8.4.0/24", "8.8.8.0/24"}, list)
+
+	list, err = geo.ASNs([]int64{64512})
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestNewReaderInvalid(t *testing.T) {
+	_, err := NewReader([]byte("not a database"))
+	assert.Error(t, err)
+}
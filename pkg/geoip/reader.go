@@ -0,0 +1,336 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package geoip reads the MaxMind DB files, such as the GeoLite2 Country and ASN
+// databases, to compile the countries and the ASNs to CIDRs.
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+)
+
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+const dataSectionSeparator = 16
+
+// Metadata is the metadata of the database
+type Metadata struct {
+	DatabaseType string
+	BuildEpoch   uint64
+	IPVersion    uint64
+	NodeCount    uint64
+	RecordSize   uint64
+}
+
+// Reader reads a MaxMind DB, see https://maxmind.github.io/MaxMind-DB/
+type Reader struct {
+	Metadata Metadata
+
+	tree      []byte
+	data      []byte
+	ipv4Start uint64
+}
+
+// NewReader parses the MaxMind DB in buf
+func NewReader(buf []byte) (*Reader, error) {
+	i := bytes.LastIndex(buf, metadataMarker)
+	if i < 0 {
+		return nil, errors.New("invalid MaxMind DB: metadata not found")
+	}
+	d := decoder{buf: buf[i+len(metadataMarker):]}
+	val, _, err := d.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MaxMind DB metadata: %w", err)
+	}
+	meta, ok := val.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid MaxMind DB metadata: not a map")
+	}
+	r := &Reader{}
+	r.Metadata.DatabaseType, _ = meta["database_type"].(string)
+	r.Metadata.BuildEpoch, _ = meta["build_epoch"].(uint64)
+	r.Metadata.IPVersion, _ = meta["ip_version"].(uint64)
+	r.Metadata.NodeCount, _ = meta["node_count"].(uint64)
+	r.Metadata.RecordSize, _ = meta["record_size"].(uint64)
+
+	switch r.Metadata.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported MaxMind DB record size %d", r.Metadata.RecordSize)
+	}
+	if r.Metadata.IPVersion != 4 && r.Metadata.IPVersion != 6 {
+		return nil, fmt.Errorf("unsupported MaxMind DB ip version %d", r.Metadata.IPVersion)
+	}
+	treeSize := r.Metadata.NodeCount * r.Metadata.RecordSize / 4
+	if treeSize+dataSectionSeparator > uint64(i) {
+		return nil, errors.New("invalid MaxMind DB: search tree out of range")
+	}
+	r.tree = buf[:treeSize]
+	r.data = buf[treeSize+dataSectionSeparator : i]
+
+	// the IPv4 addresses are in ::/96 of the IPv6 databases
+	if r.Metadata.IPVersion == 6 {
+		node := uint64(0)
+		for j := 0; j < 96 && node < r.Metadata.NodeCount; j++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// record returns the left (0) or the right (1) record of the node
+func (r *Reader) record(node uint64, bit uint) uint64 {
+	switch r.Metadata.RecordSize {
+	case 24:
+		off := node*6 + uint64(bit)*3
+		b := r.tree[off : off+3]
+		return uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2])
+	case 28:
+		b := r.tree[node*7 : node*7+7]
+		if bit == 0 {
+			return uint64(b[3]&0xF0)<<20 | uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2])
+		}
+		return uint64(b[3]&0x0F)<<24 | uint64(b[4])<<16 | uint64(b[5])<<8 | uint64(b[6])
+	default:
+		off := node*8 + uint64(bit)*4
+		return uint64(binary.BigEndian.Uint32(r.tree[off : off+4]))
+	}
+}
+
+// Networks calls fn with each network in the database and the offset of its data, which
+// is shared by the networks of the same data. The IPv4 networks of the IPv6 databases are
+// passed as IPv4, and their aliases such as ::ffff:0:0/96 are skipped
+func (r *Reader) Networks(fn func(ipNet *net.IPNet, offset uint64) error) error {
+	bits := 128
+	if r.Metadata.IPVersion == 4 {
+		bits = 32
+	}
+	return r.walk(0, make(net.IP, bits/8), 0, fn)
+}
+
+func (r *Reader) walk(node uint64, ip net.IP, depth int, fn func(*net.IPNet, uint64) error) error {
+	count := r.Metadata.NodeCount
+	bits := len(ip) * 8
+	for bit := uint(0); bit < 2; bit++ {
+		next := make(net.IP, len(ip))
+		copy(next, ip)
+		if bit == 1 {
+			next[depth/8] |= 0x80 >> (depth % 8)
+		}
+		child := r.record(node, bit)
+		switch {
+		case child < count:
+			if depth+1 >= bits {
+				return errors.New("invalid MaxMind DB: search tree too deep")
+			}
+			if child == r.ipv4Start && r.ipv4Start != 0 && !(depth+1 == 96 && next.Equal(net.IPv6zero)) {
+				continue
+			}
+			if err := r.walk(child, next, depth+1, fn); err != nil {
+				return err
+			}
+		case child == count:
+			// no data
+		default:
+			offset := child - count - dataSectionSeparator
+			if offset >= uint64(len(r.data)) {
+				return errors.New("invalid MaxMind DB: data pointer out of range")
+			}
+			if err := fn(network(next, depth+1), offset); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func network(ip net.IP, ones int) *net.IPNet {
+	if len(ip) == net.IPv6len && ones >= 96 && ip[:12].Equal(make(net.IP, 12)) {
+		return &net.IPNet{IP: net.IP(ip[12:]), Mask: net.CIDRMask(ones-96, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(ones, len(ip)*8)}
+}
+
+// Decode decodes the data at the offset passed by Networks
+func (r *Reader) Decode(offset uint64) (interface{}, error) {
+	d := decoder{buf: r.data}
+	val, _, err := d.decode(offset)
+	return val, err
+}
+
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+type decoder struct {
+	buf []byte
+}
+
+var errOutOfRange = errors.New("unexpected end of data")
+
+// decode decodes the value at the offset, and returns the offset after it
+func (d *decoder) decode(offset uint64) (interface{}, uint64, error) {
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ == typePointer {
+		val, _, err := d.decode(size)
+		return val, offset, err
+	}
+	return d.value(typ, size, offset)
+}
+
+// control decodes the control bytes, the size of a pointer is the offset it points to
+func (d *decoder) control(offset uint64) (int, uint64, uint64, error) {
+	if offset >= uint64(len(d.buf)) {
+		return 0, 0, 0, errOutOfRange
+	}
+	ctrl := d.buf[offset]
+	offset++
+	typ := int(ctrl >> 5)
+	if typ == typePointer {
+		n := uint64(ctrl>>3&0x3) + 1
+		b, err := d.bytes(offset, n)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		var ptr uint64
+		if n < 4 {
+			ptr = uint64(ctrl & 0x7)
+		}
+		for _, v := range b {
+			ptr = ptr<<8 | uint64(v)
+		}
+		switch n {
+		case 2:
+			ptr += 2048
+		case 3:
+			ptr += 526336
+		}
+		return typ, ptr, offset + n, nil
+	}
+	if typ == typeExtended {
+		if offset >= uint64(len(d.buf)) {
+			return 0, 0, 0, errOutOfRange
+		}
+		typ = 7 + int(d.buf[offset])
+		offset++
+	}
+	size := uint64(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		b, err := d.bytes(offset, n)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		size = 0
+		for _, v := range b {
+			size = size<<8 | uint64(v)
+		}
+		size += [...]uint64{29, 285, 65821}[n-1]
+		offset += n
+	}
+	return typ, size, offset, nil
+}
+
+func (d *decoder) bytes(offset, n uint64) ([]byte, error) {
+	if offset+n > uint64(len(d.buf)) {
+		return nil, errOutOfRange
+	}
+	return d.buf[offset : offset+n], nil
+}
+
+func (d *decoder) uint(offset, size uint64) (uint64, error) {
+	b, err := d.bytes(offset, size)
+	if err != nil {
+		return 0, err
+	}
+	val := uint64(0)
+	for _, v := range b {
+		val = val<<8 | uint64(v)
+	}
+	return val, nil
+}
+
+func (d *decoder) value(typ int, size, offset uint64) (interface{}, uint64, error) {
+	switch typ {
+	case typeString:
+		b, err := d.bytes(offset, size)
+		return string(b), offset + size, err
+	case typeBytes:
+		b, err := d.bytes(offset, size)
+		return append([]byte(nil), b...), offset + size, err
+	case typeDouble:
+		val, err := d.uint(offset, 8)
+		return math.Float64frombits(val), offset + 8, err
+	case typeFloat:
+		val, err := d.uint(offset, 4)
+		return float64(math.Float32frombits(uint32(val))), offset + 4, err
+	case typeUint16, typeUint32, typeUint64:
+		val, err := d.uint(offset, size)
+		return val, offset + size, err
+	case typeInt32:
+		val, err := d.uint(offset, size)
+		return int64(int32(uint32(val))), offset + size, err
+	case typeUint128:
+		b, err := d.bytes(offset, size)
+		return new(big.Int).SetBytes(b), offset + size, err
+	case typeBool:
+		return size != 0, offset, nil
+	case typeMap:
+		res := make(map[string]interface{}, size)
+		for i := uint64(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			val, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			res[k] = val
+			offset = next
+		}
+		return res, offset, nil
+	case typeArray:
+		res := make([]interface{}, 0, size)
+		for i := uint64(0); i < size; i++ {
+			val, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			res = append(res, val)
+			offset = next
+		}
+		return res, offset, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+	}
+}
@@ -34,6 +34,9 @@ type Interface interface {
 	TestEntry(entry string, set string) (bool, error)
 	// ListEntries lists all the entries from a named set
 	ListEntries(set string) ([]string, error)
+	// ListEntryCounters lists the packet and byte counters of the entries from a named set
+	// created with counters
+	ListEntryCounters(set string) (map[string]EntryCounters, error)
 	// ListSets list all set names from kernel
 	ListSets() ([]string, error)
 	// GetVersion returns the "X.Y" version string for ipset.
@@ -79,6 +82,14 @@ type IPSet struct {
 	PortRange string
 	// comment message for ipset
 	Comment string
+	// Counters enables the packet and byte counters of the entries
+	Counters bool
+}
+
+// EntryCounters is the counters of an entry of the set created with counters
+type EntryCounters struct {
+	Packets uint64
+	Bytes   uint64
 }
 
 // Validate checks if a given ipset is valid or not.
@@ -293,6 +304,9 @@ func (runner *runner) createSet(set *IPSet, ignoreExistErr bool) error {
 	if set.SetType == BitmapPort {
 		args = append(args, "range", set.PortRange)
 	}
+	if set.Counters {
+		args = append(args, "counters")
+	}
 	if ignoreExistErr {
 		args = append(args, "-exist")
 	}
@@ -389,12 +403,56 @@ func (runner *runner) ListEntries(set string) ([]string, error) {
 	results := make([]string, 0)
 	for i := range strs {
 		if len(strs[i]) > 0 {
-			results = append(results, strs[i])
+			// the entries of the set with counters are followed by "packets 0 bytes 0"
+			results = append(results, strings.Fields(strs[i])[0])
 		}
 	}
 	return results, nil
 }
 
+// ListEntryCounters lists the counters of the entries from a named set.
+func (runner *runner) ListEntryCounters(set string) (map[string]EntryCounters, error) {
+	if len(set) == 0 {
+		return nil, fmt.Errorf("set name can't be nil")
+	}
+	out, err := runner.exec.Command(IPSetCmd, "list", set).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("error listing set: %s, error: %v (%v)", set, err, string(out))
+	}
+	return parseEntryCounters(string(out)), nil
+}
+
+// parseEntryCounters parses the members of the raw output of `ipset list {set}`, which
+// are similar to,
+// Members:
+// 10.6.0.0/16 packets 12 bytes 1008
+func parseEntryCounters(out string) map[string]EntryCounters {
+	memberMatcher := regexp.MustCompile(EntryMemberPattern)
+	list := memberMatcher.ReplaceAllString(out, "")
+	res := make(map[string]EntryCounters)
+	for _, line := range strings.Split(list, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		counters := EntryCounters{}
+		for i := 1; i+1 < len(fields); i += 2 {
+			v, err := strconv.ParseUint(fields[i+1], 10, 64)
+			if err != nil {
+				continue
+			}
+			switch fields[i] {
+			case "packets":
+				counters.Packets = v
+			case "bytes":
+				counters.Bytes = v
+			}
+		}
+		res[fields[0]] = counters
+	}
+	return res
+}
+
 // GetVersion returns the version string.
 func (runner *runner) GetVersion() (string, error) {
 	return getIPSetVersionString(runner.exec)
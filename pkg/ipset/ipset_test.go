@@ -0,0 +1,28 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEntryCounters(t *testing.T) {
+	out := `Name: egress-shd-v4-test
+Type: hash:net
+Revision: 7
+Header: family inet hashsize 1024 maxelem 65536 counters
+Size in memory: 536
+References: 1
+Number of entries: 2
+Members:
+10.6.0.0/16 packets 12 bytes 1008
+1.1.1.1 packets 0 bytes 0
+`
+	assert.Equal(t, map[string]EntryCounters{
+		"10.6.0.0/16": {Packets: 12, Bytes: 1008},
+		"1.1.1.1":     {},
+	}, parseEntryCounters(out))
+}
@@ -121,6 +121,18 @@ func (f *FakeIPSet) ListEntries(set string) ([]string, error) {
 	return f.Entries[set].UnsortedList(), nil
 }
 
+// ListEntryCounters is part of interface. The counters of the fake entries are always 0.
+func (f *FakeIPSet) ListEntryCounters(set string) (map[string]ipset.EntryCounters, error) {
+	if f.Entries == nil {
+		return nil, fmt.Errorf("entries map can't be nil")
+	}
+	res := make(map[string]ipset.EntryCounters)
+	for _, entry := range f.Entries[set].UnsortedList() {
+		res[entry] = ipset.EntryCounters{}
+	}
+	return res, nil
+}
+
 // ListSets is part of interface.
 func (f *FakeIPSet) ListSets() ([]string, error) {
 	res := make([]string, 0)
@@ -145,17 +145,53 @@ func (g DNATAction) String() string {
 	return fmt.Sprintf("DNAT->%s:%d", g.DestAddr, g.DestPort)
 }
 
+// RedirectAction redirects the packets to the port of the local host
+type RedirectAction struct {
+	ToPort       uint16
+	TypeRedirect struct{}
+}
+
+func (g RedirectAction) ToFragment(features *Options) string {
+	return fmt.Sprintf("--jump REDIRECT --to-ports %d", g.ToPort)
+}
+
+func (g RedirectAction) String() string {
+	return fmt.Sprintf("Redirect->%d", g.ToPort)
+}
+
+// TProxyAction diverts the packets to the transparent socket on the port of the local host
+// without changing them, the packets are marked to be routed to the local host
+type TProxyAction struct {
+	OnPort     uint16
+	Mark       uint32
+	Mask       uint32
+	TypeTProxy struct{}
+}
+
+func (g TProxyAction) ToFragment(features *Options) string {
+	return fmt.Sprintf("--jump TPROXY --on-port %d --tproxy-mark %#x/%#x", g.OnPort, g.Mark, g.Mask)
+}
+
+func (g TProxyAction) String() string {
+	return fmt.Sprintf("TProxy->%d:%#x/%#x", g.OnPort, g.Mark, g.Mask)
+}
+
 type SNATAction struct {
-	ToAddr   string
-	TypeSNAT struct{}
+	ToAddr      string
+	RandomFully bool
+	Persistent  bool
+	TypeSNAT    struct{}
 }
 
 func (g SNATAction) ToFragment(features *Options) string {
-	fullyRand := ""
-	if features.SNATFullyRandom {
-		fullyRand = " --random-fully"
+	flags := ""
+	if g.RandomFully || features.SNATFullyRandom {
+		flags += " --random-fully"
 	}
-	return fmt.Sprintf("--jump SNAT --to-source %s%s", g.ToAddr, fullyRand)
+	if g.Persistent {
+		flags += " --persistent"
+	}
+	return fmt.Sprintf("--jump SNAT --to-source %s%s", g.ToAddr, flags)
 }
 
 func (g SNATAction) String() string {
@@ -163,13 +199,14 @@ func (g SNATAction) String() string {
 }
 
 type MasqAction struct {
-	ToPorts  string
-	TypeMasq struct{}
+	ToPorts     string
+	RandomFully bool
+	TypeMasq    struct{}
 }
 
 func (g MasqAction) ToFragment(features *Options) string {
 	fullyRand := ""
-	if features.MASQFullyRandom {
+	if g.RandomFully || features.MASQFullyRandom {
 		fullyRand = " --random-fully"
 	}
 	if g.ToPorts != "" {
@@ -222,6 +259,40 @@ func (c SetMaskedMarkAction) String() string {
 	return fmt.Sprintf("Set:%#x", c.Mark)
 }
 
+// SetClassAction sets the skb priority of the packets
+type SetClassAction struct {
+	Priority     uint32
+	TypeSetClass struct{}
+}
+
+func (c SetClassAction) ToFragment(features *Options) string {
+	return fmt.Sprintf("--jump CLASSIFY --set-class %x:%x", c.Priority>>16, c.Priority&0xffff)
+}
+
+func (c SetClassAction) String() string {
+	return fmt.Sprintf("SetClass:%x:%x", c.Priority>>16, c.Priority&0xffff)
+}
+
+// NflogAction sends the packets to the NFLOG group with the prefix, the prefix is
+// truncated to 63 bytes as the kernel does
+type NflogAction struct {
+	Group     uint16
+	Prefix    string
+	TypeNflog struct{}
+}
+
+func (n NflogAction) ToFragment(features *Options) string {
+	prefix := n.Prefix
+	if len(prefix) > 63 {
+		prefix = prefix[:63]
+	}
+	return fmt.Sprintf(`--jump NFLOG --nflog-group %d --nflog-prefix "%s"`, n.Group, prefix)
+}
+
+func (n NflogAction) String() string {
+	return fmt.Sprintf("Nflog:%d", n.Group)
+}
+
 type NoTrackAction struct {
 	TypeNoTrack struct{}
 }
@@ -0,0 +1,73 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package iptables
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+var (
+	// counterRegexp matches an append line of the iptables-save output with the counters,
+	// such as "[10:600] -A chain-name ...". It captures the packets, the bytes and the chain.
+	counterRegexp = regexp.MustCompile(`^\[(\d+):(\d+)\] -A (\S+)`)
+	// commentRegexp matches a comment of the rule, which is quoted if it has spaces
+	commentRegexp = regexp.MustCompile(`--comment (?:"([^"]*)"|(\S+))`)
+)
+
+// RuleCounters is the counters of a rule in the dataplane
+type RuleCounters struct {
+	// Comments is the comments of the rule, including the one of the hash
+	Comments []string
+	Packets  uint64
+	Bytes    uint64
+}
+
+// ReadCounters reads the counters of the rules of the chain from the dataplane, in the
+// order of the rules
+func (t *Table) ReadCounters(chainName string) ([]RuleCounters, error) {
+	cmd := t.newCmd(t.iptablesSaveCmd, "-c", "-t", t.Name)
+	countNumSaveCalls.Inc()
+	out, err := cmd.Output()
+	if err != nil {
+		countNumSaveErrors.Inc()
+		return nil, fmt.Errorf("failed to run %s: %w", t.iptablesSaveCmd, err)
+	}
+	return readCounters(bytes.NewReader(out), chainName)
+}
+
+// readCounters scans the iptables-save output with the counters for the rules of the chain
+func readCounters(r io.Reader, chainName string) ([]RuleCounters, error) {
+	res := make([]RuleCounters, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		captures := counterRegexp.FindSubmatch(line)
+		if captures == nil || string(captures[3]) != chainName {
+			continue
+		}
+		packets, err := strconv.ParseUint(string(captures[1]), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		bytes, err := strconv.ParseUint(string(captures[2]), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		item := RuleCounters{Packets: packets, Bytes: bytes}
+		for _, comment := range commentRegexp.FindAllSubmatch(line, -1) {
+			if len(comment[1]) > 0 {
+				item.Comments = append(item.Comments, string(comment[1]))
+			} else {
+				item.Comments = append(item.Comments, string(comment[2]))
+			}
+		}
+		res = append(res, item)
+	}
+	return res, scanner.Err()
+}
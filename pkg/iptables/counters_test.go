@@ -0,0 +1,33 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package iptables
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCounters(t *testing.T) {
+	out := `# Generated by iptables-save v1.8.7
+*mangle
+:PREROUTING ACCEPT [100:6000]
+:EGRESSGATEWAY-MARK-REQUEST - [0:0]
+[12:720] -A PREROUTING -m comment --comment "egw:hash1" -j EGRESSGATEWAY-MARK-REQUEST
+[5:300] -A EGRESSGATEWAY-MARK-REQUEST -m set --match-set egress-src-v4-abc src -m comment --comment egw:hash2 -m comment --comment "Set mark for EgressPolicy default-policy1" -j MARK --set-xmark 0x26000000/0xffffffff
+[0:0] -A EGRESSGATEWAY-MARK-REQUEST -m comment --comment "egw:hash3" -m comment --comment "Set mark for EgressPolicy policy2" -j MARK --set-xmark 0x26000001/0xffffffff
+COMMIT
+`
+	res, err := readCounters(strings.NewReader(out), "EGRESSGATEWAY-MARK-REQUEST")
+	assert.NoError(t, err)
+	assert.Equal(t, []RuleCounters{
+		{Comments: []string{"egw:hash2", "Set mark for EgressPolicy default-policy1"}, Packets: 5, Bytes: 300},
+		{Comments: []string{"egw:hash3", "Set mark for EgressPolicy policy2"}},
+	}, res)
+
+	res, err = readCounters(strings.NewReader(out), "EGRESSGATEWAY-SNAT-EIP")
+	assert.NoError(t, err)
+	assert.Empty(t, res)
+}
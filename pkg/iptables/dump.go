@@ -0,0 +1,50 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package iptables
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// ReadRules reads the rules written by the table from the dataplane, in the order of the
+// rules. The rules are normalized without the hash comments, so they're the same on the
+// nodes with the same datapath.
+func (t *Table) ReadRules() ([]string, error) {
+	cmd := t.newCmd(t.iptablesSaveCmd, "-t", t.Name)
+	countNumSaveCalls.Inc()
+	out, err := cmd.Output()
+	if err != nil {
+		countNumSaveErrors.Inc()
+		return nil, fmt.Errorf("failed to run %s: %w", t.iptablesSaveCmd, err)
+	}
+	return readRules(bytes.NewReader(out), t.hashCommentRegexp)
+}
+
+// readRules scans the iptables-save output for the append lines with the hash comments,
+// and returns them without the hash comments
+func readRules(r io.Reader, hashCommentRegexp *regexp.Regexp) ([]string, error) {
+	res := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !appendRegexp.MatchString(line) {
+			continue
+		}
+		loc := hashCommentRegexp.FindStringIndex(line)
+		if loc == nil {
+			continue
+		}
+		start := loc[0]
+		if strings.HasSuffix(line[:start], "-m comment ") {
+			start -= len("-m comment ")
+		}
+		res = append(res, strings.TrimSpace(line[:start]+strings.TrimPrefix(line[loc[1]:], " ")))
+	}
+	return res, scanner.Err()
+}
@@ -0,0 +1,30 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package iptables
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadRules(t *testing.T) {
+	out := `# Generated by iptables-save v1.8.7
+*mangle
+:PREROUTING ACCEPT [0:0]
+:EGRESSGATEWAY-MARK-REQUEST - [0:0]
+-A PREROUTING -m comment --comment "egw:v2:hash1" -j EGRESSGATEWAY-MARK-REQUEST
+-A PREROUTING -j CILIUM_PRE_mangle
+-A EGRESSGATEWAY-MARK-REQUEST -m set --match-set egress-src-v4-abc src -m comment --comment egw:v2:hash2 -m comment --comment "Set mark for EgressPolicy default-policy1" -j MARK --set-xmark 0x26000001/0xffffffff
+COMMIT
+`
+	res, err := readRules(strings.NewReader(out), regexp.MustCompile(`--comment "?(egw:v2:)([a-zA-Z0-9_-]+)"?`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"-A PREROUTING -j EGRESSGATEWAY-MARK-REQUEST",
+		`-A EGRESSGATEWAY-MARK-REQUEST -m set --match-set egress-src-v4-abc src -m comment --comment "Set mark for EgressPolicy default-policy1" -j MARK --set-xmark 0x26000001/0xffffffff`,
+	}, res)
+}
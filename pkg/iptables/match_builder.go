@@ -149,6 +149,24 @@ func (m MatchCriteria) NotConntrackState(stateNames string) MatchCriteria {
 	return append(m, fmt.Sprintf("-m conntrack ! --ctstate %s", stateNames))
 }
 
+// ConnLimitAbove matches when the number of connections of the source group is above
+// the limit, the sources are grouped by the prefix length of the mask
+func (m MatchCriteria) ConnLimitAbove(limit int, mask int) MatchCriteria {
+	return append(m, fmt.Sprintf("-m connlimit --connlimit-above %d --connlimit-mask %d", limit, mask))
+}
+
+// HashLimitAbove matches when the rate of the packets in the named bucket is above the
+// limit per second, all packets share one bucket
+func (m MatchCriteria) HashLimitAbove(name string, limit, burst int) MatchCriteria {
+	return append(m, fmt.Sprintf("-m hashlimit --hashlimit-above %d/sec --hashlimit-burst %d --hashlimit-name %s",
+		limit, burst, name))
+}
+
+// Probability matches the packets randomly with the probability in (0, 1)
+func (m MatchCriteria) Probability(probability float64) MatchCriteria {
+	return append(m, fmt.Sprintf("-m statistic --mode random --probability %.4f", probability))
+}
+
 func (m MatchCriteria) Protocol(name string) MatchCriteria {
 	return append(m, fmt.Sprintf("-p %s", name))
 }
@@ -309,10 +327,22 @@ const (
 	DirectionReply    Direction = "REPLY"
 )
 
+// SocketTransparent matches the packets of the local transparent sockets, such as the ones of
+// the connections diverted by TPROXY
+func (m MatchCriteria) SocketTransparent() MatchCriteria {
+	return append(m, "-m socket --transparent")
+}
+
 func (m MatchCriteria) CTDirectionOriginal(direction Direction) MatchCriteria {
 	return append(m, fmt.Sprintf("-m conntrack --ctdir %s", direction))
 }
 
+// CTReplySrcPort matches the connections whose replies are from the port, such as the ones
+// redirected to the port
+func (m MatchCriteria) CTReplySrcPort(port uint16) MatchCriteria {
+	return append(m, fmt.Sprintf("-m conntrack --ctreplsrcport %d", port))
+}
+
 // VXLANVNI matches on the VNI contained within the VXLAN header.  It assumes that this is indeed a VXLAN
 // packet; i.e. it should be used with a protocol==UDP and port==VXLAN port match.
 //
@@ -26,6 +26,7 @@ import (
 	"os/exec"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -179,12 +180,17 @@ type Options struct {
 	MASQFullyRandom          bool
 	RestoreSupportsLock      bool
 
+	// HistoricHashPrefixes are the hash prefixes of the rules written by the older
+	// versions, the rules are treated as ours, so they are replaced or cleaned up.
+	HistoricHashPrefixes []string
+
 	// LockTimeout is the timeout to use for iptables-restore's native xtables lock.
 	LockTimeout time.Duration
 	// LockProbeInterval is the probe interval to use for iptables-restore's native xtables lock.
 	LockProbeInterval time.Duration
 
-	// NewCmdOverride for tests, if non-nil, factory to use instead of the real exec.Command()
+	// NewCmdOverride for tests and the privileged helper, if non-nil, factory to use instead of
+	// the real exec.Command()
 	NewCmdOverride cmdshim.CmdFactory
 	// SleepOverride for tests, if non-nil, replacement for time.Sleep()
 	SleepOverride func(d time.Duration)
@@ -197,7 +203,17 @@ type Options struct {
 }
 
 func NewTable(name string, ipVersion uint8, hashPrefix string, options Options, log logr.Logger) (*Table, error) {
-	hashCommentRegexp := regexp.MustCompile(`--comment "?` + hashPrefix + `([a-zA-Z0-9_-]+)"?`)
+	// The current prefix comes first, the longer historic prefixes are tried before
+	// the shorter ones which may be a prefix of them.
+	hashPrefixes := []string{regexp.QuoteMeta(hashPrefix)}
+	historicHashPrefixes := append([]string{}, options.HistoricHashPrefixes...)
+	sort.Slice(historicHashPrefixes, func(i, j int) bool {
+		return len(historicHashPrefixes[i]) > len(historicHashPrefixes[j])
+	})
+	for _, prefix := range historicHashPrefixes {
+		hashPrefixes = append(hashPrefixes, regexp.QuoteMeta(prefix))
+	}
+	hashCommentRegexp := regexp.MustCompile(`--comment "?(` + strings.Join(hashPrefixes, "|") + `)([a-zA-Z0-9_-]+)"?`)
 	ourChainsPattern := "^(" + strings.Join(options.HistoricChainPrefixes, "|") + ")"
 	ourChainsRegexp := regexp.MustCompile(ourChainsPattern)
 
@@ -709,7 +725,12 @@ func (t *Table) readHashesAndRulesFrom(r io.ReadCloser) (hashes map[string][]str
 		hash := ""
 		captures = t.hashCommentRegexp.FindSubmatch(line)
 		if captures != nil {
-			hash = string(captures[1])
+			hash = string(captures[2])
+			if string(captures[1]) != t.hashCommentPrefix {
+				// The rule was written by an older version, keep the prefix, so the
+				// hash never matches the one of a current rule and it's rewritten.
+				hash = string(captures[1]) + hash
+			}
 			logCxt.V(1).Info("found hash in rule", "hash", hash)
 			chainHasCalicoRule.Add(chainName)
 		}
@@ -1147,6 +1168,30 @@ func (t *Table) desiredStateOfChain(chainName string) (chain *Chain, present boo
 	return
 }
 
+// RestoreCommand returns the iptables-restore binary of the table, e.g. iptables-nft-restore
+func (t *Table) RestoreCommand() string {
+	return t.iptablesRestoreCmd
+}
+
+// RenderRestore renders the iptables-restore input creating the chain, and inserting the
+// rules at the top of the base chain, with the rule-tracking comments of the table. So the
+// rules restored by others with --noflush, e.g. on the boot of the node, are taken over by
+// the table as its own ones.
+func (t *Table) RenderRestore(chain *Chain, baseChain string, inserts []Rule) string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "*%s\n", t.Name)
+	fmt.Fprintf(buf, ":%s - [0:0]\n", chain.Name)
+	for i, hash := range chain.RuleHashes(t.opt) {
+		fmt.Fprintln(buf, chain.Rules[i].RenderAppend(chain.Name, t.commentFrag(hash), t.opt))
+	}
+	hashes := calculateRuleHashes(baseChain, inserts, t.opt)
+	for i := range inserts {
+		fmt.Fprintln(buf, inserts[i].RenderInsertAtRuleNumber(baseChain, i+1, t.commentFrag(hashes[i]), t.opt))
+	}
+	fmt.Fprintln(buf, "COMMIT")
+	return buf.String()
+}
+
 func (t *Table) commentFrag(hash string) string {
 	return fmt.Sprintf(`-m comment --comment "%s%s"`, t.hashCommentPrefix, hash)
 }
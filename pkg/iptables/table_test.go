@@ -0,0 +1,107 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package iptables_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	"github.com/spidernet-io/egressgateway/pkg/iptables/testutils"
+)
+
+func TestTableCleansHistoricRules(t *testing.T) {
+	snat := iptables.Rule{
+		Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(0x26000000, 0xff000000),
+		Action: iptables.AcceptAction{},
+	}
+	opt := iptables.Options{
+		XTablesLock:           iptables.DummyLock{},
+		HistoricChainPrefixes: []string{"egw", "EGRESSGATEWAY-"},
+		HistoricHashPrefixes:  []string{"egw:-", "egw:"},
+	}
+	hash := (&iptables.Chain{Name: "EGRESSGATEWAY-SNAT-EIP", Rules: []iptables.Rule{snat}}).RuleHashes(&opt)[0]
+
+	dataplane := testutils.NewMockDataplane("nat", map[string][]string{
+		"PREROUTING":  {},
+		"INPUT":       {},
+		"OUTPUT":      {},
+		"POSTROUTING": {`-m comment --comment "egw:oldjumphash12" -j EGRESSGATEWAY-OLD`, "-j MASQUERADE"},
+		// the chain isn't used anymore
+		"EGRESSGATEWAY-OLD": {`-m comment --comment "egw:oldrulehash12" -j ACCEPT`},
+		// the rule is unchanged, but written by the older version
+		"EGRESSGATEWAY-SNAT-EIP": {`-m comment --comment "egw:` + hash + `" ` + snat.Match.Render() + " --jump ACCEPT"},
+	}, "legacy")
+	opt.NewCmdOverride = dataplane.NewCmd
+	opt.SleepOverride = dataplane.Sleep
+	opt.NowOverride = dataplane.Now
+	opt.LookPathOverride = func(file string) (string, error) { return file, nil }
+
+	table, err := iptables.NewTable("nat", 4, "egw:v2:", opt, logr.Discard())
+	assert.NoError(t, err)
+	table.UpdateChain(&iptables.Chain{Name: "EGRESSGATEWAY-SNAT-EIP", Rules: []iptables.Rule{snat}})
+	table.InsertOrAppendRules("POSTROUTING", []iptables.Rule{{
+		Action: iptables.JumpAction{Target: "EGRESSGATEWAY-SNAT-EIP"},
+	}})
+	_, err = table.Apply()
+	assert.NoError(t, err)
+
+	assert.NotContains(t, dataplane.Chains, "EGRESSGATEWAY-OLD")
+	assert.Len(t, dataplane.Chains["POSTROUTING"], 2)
+	assert.True(t, strings.HasPrefix(dataplane.Chains["POSTROUTING"][0], `-m comment --comment "egw:v2:`))
+	assert.Equal(t, "-j MASQUERADE", dataplane.Chains["POSTROUTING"][1])
+	assert.Equal(t, []string{`-m comment --comment "egw:v2:` + hash + `" ` + snat.Match.Render() + " --jump ACCEPT"},
+		dataplane.Chains["EGRESSGATEWAY-SNAT-EIP"])
+}
+
+func TestTableTakesOverRestoredRules(t *testing.T) {
+	chain := &iptables.Chain{Name: "EGRESSGATEWAY-FAIL-CLOSED", Rules: []iptables.Rule{{
+		Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(0x26000000, 0xff000000),
+		Action: iptables.DropAction{},
+	}}}
+	jump := []iptables.Rule{{Action: iptables.JumpAction{Target: "EGRESSGATEWAY-FAIL-CLOSED"}}}
+	newTable := func(dataplane *testutils.MockDataplane) *iptables.Table {
+		table, err := iptables.NewTable("filter", 4, "egw:v2:", iptables.Options{
+			XTablesLock:      iptables.DummyLock{},
+			NewCmdOverride:   dataplane.NewCmd,
+			SleepOverride:    dataplane.Sleep,
+			NowOverride:      dataplane.Now,
+			LookPathOverride: func(file string) (string, error) { return file, nil },
+		}, logr.Discard())
+		assert.NoError(t, err)
+		return table
+	}
+
+	// the rules are restored by others, e.g. on the boot of the node
+	chains := map[string][]string{"INPUT": {}, "OUTPUT": {}, "FORWARD": {"-j ACCEPT"}}
+	restore := newTable(testutils.NewMockDataplane("filter", nil, "legacy")).RenderRestore(chain, "FORWARD", jump)
+	lines := strings.Split(strings.TrimSpace(restore), "\n")
+	assert.Equal(t, []string{"*filter", ":EGRESSGATEWAY-FAIL-CLOSED - [0:0]"}, lines[:2])
+	assert.Equal(t, "COMMIT", lines[len(lines)-1])
+	for _, line := range lines[2 : len(lines)-1] {
+		fields := strings.SplitN(line, " ", 3)
+		switch fields[0] {
+		case "-A":
+			chains[fields[1]] = append(chains[fields[1]], fields[2])
+		case "-I":
+			rule := strings.SplitN(fields[2], " ", 2)[1]
+			chains[fields[1]] = testutils.PrependLine(chains[fields[1]], rule)
+		default:
+			t.Fatalf("unexpected line %q", line)
+		}
+	}
+
+	dataplane := testutils.NewMockDataplane("filter", chains, "legacy")
+	table := newTable(dataplane)
+	table.UpdateChain(chain)
+	table.InsertOrAppendRules("FORWARD", jump)
+	_, err := table.Apply()
+	assert.NoError(t, err)
+	assert.False(t, dataplane.ChainFlushed("EGRESSGATEWAY-FAIL-CLOSED"))
+	assert.False(t, dataplane.RuleTouched("FORWARD", 1))
+	assert.Len(t, dataplane.Chains["FORWARD"], 2)
+}
@@ -5,6 +5,8 @@ package v1beta1
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
 )
 
 // EgressClusterPolicyList contains a list of egress gateway policies
@@ -24,6 +26,7 @@ type EgressClusterPolicyList struct {
 // +kubebuilder:printcolumn:JSONPath=".status.eip.ipv4",description="ipv4",name="ipv4",type=string
 // +kubebuilder:printcolumn:JSONPath=".status.eip.ipv6",description="ipv6",name="ipv6",type=string
 // +kubebuilder:printcolumn:JSONPath=".status.node",description="egressTunnel",name="egressTunnel",type=string
+// +kubebuilder:printcolumn:JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description="ready",name="ready",type=string
 type EgressClusterPolicy struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata"`
@@ -42,8 +45,82 @@ type EgressClusterPolicySpec struct {
 	AppliedTo ClusterAppliedTo `json:"appliedTo"`
 	// +kubebuilder:validation:Optional
 	DestSubnet []string `json:"destSubnet"`
+	// DestSubnetFrom adds the CIDRs of the destination providers, the GeoIP selectors and
+	// the Services to destSubnet, the CIDRs are resolved by the controller to
+	// status.resolvedDestSubnet
+	// +kubebuilder:validation:Optional
+	DestSubnetFrom []DestSubnetSource `json:"destSubnetFrom,omitempty"`
 	// +kubebuilder:validation:Optional
 	Priority uint64 `json:"priority,omitempty"`
+	// Limits limits the connections of the policy on the gateway node
+	// +kubebuilder:validation:Optional
+	Limits *ConnectionLimits `json:"limits,omitempty"`
+	// Logging logs the new connections of the policy on the gateway node
+	// +kubebuilder:validation:Optional
+	Logging *PolicyLogging `json:"logging,omitempty"`
+	// ProxyProtocol relays the TCP connections of the policy to the destination ports
+	// through the gateway node, prepending the PROXY protocol v2 header with the Pod IP
+	// +kubebuilder:validation:Optional
+	ProxyProtocol *ProxyProtocol `json:"proxyProtocol,omitempty"`
+	// L7Proxy proxies the HTTP and SOCKS5 proxy requests of the policy to the destination
+	// ports by the gateway node, which connects to the requested targets from the EIP
+	// +kubebuilder:validation:Optional
+	L7Proxy *L7Proxy `json:"l7Proxy,omitempty"`
+	// Schedule limits the policy to be active only in the time windows, the policy is
+	// always active if it's not set
+	// +kubebuilder:validation:Optional
+	Schedule *PolicySchedule `json:"schedule,omitempty"`
+	// Mode is Enforce or Shadow. In the Shadow mode, the matched traffic is counted by
+	// destination rather than forwarded to the gateway node and SNATed, the default is Enforce
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Enforce;Shadow
+	Mode string `json:"mode,omitempty"`
+	// DestinationEIPs SNATs the traffic to the destinations of each group with the EIP of
+	// the group rather than the EIP of the policy, on the gateway node of the policy
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=name
+	DestinationEIPs []DestinationEIP `json:"destinationEIPs,omitempty"`
+	// ExcludeNodes selects the nodes whose Pods are never redirected by the policy, e.g.
+	// the edge nodes with their own public IPs, the agents on them skip the policy
+	// +kubebuilder:validation:Optional
+	ExcludeNodes *metav1.LabelSelector `json:"excludeNodes,omitempty"`
+	// ClusterDefault applies the policy to the Pods of all the namespaces, except the ones
+	// labeled to opt out, at a lower priority than the other policies. The Pods are still
+	// narrowed by appliedTo.podSelector and appliedTo.namespaceSelector if they're set.
+	// +kubebuilder:validation:Optional
+	ClusterDefault bool `json:"clusterDefault,omitempty"`
+}
+
+// PodLabelSelector returns the selector of the Pods the policy applies to, the cluster
+// default policy selects all the Pods without appliedTo.podSelector
+func (s EgressClusterPolicySpec) PodLabelSelector() (labels.Selector, error) {
+	if s.ClusterDefault && s.AppliedTo.PodSelector == nil {
+		return labels.Everything(), nil
+	}
+	return metav1.LabelSelectorAsSelector(s.AppliedTo.PodSelector)
+}
+
+// NamespaceLabelSelector returns the selector of the namespaces the policy applies to, all
+// the namespaces are selected without appliedTo.namespaceSelector. The cluster default
+// policy skips the namespaces opted out by LabelNamespaceClusterDefaultOptOut.
+func (s EgressClusterPolicySpec) NamespaceLabelSelector() (labels.Selector, error) {
+	selector := labels.Everything()
+	if s.AppliedTo.NamespaceSelector != nil {
+		var err error
+		selector, err = metav1.LabelSelectorAsSelector(s.AppliedTo.NamespaceSelector)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !s.ClusterDefault {
+		return selector, nil
+	}
+	optOut, err := labels.NewRequirement(LabelNamespaceClusterDefaultOptOut, selection.NotEquals, []string{"true"})
+	if err != nil {
+		return nil, err
+	}
+	return selector.Add(*optOut), nil
 }
 
 type ClusterAppliedTo struct {
@@ -53,6 +130,10 @@ type ClusterAppliedTo struct {
 	PodSubnet *[]string `json:"podSubnet,omitempty"`
 	// +kubebuilder:validation:Optional
 	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// StaticEndpoints is a list of IPs or CIDRs of workloads outside the cluster,
+	// such as VMs on the pod network, whose traffic is also sent to the gateway.
+	// +kubebuilder:validation:Optional
+	StaticEndpoints []string `json:"staticEndpoints,omitempty"`
 }
 
 func init() {
@@ -26,6 +26,9 @@ type EgressGatewayList struct {
 // +kubebuilder:printcolumn:JSONPath=".status.ipUsage.ipv4Free",description="ipv4Free",name="ipv4Free",type=integer
 // +kubebuilder:printcolumn:JSONPath=".status.ipUsage.ipv6Total",description="ipv6Total",name="ipv6Total",type=integer
 // +kubebuilder:printcolumn:JSONPath=".status.ipUsage.ipv6Free",description="ipv6Free",name="ipv6Free",type=integer
+// +kubebuilder:printcolumn:JSONPath=".status.readyNodes",description="readyNodes",name="readyNodes",type=integer
+// +kubebuilder:printcolumn:JSONPath=".status.ipUsage.defaultEIPPolicies",description="defaultEIPPolicies",name="defaultEIPPolicies",type=integer
+// +kubebuilder:printcolumn:JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description="ready",name="ready",type=string
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 type EgressGateway struct {
@@ -43,6 +46,75 @@ type EgressGatewaySpec struct {
 	Ippools Ippools `json:"ippools,omitempty"`
 	// +kubebuilder:validation:Required
 	NodeSelector NodeSelector `json:"nodeSelector,omitempty"`
+	// Tunnel isolates the traffic of the gateway in a dedicated tunnel network,
+	// the default tunnel network is used if it is not set.
+	// +kubebuilder:validation:Optional
+	Tunnel *GatewayTunnel `json:"tunnel,omitempty"`
+	// SNAT configures the source NAT of the egress traffic on the gateway nodes,
+	// the traffic is translated to the EIP by SNAT if it is not set.
+	// +kubebuilder:validation:Optional
+	SNAT *SNAT `json:"snat,omitempty"`
+	// Conntrack overrides the conntrack timeouts of the gateway nodes
+	// +kubebuilder:validation:Optional
+	Conntrack *ConntrackTimeouts `json:"conntrack,omitempty"`
+}
+
+// ConntrackTimeouts is the conntrack timeouts in seconds, 0 keeps the default of the node.
+// The timeouts are node-wide sysctls, the largest value of the gateways of a node is used.
+type ConntrackTimeouts struct {
+	// TCPEstablishedSeconds is the timeout of the idle established TCP connections
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	TCPEstablishedSeconds int32 `json:"tcpEstablishedSeconds,omitempty"`
+	// UDPSeconds is the timeout of the UDP flows which only have packets in one direction
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	UDPSeconds int32 `json:"udpSeconds,omitempty"`
+	// UDPStreamSeconds is the timeout of the UDP flows which have packets in both directions
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	UDPStreamSeconds int32 `json:"udpStreamSeconds,omitempty"`
+}
+
+type SNATMode string
+
+const (
+	// SNATModeSNAT translates the source address to the EIP
+	SNATModeSNAT SNATMode = "SNAT"
+	// SNATModeMasquerade translates the source address to the address of the egress interface
+	SNATModeMasquerade SNATMode = "MASQUERADE"
+)
+
+type SNAT struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=SNAT;MASQUERADE
+	Mode SNATMode `json:"mode,omitempty"`
+	// RandomFully fully randomizes the source port mapping, which avoids the port
+	// exhaustion of many connections to the same destination, but breaks the
+	// destinations which expect stable source ports.
+	// +kubebuilder:validation:Optional
+	RandomFully bool `json:"randomFully,omitempty"`
+	// Persistent maps a client to the same source for every connection, it is only
+	// supported by the SNAT mode.
+	// +kubebuilder:validation:Optional
+	Persistent bool `json:"persistent,omitempty"`
+}
+
+type GatewayTunnel struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=16777215
+	VNI int `json:"vni"`
+	// +kubebuilder:validation:Optional
+	IPv4Subnet string `json:"ipv4Subnet,omitempty"`
+	// +kubebuilder:validation:Optional
+	IPv6Subnet string `json:"ipv6Subnet,omitempty"`
+	// RouteTableBase is the routing table of the first mark in the tunnel network, the
+	// tables of the gateway nodes are numbered by their marks from it. The routeTable base
+	// of the agent config is used if it is not set.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=256
+	RouteTableBase int `json:"routeTableBase,omitempty"`
 }
 
 type Ippools struct {
@@ -54,8 +126,27 @@ type Ippools struct {
 	Ipv4DefaultEIP string `json:"ipv4DefaultEIP,omitempty"`
 	// +kubebuilder:validation:Optional
 	Ipv6DefaultEIP string `json:"ipv6DefaultEIP,omitempty"`
+	// Policy is the strategy to allocate the EIPs to the policies, the default is random
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=random;sequential;leastUsed;hashByPolicyName
+	Policy IPAllocationStrategy `json:"policy,omitempty"`
 }
 
+type IPAllocationStrategy string
+
+const (
+	// IPAllocationRandom allocates a random free EIP
+	IPAllocationRandom IPAllocationStrategy = "random"
+	// IPAllocationSequential allocates the lowest free EIP
+	IPAllocationSequential IPAllocationStrategy = "sequential"
+	// IPAllocationLeastUsed allocates the lowest free EIP, and shares the EIP used by the
+	// least policies when all EIPs are used
+	IPAllocationLeastUsed IPAllocationStrategy = "leastUsed"
+	// IPAllocationHashByPolicyName allocates the first free EIP starting from the hash
+	// of the policy name, so a recreated policy gets the same EIP
+	IPAllocationHashByPolicyName IPAllocationStrategy = "hashByPolicyName"
+)
+
 type NodeSelector struct {
 	// +kubebuilder:validation:Optional
 	Policy string `json:"policy,omitempty"`
@@ -68,8 +159,120 @@ type EgressGatewayStatus struct {
 	NodeList []EgressIPStatus `json:"nodeList,omitempty"`
 	// +kubebuilder:validation:Optional
 	IPUsage IPUsage `json:"ipUsage,omitempty"`
+	// ReadyNodes is the number of the Ready nodes in the node list
+	// +kubebuilder:validation:Optional
+	ReadyNodes int `json:"readyNodes"`
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// ObservedGeneration is the generation of the gateway observed by the controller
+	// +kubebuilder:validation:Optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// AppliedNodes is the generation of the gateway applied by the agent of each node
+	// +kubebuilder:validation:Optional
+	AppliedNodes []NodeAppliedStatus `json:"appliedNodes,omitempty"`
+	// QuarantinedIPs is the IPs of the ippools which are also claimed by an earlier
+	// EgressGateway, they are not allocated to the policies of this gateway
+	// +kubebuilder:validation:Optional
+	QuarantinedIPs []string `json:"quarantinedIPs,omitempty"`
+	// FailoverHistory is the last transitions of the gateway nodes and the EIPs, the
+	// earliest first, at most gatewayFailover.historySize of them are kept
+	// +kubebuilder:validation:Optional
+	FailoverHistory []FailoverRecord `json:"failoverHistory,omitempty"`
+	// StandbyNodes is the standby EIPs programmed by the agent of each node, the EIPs are
+	// only moved to the standby nodes which have programmed them on the failover
+	// +kubebuilder:validation:Optional
+	StandbyNodes []NodeStandbyStatus `json:"standbyNodes,omitempty"`
+}
+
+// NodeStandbyStatus is the standby EIPs whose SNAT rules are programmed on the node
+type NodeStandbyStatus struct {
+	// +kubebuilder:validation:Optional
+	Name string `json:"name,omitempty"`
+	// +kubebuilder:validation:Optional
+	IPs []string `json:"ips,omitempty"`
+}
+
+// SetNodeStandbyIPs sets the programmed standby EIPs of the node, it returns true if changed
+func SetNodeStandbyIPs(list *[]NodeStandbyStatus, node string, ips []string) bool {
+	for i, item := range *list {
+		if item.Name == node {
+			if equalIPs(item.IPs, ips) {
+				return false
+			}
+			(*list)[i].IPs = ips
+			return true
+		}
+	}
+	if len(ips) == 0 {
+		return false
+	}
+	*list = append(*list, NodeStandbyStatus{Name: node, IPs: ips})
+	return true
 }
 
+func equalIPs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// FailoverRecord is a transition of the gateway, either the status change of a node or an
+// EIP moved from a node to another one
+type FailoverRecord struct {
+	// +kubebuilder:validation:Required
+	Time metav1.Time `json:"time"`
+	// Node is the node whose status changed, or the node the EIP is moved from
+	// +kubebuilder:validation:Required
+	Node string `json:"node"`
+	// ToNode is the node the EIP is moved to
+	// +kubebuilder:validation:Optional
+	ToNode string `json:"toNode,omitempty"`
+	// +kubebuilder:validation:Optional
+	IPv4 string `json:"ipv4,omitempty"`
+	// +kubebuilder:validation:Optional
+	IPv6 string `json:"ipv6,omitempty"`
+	// +kubebuilder:validation:Required
+	Reason string `json:"reason"`
+}
+
+const (
+	// FailoverReasonNodeNotReady is the node is not Ready
+	FailoverReasonNodeNotReady = "NodeNotReady"
+	// FailoverReasonTunnelTimeout is the heartbeat of the EgressTunnel of the node timed out
+	FailoverReasonTunnelTimeout = "TunnelTimeout"
+	// FailoverReasonTunnelUnreachable is the peers can not reach the node through the tunnel
+	FailoverReasonTunnelUnreachable = "TunnelUnreachable"
+	// FailoverReasonUpstreamDown is all the upstream next hops of the node are unreachable
+	FailoverReasonUpstreamDown = "UpstreamDown"
+	// FailoverReasonManualDrain is the node is cordoned or drained longer than the grace period
+	FailoverReasonManualDrain = "ManualDrain"
+	// FailoverReasonNodeRemoved is the node is deleted or no longer selected by the gateway
+	FailoverReasonNodeRemoved = "NodeRemoved"
+	// FailoverReasonNodeRecovered is the node is Ready again
+	FailoverReasonNodeRecovered = "NodeRecovered"
+	// FailoverReasonRebalanced is the EIP is moved from a Ready node
+	FailoverReasonRebalanced = "Rebalanced"
+)
+
+const (
+	// GatewayConditionReady is true when at least one node of the gateway is Ready
+	GatewayConditionReady = "Ready"
+	// GatewayConditionIPPoolExhausted is true when all the IPs of an IP family are used
+	GatewayConditionIPPoolExhausted = "IPPoolExhausted"
+	// GatewayConditionFailoverInProgress is true when a node which is not Ready still holds EIPs
+	GatewayConditionFailoverInProgress = "FailoverInProgress"
+	// GatewayConditionIPConflict is true when some IPs of the ippools are quarantined
+	GatewayConditionIPConflict = "IPConflict"
+)
+
 type IPUsage struct {
 	// +kubebuilder:validation:Optional
 	IPv4Total int `json:"ipv4Total"`
@@ -79,6 +282,9 @@ type IPUsage struct {
 	IPv6Total int `json:"ipv6Total"`
 	// +kubebuilder:validation:Optional
 	IPv6Free int `json:"ipv6Free"`
+	// DefaultEIPPolicies is the number of policies using the default EIP
+	// +kubebuilder:validation:Optional
+	DefaultEIPPolicies int `json:"defaultEIPPolicies"`
 }
 
 func (status *EgressGatewayStatus) GetNodeIPs(nodeName string) []Eips {
@@ -90,15 +296,48 @@ func (status *EgressGatewayStatus) GetNodeIPs(nodeName string) []Eips {
 	return make([]Eips, 0)
 }
 
+// GetNodeStandbyIPs returns the EIPs of other nodes the node stands by for
+func (status *EgressGatewayStatus) GetNodeStandbyIPs(nodeName string) []Eips {
+	for _, items := range status.NodeList {
+		if items.Name == nodeName {
+			return items.StandbyEips
+		}
+	}
+	return nil
+}
+
+// GetNodeDestinationIPs returns the destination EIPs of the policies on the node
+func (status *EgressGatewayStatus) GetNodeDestinationIPs(nodeName string) []DestinationEips {
+	for _, items := range status.NodeList {
+		if items.Name == nodeName {
+			return items.DestinationEips
+		}
+	}
+	return nil
+}
+
 type EgressIPStatus struct {
 	// +kubebuilder:validation:Optional
 	Name string `json:"name,omitempty"`
 	// +kubebuilder:validation:Optional
 	Eips []Eips `json:"eips,omitempty"`
+	// DestinationEips is the destination EIPs of the policies on the node, they're placed
+	// on the gateway node of the policies
+	// +kubebuilder:validation:Optional
+	DestinationEips []DestinationEips `json:"destinationEips,omitempty"`
+	// Status is the phase of the EgressTunnel of the node, or Cordoned
 	// +kubebuilder:validation:Optional
 	Status string `json:"status,omitempty"`
+	// StandbyEips is the EIPs of other nodes the node stands by for, their SNAT rules are
+	// programmed on the node ahead of the failover
+	// +kubebuilder:validation:Optional
+	StandbyEips []Eips `json:"standbyEips,omitempty"`
 }
 
+// NodeStatusCordoned is the status of the gateway node which has been cordoned longer
+// than the grace period, its EIPs are moved to other nodes
+const NodeStatusCordoned = "Cordoned"
+
 type Eips struct {
 	// +kubebuilder:validation:Optional
 	IPv4 string `json:"ipv4,omitempty"`
@@ -108,6 +347,19 @@ type Eips struct {
 	Policies []Policy `json:"policies,omitempty"`
 }
 
+// DestinationEips is the EIP of a destination group of a policy
+type DestinationEips struct {
+	// +kubebuilder:validation:Optional
+	Policy Policy `json:"policy,omitempty"`
+	// Destination is the name of the destination group
+	// +kubebuilder:validation:Optional
+	Destination string `json:"destination,omitempty"`
+	// +kubebuilder:validation:Optional
+	IPv4 string `json:"ipv4,omitempty"`
+	// +kubebuilder:validation:Optional
+	IPv6 string `json:"ipv6,omitempty"`
+}
+
 type Policy struct {
 	// +kubebuilder:validation:Optional
 	Name string `json:"name,omitempty"`
@@ -0,0 +1,71 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EgressIPClaimList contains a list of EgressIPClaim
+// +kubebuilder:object:root=true
+type EgressIPClaimList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []EgressIPClaim `json:"items"`
+}
+
+// EgressIPClaim owns an EIP of an EgressGateway, which is shared by the policies
+// referencing the claim by spec.egressIP.claimName
+// +kubebuilder:resource:categories={egressipclaim},path="egressipclaims",singular="egressipclaim",scope="Cluster",shortName={egic}
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:JSONPath=".spec.egressGatewayName",description="egressGatewayName",name="gateway",type=string
+// +kubebuilder:printcolumn:JSONPath=".status.eip.ipv4",description="ipv4",name="ipv4",type=string
+// +kubebuilder:printcolumn:JSONPath=".status.eip.ipv6",description="ipv6",name="ipv6",type=string
+// +kubebuilder:printcolumn:JSONPath=".status.policyCount",description="policyCount",name="policies",type=integer
+// +kubebuilder:printcolumn:JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description="ready",name="ready",type=string
+type EgressIPClaim struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec   EgressIPClaimSpec   `json:"spec,omitempty"`
+	Status EgressIPClaimStatus `json:"status,omitempty"`
+}
+
+type EgressIPClaimSpec struct {
+	// +kubebuilder:validation:Required
+	EgressGatewayName string `json:"egressGatewayName"`
+	// IPv4 is the requested EIP, an EIP is allocated by the strategy of the gateway if it's empty
+	// +kubebuilder:validation:Optional
+	IPv4 string `json:"ipv4,omitempty"`
+	// IPv6 is the requested EIP, an EIP is allocated by the strategy of the gateway if it's empty
+	// +kubebuilder:validation:Optional
+	IPv6 string `json:"ipv6,omitempty"`
+}
+
+type EgressIPClaimStatus struct {
+	// Eip is the EIP owned by the claim
+	// +kubebuilder:validation:Optional
+	Eip Eip `json:"eip,omitempty"`
+	// Policies is the policies referencing the claim. The claim is kept until no policy
+	// references it, and its EIP is released when it is deleted.
+	// +kubebuilder:validation:Optional
+	Policies []Policy `json:"policies,omitempty"`
+	// +kubebuilder:validation:Optional
+	PolicyCount int `json:"policyCount,omitempty"`
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// ClaimConditionReady is true when the EIP is allocated to the claim
+	ClaimConditionReady = "Ready"
+)
+
+func init() {
+	SchemeBuilder.Register(&EgressIPClaim{}, &EgressIPClaimList{})
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package v1beta1
+
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+// EgressNodeDatapathList contains a list of EgressNodeDatapath
+// +kubebuilder:object:root=true
+type EgressNodeDatapathList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []EgressNodeDatapath `json:"items"`
+}
+
+// EgressNodeDatapath records the datapath programmed by the agent on the node, it's named
+// after the node
+// +kubebuilder:resource:categories={egressnodedatapath},path="egressnodedatapaths",singular="egressnodedatapath",scope="Cluster",shortName={egnd}
+// +kubebuilder:printcolumn:JSONPath=".status.hash",description="hash",name="hash",type=string
+// +kubebuilder:printcolumn:JSONPath=".status.updateTime",description="updateTime",name="updateTime",type=date
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+type EgressNodeDatapath struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	// +kubebuilder:validation:Optional
+	Status EgressNodeDatapathStatus `json:"status,omitempty"`
+}
+
+type EgressNodeDatapathStatus struct {
+	// DatapathVersion is the version of the datapath of the agent
+	// +kubebuilder:validation:Optional
+	DatapathVersion int `json:"datapathVersion,omitempty"`
+	// Hash is the hash of all the sections
+	// +kubebuilder:validation:Optional
+	Hash string `json:"hash,omitempty"`
+	// UpdateTime is the time the datapath is changed
+	// +kubebuilder:validation:Optional
+	UpdateTime metav1.Time `json:"updateTime,omitempty"`
+	// +kubebuilder:validation:Optional
+	Sections []DatapathSection `json:"sections,omitempty"`
+}
+
+// DatapathSection is a kind of the datapath, such as the iptables rules of a table, the
+// ip rules, the routes or the ipsets
+type DatapathSection struct {
+	// Name is the kind of the datapath, e.g. iptables/ipv4/mangle, rules/ipv4, routes/ipv6, ipsets
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+	// Hash is the hash of all the normalized lines of the section
+	// +kubebuilder:validation:Optional
+	Hash string `json:"hash,omitempty"`
+	// Count is the number of the lines
+	// +kubebuilder:validation:Optional
+	Count int `json:"count,omitempty"`
+	// Summary is the normalized lines, the lines over the limit are left out
+	// +kubebuilder:validation:Optional
+	Summary []string `json:"summary,omitempty"`
+	// Truncated is true if some lines are left out of the summary
+	// +kubebuilder:validation:Optional
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EgressNodeDatapath{}, &EgressNodeDatapathList{})
+}
@@ -4,6 +4,7 @@
 package v1beta1
 
 import (
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -24,6 +25,7 @@ type EgressPolicyList struct {
 // +kubebuilder:printcolumn:JSONPath=".status.eip.ipv4",description="ipv4",name="ipv4",type=string
 // +kubebuilder:printcolumn:JSONPath=".status.eip.ipv6",description="ipv6",name="ipv6",type=string
 // +kubebuilder:printcolumn:JSONPath=".status.node",description="egressNode",name="egressNode",type=string
+// +kubebuilder:printcolumn:JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description="ready",name="ready",type=string
 type EgressPolicy struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata"`
@@ -42,8 +44,51 @@ type EgressPolicySpec struct {
 	AppliedTo AppliedTo `json:"appliedTo"`
 	// +kubebuilder:validation:Optional
 	DestSubnet []string `json:"destSubnet"`
+	// DestSubnetFrom adds the CIDRs of the destination providers, the GeoIP selectors and
+	// the Services to destSubnet, the CIDRs are resolved by the controller to
+	// status.resolvedDestSubnet
+	// +kubebuilder:validation:Optional
+	DestSubnetFrom []DestSubnetSource `json:"destSubnetFrom,omitempty"`
 	// +kubebuilder:validation:Optional
 	Priority uint64 `json:"priority,omitempty"`
+	// Limits limits the connections of the policy on the gateway node
+	// +kubebuilder:validation:Optional
+	Limits *ConnectionLimits `json:"limits,omitempty"`
+	// Logging logs the new connections of the policy on the gateway node
+	// +kubebuilder:validation:Optional
+	Logging *PolicyLogging `json:"logging,omitempty"`
+	// ProxyProtocol relays the TCP connections of the policy to the destination ports
+	// through the gateway node, prepending the PROXY protocol v2 header with the Pod IP
+	// +kubebuilder:validation:Optional
+	ProxyProtocol *ProxyProtocol `json:"proxyProtocol,omitempty"`
+	// L7Proxy proxies the HTTP and SOCKS5 proxy requests of the policy to the destination
+	// ports by the gateway node, which connects to the requested targets from the EIP
+	// +kubebuilder:validation:Optional
+	L7Proxy *L7Proxy `json:"l7Proxy,omitempty"`
+	// Schedule limits the policy to be active only in the time windows, the policy is
+	// always active if it's not set
+	// +kubebuilder:validation:Optional
+	Schedule *PolicySchedule `json:"schedule,omitempty"`
+	// Mode is Enforce or Shadow. In the Shadow mode, the matched traffic is counted by
+	// destination rather than forwarded to the gateway node and SNATed, the default is Enforce
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Enforce;Shadow
+	Mode string `json:"mode,omitempty"`
+	// Rollout applies the policy to a part of the Pods selected by spec.appliedTo.podSelector,
+	// the policy applies to all of them if it's not set
+	// +kubebuilder:validation:Optional
+	Rollout *PolicyRollout `json:"rollout,omitempty"`
+	// DestinationEIPs SNATs the traffic to the destinations of each group with the EIP of
+	// the group rather than the EIP of the policy, on the gateway node of the policy
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=name
+	DestinationEIPs []DestinationEIP `json:"destinationEIPs,omitempty"`
+	// EgressGatewaySelector selects the EgressGateway of the policy by the labels, the
+	// selected gateway is set to spec.egressGatewayName, and another one is selected once
+	// it doesn't match the selector anymore
+	// +kubebuilder:validation:Optional
+	EgressGatewaySelector *GatewaySelector `json:"egressGatewaySelector,omitempty"`
 }
 
 type EgressPolicyStatus struct {
@@ -51,6 +96,251 @@ type EgressPolicyStatus struct {
 	Eip Eip `json:"eip,omitempty"`
 	// +kubebuilder:validation:Optional
 	Node string `json:"node,omitempty"`
+	// NodeIP is the IP of the gateway node the traffic is SNATed with, when
+	// spec.egressIP.useNodeIP is true
+	// +kubebuilder:validation:Optional
+	NodeIP Eip `json:"nodeIP,omitempty"`
+	// CleanedNodes is the nodes whose agent has removed the datapath state of the
+	// policy after it is deleted, the finalizer is removed when all nodes are cleaned.
+	// +kubebuilder:validation:Optional
+	CleanedNodes []string `json:"cleanedNodes,omitempty"`
+	// ObservedGeneration is the generation of the policy observed by the controller
+	// +kubebuilder:validation:Optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// AppliedNodes is the generation of the policy applied by the agent of each node
+	// +kubebuilder:validation:Optional
+	AppliedNodes []NodeAppliedStatus `json:"appliedNodes,omitempty"`
+	// ResolvedDestSubnet is the CIDRs of the sources in spec.destSubnetFrom, last resolved
+	// by the controller
+	// +kubebuilder:validation:Optional
+	ResolvedDestSubnet []string `json:"resolvedDestSubnet,omitempty"`
+	// Counters is the counters of the rules of the policy on each node, last sampled
+	// by the agent of the node
+	// +kubebuilder:validation:Optional
+	Counters []NodePolicyCounters `json:"counters,omitempty"`
+	// VerifiedEIP is the egress IP of the policy last observed by the verification service
+	// from the gateway node, when the EIP verification is enabled
+	// +kubebuilder:validation:Optional
+	VerifiedEIP Eip `json:"verifiedEIP,omitempty"`
+	// SelectedGateway is the EgressGateway last selected by spec.egressGatewaySelector
+	// +kubebuilder:validation:Optional
+	SelectedGateway *GatewaySelection `json:"selectedGateway,omitempty"`
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// PolicyConditionReady is true when the policy is assigned to a gateway node
+	PolicyConditionReady = "Ready"
+	// PolicyConditionActive is true when the policy with a schedule is in its time windows
+	PolicyConditionActive = "Active"
+	// PolicyConditionNetworkPolicyAllowed is false when the NetworkPolicies block the egress
+	// of the selected Pods before it reaches the gateway
+	PolicyConditionNetworkPolicyAllowed = "NetworkPolicyAllowed"
+	// PolicyConditionEIPVerified is true when the verification service observes the egress
+	// IP of the policy from the gateway node, it only exists when the verification is enabled
+	PolicyConditionEIPVerified = "EIPVerified"
+)
+
+// the reasons of the EIPVerified condition
+const (
+	ReasonEIPVerified         = "Verified"
+	ReasonEIPPending          = "Pending"
+	ReasonEIPSourceIPMismatch = "SourceIPMismatch"
+	ReasonEIPProbeFailed      = "ProbeFailed"
+)
+
+var ReasonBlockedByNetworkPolicy = "BlockedByNetworkPolicy"
+
+// SetReadyCondition sets the Ready condition by the assigned gateway node of the policy,
+// it returns true if the condition is changed.
+func (status *EgressPolicyStatus) SetReadyCondition(generation int64) bool {
+	ready := metav1.Condition{
+		Type:               PolicyConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Assigned",
+		Message:            "the policy is assigned to node " + status.Node,
+		ObservedGeneration: generation,
+	}
+	if status.Node == "" {
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = "Unassigned"
+		ready.Message = "the policy is not assigned to any gateway node"
+	} else if cond := meta.FindStatusCondition(status.Conditions, PolicyConditionEIPVerified); cond != nil && !status.EIPVerified() {
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = "EIPUnverified"
+		ready.Message = cond.Message
+		if cond.Status == metav1.ConditionTrue {
+			ready.Message = "the egress IP of the policy is not verified by node " + status.Node + " yet"
+		}
+	}
+	return meta.SetStatusCondition(&status.Conditions, ready)
+}
+
+// EgressIP returns the IP the traffic of the policy is SNATed with, it's the EIP, or the
+// IP of the gateway node if the policy uses the node IP
+func (status *EgressPolicyStatus) EgressIP() Eip {
+	if status.Eip.Ipv4 == "" && status.Eip.Ipv6 == "" {
+		return status.NodeIP
+	}
+	return status.Eip
+}
+
+// EIPVerified returns true if the current egress IP of the policy is verified
+func (status *EgressPolicyStatus) EIPVerified() bool {
+	cond := meta.FindStatusCondition(status.Conditions, PolicyConditionEIPVerified)
+	return cond != nil && cond.Status == metav1.ConditionTrue && status.VerifiedEIP == status.EgressIP()
+}
+
+// RequireEIPVerification sets the EIPVerified condition Pending if the egress IP of the
+// assigned policy is not verified, so the policy isn't Ready until the gateway node
+// verifies it. It returns true if the condition is changed.
+func (status *EgressPolicyStatus) RequireEIPVerification(generation int64) bool {
+	if status.Node == "" || status.EIPVerified() {
+		return false
+	}
+	return status.SetEIPVerifiedCondition(generation, false, ReasonEIPPending,
+		"waiting for node "+status.Node+" to verify the egress IP")
+}
+
+// SetEIPVerifiedCondition sets the EIPVerified condition of the policy, the current egress
+// IP is recorded as verified if it's verified. It returns true if the status is changed.
+func (status *EgressPolicyStatus) SetEIPVerifiedCondition(generation int64, verified bool, reason, message string) bool {
+	cond := metav1.Condition{
+		Type:               PolicyConditionEIPVerified,
+		Status:             metav1.ConditionTrue,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: generation,
+	}
+	changed := false
+	if verified {
+		if ip := status.EgressIP(); status.VerifiedEIP != ip {
+			status.VerifiedEIP = ip
+			changed = true
+		}
+	} else {
+		cond.Status = metav1.ConditionFalse
+	}
+	return meta.SetStatusCondition(&status.Conditions, cond) || changed
+}
+
+// RemoveEIPVerifiedCondition removes the EIPVerified condition and the verified egress IP
+// of the policy, it returns true if the status is changed.
+func (status *EgressPolicyStatus) RemoveEIPVerifiedCondition() bool {
+	changed := status.VerifiedEIP != Eip{}
+	status.VerifiedEIP = Eip{}
+	return meta.RemoveStatusCondition(&status.Conditions, PolicyConditionEIPVerified) || changed
+}
+
+// SetActiveCondition sets the Active condition of the policy with a schedule, the message
+// tells when the policy is activated or deactivated next time. It returns true if the
+// condition is changed.
+func (status *EgressPolicyStatus) SetActiveCondition(generation int64, active bool, message string) bool {
+	cond := metav1.Condition{
+		Type:               PolicyConditionActive,
+		Status:             metav1.ConditionTrue,
+		Reason:             "InSchedule",
+		Message:            message,
+		ObservedGeneration: generation,
+	}
+	if !active {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = "OutOfSchedule"
+	}
+	return meta.SetStatusCondition(&status.Conditions, cond)
+}
+
+// SetNetworkPolicyCondition sets the NetworkPolicyAllowed condition of the policy, the
+// message describes the blocked egress, which is empty if nothing is blocked. It returns
+// true if the condition is changed.
+func (status *EgressPolicyStatus) SetNetworkPolicyCondition(generation int64, message string) bool {
+	cond := metav1.Condition{
+		Type:               PolicyConditionNetworkPolicyAllowed,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Allowed",
+		Message:            "the egress of the selected Pods is not blocked by NetworkPolicies",
+		ObservedGeneration: generation,
+	}
+	if message != "" {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = ReasonBlockedByNetworkPolicy
+		cond.Message = message
+	}
+	return meta.SetStatusCondition(&status.Conditions, cond)
+}
+
+type NodeAppliedStatus struct {
+	// +kubebuilder:validation:Optional
+	Name string `json:"name,omitempty"`
+	// +kubebuilder:validation:Optional
+	AppliedGeneration int64 `json:"appliedGeneration,omitempty"`
+}
+
+// SetNodeAppliedGeneration sets the applied generation of the node, it returns true if changed
+func SetNodeAppliedGeneration(list *[]NodeAppliedStatus, node string, generation int64) bool {
+	for i, item := range *list {
+		if item.Name == node {
+			if item.AppliedGeneration == generation {
+				return false
+			}
+			(*list)[i].AppliedGeneration = generation
+			return true
+		}
+	}
+	*list = append(*list, NodeAppliedStatus{Name: node, AppliedGeneration: generation})
+	return true
+}
+
+// NodePolicyCounters is the counters of the rules of a policy on a node
+type NodePolicyCounters struct {
+	// +kubebuilder:validation:Optional
+	Name string `json:"name,omitempty"`
+	// Packets is the number of the packets of the Pods on the node forwarded to the
+	// gateway node, it's counted on the nodes other than the gateway node
+	// +kubebuilder:validation:Optional
+	Packets int64 `json:"packets,omitempty"`
+	// Bytes is the number of the bytes of the Pods on the node forwarded to the gateway
+	// node, it's counted on the nodes other than the gateway node
+	// +kubebuilder:validation:Optional
+	Bytes int64 `json:"bytes,omitempty"`
+	// SNATConnections is the number of the connections SNATed to the EIP, it's counted
+	// on the gateway node
+	// +kubebuilder:validation:Optional
+	SNATConnections int64 `json:"snatConnections,omitempty"`
+	// SampleTime is when the counters are sampled
+	// +kubebuilder:validation:Optional
+	SampleTime metav1.Time `json:"sampleTime,omitempty"`
+}
+
+// SetNodePolicyCounters sets the counters of the node, the sample time is only updated
+// with the counters. It returns true if changed.
+func SetNodePolicyCounters(list *[]NodePolicyCounters, counters NodePolicyCounters) bool {
+	for i, item := range *list {
+		if item.Name == counters.Name {
+			if item.Packets == counters.Packets && item.Bytes == counters.Bytes &&
+				item.SNATConnections == counters.SNATConnections {
+				return false
+			}
+			(*list)[i] = counters
+			return true
+		}
+	}
+	*list = append(*list, counters)
+	return true
+}
+
+// RemoveNodePolicyCounters removes the counters of the node, it returns true if changed
+func RemoveNodePolicyCounters(list *[]NodePolicyCounters, node string) bool {
+	for i, item := range *list {
+		if item.Name == node {
+			*list = append((*list)[:i], (*list)[i+1:]...)
+			return true
+		}
+	}
+	return false
 }
 
 type Eip struct {
@@ -71,6 +361,258 @@ type EgressIP struct {
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default:="default"
 	AllocatorPolicy string `json:"allocatorPolicy,omitempty"`
+	// ClaimName is the EgressIPClaim whose EIP is shared by the policy, it cannot be
+	// used with ipv4, ipv6 or useNodeIP at the same time
+	// +kubebuilder:validation:Optional
+	ClaimName string `json:"claimName,omitempty"`
+	// IPFamilyPolicy is the IP families of the traffic sent through the gateway, the
+	// traffic of the other family goes out directly. Empty means PreferDualStack.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=IPv4Only;IPv6Only;PreferDualStack
+	IPFamilyPolicy string `json:"ipFamilyPolicy,omitempty"`
+}
+
+// DestinationEIP is a group of destinations and the EIP the traffic to them is SNATed with
+type DestinationEIP struct {
+	// Name is the name of the group
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// +kubebuilder:validation:MaxLength=63
+	Name string `json:"name"`
+	// DestSubnet is the destination CIDRs of the group
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+	DestSubnet []string `json:"destSubnet"`
+	// IPv4 is the IPv4 EIP of the group, which is in the ippools of the EgressGateway
+	// +kubebuilder:validation:Optional
+	IPv4 string `json:"ipv4,omitempty"`
+	// IPv6 is the IPv6 EIP of the group, which is in the ippools of the EgressGateway
+	// +kubebuilder:validation:Optional
+	IPv6 string `json:"ipv6,omitempty"`
+}
+
+const (
+	// IPFamilyPolicyIPv4Only sends only the IPv4 traffic through the gateway
+	IPFamilyPolicyIPv4Only = "IPv4Only"
+	// IPFamilyPolicyIPv6Only sends only the IPv6 traffic through the gateway
+	IPFamilyPolicyIPv6Only = "IPv6Only"
+	// IPFamilyPolicyPreferDualStack sends the traffic of both families through the gateway
+	IPFamilyPolicyPreferDualStack = "PreferDualStack"
+)
+
+// IPFamilies returns whether the IPv4 and the IPv6 traffic is sent through the gateway
+func (eip EgressIP) IPFamilies() (ipv4, ipv6 bool) {
+	switch eip.IPFamilyPolicy {
+	case IPFamilyPolicyIPv4Only:
+		return true, false
+	case IPFamilyPolicyIPv6Only:
+		return false, true
+	default:
+		return true, true
+	}
+}
+
+// ConnectionLimits protects the shared EIP from a single policy exhausting the SNAT
+// ports, the new connections beyond the limits are dropped. 0 means no limit.
+type ConnectionLimits struct {
+	// MaxConnections is the maximum number of concurrent connections
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MaxConnections int32 `json:"maxConnections,omitempty"`
+	// NewConnectionsPerSecond is the maximum rate of new connections
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	NewConnectionsPerSecond int32 `json:"newConnectionsPerSecond,omitempty"`
+	// Burst is the number of new connections allowed beyond the rate in a burst,
+	// the default is 5
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	Burst int32 `json:"burst,omitempty"`
+}
+
+// PolicyLogging logs the new connections of the policy by NFLOG on the gateway node, they're
+// collected by the agent into the structured logs
+type PolicyLogging struct {
+	// Enabled logs the new connections of the policy
+	// +kubebuilder:validation:Optional
+	Enabled bool `json:"enabled,omitempty"`
+	// SampleRate is the percentage of the new connections logged, the default is 100
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	SampleRate int32 `json:"sampleRate,omitempty"`
+}
+
+// ProxyProtocol relays the TCP connections of the policy to the destination ports by the
+// agent of the gateway node, which prepends the PROXY protocol v2 header carrying the
+// original addresses of the connections, so the upstreams know the Pod IPs despite SNAT
+type ProxyProtocol struct {
+	// Ports is the destination ports of the relayed connections, the upstreams listening on
+	// them should expect the PROXY protocol v2 header
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=15
+	Ports []int32 `json:"ports"`
+}
+
+// L7Proxy proxies the TCP connections of the policy to the destination ports by the agent of
+// the gateway node, which accepts the HTTP CONNECT, the HTTP forward and the SOCKS5 requests,
+// logs each of them, and connects to the requested targets from the EIP of the policy. The
+// Pods point their proxy settings at a destination of the policy and one of the ports.
+type L7Proxy struct {
+	// Ports is the destination ports of the proxied connections
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=15
+	Ports []int32 `json:"ports"`
+}
+
+// PolicySchedule is the time windows in which the policy is active. Out of the windows,
+// the policy is released from its gateway node, and the traffic it selects leaves the
+// cluster as if there is no policy.
+type PolicySchedule struct {
+	// Windows is the time windows, the policy is active if now is in any of them
+	// +kubebuilder:validation:MinItems=1
+	Windows []ScheduleWindow `json:"windows"`
+	// TimeZone is the IANA name of the time zone of the windows, such as Asia/Shanghai,
+	// the default is UTC
+	// +kubebuilder:validation:Optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// ScheduleWindow begins at the times matching the cron expression, and lasts for the duration
+type ScheduleWindow struct {
+	// Start is a cron expression of 5 fields: minute, hour, day of month, month and day
+	// of week, such as "0 9 * * 1-5"
+	// +kubebuilder:validation:Required
+	Start string `json:"start"`
+	// Duration is how long the window lasts, such as "8h", from 1m to 168h
+	// +kubebuilder:validation:Required
+	Duration metav1.Duration `json:"duration"`
+}
+
+// DestSubnetSource is a source of the destination CIDRs of the policy, one of a destination
+// provider, a GeoIP selector and a Service
+type DestSubnetSource struct {
+	// Provider is the name of a destination provider in the controller configuration
+	// +kubebuilder:validation:Optional
+	Provider string `json:"provider,omitempty"`
+	// GeoIP selects the CIDRs by the GeoIP databases of the controller
+	// +kubebuilder:validation:Optional
+	GeoIP *GeoIPSelector `json:"geoIP,omitempty"`
+	// Service selects the endpoints of the Service, which is an ExternalName Service or a
+	// Service without selector whose EndpointSlices list the external endpoints
+	// +kubebuilder:validation:Optional
+	Service *ServiceReference `json:"service,omitempty"`
+}
+
+// ServiceReference refers to a Service
+type ServiceReference struct {
+	// Namespace of the Service, which is the namespace of the EgressPolicy if it's empty
+	// +kubebuilder:validation:Optional
+	Namespace string `json:"namespace,omitempty"`
+	// Name of the Service
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+}
+
+// GeoIPSelector selects the CIDRs of the countries and the autonomous systems
+type GeoIPSelector struct {
+	// Countries are the ISO 3166-1 alpha-2 codes of the countries, such as `US`
+	// +kubebuilder:validation:Optional
+	Countries []string `json:"countries,omitempty"`
+	// ASNs are the numbers of the autonomous systems
+	// +kubebuilder:validation:Optional
+	ASNs []int64 `json:"asns,omitempty"`
+}
+
+// NoDestSubnet is the destSubnet of a policy whose destSubnetFrom is not resolved yet, it
+// matches no traffic, rather than all the traffic out of the cluster as an empty one does
+var NoDestSubnet = []string{"0.0.0.0/32", "::/128"}
+
+// DestSubnets returns the destination CIDRs of the policy, which are spec.destSubnet and
+// status.resolvedDestSubnet
+func (in *EgressPolicy) DestSubnets() []string {
+	return withDestinationEIPs(destSubnets(in.Spec.DestSubnet, in.Spec.DestSubnetFrom, in.Status.ResolvedDestSubnet),
+		in.Spec.DestinationEIPs)
+}
+
+// DestSubnets returns the destination CIDRs of the policy, which are spec.destSubnet and
+// status.resolvedDestSubnet
+func (in *EgressClusterPolicy) DestSubnets() []string {
+	return withDestinationEIPs(destSubnets(in.Spec.DestSubnet, in.Spec.DestSubnetFrom, in.Status.ResolvedDestSubnet),
+		in.Spec.DestinationEIPs)
+}
+
+// withDestinationEIPs adds the destinations of the groups of the destination EIPs, which
+// are also sent to the gateway node. No destination means all the destinations out of
+// the cluster, which already contain them.
+func withDestinationEIPs(dests []string, groups []DestinationEIP) []string {
+	if len(dests) == 0 || len(groups) == 0 {
+		return dests
+	}
+	res := append([]string(nil), dests...)
+	for _, group := range groups {
+		res = append(res, group.DestSubnet...)
+	}
+	return res
+}
+
+func destSubnets(destSubnet []string, from []DestSubnetSource, resolved []string) []string {
+	if len(from) == 0 {
+		return destSubnet
+	}
+	res := make([]string, 0, len(destSubnet)+len(resolved))
+	res = append(res, destSubnet...)
+	res = append(res, resolved...)
+	if len(res) == 0 {
+		return NoDestSubnet
+	}
+	return res
+}
+
+// the strategies selecting an EgressGateway from the ones matching the selector
+const (
+	GatewaySelectionFirstReady  = "FirstReady"
+	GatewaySelectionLeastLoaded = "LeastLoaded"
+)
+
+// GatewaySelector selects an EgressGateway by the labels and the strategy
+type GatewaySelector struct {
+	// Selector selects the candidate EgressGateways by the labels
+	// +kubebuilder:validation:Required
+	Selector *metav1.LabelSelector `json:"selector"`
+	// Strategy is FirstReady or LeastLoaded. FirstReady selects the first Ready candidate
+	// by name, LeastLoaded selects the Ready candidate with the fewest policies, the
+	// default is FirstReady
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=FirstReady;LeastLoaded
+	Strategy string `json:"strategy,omitempty"`
+}
+
+// GatewaySelection is the result of the selection of the EgressGateway
+type GatewaySelection struct {
+	// Name is the name of the selected EgressGateway
+	Name string `json:"name"`
+	// Strategy is the strategy the gateway is selected by
+	Strategy string `json:"strategy"`
+	// Candidates is the number of the Ready EgressGateways matching the selector
+	Candidates int `json:"candidates"`
+	// LastSelectionTime is the time the gateway is selected
+	LastSelectionTime metav1.Time `json:"lastSelectionTime"`
+}
+
+// PolicyRollout selects the part of the Pods the policy applies to, one of Percentage and
+// PodSelector is required.
+type PolicyRollout struct {
+	// Percentage is the percentage of the Pods the policy applies to. The Pods are chosen
+	// by the hash of the policy and the Pod names, so a larger percentage always includes
+	// the Pods of a smaller one.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	Percentage *int32 `json:"percentage,omitempty"`
+	// PodSelector selects the Pods the policy applies to explicitly
+	// +kubebuilder:validation:Optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
 }
 
 type AppliedTo struct {
@@ -78,6 +620,10 @@ type AppliedTo struct {
 	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
 	// +kubebuilder:validation:Optional
 	PodSubnet []string `json:"podSubnet,omitempty"`
+	// StaticEndpoints is a list of IPs or CIDRs of workloads outside the cluster,
+	// such as VMs on the pod network, whose traffic is also sent to the gateway.
+	// +kubebuilder:validation:Optional
+	StaticEndpoints []string `json:"staticEndpoints,omitempty"`
 }
 
 func init() {
@@ -85,7 +631,7 @@ func init() {
 }
 
 func (eip EgressIP) IsEmpty() bool {
-	return eip.IPv4 == EgressIP{}.IPv4 && eip.IPv6 == EgressIP{}.IPv6 && eip.UseNodeIP == EgressIP{}.UseNodeIP && eip.AllocatorPolicy == EgressIP{}.AllocatorPolicy
+	return eip.IPv4 == EgressIP{}.IPv4 && eip.IPv6 == EgressIP{}.IPv6 && eip.UseNodeIP == EgressIP{}.UseNodeIP && eip.AllocatorPolicy == EgressIP{}.AllocatorPolicy && eip.ClaimName == EgressIP{}.ClaimName && eip.IPFamilyPolicy == EgressIP{}.IPFamilyPolicy
 }
 
 const (
@@ -94,3 +640,10 @@ const (
 	// The unassigned EIP is preferred. If no EIP is available, select one at random
 	EipAllocatorRR = "rr"
 )
+
+const (
+	// PolicyModeEnforce forwards the matched traffic to the gateway node and SNATs it with the EIP
+	PolicyModeEnforce = "Enforce"
+	// PolicyModeShadow only counts the matched traffic by destination on the nodes of the Pods
+	PolicyModeShadow = "Shadow"
+)
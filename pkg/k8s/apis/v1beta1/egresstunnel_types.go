@@ -4,6 +4,7 @@
 package v1beta1
 
 import (
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -38,12 +39,146 @@ type EgressTunnelSpec struct{}
 type EgressTunnelStatus struct {
 	// +kubebuilder:validation:Optional
 	Tunnel Tunnel `json:"tunnel,omitempty"`
-	// +kubebuilder:validation:Enum=Pending;Init;Failed;Ready;HeartbeatTimeout;NodeNotReady
+	// +kubebuilder:validation:Enum=Pending;Init;Failed;Ready;HeartbeatTimeout;NodeNotReady;Unreachable;UpstreamDown
 	Phase EgressTunnelPhase `json:"phase,omitempty"`
 	// +kubebuilder:validation:Optional
 	Mark string `json:"mark,omitempty"`
 	// +kubebuilder:validation:Optional
 	LastHeartbeatTime metav1.Time `json:"lastHeartbeatTime,omitempty"`
+	// +kubebuilder:validation:Optional
+	Peers []PeerStatus `json:"peers,omitempty"`
+	// +kubebuilder:validation:Optional
+	Upstreams []UpstreamStatus `json:"upstreams,omitempty"`
+	// +kubebuilder:validation:Optional
+	Networks []TunnelNetwork `json:"networks,omitempty"`
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// TunnelConditionReady is true when the phase of the tunnel is Ready
+	TunnelConditionReady = "Ready"
+	// TunnelConditionReturnPath is true when the replies from the gateway nodes come back
+	// through the tunnel, the message names the suspect node of the failures
+	TunnelConditionReturnPath = "ReturnPath"
+	// TunnelConditionDatapath is false when the datapath of the node is partially programmed,
+	// it's only set in the strict datapath mode of the agent
+	TunnelConditionDatapath = "Datapath"
+	// TunnelConditionNeighborTable is set once the neighbor tables of the node overflow, the
+	// kernel refuses the entries of the tunnel peers until the gc thresholds are raised
+	TunnelConditionNeighborTable = "NeighborTable"
+)
+
+const (
+	// NeighborTableOverflow the neighbor tables overflow and the thresholds can't be raised,
+	// the message tells the sysctls to raise
+	NeighborTableOverflow = "Overflow"
+	// NeighborTableThresholdRaised the gc thresholds of the neighbor tables are raised by the agent
+	NeighborTableThresholdRaised = "ThresholdRaised"
+	// NeighborTableRecovered the entries of the tunnel peers are programmed after the overflow
+	NeighborTableRecovered = "Recovered"
+)
+
+const (
+	// DatapathProgrammed the datapath of the node is fully programmed
+	DatapathProgrammed = "Programmed"
+	// DatapathPartialFailure some of the datapath of the node failed to be programmed, the
+	// message lists the failures
+	DatapathPartialFailure = "PartialFailure"
+)
+
+const (
+	// ReturnPathVerified the replies from all the gateway nodes come back through the tunnel
+	ReturnPathVerified = "Verified"
+	// ReturnPathReplyLost no reply comes back, which is dropped by rp_filter on the node typically
+	ReturnPathReplyLost = "ReplyLost"
+	// ReturnPathRequestNotViaTunnel the probes arrive at the gateway node out of the tunnel, the
+	// route of the node to the gateway node is missing
+	ReturnPathRequestNotViaTunnel = "RequestNotViaTunnel"
+	// ReturnPathReplyRouteNotViaTunnel the gateway node routes the replies out of the tunnel, the
+	// route of the gateway node to the node is missing
+	ReturnPathReplyRouteNotViaTunnel = "ReplyRouteNotViaTunnel"
+	// ReturnPathReplyNotViaTunnel the replies arrive at the node out of the tunnel
+	ReturnPathReplyNotViaTunnel = "ReplyNotViaTunnel"
+)
+
+// SetReadyCondition sets the Ready condition by the phase of the tunnel,
+// it returns true if the condition is changed.
+func (status *EgressTunnelStatus) SetReadyCondition(generation int64) bool {
+	ready := metav1.Condition{
+		Type:               TunnelConditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             string(status.Phase),
+		Message:            "the phase of the tunnel is " + string(status.Phase),
+		ObservedGeneration: generation,
+	}
+	if status.Phase == EgressTunnelReady {
+		ready.Status = metav1.ConditionTrue
+	}
+	if ready.Reason == "" {
+		ready.Reason = string(EgressTunnelPending)
+	}
+	return meta.SetStatusCondition(&status.Conditions, ready)
+}
+
+// TunnelNetwork is the tunnel of the node in the dedicated network of an EgressGateway
+type TunnelNetwork struct {
+	// +kubebuilder:validation:Optional
+	Gateway string `json:"gateway,omitempty"`
+	// +kubebuilder:validation:Optional
+	VNI int `json:"vni,omitempty"`
+	// +kubebuilder:validation:Optional
+	IPv4 string `json:"ipv4,omitempty"`
+	// +kubebuilder:validation:Optional
+	IPv6 string `json:"ipv6,omitempty"`
+	// +kubebuilder:validation:Optional
+	Mark string `json:"mark,omitempty"`
+}
+
+// GetNetwork returns the tunnel network of the gateway
+func (status *EgressTunnelStatus) GetNetwork(gateway string) (TunnelNetwork, bool) {
+	for _, item := range status.Networks {
+		if item.Gateway == gateway {
+			return item, true
+		}
+	}
+	return TunnelNetwork{}, false
+}
+
+// PeerStatus is the result of probing a peer through the tunnel
+type PeerStatus struct {
+	// +kubebuilder:validation:Optional
+	Name string `json:"name,omitempty"`
+	// +kubebuilder:validation:Optional
+	Reachable bool `json:"reachable"`
+	// +kubebuilder:validation:Optional
+	RTT metav1.Duration `json:"rtt,omitempty"`
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	Loss int `json:"loss"`
+	// +kubebuilder:validation:Optional
+	LastProbeTime metav1.Time `json:"lastProbeTime,omitempty"`
+}
+
+// UpstreamStatus is the result of probing a next hop of the default routes of the node
+type UpstreamStatus struct {
+	// +kubebuilder:validation:Optional
+	NextHop string `json:"nextHop,omitempty"`
+	// +kubebuilder:validation:Optional
+	Interface string `json:"interface,omitempty"`
+	// +kubebuilder:validation:Optional
+	Reachable bool `json:"reachable"`
+	// +kubebuilder:validation:Optional
+	RTT metav1.Duration `json:"rtt,omitempty"`
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	Loss int `json:"loss"`
+	// +kubebuilder:validation:Optional
+	LastProbeTime metav1.Time `json:"lastProbeTime,omitempty"`
 }
 
 type Tunnel struct {
@@ -55,6 +190,19 @@ type Tunnel struct {
 	MAC string `json:"mac,omitempty"`
 	// +kubebuilder:validation:Optional
 	Parent Parent `json:"parent,omitempty"`
+	// Previous is the tunnel addresses before the tunnel subnet is changed, they are
+	// kept on the node until the expire time to keep the existing traffic working.
+	// +kubebuilder:validation:Optional
+	Previous *PreviousTunnel `json:"previous,omitempty"`
+}
+
+type PreviousTunnel struct {
+	// +kubebuilder:validation:Optional
+	IPv4 string `json:"ipv4,omitempty"`
+	// +kubebuilder:validation:Optional
+	IPv6 string `json:"ipv6,omitempty"`
+	// +kubebuilder:validation:Optional
+	ExpireTime metav1.Time `json:"expireTime,omitempty"`
 }
 
 type Parent struct {
@@ -73,7 +221,8 @@ const (
 	EgressTunnelPending EgressTunnelPhase = "Pending"
 	// EgressTunnelInit Init tunnel address
 	EgressTunnelInit EgressTunnelPhase = "Init"
-	// EgressTunnelFailed allocate tunnel address failed
+	// EgressTunnelFailed allocate tunnel address failed, or the datapath of the node is
+	// partially programmed in the strict datapath mode
 	EgressTunnelFailed EgressTunnelPhase = "Failed"
 	// EgressTunnelHeartbeatTimeout tunnel heartbeat timeout
 	EgressTunnelHeartbeatTimeout EgressTunnelPhase = "HeartbeatTimeout"
@@ -81,10 +230,18 @@ const (
 	EgressTunnelNodeNotReady EgressTunnelPhase = "NodeNotReady"
 	// EgressTunnelReady tunnel is available
 	EgressTunnelReady EgressTunnelPhase = "Ready"
+	// EgressTunnelUnreachable peers can not reach the node through the tunnel
+	EgressTunnelUnreachable EgressTunnelPhase = "Unreachable"
+	// EgressTunnelUpstreamDown all the upstream next hops of the node are unreachable
+	EgressTunnelUpstreamDown EgressTunnelPhase = "UpstreamDown"
 )
 
 var ReasonStatusChanged = "StatusChanged"
 
+var ReasonTunnelIPConflict = "TunnelIPConflict"
+
+var ReasonMarkExhausted = "MarkExhausted"
+
 func init() {
 	SchemeBuilder.Register(&EgressTunnel{}, &EgressTunnelList{})
 }
@@ -6,4 +6,59 @@ package v1beta1
 const (
 	LabelPolicyName                    = "spidernet.io/policy-name"
 	LabelNamespaceEgressGatewayDefault = "spidernet.io/egressgateway-default"
+	// LabelNamespaceClusterDefaultOptOut opts the namespace out of the cluster default
+	// EgressClusterPolicies, its value should be "true"
+	LabelNamespaceClusterDefaultOptOut = "spidernet.io/egressgateway-cluster-default-opt-out"
+	// LabelPreferColocateWithGateway marks the Pods which prefer to be scheduled to
+	// the gateway node of their policies, its value should be "true"
+	LabelPreferColocateWithGateway = "spidernet.io/prefer-colocate-with-egress-gateway"
+	// LabelNATMappingExport requires the SNAT mappings of the connections of the policy to
+	// be exported by its gateway nodes, its value should be "true"
+	LabelNATMappingExport = "egressgateway.spidernet.io/nat-mapping-export"
+	// LabelCluster is the cluster name of the objects imported from or exported to
+	// another cluster by the multi-cluster broker
+	LabelCluster = "egressgateway.spidernet.io/cluster"
 )
+
+const (
+	// AnnotationSourceNamespace and AnnotationSourceName are the namespace and name
+	// of the policy which an exported EgressClusterPolicy is created for
+	AnnotationSourceNamespace = "egressgateway.spidernet.io/source-namespace"
+	AnnotationSourceName      = "egressgateway.spidernet.io/source-name"
+)
+
+const (
+	// AnnotationCapture requests a packet capture of the policy on its gateway node and
+	// the nodes of its Pods, the value identifies the capture, set a new value to capture
+	// again
+	AnnotationCapture = "egressgateway.spidernet.io/capture"
+	// AnnotationCaptureDuration is how long the capture runs, such as `30s`
+	AnnotationCaptureDuration = "egressgateway.spidernet.io/capture-duration"
+)
+
+const (
+	// AnnotationDNSName is the comma separated DNS names of the policy, which point at the
+	// EIP of the policy by the DNSEndpoint of external-dns
+	AnnotationDNSName = "egressgateway.spidernet.io/dns-name"
+	// AnnotationDNSTTL is the TTL of the DNS records of the policy in seconds
+	AnnotationDNSTTL = "egressgateway.spidernet.io/dns-ttl"
+)
+
+// ConditionDatapathReady is the condition of the Node set by the agent once the
+// datapath of the node converged after the agent started, it's also set on the Pods of
+// the node which have the readiness gate of it.
+const ConditionDatapathReady = "egressgateway.spidernet.io/DatapathReady"
+
+// IsImported returns whether the object is imported from another cluster, the
+// imported objects are managed by the multi-cluster broker only.
+func IsImported(labels map[string]string) bool {
+	_, ok := labels[LabelCluster]
+	return ok
+}
+
+// FinalizerPolicyCleanup is kept on EgressPolicy and EgressClusterPolicy until
+// the agents of all nodes have removed the datapath state of the policy.
+const FinalizerPolicyCleanup = "egressgateway.spidernet.io/policy-cleanup"
+
+// FinalizerClaimInUse is kept on EgressIPClaim until no policy references it
+const FinalizerClaimInUse = "egressgateway.spidernet.io/claim-in-use"
@@ -1,14 +1,25 @@
 // Copyright 2022 Authors of spidernet-io
 // SPDX-License-Identifier: Apache-2.0
 
-// +kubebuilder:rbac:groups=egressgateway.spidernet.io,resources=egressgateways;egresstunnels;egressclusterpolicies;egresspolicies;egressendpointslices;egressclusterendpointslices;egressclusterinfos,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=egressgateway.spidernet.io,resources=egressgateways/status;egresstunnels/status;egressclusterpolicies/status;egresspolicies/status;egressclusterinfos/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=egressgateway.spidernet.io,resources=egressgateways;egresstunnels;egressclusterpolicies;egresspolicies;egressendpointslices;egressclusterendpointslices;egressclusterinfos;egressipclaims;egressnodedatapaths,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=egressgateway.spidernet.io,resources=egressgateways/status;egresstunnels/status;egressclusterpolicies/status;egresspolicies/status;egressclusterinfos/status;egressipclaims/status;egressnodedatapaths/status,verbs=get;update;patch
 
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;get;list;watch;update;delete
 // +kubebuilder:rbac:groups="coordination.k8s.io",resources=leases,verbs=create;get;update
 // +kubebuilder:rbac:groups="",resources=nodes;namespaces;endpoints;pods;services,verbs=get;list;watch;update
+// +kubebuilder:rbac:groups="",resources=nodes/status;pods/status,verbs=get;patch
+// +kubebuilder:rbac:groups="",resources=secrets;configmaps,verbs=get;create;update
+// +kubebuilder:rbac:groups="apps",resources=daemonsets,verbs=get;delete
+// +kubebuilder:rbac:groups="batch",resources=jobs,verbs=create;get;delete
+// +kubebuilder:rbac:groups="authentication.k8s.io",resources=tokenreviews,verbs=create
 
 // +kubebuilder:rbac:groups=crd.projectcalico.org,resources=ippools,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=clustercidrs;networkpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cilium.io,resources=ciliumnetworkpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch
+// +kubebuilder:rbac:groups=externaldns.k8s.io,resources=dnsendpoints,verbs=get;list;watch;create;update;delete
+
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;create;update
 
 // +kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations;validatingwebhookconfigurations,verbs=get;list;watch;create;update;patch;delete
 
@@ -23,6 +23,11 @@ func (in *AppliedTo) DeepCopyInto(out *AppliedTo) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.StaticEndpoints != nil {
+		in, out := &in.StaticEndpoints, &out.StaticEndpoints
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppliedTo.
@@ -72,6 +77,11 @@ func (in *ClusterAppliedTo) DeepCopyInto(out *ClusterAppliedTo) {
 		*out = new(v1.LabelSelector)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.StaticEndpoints != nil {
+		in, out := &in.StaticEndpoints, &out.StaticEndpoints
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterAppliedTo.
@@ -84,6 +94,117 @@ func (in *ClusterAppliedTo) DeepCopy() *ClusterAppliedTo {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionLimits) DeepCopyInto(out *ConnectionLimits) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionLimits.
+func (in *ConnectionLimits) DeepCopy() *ConnectionLimits {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionLimits)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConntrackTimeouts) DeepCopyInto(out *ConntrackTimeouts) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConntrackTimeouts.
+func (in *ConntrackTimeouts) DeepCopy() *ConntrackTimeouts {
+	if in == nil {
+		return nil
+	}
+	out := new(ConntrackTimeouts)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DatapathSection) DeepCopyInto(out *DatapathSection) {
+	*out = *in
+	if in.Summary != nil {
+		in, out := &in.Summary, &out.Summary
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DatapathSection.
+func (in *DatapathSection) DeepCopy() *DatapathSection {
+	if in == nil {
+		return nil
+	}
+	out := new(DatapathSection)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DestSubnetSource) DeepCopyInto(out *DestSubnetSource) {
+	*out = *in
+	if in.GeoIP != nil {
+		in, out := &in.GeoIP, &out.GeoIP
+		*out = new(GeoIPSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Service != nil {
+		in, out := &in.Service, &out.Service
+		*out = new(ServiceReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DestSubnetSource.
+func (in *DestSubnetSource) DeepCopy() *DestSubnetSource {
+	if in == nil {
+		return nil
+	}
+	out := new(DestSubnetSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DestinationEIP) DeepCopyInto(out *DestinationEIP) {
+	*out = *in
+	if in.DestSubnet != nil {
+		in, out := &in.DestSubnet, &out.DestSubnet
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DestinationEIP.
+func (in *DestinationEIP) DeepCopy() *DestinationEIP {
+	if in == nil {
+		return nil
+	}
+	out := new(DestinationEIP)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DestinationEips) DeepCopyInto(out *DestinationEips) {
+	*out = *in
+	out.Policy = in.Policy
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DestinationEips.
+func (in *DestinationEips) DeepCopy() *DestinationEips {
+	if in == nil {
+		return nil
+	}
+	out := new(DestinationEips)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EgressClusterEndpointSlice) DeepCopyInto(out *EgressClusterEndpointSlice) {
 	*out = *in
@@ -273,7 +394,7 @@ func (in *EgressClusterPolicy) DeepCopyInto(out *EgressClusterPolicy) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressClusterPolicy.
@@ -336,6 +457,50 @@ func (in *EgressClusterPolicySpec) DeepCopyInto(out *EgressClusterPolicySpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.DestSubnetFrom != nil {
+		in, out := &in.DestSubnetFrom, &out.DestSubnetFrom
+		*out = make([]DestSubnetSource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Limits != nil {
+		in, out := &in.Limits, &out.Limits
+		*out = new(ConnectionLimits)
+		**out = **in
+	}
+	if in.Logging != nil {
+		in, out := &in.Logging, &out.Logging
+		*out = new(PolicyLogging)
+		**out = **in
+	}
+	if in.ProxyProtocol != nil {
+		in, out := &in.ProxyProtocol, &out.ProxyProtocol
+		*out = new(ProxyProtocol)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.L7Proxy != nil {
+		in, out := &in.L7Proxy, &out.L7Proxy
+		*out = new(L7Proxy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Schedule != nil {
+		in, out := &in.Schedule, &out.Schedule
+		*out = new(PolicySchedule)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DestinationEIPs != nil {
+		in, out := &in.DestinationEIPs, &out.DestinationEIPs
+		*out = make([]DestinationEIP, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ExcludeNodes != nil {
+		in, out := &in.ExcludeNodes, &out.ExcludeNodes
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressClusterPolicySpec.
@@ -501,6 +666,21 @@ func (in *EgressGatewaySpec) DeepCopyInto(out *EgressGatewaySpec) {
 	*out = *in
 	in.Ippools.DeepCopyInto(&out.Ippools)
 	in.NodeSelector.DeepCopyInto(&out.NodeSelector)
+	if in.Tunnel != nil {
+		in, out := &in.Tunnel, &out.Tunnel
+		*out = new(GatewayTunnel)
+		**out = **in
+	}
+	if in.SNAT != nil {
+		in, out := &in.SNAT, &out.SNAT
+		*out = new(SNAT)
+		**out = **in
+	}
+	if in.Conntrack != nil {
+		in, out := &in.Conntrack, &out.Conntrack
+		*out = new(ConntrackTimeouts)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressGatewaySpec.
@@ -524,6 +704,37 @@ func (in *EgressGatewayStatus) DeepCopyInto(out *EgressGatewayStatus) {
 		}
 	}
 	out.IPUsage = in.IPUsage
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.AppliedNodes != nil {
+		in, out := &in.AppliedNodes, &out.AppliedNodes
+		*out = make([]NodeAppliedStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.QuarantinedIPs != nil {
+		in, out := &in.QuarantinedIPs, &out.QuarantinedIPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.FailoverHistory != nil {
+		in, out := &in.FailoverHistory, &out.FailoverHistory
+		*out = make([]FailoverRecord, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.StandbyNodes != nil {
+		in, out := &in.StandbyNodes, &out.StandbyNodes
+		*out = make([]NodeStandbyStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressGatewayStatus.
@@ -552,48 +763,26 @@ func (in *EgressIP) DeepCopy() *EgressIP {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *EgressIPStatus) DeepCopyInto(out *EgressIPStatus) {
-	*out = *in
-	if in.Eips != nil {
-		in, out := &in.Eips, &out.Eips
-		*out = make([]Eips, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
-	}
-}
-
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPStatus.
-func (in *EgressIPStatus) DeepCopy() *EgressIPStatus {
-	if in == nil {
-		return nil
-	}
-	out := new(EgressIPStatus)
-	in.DeepCopyInto(out)
-	return out
-}
-
-// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *EgressPolicy) DeepCopyInto(out *EgressPolicy) {
+func (in *EgressIPClaim) DeepCopyInto(out *EgressIPClaim) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressPolicy.
-func (in *EgressPolicy) DeepCopy() *EgressPolicy {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPClaim.
+func (in *EgressIPClaim) DeepCopy() *EgressIPClaim {
 	if in == nil {
 		return nil
 	}
-	out := new(EgressPolicy)
+	out := new(EgressIPClaim)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *EgressPolicy) DeepCopyObject() runtime.Object {
+func (in *EgressIPClaim) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -601,31 +790,31 @@ func (in *EgressPolicy) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *EgressPolicyList) DeepCopyInto(out *EgressPolicyList) {
+func (in *EgressIPClaimList) DeepCopyInto(out *EgressIPClaimList) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ListMeta.DeepCopyInto(&out.ListMeta)
 	if in.Items != nil {
 		in, out := &in.Items, &out.Items
-		*out = make([]EgressPolicy, len(*in))
+		*out = make([]EgressIPClaim, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressPolicyList.
-func (in *EgressPolicyList) DeepCopy() *EgressPolicyList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPClaimList.
+func (in *EgressIPClaimList) DeepCopy() *EgressIPClaimList {
 	if in == nil {
 		return nil
 	}
-	out := new(EgressPolicyList)
+	out := new(EgressIPClaimList)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *EgressPolicyList) DeepCopyObject() runtime.Object {
+func (in *EgressIPClaimList) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -633,64 +822,102 @@ func (in *EgressPolicyList) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *EgressPolicySpec) DeepCopyInto(out *EgressPolicySpec) {
+func (in *EgressIPClaimSpec) DeepCopyInto(out *EgressIPClaimSpec) {
 	*out = *in
-	out.EgressIP = in.EgressIP
-	in.AppliedTo.DeepCopyInto(&out.AppliedTo)
-	if in.DestSubnet != nil {
-		in, out := &in.DestSubnet, &out.DestSubnet
-		*out = make([]string, len(*in))
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPClaimSpec.
+func (in *EgressIPClaimSpec) DeepCopy() *EgressIPClaimSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressIPClaimSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressIPClaimStatus) DeepCopyInto(out *EgressIPClaimStatus) {
+	*out = *in
+	out.Eip = in.Eip
+	if in.Policies != nil {
+		in, out := &in.Policies, &out.Policies
+		*out = make([]Policy, len(*in))
 		copy(*out, *in)
 	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressPolicySpec.
-func (in *EgressPolicySpec) DeepCopy() *EgressPolicySpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPClaimStatus.
+func (in *EgressIPClaimStatus) DeepCopy() *EgressIPClaimStatus {
 	if in == nil {
 		return nil
 	}
-	out := new(EgressPolicySpec)
+	out := new(EgressIPClaimStatus)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *EgressPolicyStatus) DeepCopyInto(out *EgressPolicyStatus) {
+func (in *EgressIPStatus) DeepCopyInto(out *EgressIPStatus) {
 	*out = *in
-	out.Eip = in.Eip
+	if in.Eips != nil {
+		in, out := &in.Eips, &out.Eips
+		*out = make([]Eips, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.DestinationEips != nil {
+		in, out := &in.DestinationEips, &out.DestinationEips
+		*out = make([]DestinationEips, len(*in))
+		copy(*out, *in)
+	}
+	if in.StandbyEips != nil {
+		in, out := &in.StandbyEips, &out.StandbyEips
+		*out = make([]Eips, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressPolicyStatus.
-func (in *EgressPolicyStatus) DeepCopy() *EgressPolicyStatus {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIPStatus.
+func (in *EgressIPStatus) DeepCopy() *EgressIPStatus {
 	if in == nil {
 		return nil
 	}
-	out := new(EgressPolicyStatus)
+	out := new(EgressIPStatus)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *EgressTunnel) DeepCopyInto(out *EgressTunnel) {
+func (in *EgressNodeDatapath) DeepCopyInto(out *EgressNodeDatapath) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
 	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressTunnel.
-func (in *EgressTunnel) DeepCopy() *EgressTunnel {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressNodeDatapath.
+func (in *EgressNodeDatapath) DeepCopy() *EgressNodeDatapath {
 	if in == nil {
 		return nil
 	}
-	out := new(EgressTunnel)
+	out := new(EgressNodeDatapath)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *EgressTunnel) DeepCopyObject() runtime.Object {
+func (in *EgressNodeDatapath) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -698,31 +925,31 @@ func (in *EgressTunnel) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *EgressTunnelList) DeepCopyInto(out *EgressTunnelList) {
+func (in *EgressNodeDatapathList) DeepCopyInto(out *EgressNodeDatapathList) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ListMeta.DeepCopyInto(&out.ListMeta)
 	if in.Items != nil {
 		in, out := &in.Items, &out.Items
-		*out = make([]EgressTunnel, len(*in))
+		*out = make([]EgressNodeDatapath, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressTunnelList.
-func (in *EgressTunnelList) DeepCopy() *EgressTunnelList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressNodeDatapathList.
+func (in *EgressNodeDatapathList) DeepCopy() *EgressNodeDatapathList {
 	if in == nil {
 		return nil
 	}
-	out := new(EgressTunnelList)
+	out := new(EgressNodeDatapathList)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *EgressTunnelList) DeepCopyObject() runtime.Object {
+func (in *EgressNodeDatapathList) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -730,46 +957,336 @@ func (in *EgressTunnelList) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *EgressTunnelSpec) DeepCopyInto(out *EgressTunnelSpec) {
+func (in *EgressNodeDatapathStatus) DeepCopyInto(out *EgressNodeDatapathStatus) {
 	*out = *in
+	in.UpdateTime.DeepCopyInto(&out.UpdateTime)
+	if in.Sections != nil {
+		in, out := &in.Sections, &out.Sections
+		*out = make([]DatapathSection, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressTunnelSpec.
-func (in *EgressTunnelSpec) DeepCopy() *EgressTunnelSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressNodeDatapathStatus.
+func (in *EgressNodeDatapathStatus) DeepCopy() *EgressNodeDatapathStatus {
 	if in == nil {
 		return nil
 	}
-	out := new(EgressTunnelSpec)
+	out := new(EgressNodeDatapathStatus)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *EgressTunnelStatus) DeepCopyInto(out *EgressTunnelStatus) {
+func (in *EgressPolicy) DeepCopyInto(out *EgressPolicy) {
 	*out = *in
-	out.Tunnel = in.Tunnel
-	in.LastHeartbeatTime.DeepCopyInto(&out.LastHeartbeatTime)
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressTunnelStatus.
-func (in *EgressTunnelStatus) DeepCopy() *EgressTunnelStatus {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressPolicy.
+func (in *EgressPolicy) DeepCopy() *EgressPolicy {
 	if in == nil {
 		return nil
 	}
-	out := new(EgressTunnelStatus)
+	out := new(EgressPolicy)
 	in.DeepCopyInto(out)
 	return out
 }
 
-// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *Eip) DeepCopyInto(out *Eip) {
-	*out = *in
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Eip.
-func (in *Eip) DeepCopy() *Eip {
-	if in == nil {
-		return nil
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressPolicyList) DeepCopyInto(out *EgressPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]EgressPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressPolicyList.
+func (in *EgressPolicyList) DeepCopy() *EgressPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressPolicySpec) DeepCopyInto(out *EgressPolicySpec) {
+	*out = *in
+	out.EgressIP = in.EgressIP
+	in.AppliedTo.DeepCopyInto(&out.AppliedTo)
+	if in.DestSubnet != nil {
+		in, out := &in.DestSubnet, &out.DestSubnet
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DestSubnetFrom != nil {
+		in, out := &in.DestSubnetFrom, &out.DestSubnetFrom
+		*out = make([]DestSubnetSource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Limits != nil {
+		in, out := &in.Limits, &out.Limits
+		*out = new(ConnectionLimits)
+		**out = **in
+	}
+	if in.Logging != nil {
+		in, out := &in.Logging, &out.Logging
+		*out = new(PolicyLogging)
+		**out = **in
+	}
+	if in.ProxyProtocol != nil {
+		in, out := &in.ProxyProtocol, &out.ProxyProtocol
+		*out = new(ProxyProtocol)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.L7Proxy != nil {
+		in, out := &in.L7Proxy, &out.L7Proxy
+		*out = new(L7Proxy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Schedule != nil {
+		in, out := &in.Schedule, &out.Schedule
+		*out = new(PolicySchedule)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Rollout != nil {
+		in, out := &in.Rollout, &out.Rollout
+		*out = new(PolicyRollout)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DestinationEIPs != nil {
+		in, out := &in.DestinationEIPs, &out.DestinationEIPs
+		*out = make([]DestinationEIP, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.EgressGatewaySelector != nil {
+		in, out := &in.EgressGatewaySelector, &out.EgressGatewaySelector
+		*out = new(GatewaySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressPolicySpec.
+func (in *EgressPolicySpec) DeepCopy() *EgressPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressPolicyStatus) DeepCopyInto(out *EgressPolicyStatus) {
+	*out = *in
+	out.Eip = in.Eip
+	out.NodeIP = in.NodeIP
+	if in.CleanedNodes != nil {
+		in, out := &in.CleanedNodes, &out.CleanedNodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AppliedNodes != nil {
+		in, out := &in.AppliedNodes, &out.AppliedNodes
+		*out = make([]NodeAppliedStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.ResolvedDestSubnet != nil {
+		in, out := &in.ResolvedDestSubnet, &out.ResolvedDestSubnet
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Counters != nil {
+		in, out := &in.Counters, &out.Counters
+		*out = make([]NodePolicyCounters, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	out.VerifiedEIP = in.VerifiedEIP
+	if in.SelectedGateway != nil {
+		in, out := &in.SelectedGateway, &out.SelectedGateway
+		*out = new(GatewaySelection)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressPolicyStatus.
+func (in *EgressPolicyStatus) DeepCopy() *EgressPolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressPolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressTunnel) DeepCopyInto(out *EgressTunnel) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressTunnel.
+func (in *EgressTunnel) DeepCopy() *EgressTunnel {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressTunnel)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressTunnel) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressTunnelList) DeepCopyInto(out *EgressTunnelList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]EgressTunnel, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressTunnelList.
+func (in *EgressTunnelList) DeepCopy() *EgressTunnelList {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressTunnelList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressTunnelList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressTunnelSpec) DeepCopyInto(out *EgressTunnelSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressTunnelSpec.
+func (in *EgressTunnelSpec) DeepCopy() *EgressTunnelSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressTunnelSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressTunnelStatus) DeepCopyInto(out *EgressTunnelStatus) {
+	*out = *in
+	in.Tunnel.DeepCopyInto(&out.Tunnel)
+	in.LastHeartbeatTime.DeepCopyInto(&out.LastHeartbeatTime)
+	if in.Peers != nil {
+		in, out := &in.Peers, &out.Peers
+		*out = make([]PeerStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Upstreams != nil {
+		in, out := &in.Upstreams, &out.Upstreams
+		*out = make([]UpstreamStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Networks != nil {
+		in, out := &in.Networks, &out.Networks
+		*out = make([]TunnelNetwork, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressTunnelStatus.
+func (in *EgressTunnelStatus) DeepCopy() *EgressTunnelStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressTunnelStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Eip) DeepCopyInto(out *Eip) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Eip.
+func (in *Eip) DeepCopy() *Eip {
+	if in == nil {
+		return nil
 	}
 	out := new(Eip)
 	in.DeepCopyInto(out)
@@ -796,6 +1313,98 @@ func (in *Eips) DeepCopy() *Eips {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FailoverRecord) DeepCopyInto(out *FailoverRecord) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FailoverRecord.
+func (in *FailoverRecord) DeepCopy() *FailoverRecord {
+	if in == nil {
+		return nil
+	}
+	out := new(FailoverRecord)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatewaySelection) DeepCopyInto(out *GatewaySelection) {
+	*out = *in
+	in.LastSelectionTime.DeepCopyInto(&out.LastSelectionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewaySelection.
+func (in *GatewaySelection) DeepCopy() *GatewaySelection {
+	if in == nil {
+		return nil
+	}
+	out := new(GatewaySelection)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatewaySelector) DeepCopyInto(out *GatewaySelector) {
+	*out = *in
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewaySelector.
+func (in *GatewaySelector) DeepCopy() *GatewaySelector {
+	if in == nil {
+		return nil
+	}
+	out := new(GatewaySelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatewayTunnel) DeepCopyInto(out *GatewayTunnel) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewayTunnel.
+func (in *GatewayTunnel) DeepCopy() *GatewayTunnel {
+	if in == nil {
+		return nil
+	}
+	out := new(GatewayTunnel)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GeoIPSelector) DeepCopyInto(out *GeoIPSelector) {
+	*out = *in
+	if in.Countries != nil {
+		in, out := &in.Countries, &out.Countries
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ASNs != nil {
+		in, out := &in.ASNs, &out.ASNs
+		*out = make([]int64, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GeoIPSelector.
+func (in *GeoIPSelector) DeepCopy() *GeoIPSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(GeoIPSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IPListPair) DeepCopyInto(out *IPListPair) {
 	*out = *in
@@ -861,6 +1470,57 @@ func (in *Ippools) DeepCopy() *Ippools {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *L7Proxy) DeepCopyInto(out *L7Proxy) {
+	*out = *in
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new L7Proxy.
+func (in *L7Proxy) DeepCopy() *L7Proxy {
+	if in == nil {
+		return nil
+	}
+	out := new(L7Proxy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeAppliedStatus) DeepCopyInto(out *NodeAppliedStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeAppliedStatus.
+func (in *NodeAppliedStatus) DeepCopy() *NodeAppliedStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeAppliedStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodePolicyCounters) DeepCopyInto(out *NodePolicyCounters) {
+	*out = *in
+	in.SampleTime.DeepCopyInto(&out.SampleTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodePolicyCounters.
+func (in *NodePolicyCounters) DeepCopy() *NodePolicyCounters {
+	if in == nil {
+		return nil
+	}
+	out := new(NodePolicyCounters)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeSelector) DeepCopyInto(out *NodeSelector) {
 	*out = *in
@@ -881,6 +1541,26 @@ func (in *NodeSelector) DeepCopy() *NodeSelector {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeStandbyStatus) DeepCopyInto(out *NodeStandbyStatus) {
+	*out = *in
+	if in.IPs != nil {
+		in, out := &in.IPs, &out.IPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeStandbyStatus.
+func (in *NodeStandbyStatus) DeepCopy() *NodeStandbyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeStandbyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Parent) DeepCopyInto(out *Parent) {
 	*out = *in
@@ -896,6 +1576,23 @@ func (in *Parent) DeepCopy() *Parent {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PeerStatus) DeepCopyInto(out *PeerStatus) {
+	*out = *in
+	out.RTT = in.RTT
+	in.LastProbeTime.DeepCopyInto(&out.LastProbeTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PeerStatus.
+func (in *PeerStatus) DeepCopy() *PeerStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PeerStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Policy) DeepCopyInto(out *Policy) {
 	*out = *in
@@ -911,10 +1608,157 @@ func (in *Policy) DeepCopy() *Policy {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PolicyLogging) DeepCopyInto(out *PolicyLogging) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PolicyLogging.
+func (in *PolicyLogging) DeepCopy() *PolicyLogging {
+	if in == nil {
+		return nil
+	}
+	out := new(PolicyLogging)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PolicyRollout) DeepCopyInto(out *PolicyRollout) {
+	*out = *in
+	if in.Percentage != nil {
+		in, out := &in.Percentage, &out.Percentage
+		*out = new(int32)
+		**out = **in
+	}
+	if in.PodSelector != nil {
+		in, out := &in.PodSelector, &out.PodSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PolicyRollout.
+func (in *PolicyRollout) DeepCopy() *PolicyRollout {
+	if in == nil {
+		return nil
+	}
+	out := new(PolicyRollout)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PolicySchedule) DeepCopyInto(out *PolicySchedule) {
+	*out = *in
+	if in.Windows != nil {
+		in, out := &in.Windows, &out.Windows
+		*out = make([]ScheduleWindow, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PolicySchedule.
+func (in *PolicySchedule) DeepCopy() *PolicySchedule {
+	if in == nil {
+		return nil
+	}
+	out := new(PolicySchedule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PreviousTunnel) DeepCopyInto(out *PreviousTunnel) {
+	*out = *in
+	in.ExpireTime.DeepCopyInto(&out.ExpireTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PreviousTunnel.
+func (in *PreviousTunnel) DeepCopy() *PreviousTunnel {
+	if in == nil {
+		return nil
+	}
+	out := new(PreviousTunnel)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProxyProtocol) DeepCopyInto(out *ProxyProtocol) {
+	*out = *in
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProxyProtocol.
+func (in *ProxyProtocol) DeepCopy() *ProxyProtocol {
+	if in == nil {
+		return nil
+	}
+	out := new(ProxyProtocol)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SNAT) DeepCopyInto(out *SNAT) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SNAT.
+func (in *SNAT) DeepCopy() *SNAT {
+	if in == nil {
+		return nil
+	}
+	out := new(SNAT)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScheduleWindow) DeepCopyInto(out *ScheduleWindow) {
+	*out = *in
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScheduleWindow.
+func (in *ScheduleWindow) DeepCopy() *ScheduleWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(ScheduleWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceReference) DeepCopyInto(out *ServiceReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceReference.
+func (in *ServiceReference) DeepCopy() *ServiceReference {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Tunnel) DeepCopyInto(out *Tunnel) {
 	*out = *in
 	out.Parent = in.Parent
+	if in.Previous != nil {
+		in, out := &in.Previous, &out.Previous
+		*out = new(PreviousTunnel)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Tunnel.
@@ -926,3 +1770,35 @@ func (in *Tunnel) DeepCopy() *Tunnel {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TunnelNetwork) DeepCopyInto(out *TunnelNetwork) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TunnelNetwork.
+func (in *TunnelNetwork) DeepCopy() *TunnelNetwork {
+	if in == nil {
+		return nil
+	}
+	out := new(TunnelNetwork)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpstreamStatus) DeepCopyInto(out *UpstreamStatus) {
+	*out = *in
+	out.RTT = in.RTT
+	in.LastProbeTime.DeepCopyInto(&out.LastProbeTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpstreamStatus.
+func (in *UpstreamStatus) DeepCopy() *UpstreamStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(UpstreamStatus)
+	in.DeepCopyInto(out)
+	return out
+}
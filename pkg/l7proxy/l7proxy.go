@@ -0,0 +1,236 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package l7proxy reads the requests of the HTTP and SOCKS5 proxy clients. The HTTP CONNECT
+// and the SOCKS5 CONNECT requests are tunneled to their targets, the other HTTP requests in
+// the absolute form are forwarded to their targets.
+package l7proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+type Protocol string
+
+const (
+	ProtocolSOCKS5      Protocol = "socks5"
+	ProtocolHTTPConnect Protocol = "http-connect"
+	ProtocolHTTP        Protocol = "http"
+)
+
+// Reply is the result of the request told to the client
+type Reply int
+
+const (
+	ReplySucceeded Reply = iota
+	ReplyFailure
+	ReplyNotAllowed
+	ReplyUnreachable
+	ReplyRefused
+	ReplyNotSupported
+)
+
+const (
+	socks5Version = 0x05
+	socks4Version = 0x04
+
+	socks5NoAuth       = 0x00
+	socks5NoAcceptable = 0xff
+	socks5Connect      = 0x01
+
+	socks5IPv4   = 0x01
+	socks5Domain = 0x03
+	socks5IPv6   = 0x04
+
+	// the reply codes of RFC 1928
+	socks5Succeeded          = 0x00
+	socks5Failure            = 0x01
+	socks5NotAllowed         = 0x02
+	socks5HostUnreachable    = 0x04
+	socks5Refused            = 0x05
+	socks5CommandUnsupported = 0x07
+	socks5AddrUnsupported    = 0x08
+)
+
+// Request is a request of the proxy client
+type Request struct {
+	Protocol Protocol
+	// Target is the host and the port to connect
+	Target string
+	// Method and URL are of the HTTP requests
+	Method string
+	URL    string
+
+	// forward is the HTTP request forwarded to the target
+	forward *http.Request
+}
+
+// ReadRequest reads the request of the client from r, the SOCKS5 method negotiation is
+// replied to w. The requests known but not supported are rejected to w before the error
+// is returned.
+func ReadRequest(r *bufio.Reader, w io.Writer) (*Request, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case socks5Version:
+		return readSOCKS5(r, w)
+	case socks4Version:
+		return nil, errors.New("SOCKS4 is not supported")
+	}
+	return readHTTP(r, w)
+}
+
+func readSOCKS5(r *bufio.Reader, w io.Writer) (*Request, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return nil, err
+	}
+	noAuth := false
+	for _, method := range methods {
+		if method == socks5NoAuth {
+			noAuth = true
+		}
+	}
+	if !noAuth {
+		_, _ = w.Write([]byte{socks5Version, socks5NoAcceptable})
+		return nil, errors.New("the SOCKS5 client requires authentication")
+	}
+	if _, err := w.Write([]byte{socks5Version, socks5NoAuth}); err != nil {
+		return nil, err
+	}
+
+	head = make([]byte, 4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if head[0] != socks5Version {
+		return nil, fmt.Errorf("invalid SOCKS5 version %d", head[0])
+	}
+	if head[1] != socks5Connect {
+		_ = writeSOCKS5Reply(w, socks5CommandUnsupported)
+		return nil, fmt.Errorf("SOCKS5 command %d is not supported", head[1])
+	}
+	var host string
+	switch head[3] {
+	case socks5IPv4, socks5IPv6:
+		size := net.IPv4len
+		if head[3] == socks5IPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return nil, err
+		}
+		host = net.IP(ip).String()
+	case socks5Domain:
+		size, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		name := make([]byte, size)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, err
+		}
+		host = string(name)
+	default:
+		_ = writeSOCKS5Reply(w, socks5AddrUnsupported)
+		return nil, fmt.Errorf("SOCKS5 address type %d is not supported", head[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return nil, err
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	return &Request{Protocol: ProtocolSOCKS5, Target: target}, nil
+}
+
+func readHTTP(r *bufio.Reader, w io.Writer) (*Request, error) {
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	if req.Method == http.MethodConnect {
+		if _, _, err := net.SplitHostPort(req.Host); err != nil {
+			_ = writeHTTPReply(w, http.StatusBadRequest)
+			return nil, fmt.Errorf("invalid CONNECT target %q", req.Host)
+		}
+		return &Request{Protocol: ProtocolHTTPConnect, Target: req.Host, Method: req.Method, URL: req.Host}, nil
+	}
+	// the requests to the proxy itself or the TLS ones can't be forwarded
+	if req.URL.Host == "" || req.URL.Scheme != "http" {
+		_ = writeHTTPReply(w, http.StatusBadRequest)
+		return nil, fmt.Errorf("the HTTP request %s %s is not in the absolute form", req.Method, req.RequestURI)
+	}
+	target := req.URL.Host
+	if req.URL.Port() == "" {
+		target = net.JoinHostPort(req.URL.Hostname(), "80")
+	}
+	req.Header.Del("Proxy-Connection")
+	req.Header.Del("Proxy-Authorization")
+	// the connection to the target serves only this request, the next request of the client
+	// may be to another target
+	req.Close = true
+	return &Request{Protocol: ProtocolHTTP, Target: target, Method: req.Method, URL: req.URL.String(), forward: req}, nil
+}
+
+// Accept tells the client the target is connected, the forwarded HTTP request is written to
+// the upstream instead, and the response of the upstream tells the client
+func (req *Request) Accept(client io.Writer, upstream io.Writer) error {
+	switch req.Protocol {
+	case ProtocolSOCKS5:
+		return writeSOCKS5Reply(client, socks5Succeeded)
+	case ProtocolHTTPConnect:
+		_, err := io.WriteString(client, "HTTP/1.1 200 Connection established\r\n\r\n")
+		return err
+	}
+	return req.forward.Write(upstream)
+}
+
+// Reject tells the client the request fails
+func (req *Request) Reject(client io.Writer, reply Reply) error {
+	if req.Protocol == ProtocolSOCKS5 {
+		code := map[Reply]byte{
+			ReplyNotAllowed:   socks5NotAllowed,
+			ReplyUnreachable:  socks5HostUnreachable,
+			ReplyRefused:      socks5Refused,
+			ReplyNotSupported: socks5CommandUnsupported,
+		}[reply]
+		if code == 0 {
+			code = socks5Failure
+		}
+		return writeSOCKS5Reply(client, code)
+	}
+	status := map[Reply]int{
+		ReplyNotAllowed:   http.StatusForbidden,
+		ReplyNotSupported: http.StatusNotImplemented,
+	}[reply]
+	if status == 0 {
+		status = http.StatusBadGateway
+	}
+	return writeHTTPReply(client, status)
+}
+
+// writeSOCKS5Reply writes the reply without the bound address, which the clients don't use
+// for the CONNECT requests
+func writeSOCKS5Reply(w io.Writer, code byte) error {
+	_, err := w.Write([]byte{socks5Version, code, 0x00, socks5IPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+func writeHTTPReply(w io.Writer, status int) error {
+	_, err := fmt.Fprintf(w, "HTTP/1.1 %d %s\r\nConnection: close\r\nContent-Length: 0\r\n\r\n", status, http.StatusText(status))
+	return err
+}
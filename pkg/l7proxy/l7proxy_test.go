@@ -0,0 +1,95 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package l7proxy
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadSOCKS5(t *testing.T) {
+	in := []byte{
+		0x05, 0x01, 0x00,
+		0x05, 0x01, 0x00, 0x03, 11, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm', 0x01, 0xbb,
+	}
+	out := new(bytes.Buffer)
+	req, err := ReadRequest(bufio.NewReader(bytes.NewReader(in)), out)
+	assert.NoError(t, err)
+	assert.Equal(t, ProtocolSOCKS5, req.Protocol)
+	assert.Equal(t, "example.com:443", req.Target)
+	assert.Equal(t, []byte{0x05, 0x00}, out.Bytes())
+
+	out.Reset()
+	assert.NoError(t, req.Accept(out, nil))
+	assert.Equal(t, []byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}, out.Bytes())
+	out.Reset()
+	assert.NoError(t, req.Reject(out, ReplyRefused))
+	assert.Equal(t, byte(0x05), out.Bytes()[1])
+
+	// the IPv6 targets
+	in = []byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x04}
+	in = append(in, make([]byte, 15)...)
+	in = append(in, 0x01, 0x00, 0x50)
+	req, err = ReadRequest(bufio.NewReader(bytes.NewReader(in)), new(bytes.Buffer))
+	assert.NoError(t, err)
+	assert.Equal(t, "[::1]:80", req.Target)
+
+	// the clients requiring the authentication
+	out.Reset()
+	_, err = ReadRequest(bufio.NewReader(bytes.NewReader([]byte{0x05, 0x01, 0x02})), out)
+	assert.Error(t, err)
+	assert.Equal(t, []byte{0x05, 0xff}, out.Bytes())
+
+	// the BIND requests
+	out.Reset()
+	_, err = ReadRequest(bufio.NewReader(bytes.NewReader([]byte{0x05, 0x01, 0x00, 0x05, 0x02, 0x00, 0x01})), out)
+	assert.ErrorContains(t, err, "command 2")
+	assert.Equal(t, byte(0x07), out.Bytes()[3])
+}
+
+func TestReadHTTPConnect(t *testing.T) {
+	in := "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"
+	req, err := ReadRequest(bufio.NewReader(strings.NewReader(in)), new(bytes.Buffer))
+	assert.NoError(t, err)
+	assert.Equal(t, ProtocolHTTPConnect, req.Protocol)
+	assert.Equal(t, "example.com:443", req.Target)
+
+	out := new(bytes.Buffer)
+	assert.NoError(t, req.Accept(out, nil))
+	assert.Equal(t, "HTTP/1.1 200 Connection established\r\n\r\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, req.Reject(out, ReplyNotAllowed))
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 403 Forbidden\r\n"))
+}
+
+func TestReadHTTPForward(t *testing.T) {
+	in := "GET http://example.com/path?q=1 HTTP/1.1\r\nHost: example.com\r\nProxy-Connection: keep-alive\r\n\r\n"
+	req, err := ReadRequest(bufio.NewReader(strings.NewReader(in)), new(bytes.Buffer))
+	assert.NoError(t, err)
+	assert.Equal(t, ProtocolHTTP, req.Protocol)
+	assert.Equal(t, "example.com:80", req.Target)
+	assert.Equal(t, "http://example.com/path?q=1", req.URL)
+
+	client, upstream := new(bytes.Buffer), new(bytes.Buffer)
+	assert.NoError(t, req.Accept(client, upstream))
+	assert.Empty(t, client.String())
+	forwarded := upstream.String()
+	assert.True(t, strings.HasPrefix(forwarded, "GET /path?q=1 HTTP/1.1\r\n"), forwarded)
+	assert.Contains(t, forwarded, "Connection: close\r\n")
+	assert.NotContains(t, forwarded, "Proxy-Connection")
+
+	// the requests not in the absolute form
+	out := new(bytes.Buffer)
+	_, err = ReadRequest(bufio.NewReader(strings.NewReader("GET /path HTTP/1.1\r\nHost: example.com\r\n\r\n")), out)
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 400 Bad Request\r\n"))
+
+	_, err = ReadRequest(bufio.NewReader(bytes.NewReader([]byte{0x04, 0x01})), out)
+	assert.ErrorContains(t, err, "SOCKS4")
+}
@@ -0,0 +1,419 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/netip"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+const (
+	// LabelLoadgen is set on all the objects created by the load generator
+	LabelLoadgen = "egressgateway.spidernet.io/loadgen"
+	// labelPolicy selects the Pods of a policy
+	labelPolicy = "egressgateway.spidernet.io/loadgen-policy"
+	// schedulerName is not served by any scheduler, so the Pods are never scheduled and run,
+	// they're only the objects reconciled by the controller
+	schedulerName = "egress-loadgen"
+	pauseImage    = "registry.k8s.io/pause:3.9"
+)
+
+type Options struct {
+	Namespace string
+	Gateway   string
+	Policies  int
+	Pods      int
+	Rounds    int
+	// ChurnPods is the count of the Pods churned in each round, half of them are moved
+	// to another policy by the label, and the others get a new IP
+	ChurnPods    int
+	PodCIDR      string
+	Workers      int
+	Interval     time.Duration
+	PollInterval time.Duration
+	Timeout      time.Duration
+	Cleanup      bool
+}
+
+// Generator creates the policies and the Pods, churns the labels and the IPs of the Pods,
+// and measures the latency until the EgressEndpointSlices of the policies converge
+type Generator struct {
+	Client  client.Client
+	Options Options
+	Out     io.Writer
+
+	rand   *rand.Rand
+	prefix netip.Prefix
+	nextIP netip.Addr
+	// usedIPs is the IPs of the Pods, they're not allocated again until released
+	usedIPs map[string]struct{}
+	// pods is the expected policy and IP of each Pod
+	pods map[string]podState
+}
+
+type podState struct {
+	policy string
+	ip     string
+}
+
+func (s podState) key() string {
+	return s.policy + "/" + s.ip
+}
+
+func New(cli client.Client, opts Options, out io.Writer) (*Generator, error) {
+	if opts.Policies <= 0 || opts.Pods <= 0 {
+		return nil, fmt.Errorf("policies and pods should be greater than 0")
+	}
+	if opts.ChurnPods > opts.Pods {
+		return nil, fmt.Errorf("churn pods %d should not be greater than pods %d", opts.ChurnPods, opts.Pods)
+	}
+	if opts.Workers <= 0 {
+		return nil, fmt.Errorf("workers should be greater than 0")
+	}
+	prefix, err := netip.ParsePrefix(opts.PodCIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pod cidr %q: %w", opts.PodCIDR, err)
+	}
+	prefix = prefix.Masked()
+	// a churned Pod gets a new IP before its IP is released, so one more IP is required
+	if size := prefixSize(prefix); size <= opts.Pods {
+		return nil, fmt.Errorf("pod cidr %s has %d ips, it should have more than the %d pods", prefix, size, opts.Pods)
+	}
+	return &Generator{
+		Client:  cli,
+		Options: opts,
+		Out:     out,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		prefix:  prefix,
+		nextIP:  prefix.Addr(),
+		usedIPs: make(map[string]struct{}),
+		pods:    make(map[string]podState),
+	}, nil
+}
+
+// prefixSize returns the count of the IPs of the prefix allocated to the Pods, the first IP
+// of the prefix is skipped
+func prefixSize(prefix netip.Prefix) int {
+	bits := prefix.Addr().BitLen() - prefix.Bits()
+	if bits >= 31 {
+		return math.MaxInt32
+	}
+	return 1<<bits - 1
+}
+
+// Run creates the objects, churns them in rounds and returns the report of the churns
+func (g *Generator) Run(ctx context.Context) (*Report, error) {
+	if g.Options.Cleanup {
+		defer func() {
+			if err := g.cleanup(context.Background()); err != nil {
+				fmt.Fprintf(g.Out, "failed to clean up: %v\n", err)
+			}
+		}()
+	}
+
+	start := time.Now()
+	if err := g.setup(ctx); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(g.pods))
+	for name := range g.pods {
+		names = append(names, name)
+	}
+	latencies, timedOut, err := g.waitConverged(ctx, names, start)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(g.Out, "setup: %s\n", newReport(latencies))
+	if timedOut != 0 {
+		return nil, fmt.Errorf("%d pods are not converged in %s after the setup", timedOut, g.Options.Timeout)
+	}
+
+	latencies = make([]time.Duration, 0)
+	total := 0
+	for round := 0; round < g.Options.Rounds; round++ {
+		names, start, err := g.churn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		res, timedOut, err := g.waitConverged(ctx, names, start)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(g.Out, "round %d: %s\n", round, newReport(res))
+		latencies = append(latencies, res...)
+		total += timedOut
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(g.Options.Interval):
+		}
+	}
+
+	report := newReport(latencies)
+	report.Policies = g.Options.Policies
+	report.Pods = g.Options.Pods
+	report.Rounds = g.Options.Rounds
+	report.Churns += total
+	report.TimedOut = total
+	return report, nil
+}
+
+func policyName(i int) string {
+	return fmt.Sprintf("loadgen-%d", i)
+}
+
+func podName(i int) string {
+	return fmt.Sprintf("loadgen-%d", i)
+}
+
+func (g *Generator) setup(ctx context.Context) error {
+	for i := 0; i < g.Options.Policies; i++ {
+		policy := &egressv1.EgressPolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      policyName(i),
+				Namespace: g.Options.Namespace,
+				Labels:    map[string]string{LabelLoadgen: "true"},
+			},
+			Spec: egressv1.EgressPolicySpec{
+				EgressGatewayName: g.Options.Gateway,
+				AppliedTo: egressv1.AppliedTo{PodSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{labelPolicy: policyName(i)},
+				}},
+			},
+		}
+		if err := g.Client.Create(ctx, policy); err != nil && !apierr.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create policy %s: %w", policy.Name, err)
+		}
+	}
+
+	for i := 0; i < g.Options.Pods; i++ {
+		g.pods[podName(i)] = podState{policy: policyName(i % g.Options.Policies), ip: g.allocateIP()}
+	}
+	return g.parallel(ctx, g.sortedPods(), g.createPod)
+}
+
+func (g *Generator) createPod(ctx context.Context, name string) error {
+	state := g.pods[name]
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: g.Options.Namespace,
+			Labels:    map[string]string{LabelLoadgen: "true", labelPolicy: state.policy},
+		},
+		Spec: corev1.PodSpec{
+			SchedulerName: schedulerName,
+			Containers:    []corev1.Container{{Name: "pause", Image: pauseImage}},
+		},
+	}
+	err := g.Client.Create(ctx, pod)
+	if apierr.IsAlreadyExists(err) {
+		return g.updatePod(ctx, name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create pod %s: %w", name, err)
+	}
+	return g.updatePodIP(ctx, pod, state.ip)
+}
+
+// updatePod updates the label and the IP of the existing Pod to the expected ones
+func (g *Generator) updatePod(ctx context.Context, name string) error {
+	state := g.pods[name]
+	pod := new(corev1.Pod)
+	if err := g.Client.Get(ctx, types.NamespacedName{Namespace: g.Options.Namespace, Name: name}, pod); err != nil {
+		return err
+	}
+	if pod.Labels[labelPolicy] != state.policy {
+		pod.Labels[labelPolicy] = state.policy
+		if err := g.Client.Update(ctx, pod); err != nil {
+			return fmt.Errorf("failed to update pod %s: %w", name, err)
+		}
+	}
+	if pod.Status.PodIP != state.ip {
+		return g.updatePodIP(ctx, pod, state.ip)
+	}
+	return nil
+}
+
+func (g *Generator) updatePodIP(ctx context.Context, pod *corev1.Pod, ip string) error {
+	pod.Status.PodIP = ip
+	pod.Status.PodIPs = []corev1.PodIP{{IP: ip}}
+	if err := g.Client.Status().Update(ctx, pod); err != nil {
+		return fmt.Errorf("failed to update the ip of pod %s: %w", pod.Name, err)
+	}
+	return nil
+}
+
+// churn moves half of the random Pods to another policy, and changes the IP of the others
+func (g *Generator) churn(ctx context.Context) ([]string, time.Time, error) {
+	names := g.sortedPods()
+	g.rand.Shuffle(len(names), func(i, j int) { names[i], names[j] = names[j], names[i] })
+	names = names[:g.Options.ChurnPods]
+	for i, name := range names {
+		state := g.pods[name]
+		if i%2 == 0 && g.Options.Policies > 1 {
+			next := (g.rand.Intn(g.Options.Policies-1) + 1 + g.policyIndex(state.policy)) % g.Options.Policies
+			state.policy = policyName(next)
+		} else {
+			ip := g.allocateIP()
+			g.releaseIP(state.ip)
+			state.ip = ip
+		}
+		g.pods[name] = state
+	}
+	start := time.Now()
+	return names, start, g.parallel(ctx, names, g.updatePod)
+}
+
+func (g *Generator) policyIndex(name string) int {
+	var res int
+	_, _ = fmt.Sscanf(name, "loadgen-%d", &res)
+	return res
+}
+
+// waitConverged waits until the EgressEndpointSlices have the expected policy and IP of
+// the Pods, and returns the latencies of the converged Pods and the count of the others
+func (g *Generator) waitConverged(ctx context.Context, names []string, start time.Time) ([]time.Duration, int, error) {
+	pending := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		pending[name] = struct{}{}
+	}
+	latencies := make([]time.Duration, 0, len(names))
+	ticker := time.NewTicker(g.Options.PollInterval)
+	defer ticker.Stop()
+	deadline := start.Add(g.Options.Timeout)
+	for {
+		observed, err := g.observe(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		now := time.Now()
+		for name := range pending {
+			if converged(observed[name], g.pods[name]) {
+				latencies = append(latencies, now.Sub(start))
+				delete(pending, name)
+			}
+		}
+		if len(pending) == 0 || now.After(deadline) {
+			return latencies, len(pending), nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// observe returns the policies and the IPs of the Pods in the EgressEndpointSlices
+func (g *Generator) observe(ctx context.Context) (map[string][]string, error) {
+	slices := new(egressv1.EgressEndpointSliceList)
+	if err := g.Client.List(ctx, slices, client.InNamespace(g.Options.Namespace)); err != nil {
+		return nil, err
+	}
+	res := make(map[string][]string)
+	for _, slice := range slices.Items {
+		policy := slice.Labels[egressv1.LabelPolicyName]
+		for _, ep := range slice.Endpoints {
+			for _, ip := range append(ep.IPv4, ep.IPv6...) {
+				res[ep.Pod] = append(res[ep.Pod], podState{policy: policy, ip: ip}.key())
+			}
+		}
+	}
+	return res, nil
+}
+
+// converged returns true if the Pod is only in the slices of the expected policy with the expected IP
+func converged(observed []string, expected podState) bool {
+	return len(observed) == 1 && observed[0] == expected.key()
+}
+
+func (g *Generator) sortedPods() []string {
+	res := make([]string, 0, len(g.pods))
+	for i := 0; i < g.Options.Pods; i++ {
+		res = append(res, podName(i))
+	}
+	return res
+}
+
+// allocateIP returns the next unused IP of the pod CIDR, it wraps around at the end of the
+// CIDR. The CIDR is checked to have more IPs than the Pods, so there's always an unused one.
+func (g *Generator) allocateIP() string {
+	for {
+		g.nextIP = g.nextIP.Next()
+		if !g.prefix.Contains(g.nextIP) {
+			g.nextIP = g.prefix.Addr().Next()
+		}
+		ip := g.nextIP.String()
+		if _, ok := g.usedIPs[ip]; !ok {
+			g.usedIPs[ip] = struct{}{}
+			return ip
+		}
+	}
+}
+
+func (g *Generator) releaseIP(ip string) {
+	delete(g.usedIPs, ip)
+}
+
+// parallel runs the fn of the names by the workers, and returns the first error
+func (g *Generator) parallel(ctx context.Context, names []string, fn func(ctx context.Context, name string) error) error {
+	ch := make(chan string)
+	errs := make(chan error, g.Options.Workers)
+	wg := sync.WaitGroup{}
+	for i := 0; i < g.Options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range ch {
+				if err := fn(ctx, name); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	var err error
+loop:
+	for _, name := range names {
+		select {
+		case ch <- name:
+		case err = <-errs:
+			break loop
+		}
+	}
+	close(ch)
+	wg.Wait()
+	close(errs)
+	if err != nil {
+		return err
+	}
+	return <-errs
+}
+
+func (g *Generator) cleanup(ctx context.Context) error {
+	opts := []client.DeleteAllOfOption{
+		client.InNamespace(g.Options.Namespace),
+		client.MatchingLabels{LabelLoadgen: "true"},
+	}
+	if err := g.Client.DeleteAllOf(ctx, &corev1.Pod{}, opts...); err != nil {
+		return err
+	}
+	return g.Client.DeleteAllOf(ctx, &egressv1.EgressPolicy{}, opts...)
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestPercentile(t *testing.T) {
+	latencies := make([]time.Duration, 0)
+	for i := 100; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	report := newReport(latencies)
+	assert.Equal(t, 100, report.Churns)
+	assert.Equal(t, 50*time.Millisecond, report.P50)
+	assert.Equal(t, 90*time.Millisecond, report.P90)
+	assert.Equal(t, 99*time.Millisecond, report.P99)
+	assert.Equal(t, 100*time.Millisecond, report.Max)
+
+	assert.Equal(t, time.Duration(0), newReport(nil).P99)
+	assert.Equal(t, time.Second, percentile([]time.Duration{time.Second}, 50))
+}
+
+func TestNew(t *testing.T) {
+	opts := Options{Policies: 1, Pods: 3, Workers: 1, PodCIDR: "10.240.0.0/30"}
+	_, err := New(nil, opts, new(bytes.Buffer))
+	assert.Error(t, err)
+	opts.Pods = 2
+	_, err = New(nil, opts, new(bytes.Buffer))
+	assert.NoError(t, err)
+	opts.PodCIDR = "fd00:10:240::/64"
+	opts.Pods = 20000
+	_, err = New(nil, opts, new(bytes.Buffer))
+	assert.NoError(t, err)
+}
+
+func TestGenerator(t *testing.T) {
+	ctx := context.Background()
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithStatusSubresource(&corev1.Pod{}).
+		Build()
+	g, err := New(cli, Options{
+		Namespace:    "default",
+		Gateway:      "default",
+		Policies:     2,
+		Pods:         4,
+		ChurnPods:    2,
+		PodCIDR:      "10.240.0.0/29",
+		Workers:      2,
+		PollInterval: time.Millisecond,
+		Timeout:      10 * time.Millisecond,
+	}, new(bytes.Buffer))
+	assert.NoError(t, err)
+
+	assert.NoError(t, g.setup(ctx))
+	pod := new(corev1.Pod)
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Namespace: "default", Name: "loadgen-3"}, pod))
+	assert.Equal(t, "loadgen-1", pod.Labels[labelPolicy])
+	assert.Equal(t, "10.240.0.4", pod.Status.PodIP)
+	assert.Equal(t, schedulerName, pod.Spec.SchedulerName)
+
+	// the slices of the controller
+	for i := 0; i < 2; i++ {
+		slice := &egressv1.EgressEndpointSlice{ObjectMeta: metav1.ObjectMeta{
+			Name:      policyName(i) + "-abc",
+			Namespace: "default",
+			Labels:    map[string]string{egressv1.LabelPolicyName: policyName(i)},
+		}}
+		for j := i; j < 4; j += 2 {
+			slice.Endpoints = append(slice.Endpoints, egressv1.EgressEndpoint{
+				Pod: podName(j), IPv4: []string{g.pods[podName(j)].ip},
+			})
+		}
+		assert.NoError(t, cli.Create(ctx, slice))
+	}
+	latencies, timedOut, err := g.waitConverged(ctx, g.sortedPods(), time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(latencies))
+	assert.Equal(t, 0, timedOut)
+
+	// the churned pods are not converged until the slices are changed
+	names, start, err := g.churn(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(names))
+	latencies, timedOut, err = g.waitConverged(ctx, names, start)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(latencies))
+	assert.Equal(t, 2, timedOut)
+	for _, name := range names {
+		assert.NoError(t, cli.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, pod))
+		assert.Equal(t, g.pods[name].policy, pod.Labels[labelPolicy])
+		assert.Equal(t, g.pods[name].ip, pod.Status.PodIP)
+	}
+
+	// the ips wrap around in the pod cidr, and are never shared by the pods
+	for i := 0; i < 10; i++ {
+		_, _, err = g.churn(ctx)
+		assert.NoError(t, err)
+		ips := make(map[string]struct{})
+		for _, state := range g.pods {
+			ips[state.ip] = struct{}{}
+		}
+		assert.Equal(t, 4, len(ips))
+		assert.Equal(t, 4, len(g.usedIPs))
+	}
+
+	g.Options.Cleanup = true
+	assert.NoError(t, g.cleanup(ctx))
+	pods := new(corev1.PodList)
+	assert.NoError(t, cli.List(ctx, pods))
+	assert.Empty(t, pods.Items)
+}
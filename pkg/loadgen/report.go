@@ -0,0 +1,58 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package loadgen
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Report is the convergence latency of the churns, from the update of the Pods until the
+// EgressEndpointSlices of the policies reflect them
+type Report struct {
+	Policies int
+	Pods     int
+	Rounds   int
+	// Churns is the count of the churned Pods, and TimedOut is the count of the churns
+	// which did not converge in the timeout
+	Churns   int
+	TimedOut int
+	P50      time.Duration
+	P90      time.Duration
+	P99      time.Duration
+	Max      time.Duration
+}
+
+func newReport(latencies []time.Duration) *Report {
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	res := &Report{
+		Churns: len(sorted),
+		P50:    percentile(sorted, 50),
+		P90:    percentile(sorted, 90),
+		P99:    percentile(sorted, 99),
+	}
+	if len(sorted) != 0 {
+		res.Max = sorted[len(sorted)-1]
+	}
+	return res
+}
+
+// percentile returns the nearest-rank percentile of the sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("policies=%d pods=%d rounds=%d churns=%d timedOut=%d p50=%s p90=%s p99=%s max=%s",
+		r.Policies, r.Pods, r.Rounds, r.Churns, r.TimedOut, r.P50, r.P90, r.P99, r.Max)
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package logger
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Deduper logs the recurring errors of a loop at most once per interval. An error
+// is identified by the message and the error string, the first occurrence is logged
+// immediately, and the repeated occurrences in the interval are summarized with the
+// count and the time of the last occurrence when the error is logged next time.
+type Deduper struct {
+	log      logr.Logger
+	interval time.Duration
+	now      func() time.Time
+
+	lock    sync.Mutex
+	entries map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	msg        string
+	logged     time.Time
+	last       time.Time
+	suppressed int
+}
+
+// NewDeduper creates a Deduper which logs the same error at most once per interval
+func NewDeduper(log logr.Logger, interval time.Duration) *Deduper {
+	return &Deduper{
+		log:      log,
+		interval: interval,
+		now:      time.Now,
+		entries:  make(map[string]*dedupEntry),
+	}
+}
+
+// Error logs the error if it is not logged in the interval, otherwise counts it
+func (d *Deduper) Error(err error, msg string, keysAndValues ...interface{}) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := d.now()
+	key := msg
+	if err != nil {
+		key += ": " + err.Error()
+	}
+	entry, ok := d.entries[key]
+	if ok && now.Sub(entry.logged) < d.interval {
+		entry.suppressed++
+		entry.last = now
+		return
+	}
+	if ok && entry.suppressed > 0 {
+		keysAndValues = append(keysAndValues, "suppressed", entry.suppressed, "lastOccurrence", entry.last)
+	}
+	d.log.WithCallDepth(1).Error(err, msg, keysAndValues...)
+	d.entries[key] = &dedupEntry{msg: msg, logged: now, last: now}
+
+	// drop the errors which do not occur anymore
+	for k, e := range d.entries {
+		if now.Sub(e.last) > d.interval*2 {
+			delete(d.entries, k)
+		}
+	}
+}
+
+// Resolved summarizes the suppressed occurrences of the errors with the message, and
+// forgets them, it should be called when the operation succeeds.
+func (d *Deduper) Resolved(msg string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for key, entry := range d.entries {
+		if entry.msg != msg {
+			continue
+		}
+		if entry.suppressed > 0 {
+			d.log.WithCallDepth(1).Info("error resolved", "error", key,
+				"suppressed", entry.suppressed, "lastOccurrence", entry.last)
+		}
+		delete(d.entries, key)
+	}
+}
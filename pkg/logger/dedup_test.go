@@ -0,0 +1,56 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package logger
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeduper(t *testing.T) {
+	lines := make([]string, 0)
+	log := funcr.New(func(prefix, args string) {
+		lines = append(lines, args)
+	}, funcr.Options{})
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := NewDeduper(log, time.Minute)
+	d.now = func() time.Time { return now }
+
+	err := errors.New("link not found")
+	d.Error(err, "ensure vxlan link")
+	assert.Len(t, lines, 1)
+
+	// the repeated errors in the interval are suppressed
+	for i := 0; i < 3; i++ {
+		now = now.Add(time.Second * 10)
+		d.Error(err, "ensure vxlan link")
+	}
+	assert.Len(t, lines, 1)
+
+	// another error is logged immediately
+	d.Error(errors.New("permission denied"), "ensure vxlan link")
+	assert.Len(t, lines, 2)
+
+	// the summary is logged after the interval
+	now = now.Add(time.Minute)
+	d.Error(err, "ensure vxlan link")
+	assert.Len(t, lines, 3)
+	assert.Contains(t, lines[2], `"suppressed"=3`)
+
+	// the suppressed errors are summarized when resolved
+	now = now.Add(time.Second)
+	d.Error(err, "ensure vxlan link")
+	d.Resolved("ensure vxlan link")
+	assert.Len(t, lines, 4)
+	assert.Contains(t, lines[3], `"msg"="error resolved"`)
+	assert.Empty(t, d.entries)
+
+	d.Error(err, "ensure vxlan link")
+	assert.Len(t, lines, 5)
+}
@@ -0,0 +1,238 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-logr/logr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// modules of the log, the level of each module can be set separately
+const (
+	ModuleEndpoint      = "endpoint"
+	ModuleGateway       = "gateway"
+	ModulePolicy        = "policy"
+	ModuleTunnel        = "tunnel"
+	ModuleAgentVXLAN    = "agent.vxlan"
+	ModuleAgentIPTables = "agent.iptables"
+	ModuleLayer2        = "layer2"
+	ModuleMultiCluster  = "multicluster"
+	ModuleBFD           = "bfd"
+)
+
+// Modules is the declared modules, only their levels can be set
+var Modules = []string{
+	ModuleEndpoint,
+	ModuleGateway,
+	ModulePolicy,
+	ModuleTunnel,
+	ModuleAgentVXLAN,
+	ModuleAgentIPTables,
+	ModuleLayer2,
+	ModuleMultiCluster,
+	ModuleBFD,
+}
+
+// moduleLevel is the log level of a module, the default level is used if it is not set
+type moduleLevel struct {
+	set   atomic.Bool
+	level zap.AtomicLevel
+}
+
+func (l *moduleLevel) enabled(lvl zapcore.Level) bool {
+	if l == nil || !l.set.Load() {
+		return defaultLevel.Enabled(lvl)
+	}
+	return l.level.Enabled(lvl)
+}
+
+var (
+	defaultLevel = zap.NewAtomicLevel()
+	modulesLock  sync.Mutex
+	modules      = newModules()
+)
+
+func newModules() map[string]*moduleLevel {
+	res := make(map[string]*moduleLevel, len(Modules))
+	for _, name := range Modules {
+		res[name] = &moduleLevel{level: zap.NewAtomicLevel()}
+	}
+	return res
+}
+
+// getModule returns the level of the declared module, it's nil for the unknown modules,
+// which use the default level
+func getModule(name string) *moduleLevel {
+	modulesLock.Lock()
+	defer modulesLock.Unlock()
+	return modules[name]
+}
+
+// IsModule returns true if the name is a declared module
+func IsModule(name string) bool {
+	return getModule(name) != nil
+}
+
+// ParseLevel parses the zap level name or the verbosity number of logr, the verbosity
+// n is converted to the zap level -n.
+func ParseLevel(text string) (zapcore.Level, error) {
+	level, err := strconv.ParseInt(text, 10, 8)
+	if err != nil {
+		atomicLevel, err := zap.ParseAtomicLevel(text)
+		if err != nil {
+			return 0, err
+		}
+		return atomicLevel.Level(), nil
+	}
+	// compatible with zap, the minimum is the maximum log level
+	if level > 0 {
+		level = 0 - level
+	}
+	return zapcore.Level(level), nil
+}
+
+// SetDefaultLevel sets the log level of the modules whose level is not set
+func SetDefaultLevel(level zapcore.Level) {
+	defaultLevel.SetLevel(level)
+}
+
+// SetModuleLevel sets the log level of the module, the unknown modules are ignored
+func SetModuleLevel(module string, level zapcore.Level) {
+	l := getModule(module)
+	if l == nil {
+		return
+	}
+	l.level.SetLevel(level)
+	l.set.Store(true)
+}
+
+// ResetModuleLevel makes the module use the default log level, the unknown modules are ignored
+func ResetModuleLevel(module string) {
+	if l := getModule(module); l != nil {
+		l.set.Store(false)
+	}
+}
+
+// Levels returns the default log level and the levels of the modules which are set
+func Levels() (zapcore.Level, map[string]zapcore.Level) {
+	modulesLock.Lock()
+	defer modulesLock.Unlock()
+	res := make(map[string]zapcore.Level)
+	for name, l := range modules {
+		if l.set.Load() {
+			res[name] = l.level.Level()
+		}
+	}
+	return defaultLevel.Level(), res
+}
+
+// ForModule returns the logger of the module, which is filtered by the level of the module
+func ForModule(log logr.Logger, module string) logr.Logger {
+	sink, ok := log.GetSink().(*levelSink)
+	if !ok {
+		return log.WithName(module)
+	}
+	return log.WithSink(&levelSink{sink: sink.sink.WithName(module), level: getModule(module)})
+}
+
+// levelSink filters the logs of the sink by the level of the module
+type levelSink struct {
+	sink  logr.LogSink
+	level *moduleLevel
+}
+
+func (s *levelSink) Init(info logr.RuntimeInfo) {
+	s.sink.Init(info)
+}
+
+func (s *levelSink) Enabled(level int) bool {
+	return s.level.enabled(zapcore.Level(-level)) && s.sink.Enabled(level)
+}
+
+func (s *levelSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.sink.Info(level, msg, keysAndValues...)
+}
+
+func (s *levelSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.sink.Error(err, msg, keysAndValues...)
+}
+
+func (s *levelSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return &levelSink{sink: s.sink.WithValues(keysAndValues...), level: s.level}
+}
+
+func (s *levelSink) WithName(name string) logr.LogSink {
+	return &levelSink{sink: s.sink.WithName(name), level: s.level}
+}
+
+func (s *levelSink) WithCallDepth(depth int) logr.LogSink {
+	if sink, ok := s.sink.(logr.CallDepthLogSink); ok {
+		return &levelSink{sink: sink.WithCallDepth(depth), level: s.level}
+	}
+	return s
+}
+
+type levelResponse struct {
+	Default string            `json:"default"`
+	Modules map[string]string `json:"modules"`
+}
+
+// LevelHandler returns the HTTP handler to get and set the log levels at runtime:
+//   - GET returns the default level and the levels of modules
+//   - PUT with the query `module` and `level` sets the level of the module, the
+//     default level is set if the module is empty, and the level of the module is
+//     reset to the default level if the level is empty. The unknown modules are rejected.
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			module := req.URL.Query().Get("module")
+			if module != "" && !IsModule(module) {
+				http.Error(w, fmt.Sprintf("unknown module %q, the modules are %s", module, strings.Join(Modules, ", ")),
+					http.StatusBadRequest)
+				return
+			}
+			text := req.URL.Query().Get("level")
+			if text == "" {
+				if module == "" {
+					http.Error(w, "level is required", http.StatusBadRequest)
+					return
+				}
+				ResetModuleLevel(module)
+				break
+			}
+			level, err := ParseLevel(text)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid level %q: %v", text, err), http.StatusBadRequest)
+				return
+			}
+			if module == "" {
+				SetDefaultLevel(level)
+			} else {
+				SetModuleLevel(module, level)
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		def, list := Levels()
+		res := levelResponse{Default: def.String(), Modules: make(map[string]string)}
+		for name, level := range list {
+			res.Modules[name] = level.String()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	})
+}
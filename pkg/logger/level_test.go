@@ -0,0 +1,85 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package logger_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+)
+
+func TestParseLevel(t *testing.T) {
+	cases := []struct {
+		text    string
+		level   zapcore.Level
+		wantErr bool
+	}{
+		{text: "info", level: zapcore.InfoLevel},
+		{text: "debug", level: zapcore.DebugLevel},
+		{text: "3", level: zapcore.Level(-3)},
+		{text: "-2", level: zapcore.Level(-2)},
+		{text: "verbose", wantErr: true},
+	}
+	for _, c := range cases {
+		level, err := logger.ParseLevel(c.text)
+		if c.wantErr {
+			assert.Error(t, err, c.text)
+			continue
+		}
+		assert.NoError(t, err, c.text)
+		assert.Equal(t, c.level, level, c.text)
+	}
+}
+
+func TestModuleLevel(t *testing.T) {
+	log := logger.NewLogger(logger.Config{
+		Level:        zapcore.InfoLevel,
+		ModuleLevels: map[string]zapcore.Level{logger.ModuleAgentVXLAN: zapcore.DebugLevel},
+	})
+	vxlan := logger.ForModule(log, logger.ModuleAgentVXLAN)
+	gateway := logger.ForModule(log, logger.ModuleGateway)
+
+	assert.False(t, log.V(1).Enabled())
+	assert.True(t, vxlan.V(1).Enabled())
+	assert.True(t, vxlan.WithValues("key", "value").V(1).Enabled())
+	assert.False(t, gateway.V(1).Enabled())
+
+	// change the levels at runtime
+	req := httptest.NewRequest(http.MethodPut, "/loglevel?module=gateway&level=2", nil)
+	rec := httptest.NewRecorder()
+	logger.LevelHandler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, gateway.V(2).Enabled())
+	assert.False(t, gateway.V(3).Enabled())
+
+	req = httptest.NewRequest(http.MethodPut, "/loglevel?module=agent.vxlan", nil)
+	rec = httptest.NewRecorder()
+	logger.LevelHandler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, vxlan.V(1).Enabled())
+
+	// the unknown modules are rejected
+	req = httptest.NewRequest(http.MethodPut, "/loglevel?module=agent-vxaln&level=debug", nil)
+	rec = httptest.NewRecorder()
+	logger.LevelHandler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "unknown module")
+	assert.False(t, logger.IsModule("agent-vxaln"))
+
+	req = httptest.NewRequest(http.MethodPut, "/loglevel?level=unknown", nil)
+	rec = httptest.NewRecorder()
+	logger.LevelHandler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/loglevel", nil)
+	rec = httptest.NewRecorder()
+	logger.LevelHandler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"default":"info","modules":{"gateway":"Level(-2)"}}`, rec.Body.String())
+}
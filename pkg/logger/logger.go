@@ -16,13 +16,22 @@ type Config struct {
 	Level      zapcore.Level
 	WithCaller bool
 	Encoder    string
+	// ModuleLevels is the log levels of modules, the modules which are not set use Level
+	ModuleLevels map[string]zapcore.Level
 }
 
+// NewLogger creates the root logger, the logs are filtered by the levels which can be
+// changed at runtime, see ForModule and LevelHandler.
 func NewLogger(cfg Config) logr.Logger {
+	SetDefaultLevel(cfg.Level)
+	for module, level := range cfg.ModuleLevels {
+		SetModuleLevel(module, level)
+	}
+
 	var opts []czap.Opts
 	opts = append(opts,
 		czap.UseDevMode(cfg.UseDevMode),
-		czap.Level(cfg.Level),
+		czap.Level(zap.LevelEnablerFunc(func(zapcore.Level) bool { return true })),
 		czap.RawZapOpts(zap.WithCaller(cfg.WithCaller)),
 	)
 	if cfg.Encoder == "console" {
@@ -34,7 +43,7 @@ func NewLogger(cfg Config) logr.Logger {
 				config.EncodeDuration = zapcore.StringDurationEncoder
 			}))
 	}
-	logger := czap.New(opts...)
+	logger := logr.New(&levelSink{sink: czap.New(opts...).GetSink()})
 	log.SetLogger(logger)
 	return logger
 }
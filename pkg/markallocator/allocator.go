@@ -6,7 +6,6 @@ package markallocator
 import (
 	"errors"
 	"fmt"
-	"math/big"
 	"strconv"
 	"strings"
 
@@ -24,72 +23,41 @@ type Interface interface {
 	Release(mark string) error
 	ForEach(func(mark string))
 
+	// Free returns the count of the marks left in the range
+	Free() int
+	// Size returns the count of the usable marks in the range
+	Size() int
+
 	// Has function for testing
 	Has(mark string) bool
 }
 
 type Range struct {
-	base *big.Int
-
-	start, end uint64
-
-	// max is the maximum size of the usable addresses in the range
-	max int
+	space Space
 
 	alloc allocator.Interface
 }
 
-func NewAllocatorMarkRange(mask string) (Interface, error) {
-	start, end, err := RangeSize(mask)
-	if err != nil {
-		return nil, err
-	}
-	base, err := bigForMark(mask)
-	if err != nil {
-		return nil, err
-	}
-	r := &Range{
-		max:   int(end - start - 1),
-		base:  base.Add(base, big.NewInt(1)),
-		start: start,
-		end:   end,
-	}
-	r.alloc = allocator.NewAllocationMap(r.max, "")
-	return r, err
-}
-
-func bigForMark(mark string) (*big.Int, error) {
-	val, err := Parse(mark)
+// NewAllocatorMarkRange returns the allocator of the marks after the base, the mask of
+// the marks is the trailing zero hex digits of the base
+func NewAllocatorMarkRange(base string) (Interface, error) {
+	space, err := NewSpace(base, "")
 	if err != nil {
 		return nil, err
 	}
-	return big.NewInt(0).SetUint64(val), nil
+	return NewAllocatorMarkSpace(space), nil
 }
 
-func RangeSize(mask string) (uint64, uint64, error) {
-	start, err := Parse(mask)
-	if err != nil {
-		return 0, 0, err
-	}
-	endStr := ""
-	for i := len(mask) - 1; i >= 0; i-- {
-		item := mask[i]
-		if item == 48 {
-			endStr = "f" + endStr
-		} else {
-			endStr = mask[:i+1] + endStr
-			break
-		}
-	}
-	end, err := Parse(endStr)
-	if err != nil {
-		return 0, 0, err
-	}
-	return start, end, nil
+// NewAllocatorMarkSpace returns the allocator of the marks in the space
+func NewAllocatorMarkSpace(space Space) Interface {
+	r := &Range{space: space}
+	r.alloc = allocator.NewAllocationMap(space.Size(), "")
+	return r
 }
 
+// Parse parses the mark in hex, with or without the 0x prefix
 func Parse(mark string) (uint64, error) {
-	tmp := strings.ReplaceAll(mark, "0x", "")
+	tmp := strings.TrimPrefix(mark, "0x")
 	return strconv.ParseUint(tmp, 16, 64)
 }
 
@@ -98,16 +66,11 @@ func Parse(mark string) (uint64, error) {
 // or has already been reserved. ErrFull will be returned if there
 // are no addresses left.
 func (r *Range) Allocate(mark string) error {
-	m, err := Parse(mark)
+	offset, err := r.offset(mark)
 	if err != nil {
 		return err
 	}
 
-	ok, offset := r.contains(m)
-	if !ok {
-		return fmt.Errorf("%s not in range [%x, %x]", mark, r.start, r.end)
-	}
-
 	allocated, err := r.alloc.Allocate(offset)
 	if err != nil {
 		return err
@@ -128,78 +91,60 @@ func (r *Range) AllocateNext() (string, error) {
 	if !ok {
 		return "", ErrFull
 	}
-	return addMarkOffset(r.base, offset), nil
+	return formatMark(r.space.Mark(offset)), nil
 }
 
 // Release releases the mark back to the pool. Releasing an
-// unallocated mark or a mark out of the range is a no-op and
-// returns no error.
+// unallocated mark is a no-op and returns no error.
 func (r *Range) Release(mark string) error {
-	m, err := Parse(mark)
+	offset, err := r.offset(mark)
 	if err != nil {
 		return err
 	}
-
-	ok, offset := r.contains(m)
-	if !ok {
-		return fmt.Errorf("%s not in range [%x, %x]", mark, r.start, r.end)
-	}
-
 	return r.alloc.Release(offset)
 }
 
 // ForEach calls the provided function for each allocated mark.
 func (r *Range) ForEach(fn func(mark string)) {
 	r.alloc.ForEach(func(offset int) {
-		mark, _ := GetIndexedMark(r.base, offset)
-		fn(mark)
+		fn(formatMark(r.space.Mark(offset)))
 	})
 }
 
-// GetIndexedMark returns a string that is r.base + index in the contiguous mark space.
-func GetIndexedMark(base *big.Int, index int) (string, error) {
-	mark := addMarkOffset(base, index)
-	return mark, nil
+// Free returns the count of the marks left in the range.
+func (r *Range) Free() int {
+	return r.alloc.Free()
+}
+
+// Size returns the count of the usable marks in the range.
+func (r *Range) Size() int {
+	return r.space.Size()
 }
 
 // Has returns true if the provided mark is already allocated and a call
 // to Allocate(mark) would fail with ErrAllocated.
 func (r *Range) Has(mark string) bool {
-	m, err := Parse(mark)
+	offset, err := r.offset(mark)
 	if err != nil {
 		return false
 	}
-
-	ok, offset := r.contains(m)
-	if !ok {
-		return false
-	}
-
 	return r.alloc.Has(offset)
 }
 
-// addMarkOffset adds the provided integer offset to a base big.Int representing a mark
-func addMarkOffset(base *big.Int, offset int) string {
-	r := big.NewInt(0).Add(base, big.NewInt(int64(offset))).Uint64()
-	return "0x" + strconv.FormatUint(r, 16)
-}
-
-// contains returns true and the offset if the mrk is in the range, and false
-// and nil otherwise. The first and last addresses of the mask are omitted.
-func (r *Range) contains(mark uint64) (bool, int) {
-	if r.start > mark || r.end < mark {
-		return false, 0
+// offset returns the offset of the mark in the range, the first and last marks of
+// the mask are omitted.
+func (r *Range) offset(mark string) (int, error) {
+	m, err := Parse(mark)
+	if err != nil {
+		return 0, err
 	}
-
-	offset := calculateMarkOffset(r.base, mark)
-	if offset < 0 || offset >= r.max {
-		return false, 0
+	offset, ok := r.space.Index(m)
+	if !ok {
+		return 0, fmt.Errorf("%s not in range of mark %#x mask %#x", mark, r.space.Base, r.space.Mask)
 	}
-	return true, offset
+	return offset, nil
 }
 
-// calculateMarkOffset calculates the integer offset of mark from base such that
-// base + offset = mark. It requires mark >= base.
-func calculateMarkOffset(base *big.Int, mark uint64) int {
-	return int(big.NewInt(0).Sub(big.NewInt(0).SetUint64(mark), base).Int64())
+func formatMark(mark uint32) string {
+	return "0x" + strconv.FormatUint(uint64(mark), 16)
 }
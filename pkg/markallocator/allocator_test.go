@@ -4,8 +4,12 @@
 package markallocator_test
 
 import (
-	"github.com/spidernet-io/egressgateway/pkg/markallocator"
+	"fmt"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
 )
 
 func TestAllocatorMarkRange(t *testing.T) {
@@ -21,3 +25,98 @@ func TestAllocatorMarkRange(t *testing.T) {
 	_ = Allocator.Allocate("0x23000000")
 	_ = Allocator.Release("0x23000000")
 }
+
+func TestNewSpace(t *testing.T) {
+	space, err := markallocator.NewSpace("0x26000000", "")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0x00ffffff), space.Mask)
+	assert.Equal(t, uint32(0xff000000), space.GroupMask())
+	assert.Equal(t, uint32(0xffffffff), space.MarkMask())
+	assert.Equal(t, 0xfffffe, space.Size())
+
+	space, err = markallocator.NewSpace("0x26000000", "0x0000ff00")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(8), space.Shift)
+	assert.Equal(t, uint32(0x26000100), space.Mark(0))
+	assert.Equal(t, uint32(0xffff0000), space.GroupMask())
+	assert.Equal(t, uint32(0xffffff00), space.MarkMask())
+	index, ok := space.Index(0x26000300)
+	assert.True(t, ok)
+	assert.Equal(t, 2, index)
+	_, ok = space.Index(0x26000301)
+	assert.False(t, ok)
+	assert.True(t, space.InRange(0x260003ff))
+	assert.False(t, space.InRange(0x27000000))
+
+	for _, mask := range []string{"0", "0x0000f0f0", "0x06000000", "0x00000001", "0x100000000"} {
+		_, err = markallocator.NewSpace("0x26000000", mask)
+		assert.Error(t, err, mask)
+	}
+	_, err = markallocator.NewSpace("0x0", "")
+	assert.Error(t, err)
+}
+
+func TestAllocatorMarkSpace(t *testing.T) {
+	space, err := markallocator.NewSpace("0x26000000", "0x00000300")
+	assert.NoError(t, err)
+	allocator := markallocator.NewAllocatorMarkSpace(space)
+	assert.Equal(t, 2, allocator.Size())
+
+	assert.NoError(t, allocator.Allocate("0x26000200"))
+	mark, err := allocator.AllocateNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "0x26000100", mark)
+	assert.True(t, allocator.Has(mark))
+	assert.Equal(t, 0, allocator.Free())
+	_, err = allocator.AllocateNext()
+	assert.ErrorIs(t, err, markallocator.ErrFull)
+	assert.Error(t, allocator.Allocate("0x26000300"))
+
+	assert.NoError(t, allocator.Release(mark))
+	assert.Equal(t, 1, allocator.Free())
+}
+
+func FuzzParse(f *testing.F) {
+	for _, item := range []string{"0x26000000", "26000000", "0xffffffffffffffff", "0x0x1", "10x2", ""} {
+		f.Add(item)
+	}
+	f.Fuzz(func(t *testing.T, mark string) {
+		res, err := markallocator.Parse(mark)
+		if err != nil {
+			return
+		}
+		again, err := markallocator.Parse(fmt.Sprintf("%#x", res))
+		if err != nil || again != res {
+			t.Fatalf("mark %q is parsed to %#x, which is parsed to %#x: %v", mark, res, again, err)
+		}
+	})
+}
+
+func FuzzNewSpace(f *testing.F) {
+	f.Add("0x26000000", "")
+	f.Add("0x26000000", "0x00ff0000")
+	f.Add("0x80000000", "0x0000ff00")
+	f.Add("0x1", "0x2")
+	f.Fuzz(func(t *testing.T, base, mask string) {
+		space, err := markallocator.NewSpace(base, mask)
+		if err != nil {
+			return
+		}
+		if space.Size() <= 0 {
+			t.Fatalf("space %#x/%#x has no marks", space.Base, space.Mask)
+		}
+		for _, i := range []int{0, space.Size() - 1} {
+			mark := space.Mark(i)
+			index, ok := space.Index(uint64(mark))
+			if !ok || index != i {
+				t.Fatalf("mark %#x of index %d is indexed to %d, %v", mark, i, index, ok)
+			}
+			if !space.InRange(int(mark)) {
+				t.Fatalf("mark %#x is out of the range of the space %#x/%#x", mark, space.Base, space.Mask)
+			}
+			if mark&space.MarkMask() != mark {
+				t.Fatalf("mark %#x is out of the mark mask %#x", mark, space.MarkMask())
+			}
+		}
+	})
+}
@@ -0,0 +1,118 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package markallocator
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Space is the marks of the gateway nodes: the Base is set in every mark, and the marks are
+// numbered in the bits of the Mask. The bits under the Mask are left to the other tools,
+// e.g. the other CNIs, so they're not matched or changed by the agent.
+type Space struct {
+	Base uint32
+	Mask uint32
+	// Shift is the trailing zero bits of the Mask
+	Shift uint
+}
+
+// NewSpace returns the mark space of the base and the mask, the mask is the trailing zero
+// hex digits of the base if it's empty. The mask must be contiguous, and must not overlap
+// the base.
+func NewSpace(base, mask string) (Space, error) {
+	b, err := parseUint32(base)
+	if err != nil {
+		return Space{}, fmt.Errorf("invalid mark %q: %w", base, err)
+	}
+	if b == 0 {
+		return Space{}, fmt.Errorf("mark can not be 0")
+	}
+	m := uint32(1)<<(bits.TrailingZeros32(b)/4*4) - 1
+	if mask != "" {
+		m, err = parseUint32(mask)
+		if err != nil {
+			return Space{}, fmt.Errorf("invalid mark mask %q: %w", mask, err)
+		}
+	}
+	if m == 0 {
+		return Space{}, fmt.Errorf("mark mask can not be 0")
+	}
+
+	s := Space{Base: b, Mask: m, Shift: uint(bits.TrailingZeros32(m))}
+	if width := m >> s.Shift; width&(width+1) != 0 {
+		return Space{}, fmt.Errorf("mark mask %#x is not contiguous", m)
+	}
+	if b&^s.GroupMask() != 0 {
+		return Space{}, fmt.Errorf("mark %#x overlaps the mask %#x or the bits under it", b, m)
+	}
+	if s.Size() <= 0 {
+		return Space{}, fmt.Errorf("mark mask %#x is too narrow", m)
+	}
+	return s, nil
+}
+
+func parseUint32(val string) (uint32, error) {
+	res, err := Parse(val)
+	if err != nil {
+		return 0, err
+	}
+	if res > math.MaxUint32 {
+		return 0, fmt.Errorf("out of 32 bits")
+	}
+	return uint32(res), nil
+}
+
+// Size returns the count of the usable marks, the first and last marks of the mask are omitted
+func (s Space) Size() int {
+	return int(s.Mask>>s.Shift) - 1
+}
+
+// Mark returns the mark of the index
+func (s Space) Mark(index int) uint32 {
+	return s.Base | uint32(index+1)<<s.Shift
+}
+
+// Index returns the index of the mark, and false if it's not a usable mark of the space
+func (s Space) Index(mark uint64) (int, bool) {
+	if mark > math.MaxUint32 || uint32(mark)&^s.Mask != s.Base {
+		return 0, false
+	}
+	index := int(uint32(mark)&s.Mask>>s.Shift) - 1
+	if index < 0 || index >= s.Size() {
+		return 0, false
+	}
+	return index, true
+}
+
+// InRange returns true if the value is in the range of the space, including the bits
+// under the mask, the tables and rules in the range are owned by the agent
+func (s Space) InRange(val int) bool {
+	return val >= 0 && uint64(val) <= math.MaxUint32 && uint32(val)&s.GroupMask() == s.Base
+}
+
+// Start returns the first value of the range of the space
+func (s Space) Start() int {
+	return int(s.Base)
+}
+
+// Span returns the count of the values in the range of the space
+func (s Space) Span() int {
+	return int(s.Mask|s.lowMask()) + 1
+}
+
+// MarkMask returns the bits of the marks matched and set by the agent
+func (s Space) MarkMask() uint32 {
+	return ^s.lowMask()
+}
+
+// GroupMask returns the bits of the base, which are shared by all the marks
+func (s Space) GroupMask() uint32 {
+	return ^(s.Mask | s.lowMask())
+}
+
+func (s Space) lowMask() uint32 {
+	return uint32(1)<<s.Shift - 1
+}
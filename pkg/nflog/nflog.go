@@ -0,0 +1,298 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package nflog receives the packets sent to an NFLOG group by the iptables rules over the
+// nfnetlink_log protocol, the same protocol ulogd listens on.
+package nflog
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// the nfnetlink_log subsystem and its messages
+	subsysULOG    = 4
+	msgTypePacket = subsysULOG<<8 | 0
+	msgTypeConfig = subsysULOG<<8 | 1
+
+	// the attributes of the config messages
+	attrCfgCmd  = 1
+	attrCfgMode = 2
+
+	// the commands of the config messages
+	cfgCmdBind   = 1
+	cfgCmdPFBind = 3
+
+	copyModePacket = 2
+
+	// the attributes of the packet messages
+	attrTimestamp = 3
+	attrPayload   = 9
+	attrPrefix    = 10
+
+	// nlaTypeMask strips the nested and the byte order flags of the attribute types
+	nlaTypeMask = 0x3fff
+
+	// readTimeout is how often the context is checked while no packet arrives
+	readTimeout = time.Second
+)
+
+// Packet is a packet received from the NFLOG group
+type Packet struct {
+	// Prefix is the --nflog-prefix of the rule sending the packet
+	Prefix string
+	// Time is the time the packet is logged, it's the time received if the kernel doesn't
+	// carry it
+	Time time.Time
+	// Payload is the packet from the IP header, truncated to the snap length
+	Payload []byte
+}
+
+// Listen binds the NFLOG group and calls handle with the packets sent to it, each packet is
+// truncated to snapLen bytes. It returns when ctx is done, the group is released as the
+// socket is closed.
+func Listen(ctx context.Context, group uint16, snapLen int, handle func(Packet)) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW, unix.NETLINK_NETFILTER)
+	if err != nil {
+		return fmt.Errorf("failed to open nfnetlink socket: %w", err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fmt.Errorf("failed to bind nfnetlink socket: %w", err)
+	}
+	tv := unix.NsecToTimeval(readTimeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		return fmt.Errorf("failed to set read timeout of nfnetlink socket: %w", err)
+	}
+
+	// the families are bound for the kernels before 3.17, it's a no-op on the later ones
+	for seq, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		_ = request(fd, buildConfigMessage(uint32(seq+1), family, 0, cmdAttr(cfgCmdPFBind)))
+	}
+	if err := request(fd, buildConfigMessage(3, unix.AF_UNSPEC, group, cmdAttr(cfgCmdBind))); err != nil {
+		return fmt.Errorf("failed to bind nflog group %d: %w", group, err)
+	}
+	if err := request(fd, buildConfigMessage(4, unix.AF_UNSPEC, group, modeAttr(uint32(snapLen)))); err != nil {
+		return fmt.Errorf("failed to set copy mode of nflog group %d: %w", group, err)
+	}
+
+	buf := make([]byte, 1<<16)
+	for ctx.Err() == nil {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+				continue
+			}
+			// the packets are dropped by the kernel as the socket buffer is full, the
+			// following ones are received
+			if errors.Is(err, unix.ENOBUFS) {
+				continue
+			}
+			return fmt.Errorf("failed to read nfnetlink socket: %w", err)
+		}
+		packets, err := parsePackets(buf[:n], time.Now())
+		if err != nil {
+			return err
+		}
+		for _, packet := range packets {
+			handle(packet)
+		}
+	}
+	return nil
+}
+
+// request sends the config message and waits for its ack
+func request(fd int, msg []byte) error {
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+	buf := make([]byte, unix.Getpagesize())
+	n, _, err := unix.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+	if err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		if m.Header.Type != unix.NLMSG_ERROR || len(m.Data) < 4 {
+			continue
+		}
+		if errno := int32(binary.NativeEndian.Uint32(m.Data[:4])); errno != 0 {
+			return unix.Errno(-errno)
+		}
+	}
+	return nil
+}
+
+// buildConfigMessage builds the config message of the group, with the nfgenmsg header and
+// the attributes
+func buildConfigMessage(seq uint32, family uint8, group uint16, attrs ...[]byte) []byte {
+	// the nfgenmsg header, the resource ID is the group in the network byte order
+	body := []byte{family, 0, 0, 0}
+	binary.BigEndian.PutUint16(body[2:], group)
+	for _, attr := range attrs {
+		body = append(body, attr...)
+	}
+	msg := make([]byte, unix.NLMSG_HDRLEN, unix.NLMSG_HDRLEN+len(body))
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(unix.NLMSG_HDRLEN+len(body)))
+	binary.NativeEndian.PutUint16(msg[4:6], msgTypeConfig)
+	binary.NativeEndian.PutUint16(msg[6:8], unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	binary.NativeEndian.PutUint32(msg[8:12], seq)
+	return append(msg, body...)
+}
+
+func cmdAttr(cmd uint8) []byte {
+	return buildAttr(attrCfgCmd, []byte{cmd})
+}
+
+// modeAttr copies the packets to the socket, up to the copy range
+func modeAttr(copyRange uint32) []byte {
+	data := make([]byte, 6)
+	binary.BigEndian.PutUint32(data, copyRange)
+	data[4] = copyModePacket
+	return buildAttr(attrCfgMode, data)
+}
+
+// buildAttr builds the netlink attribute padded to 4 bytes
+func buildAttr(typ uint16, data []byte) []byte {
+	length := unix.SizeofNlAttr + len(data)
+	attr := make([]byte, nlaAlign(length))
+	binary.NativeEndian.PutUint16(attr[0:2], uint16(length))
+	binary.NativeEndian.PutUint16(attr[2:4], typ)
+	copy(attr[unix.SizeofNlAttr:], data)
+	return attr
+}
+
+func nlaAlign(length int) int {
+	return (length + unix.NLA_ALIGNTO - 1) &^ (unix.NLA_ALIGNTO - 1)
+}
+
+// parsePackets parses the packet messages in the buffer received from the socket, the
+// other messages are skipped
+func parsePackets(buf []byte, now time.Time) ([]Packet, error) {
+	msgs, err := syscall.ParseNetlinkMessage(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse nfnetlink messages: %w", err)
+	}
+	res := make([]Packet, 0, len(msgs))
+	for _, m := range msgs {
+		// the nfgenmsg header precedes the attributes
+		if m.Header.Type != msgTypePacket || len(m.Data) < 4 {
+			continue
+		}
+		packet := Packet{Time: now}
+		data := m.Data[4:]
+		for len(data) >= unix.SizeofNlAttr {
+			length := int(binary.NativeEndian.Uint16(data[0:2]))
+			typ := binary.NativeEndian.Uint16(data[2:4]) & nlaTypeMask
+			if length < unix.SizeofNlAttr || length > len(data) {
+				break
+			}
+			value := data[unix.SizeofNlAttr:length]
+			switch typ {
+			case attrPrefix:
+				packet.Prefix = string(trimNull(value))
+			case attrPayload:
+				packet.Payload = append([]byte(nil), value...)
+			case attrTimestamp:
+				// the seconds and the microseconds in the network byte order
+				if len(value) >= 16 {
+					sec := int64(binary.BigEndian.Uint64(value[0:8]))
+					usec := int64(binary.BigEndian.Uint64(value[8:16]))
+					packet.Time = time.Unix(sec, usec*int64(time.Microsecond))
+				}
+			}
+			if nlaAlign(length) >= len(data) {
+				break
+			}
+			data = data[nlaAlign(length):]
+		}
+		res = append(res, packet)
+	}
+	return res, nil
+}
+
+func trimNull(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
+
+// Connection is the addresses of the connection of a packet
+type Connection struct {
+	Protocol string
+	Src      net.IP
+	Dst      net.IP
+	// SrcPort and DstPort are 0 for the protocols without ports
+	SrcPort uint16
+	DstPort uint16
+}
+
+// ParseConnection parses the connection of the packet from the IP header, it returns false
+// if the packet is not an IPv4 or IPv6 packet
+func ParseConnection(payload []byte) (Connection, bool) {
+	conn := Connection{}
+	if len(payload) == 0 {
+		return conn, false
+	}
+	var proto uint8
+	var transport []byte
+	switch payload[0] >> 4 {
+	case 4:
+		if len(payload) < 20 {
+			return conn, false
+		}
+		headerLen := int(payload[0]&0x0f) * 4
+		proto = payload[9]
+		conn.Src, conn.Dst = net.IP(payload[12:16]), net.IP(payload[16:20])
+		// the ports are only in the first fragment
+		if fragOffset := binary.BigEndian.Uint16(payload[6:8]) & 0x1fff; fragOffset == 0 && len(payload) > headerLen {
+			transport = payload[headerLen:]
+		}
+	case 6:
+		if len(payload) < 40 {
+			return conn, false
+		}
+		// the extension headers are not followed
+		proto = payload[6]
+		conn.Src, conn.Dst = net.IP(payload[8:24]), net.IP(payload[24:40])
+		transport = payload[40:]
+	default:
+		return conn, false
+	}
+
+	switch proto {
+	case unix.IPPROTO_TCP:
+		conn.Protocol = "tcp"
+	case unix.IPPROTO_UDP:
+		conn.Protocol = "udp"
+	case unix.IPPROTO_SCTP:
+		conn.Protocol = "sctp"
+	case unix.IPPROTO_ICMP:
+		conn.Protocol = "icmp"
+	case unix.IPPROTO_ICMPV6:
+		conn.Protocol = "icmpv6"
+	default:
+		conn.Protocol = fmt.Sprintf("%d", proto)
+	}
+	if conn.Protocol == "tcp" || conn.Protocol == "udp" || conn.Protocol == "sctp" {
+		if len(transport) >= 4 {
+			conn.SrcPort = binary.BigEndian.Uint16(transport[0:2])
+			conn.DstPort = binary.BigEndian.Uint16(transport[2:4])
+		}
+	}
+	return conn, true
+}
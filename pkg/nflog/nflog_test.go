@@ -0,0 +1,112 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package nflog
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func buildPacketMessage(attrs ...[]byte) []byte {
+	msg := buildConfigMessage(1, unix.AF_INET, 100, attrs...)
+	binary.NativeEndian.PutUint16(msg[4:6], msgTypePacket)
+	return msg
+}
+
+func TestBuildConfigMessage(t *testing.T) {
+	msg := buildConfigMessage(3, unix.AF_UNSPEC, 100, cmdAttr(cfgCmdBind))
+	assert.Len(t, msg, unix.NLMSG_HDRLEN+4+8)
+	assert.Equal(t, uint32(len(msg)), binary.NativeEndian.Uint32(msg[0:4]))
+	assert.Equal(t, uint16(0x0401), binary.NativeEndian.Uint16(msg[4:6]))
+	// the group is in the network byte order
+	assert.Equal(t, []byte{0, 0, 0, 100}, msg[unix.NLMSG_HDRLEN:unix.NLMSG_HDRLEN+4])
+	// the attribute is padded to 4 bytes
+	assert.Equal(t, uint16(5), binary.NativeEndian.Uint16(msg[unix.NLMSG_HDRLEN+4:]))
+
+	mode := modeAttr(128)
+	assert.Len(t, mode, 12)
+	assert.Equal(t, []byte{0, 0, 0, 128, copyModePacket}, mode[4:9])
+}
+
+func TestParsePackets(t *testing.T) {
+	now := time.Unix(100, 0)
+	timestamp := make([]byte, 16)
+	binary.BigEndian.PutUint64(timestamp[0:8], 1700000000)
+	binary.BigEndian.PutUint64(timestamp[8:16], 500)
+	payload := []byte{0x45, 0, 0, 20}
+
+	buf := append(buildPacketMessage(
+		buildAttr(attrPrefix, []byte("egress-log-abc\x00")),
+		buildAttr(attrTimestamp, timestamp),
+		buildAttr(attrPayload, payload),
+	), buildPacketMessage(buildAttr(attrPrefix, []byte("other\x00")))...)
+	// the other messages are skipped
+	buf = append(buf, buildConfigMessage(2, unix.AF_INET, 100, cmdAttr(cfgCmdBind))...)
+
+	packets, err := parsePackets(buf, now)
+	assert.NoError(t, err)
+	assert.Len(t, packets, 2)
+	assert.Equal(t, "egress-log-abc", packets[0].Prefix)
+	assert.Equal(t, time.Unix(1700000000, 500000), packets[0].Time)
+	assert.Equal(t, payload, packets[0].Payload)
+	assert.Equal(t, "other", packets[1].Prefix)
+	assert.Equal(t, now, packets[1].Time)
+	assert.Nil(t, packets[1].Payload)
+
+	// the message is longer than the buffer
+	truncated := buildPacketMessage(buildAttr(attrPayload, payload))
+	_, err = parsePackets(truncated[:len(truncated)-4], now)
+	assert.Error(t, err)
+}
+
+func TestParseConnection(t *testing.T) {
+	ipv4 := make([]byte, 24)
+	ipv4[0] = 0x45
+	ipv4[9] = unix.IPPROTO_TCP
+	copy(ipv4[12:16], net.ParseIP("10.6.0.10").To4())
+	copy(ipv4[16:20], net.ParseIP("1.1.1.1").To4())
+	binary.BigEndian.PutUint16(ipv4[20:22], 40000)
+	binary.BigEndian.PutUint16(ipv4[22:24], 443)
+
+	conn, ok := ParseConnection(ipv4)
+	assert.True(t, ok)
+	assert.Equal(t, "tcp", conn.Protocol)
+	assert.Equal(t, "10.6.0.10", conn.Src.String())
+	assert.Equal(t, "1.1.1.1", conn.Dst.String())
+	assert.Equal(t, uint16(40000), conn.SrcPort)
+	assert.Equal(t, uint16(443), conn.DstPort)
+
+	// the ports are not in the following fragments
+	binary.BigEndian.PutUint16(ipv4[6:8], 10)
+	conn, ok = ParseConnection(ipv4)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(0), conn.DstPort)
+
+	ipv6 := make([]byte, 44)
+	ipv6[0] = 0x60
+	ipv6[6] = unix.IPPROTO_UDP
+	copy(ipv6[8:24], net.ParseIP("fd00::10"))
+	copy(ipv6[24:40], net.ParseIP("2001:db8::1"))
+	binary.BigEndian.PutUint16(ipv6[40:42], 5353)
+	binary.BigEndian.PutUint16(ipv6[42:44], 53)
+
+	conn, ok = ParseConnection(ipv6)
+	assert.True(t, ok)
+	assert.Equal(t, "udp", conn.Protocol)
+	assert.Equal(t, "fd00::10", conn.Src.String())
+	assert.Equal(t, "2001:db8::1", conn.Dst.String())
+	assert.Equal(t, uint16(53), conn.DstPort)
+
+	_, ok = ParseConnection(nil)
+	assert.False(t, ok)
+	_, ok = ParseConnection(ipv4[:10])
+	assert.False(t, ok)
+	_, ok = ParseConnection([]byte{0x10, 0, 0, 0})
+	assert.False(t, ok)
+}
@@ -0,0 +1,119 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package privilege
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Capability is a Linux capability, the value is its bit in the capability sets
+type Capability uint
+
+const (
+	CapNetAdmin Capability = 12
+	CapNetRaw   Capability = 13
+)
+
+// capabilityNames is the names of the capabilities by their bits
+var capabilityNames = []string{
+	"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_DAC_READ_SEARCH", "CAP_FOWNER", "CAP_FSETID",
+	"CAP_KILL", "CAP_SETGID", "CAP_SETUID", "CAP_SETPCAP", "CAP_LINUX_IMMUTABLE",
+	"CAP_NET_BIND_SERVICE", "CAP_NET_BROADCAST", "CAP_NET_ADMIN", "CAP_NET_RAW", "CAP_IPC_LOCK",
+	"CAP_IPC_OWNER", "CAP_SYS_MODULE", "CAP_SYS_RAWIO", "CAP_SYS_CHROOT", "CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT", "CAP_SYS_ADMIN", "CAP_SYS_BOOT", "CAP_SYS_NICE", "CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME", "CAP_SYS_TTY_CONFIG", "CAP_MKNOD", "CAP_LEASE", "CAP_AUDIT_WRITE",
+	"CAP_AUDIT_CONTROL", "CAP_SETFCAP", "CAP_MAC_OVERRIDE", "CAP_MAC_ADMIN", "CAP_SYSLOG",
+	"CAP_WAKE_ALARM", "CAP_BLOCK_SUSPEND", "CAP_AUDIT_READ", "CAP_PERFMON", "CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+func (c Capability) String() string {
+	if int(c) < len(capabilityNames) {
+		return capabilityNames[c]
+	}
+	return fmt.Sprintf("CAP_%d", c)
+}
+
+// AgentCapabilities is the capabilities the agent runs with when the sysctls are written
+// and the iptables commands are run by the helper:
+//   - CAP_NET_ADMIN to manage the links, addresses, routes, neighbors, conntrack entries
+//     and ipsets of the node by netlink, the kernel checks it on the sending process
+//   - CAP_NET_RAW to announce the EIPs by ARP and NDP, and to capture the packets
+var AgentCapabilities = []Capability{CapNetAdmin, CapNetRaw}
+
+// CapabilitySet is the bitmap of a capability set of a process
+type CapabilitySet uint64
+
+func (s CapabilitySet) Has(c Capability) bool {
+	return s&(1<<c) != 0
+}
+
+// Missing returns the capabilities not in the set
+func (s CapabilitySet) Missing(caps []Capability) []Capability {
+	res := make([]Capability, 0)
+	for _, c := range caps {
+		if !s.Has(c) {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// Extra returns the capabilities in the set except the ones of caps
+func (s CapabilitySet) Extra(caps []Capability) []Capability {
+	var expected CapabilitySet
+	for _, c := range caps {
+		expected |= 1 << c
+	}
+	res := make([]Capability, 0)
+	for c := Capability(0); c < 64; c++ {
+		if s.Has(c) && !expected.Has(c) {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// EffectiveCapabilities returns the effective capability set of the process
+func EffectiveCapabilities() (CapabilitySet, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return parseEffectiveCapabilities(f)
+}
+
+func parseEffectiveCapabilities(r io.Reader) (CapabilitySet, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, val, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || key != "CapEff" {
+			continue
+		}
+		set, err := strconv.ParseUint(strings.TrimSpace(val), 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid CapEff %q: %w", val, err)
+		}
+		return CapabilitySet(set), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("CapEff not found")
+}
+
+// CheckAgentCapabilities returns an error if the set lacks any capability the agent needs,
+// and returns the capabilities beyond the need of the agent.
+func CheckAgentCapabilities(set CapabilitySet) ([]Capability, error) {
+	if missing := set.Missing(AgentCapabilities); len(missing) > 0 {
+		return nil, fmt.Errorf("the agent requires capabilities %v, missing %v", AgentCapabilities, missing)
+	}
+	return set.Extra(AgentCapabilities), nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package privilege
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables/cmdshim"
+)
+
+// commandTimeout is the timeout of running a command by the helper, iptables-restore may
+// wait for the xtables lock
+const commandTimeout = time.Minute * 2
+
+// allowedCommands is the commands the helper runs for the agent, they're the iptables
+// binaries reading and programming the rules of the node
+var allowedCommands = []*regexp.Regexp{
+	regexp.MustCompile(`^ip6?tables(-legacy|-nft)?(-save|-restore)?$`),
+}
+
+// commandRequest requests the helper to run the command with the stdin
+type commandRequest struct {
+	Name  string   `json:"name"`
+	Args  []string `json:"args,omitempty"`
+	Stdin []byte   `json:"stdin,omitempty"`
+}
+
+func commandAllowed(name string) bool {
+	for _, reg := range allowedCommands {
+		if reg.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// runCommand runs the allowed command, the non-zero exit code of the command is returned
+// in the response rather than as an error
+func (h *Helper) runCommand(req *commandRequest) *response {
+	if !commandAllowed(req.Name) {
+		return &response{Error: fmt.Sprintf("command %s is not allowed", req.Name)}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, req.Name, req.Args...)
+	cmd.Stdin = bytes.NewReader(req.Stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	res := &response{}
+	if err := cmd.Run(); err != nil {
+		exitErr := new(exec.ExitError)
+		if !errors.As(err, &exitErr) {
+			return &response{Error: err.Error()}
+		}
+		res.ExitCode = exitErr.ExitCode()
+	}
+	res.Stdout = stdout.Bytes()
+	res.Stderr = stderr.Bytes()
+	return res
+}
+
+// NewCmdFactory returns the factory of the commands run by the helper listening on the
+// socket, the agent running with the helper programs the iptables rules by it.
+func NewCmdFactory(socket string) cmdshim.CmdFactory {
+	client := &helperClient{socket: socket}
+	return func(name string, arg ...string) cmdshim.Command {
+		return &helperCmd{client: client, name: name, args: arg}
+	}
+}
+
+// helperCmd is the cmdshim.Command run by the helper, the output is sent back after the
+// command exits, so the StdoutPipe is only fed then.
+type helperCmd struct {
+	client *helperClient
+	name   string
+	args   []string
+
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	// the reader and the writer of the StdoutPipe
+	pipeR *io.PipeReader
+	pipeW *io.PipeWriter
+	done  chan error
+}
+
+func (c *helperCmd) SetStdin(r io.Reader) {
+	c.stdin = r
+}
+
+func (c *helperCmd) SetStdout(w io.Writer) {
+	c.stdout = w
+}
+
+func (c *helperCmd) SetStderr(w io.Writer) {
+	c.stderr = w
+}
+
+func (c *helperCmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+func (c *helperCmd) Start() error {
+	if c.done != nil {
+		return errors.New("command already started")
+	}
+	req := &commandRequest{Name: c.name, Args: c.args}
+	if c.stdin != nil {
+		stdin, err := io.ReadAll(c.stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %w", err)
+		}
+		req.Stdin = stdin
+	}
+	c.done = make(chan error, 1)
+	go func() {
+		err := c.run(req)
+		if c.pipeW != nil {
+			_ = c.pipeW.CloseWithError(err)
+		}
+		c.done <- err
+	}()
+	return nil
+}
+
+func (c *helperCmd) run(req *commandRequest) error {
+	res, err := c.client.call(&request{Command: req}, commandTimeout)
+	if err != nil {
+		return err
+	}
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+	if c.stdout != nil {
+		if _, err := c.stdout.Write(res.Stdout); err != nil {
+			return err
+		}
+	}
+	if c.stderr != nil {
+		if _, err := c.stderr.Write(res.Stderr); err != nil {
+			return err
+		}
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("%s exited with code %d: %s", c.name, res.ExitCode, bytes.TrimSpace(res.Stderr))
+	}
+	return nil
+}
+
+// Kill stops feeding the StdoutPipe, the command run by the helper isn't interrupted
+func (c *helperCmd) Kill() error {
+	if c.pipeR != nil {
+		return c.pipeR.CloseWithError(errors.New("command killed"))
+	}
+	return nil
+}
+
+func (c *helperCmd) Wait() error {
+	if c.done == nil {
+		return errors.New("command not started")
+	}
+	return <-c.done
+}
+
+func (c *helperCmd) Output() ([]byte, error) {
+	var stdout bytes.Buffer
+	c.stdout = &stdout
+	err := c.Run()
+	return stdout.Bytes(), err
+}
+
+func (c *helperCmd) StdoutPipe() (io.ReadCloser, error) {
+	if c.done != nil {
+		return nil, errors.New("StdoutPipe after command started")
+	}
+	c.pipeR, c.pipeW = io.Pipe()
+	c.stdout = c.pipeW
+	return c.pipeR, nil
+}
+
+func (c *helperCmd) String() string {
+	return strings.Join(append([]string{c.name}, c.args...), " ")
+}
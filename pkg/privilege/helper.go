@@ -0,0 +1,141 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package privilege
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Helper is the privileged helper of the agent running without the privileges. It does
+// nothing but writes the allowed sysctls and runs the iptables commands requested on the
+// unix socket, so it's the only part of the agent that runs in a privileged container.
+type Helper struct {
+	Socket  string
+	ProcSys string
+	Log     logr.Logger
+}
+
+// Start serves the requests until the ctx is done
+func (h *Helper) Start(ctx context.Context) error {
+	if err := os.MkdirAll(filepath.Dir(h.Socket), 0700); err != nil {
+		return err
+	}
+	if err := os.Remove(h.Socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	listener, err := net.Listen("unix", h.Socket)
+	if err != nil {
+		return err
+	}
+	// only the agent running as root in the pod writes to the socket
+	if err := os.Chmod(h.Socket, 0600); err != nil {
+		_ = listener.Close()
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+
+	h.Log.Info("privileged helper started", "socket", h.Socket)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go h.serve(conn)
+	}
+}
+
+func (h *Helper) serve(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(sysctlTimeout))
+
+	req := new(request)
+	if err := json.NewDecoder(conn).Decode(req); err != nil {
+		h.Log.Error(err, "failed to read request")
+		return
+	}
+	res := new(response)
+	switch {
+	case req.Sysctl != nil:
+		key, value := req.Sysctl.Key, req.Sysctl.Value
+		if err := h.writeSysctl(key, value); err != nil {
+			h.Log.Error(err, "failed to write sysctl", "key", key, "value", value)
+			res.Error = err.Error()
+		} else {
+			h.Log.Info("write sysctl", "key", key, "value", value)
+		}
+	case req.Command != nil:
+		_ = conn.SetDeadline(time.Now().Add(commandTimeout))
+		res = h.runCommand(req.Command)
+		if res.Error != "" {
+			h.Log.Error(errors.New(res.Error), "failed to run command", "name", req.Command.Name, "args", req.Command.Args)
+		} else {
+			h.Log.V(1).Info("run command", "name", req.Command.Name, "args", req.Command.Args, "exitCode", res.ExitCode)
+		}
+	default:
+		res.Error = "invalid request"
+	}
+	if err := json.NewEncoder(conn).Encode(res); err != nil {
+		h.Log.Error(err, "failed to send response")
+	}
+}
+
+func (h *Helper) writeSysctl(key, value string) error {
+	if !sysctlAllowed(key) {
+		return fmt.Errorf("sysctl %s is not allowed", key)
+	}
+	return writeSysctl(filepath.Join(h.ProcSys, key), value)
+}
+
+// request is sent to the helper as a line of JSON, one of the fields is set, and the
+// helper responds with a response
+type request struct {
+	Sysctl  *sysctlRequest  `json:"sysctl,omitempty"`
+	Command *commandRequest `json:"command,omitempty"`
+}
+
+// response is the result of a request, the output and the exit code are set for the
+// commands
+type response struct {
+	Error    string `json:"error,omitempty"`
+	ExitCode int    `json:"exitCode,omitempty"`
+	Stdout   []byte `json:"stdout,omitempty"`
+	Stderr   []byte `json:"stderr,omitempty"`
+}
+
+type helperClient struct {
+	socket string
+}
+
+func (c *helperClient) call(req *request, timeout time.Duration) (*response, error) {
+	conn, err := net.DialTimeout("unix", c.socket, time.Second*5)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect privileged helper: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("failed to send request to privileged helper: %w", err)
+	}
+	res := new(response)
+	if err := json.NewDecoder(conn).Decode(res); err != nil {
+		return nil, fmt.Errorf("failed to read response of privileged helper: %w", err)
+	}
+	return res, nil
+}
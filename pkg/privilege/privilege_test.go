@@ -0,0 +1,131 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package privilege
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilities(t *testing.T) {
+	status := "Name:\tagent\nCapInh:\t0000000000000000\nCapPrm:\t0000000000003000\nCapEff:\t0000000000003000\n"
+	set, err := parseEffectiveCapabilities(strings.NewReader(status))
+	assert.NoError(t, err)
+	assert.True(t, set.Has(CapNetAdmin))
+	assert.True(t, set.Has(CapNetRaw))
+
+	extra, err := CheckAgentCapabilities(set)
+	assert.NoError(t, err)
+	assert.Empty(t, extra)
+
+	// the privileged container gets all the capabilities
+	extra, err = CheckAgentCapabilities(CapabilitySet(0x1ffffffffff))
+	assert.NoError(t, err)
+	assert.Contains(t, extra, Capability(21))
+	assert.Equal(t, "CAP_SYS_ADMIN", Capability(21).String())
+
+	_, err = CheckAgentCapabilities(CapabilitySet(1 << CapNetAdmin))
+	assert.ErrorContains(t, err, "CAP_NET_RAW")
+
+	_, err = parseEffectiveCapabilities(strings.NewReader("Name:\tagent\n"))
+	assert.Error(t, err)
+}
+
+func TestSysctlAllowed(t *testing.T) {
+	for key, allowed := range map[string]bool{
+		"net/ipv4/conf/all/rp_filter":                        true,
+		"net/ipv4/conf/egress.vxlan/rp_filter":               true,
+		"net/netfilter/nf_conntrack_udp_timeout":             true,
+		"net/netfilter/nf_conntrack_tcp_timeout_established": true,
+		"net/ipv6/neigh/default/gc_thresh3":                  true,
+		"net/ipv4/neigh/default/gc_thresh1":                  false,
+		"net/ipv4/conf/../../../kernel/rp_filter":            false,
+		"net/ipv4/conf/../rp_filter":                         false,
+		"net/ipv4/ip_forward":                                false,
+		"kernel/core_pattern":                                false,
+	} {
+		assert.Equal(t, allowed, sysctlAllowed(key), key)
+	}
+}
+
+func TestCommandAllowed(t *testing.T) {
+	for name, allowed := range map[string]bool{
+		"iptables":              true,
+		"ip6tables-restore":     true,
+		"iptables-nft-save":     true,
+		"ip6tables-legacy-save": true,
+		"ipset":                 false,
+		"/usr/sbin/iptables":    false,
+		"sh":                    false,
+	} {
+		assert.Equal(t, allowed, commandAllowed(name), name)
+	}
+}
+
+func TestHelper(t *testing.T) {
+	root := t.TempDir()
+	key := "net/netfilter/nf_conntrack_udp_timeout"
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "net/netfilter"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, key), []byte("30\n"), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	socket := filepath.Join(t.TempDir(), "helper.sock")
+	helper := &Helper{Socket: socket, ProcSys: root, Log: logr.Discard()}
+	done := make(chan error)
+	go func() { done <- helper.Start(ctx) }()
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(socket)
+		return err == nil
+	}, time.Second*5, time.Millisecond*10)
+
+	writer := NewSysctlWriter("", socket)
+	assert.NoError(t, writer.WriteSysctl(key, "60"))
+	val, err := os.ReadFile(filepath.Join(root, key))
+	assert.NoError(t, err)
+	assert.Equal(t, "60", string(val))
+
+	assert.ErrorContains(t, writer.WriteSysctl("kernel/core_pattern", "|/bin/sh"), "not allowed")
+
+	newCmd := NewCmdFactory(socket)
+	_, err = newCmd("cat").Output()
+	assert.ErrorContains(t, err, "not allowed")
+
+	allowed := allowedCommands
+	defer func() { allowedCommands = allowed }()
+	allowedCommands = append(allowedCommands, regexp.MustCompile(`^(cat|false)$`))
+
+	var stdout bytes.Buffer
+	cmd := newCmd("cat")
+	cmd.SetStdin(strings.NewReader("*filter\nCOMMIT\n"))
+	cmd.SetStdout(&stdout)
+	assert.NoError(t, cmd.Run())
+	assert.Equal(t, "*filter\nCOMMIT\n", stdout.String())
+
+	cmd = newCmd("cat", "-")
+	cmd.SetStdin(strings.NewReader("*nat\n"))
+	pipe, err := cmd.StdoutPipe()
+	assert.NoError(t, err)
+	assert.NoError(t, cmd.Start())
+	out, err := io.ReadAll(pipe)
+	assert.NoError(t, err)
+	assert.Equal(t, "*nat\n", string(out))
+	assert.NoError(t, cmd.Wait())
+
+	_, err = newCmd("false").Output()
+	assert.ErrorContains(t, err, "exited with code 1")
+
+	cancel()
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,101 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package privilege
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// sysctlTimeout is the timeout of writing a sysctl by the helper
+const sysctlTimeout = time.Second * 5
+
+// allowedSysctls is the sysctls the helper writes for the agent, the keys are relative
+// to /proc/sys
+var allowedSysctls = []*regexp.Regexp{
+	regexp.MustCompile(`^net/ipv[46]/conf/[a-zA-Z0-9._-]+/rp_filter$`),
+	regexp.MustCompile(`^net/netfilter/nf_conntrack_[a-z_]+_timeout(_[a-z]+)?$`),
+	regexp.MustCompile(`^net/ipv[46]/neigh/default/gc_thresh[23]$`),
+}
+
+// SysctlWriter writes the sysctls of the node, the key is relative to /proc/sys, such
+// as net/ipv4/conf/all/rp_filter
+type SysctlWriter interface {
+	WriteSysctl(key, value string) error
+}
+
+// NewSysctlWriter returns the SysctlWriter writing the sysctls under procSys by itself,
+// or through the helper listening on the socket if it is not empty. The agent without
+// the privilege to write /proc/sys, which is mounted read-only in the unprivileged
+// containers, uses the helper.
+func NewSysctlWriter(procSys, socket string) SysctlWriter {
+	if socket != "" {
+		return &helperClient{socket: socket}
+	}
+	return &directWriter{procSys: procSys}
+}
+
+type directWriter struct {
+	procSys string
+}
+
+func (w *directWriter) WriteSysctl(key, value string) error {
+	return writeSysctl(filepath.Join(w.procSys, key), value)
+}
+
+func writeSysctl(path, value string) (err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() {
+		if cErr := f.Close(); cErr != nil && err == nil {
+			err = fmt.Errorf("failed to close file: %w", cErr)
+		}
+	}()
+
+	n, err := f.Write([]byte(value))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if n < len(value) {
+		return io.ErrShortWrite
+	}
+	return nil
+}
+
+// sysctlRequest requests the helper to write the sysctl
+type sysctlRequest struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (c *helperClient) WriteSysctl(key, value string) error {
+	res, err := c.call(&request{Sysctl: &sysctlRequest{Key: key, Value: value}}, sysctlTimeout)
+	if err != nil {
+		return err
+	}
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+	return nil
+}
+
+func sysctlAllowed(key string) bool {
+	if strings.Contains(key, "..") {
+		return false
+	}
+	for _, reg := range allowedSysctls {
+		if reg.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package profiling
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+)
+
+// DebugHandlers returns the handlers of the runtime diagnostics by their paths:
+//   - /debug/pprof/ serves the profiles of pprof, such as /debug/pprof/profile?seconds=30
+//   - /debug/vars serves the variables of expvar, including the memstats
+//   - /debug/goroutines dumps the stacks of all goroutines
+func DebugHandlers() map[string]http.Handler {
+	return map[string]http.Handler{
+		"/debug/pprof/":        http.HandlerFunc(pprof.Index),
+		"/debug/pprof/cmdline": http.HandlerFunc(pprof.Cmdline),
+		"/debug/pprof/profile": http.HandlerFunc(pprof.Profile),
+		"/debug/pprof/symbol":  http.HandlerFunc(pprof.Symbol),
+		"/debug/pprof/trace":   http.HandlerFunc(pprof.Trace),
+		"/debug/vars":          expvar.Handler(),
+		"/debug/goroutines":    http.HandlerFunc(dumpGoroutines),
+	}
+}
+
+func dumpGoroutines(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	// debug 2 prints the stacks in the same format as an unrecovered panic
+	_ = runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+}
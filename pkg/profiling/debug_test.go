@@ -0,0 +1,30 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package profiling
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebugHandlers(t *testing.T) {
+	mux := http.NewServeMux()
+	for path, handler := range DebugHandlers() {
+		mux.Handle(path, handler)
+	}
+	for path, want := range map[string]string{
+		"/debug/pprof/":             "goroutine",
+		"/debug/pprof/heap?debug=1": "heap profile",
+		"/debug/vars":               "memstats",
+		"/debug/goroutines":         "TestDebugHandlers",
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+		assert.Contains(t, rec.Body.String(), want, path)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package proxyprotocol builds the headers of the PROXY protocol v2, which tell the
+// upstreams the original addresses of the relayed connections.
+package proxyprotocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// signature starts the v2 headers
+var signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	// versionProxy is the version 2 and the PROXY command
+	versionProxy = 0x21
+	// the address families with the STREAM transport
+	familyTCP4 = 0x11
+	familyTCP6 = 0x21
+)
+
+// HeaderV2 returns the v2 header of the TCP connection from src to dst, the addresses should
+// be of the same IP version
+func HeaderV2(src, dst *net.TCPAddr) ([]byte, error) {
+	family := byte(familyTCP4)
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	if srcIP == nil || dstIP == nil {
+		family = familyTCP6
+		srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+		if srcIP == nil || dstIP == nil || src.IP.To4() != nil || dst.IP.To4() != nil {
+			return nil, fmt.Errorf("the addresses %s and %s are not of the same IP version", src, dst)
+		}
+	}
+
+	addrLen := 2*len(srcIP) + 4
+	header := make([]byte, 0, len(signature)+4+addrLen)
+	header = append(header, signature...)
+	header = append(header, versionProxy, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(addrLen))
+	header = append(header, srcIP...)
+	header = append(header, dstIP...)
+	header = binary.BigEndian.AppendUint16(header, uint16(src.Port))
+	header = binary.BigEndian.AppendUint16(header, uint16(dst.Port))
+	return header, nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package proxyprotocol
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderV2(t *testing.T) {
+	header, err := HeaderV2(
+		&net.TCPAddr{IP: net.ParseIP("10.21.0.1"), Port: 40000},
+		&net.TCPAddr{IP: net.ParseIP("172.16.0.10"), Port: 5432},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x0d, 0x0a, 0x0d, 0x0a, 0x00, 0x0d, 0x0a, 0x51, 0x55, 0x49, 0x54, 0x0a,
+		0x21, 0x11, 0x00, 0x0c,
+		10, 21, 0, 1,
+		172, 16, 0, 10,
+		0x9c, 0x40,
+		0x15, 0x38,
+	}, header)
+
+	header, err = HeaderV2(
+		&net.TCPAddr{IP: net.ParseIP("fd00::1"), Port: 40000},
+		&net.TCPAddr{IP: net.ParseIP("fd00:1::10"), Port: 443},
+	)
+	assert.NoError(t, err)
+	assert.Len(t, header, 16+36)
+	assert.Equal(t, []byte{0x21, 0x21, 0x00, 0x24}, header[12:16])
+	assert.Equal(t, net.ParseIP("fd00::1"), net.IP(header[16:32]))
+	assert.Equal(t, []byte{0x01, 0xbb}, header[50:52])
+
+	_, err = HeaderV2(
+		&net.TCPAddr{IP: net.ParseIP("10.21.0.1"), Port: 40000},
+		&net.TCPAddr{IP: net.ParseIP("fd00:1::10"), Port: 443},
+	)
+	assert.Error(t, err)
+}
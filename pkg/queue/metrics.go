@@ -0,0 +1,162 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// the workqueue metrics registered by controller-runtime, which are exported again with
+// the prefix `egress_` and the label `controller`
+var workqueueMetrics = []struct {
+	name      string
+	help      string
+	collector prometheus.Collector
+}{
+	{
+		name: "egress_workqueue_depth",
+		help: "Current depth of the workqueue of the controller",
+		collector: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Subsystem: metrics.WorkQueueSubsystem,
+			Name:      metrics.DepthKey,
+			Help:      "Current depth of workqueue",
+		}, []string{"name"}),
+	},
+	{
+		name: "egress_workqueue_adds_total",
+		help: "Total number of adds handled by the workqueue of the controller",
+		collector: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: metrics.WorkQueueSubsystem,
+			Name:      metrics.AddsKey,
+			Help:      "Total number of adds handled by workqueue",
+		}, []string{"name"}),
+	},
+	{
+		name: "egress_workqueue_retries_total",
+		help: "Total number of retries handled by the workqueue of the controller",
+		collector: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: metrics.WorkQueueSubsystem,
+			Name:      metrics.RetriesKey,
+			Help:      "Total number of retries handled by workqueue",
+		}, []string{"name"}),
+	},
+	{
+		name: "egress_workqueue_queue_duration_seconds",
+		help: "How long in seconds an item stays in the workqueue of the controller before being requested",
+		collector: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Subsystem: metrics.WorkQueueSubsystem,
+			Name:      metrics.QueueLatencyKey,
+			Help:      "How long in seconds an item stays in workqueue before being requested",
+			Buckets:   prometheus.ExponentialBuckets(10e-9, 10, 10),
+		}, []string{"name"}),
+	},
+	{
+		name: "egress_workqueue_work_duration_seconds",
+		help: "How long in seconds the controller takes to process an item from its workqueue",
+		collector: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Subsystem: metrics.WorkQueueSubsystem,
+			Name:      metrics.WorkDurationKey,
+			Help:      "How long in seconds processing an item from workqueue takes.",
+			Buckets:   prometheus.ExponentialBuckets(10e-9, 10, 10),
+		}, []string{"name"}),
+	},
+}
+
+// relabeled exports a metric of controller-runtime with another name and labels
+type relabeled struct {
+	desc   *prometheus.Desc
+	source prometheus.Collector
+}
+
+// Collector exports the workqueue metrics of controller-runtime as `egress_workqueue_*`
+// with the label `controller`, and the constant label `component` of the binary
+type Collector struct {
+	metrics []relabeled
+}
+
+// NewCollector returns the collector of the workqueue metrics. The metrics are read from
+// the collectors registered by controller-runtime, which are found by registering the
+// collectors with the same descriptions again.
+func NewCollector(component string) *Collector {
+	c := new(Collector)
+	for _, item := range workqueueMetrics {
+		source := registered(metrics.Registry, item.collector)
+		if source == nil {
+			continue
+		}
+		c.metrics = append(c.metrics, relabeled{
+			desc:   prometheus.NewDesc(item.name, item.help, []string{"controller"}, prometheus.Labels{"component": component}),
+			source: source,
+		})
+	}
+	return c
+}
+
+// registered returns the collector registered with the same descriptions as c, or nil
+func registered(reg prometheus.Registerer, c prometheus.Collector) prometheus.Collector {
+	err := reg.Register(c)
+	if err == nil {
+		reg.Unregister(c)
+		return nil
+	}
+	are := prometheus.AlreadyRegisteredError{}
+	if errors.As(err, &are) {
+		return are.ExistingCollector
+	}
+	return nil
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, item := range c.metrics {
+		ch <- item.desc
+	}
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, item := range c.metrics {
+		source := make(chan prometheus.Metric)
+		go func(collector prometheus.Collector) {
+			collector.Collect(source)
+			close(source)
+		}(item.source)
+		for m := range source {
+			if res := relabel(item.desc, m); res != nil {
+				ch <- res
+			}
+		}
+	}
+}
+
+// relabel returns the metric with the description, the label `name` of the workqueue
+// becomes the label `controller`
+func relabel(desc *prometheus.Desc, m prometheus.Metric) prometheus.Metric {
+	out := new(dto.Metric)
+	if err := m.Write(out); err != nil {
+		return nil
+	}
+	name := ""
+	for _, label := range out.GetLabel() {
+		if label.GetName() == "name" {
+			name = label.GetValue()
+		}
+	}
+	switch {
+	case out.Gauge != nil:
+		return prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, out.GetGauge().GetValue(), name)
+	case out.Counter != nil:
+		return prometheus.MustNewConstMetric(desc, prometheus.CounterValue, out.GetCounter().GetValue(), name)
+	case out.Histogram != nil:
+		buckets := make(map[float64]uint64, len(out.GetHistogram().GetBucket()))
+		for _, bucket := range out.GetHistogram().GetBucket() {
+			buckets[bucket.GetUpperBound()] = bucket.GetCumulativeCount()
+		}
+		return prometheus.MustNewConstHistogram(desc, out.GetHistogram().GetSampleCount(),
+			out.GetHistogram().GetSampleSum(), buckets, name)
+	}
+	return nil
+}
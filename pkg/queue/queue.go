@@ -0,0 +1,70 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/ratelimiter"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egresserrors "github.com/spidernet-io/egressgateway/pkg/errors"
+)
+
+// the defaults of workqueue.DefaultControllerRateLimiter
+const (
+	defaultBaseDelay = 5 * time.Millisecond
+	defaultMaxDelay  = 1000 * time.Second
+	defaultQPS       = 10
+	defaultBurst     = 100
+)
+
+// Options returns the options of the controller with the name, its concurrency and rate
+// limiter are overridden by the reconciler of the same name in the config. The workers
+// is the default concurrency of the controller. The errors of the reconciler are retried
+// by their classes.
+func Options(cfg *config.Config, name string, r reconcile.Reconciler, workers int) controller.Options {
+	opts := controller.Options{Reconciler: egresserrors.NewReconciler(name, r), MaxConcurrentReconciles: workers}
+	if cfg == nil {
+		return opts
+	}
+	item, ok := cfg.FileConfig.Reconcilers[name]
+	if !ok {
+		return opts
+	}
+	if item.MaxConcurrentReconciles > 0 {
+		opts.MaxConcurrentReconciles = item.MaxConcurrentReconciles
+	}
+	opts.RateLimiter = RateLimiter(item.RateLimiter)
+	return opts
+}
+
+// RateLimiter returns the rate limiter of the workqueue, the zero values of the config
+// keep the defaults of controller-runtime
+func RateLimiter(cfg config.RateLimiter) ratelimiter.RateLimiter {
+	baseDelay := defaultBaseDelay
+	if cfg.BaseDelayMillis > 0 {
+		baseDelay = time.Duration(cfg.BaseDelayMillis) * time.Millisecond
+	}
+	maxDelay := defaultMaxDelay
+	if cfg.MaxDelaySecond > 0 {
+		maxDelay = time.Duration(cfg.MaxDelaySecond) * time.Second
+	}
+	qps := rate.Limit(defaultQPS)
+	if cfg.QPS > 0 {
+		qps = rate.Limit(cfg.QPS)
+	}
+	burst := defaultBurst
+	if cfg.Burst > 0 {
+		burst = cfg.Burst
+	}
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(qps, burst)},
+	)
+}
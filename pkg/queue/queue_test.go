@@ -0,0 +1,77 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+)
+
+func TestOptions(t *testing.T) {
+	r := reconcile.Func(nil)
+	opts := Options(nil, "endpoint", r, 2)
+	assert.Equal(t, 2, opts.MaxConcurrentReconciles)
+	assert.Nil(t, opts.RateLimiter)
+
+	cfg := new(config.Config)
+	cfg.FileConfig.Reconcilers = map[string]config.Reconciler{
+		"endpoint": {MaxConcurrentReconciles: 8, RateLimiter: config.RateLimiter{BaseDelayMillis: 100}},
+	}
+	opts = Options(cfg, "egressGateway", r, 1)
+	assert.Equal(t, 1, opts.MaxConcurrentReconciles)
+	assert.Nil(t, opts.RateLimiter)
+
+	opts = Options(cfg, "endpoint", r, 2)
+	assert.Equal(t, 8, opts.MaxConcurrentReconciles)
+	assert.Equal(t, 100*time.Millisecond, opts.RateLimiter.When("p1"))
+	assert.Equal(t, 200*time.Millisecond, opts.RateLimiter.When("p1"))
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := RateLimiter(config.RateLimiter{})
+	assert.Equal(t, defaultBaseDelay, limiter.When("p1"))
+
+	limiter = RateLimiter(config.RateLimiter{BaseDelayMillis: 1000, MaxDelaySecond: 1})
+	assert.Equal(t, time.Second, limiter.When("p1"))
+	assert.Equal(t, time.Second, limiter.When("p1"))
+}
+
+func TestCollector(t *testing.T) {
+	q := workqueue.NewRateLimitingQueueWithConfig(RateLimiter(config.RateLimiter{}),
+		workqueue.RateLimitingQueueConfig{Name: "queue-test"})
+	defer q.ShutDown()
+	q.Add("p1")
+	q.Add("p2")
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollector("controller"))
+	families, err := reg.Gather()
+	assert.NoError(t, err)
+
+	found := false
+	for _, family := range families {
+		if family.GetName() != "egress_workqueue_depth" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range m.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["controller"] == "queue-test" {
+				found = true
+				assert.Equal(t, "controller", labels["component"])
+				assert.Equal(t, float64(2), m.GetGauge().GetValue())
+			}
+		}
+	}
+	assert.True(t, found)
+}
@@ -0,0 +1,124 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronField is the bits of the values matched by a field of the cron expression
+type cronField struct {
+	bits uint64
+	// any is true if the field is "*", which matters to the day of month and the day of week
+	any bool
+}
+
+func (f cronField) has(v int) bool {
+	return f.bits&(1<<uint(v)) != 0
+}
+
+type cronBounds struct {
+	name     string
+	min, max int
+}
+
+var (
+	minuteBounds = cronBounds{"minute", 0, 59}
+	hourBounds   = cronBounds{"hour", 0, 23}
+	domBounds    = cronBounds{"day of month", 1, 31}
+	monthBounds  = cronBounds{"month", 1, 12}
+	dowBounds    = cronBounds{"day of week", 0, 7}
+)
+
+// cronExpr is a standard cron expression of 5 fields
+type cronExpr struct {
+	minute, hour, dom, month, dow cronField
+}
+
+// parseCron parses the expression of minute, hour, day of month, month and day of week,
+// each field supports "*", lists, ranges and steps, such as "0,30 8-18/2 * * 1-5"
+func parseCron(expr string) (*cronExpr, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields, got %d", expr, len(fields))
+	}
+	bounds := []cronBounds{minuteBounds, hourBounds, domBounds, monthBounds, dowBounds}
+	parsed := make([]cronField, len(fields))
+	for i, field := range fields {
+		f, err := parseCronField(field, bounds[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+		}
+		parsed[i] = f
+	}
+	c := &cronExpr{minute: parsed[0], hour: parsed[1], dom: parsed[2], month: parsed[3], dow: parsed[4]}
+	// both 0 and 7 are Sunday
+	if c.dow.has(7) {
+		c.dow.bits |= 1
+	}
+	return c, nil
+}
+
+func parseCronField(field string, b cronBounds) (cronField, error) {
+	res := cronField{any: field == "*"}
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			v, err := strconv.Atoi(item[i+1:])
+			if err != nil || v <= 0 {
+				return res, fmt.Errorf("invalid step of %s %q", b.name, item)
+			}
+			rng, step = item[:i], v
+		}
+
+		var low, high int
+		switch {
+		case rng == "*":
+			low, high = b.min, b.max
+		case strings.Contains(rng, "-"):
+			parts := strings.SplitN(rng, "-", 2)
+			l, err1 := strconv.Atoi(parts[0])
+			h, err2 := strconv.Atoi(parts[1])
+			if err1 != nil || err2 != nil {
+				return res, fmt.Errorf("invalid range of %s %q", b.name, item)
+			}
+			low, high = l, h
+		default:
+			v, err := strconv.Atoi(rng)
+			if err != nil {
+				return res, fmt.Errorf("invalid value of %s %q", b.name, item)
+			}
+			low, high = v, v
+			if step > 1 {
+				// "5/10" means from 5 to the max with the step 10
+				high = b.max
+			}
+		}
+		if low < b.min || high > b.max || low > high {
+			return res, fmt.Errorf("%s %q is out of range [%d, %d]", b.name, item, b.min, b.max)
+		}
+		for v := low; v <= high; v += step {
+			res.bits |= 1 << uint(v)
+		}
+	}
+	return res, nil
+}
+
+// match returns true if the time matches the expression at the minute level. Like the
+// standard cron, the day matches either the day of month or the day of week if both of
+// them are restricted.
+func (c *cronExpr) match(t time.Time) bool {
+	if !c.minute.has(t.Minute()) || !c.hour.has(t.Hour()) || !c.month.has(int(t.Month())) {
+		return false
+	}
+	domMatch := c.dom.has(t.Day())
+	dowMatch := c.dow.has(int(t.Weekday()))
+	if c.dom.any || c.dow.any {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,94 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package schedule
+
+import (
+	"fmt"
+	"time"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+const (
+	// MaxWindowDuration is the max duration of a time window
+	MaxWindowDuration = time.Hour * 24 * 7
+	// maxNextCheck is the max interval between two checks of an inactive schedule
+	maxNextCheck = time.Hour * 24
+)
+
+type window struct {
+	start    *cronExpr
+	duration time.Duration
+}
+
+// Schedule is the parsed time windows of a policy
+type Schedule struct {
+	windows  []window
+	location *time.Location
+}
+
+// New parses the schedule of the policy
+func New(spec *egressv1.PolicySchedule) (*Schedule, error) {
+	if spec == nil || len(spec.Windows) == 0 {
+		return nil, fmt.Errorf("the schedule has no time window")
+	}
+	location := time.UTC
+	if spec.TimeZone != "" {
+		loc, err := time.LoadLocation(spec.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", spec.TimeZone, err)
+		}
+		location = loc
+	}
+
+	s := &Schedule{location: location}
+	for i, item := range spec.Windows {
+		start, err := parseCron(item.Start)
+		if err != nil {
+			return nil, fmt.Errorf("window %d: %w", i, err)
+		}
+		d := item.Duration.Duration
+		if d < time.Minute || d > MaxWindowDuration {
+			return nil, fmt.Errorf("window %d: duration %s is out of range [1m, %s]", i, d, MaxWindowDuration)
+		}
+		s.windows = append(s.windows, window{start: start, duration: d})
+	}
+	return s, nil
+}
+
+// Active returns true if now is in any of the time windows, and the next time to check
+// the schedule again, which is when the earliest active window ends, or when the next
+// window starts. The next time is at most one day later.
+func (s *Schedule) Active(now time.Time) (bool, time.Time) {
+	now = now.In(s.location)
+	minute := now.Truncate(time.Minute)
+
+	active := false
+	next := now.Add(maxNextCheck)
+	for _, w := range s.windows {
+		// the latest start of the window which covers now
+		for t := minute; now.Sub(t) < w.duration; t = t.Add(-time.Minute) {
+			if !w.start.match(t) {
+				continue
+			}
+			active = true
+			if end := t.Add(w.duration); end.Before(next) {
+				next = end
+			}
+			break
+		}
+	}
+	if active {
+		return true, next
+	}
+
+	for t := minute.Add(time.Minute); t.Before(next); t = t.Add(time.Minute) {
+		for _, w := range s.windows {
+			if w.start.match(t) {
+				return false, t
+			}
+		}
+	}
+	return false, next
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestParseCron(t *testing.T) {
+	cases := map[string]bool{
+		"* * * * *":            true,
+		"0 9 * * 1-5":          true,
+		"*/15 8-18/2 1,15 * 7": true,
+		"5/10 * * * *":         true,
+		"0 9 * *":              false,
+		"60 * * * *":           false,
+		"* 24 * * *":           false,
+		"* * 0 * *":            false,
+		"* * * 13 *":           false,
+		"* * * * 8":            false,
+		"5-1 * * * *":          false,
+		"*/0 * * * *":          false,
+		"a * * * *":            false,
+	}
+	for expr, valid := range cases {
+		_, err := parseCron(expr)
+		assert.Equal(t, valid, err == nil, expr)
+	}
+}
+
+func TestCronMatch(t *testing.T) {
+	// 2024-01-01 is Monday
+	monday := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	c, err := parseCron("0 9 * * 1-5")
+	assert.NoError(t, err)
+	assert.True(t, c.match(monday))
+	assert.False(t, c.match(monday.Add(time.Minute)))
+	assert.False(t, c.match(monday.AddDate(0, 0, 5)))
+
+	// Sunday is both 0 and 7
+	c, err = parseCron("0 9 * * 7")
+	assert.NoError(t, err)
+	assert.True(t, c.match(monday.AddDate(0, 0, 6)))
+
+	// the day matches either the day of month or the day of week if both are restricted
+	c, err = parseCron("0 9 15 * 1")
+	assert.NoError(t, err)
+	assert.True(t, c.match(monday))
+	assert.True(t, c.match(monday.AddDate(0, 0, 14)))
+	assert.False(t, c.match(monday.AddDate(0, 0, 1)))
+}
+
+func TestScheduleActive(t *testing.T) {
+	s, err := New(&egressv1.PolicySchedule{
+		Windows: []egressv1.ScheduleWindow{
+			{Start: "0 9 * * 1-5", Duration: metav1.Duration{Duration: time.Hour * 8}},
+		},
+		TimeZone: "Asia/Shanghai",
+	})
+	assert.NoError(t, err)
+	loc, err := time.LoadLocation("Asia/Shanghai")
+	assert.NoError(t, err)
+
+	// Monday 12:00 is in the window which ends at 17:00
+	now := time.Date(2024, 1, 1, 12, 0, 30, 0, loc)
+	active, next := s.Active(now)
+	assert.True(t, active)
+	assert.True(t, next.Equal(time.Date(2024, 1, 1, 17, 0, 0, 0, loc)))
+
+	// Monday 17:00 is out of the window, the next one starts on Tuesday 09:00
+	active, next = s.Active(time.Date(2024, 1, 1, 17, 0, 0, 0, loc))
+	assert.False(t, active)
+	assert.True(t, next.Equal(time.Date(2024, 1, 2, 9, 0, 0, 0, loc)))
+
+	// the time zone matters, Monday 12:00 in Shanghai is 04:00 in UTC
+	active, _ = s.Active(time.Date(2024, 1, 1, 4, 0, 0, 0, time.UTC))
+	assert.True(t, active)
+
+	// the next check of Saturday is at most one day later
+	saturday := time.Date(2024, 1, 6, 12, 0, 0, 0, loc)
+	active, next = s.Active(saturday)
+	assert.False(t, active)
+	assert.True(t, next.Equal(saturday.Add(maxNextCheck)))
+}
+
+func TestNewInvalidSchedule(t *testing.T) {
+	window := egressv1.ScheduleWindow{Start: "0 9 * * *", Duration: metav1.Duration{Duration: time.Hour}}
+
+	_, err := New(&egressv1.PolicySchedule{})
+	assert.Error(t, err)
+	_, err = New(&egressv1.PolicySchedule{Windows: []egressv1.ScheduleWindow{window}, TimeZone: "Mars/Base"})
+	assert.Error(t, err)
+
+	window.Duration.Duration = MaxWindowDuration + time.Hour
+	_, err = New(&egressv1.PolicySchedule{Windows: []egressv1.ScheduleWindow{window}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,183 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+	egwtypes "github.com/spidernet-io/egressgateway/pkg/types"
+)
+
+// Agent simulates the agents of the nodes out-of-cluster, e.g. in the local development
+// or the integration tests against envtest. It heartbeats the EgressTunnels of the nodes
+// like the agent, and programs the Datapath instead of the netlink and iptables of the node.
+type Agent struct {
+	Client   client.Client
+	Log      logr.Logger
+	Config   *config.Config
+	Nodes    []string
+	Datapath Datapath
+}
+
+// New returns the manager running the simulated agents of the nodes with the fake datapath
+func New(cfg *config.Config, nodes []string) (egwtypes.Service, error) {
+	log := logger.NewLogger(cfg.EnvConfig.Logger)
+	mgrOpts := manager.Options{
+		Scheme:                 schema.GetScheme(),
+		Logger:                 log,
+		HealthProbeBindAddress: "0",
+	}
+	mgrOpts.Metrics.BindAddress = "0"
+	mgr, err := ctrl.NewManager(cfg.KubeConfig, mgrOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create manager: %w", err)
+	}
+	err = mgr.Add(&Agent{
+		Client:   mgr.GetClient(),
+		Log:      log.WithName("simulator"),
+		Config:   cfg,
+		Nodes:    nodes,
+		Datapath: NewFakeDatapath(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mgr, nil
+}
+
+func (a *Agent) Start(ctx context.Context) error {
+	a.Log.Info("start simulated agents", "nodes", a.Nodes)
+	ticker := time.NewTicker(time.Second * time.Duration(a.Config.FileConfig.GatewayFailover.TunnelUpdatePeriod))
+	defer ticker.Stop()
+	for {
+		a.Sync(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync syncs the EgressTunnel and the datapath of every node once
+func (a *Agent) Sync(ctx context.Context) {
+	for _, node := range a.Nodes {
+		if err := a.syncNode(ctx, node); err != nil {
+			a.Log.Error(err, "failed to sync the simulated node", "node", node)
+		}
+	}
+}
+
+func (a *Agent) syncNode(ctx context.Context, name string) error {
+	tunnel := new(egressv1.EgressTunnel)
+	err := a.Client.Get(ctx, types.NamespacedName{Name: name}, tunnel)
+	if err != nil {
+		if apierr.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	node := new(corev1.Node)
+	if err := a.Client.Get(ctx, types.NamespacedName{Name: name}, node); err != nil {
+		return err
+	}
+	if err := a.updateTunnelStatus(ctx, node, tunnel); err != nil {
+		return err
+	}
+
+	tunnels := new(egressv1.EgressTunnelList)
+	if err := a.Client.List(ctx, tunnels); err != nil {
+		return err
+	}
+	peers := make([]Peer, 0)
+	for _, item := range tunnels.Items {
+		if item.Name == name || item.Status.Tunnel.MAC == "" {
+			continue
+		}
+		peers = append(peers, Peer{
+			Node: item.Name,
+			IPv4: item.Status.Tunnel.IPv4,
+			IPv6: item.Status.Tunnel.IPv6,
+			MAC:  item.Status.Tunnel.MAC,
+			Mark: item.Status.Mark,
+		})
+	}
+	if err := a.Datapath.SyncPeers(name, peers); err != nil {
+		return err
+	}
+
+	gateways := new(egressv1.EgressGatewayList)
+	if err := a.Client.List(ctx, gateways); err != nil {
+		return err
+	}
+	eips := make([]EIP, 0)
+	for _, gateway := range gateways.Items {
+		for _, item := range gateway.Status.NodeList {
+			if item.Name != name {
+				continue
+			}
+			for _, eip := range item.Eips {
+				for _, policy := range eip.Policies {
+					key := policy.Name
+					if policy.Namespace != "" {
+						key = policy.Namespace + "/" + policy.Name
+					}
+					eips = append(eips, EIP{Policy: key, IPv4: eip.IPv4, IPv6: eip.IPv6})
+				}
+			}
+		}
+	}
+	return a.Datapath.SyncEIPs(name, eips)
+}
+
+// updateTunnelStatus updates the parent, the phase and the heartbeat of the tunnel like the agent
+func (a *Agent) updateTunnelStatus(ctx context.Context, node *corev1.Node, tunnel *egressv1.EgressTunnel) error {
+	status := &tunnel.Status
+	status.Tunnel.Parent = egressv1.Parent{}
+	ready := status.Tunnel.MAC != ""
+	if a.Config.FileConfig.EnableIPv4 {
+		parent, ip, err := a.Datapath.Parent(node, 4)
+		if err != nil {
+			return err
+		}
+		status.Tunnel.Parent.Name = parent
+		status.Tunnel.Parent.IPv4 = ip.String()
+		ready = ready && status.Tunnel.IPv4 != ""
+	}
+	if a.Config.FileConfig.EnableIPv6 {
+		parent, ip, err := a.Datapath.Parent(node, 6)
+		if err != nil {
+			return err
+		}
+		status.Tunnel.Parent.Name = parent
+		status.Tunnel.Parent.IPv6 = ip.String()
+		ready = ready && status.Tunnel.IPv6 != ""
+	}
+
+	// the phases set by the controller are kept
+	if ready &&
+		status.Phase != egressv1.EgressTunnelNodeNotReady &&
+		status.Phase != egressv1.EgressTunnelUnreachable &&
+		status.Phase != egressv1.EgressTunnelUpstreamDown {
+		status.Phase = egressv1.EgressTunnelReady
+	}
+	status.LastHeartbeatTime = metav1.Now()
+	status.SetReadyCondition(tunnel.Generation)
+	return a.Client.Status().Update(ctx, tunnel)
+}
@@ -0,0 +1,92 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package simulator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestAgentSync(t *testing.T) {
+	ctx := context.Background()
+	newNode := func(name, ip string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeHostName, Address: name},
+				{Type: corev1.NodeInternalIP, Address: ip},
+			}},
+		}
+	}
+	newTunnel := func(name, ip, mac, mark string) *egressv1.EgressTunnel {
+		return &egressv1.EgressTunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: egressv1.EgressTunnelStatus{
+				Tunnel: egressv1.Tunnel{IPv4: ip, MAC: mac},
+				Mark:   mark,
+				Phase:  egressv1.EgressTunnelPending,
+			},
+		}
+	}
+	gateway := &egressv1.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "gateway"},
+		Status: egressv1.EgressGatewayStatus{NodeList: []egressv1.EgressIPStatus{
+			{Name: "node1", Eips: []egressv1.Eips{{IPv4: "10.6.1.100", Policies: []egressv1.Policy{
+				{Name: "policy1", Namespace: "default"},
+				{Name: "cluster-policy"},
+			}}}},
+		}},
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(
+			newNode("node1", "10.6.0.1"), newNode("node2", "10.6.0.2"),
+			newTunnel("node1", "172.31.0.1", "66:5b:7b:9a:0f:01", "0x26000001"),
+			newTunnel("node2", "", "", ""),
+			gateway,
+		).
+		WithStatusSubresource(&egressv1.EgressTunnel{}, &egressv1.EgressGateway{}).
+		Build()
+
+	cfg := &config.Config{FileConfig: config.FileConfig{EnableIPv4: true}}
+	datapath := NewFakeDatapath()
+	a := &Agent{
+		Client:   cli,
+		Log:      logr.Discard(),
+		Config:   cfg,
+		Nodes:    []string{"node1", "node2", "node3"},
+		Datapath: datapath,
+	}
+	a.Sync(ctx)
+
+	tunnel := new(egressv1.EgressTunnel)
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Name: "node1"}, tunnel))
+	assert.Equal(t, egressv1.EgressTunnelReady, tunnel.Status.Phase)
+	assert.Equal(t, egressv1.Parent{Name: "sim0", IPv4: "10.6.0.1"}, tunnel.Status.Tunnel.Parent)
+	assert.False(t, tunnel.Status.LastHeartbeatTime.IsZero())
+
+	// the tunnel of node2 is not allocated by the controller yet
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Name: "node2"}, tunnel))
+	assert.Equal(t, egressv1.EgressTunnelPending, tunnel.Status.Phase)
+	assert.False(t, tunnel.Status.LastHeartbeatTime.IsZero())
+
+	assert.Equal(t, []Peer(nil), datapath.Peers("node1"))
+	assert.Equal(t, []Peer{{Node: "node1", IPv4: "172.31.0.1", MAC: "66:5b:7b:9a:0f:01", Mark: "0x26000001"}}, datapath.Peers("node2"))
+	assert.Equal(t, []EIP{
+		{Policy: "cluster-policy", IPv4: "10.6.1.100"},
+		{Policy: "default/policy1", IPv4: "10.6.1.100"},
+	}, datapath.EIPs("node1"))
+	assert.Equal(t, []EIP(nil), datapath.EIPs("node2"))
+}
@@ -0,0 +1,104 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package simulator
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Peer is the tunnel to another node
+type Peer struct {
+	Node string
+	IPv4 string
+	IPv6 string
+	MAC  string
+	Mark string
+}
+
+// EIP is the EIP of a policy served by the gateway node
+type EIP struct {
+	// Policy is the namespace/name of the policy, or the name of the cluster policy
+	Policy string
+	IPv4   string
+	IPv6   string
+}
+
+// Datapath is the datapath programmed by the agent of a node. The agent on Linux programs
+// it with netlink and iptables, the simulated agent only needs the calls below, so it runs
+// on any OS.
+type Datapath interface {
+	// Parent returns the name and the IP of the parent interface of the tunnel of the node
+	Parent(node *corev1.Node, version int) (string, net.IP, error)
+	// SyncPeers programs the tunnels to the peers of the node
+	SyncPeers(node string, peers []Peer) error
+	// SyncEIPs programs the EIPs of the policies served by the gateway node
+	SyncEIPs(node string, eips []EIP) error
+}
+
+// FakeDatapath records the datapath of the nodes in memory, the tests check the tunnels
+// and the EIPs programmed by the simulated agent in it
+type FakeDatapath struct {
+	mutex sync.RWMutex
+	peers map[string][]Peer
+	eips  map[string][]EIP
+}
+
+func NewFakeDatapath() *FakeDatapath {
+	return &FakeDatapath{
+		peers: make(map[string][]Peer),
+		eips:  make(map[string][]EIP),
+	}
+}
+
+// Parent returns the fake interface sim0 with the InternalIP of the node
+func (f *FakeDatapath) Parent(node *corev1.Node, version int) (string, net.IP, error) {
+	for _, addr := range node.Status.Addresses {
+		if addr.Type != corev1.NodeInternalIP {
+			continue
+		}
+		ip := net.ParseIP(addr.Address)
+		if ip == nil {
+			continue
+		}
+		if (ip.To4() != nil) == (version == 4) {
+			return "sim0", ip, nil
+		}
+	}
+	return "", nil, fmt.Errorf("node %s has no IPv%d InternalIP", node.Name, version)
+}
+
+func (f *FakeDatapath) SyncPeers(node string, peers []Peer) error {
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Node < peers[j].Node })
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.peers[node] = peers
+	return nil
+}
+
+func (f *FakeDatapath) SyncEIPs(node string, eips []EIP) error {
+	sort.Slice(eips, func(i, j int) bool { return eips[i].Policy < eips[j].Policy })
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.eips[node] = eips
+	return nil
+}
+
+// Peers returns the tunnels programmed on the node
+func (f *FakeDatapath) Peers(node string) []Peer {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return append([]Peer(nil), f.peers[node]...)
+}
+
+// EIPs returns the EIPs programmed on the node
+func (f *FakeDatapath) EIPs(node string) []EIP {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return append([]EIP(nil), f.eips[node]...)
+}
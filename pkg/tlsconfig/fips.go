@@ -0,0 +1,12 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build boringcrypto
+
+package tlsconfig
+
+// restrict all the TLS settings of the process to the FIPS approved ones
+import _ "crypto/tls/fipsonly"
+
+// FIPSOnly is whether the binary is built with BoringCrypto, the FIPS validated module
+const FIPSOnly = true
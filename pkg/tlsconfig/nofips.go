@@ -0,0 +1,9 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !boringcrypto
+
+package tlsconfig
+
+// FIPSOnly is whether the binary is built with BoringCrypto, the FIPS validated module
+const FIPSOnly = false
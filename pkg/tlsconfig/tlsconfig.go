@@ -0,0 +1,93 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+)
+
+// fipsCipherSuites is the FIPS approved cipher suites of TLS 1.2
+var fipsCipherSuites = map[uint16]struct{}{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256: {},
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384: {},
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256:   {},
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384:   {},
+}
+
+// Server is the TLS settings shared by the webhook server and the metrics server.
+// It's a runnable of the manager, which watches the certificate files in the certDir
+// and serves the new certificate once they are changed.
+type Server struct {
+	cfg     config.TLS
+	log     logr.Logger
+	watcher *certwatcher.CertWatcher
+}
+
+// New returns the TLS settings of the servers. The certificate is left to the servers if
+// there is no tls.crt or tls.key in the certDir.
+func New(cfg config.TLS, certDir string, log logr.Logger) (*Server, error) {
+	if FIPSOnly {
+		for _, id := range cfg.CipherSuiteIDs {
+			if _, ok := fipsCipherSuites[id]; !ok {
+				return nil, fmt.Errorf("tls cipher suite %s is not FIPS approved", tls.CipherSuiteName(id))
+			}
+		}
+		log.Info("FIPS mode is enabled, only the FIPS approved TLS settings are used")
+	}
+
+	s := &Server{cfg: cfg, log: log}
+	certPath := filepath.Join(certDir, "tls.crt")
+	keyPath := filepath.Join(certDir, "tls.key")
+	if !exists(certPath) || !exists(keyPath) {
+		return s, nil
+	}
+	watcher, err := certwatcher.New(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate of %s: %w", certDir, err)
+	}
+	watcher.RegisterCallback(func(cert tls.Certificate) {
+		log.Info("load tls certificate", "certificate", certPath)
+	})
+	s.watcher = watcher
+	return s, nil
+}
+
+// Options returns the options of the tls.Config of the servers
+func (s *Server) Options() []func(*tls.Config) {
+	return []func(*tls.Config){
+		func(c *tls.Config) {
+			c.MinVersion = s.cfg.MinVersionID
+			if len(s.cfg.CipherSuiteIDs) > 0 {
+				c.CipherSuites = s.cfg.CipherSuiteIDs
+			}
+			if s.watcher != nil {
+				c.GetCertificate = s.watcher.GetCertificate
+			}
+		},
+	}
+}
+
+// Start watches the certificate files until the ctx is done
+func (s *Server) Start(ctx context.Context) error {
+	if s.watcher == nil {
+		return nil
+	}
+	return s.watcher.Start(ctx)
+}
+
+func (s *Server) NeedLeaderElection() bool { return false }
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
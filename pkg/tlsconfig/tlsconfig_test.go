@@ -0,0 +1,52 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	certutil "k8s.io/client-go/util/cert"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+)
+
+func TestServer(t *testing.T) {
+	cfg := config.TLS{
+		MinVersionID:   tls.VersionTLS13,
+		CipherSuiteIDs: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	}
+
+	// the certificate is left to the servers without the certificate files
+	s, err := New(cfg, t.TempDir(), logr.Discard())
+	assert.NoError(t, err)
+	c := &tls.Config{}
+	for _, o := range s.Options() {
+		o(c)
+	}
+	assert.Equal(t, uint16(tls.VersionTLS13), c.MinVersion)
+	assert.Equal(t, cfg.CipherSuiteIDs, c.CipherSuites)
+	assert.Nil(t, c.GetCertificate)
+
+	dir := t.TempDir()
+	cert, key, err := certutil.GenerateSelfSignedCertKey("localhost", []net.IP{net.IPv4(127, 0, 0, 1)}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "tls.crt"), cert, 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "tls.key"), key, 0600))
+
+	s, err = New(cfg, dir, logr.Discard())
+	assert.NoError(t, err)
+	c = &tls.Config{}
+	for _, o := range s.Options() {
+		o(c)
+	}
+	current, err := c.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, current)
+}
@@ -0,0 +1,258 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package apply writes the objects by the server-side apply, so the writers only send
+// the fields they own and never conflict on the resource version.
+package apply
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// FieldOwnerEndpoint owns the EgressEndpointSlices and EgressClusterEndpointSlices
+	FieldOwnerEndpoint = "egressgateway-endpoint"
+	// FieldOwnerPolicy owns the EIPs, nodes and Ready conditions in the status of the policies
+	FieldOwnerPolicy = "egressgateway-policy"
+)
+
+// LegacyManagers is the field managers of the objects written by get-modify-update before,
+// the controller-runtime client takes the name of the binary as the field manager
+var LegacyManagers = []string{"controller", "endpoint-controller"}
+
+// Fields is a field set in the format of FieldsV1, such as `{"f:status": {"f:node": {}}}`,
+// an empty set of a field covers all of its children
+type Fields = map[string]interface{}
+
+// Object applies the object as the owner, the fields it omits are removed if no one
+// else owns them
+func Object(ctx context.Context, cli client.Client, obj client.Object, owner string) error {
+	return cli.Patch(ctx, obj, client.Apply, client.FieldOwner(owner), client.ForceOwnership)
+}
+
+// Status applies the status of the object as the owner
+func Status(ctx context.Context, cli client.Client, obj client.Object, owner string) error {
+	return cli.Status().Patch(ctx, obj, client.Apply, client.FieldOwner(owner), client.ForceOwnership)
+}
+
+// StatusObject returns the object to apply the status, a pointer to the status struct, to
+// the object of the kind. It only contains the name and the status, so no other field is
+// claimed by the owner.
+func StatusObject(obj client.Object, gvk schema.GroupVersionKind, status interface{}) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(status)
+	if err != nil {
+		return nil, err
+	}
+	res := &unstructured.Unstructured{Object: map[string]interface{}{"status": content}}
+	res.SetGroupVersionKind(gvk)
+	res.SetNamespace(obj.GetNamespace())
+	res.SetName(obj.GetName())
+	return res, nil
+}
+
+// Migrator moves the ownership of the fields from the legacy field managers to the owner
+// once for each object, otherwise the fields the owner omits are kept by the legacy ones.
+// It reads the managed fields from the API server, as the cache drops them.
+type Migrator struct {
+	reader      client.Reader
+	writer      client.Client
+	owner       string
+	subresource string
+	fields      Fields
+	done        sync.Map
+}
+
+// NewMigrator returns the migrator of the fields written to the subresource, which is
+// empty for the main resource
+func NewMigrator(reader client.Reader, writer client.Client, owner, subresource string, fields Fields) *Migrator {
+	return &Migrator{
+		reader:      reader,
+		writer:      writer,
+		owner:       owner,
+		subresource: subresource,
+		fields:      fields,
+	}
+}
+
+// Migrate moves the fields of the object from the legacy field managers to the owner
+func (m *Migrator) Migrate(ctx context.Context, obj client.Object) error {
+	if obj.GetUID() != "" {
+		if _, ok := m.done.Load(obj.GetUID()); ok {
+			return nil
+		}
+	}
+	cur, ok := obj.DeepCopyObject().(client.Object)
+	if !ok {
+		return nil
+	}
+	err := m.reader.Get(ctx, types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}, cur)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	entries, changed, err := migrateEntries(cur.GetManagedFields(), m.owner, m.subresource, m.fields, metav1.Now())
+	if err != nil {
+		return err
+	}
+	if changed {
+		patch := client.MergeFrom(cur.DeepCopyObject().(client.Object))
+		cur.SetManagedFields(entries)
+		if err := m.writer.Patch(ctx, cur, patch); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	m.done.Store(cur.GetUID(), struct{}{})
+	return nil
+}
+
+// Forget drops the record of the migrated object
+func (m *Migrator) Forget(uid types.UID) {
+	m.done.Delete(uid)
+}
+
+// migrateEntries moves the fields in the Update entries of the legacy managers and the
+// owner to the Apply entry of the owner
+func migrateEntries(entries []metav1.ManagedFieldsEntry, owner, subresource string,
+	fields Fields, now metav1.Time) ([]metav1.ManagedFieldsEntry, bool, error) {
+	legacy := map[string]bool{owner: true}
+	for _, name := range LegacyManagers {
+		legacy[name] = true
+	}
+
+	res := make([]metav1.ManagedFieldsEntry, 0, len(entries)+1)
+	applied := -1
+	moved := make(Fields)
+	changed := false
+	apiVersion := ""
+	for _, entry := range entries {
+		if entry.Subresource != subresource || entry.FieldsV1 == nil {
+			res = append(res, entry)
+			continue
+		}
+		if entry.Manager == owner && entry.Operation == metav1.ManagedFieldsOperationApply {
+			applied = len(res)
+			res = append(res, entry)
+			continue
+		}
+		if !legacy[entry.Manager] || entry.Operation != metav1.ManagedFieldsOperationUpdate {
+			res = append(res, entry)
+			continue
+		}
+		set := make(Fields)
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &set); err != nil {
+			return nil, false, err
+		}
+		owned := intersect(set, fields)
+		if len(owned) == 0 {
+			res = append(res, entry)
+			continue
+		}
+		changed = true
+		apiVersion = entry.APIVersion
+		union(moved, owned)
+		if rest := subtract(set, fields); len(rest) != 0 {
+			raw, err := json.Marshal(rest)
+			if err != nil {
+				return nil, false, err
+			}
+			entry.FieldsV1 = &metav1.FieldsV1{Raw: raw}
+			res = append(res, entry)
+		}
+	}
+	if !changed {
+		return entries, false, nil
+	}
+
+	if applied >= 0 {
+		set := make(Fields)
+		if err := json.Unmarshal(res[applied].FieldsV1.Raw, &set); err != nil {
+			return nil, false, err
+		}
+		union(moved, set)
+	}
+	raw, err := json.Marshal(moved)
+	if err != nil {
+		return nil, false, err
+	}
+	entry := metav1.ManagedFieldsEntry{
+		Manager:     owner,
+		Operation:   metav1.ManagedFieldsOperationApply,
+		APIVersion:  apiVersion,
+		Time:        &now,
+		FieldsType:  "FieldsV1",
+		FieldsV1:    &metav1.FieldsV1{Raw: raw},
+		Subresource: subresource,
+	}
+	if applied >= 0 {
+		entry.APIVersion = res[applied].APIVersion
+		res[applied] = entry
+	} else {
+		res = append(res, entry)
+	}
+	return res, true, nil
+}
+
+// intersect returns the fields of the set covered by the mask
+func intersect(set, mask Fields) Fields {
+	res := make(Fields)
+	for key, value := range set {
+		sub, ok := mask[key]
+		if !ok {
+			continue
+		}
+		subMask, _ := sub.(Fields)
+		if len(subMask) == 0 {
+			res[key] = value
+			continue
+		}
+		subSet, _ := value.(Fields)
+		if owned := intersect(subSet, subMask); len(owned) != 0 {
+			res[key] = owned
+		}
+	}
+	return res
+}
+
+// subtract returns the fields of the set not covered by the mask
+func subtract(set, mask Fields) Fields {
+	res := make(Fields)
+	for key, value := range set {
+		sub, ok := mask[key]
+		if !ok {
+			res[key] = value
+			continue
+		}
+		subMask, _ := sub.(Fields)
+		if len(subMask) == 0 {
+			continue
+		}
+		subSet, _ := value.(Fields)
+		if rest := subtract(subSet, subMask); len(rest) != 0 {
+			res[key] = rest
+		}
+	}
+	return res
+}
+
+// union adds the fields of the set to the dst
+func union(dst, set Fields) {
+	for key, value := range set {
+		subSet, _ := value.(Fields)
+		subDst, ok := dst[key].(Fields)
+		if !ok {
+			subDst = make(Fields)
+			dst[key] = subDst
+		}
+		union(subDst, subSet)
+	}
+}
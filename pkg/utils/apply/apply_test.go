@@ -0,0 +1,144 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package apply
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+var testFields = Fields{
+	"f:metadata":  Fields{"f:labels": Fields{}},
+	"f:endpoints": Fields{},
+}
+
+func fieldsV1(t *testing.T, set Fields) *metav1.FieldsV1 {
+	raw, err := json.Marshal(set)
+	assert.NoError(t, err)
+	return &metav1.FieldsV1{Raw: raw}
+}
+
+func TestIntersectSubtract(t *testing.T) {
+	set := Fields{
+		"f:metadata": Fields{
+			"f:labels":     Fields{"f:app": Fields{}},
+			"f:finalizers": Fields{},
+		},
+		"f:endpoints": Fields{},
+		"f:spec":      Fields{},
+	}
+	assert.Equal(t, Fields{
+		"f:metadata":  Fields{"f:labels": Fields{"f:app": Fields{}}},
+		"f:endpoints": Fields{},
+	}, intersect(set, testFields))
+	assert.Equal(t, Fields{
+		"f:metadata": Fields{"f:finalizers": Fields{}},
+		"f:spec":     Fields{},
+	}, subtract(set, testFields))
+}
+
+func TestMigrateEntries(t *testing.T) {
+	now := metav1.Now()
+	cases := []struct {
+		name    string
+		entries []metav1.ManagedFieldsEntry
+		changed bool
+		expect  []metav1.ManagedFieldsEntry
+	}{
+		{
+			name: "other managers",
+			entries: []metav1.ManagedFieldsEntry{
+				{Manager: "kubectl", Operation: metav1.ManagedFieldsOperationUpdate,
+					FieldsV1: fieldsV1(t, Fields{"f:endpoints": Fields{}})},
+			},
+		},
+		{
+			name: "legacy manager",
+			entries: []metav1.ManagedFieldsEntry{
+				{Manager: "controller", Operation: metav1.ManagedFieldsOperationUpdate, APIVersion: "v1beta1",
+					FieldsV1: fieldsV1(t, Fields{"f:endpoints": Fields{}, "f:spec": Fields{}})},
+			},
+			changed: true,
+			expect: []metav1.ManagedFieldsEntry{
+				{Manager: "controller", Operation: metav1.ManagedFieldsOperationUpdate, APIVersion: "v1beta1",
+					FieldsV1: fieldsV1(t, Fields{"f:spec": Fields{}})},
+				{Manager: "owner", Operation: metav1.ManagedFieldsOperationApply, APIVersion: "v1beta1",
+					Time: &now, FieldsType: "FieldsV1", FieldsV1: fieldsV1(t, Fields{"f:endpoints": Fields{}})},
+			},
+		},
+		{
+			name: "merged into the apply entry",
+			entries: []metav1.ManagedFieldsEntry{
+				{Manager: "owner", Operation: metav1.ManagedFieldsOperationApply, APIVersion: "v1beta1",
+					FieldsV1: fieldsV1(t, Fields{"f:metadata": Fields{"f:labels": Fields{}}})},
+				{Manager: "owner", Operation: metav1.ManagedFieldsOperationUpdate, APIVersion: "v1beta1",
+					FieldsV1: fieldsV1(t, Fields{"f:endpoints": Fields{}})},
+			},
+			changed: true,
+			expect: []metav1.ManagedFieldsEntry{
+				{Manager: "owner", Operation: metav1.ManagedFieldsOperationApply, APIVersion: "v1beta1",
+					Time: &now, FieldsType: "FieldsV1", FieldsV1: fieldsV1(t, Fields{
+						"f:metadata":  Fields{"f:labels": Fields{}},
+						"f:endpoints": Fields{},
+					})},
+			},
+		},
+		{
+			name: "other subresource",
+			entries: []metav1.ManagedFieldsEntry{
+				{Manager: "controller", Operation: metav1.ManagedFieldsOperationUpdate, Subresource: "status",
+					FieldsV1: fieldsV1(t, Fields{"f:endpoints": Fields{}})},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, changed, err := migrateEntries(c.entries, "owner", "", testFields, now)
+			assert.NoError(t, err)
+			assert.Equal(t, c.changed, changed)
+			if !c.changed {
+				assert.Equal(t, c.entries, res)
+				return
+			}
+			assert.Equal(t, c.expect, res)
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	slice := &v1beta1.EgressEndpointSlice{ObjectMeta: metav1.ObjectMeta{
+		Name: "s1", Namespace: "default", UID: "uid1",
+		ManagedFields: []metav1.ManagedFieldsEntry{
+			{Manager: "controller", Operation: metav1.ManagedFieldsOperationUpdate,
+				FieldsV1: fieldsV1(t, Fields{"f:endpoints": Fields{}})},
+		},
+	}}
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(slice).Build()
+	m := NewMigrator(cli, cli, "owner", "", testFields)
+
+	assert.NoError(t, m.Migrate(ctx, slice))
+	res := new(v1beta1.EgressEndpointSlice)
+	assert.NoError(t, cli.Get(ctx, client.ObjectKeyFromObject(slice), res))
+	assert.Len(t, res.ManagedFields, 1)
+	assert.Equal(t, "owner", res.ManagedFields[0].Manager)
+	_, ok := m.done.Load(slice.UID)
+	assert.True(t, ok)
+
+	m.Forget(slice.UID)
+	_, ok = m.done.Load(slice.UID)
+	assert.False(t, ok)
+
+	// the deleted objects are ignored
+	assert.NoError(t, m.Migrate(ctx, &v1beta1.EgressEndpointSlice{ObjectMeta: metav1.ObjectMeta{Name: "s2", Namespace: "default"}}))
+}
@@ -435,11 +435,11 @@ func IsIPv4IPRange(ipRange string) bool {
 	}
 
 	if n == 1 {
-		return govalidator.IsIPv4(ips[0])
+		return isIPv4String(ips[0])
 	}
 
 	if n == 2 {
-		if !govalidator.IsIPv4(ips[0]) || !govalidator.IsIPv4(ips[1]) {
+		if !isIPv4String(ips[0]) || !isIPv4String(ips[1]) {
 			return false
 		}
 		if Cmp(net.ParseIP(ips[0]), net.ParseIP(ips[1])) == 1 {
@@ -450,6 +450,12 @@ func IsIPv4IPRange(ipRange string) bool {
 	return true
 }
 
+// isIPv4String reports whether the string is an IPv4 address in the dotted decimal form,
+// the IPv4 addresses in the IPv6 form such as ::0.0.0.0 are not IPv4 ranges
+func isIPv4String(ip string) bool {
+	return govalidator.IsIPv4(ip) && !strings.Contains(ip, ":")
+}
+
 // IsIPv6IPRange reports whether ipRange string is a valid IPv6 range.
 // See IsIPRange for more description of IP range.
 func IsIPv6IPRange(ipRange string) bool {
@@ -647,3 +653,8 @@ func IsIPIncludedCidr(ip, cidr string) (bool, error) {
 	}
 	return IPnet.Contains(IPip), nil
 }
+
+// IsCIDROverlap reports whether the two CIDRs have common IP addresses
+func IsCIDROverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
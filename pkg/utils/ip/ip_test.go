@@ -6,6 +6,7 @@ package ip_test
 import (
 	"net"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/spidernet-io/egressgateway/pkg/constant"
@@ -1070,3 +1071,66 @@ func ipsEqual(got, want []net.IP) bool {
 	// If the map is empty, all IPs were matched
 	return len(gotMap) == 0
 }
+
+func TestIsCIDROverlap(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"10.6.0.0/16", "10.6.1.0/24", true},
+		{"10.6.1.0/24", "10.6.0.0/16", true},
+		{"10.6.0.0/24", "10.7.0.0/24", false},
+		{"fd00::/64", "fd00::/120", true},
+		{"fd00::/120", "fd01::/120", false},
+	}
+	for _, tt := range tests {
+		_, a, _ := net.ParseCIDR(tt.a)
+		_, b, _ := net.ParseCIDR(tt.b)
+		if got := ip.IsCIDROverlap(a, b); got != tt.expected {
+			t.Errorf("IsCIDROverlap(%s, %s) = %v, expected %v", tt.a, tt.b, got, tt.expected)
+		}
+	}
+}
+
+func FuzzIsIPRange(f *testing.F) {
+	for _, item := range []string{
+		"172.18.40.0", "172.18.40.0-172.18.40.10", "172.18.40.10-172.18.40.1",
+		"172.18.40.1-2001:db8:a0b:12f0::1", "2001:db8::1-2001:db8::a", "::ffff:1.2.3.4", "-", "",
+	} {
+		f.Add(item)
+	}
+	f.Fuzz(func(t *testing.T, ipRange string) {
+		for _, version := range []constant.IPVersion{constant.IPv4, constant.IPv6} {
+			if err := ip.IsIPRange(version, ipRange); err != nil {
+				continue
+			}
+			ends := strings.Split(ipRange, "-")
+			start, end := net.ParseIP(ends[0]), net.ParseIP(ends[len(ends)-1])
+			if start == nil || end == nil {
+				t.Fatalf("invalid IPv%d range %q is validated", version, ipRange)
+			}
+			if version == constant.IPv4 && (start.To4() == nil || end.To4() == nil) {
+				t.Fatalf("IPv6 range %q is validated as IPv4", ipRange)
+			}
+			if ip.Cmp(start, end) > 0 {
+				t.Fatalf("unordered IPv%d range %q is validated", version, ipRange)
+			}
+		}
+	})
+}
+
+func FuzzIsCidr(f *testing.F) {
+	for _, item := range []string{"10.6.0.0/16", "fd00::/64", "::ffff:10.6.0.0/112", "10.6.0.0/33", "10.6.0.0", ""} {
+		f.Add(item)
+	}
+	f.Fuzz(func(t *testing.T, cidr string) {
+		v4, err4 := ip.IsIPv4Cidr(cidr)
+		v6, err6 := ip.IsIPv6Cidr(cidr)
+		if (err4 == nil) != (err6 == nil) {
+			t.Fatalf("cidr %q is parsed by one of the versions: %v, %v", cidr, err4, err6)
+		}
+		if v4 && v6 {
+			t.Fatalf("cidr %q is validated as both IPv4 and IPv6", cidr)
+		}
+	})
+}
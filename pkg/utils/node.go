@@ -21,3 +21,19 @@ func GetNodeIP(node *v1.Node) (nodeIPv4, nodeIPv6 string) {
 	}
 	return
 }
+
+// GetNodeIPs returns all the internal IPs of the node, a dual-homed node has more than
+// one internal IP of a family
+func GetNodeIPs(node *v1.Node) (ipv4s, ipv6s []string) {
+	for _, addresses := range node.Status.Addresses {
+		if addresses.Type != v1.NodeInternalIP {
+			continue
+		}
+		if isV4, _ := ip.IsIPv4(addresses.Address); isV4 {
+			ipv4s = append(ipv4s, addresses.Address)
+		} else if isV6, _ := ip.IsIPv6(addresses.Address); isV6 {
+			ipv6s = append(ipv6s, addresses.Address)
+		}
+	}
+	return
+}
@@ -28,3 +28,21 @@ func TestGetNodeIP(t *testing.T) {
 	node.Status.Addresses = address
 	utils.GetNodeIP(node)
 }
+
+func TestGetNodeIPs(t *testing.T) {
+	node := new(v1.Node)
+	node.Status.Addresses = []v1.NodeAddress{
+		{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+		{Type: v1.NodeInternalIP, Address: "192.168.0.1"},
+		{Type: v1.NodeInternalIP, Address: "fd00::1"},
+		{Type: v1.NodeExternalIP, Address: "1.1.1.1"},
+		{Type: v1.NodeHostName, Address: "node1"},
+	}
+	ipv4s, ipv6s := utils.GetNodeIPs(node)
+	if len(ipv4s) != 2 || ipv4s[0] != "10.0.0.1" || ipv4s[1] != "192.168.0.1" {
+		t.Fatalf("unexpected ipv4s %v", ipv4s)
+	}
+	if len(ipv6s) != 1 || ipv6s[0] != "fd00::1" {
+		t.Fatalf("unexpected ipv6s %v", ipv6s)
+	}
+}
@@ -108,8 +108,8 @@ func CheckPodEgressIP(ctx context.Context, cfg *Config, pod corev1.Pod, egressIP
 }
 
 func generateCmd(ctx context.Context, config *Config, pod corev1.Pod, eip, serverIP string, expectUsedEip bool) *exec.Cmd {
-	curlServer := fmt.Sprintf("nettools-client -addr %s -protocol %s -tcpPort %v -udpPort %v -webPort %v -eip %s -batch true",
-		serverIP, config.Mod, config.TcpPort, config.UdpPort, config.WebPort, eip)
+	curlServer := fmt.Sprintf("nettools-client -addr %s -protocol %s -tcpPort %v -udpPort %v -sctpPort %v -webPort %v -eip %s -batch true",
+		serverIP, config.Mod, config.TcpPort, config.UdpPort, config.SctpPort, config.WebPort, eip)
 	if !expectUsedEip {
 		curlServer = curlServer + " -contain false"
 	}
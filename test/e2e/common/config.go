@@ -37,11 +37,12 @@ const (
 )
 
 type Config struct {
-	Image   string `mapstructure:"IMAGE"`
-	TcpPort int    `mapstructure:"TCP_PORT"`
-	UdpPort int    `mapstructure:"UDP_PORT"`
-	WebPort int    `mapstructure:"WEB_PORT"`
-	Mod     string `mapstructure:"MOD"`
+	Image    string `mapstructure:"IMAGE"`
+	TcpPort  int    `mapstructure:"TCP_PORT"`
+	UdpPort  int    `mapstructure:"UDP_PORT"`
+	SctpPort int    `mapstructure:"SCTP_PORT"`
+	WebPort  int    `mapstructure:"WEB_PORT"`
+	Mod      string `mapstructure:"MOD"`
 
 	// for A/B testing
 	ServerAIPv4 string `mapstructure:"SERVER_A_IPV4"`
@@ -59,6 +60,7 @@ func ReadConfig() (*Config, error) {
 		Image:       "",
 		TcpPort:     63380,
 		UdpPort:     63381,
+		SctpPort:    63383,
 		WebPort:     63382,
 		Mod:         "all",
 		ServerAIPv4: "",
@@ -932,6 +932,58 @@ var _ = Describe("EgressPolicy", Serial, func() {
 		})
 	})
 
+	Context("protocols", Label("P00022"), func() {
+		var ctx context.Context
+		var podObj *corev1.Pod
+		var egp *egressv1.EgressPolicy
+		var err error
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			podName := "pod-" + uuid.NewString()
+			podLabel := map[string]string{"app": podName}
+
+			podObj, err = common.CreatePodCustom(ctx, cli, podName, config.Image, func(pod *corev1.Pod) {
+				pod.Namespace = "default"
+				pod.Labels = podLabel
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(common.WaitPodRunning(ctx, cli, podObj, time.Second*10)).NotTo(HaveOccurred())
+
+			egp, err = common.CreateEgressPolicyNew(ctx, cli, egressConfig, egw.Name, podLabel, "")
+			Expect(err).NotTo(HaveOccurred())
+			err = common.WaitEgressPolicyStatusReady(ctx, cli, egp, egressConfig.EnableIPv4, egressConfig.EnableIPv6, time.Second*3)
+			Expect(err).NotTo(HaveOccurred())
+
+			DeferCleanup(func() {
+				if podObj != nil {
+					Expect(common.DeleteObj(ctx, cli, podObj)).NotTo(HaveOccurred())
+				}
+				if egp != nil {
+					err = common.WaitEgressPoliciesDeleted(ctx, cli, []*egressv1.EgressPolicy{egp}, time.Second*5)
+					Expect(err).NotTo(HaveOccurred())
+				}
+			})
+		})
+
+		DescribeTable("the flows should be SNATed to the eip", func(protocol string) {
+			cfg := *config
+			cfg.Mod = protocol
+			if egressConfig.EnableIPv4 {
+				err = common.CheckPodEgressIP(ctx, &cfg, *podObj, egp.Status.Eip.Ipv4, config.ServerAIPv4, true)
+				Expect(err).NotTo(HaveOccurred())
+			}
+			if egressConfig.EnableIPv6 {
+				err = common.CheckPodEgressIP(ctx, &cfg, *podObj, egp.Status.Eip.Ipv6, config.ServerAIPv6, true)
+				Expect(err).NotTo(HaveOccurred())
+			}
+		},
+			Entry("tcp", "tcp"),
+			Entry("udp", "udp"),
+			Entry("sctp", "sctp"),
+		)
+	})
+
 	/*
 		This test case focuses on creating a policy with the default gateway in cluster level or namespace level
 
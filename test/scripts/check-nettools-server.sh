@@ -6,6 +6,7 @@ set -x
 
 TCP_HELLO="TCP Server Say hello"
 UDP_HELLO="UDP Server Say hello"
+SCTP_HELLO="SCTP Server Say hello"
 WEB_HELLO="WebSocket Server Say hello"
 
 checkNettoolsServer() {
@@ -18,12 +19,12 @@ checkNettoolsServer() {
   fi
   RESULT=$(mktemp)
   
-  "${CLIENT}" -addr "${SERVER_IP}" -protocol all -tcpPort "${TCP_PORT}"  -udpPort "${UDP_PORT}" -webPort "${WEB_PORT}" > "${RESULT}" 2>&1 &
+  "${CLIENT}" -addr "${SERVER_IP}" -protocol all -tcpPort "${TCP_PORT}"  -udpPort "${UDP_PORT}" -sctpPort "${SCTP_PORT}" -webPort "${WEB_PORT}" > "${RESULT}" 2>&1 &
   
   server="bad"
   
   for i in {0..10}; do
-    if grep -e "${TCP_HELLO}" -e "${UDP_HELLO}" -e "${WEB_HELLO}"  "${RESULT}"; then
+    if grep -e "${TCP_HELLO}" -e "${UDP_HELLO}" -e "${SCTP_HELLO}" -e "${WEB_HELLO}"  "${RESULT}"; then
         echo "server is ok"
         server="ok"
         break
@@ -28,5 +28,9 @@ controllerGenCmd rbac:roleName="exampleClusterRole" paths="${API_CODE_DIR}" outp
 echo "generate CRD yaml to chart"
 controllerGenCmd crd paths="${API_CODE_DIR}"  output:dir="${CHART_DIR}/crds"
 
+echo "copy CRD yaml to the embedded manifests of the controller bootstrap"
+rm -f ${PROJECT_ROOT}/pkg/bootstrap/crds/*.yaml
+cp ${CHART_DIR}/crds/*.yaml ${PROJECT_ROOT}/pkg/bootstrap/crds/
+
 echo "generate deepcode to api code"
 controllerGenCmd  object paths="${API_CODE_DIR}"